@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser"
+)
+
+// checkProblem is one reason a file failed to read, parse or compile.
+type checkProblem struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndColumn int    `json:"end_column,omitempty"`
+	Phase     string `json:"phase"`
+	Message   string `json:"message"`
+}
+
+type checkReport struct {
+	Files    int            `json:"files"`
+	Problems []checkProblem `json:"problems"`
+}
+
+// runCheckCommand implements `rgo check [--format=text|json] [paths...]`:
+// every .rb file under the given files and directories is parsed and
+// compiled without being run. It exits 1 when any file fails.
+func runCheckCommand(args []string) {
+	format := "text"
+	var paths []string
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		switch {
+		case arg == "--json":
+			format = "json"
+		case arg == "--format" && index+1 < len(args):
+			format = args[index+1]
+			index++
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case arg == "--":
+			paths = append(paths, args[index+1:]...)
+			index = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			fmt.Fprintf(os.Stderr, "rgo check: unknown option %s\n", arg)
+			os.Exit(2)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "rgo check: unknown format %q (want text or json)\n", format)
+		os.Exit(2)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, problems := collectRubyFiles(paths)
+	report := checkReport{Files: len(files), Problems: problems}
+	failed := len(problems)
+	for _, file := range files {
+		if fileProblems := checkRubyFile(file); len(fileProblems) > 0 {
+			report.Problems = append(report.Problems, fileProblems...)
+			failed++
+		}
+	}
+
+	if format == "json" {
+		if report.Problems == nil {
+			report.Problems = []checkProblem{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		for _, problem := range report.Problems {
+			fmt.Printf("%s:%d:%d: %s error: %s\n", problem.File, problem.Line, problem.Column, problem.Phase, problem.Message)
+		}
+		fmt.Fprintf(os.Stderr, "%d files checked, %d with errors\n", report.Files, failed)
+	}
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// collectRubyFiles expands paths into a sorted list of .rb files. Files named
+// explicitly are checked whatever their extension; hidden directories are
+// skipped while walking.
+func collectRubyFiles(paths []string) ([]string, []checkProblem) {
+	var files []string
+	var problems []checkProblem
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			problems = append(problems, checkProblem{File: path, Phase: "read", Message: err.Error()})
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				problems = append(problems, checkProblem{File: name, Phase: "read", Message: err.Error()})
+				return nil
+			}
+			if entry.IsDir() {
+				if name != path && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(name, ".rb") {
+				files = append(files, name)
+			}
+			return nil
+		})
+		if err != nil {
+			problems = append(problems, checkProblem{File: path, Phase: "read", Message: err.Error()})
+		}
+	}
+	sort.Strings(files)
+	return files, problems
+}
+
+// checkRubyFile parses and compiles one file. It reports every syntax error
+// the parser recovers from, or else the first compile error; nil means the
+// file is fine.
+func checkRubyFile(file string) (problems []checkProblem) {
+	problem := checkProblem{File: file}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		problem.Phase, problem.Message = "read", err.Error()
+		return []checkProblem{problem}
+	}
+	source := string(content)
+	encoding := core.SourceEncoding(source)
+	oldSpecFile, oldSpecFileAbsolute, oldSourceEncoding := core.CurrentSpecFile, core.CurrentSpecFileAbsolute, core.CurrentEvalSourceEncoding
+	core.CurrentSpecFile = file
+	core.CurrentSpecFileAbsolute, _ = filepath.Abs(file)
+	core.CurrentEvalSourceEncoding = encoding
+	defer func() {
+		core.CurrentSpecFile, core.CurrentSpecFileAbsolute, core.CurrentEvalSourceEncoding = oldSpecFile, oldSpecFileAbsolute, oldSourceEncoding
+	}()
+
+	p := parser.New(lexer.NewWithEncoding(source, encoding))
+	p.SetFile(file)
+	phase := "parse"
+	defer func() {
+		// A crash in the parser or compiler is a failure of this file, not
+		// of the whole check.
+		if recovered := recover(); recovered != nil {
+			problem.Phase, problem.Message = phase, fmt.Sprintf("internal error: %v", recovered)
+			problems = append(problems, problem)
+		}
+	}()
+	program := p.ParseProgram()
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		for _, d := range diagnostics {
+			problems = append(problems, checkProblem{File: file, Line: d.Line, Column: d.Column, EndColumn: d.EndColumn, Phase: "parse", Message: d.Message})
+		}
+		return problems
+	}
+
+	phase = "compile"
+	c := compiler.NewWithSourceEncoding(encoding)
+	if err := c.Compile(program); err != nil {
+		problem.Line = c.ErrorLine()
+		problem.Phase, problem.Message = "compile", err.Error()
+		return []checkProblem{problem}
+	}
+	return nil
+}
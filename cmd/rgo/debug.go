@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/debugger"
+)
+
+// runDebugCommand implements `rgo debug [--nonstop] [--port=N [--host=H]]
+// <file.rb> [args...]`. Without --port the script runs under a console
+// session on the terminal that stops at its first line unless --nonstop is
+// given. With --port it waits for an editor to connect over the Debug
+// Adapter Protocol and runs the script once the editor is configured.
+func runDebugCommand(opts *rubyOptions, args []string) {
+	port := 0
+	host := "127.0.0.1"
+	nonstop := false
+	index := 0
+	for ; index < len(args); index++ {
+		arg := args[index]
+		if arg == "--" {
+			index++
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		switch {
+		case arg == "--nonstop":
+			nonstop = true
+		case arg == "--port" && index+1 < len(args):
+			port = parseDebugPort(args[index+1])
+			index++
+		case strings.HasPrefix(arg, "--port="):
+			port = parseDebugPort(strings.TrimPrefix(arg, "--port="))
+		case arg == "--host" && index+1 < len(args):
+			host = args[index+1]
+			index++
+		case strings.HasPrefix(arg, "--host="):
+			host = strings.TrimPrefix(arg, "--host=")
+		default:
+			fmt.Fprintf(os.Stderr, "rgo debug: unknown option %s\n", arg)
+			os.Exit(2)
+		}
+	}
+	args = args[index:]
+	if len(args) == 0 && len(opts.eval) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: rgo debug [--nonstop] [--port=N [--host=H]] <file.rb> [args...]\n")
+		os.Exit(1)
+	}
+
+	if port == 0 {
+		d := debugger.New(debugger.NewConsole(os.Stdin, os.Stdout))
+		if !nonstop {
+			d.StopOnEntry()
+		}
+		core.StartDebugger(d)
+		runRubyProgram(opts, args)
+		return
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo debug: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "rgo debug: waiting for a debug adapter client on %s\n", listener.Addr())
+	conn, err := listener.Accept()
+	_ = listener.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo debug: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	server := debugger.NewServer(conn, conn)
+	d := debugger.New(server)
+	go server.Serve(d)
+	if !server.WaitConfigured() {
+		return
+	}
+	core.StartDebugger(d)
+	// A script ending in exit or an uncaught exception leaves through
+	// stopProfiles; the editor still has to hear that the session is over.
+	stopRuntimeProfiles := stopProfiles
+	stopProfiles = func() {
+		stopRuntimeProfiles()
+		server.Terminated()
+	}
+	runRubyProgram(opts, args)
+	server.Exited(0)
+}
+
+func parseDebugPort(value string) int {
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		fmt.Fprintf(os.Stderr, "rgo debug: invalid port %q\n", value)
+		os.Exit(2)
+	}
+	return port
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/formatter"
+)
+
+// runFmtCommand implements `rgo fmt [--check] [-w] [paths...]`. Formatted
+// source goes to stdout unless -w rewrites the files in place; --check only
+// lists the files that are not formatted and exits 1 if there are any. With
+// no paths it formats standard input.
+func runFmtCommand(args []string) {
+	check, write := false, false
+	var paths []string
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		switch {
+		case arg == "--check":
+			check = true
+		case arg == "-w" || arg == "--write":
+			write = true
+		case arg == "--":
+			paths = append(paths, args[index+1:]...)
+			index = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			fmt.Fprintf(os.Stderr, "rgo fmt: unknown option %s\n", arg)
+			os.Exit(2)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if check && write {
+		fmt.Fprintf(os.Stderr, "rgo fmt: --check and -w cannot be combined\n")
+		os.Exit(2)
+	}
+
+	failed := false
+	if len(paths) == 0 || len(paths) == 1 && paths[0] == "-" {
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rgo fmt: %v\n", err)
+			os.Exit(1)
+		}
+		formatted, err := formatter.Format(string(source))
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "rgo fmt: <stdin>:%v\n", err)
+			failed = true
+		case check:
+			if formatted != string(source) {
+				fmt.Println("<stdin>")
+				failed = true
+			}
+		default:
+			fmt.Print(formatted)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	files, problems := collectRubyFiles(paths)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "rgo fmt: %s: %s\n", problem.File, problem.Message)
+		failed = true
+	}
+	for _, file := range files {
+		if !formatRubyFile(file, check, write) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// formatRubyFile formats one file as runFmtCommand describes and reports
+// whether it was already formatted (for --check) or formatted cleanly.
+func formatRubyFile(file string, check, write bool) bool {
+	info, err := os.Stat(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo fmt: %v\n", err)
+		return false
+	}
+	source, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo fmt: %v\n", err)
+		return false
+	}
+	formatted, err := formatter.Format(string(source))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo fmt: %s:%v\n", file, err)
+		return false
+	}
+	switch {
+	case check:
+		if formatted != string(source) {
+			fmt.Println(file)
+			return false
+		}
+	case write:
+		if formatted != string(source) {
+			if err := os.WriteFile(file, []byte(formatted), info.Mode().Perm()); err != nil {
+				fmt.Fprintf(os.Stderr, "rgo fmt: %v\n", err)
+				return false
+			}
+		}
+	default:
+		fmt.Print(formatted)
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/vm"
+)
+
+// runLinkCommand implements `rgo link <file.rb> [-o output.rgob]`: the
+// script and the files it loads with require_relative are compiled into one
+// bundle that `rgo run` executes. The output defaults to the script's name
+// with the .rgob extension.
+func runLinkCommand(args []string) {
+	entry, output := "", ""
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		switch {
+		case arg == "-o" && index+1 < len(args):
+			output = args[index+1]
+			index++
+		case strings.HasPrefix(arg, "-o") && len(arg) > 2:
+			output = arg[2:]
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(os.Stderr, "rgo link: unknown option %s\n", arg)
+			os.Exit(2)
+		case entry == "":
+			entry = arg
+		default:
+			fmt.Fprintf(os.Stderr, "rgo link: more than one script given\n")
+			os.Exit(2)
+		}
+	}
+	if entry == "" {
+		fmt.Fprintf(os.Stderr, "Usage: rgo link <file.rb> [-o output%s]\n", compiler.BundleExtension)
+		os.Exit(2)
+	}
+	if output == "" {
+		output = strings.TrimSuffix(entry, filepath.Ext(entry)) + compiler.BundleExtension
+	}
+
+	bundle, warnings, err := vm.Link(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo link: %v\n", err)
+		os.Exit(1)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "rgo link: warning: %s\n", warning)
+	}
+	data, err := compiler.MarshalBundle(bundle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo link: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "rgo link: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runBundleFile runs a bundle written by `rgo link` as the script filename.
+func runBundleFile(filename string, argv []string) {
+	_ = os.Setenv("RGO_REAL_SLEEP", "1")
+	stopSignals := forwardSignalsToRuby()
+	defer stopSignals()
+
+	bundle, err := vm.LoadBundle(filename)
+	if errors.Is(err, compiler.ErrStaleBytecode) {
+		fmt.Fprintf(os.Stderr, "rgo: %s was linked by another RGo build; link it again\n", filename)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo: %v\n", err)
+		os.Exit(1)
+	}
+	entry := bundle.Files[0]
+
+	oldSpecFile := core.CurrentSpecFile
+	oldSpecFileAbsolute := core.CurrentSpecFileAbsolute
+	oldSourceEncoding := core.CurrentEvalSourceEncoding
+	oldTopLevelMain := core.CurrentTopLevelMain
+	core.CurrentSpecFile = filename
+	core.CurrentSpecFileAbsolute, _ = filepath.Abs(filename)
+	core.CurrentEvalSourceEncoding = entry.Encoding
+	core.CurrentTopLevelMain = true
+	defer func() {
+		core.CurrentSpecFile = oldSpecFile
+		core.CurrentSpecFileAbsolute = oldSpecFileAbsolute
+		core.CurrentEvalSourceEncoding = oldSourceEncoding
+		core.CurrentTopLevelMain = oldTopLevelMain
+	}()
+
+	v := vm.New(entry.Bytecode)
+	v.SetBundle(bundle)
+	v.SetFreezeStringLiterals(frozenStringLiterals || entry.FreezeStringLiterals)
+	v.SetChillStringLiterals(entry.ChillStringLiterals)
+	v.SetProgramName(filename)
+	setARGV(v, argv)
+	err = v.Run()
+	exitIfSandboxLimitExceeded()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Runtime Error: %v\n", err)
+		os.Exit(1)
+	}
+	exitIfSystemExit()
+	exitIfUnhandledRuntimeException(v.UnhandledException())
+}
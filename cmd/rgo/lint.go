@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/linter"
+)
+
+// runLintCommand implements `rgo lint [--config=FILE] [--format=text|json|sarif]
+// [--list-rules] [paths...]`. Without --config it uses the first of
+// linter.ConfigFiles found in the current directory. It exits 1 when any
+// offense is reported.
+func runLintCommand(args []string) {
+	format := "text"
+	configFile := ""
+	listRules := false
+	var paths []string
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		switch {
+		case arg == "--format" && index+1 < len(args):
+			format = args[index+1]
+			index++
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case arg == "--config" && index+1 < len(args):
+			configFile = args[index+1]
+			index++
+		case strings.HasPrefix(arg, "--config="):
+			configFile = strings.TrimPrefix(arg, "--config=")
+		case arg == "--list-rules":
+			listRules = true
+		case arg == "--":
+			paths = append(paths, args[index+1:]...)
+			index = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			fmt.Fprintf(os.Stderr, "rgo lint: unknown option %s\n", arg)
+			os.Exit(2)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if format != "text" && format != "json" && format != "sarif" {
+		fmt.Fprintf(os.Stderr, "rgo lint: unknown format %q (want text, json or sarif)\n", format)
+		os.Exit(2)
+	}
+	if listRules {
+		for _, rule := range linter.Rules() {
+			fmt.Printf("%-24s %-8s %s\n", rule.Name, rule.Severity, rule.Description)
+		}
+		return
+	}
+
+	if configFile == "" {
+		for _, name := range linter.ConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				configFile = name
+				break
+			}
+		}
+	}
+	config := linter.DefaultConfig()
+	if configFile != "" {
+		loaded, err := linter.LoadConfig(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rgo lint: %v\n", err)
+			os.Exit(2)
+		}
+		config = loaded
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, problems := collectRubyFiles(paths)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "rgo lint: %s: %s\n", problem.File, problem.Message)
+	}
+	var offenses []linter.Offense
+	linted := 0
+	for _, file := range files {
+		if config.Excluded(file) {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rgo lint: %v\n", err)
+			continue
+		}
+		linted++
+		offenses = append(offenses, linter.Lint(file, string(source), config)...)
+	}
+
+	switch format {
+	case "json":
+		_ = linter.WriteJSON(os.Stdout, linted, offenses)
+	case "sarif":
+		_ = linter.WriteSARIF(os.Stdout, offenses)
+	default:
+		for _, o := range offenses {
+			fmt.Printf("%s:%d:%d: %s: %s [%s]\n", o.File, o.Line, o.Column, o.Severity, o.Message, o.Rule)
+		}
+		fmt.Fprintf(os.Stderr, "%d files linted, %d offenses\n", linted, len(offenses))
+	}
+	if len(offenses) > 0 || len(problems) > 0 {
+		os.Exit(1)
+	}
+}
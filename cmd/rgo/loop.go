@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// loopOptions is set when -n or -p asks for the main program to run once
+// per input line.
+var loopOptions *rubyOptions
+
+// wrapRubyLoopProgram puts the parsed main program inside the
+// `while gets ... end` loop of -n and -p. Wrapping the tree instead of the
+// source keeps line numbers, =begin comments and __END__ intact. Top-level
+// BEGIN blocks stay outside the loop so they run once before the first
+// line. -p loops with `begin ... end while` and prints $_ in the condition,
+// so `next` still prints the line while `break` and a raise do not.
+func wrapRubyLoopProgram(program *ast.Program, o *rubyOptions) *ast.Program {
+	var begins, body []ast.Statement
+	for _, statement := range program.Statements {
+		if isBeginHook(statement) {
+			begins = append(begins, statement)
+			continue
+		}
+		body = append(body, statement)
+	}
+
+	var header strings.Builder
+	if o.print {
+		header.WriteString("if gets\nbegin\n")
+	} else {
+		header.WriteString("while gets\n")
+	}
+	if o.chomp {
+		header.WriteString("$_.chomp!\n")
+	}
+	if o.split {
+		header.WriteString("$F = $_.split($;)\n")
+	}
+	if o.print {
+		header.WriteString("end while (print $_; gets)\n")
+	}
+	header.WriteString("end\n")
+	loopStatement := parseLoopSkeleton(header.String())
+
+	if o.print {
+		guard := loopStatement.(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+		loop := guard.Consequent.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhileExpression)
+		printer := loop.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.BeginExpression)
+		printer.Body.Statements = append(printer.Body.Statements, body...)
+	} else {
+		loop := loopStatement.(*ast.ExpressionStatement).Expression.(*ast.WhileExpression)
+		loop.Body.Statements = append(loop.Body.Statements, body...)
+	}
+	return &ast.Program{Statements: append(begins, loopStatement)}
+}
+
+// parseLoopSkeleton parses the fixed loop source built by
+// wrapRubyLoopProgram, which is always valid Ruby.
+func parseLoopSkeleton(source string) ast.Statement {
+	return parser.New(lexer.New(source)).ParseProgram().Statements[0]
+}
+
+func isBeginHook(statement ast.Statement) bool {
+	expressionStatement, ok := statement.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	call, ok := expressionStatement.Expression.(*ast.MethodCall)
+	return ok && call.Receiver == nil && call.Method != nil && call.Method.Value == "BEGIN" && call.Block != nil
+}
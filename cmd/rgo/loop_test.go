@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintLoopPrintsOnNextOnly(t *testing.T) {
+	tests := []struct {
+		script string
+		stdout string
+		status int
+	}{
+		{`$_ = $_.upcase`, "A\nB\nC\n", 0},
+		{`next if $_ == "b\n"`, "a\nb\nc\n", 0},
+		{`break if $_ == "b\n"`, "a\n", 0},
+		{`raise "stop" if $_ == "b\n"`, "a\n", 1},
+	}
+	for _, tt := range tests {
+		stdout, stderr, status := runRgo(t, "a\nb\nc\n", "-pe", tt.script)
+		if stdout != tt.stdout || status != tt.status {
+			t.Errorf("rgo -pe %q: stdout %q, status %d; want %q, %d (stderr %q)", tt.script, stdout, status, tt.stdout, tt.status, stderr)
+		}
+		if tt.status != 0 && !strings.Contains(stderr, "stop") {
+			t.Errorf("rgo -pe %q: stderr %q, want the error", tt.script, stderr)
+		}
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,8 +23,11 @@ import (
 	"github.com/GoLangDream/rgo/pkg/compiler"
 	"github.com/GoLangDream/rgo/pkg/core"
 	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/lsp"
 	"github.com/GoLangDream/rgo/pkg/object"
 	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+	"github.com/GoLangDream/rgo/pkg/profiler"
 	"github.com/GoLangDream/rgo/pkg/vm"
 )
 
@@ -42,57 +46,66 @@ type SpecRunner struct {
 
 func main() {
 	configureRuntimeGC()
-	stopProfiles := startRuntimeProfiles()
+	stopProfiles = startRuntimeProfiles()
 	defer stopProfiles()
+	if runPackagedApplication() {
+		return
+	}
 
 	args := os.Args[1:]
-
-	if len(args) < 1 {
+	if len(args) < 1 && stdinIsTerminal() {
 		printUsage()
 		os.Exit(1)
 	}
 
-	args, loopMode, warningAll := parseLeadingRubyOptions(args)
-	if warningAll {
-		_ = os.Setenv("RGO_WARNING_ALL", "1")
+	opts := newRubyOptions()
+	args, err := opts.parse(args, false)
+	if err == nil && !opts.disableRubyopt {
+		err = opts.parseRubyopt(os.Getenv("RUBYOPT"))
 	}
-
-	if len(args) == 0 {
-		printUsage()
-		os.Exit(1)
+	if err != nil {
+		exitOptionError(err)
 	}
-	command := args[0]
-	core.Init()
-	if loopMode {
-		if command == "-e" {
-			if len(args) < 2 {
-				fmt.Fprintf(os.Stderr, "Usage: rgo -n -e <code>\n")
-				os.Exit(1)
-			}
-			runRubySource(wrapRubyLoopSource(args[1]), "-e", args[2:])
+	if opts.warningLevel == 2 {
+		_ = os.Setenv("RGO_WARNING_ALL", "1")
+	}
+	frozenStringLiterals = opts.frozenLiterals
+	if opts.version || opts.showVersion {
+		fmt.Printf("ruby %s (rgo) [%s-%s]\n", core.RubyCompatibilityVersion, runtime.GOOS, runtime.GOARCH)
+		if opts.version || (len(args) == 0 && len(opts.eval) == 0) {
 			return
 		}
-		bytes, err := os.ReadFile(command)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-			os.Exit(1)
-		}
-		runRubySource(wrapRubyLoopSource(string(bytes)), command, args[1:])
-		return
 	}
-	if strings.HasPrefix(command, "--enable") || strings.HasPrefix(command, "--disable") {
-		runRubyWithFeatureFlagWarning(command, args[1:])
+	if opts.copyright {
+		fmt.Println("rgo - Copyright (C) the RGo authors; Ruby - Copyright (C) 1993 Yukihiro Matsumoto")
 		return
 	}
-	if requiredArgs, ok := requiredCLIArgs(command, args[1:]); ok {
-		runRubyFileWithRequired(requiredArgs)
+	if opts.help {
+		printUsage()
 		return
 	}
-	if loadPathArgs, ok := loadPathCLIArgs(command, args[1:]); ok {
-		runRubyFileWithLoadPath(loadPathArgs)
+
+	core.Init()
+	startAllocationProfile()
+	if len(opts.eval) == 0 && len(args) > 0 && args[0] == "disasm" {
+		dumpTargets = []string{"insns"}
+		args = args[1:]
+	}
+	if len(dumpTargets) > 0 {
+		if len(opts.eval) > 0 {
+			args = []string{"-e", strings.Join(opts.eval, "\n")}
+		}
+		dumpRubyProgram(args)
 		return
 	}
+	if sandboxPolicy != nil {
+		core.EnableSandbox(*sandboxPolicy)
+	}
 
+	command := ""
+	if len(opts.eval) == 0 && len(args) > 0 {
+		command = args[0]
+	}
 	switch command {
 	case "compile":
 		compileAOTCommand(args[1:])
@@ -119,55 +132,207 @@ func main() {
 			return
 		}
 		runRubyFileWithMode(args[1], args[2:], true)
-	case "-e":
-		if len(args) < 2 {
-			fmt.Fprintf(os.Stderr, "Usage: rgo -e <code>\n")
-			os.Exit(1)
-		}
-		runRubySource(args[1], "-e", args[2:])
-	case "-x":
-		if len(args) < 2 {
-			fmt.Fprintf(os.Stderr, "Usage: rgo -x <file.rb>\n")
-			os.Exit(1)
-		}
-		if args[1] == "run" {
-			if len(args) < 3 {
-				fmt.Fprintf(os.Stderr, "Usage: rgo -x run <file.rb>\n")
-				os.Exit(1)
-			}
-			runRubyFileAfterRubyShebang(args[2], args[3:])
-			return
-		}
-		runRubyFileAfterRubyShebang(args[1], args[2:])
-	case "-r":
-		runRubyFileWithRequired(args[1:])
-	case "-S":
-		runRubyPathLauncher(args[1:])
 	case "run":
 		if len(args) < 2 {
 			fmt.Fprintf(os.Stderr, "Usage: rgo run <file.rb>\n")
 			os.Exit(1)
 		}
-		runRubyFile(args[1], args[2:])
+		runRubyProgram(opts, args[1:])
+	case "irb":
+		runIRBCommand(args[1:])
+	case "debug":
+		runDebugCommand(opts, args[1:])
+	case "link":
+		runLinkCommand(args[1:])
+	case "package":
+		runPackageCommand(args[1:])
+	case "check":
+		runCheckCommand(args[1:])
+	case "fmt":
+		runFmtCommand(args[1:])
+	case "lint":
+		runLintCommand(args[1:])
+	case "lsp":
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "rgo lsp: %v\n", err)
+			os.Exit(1)
+		}
 	case "test":
 		if len(args) < 2 {
 			fmt.Fprintf(os.Stderr, "Usage: rgo test <file.rb>\n")
 			os.Exit(1)
 		}
 		runSpecFile(args[1])
-	case "-h", "-help", "--help", "help":
+	case "help":
 		printUsage()
-	case "-v", "--version":
-		fmt.Printf("ruby %s (rgo) [%s-%s]\n", core.RubyCompatibilityVersion, runtime.GOOS, runtime.GOARCH)
 	default:
-		if strings.HasSuffix(command, ".rb") {
-			runRubyFile(command, args[1:])
+		runRubyProgram(opts, args)
+	}
+}
+
+// frozenStringLiterals is set by --enable=frozen-string-literal.
+var frozenStringLiterals bool
+
+func exitOptionError(err error) {
+	fmt.Fprintf(os.Stderr, "rgo: %v\n", err)
+	os.Exit(1)
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runRubyProgram runs -e code, a script or standard input the way `ruby`
+// does, after applying the switches in opts.
+func runRubyProgram(opts *rubyOptions, args []string) {
+	if opts.chdir != "" {
+		if err := os.Chdir(opts.chdir); err != nil {
+			fmt.Fprintf(os.Stderr, "rgo: Can't chdir to %s (fatal)\n", opts.chdir)
+			os.Exit(1)
+		}
+	}
+
+	var source, filename string
+	var argv []string
+	switch {
+	case len(opts.eval) > 0:
+		source, filename, argv = strings.Join(opts.eval, "\n"), "-e", args
+	case len(args) == 0 || args[0] == "-":
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+			os.Exit(1)
+		}
+		source, filename = string(input), "-"
+		if len(args) > 0 {
+			argv = args[1:]
+		}
+	default:
+		filename, argv = args[0], args[1:]
+		if opts.searchPath {
+			path, ok := searchRubyPath(filename)
+			if !ok {
+				runRubyPathLauncher(args)
+				return
+			}
+			filename = path
+		}
+		if info, err := os.Stat(filename); err != nil || info.IsDir() {
+			fmt.Fprintf(os.Stderr, "rgo: No such file or directory -- %s (LoadError)\n", filename)
+			os.Exit(1)
+		}
+		if filepath.Ext(filename) == compiler.BundleExtension {
+			runBundleFile(filename, argv)
 			return
 		}
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
-		printUsage()
+		content, err := readSpecFileWithSharedRequires(filename, map[string]bool{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			os.Exit(1)
+		}
+		source = content
+	}
+
+	if opts.extract {
+		extracted, ok := sourceAfterRubyShebang(source)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "no Ruby script found in input")
+			os.Exit(1)
+		}
+		source = extracted
+		if opts.extractDir != "" {
+			if err := os.Chdir(opts.extractDir); err != nil {
+				fmt.Fprintf(os.Stderr, "rgo: Can't chdir to %s (fatal)\n", opts.extractDir)
+				os.Exit(1)
+			}
+		}
+	}
+	if filename != "-e" {
+		if err := opts.parseShebang(source); err != nil {
+			exitOptionError(err)
+		}
+	}
+	if len(opts.loadPaths) > 0 {
+		paths := make([]string, 0, len(opts.loadPaths))
+		for _, path := range opts.loadPaths {
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+			paths = append(paths, path)
+		}
+		prependRubyLib(paths...)
+	}
+
+	var switches []scriptSwitch
+	if opts.switches {
+		argv, switches = takeScriptSwitches(argv)
+	}
+	if opts.syntaxOnly {
+		checkRubySyntax(source, filename)
+		return
+	}
+	transformed := opts.extract
+	if opts.loop || opts.print {
+		loopOptions = opts
+		transformed = true
+	}
+	prelude := opts.prelude(switches)
+	if prelude == "" && !transformed && filename != "-e" && filename != "-" {
+		runRubyFile(filename, argv)
+		return
+	}
+	runRubySourceWithEncodingAndPreload(source, filename, argv, core.SourceEncoding(source), prelude, "")
+}
+
+// checkRubySyntax implements -c. The program is compiled as well as parsed,
+// so anything the parser lets through but the compiler cannot lower is
+// reported instead of "Syntax OK".
+func checkRubySyntax(source, filename string) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		exitSyntaxError(p, filename)
+	}
+	c := compiler.NewWithSourceEncoding(core.SourceEncoding(source))
+	if err := c.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s:%d: %v (SyntaxError)\n", filename, filename, c.ErrorLine(), err)
 		os.Exit(1)
 	}
+	fmt.Println("Syntax OK")
+}
+
+// exitSyntaxError reports the first parse error in the main script the way
+// MRI does, "script: script:line: message (SyntaxError)" followed by the
+// offending line and a caret, and exits 1.
+func exitSyntaxError(p *parser.Parser, filename string) {
+	diagnostic := p.Diagnostics()[0]
+	diagnostic.File = filename
+	message, snippet, _ := strings.Cut(diagnostic.Report(1), "\n")
+	fmt.Fprintf(os.Stderr, "%s: %s (SyntaxError)\n", filename, message)
+	if snippet != "" {
+		fmt.Fprintln(os.Stderr, snippet)
+	}
+	os.Exit(1)
+}
+
+// searchRubyPath implements -S: a bare script name is looked up in RUBYPATH
+// and then PATH.
+func searchRubyPath(name string) (string, bool) {
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, os.PathSeparator) {
+		return name, true
+	}
+	dirs := append(filepath.SplitList(os.Getenv("RUBYPATH")), filepath.SplitList(os.Getenv("PATH"))...)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, name)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
 }
 
 // configureRuntimeGC keeps short-lived Ruby scripts from spending most of
@@ -193,7 +358,12 @@ func configureRuntimeGC() {
 	debug.SetGCPercent(target)
 }
 
+// stopProfiles writes the profiles startRuntimeProfiles started. The exit
+// paths of a script call it too, as os.Exit skips main's deferred call.
+var stopProfiles = func() {}
+
 func startRuntimeProfiles() func() {
+	stopRubyProfile := startRubyProfile()
 	var cpuFile *os.File
 	if path := os.Getenv("RGO_CPU_PROFILE"); path != "" {
 		file, err := os.Create(path)
@@ -207,103 +377,238 @@ func startRuntimeProfiles() func() {
 		}
 	}
 
+	var once sync.Once
 	return func() {
-		if cpuFile != nil {
-			pprof.StopCPUProfile()
-			_ = cpuFile.Close()
+		once.Do(func() {
+			stopRubyProfile()
+			stopAllocationProfile()
+			stopGoProfiles(cpuFile)
+		})
+	}
+}
+
+// startRubyProfile starts the Ruby-level sampling profile RGO_RUBY_PROFILE
+// asks for and returns the function writing it there. The file's extension
+// picks the format, as for StackProf's out: option, and collapsed stacks
+// are written when it names none. RGO_RUBY_PROFILE_MODE=wall samples
+// elapsed rather than CPU time and RGO_RUBY_PROFILE_INTERVAL sets the
+// sampling interval in microseconds.
+func startRubyProfile() func() {
+	path := os.Getenv("RGO_RUBY_PROFILE")
+	if path == "" {
+		return func() {}
+	}
+	mode, interval := profiler.CPU, profiler.DefaultInterval
+	if raw := os.Getenv("RGO_RUBY_PROFILE_MODE"); raw != "" {
+		mode = profiler.Mode(raw)
+	}
+	if raw := os.Getenv("RGO_RUBY_PROFILE_INTERVAL"); raw != "" {
+		micros, err := strconv.Atoi(raw)
+		if err != nil {
+			micros = -1
 		}
-		if path := os.Getenv("RGO_HEAP_PROFILE"); path != "" {
-			file, err := os.Create(path)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Cannot create heap profile: %v\n", err)
-				return
-			}
-			runtime.GC()
-			if err := pprof.WriteHeapProfile(file); err != nil {
-				fmt.Fprintf(os.Stderr, "Cannot write heap profile: %v\n", err)
-			}
-			_ = file.Close()
+		interval = time.Duration(micros) * time.Microsecond
+	}
+	if err := core.StartRubyProfile(mode, interval); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot start Ruby profile: %v\n", err)
+		return func() {}
+	}
+	return func() {
+		profile := core.StopRubyProfile()
+		if profile == nil {
+			return
+		}
+		if err := profile.WriteFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write Ruby profile: %v\n", err)
 		}
 	}
 }
 
-func parseLeadingRubyOptions(args []string) ([]string, bool, bool) {
-	loopMode := false
-	warningAll := false
-	for len(args) > 0 {
-		switch {
-		case args[0] == "-n":
-			loopMode = true
-			args = args[1:]
-		case args[0] == "-w":
-			warningAll = true
-			args = args[1:]
-		case strings.HasPrefix(args[0], "-W"):
-			args = args[1:]
-		case strings.HasPrefix(args[0], "--backtrace-limit="):
-			limit := strings.TrimPrefix(args[0], "--backtrace-limit=")
-			if _, err := strconv.ParseInt(limit, 10, 64); err == nil {
-				_ = os.Setenv("RGO_BACKTRACE_LIMIT", limit)
-			}
-			args = args[1:]
-		default:
-			return args, loopMode, warningAll
-		}
+// startAllocationProfile starts the allocation profile RGO_ALLOCATION_PROFILE
+// asks for. It runs once the core library is initialized, so the report
+// counts the script's allocations rather than the builtin classes'.
+func startAllocationProfile() {
+	if os.Getenv("RGO_ALLOCATION_PROFILE") != "" {
+		core.StartAllocationProfile()
+	}
+}
+
+// stopAllocationProfile writes the allocation profile as a memory_profiler
+// report to the file RGO_ALLOCATION_PROFILE names.
+func stopAllocationProfile() {
+	path := os.Getenv("RGO_ALLOCATION_PROFILE")
+	if path == "" {
+		return
+	}
+	profile := core.StopAllocationProfile()
+	if profile == nil {
+		return
+	}
+	if err := profile.WriteReportFile(path, profiler.DefaultReportOptions); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write allocation profile: %v\n", err)
 	}
-	return args, loopMode, warningAll
 }
 
-func wrapRubyLoopSource(source string) string {
-	input, _ := io.ReadAll(os.Stdin)
-	lines := strings.SplitAfter(string(input), "\n")
-	if len(lines) > 0 && lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
+func stopGoProfiles(cpuFile *os.File) {
+	if cpuFile != nil {
+		pprof.StopCPUProfile()
+		_ = cpuFile.Close()
 	}
-	literals := make([]string, 0, len(lines))
-	for _, line := range lines {
-		literals = append(literals, strconv.Quote(line))
+	if path := os.Getenv("RGO_HEAP_PROFILE"); path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create heap profile: %v\n", err)
+			return
+		}
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write heap profile: %v\n", err)
+		}
+		_ = file.Close()
 	}
-	return "[" + strings.Join(literals, ",") + "].each do |__rgo_line|\n$_ = __rgo_line\n" + source + "\nend"
 }
 
-func requiredCLIArgs(command string, args []string) ([]string, bool) {
-	if command == "-r" {
-		return append([]string(nil), args...), true
+// dumpTargets is set by --dump=insns,parsetree; the program is printed
+// instead of run.
+var dumpTargets []string
+
+// sandboxPolicy is set by the --sandbox options and applied once the core
+// library is initialized.
+var sandboxPolicy *core.SandboxPolicy
+
+// parseSandboxOption handles --sandbox, which denies every capability,
+// --sandbox-allow=file,process,network,env,require to grant some back,
+// --sandbox-timeout=DURATION and --sandbox-memory=SIZE. Any of them turns
+// the sandbox on.
+func parseSandboxOption(arg string) error {
+	if sandboxPolicy == nil {
+		sandboxPolicy = &core.SandboxPolicy{}
 	}
-	if strings.HasPrefix(command, "-r") && len(command) > 2 {
-		result := make([]string, 0, len(args)+1)
-		result = append(result, command[2:])
-		result = append(result, args...)
-		return result, true
+	name, value, _ := strings.Cut(arg, "=")
+	switch name {
+	case "--sandbox":
+		return nil
+	case "--sandbox-allow":
+		for _, capability := range strings.Split(value, ",") {
+			switch strings.TrimSpace(capability) {
+			case "file":
+				sandboxPolicy.AllowFileSystem = true
+			case "process":
+				sandboxPolicy.AllowProcess = true
+			case "network":
+				sandboxPolicy.AllowNetwork = true
+			case "env":
+				sandboxPolicy.AllowEnv = true
+			case "require":
+				sandboxPolicy.AllowRequire = true
+			case "":
+			default:
+				return fmt.Errorf("unknown sandbox capability %q (want file, process, network, env or require)", capability)
+			}
+		}
+		return nil
+	case "--sandbox-timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid --sandbox-timeout %q", value)
+		}
+		sandboxPolicy.Timeout = timeout
+		return nil
+	case "--sandbox-memory":
+		limit, err := parseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid --sandbox-memory %q", value)
+		}
+		sandboxPolicy.MemoryLimit = limit
+		return nil
 	}
-	return nil, false
+	return fmt.Errorf("unknown option %s", arg)
 }
 
-func loadPathCLIArgs(command string, args []string) ([]string, bool) {
-	if command == "-I" {
-		return append([]string(nil), args...), true
+// parseByteSize accepts a byte count with an optional K, M or G suffix.
+func parseByteSize(value string) (uint64, error) {
+	digits := strings.TrimSuffix(strings.ToUpper(value), "B")
+	multiplier := uint64(1)
+	if digits != "" {
+		switch digits[len(digits)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			digits = digits[:len(digits)-1]
+		}
 	}
-	if strings.HasPrefix(command, "-I") && len(command) > 2 {
-		result := make([]string, 0, len(args)+1)
-		result = append(result, command[2:])
-		result = append(result, args...)
-		return result, true
+	size, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
 	}
-	return nil, false
+	return size * multiplier, nil
 }
 
 func printUsage() {
 	fmt.Fprintf(os.Stderr, `RGo - Ruby implementation in Go
 
 Usage:
-	  rgo run <file.rb>    Run a Ruby file
+	  rgo run <file.rb>    Run a Ruby file, or a bundle written by rgo link
 	  rgo fast <file.rb>   Run the strict AOT subset with cached Go code, then VM fallback
 	  rgo compile <file.rb> Generate standalone Go for the strict integer AOT subset
   rgo build <file.rb>   Build a standalone executable from that AOT subset
   rgo test <file.rb>   Run a spec test file (supports mspec DSL)
+  rgo irb             Start an interactive Ruby session
+  rgo debug [--nonstop] [--port=N [--host=H]] <file.rb>
+                       Run a file under the debugger: a console session, or
+                       a Debug Adapter Protocol server for editors with --port
+  rgo disasm <file.rb> Print the compiled bytecode of every method and block
+  rgo link <file.rb> [-o app.rgob]
+                       Compile a script and the files it require_relatives
+                       into one bundle
+  rgo package <file.rb> [-o app] [-I dir]...
+                       Build an executable that carries the script, the .rb
+                       files beside it and vendored gems, and runs anywhere
+  rgo check [--format=text|json] [paths...]
+                       Parse and compile .rb files without running them
+  rgo fmt [--check] [-w] [paths...]
+                       Print .rb files (or stdin) in canonical layout; -w
+                       rewrites them, --check lists unformatted files
+  rgo lint [--config=FILE] [--format=text|json|sarif] [paths...]
+                       Run the lint rules (--list-rules) over .rb files
+  rgo lsp              Run a language server over stdin/stdout
+  rgo --dump=insns|parsetree <file.rb|-e code>
+                       Print bytecode or the parse tree instead of running
   rgo -e <code>        Run Ruby source passed on the command line
+  rgo --sandbox [--sandbox-allow=file,process,network,env,require]
+      [--sandbox-timeout=5s] [--sandbox-memory=256M] <file.rb|-e code>
+                       Run untrusted code; denied operations raise SecurityError
   rgo help            Show this help
 
+Ruby switches (also read from RUBYOPT and the script's #! line):
+  -0[octal]       Record separator ($/); -00 is paragraph mode, -0777 slurps
+  -a              Split each line into $F when used with -n or -p
+  -c              Check syntax only
+  -Cdirectory     Change to directory before running
+  -d, --debug     Set $DEBUG to true
+  -e 'command'    One line of script; several -e's are allowed
+  -Eext[:int]     Set the default external and internal encodings
+  -Fpattern       Split pattern for -a ($;)
+  -i[extension]   Edit ARGV files in place, keeping a backup with extension
+  -Idirectory     Add directory to $LOAD_PATH
+  -l              Chomp lines read with -n/-p and set $\ to $/
+  -n              Wrap the script in a while gets ... end loop
+  -p              Like -n, printing $_ after each iteration
+  -rlibrary       Require library before running the script
+  -s              Turn -name switches after the script name into $name
+  -S              Look up the script in RUBYPATH and PATH
+  -v, --version   Print the version (-v also turns on verbose mode)
+  -w, -W[level]   Set warning level: 0 silent, 1 medium, 2 verbose
+  -x[directory]   Ignore text before the #!ruby line
+  --enable=feature, --disable=feature
+                  frozen-string-literal and rubyopt take effect; gems,
+                  did_you_mean and JIT switches are accepted and ignored
+
 `)
 }
 
@@ -807,24 +1112,88 @@ func buildAOTCommand(args []string) {
 	fmt.Printf("built %s\n", output)
 }
 
-func runRubyWithFeatureFlagWarning(command string, args []string) {
-	if strings.Contains(command, "ruby-spec-feature-does-not-exist") {
-		if strings.HasPrefix(command, "--enable") {
-			fmt.Fprintln(os.Stderr, "warning: unknown argument for --enable")
-		} else {
-			fmt.Fprintln(os.Stderr, "warning: unknown argument for --disable")
+// runIRBCommand starts an interactive session on the top-level binding. Any
+// -r features are required first so their constants are visible to the first
+// entry; everything else about the session lives in pkg/irb.
+func runIRBCommand(args []string) {
+	var source strings.Builder
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-r" && i+1 < len(args):
+			source.WriteString("require " + strconv.Quote(args[i+1]) + "\n")
+			i++
+		case strings.HasPrefix(args[i], "-r") && len(args[i]) > 2:
+			source.WriteString("require " + strconv.Quote(args[i][2:]) + "\n")
+		default:
+			fmt.Fprintf(os.Stderr, "Usage: rgo irb [-r feature]...\n")
+			os.Exit(1)
 		}
 	}
-	for i := 0; i < len(args)-1; i++ {
-		if args[i] == "-e" {
-			runRubySource(args[i+1], "-e", args[i+2:])
-			return
+	source.WriteString("binding.irb\n")
+	runRubySourceWithEncodingAndPreloadMode(source.String(), "irb", nil, "UTF-8", "", "", false)
+}
+
+// dumpRubyProgram prints the parse tree and/or disassembled bytecode of a
+// file or `-e` source for each of dumpTargets. Methods are marked with
+// whether the Register IR tier accepts them.
+func dumpRubyProgram(args []string) {
+	if len(args) == 0 || (args[0] == "-e" && len(args) < 2) {
+		fmt.Fprintf(os.Stderr, "Usage: rgo disasm <file.rb>\n       rgo --dump=insns|parsetree <file.rb|-e code>\n")
+		os.Exit(1)
+	}
+	filename, source := "-e", ""
+	if args[0] == "-e" {
+		source = args[1]
+	} else {
+		filename = args[0]
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			os.Exit(1)
 		}
+		source = string(content)
 	}
-	for i, arg := range args {
-		if strings.HasSuffix(arg, ".rb") {
-			runRubyFile(arg, args[i+1:])
-			return
+	core.CurrentSpecFile = filename
+	core.CurrentSpecFileAbsolute, _ = filepath.Abs(filename)
+	core.CurrentEvalSourceEncoding = core.SourceEncoding(source)
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		exitSyntaxError(p, filename)
+	}
+	var bytecode *compiler.Bytecode
+	for _, target := range dumpTargets {
+		var err error
+		switch target {
+		case "parsetree":
+			err = ast.Dump(os.Stdout, program)
+		case "insns":
+			if bytecode == nil {
+				c := compiler.New()
+				if err := c.Compile(program); err != nil {
+					fmt.Fprintf(os.Stderr, "Compile Error: %v\n", err)
+					os.Exit(1)
+				}
+				bytecode = c.Bytecode()
+			}
+			err = compiler.Disassemble(os.Stdout, bytecode, compiler.DisassembleOptions{
+				Path:   filename,
+				Source: source,
+				Annotate: func(fn *object.Function) string {
+					if !fn.MethodBody {
+						return ""
+					}
+					if vm.RegisterIRCompiles(fn) {
+						return "register IR"
+					}
+					return "bytecode only"
+				},
+			})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rgo: %v\n", err)
+			os.Exit(1)
 		}
 	}
 }
@@ -847,17 +1216,22 @@ func runRubySourceWithEncodingAndPreloadMode(source string, filename string, arg
 	defer stopSignals()
 
 	oldSpecFile := core.CurrentSpecFile
+	oldSpecFileAbsolute := core.CurrentSpecFileAbsolute
 	oldSourceEncoding := core.CurrentEvalSourceEncoding
 	oldTopLevelMain := core.CurrentTopLevelMain
 	core.CurrentSpecFile = filename
+	if filename != "-e" && filename != "-" && filename != "irb" {
+		core.CurrentSpecFileAbsolute, _ = filepath.Abs(filename)
+	}
 	core.CurrentEvalSourceEncoding = sourceEncoding
 	core.CurrentTopLevelMain = true
 	defer func() {
 		core.CurrentSpecFile = oldSpecFile
+		core.CurrentSpecFileAbsolute = oldSpecFileAbsolute
 		core.CurrentEvalSourceEncoding = oldSourceEncoding
 		core.CurrentTopLevelMain = oldTopLevelMain
 	}()
-	if allowCompiled && preloadSource == "" {
+	if allowCompiled && preloadSource == "" && loopOptions == nil && core.Sandbox == nil {
 		if handled, err := tryRunCompiledSource(source, argv); handled {
 			if err != nil {
 				exitCompiledError(err)
@@ -873,10 +1247,10 @@ func runRubySourceWithEncodingAndPreloadMode(source string, filename string, arg
 	program := p.ParseProgram()
 
 	if len(p.Errors()) > 0 {
-		for _, err := range p.Errors() {
-			fmt.Fprintf(os.Stderr, "Parse Error: %s\n", err)
-		}
-		os.Exit(1)
+		exitSyntaxError(p, filename)
+	}
+	if loopOptions != nil {
+		program = wrapRubyLoopProgram(program, loopOptions)
 	}
 	c := compiler.New()
 	err := c.Compile(program)
@@ -888,7 +1262,7 @@ func runRubySourceWithEncodingAndPreloadMode(source string, filename string, arg
 	bytecode := c.Bytecode()
 	v := vm.New(bytecode)
 	v.SetInstructionLimit(uint64(getEnvInt("RGO_VM_INSTRUCTION_LIMIT")))
-	v.SetFreezeStringLiterals(vm.SourceFreezesStringLiterals(source))
+	v.SetFreezeStringLiterals(frozenStringLiterals || vm.SourceFreezesStringLiterals(source))
 	v.SetChillStringLiterals(vm.SourceChillsStringLiterals(source))
 	v.SetProgramName(filename)
 	setARGV(v, argv)
@@ -906,6 +1280,7 @@ func runRubySourceWithEncodingAndPreloadMode(source string, filename string, arg
 		}
 	}
 	err = v.Run()
+	exitIfSandboxLimitExceeded()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Runtime Error: %v\n", err)
 		os.Exit(1)
@@ -950,172 +1325,17 @@ func mainDataOffset(source, filename string) (int64, bool) {
 	return 0, false
 }
 
-func runRubyFileAfterRubyShebang(filename string, argv []string) {
-	bytes, err := os.ReadFile(filename)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
-	}
-	lines := strings.Split(string(bytes), "\n")
+// sourceAfterRubyShebang implements -x: text before the first #! line that
+// mentions ruby is discarded. The #! line is kept for its switches.
+func sourceAfterRubyShebang(source string) (string, bool) {
+	lines := strings.Split(source, "\n")
 	for i, line := range lines {
 		trimmed := strings.TrimSpace(line)
 		if strings.HasPrefix(trimmed, "#!") && strings.Contains(trimmed, "ruby") {
-			runRubySource(strings.Join(lines[i+1:], "\n"), filename, argv)
-			return
-		}
-	}
-	fmt.Fprintln(os.Stderr, "no Ruby script found in input")
-	os.Exit(1)
-}
-
-func runRubyFileWithRequired(args []string) {
-	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: rgo -r <file> [script.rb|-e code]\n")
-		os.Exit(1)
-	}
-	requirePath := args[0]
-	requiredSource, requiredFile, err := readRequiredSource(requirePath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-	for i := 1; i < len(args); i++ {
-		if !strings.HasPrefix(args[i], "-r") || len(args[i]) <= 2 {
-			continue
-		}
-		extraSource, _, extraErr := readRequiredSource(args[i][2:])
-		if extraErr != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", extraErr)
-			os.Exit(1)
-		}
-		requiredSource += extraSource
-	}
-	if len(args) == 1 {
-		mainSource, readErr := io.ReadAll(os.Stdin)
-		if readErr != nil {
-			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", readErr)
-			os.Exit(1)
+			return strings.Join(lines[i:], "\n"), true
 		}
-		mainText := string(mainSource)
-		runRubySourceWithEncodingAndPreload(mainText, "-", nil, core.SourceEncoding(mainText), requiredSource, requiredFile)
-		return
-	}
-	evalIndex := -1
-	for i := 1; i < len(args); i++ {
-		if args[i] == "-e" {
-			evalIndex = i
-			break
-		}
-	}
-	if evalIndex >= 0 {
-		if evalIndex+1 >= len(args) {
-			fmt.Fprintln(os.Stderr, "Usage: rgo -r <file> -e <code>")
-			os.Exit(1)
-		}
-		mainText := args[evalIndex+1]
-		runRubySourceWithEncodingAndPreload(mainText, "-e", args[evalIndex+2:], core.SourceEncoding(mainText), requiredSource, requiredFile)
-		return
 	}
-	scriptIndex := 1
-	for scriptIndex < len(args) && strings.HasPrefix(args[scriptIndex], "-r") && len(args[scriptIndex]) > 2 {
-		scriptIndex++
-	}
-	if scriptIndex >= len(args) {
-		fmt.Fprintf(os.Stderr, "Usage: rgo -r <file> <script.rb>\n")
-		os.Exit(1)
-	}
-	if args[scriptIndex] == "run" {
-		scriptIndex++
-	}
-	if scriptIndex >= len(args) {
-		fmt.Fprintf(os.Stderr, "Usage: rgo -r <file> <script.rb>\n")
-		os.Exit(1)
-	}
-	scriptPath := args[scriptIndex]
-	if _, err := os.Stat(scriptPath); err != nil {
-		fmt.Fprintf(os.Stderr, "No such file or directory -- %s\n", scriptPath)
-		os.Exit(1)
-	}
-	mainSource, err := readSpecFileWithSharedRequires(scriptPath, map[string]bool{})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
-	}
-	runRubySourceWithEncodingAndPreload(mainSource, scriptPath, args[scriptIndex+1:], core.SourceEncoding(mainSource), requiredSource, requiredFile)
-}
-
-func readRequiredSource(path string) (string, string, error) {
-	candidates := []string{path}
-	if !strings.HasSuffix(path, ".rb") {
-		candidates = append(candidates, path+".rb")
-	}
-	for _, candidate := range candidates {
-		if content, err := os.ReadFile(candidate); err == nil {
-			abs, _ := filepath.Abs(candidate)
-			return string(content), abs, nil
-		}
-	}
-	if path == "mkmf" || path == "mkmf.rb" || path == "objspace" || path == "objspace.rb" || path == "tempfile" || path == "tempfile.rb" || path == "rubygems" || path == "rubygems.rb" {
-		feature := strings.TrimSuffix(path, ".rb")
-		return "require \"" + feature + "\"\n", feature + ".rb", nil
-	}
-	return "", "", fmt.Errorf("cannot load such file -- %s", path)
-}
-
-func runRubyFileWithLoadPath(args []string) {
-	if len(args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: rgo -I <path> <script.rb | -e code>\n")
-		os.Exit(1)
-	}
-	scriptIndex := 1
-	loadPaths := []string{args[0]}
-	for scriptIndex < len(args) {
-		switch {
-		case args[scriptIndex] == "-I":
-			if scriptIndex+1 >= len(args) {
-				fmt.Fprintf(os.Stderr, "Usage: rgo -I <path> <script.rb | -e code>\n")
-				os.Exit(1)
-			}
-			loadPaths = append(loadPaths, args[scriptIndex+1])
-			scriptIndex += 2
-		case strings.HasPrefix(args[scriptIndex], "-I") && len(args[scriptIndex]) > 2:
-			loadPaths = append(loadPaths, args[scriptIndex][2:])
-			scriptIndex++
-		default:
-			goto optionsComplete
-		}
-	}
-
-optionsComplete:
-	if scriptIndex >= len(args) {
-		fmt.Fprintf(os.Stderr, "Usage: rgo -I <path> <script.rb | -e code>\n")
-		os.Exit(1)
-	}
-	if args[scriptIndex] == "run" {
-		scriptIndex++
-	}
-	if scriptIndex >= len(args) {
-		fmt.Fprintf(os.Stderr, "Usage: rgo -I <path> <script.rb | -e code>\n")
-		os.Exit(1)
-	}
-	for index, loadPath := range loadPaths {
-		if !filepath.IsAbs(loadPath) {
-			if wd, err := os.Getwd(); err == nil {
-				loadPath = filepath.Join(wd, loadPath)
-			}
-		}
-		loadPaths[index] = loadPath
-	}
-	prependRubyLib(loadPaths...)
-	if args[scriptIndex] == "-e" {
-		if scriptIndex+1 >= len(args) {
-			fmt.Fprintf(os.Stderr, "Usage: rgo -I <path> -e <code>\n")
-			os.Exit(1)
-		}
-		runRubySource(args[scriptIndex+1], "-e", args[scriptIndex+2:])
-		return
-	}
-	runRubyFile(args[scriptIndex], args[scriptIndex+1:])
+	return "", false
 }
 
 func prependRubyLib(paths ...string) {
@@ -1197,10 +1417,7 @@ func runRubyFileWithMode(filename string, argv []string, allowCompiled bool) {
 	program := p.ParseProgram()
 
 	if len(p.Errors()) > 0 {
-		for _, err := range p.Errors() {
-			fmt.Fprintf(os.Stderr, "Parse Error: %s\n", err)
-		}
-		os.Exit(1)
+		exitSyntaxError(p, filename)
 	}
 	c := compiler.New()
 	err = c.Compile(program)
@@ -1211,7 +1428,7 @@ func runRubyFileWithMode(filename string, argv []string, allowCompiled bool) {
 
 	bytecode := c.Bytecode()
 	v := vm.New(bytecode)
-	v.SetFreezeStringLiterals(vm.SourceFreezesStringLiterals(content))
+	v.SetFreezeStringLiterals(frozenStringLiterals || vm.SourceFreezesStringLiterals(content))
 	v.SetProgramName(filename)
 	setARGV(v, argv)
 	if offset, ok := mainDataOffset(content, filename); ok {
@@ -1221,6 +1438,7 @@ func runRubyFileWithMode(filename string, argv []string, allowCompiled bool) {
 		}
 	}
 	err = v.Run()
+	exitIfSandboxLimitExceeded()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Runtime Error: %v\n", err)
 		os.Exit(1)
@@ -1229,11 +1447,24 @@ func runRubyFileWithMode(filename string, argv []string, allowCompiled bool) {
 	exitIfUnhandledRuntimeException(v.UnhandledException())
 }
 
+// exitIfSandboxLimitExceeded ends a run that used up its sandbox budget.
+// The violation is not a Ruby exception, so it is reported on its own.
+func exitIfSandboxLimitExceeded() {
+	err := core.SandboxLimitExceeded()
+	if err == nil {
+		return
+	}
+	stopProfiles()
+	fmt.Fprintf(os.Stderr, "rgo: %v\n", err)
+	os.Exit(1)
+}
+
 func exitIfSystemExit() {
 	exception := core.LastException
 	if exception == nil || exception.Type != object.ValueException || exception.Class == nil || exception.Class.Name != "SystemExit" {
 		return
 	}
+	stopProfiles()
 	if data, ok := exception.Data.(*object.RException); ok && data != nil && data.Status != nil {
 		os.Exit(int(*data.Status))
 	}
@@ -1249,6 +1480,7 @@ func exitIfUnhandledRuntimeException(exception *object.EmeraldValue) {
 		signal.Reset(syscall.Signal(signalNumber))
 		_ = syscall.Kill(os.Getpid(), syscall.Signal(signalNumber))
 	}
+	stopProfiles()
 	fmt.Fprintf(os.Stderr, "Runtime Error: %s\n", runtimeExceptionDescription(exception))
 	os.Exit(1)
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain lets runRgo re-execute the test binary as the rgo command.
+func TestMain(m *testing.M) {
+	if os.Getenv("RGO_TEST_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runRgo runs rgo with args in a child process, feeding it stdin, and
+// returns what it wrote to stdout and stderr and its exit status.
+func runRgo(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	command := exec.Command(os.Args[0], args...)
+	command.Env = append(os.Environ(), "RGO_TEST_RUN_MAIN=1")
+	command.Stdin = strings.NewReader(stdin)
+	var stdout, stderr strings.Builder
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("running rgo %q: %v", args, err)
+	}
+	return stdout.String(), stderr.String(), command.ProcessState.ExitCode()
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rubyOptions holds the MRI-compatible switches given on the command line,
+// in RUBYOPT or on the script's #! line. Most of them are applied by
+// running a short prelude (see prelude) before the main program.
+type rubyOptions struct {
+	eval       []string
+	requires   []string
+	loadPaths  []string
+	chdir      string
+	extract    bool
+	extractDir string
+
+	loop       bool
+	print      bool
+	split      bool
+	chomp      bool
+	fieldSep   *string
+	recordSep  *string
+	slurp      bool
+	inPlace    *string
+	switches   bool
+	searchPath bool
+	syntaxOnly bool
+	debug      bool
+
+	// warningLevel mirrors -W: -1 when unset, otherwise 0 ($VERBOSE = nil),
+	// 1 ($VERBOSE = false) or 2 ($VERBOSE = true).
+	warningLevel     int
+	externalEncoding string
+	internalEncoding string
+	frozenLiterals   bool
+	disableRubyopt   bool
+
+	showVersion bool
+	version     bool
+	help        bool
+	copyright   bool
+}
+
+func newRubyOptions() *rubyOptions {
+	return &rubyOptions{warningLevel: -1}
+}
+
+// rubyOptionError is reported as `rgo: <message> (RuntimeError)`, the way
+// MRI reports bad switches.
+type rubyOptionError struct {
+	message string
+}
+
+func (e *rubyOptionError) Error() string {
+	return e.message + " (RuntimeError)"
+}
+
+// rubyoptSwitches are the only short switches MRI honours in RUBYOPT.
+const rubyoptSwitches = "dEIKrTUvwW"
+
+// parse consumes leading switches from args and returns the rest, starting
+// at the script name. Switches may be clustered (-ne, -rjson, -pi.bak) and
+// stop at the first non-switch or at `--`. When fromEnv is set only the
+// switches RUBYOPT allows are accepted.
+func (o *rubyOptions) parse(args []string, fromEnv bool) ([]string, error) {
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			return args[1:], nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return args, nil
+		}
+		args = args[1:]
+		if strings.HasPrefix(arg, "--") {
+			if err := o.parseLong(arg, fromEnv); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var err error
+		if args, err = o.parseCluster(arg[1:], args, fromEnv); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// parseCluster handles one short-switch word without its leading dash.
+// Switches taking an argument use the rest of the word or, for -e, -r, -I,
+// -C and -E, the next word when the rest is empty.
+func (o *rubyOptions) parseCluster(cluster string, args []string, fromEnv bool) ([]string, error) {
+	for i := 0; i < len(cluster); i++ {
+		c := cluster[i]
+		if fromEnv && !strings.ContainsRune(rubyoptSwitches, rune(c)) {
+			return nil, &rubyOptionError{message: fmt.Sprintf("invalid switch in RUBYOPT: -%c", c)}
+		}
+		rest := cluster[i+1:]
+		argument := func() (string, error) {
+			if rest != "" {
+				return rest, nil
+			}
+			if len(args) == 0 {
+				return "", &rubyOptionError{message: fmt.Sprintf("no code specified for -%c", c)}
+			}
+			value := args[0]
+			args = args[1:]
+			return value, nil
+		}
+		switch c {
+		case 'a':
+			o.split = true
+		case 'c':
+			o.syntaxOnly = true
+		case 'd':
+			o.debug = true
+		case 'h':
+			o.help = true
+		case 'l':
+			o.chomp = true
+		case 'n':
+			o.loop = true
+		case 'p':
+			o.print = true
+		case 's':
+			o.switches = true
+		case 'S':
+			o.searchPath = true
+		case 'v':
+			if !fromEnv {
+				o.showVersion = true
+			}
+			o.warningLevel = 2
+		case 'w':
+			o.warningLevel = 2
+		case 'y':
+		case 'U':
+			o.internalEncoding = "UTF-8"
+		case 'K':
+			// -K<kcode> selected a source encoding in Ruby 1.8; it is accepted
+			// and ignored like the other legacy switches.
+			if rest != "" {
+				i++
+			}
+		case 'T':
+			for i+1 < len(cluster) && cluster[i+1] >= '0' && cluster[i+1] <= '9' {
+				i++
+			}
+		case 'W':
+			switch {
+			case rest == "" || rest[0] == '2':
+				o.warningLevel = 2
+			case rest[0] == '0':
+				o.warningLevel = 0
+			case rest[0] == '1':
+				o.warningLevel = 1
+			case rest[0] == ':':
+				// -W:category, e.g. -W:no-deprecated, names a warning category.
+				return args, nil
+			default:
+				o.warningLevel = 2
+				continue
+			}
+			if rest != "" {
+				i++
+			}
+		case '0':
+			digits := 0
+			for digits < 3 && i+1+digits < len(cluster) && cluster[i+1+digits] >= '0' && cluster[i+1+digits] <= '7' {
+				digits++
+			}
+			code, _ := strconv.ParseUint("0"+cluster[i+1:i+1+digits], 8, 32)
+			i += digits
+			switch {
+			case digits > 0 && code == 0:
+				// -00 selects paragraph mode.
+				separator := ""
+				o.recordSep, o.slurp = &separator, false
+			case code >= 0o400:
+				o.recordSep, o.slurp = nil, true
+			default:
+				separator := string(rune(code))
+				o.recordSep, o.slurp = &separator, false
+			}
+		case 'e':
+			code, err := argument()
+			if err != nil {
+				return nil, err
+			}
+			o.eval = append(o.eval, code)
+			return args, nil
+		case 'r':
+			feature, err := argument()
+			if err != nil {
+				return nil, err
+			}
+			o.requires = append(o.requires, feature)
+			return args, nil
+		case 'I':
+			dir, err := argument()
+			if err != nil {
+				return nil, err
+			}
+			o.loadPaths = append(o.loadPaths, dir)
+			return args, nil
+		case 'C':
+			dir, err := argument()
+			if err != nil {
+				return nil, err
+			}
+			o.chdir = dir
+			return args, nil
+		case 'E':
+			encodings, err := argument()
+			if err != nil {
+				return nil, err
+			}
+			o.setEncodings(encodings)
+			return args, nil
+		case 'F':
+			separator := rest
+			o.fieldSep = &separator
+			return args, nil
+		case 'i':
+			extension := rest
+			o.inPlace = &extension
+			return args, nil
+		case 'x':
+			o.extract = true
+			o.extractDir = rest
+			return args, nil
+		default:
+			return nil, &rubyOptionError{message: fmt.Sprintf("invalid option -%c  (-h will show valid options)", c)}
+		}
+	}
+	return args, nil
+}
+
+// parseLong handles --name and --name=value switches.
+func (o *rubyOptions) parseLong(arg string, fromEnv bool) error {
+	name, value, hasValue := strings.Cut(arg, "=")
+	if fromEnv {
+		switch name {
+		case "--debug", "--disable", "--enable", "--encoding", "--external-encoding", "--internal-encoding", "--verbose", "--backtrace-limit":
+		default:
+			if !strings.HasPrefix(name, "--disable-") && !strings.HasPrefix(name, "--enable-") {
+				return &rubyOptionError{message: "invalid switch in RUBYOPT: " + name}
+			}
+		}
+	}
+	switch {
+	case name == "--version":
+		o.version = true
+	case name == "--verbose":
+		o.warningLevel = 2
+	case name == "--help":
+		o.help = true
+	case name == "--copyright":
+		o.copyright = true
+	case name == "--debug":
+		o.debug = true
+	case name == "--yydebug":
+	case name == "--encoding" && hasValue:
+		o.setEncodings(value)
+	case name == "--external-encoding" && hasValue:
+		o.externalEncoding = value
+	case name == "--internal-encoding" && hasValue:
+		o.internalEncoding = value
+	case (name == "--enable" || name == "--disable") && hasValue:
+		for _, feature := range strings.Split(value, ",") {
+			o.setFeature(feature, name == "--enable")
+		}
+	case strings.HasPrefix(name, "--enable-") && !hasValue:
+		o.setFeature(strings.TrimPrefix(name, "--enable-"), true)
+	case strings.HasPrefix(name, "--disable-") && !hasValue:
+		o.setFeature(strings.TrimPrefix(name, "--disable-"), false)
+	case isIgnoredJITOption(name):
+		// JIT and parser selection switches exist for MRI compatibility only.
+	case name == "--sandbox" || strings.HasPrefix(name, "--sandbox-"):
+		return parseSandboxOption(arg)
+	case name == "--dump" && hasValue:
+		for _, target := range strings.Split(value, ",") {
+			if target != "insns" && target != "parsetree" {
+				return fmt.Errorf("invalid option --dump=%s (expected insns or parsetree)", target)
+			}
+			dumpTargets = append(dumpTargets, target)
+		}
+	case name == "--backtrace-limit" && hasValue:
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			_ = os.Setenv("RGO_BACKTRACE_LIMIT", value)
+		}
+	default:
+		return &rubyOptionError{message: "invalid option " + name + "  (-h will show valid options)"}
+	}
+	return nil
+}
+
+func isIgnoredJITOption(name string) bool {
+	for _, prefix := range []string{"--jit", "--yjit", "--rjit", "--mjit", "--parser", "--crash-report"} {
+		if name == prefix || strings.HasPrefix(name, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// setFeature applies --enable/--disable. gems, did_you_mean and the other
+// MRI features RGo does not load are accepted and ignored.
+func (o *rubyOptions) setFeature(feature string, enable bool) {
+	switch strings.ReplaceAll(strings.TrimSpace(feature), "_", "-") {
+	case "frozen-string-literal":
+		o.frozenLiterals = enable
+	case "rubyopt":
+		o.disableRubyopt = !enable
+	case "all":
+		if !enable {
+			o.disableRubyopt = true
+			o.frozenLiterals = false
+		}
+	case "gems", "rubygems", "did-you-mean", "error-highlight", "syntax-suggest", "jit", "yjit", "rjit", "mjit", "":
+	default:
+		flag := "--disable"
+		if enable {
+			flag = "--enable"
+		}
+		fmt.Fprintf(os.Stderr, "rgo: warning: unknown argument for %s: '%s'\n", flag, feature)
+	}
+}
+
+// setEncodings handles -E ext[:int] and --encoding.
+func (o *rubyOptions) setEncodings(value string) {
+	external, internal, hasInternal := strings.Cut(value, ":")
+	if external != "" {
+		o.externalEncoding = external
+	}
+	if hasInternal && internal != "" {
+		o.internalEncoding = internal
+	}
+}
+
+// parseRubyopt prepends the switches in RUBYOPT. Words without a dash are
+// treated as switches, so RUBYOPT=rjson works like RUBYOPT=-rjson.
+func (o *rubyOptions) parseRubyopt(value string) error {
+	words := strings.Fields(value)
+	for i, word := range words {
+		if !strings.HasPrefix(word, "-") {
+			words[i] = "-" + word
+		}
+	}
+	rest, err := o.parse(words, true)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return &rubyOptionError{message: "invalid switch in RUBYOPT: " + rest[0]}
+	}
+	return nil
+}
+
+// parseShebang applies switches from a `#!...ruby -w` first line, which MRI
+// honours so that scripts can carry -n, -p, -l and friends with them.
+func (o *rubyOptions) parseShebang(source string) error {
+	line, _, _ := strings.Cut(source, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return nil
+	}
+	fields := strings.Fields(strings.TrimSuffix(line[2:], "\r"))
+	for i, field := range fields {
+		if strings.Contains(filepath.Base(field), "ruby") || filepath.Base(field) == "rgo" {
+			_, err := o.parse(fields[i+1:], false)
+			return err
+		}
+	}
+	return nil
+}
+
+// scriptSwitch is a -name or -name=value argument taken by -s.
+type scriptSwitch struct {
+	name  string
+	value string
+	flag  bool
+}
+
+// takeScriptSwitches implements -s: leading -name and -name=value arguments
+// after the script name become $name globals and are removed from ARGV.
+func takeScriptSwitches(argv []string) ([]string, []scriptSwitch) {
+	var switches []scriptSwitch
+	for len(argv) > 0 && strings.HasPrefix(argv[0], "-") && argv[0] != "-" {
+		arg := argv[0]
+		argv = argv[1:]
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(arg[1:], "=")
+		switches = append(switches, scriptSwitch{name: strings.ReplaceAll(name, "-", "_"), value: value, flag: !hasValue})
+	}
+	return argv, switches
+}
+
+// prelude returns Ruby source that applies the switches which map onto
+// global state. It runs before the main program.
+func (o *rubyOptions) prelude(switches []scriptSwitch) string {
+	var b strings.Builder
+	if o.debug {
+		b.WriteString("$DEBUG = true\n")
+	}
+	switch o.warningLevel {
+	case 0:
+		b.WriteString("$VERBOSE = nil\n")
+	case 1:
+		b.WriteString("$VERBOSE = false\n")
+	case 2:
+		b.WriteString("$VERBOSE = true\n")
+	}
+	if o.externalEncoding != "" {
+		b.WriteString("Encoding.default_external = " + rubyQuote(o.externalEncoding) + "\n")
+	}
+	if o.internalEncoding != "" {
+		b.WriteString("Encoding.default_internal = " + rubyQuote(o.internalEncoding) + "\n")
+	}
+	switch {
+	case o.slurp:
+		b.WriteString("$/ = nil\n")
+	case o.recordSep != nil:
+		b.WriteString("$/ = " + rubyQuote(*o.recordSep) + "\n")
+	}
+	if o.chomp {
+		b.WriteString("$\\ = $/\n")
+	}
+	if o.fieldSep != nil {
+		b.WriteString("$; = Regexp.new(" + rubyQuote(*o.fieldSep) + ")\n")
+	}
+	if o.inPlace != nil {
+		b.WriteString("ARGF.inplace_mode = " + rubyQuote(*o.inPlace) + "\n")
+	}
+	for _, sw := range switches {
+		if !validGlobalName(sw.name) {
+			continue
+		}
+		if sw.flag {
+			b.WriteString("$" + sw.name + " = true\n")
+		} else {
+			b.WriteString("$" + sw.name + " = " + rubyQuote(sw.value) + "\n")
+		}
+	}
+	for _, feature := range o.requires {
+		b.WriteString("require " + rubyQuote(resolveRequiredFeature(feature)) + "\n")
+	}
+	return b.String()
+}
+
+// resolveRequiredFeature lets -r name a file relative to the working
+// directory as well as a feature on the load path.
+func resolveRequiredFeature(feature string) string {
+	if filepath.IsAbs(feature) {
+		return feature
+	}
+	candidates := []string{feature}
+	if !strings.HasSuffix(feature, ".rb") {
+		candidates = append(candidates, feature+".rb")
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			if abs, err := filepath.Abs(candidate); err == nil {
+				return abs
+			}
+		}
+	}
+	return feature
+}
+
+// rubyQuote renders value as a double-quoted Ruby string literal.
+func rubyQuote(value string) string {
+	return strings.ReplaceAll(strconv.Quote(value), "#", "\\#")
+}
+
+func validGlobalName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRubyOptionsParse(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		args []string
+		want func(o *rubyOptions)
+		rest []string
+	}{
+		{[]string{"-ne", "print", "in.txt"}, func(o *rubyOptions) {
+			o.loop, o.eval = true, []string{"print"}
+		}, []string{"in.txt"}},
+		{[]string{"-lane", "puts $F[0]"}, func(o *rubyOptions) {
+			o.chomp, o.split, o.loop, o.eval = true, true, true, []string{"puts $F[0]"}
+		}, []string{}},
+		{[]string{"-e", "a", "-e", "b"}, func(o *rubyOptions) {
+			o.eval = []string{"a", "b"}
+		}, []string{}},
+		{[]string{"-F:", "-a"}, func(o *rubyOptions) {
+			o.fieldSep, o.split = str(":"), true
+		}, []string{}},
+		{[]string{"-naF,"}, func(o *rubyOptions) {
+			o.loop, o.split, o.fieldSep = true, true, str(",")
+		}, []string{}},
+		{[]string{"-0"}, func(o *rubyOptions) {
+			o.recordSep = str("\x00")
+		}, []string{}},
+		{[]string{"-00"}, func(o *rubyOptions) {
+			o.recordSep = str("")
+		}, []string{}},
+		{[]string{"-0777"}, func(o *rubyOptions) {
+			o.slurp = true
+		}, []string{}},
+		{[]string{"-012n"}, func(o *rubyOptions) {
+			o.recordSep, o.loop = str("\n"), true
+		}, []string{}},
+		{[]string{"-i.bak", "-p", "f.txt"}, func(o *rubyOptions) {
+			o.inPlace, o.print = str(".bak"), true
+		}, []string{"f.txt"}},
+		{[]string{"-pi", "f.txt"}, func(o *rubyOptions) {
+			o.print, o.inPlace = true, str("")
+		}, []string{"f.txt"}},
+		{[]string{"-Ilib", "-I", "vendor", "s.rb"}, func(o *rubyOptions) {
+			o.loadPaths = []string{"lib", "vendor"}
+		}, []string{"s.rb"}},
+		{[]string{"-rjson", "-r", "set"}, func(o *rubyOptions) {
+			o.requires = []string{"json", "set"}
+		}, []string{}},
+		{[]string{"-x", "s.rb"}, func(o *rubyOptions) {
+			o.extract = true
+		}, []string{"s.rb"}},
+		{[]string{"-xsub", "s.rb"}, func(o *rubyOptions) {
+			o.extract, o.extractDir = true, "sub"
+		}, []string{"s.rb"}},
+		{[]string{"-W0"}, func(o *rubyOptions) { o.warningLevel = 0 }, []string{}},
+		{[]string{"-W1"}, func(o *rubyOptions) { o.warningLevel = 1 }, []string{}},
+		{[]string{"-W2"}, func(o *rubyOptions) { o.warningLevel = 2 }, []string{}},
+		{[]string{"-W"}, func(o *rubyOptions) { o.warningLevel = 2 }, []string{}},
+		{[]string{"-w"}, func(o *rubyOptions) { o.warningLevel = 2 }, []string{}},
+		{[]string{"-W0c"}, func(o *rubyOptions) {
+			o.warningLevel, o.syntaxOnly = 0, true
+		}, []string{}},
+		{[]string{"-W:no-deprecated"}, func(o *rubyOptions) {}, []string{}},
+		{[]string{"-c", "--", "-n"}, func(o *rubyOptions) {
+			o.syntaxOnly = true
+		}, []string{"-n"}},
+		{[]string{"--", "--", "x"}, func(o *rubyOptions) {}, []string{"--", "x"}},
+		{[]string{"s.rb", "-n"}, func(o *rubyOptions) {}, []string{"s.rb", "-n"}},
+		{[]string{"-", "-n"}, func(o *rubyOptions) {}, []string{"-", "-n"}},
+		{[]string{"--enable=frozen-string-literal", "--verbose"}, func(o *rubyOptions) {
+			o.frozenLiterals, o.warningLevel = true, 2
+		}, []string{}},
+	}
+	for _, tt := range tests {
+		got := newRubyOptions()
+		rest, err := got.parse(tt.args, false)
+		if err != nil {
+			t.Errorf("parse(%q): %v", tt.args, err)
+			continue
+		}
+		want := newRubyOptions()
+		tt.want(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parse(%q) = %+v, want %+v", tt.args, *got, *want)
+		}
+		if len(rest) != len(tt.rest) || strings.Join(rest, "\x00") != strings.Join(tt.rest, "\x00") {
+			t.Errorf("parse(%q) rest = %q, want %q", tt.args, rest, tt.rest)
+		}
+	}
+}
+
+func TestRubyOptionsParseErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		fromEnv bool
+		message string
+	}{
+		{[]string{"-e"}, false, "no code specified for -e"},
+		{[]string{"-nr"}, false, "no code specified for -r"},
+		{[]string{"-I"}, false, "no code specified for -I"},
+		{[]string{"-j"}, false, "invalid option -j"},
+		{[]string{"--frobnicate"}, false, "invalid option --frobnicate"},
+		{[]string{"-n"}, true, "invalid switch in RUBYOPT: -n"},
+		{[]string{"--version"}, true, "invalid switch in RUBYOPT: --version"},
+	}
+	for _, tt := range tests {
+		_, err := newRubyOptions().parse(tt.args, tt.fromEnv)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("parse(%q, %v) error = %v, want %q", tt.args, tt.fromEnv, err, tt.message)
+		}
+	}
+}
+
+func TestSyntaxCheckRejectsIncompleteExpression(t *testing.T) {
+	stdout, stderr, status := runRgo(t, "", "-c", "-e", "1 +")
+	if status != 1 || stdout != "" || !strings.Contains(stderr, "unexpected end-of-input") {
+		t.Errorf("rgo -c -e '1 +': stdout %q, stderr %q, status %d", stdout, stderr, status)
+	}
+	stdout, _, status = runRgo(t, "", "-c", "-e", "1 +\n2")
+	if status != 0 || stdout != "Syntax OK\n" {
+		t.Errorf("rgo -c -e '1 +\\n2': stdout %q, status %d", stdout, status)
+	}
+}
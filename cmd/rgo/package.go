@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/packager"
+	"github.com/GoLangDream/rgo/pkg/vm"
+)
+
+// runPackageCommand implements `rgo package <file.rb> [-o output] [-I dir]...`:
+// the script, the .rb files beside it, vendored gems and the -I directories
+// are appended to a copy of this executable, which then runs the script
+// wherever it is copied. The output defaults to the script's name without
+// its extension.
+func runPackageCommand(args []string) {
+	opts := packager.Options{}
+	output := ""
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		switch {
+		case (arg == "-o" || arg == "-I") && index+1 < len(args):
+			if arg == "-o" {
+				output = args[index+1]
+			} else {
+				opts.LibDirs = append(opts.LibDirs, args[index+1])
+			}
+			index++
+		case strings.HasPrefix(arg, "-o") && len(arg) > 2:
+			output = arg[2:]
+		case strings.HasPrefix(arg, "-I") && len(arg) > 2:
+			opts.LibDirs = append(opts.LibDirs, arg[2:])
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(os.Stderr, "rgo package: unknown option %s\n", arg)
+			os.Exit(2)
+		case opts.Entry == "":
+			opts.Entry = arg
+		default:
+			fmt.Fprintf(os.Stderr, "rgo package: more than one script given\n")
+			os.Exit(2)
+		}
+	}
+	if opts.Entry == "" {
+		fmt.Fprintf(os.Stderr, "Usage: rgo package <file.rb> [-o output] [-I dir]...\n")
+		os.Exit(2)
+	}
+	if output == "" {
+		output = strings.TrimSuffix(opts.Entry, filepath.Ext(opts.Entry))
+		if output == opts.Entry {
+			output += ".bin"
+		}
+	}
+	runtimePath, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo package: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Runtime = runtimePath
+
+	// Build into a temporary file, so that a failure leaves no half-written
+	// executable and the output may be the running executable itself.
+	file, err := os.CreateTemp(filepath.Dir(output), ".rgo-package-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo package: %v\n", err)
+		os.Exit(1)
+	}
+	err = packager.Build(file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0o755)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), output)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		fmt.Fprintf(os.Stderr, "rgo package: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runPackagedApplication runs the application appended to this executable
+// by `rgo package`, passing it the whole command line. It returns false for
+// a plain rgo executable.
+func runPackagedApplication() bool {
+	executable, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	pkg, err := packager.Open(executable)
+	if errors.Is(err, packager.ErrNotPackaged) {
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo: %v\n", err)
+		os.Exit(1)
+	}
+	defer pkg.Close()
+
+	source, err := fs.ReadFile(pkg.Files, pkg.Manifest.Entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rgo: %s: %v\n", executable, err)
+		os.Exit(1)
+	}
+	// The packaged files appear under the executable's own path, where
+	// nothing on disk can shadow them.
+	core.Init()
+	startAllocationProfile()
+	vm.EmbedFiles(executable, pkg.Files)
+	if len(pkg.Manifest.LoadPaths) > 0 {
+		paths := make([]string, 0, len(pkg.Manifest.LoadPaths))
+		for _, path := range pkg.Manifest.LoadPaths {
+			paths = append(paths, filepath.Join(executable, filepath.FromSlash(path)))
+		}
+		prependRubyLib(paths...)
+	}
+	entry := filepath.Join(executable, filepath.FromSlash(pkg.Manifest.Entry))
+	text := strings.TrimPrefix(string(source), "\ufeff")
+	runRubySourceWithEncodingAndPreloadMode(text, entry, os.Args[1:], core.SourceEncoding(text), "", "", false)
+	return true
+}
@@ -0,0 +1,235 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// BundleExtension names linked programs written by `rgo link`.
+const BundleExtension = ".rgob"
+
+var bundleMagic = []byte("RGOB")
+
+// Bundle is a program linked from an entry script and the files it loads
+// with require_relative. The files share one constant pool, and a bundle
+// file stores every string once, so a library that many files use costs the
+// same as one that a single file uses.
+type Bundle struct {
+	// Root is the directory of the entry script. Source paths recorded in
+	// the bytecode are under it until Relocate moves them.
+	Root      string
+	Files     []*BundleFile // Files[0] is the entry script
+	Constants []*object.EmeraldValue
+
+	symbols map[string]int
+	pooled  map[*object.EmeraldValue]int
+}
+
+// BundleFile is one source file of a Bundle. Its Bytecode.Constants is
+// made of entries of the bundle's pool, listed in ConstantIndexes.
+type BundleFile struct {
+	Path                 string // slash-separated, relative to Root
+	Encoding             string
+	FreezeStringLiterals bool
+	ChillStringLiterals  bool
+	Bytecode             *Bytecode
+	ConstantIndexes      []int
+}
+
+// NewBundle returns an empty bundle rooted at root.
+func NewBundle(root string) *Bundle {
+	return &Bundle{Root: root}
+}
+
+// AbsolutePath returns where f lives: Root joined with its Path.
+func (b *Bundle) AbsolutePath(f *BundleFile) string {
+	return filepath.Join(b.Root, filepath.FromSlash(f.Path))
+}
+
+// Add moves the constants of file's bytecode into the pool and appends the
+// file. Symbols of the same name and encoding become one constant, since a
+// symbol constant is never copied or mutated by the code that loads it.
+func (b *Bundle) Add(file *BundleFile) {
+	if b.pooled == nil {
+		b.pooled = make(map[*object.EmeraldValue]int)
+		b.symbols = make(map[string]int)
+	}
+	constants := file.Bytecode.Constants
+	file.ConstantIndexes = make([]int, len(constants))
+	for i, value := range constants {
+		index := b.intern(value, file.Encoding)
+		file.ConstantIndexes[i] = index
+		constants[i] = b.Constants[index]
+	}
+	b.Files = append(b.Files, file)
+}
+
+func (b *Bundle) intern(value *object.EmeraldValue, encoding string) int {
+	if index, ok := b.pooled[value]; ok {
+		return index
+	}
+	key := ""
+	if value != nil && value.Type == object.ValueSymbol {
+		if name, ok := value.Data.(string); ok {
+			// The loading file's encoding is applied to symbols without
+			// their own, so it is part of the identity.
+			key = name + "\x00" + value.Encoding + "\x00" + encoding
+			if index, ok := b.symbols[key]; ok {
+				b.pooled[value] = index
+				return index
+			}
+		}
+	}
+	index := len(b.Constants)
+	b.Constants = append(b.Constants, value)
+	b.pooled[value] = index
+	if key != "" {
+		b.symbols[key] = index
+	}
+	return index
+}
+
+// Relocate moves the bundle to root. Source paths recorded for backtraces,
+// and the __FILE__ and __dir__ strings the compiler stored, are rewritten
+// from the old root to the new one; the entry script takes entryPath
+// instead, the way a script's own path is the one it was run as.
+func (b *Bundle) Relocate(root, entryPath string) {
+	paths := make(map[string]string)
+	absolutePaths := make(map[string]string)
+	for i, file := range b.Files {
+		from, to := b.AbsolutePath(file), filepath.Join(root, filepath.FromSlash(file.Path))
+		if i == 0 && entryPath != "" {
+			to = entryPath
+		}
+		absolute := to
+		if abs, err := filepath.Abs(to); err == nil {
+			absolute = abs
+		}
+		paths[from], absolutePaths[from] = to, absolute
+		paths[filepath.Dir(from)] = filepath.Dir(absolute)
+	}
+	rewrite := func(paths map[string]string, s string) string {
+		if to, ok := paths[s]; ok && s != "" {
+			return to
+		}
+		return s
+	}
+	values := make(map[*object.EmeraldValue]bool)
+	functions := make(map[*object.Function]bool)
+	var walk func(value *object.EmeraldValue)
+	walk = func(value *object.EmeraldValue) {
+		if value == nil || values[value] {
+			return
+		}
+		values[value] = true
+		switch data := value.Data.(type) {
+		case string:
+			if value.Type == object.ValueString {
+				value.Data = rewrite(paths, data)
+			}
+		case []*object.EmeraldValue:
+			for _, element := range data {
+				walk(element)
+			}
+		case *object.Function:
+			if data == nil || functions[data] {
+				return
+			}
+			functions[data] = true
+			data.SourcePath = rewrite(paths, data.SourcePath)
+			data.SourceAbsolutePath = rewrite(absolutePaths, data.SourceAbsolutePath)
+			for _, list := range [][]*object.EmeraldValue{data.Constants, data.ParamDefaults} {
+				for _, element := range list {
+					walk(element)
+				}
+			}
+			for _, param := range data.KeywordParams {
+				walk(param.Default)
+			}
+		}
+	}
+	for _, value := range b.Constants {
+		walk(value)
+	}
+	b.Root = root
+}
+
+// MarshalBundle encodes b. Like cached bytecode, a bundle only runs on the
+// RGo build that linked it.
+func MarshalBundle(b *Bundle) ([]byte, error) {
+	w := &bytecodeWriter{
+		values:    make(map[*object.EmeraldValue]int),
+		functions: make(map[*object.Function]int),
+		interned:  make(map[string]int),
+	}
+	w.buf.Write(bundleMagic)
+	w.uint(BytecodeFormatVersion)
+	w.string(Version())
+	w.string(b.Root)
+	w.valueList(b.Constants)
+	w.uint(uint64(len(b.Files)))
+	for _, file := range b.Files {
+		w.string(file.Path)
+		w.string(file.Encoding)
+		w.bool(file.FreezeStringLiterals)
+		w.bool(file.ChillStringLiterals)
+		w.ints(file.ConstantIndexes)
+		bc := file.Bytecode
+		w.bytes(bc.Instructions)
+		w.intMap(bc.LineMap)
+		w.int(int64(bc.NumLocals))
+		w.nameMap(bc.GlobalNames)
+		w.nameMap(bc.LocalNames)
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// UnmarshalBundle decodes a bundle written by MarshalBundle. It returns
+// ErrStaleBytecode for a bundle linked by another RGo build.
+func UnmarshalBundle(data []byte) (*Bundle, error) {
+	if !bytes.HasPrefix(data, bundleMagic) {
+		return nil, fmt.Errorf("not an RGo bundle")
+	}
+	r := &bytecodeReader{data: data[len(bundleMagic):], interning: true}
+	if r.uint() != BytecodeFormatVersion || r.string() != Version() {
+		return nil, ErrStaleBytecode
+	}
+	b := &Bundle{Root: r.string()}
+	b.Constants = r.valueList()
+	n := r.length()
+	for i := 0; i < n && r.err == nil; i++ {
+		file := &BundleFile{Path: r.string(), Encoding: r.string(), FreezeStringLiterals: r.bool(), ChillStringLiterals: r.bool()}
+		file.ConstantIndexes = r.ints()
+		bc := &Bytecode{Constants: make([]*object.EmeraldValue, len(file.ConstantIndexes))}
+		for j, index := range file.ConstantIndexes {
+			if index < 0 || index >= len(b.Constants) {
+				r.fail("bad constant index %d", index)
+				break
+			}
+			bc.Constants[j] = b.Constants[index]
+		}
+		bc.Instructions = r.bytes()
+		bc.LineMap = r.intMap()
+		bc.NumLocals = int(r.int())
+		bc.GlobalNames = r.nameMap()
+		bc.LocalNames = r.nameMap()
+		file.Bytecode = bc
+		b.Files = append(b.Files, file)
+	}
+	if r.err == nil && len(r.data) != 0 {
+		r.fail("trailing data")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(b.Files) == 0 {
+		return nil, fmt.Errorf("corrupt bytecode: bundle has no files")
+	}
+	return b, nil
+}
@@ -0,0 +1,57 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMarshalBundleSharesConstantsAndStrings(t *testing.T) {
+	bundle := NewBundle("/app")
+	for _, source := range []string{
+		`def first = [:shared, "a long shared string literal"]`,
+		`def second = [:shared, "a long shared string literal"]`,
+	} {
+		bundle.Add(&BundleFile{Path: "file.rb", Encoding: "UTF-8", Bytecode: compile(t, source)})
+	}
+	symbols := 0
+	for _, constant := range bundle.Constants {
+		if constant != nil && constant.Data == "shared" {
+			symbols++
+		}
+	}
+	if symbols != 1 {
+		t.Fatalf("pool holds %d :shared symbols, want 1", symbols)
+	}
+
+	data, err := MarshalBundle(bundle)
+	if err != nil {
+		t.Fatalf("MarshalBundle: %v", err)
+	}
+	if n := bytes.Count(data, []byte("a long shared string literal")); n != 1 {
+		t.Fatalf("string stored %d times, want 1", n)
+	}
+	decoded, err := UnmarshalBundle(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBundle: %v", err)
+	}
+	if len(decoded.Files) != 2 || decoded.Root != "/app" {
+		t.Fatalf("decoded %d files rooted at %q", len(decoded.Files), decoded.Root)
+	}
+	for _, file := range decoded.Files {
+		for i, index := range file.ConstantIndexes {
+			if file.Bytecode.Constants[i] != decoded.Constants[index] {
+				t.Fatalf("%s constant %d is not pool entry %d", file.Path, i, index)
+			}
+		}
+	}
+	again, err := MarshalBundle(decoded)
+	if err != nil {
+		t.Fatalf("MarshalBundle after decode: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Fatalf("round trip changed the encoding")
+	}
+	if _, err := UnmarshalBundle(data[:len(data)-2]); err == nil {
+		t.Fatalf("truncated bundle decoded without error")
+	}
+}
@@ -0,0 +1,119 @@
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// BytecodeCacheExtension names serialized bytecode files in the cache
+// directory.
+const BytecodeCacheExtension = ".rgoc"
+
+// BytecodeCacheDir returns the directory holding .rgoc files, or "" when the
+// cache is disabled. RGO_BYTECODE_CACHE_DIR overrides the location the same
+// way RGO_AOT_CACHE_DIR does for AOT artifacts; RGO_DISABLE_BYTECODE_CACHE
+// turns the cache off. The default lives in the user's cache directory, not
+// a shared temporary one, because cached bytecode is run without checking
+// who wrote it.
+func BytecodeCacheDir() string {
+	if os.Getenv("RGO_DISABLE_BYTECODE_CACHE") != "" {
+		return ""
+	}
+	if path := strings.TrimSpace(os.Getenv("RGO_BYTECODE_CACHE_DIR")); path != "" {
+		return path
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rgo", "bytecode")
+}
+
+// ErrUntrustedCacheDir is returned for a cache directory that another user
+// owns or that others can write: anyone who can plant a .rgoc file there can
+// make this process run their code.
+var ErrUntrustedCacheDir = errors.New("bytecode cache directory is owned by another user or writable by others")
+
+// checkCacheDir reports whether dir is a directory only the current user
+// can write.
+func checkCacheDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || info.Mode().Perm()&0o022 != 0 {
+		return ErrUntrustedCacheDir
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return ErrUntrustedCacheDir
+	}
+	return nil
+}
+
+// CachedBytecodePath maps a source file to its .rgoc file. The name only
+// depends on the file's absolute path, so editing the file rewrites one entry
+// instead of accumulating a new one per revision; freshness is checked against
+// the digest stored inside the file.
+func CachedBytecodePath(dir, sourcePath string) string {
+	name := sha256.Sum256([]byte(sourcePath))
+	return filepath.Join(dir, hex.EncodeToString(name[:16])+BytecodeCacheExtension)
+}
+
+// LoadCachedBytecode reads the .rgoc file for sourcePath. It reports a miss
+// for absent, stale or unreadable entries and for an untrusted directory.
+func LoadCachedBytecode(dir, sourcePath string, digest [sha256.Size]byte) (*Bytecode, bool) {
+	if checkCacheDir(dir) != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(CachedBytecodePath(dir, sourcePath))
+	if err != nil {
+		return nil, false
+	}
+	bc, err := UnmarshalBytecode(data, digest)
+	if err != nil {
+		return nil, false
+	}
+	return bc, true
+}
+
+// StoreCachedBytecode writes bc for sourcePath. The file is written under a
+// temporary name and renamed into place so concurrent processes never observe
+// a partial entry. Bytecode that cannot be serialized is silently skipped,
+// and an untrusted directory is refused with ErrUntrustedCacheDir.
+func StoreCachedBytecode(dir, sourcePath string, digest [sha256.Size]byte, bc *Bytecode) error {
+	data, err := MarshalBytecode(bc, digest)
+	if errors.Is(err, ErrUncacheableBytecode) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := checkCacheDir(dir); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".rgoc-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), CachedBytecodePath(dir, sourcePath))
+}
@@ -0,0 +1,51 @@
+package compiler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBytecodeCacheRefusesSharedDirectory(t *testing.T) {
+	bc := compile(t, "puts 1")
+	digest := SourceDigest("puts 1", "main.rb")
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	if err := StoreCachedBytecode(dir, "main.rb", digest, bc); err != nil {
+		t.Fatalf("StoreCachedBytecode: %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("cache directory mode = %v, %v; want 0700", info.Mode().Perm(), err)
+	}
+	if entry, err := os.Stat(CachedBytecodePath(dir, "main.rb")); err != nil || entry.Mode().Perm() != 0o600 {
+		t.Fatalf("cache entry = %v, %v; want mode 0600", entry, err)
+	}
+	if _, ok := LoadCachedBytecode(dir, "main.rb", digest); !ok {
+		t.Fatalf("LoadCachedBytecode missed a fresh entry")
+	}
+
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LoadCachedBytecode(dir, "main.rb", digest); ok {
+		t.Fatalf("LoadCachedBytecode used a world-writable directory")
+	}
+	if err := StoreCachedBytecode(dir, "main.rb", digest, bc); !errors.Is(err, ErrUntrustedCacheDir) {
+		t.Fatalf("StoreCachedBytecode into a world-writable directory = %v", err)
+	}
+}
+
+func TestBytecodeCacheDirDefaultsToUserCache(t *testing.T) {
+	t.Setenv("RGO_DISABLE_BYTECODE_CACHE", "")
+	t.Setenv("RGO_BYTECODE_CACHE_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	base, err := os.UserCacheDir()
+	if err != nil {
+		t.Skipf("no user cache directory: %v", err)
+	}
+	if got, want := BytecodeCacheDir(), filepath.Join(base, "rgo", "bytecode"); got != want {
+		t.Fatalf("BytecodeCacheDir() = %q, want %q", got, want)
+	}
+}
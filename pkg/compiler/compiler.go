@@ -251,6 +251,38 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	return obj, ok
 }
 
+// Lookup returns the symbol name has in this table itself. Unlike Resolve it
+// never looks at outer tables, so it does not capture free variables.
+func (s *SymbolTable) Lookup(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	return symbol, ok
+}
+
+// LocalNames returns the Ruby local variables visible in this table without
+// looking at outer tables: its own locals and the ones it captured as free
+// variables, sorted by name. Compiler temporaries are left out.
+func (s *SymbolTable) LocalNames() []string {
+	names := []string{}
+	for name, symbol := range s.store {
+		if symbol.Scope != ScopeLocal && symbol.Scope != ScopeFree {
+			continue
+		}
+		if strings.HasPrefix(name, "__rgo") || !isValidLocalNameLikeRuby(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ScopeLocals pairs a node that opened a local variable scope (a def, block,
+// lambda or class body) with the symbol table its body compiled against.
+type ScopeLocals struct {
+	Node  ast.Node
+	Table *SymbolTable
+}
+
 type EmittedInstruction struct {
 	Opcode   Opcode
 	Position int
@@ -284,6 +316,52 @@ type Compiler struct {
 	voidContext        bool
 	evalTopLevelReturn bool
 	sourceEncoding     string
+	warned             bool
+	errorLine          int
+	recordScopes       bool
+	compilingNodes     []ast.Node
+	scopeOwners        []ast.Node
+	recordedScopes     []ScopeLocals
+	coverage           *core.CoverageSource
+	coverageLine       int
+	coverageScope      int
+}
+
+// warn prints a compile-time warning. Bytecode from a compilation that
+// warned is not written to the bytecode cache, so the warning repeats on
+// every load like it does in MRI.
+func (c *Compiler) warn(message string) {
+	c.warned = true
+	fmt.Fprintln(os.Stderr, "warning: "+message)
+}
+
+// Warned reports whether compilation printed a warning.
+func (c *Compiler) Warned() bool {
+	return c.warned
+}
+
+// ErrorLine returns the source line of the innermost node whose compilation
+// failed, or 0 when Compile has not failed or the line is unknown.
+func (c *Compiler) ErrorLine() int {
+	return c.errorLine
+}
+
+// RecordScopes makes the compiler remember every local variable scope it
+// compiles, for tools that want to know which locals are visible where.
+func (c *Compiler) RecordScopes() {
+	c.recordScopes = true
+}
+
+// Scopes returns the scopes recorded since RecordScopes, innermost first
+// within each nesting.
+func (c *Compiler) Scopes() []ScopeLocals {
+	return c.recordedScopes
+}
+
+// SymbolTable returns the table of the scope being compiled; after Compile
+// returns successfully that is the top-level table.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
 }
 
 func New() *Compiler {
@@ -441,20 +519,33 @@ func (c *Compiler) splatValue(splat *ast.SplatExpression) ast.Expression {
 	return splat.Value
 }
 
-func (c *Compiler) Compile(node interface{}) error {
+func (c *Compiler) Compile(node interface{}) (err error) {
 	prevLine := c.currentLine
 	if line := compileNodeLine(node); line > 0 {
 		c.currentLine = line
 	}
+	if astNode, ok := node.(ast.Node); ok && c.recordScopes {
+		c.compilingNodes = append(c.compilingNodes, astNode)
+		defer func() { c.compilingNodes = c.compilingNodes[:len(c.compilingNodes)-1] }()
+	}
+	if astNode, ok := node.(ast.Node); ok && c.coverage != nil {
+		if restore := c.coverStatement(astNode); restore != nil {
+			defer restore()
+		}
+	}
 	defer func() {
+		if err != nil && c.errorLine == 0 {
+			c.errorLine = c.currentLine
+		}
 		c.currentLine = prevLine
 	}()
 
 	switch node := node.(type) {
 	case *ast.Program:
-		for i, s := range node.Statements {
+		statements := hoistBeginBlocks(node.Statements)
+		for i, s := range statements {
 			previousVoidContext := c.voidContext
-			if expressionStatement, ok := s.(*ast.ExpressionStatement); ok && i < len(node.Statements)-1 {
+			if expressionStatement, ok := s.(*ast.ExpressionStatement); ok && i < len(statements)-1 {
 				_, c.voidContext = expressionStatement.Expression.(*ast.DefinedExpression)
 			}
 			if err := c.Compile(s); err != nil {
@@ -468,6 +559,9 @@ func (c *Compiler) Compile(node interface{}) error {
 			return err
 		}
 		c.Emit(OpPop)
+	case *ast.ErrorNode:
+		// Only a tree the parser reported errors for has these.
+		return fmt.Errorf("%s", node.Message)
 	case *ast.IntegerLiteral:
 		value := &object.EmeraldValue{
 			Type:  object.ValueInteger,
@@ -480,16 +574,19 @@ func (c *Compiler) Compile(node interface{}) error {
 			return err
 		}
 	case *ast.TernaryExpression:
+		thenCounter, elseCounter := c.coverIf(node, node.Loc, false, node.Consequent, node.Alternative, coverageRange(node.Alternative, node.Loc))
 		if err := c.compileCondition(node.Condition); err != nil {
 			return err
 		}
 		jumpNotTruthyPos := c.emit(OpJumpNotTruthy, 9999)
+		c.emitCoverage(thenCounter)
 		if err := c.Compile(node.Consequent); err != nil {
 			return err
 		}
 		jumpPos := c.emit(OpJump, 9999)
 		afterConsequent := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequent)
+		c.emitCoverage(elseCounter)
 		if err := c.Compile(node.Alternative); err != nil {
 			return err
 		}
@@ -875,6 +972,17 @@ func (c *Compiler) Compile(node interface{}) error {
 			c.Emit(OpBitNot)
 		}
 	case *ast.IfExpression:
+		// An elsif is an if nested in the else part of the previous
+		// branch, running from its condition to the end of the chain.
+		elsifLoc := func(i int) ast.Range {
+			return ast.Range{Start: node.ElsIf[i].Condition.SourceRange().Start, End: node.Loc.End}
+		}
+		var other ast.Node = node.Alternative
+		otherLoc := coverageRange(node.Alternative, node.Loc)
+		if len(node.ElsIf) > 0 {
+			other, otherLoc = node.ElsIf[0].Consequent, elsifLoc(0)
+		}
+		bodyCounter, otherCounter := c.coverIf(node, node.Loc, node.IsUnless, node.Consequent, other, otherLoc)
 		if err := c.compileCondition(node.Condition); err != nil {
 			return err
 		}
@@ -885,6 +993,7 @@ func (c *Compiler) Compile(node interface{}) error {
 		}
 		jumpNotTruthyPos := c.emit(jumpOp, 9999)
 
+		c.emitCoverage(bodyCounter)
 		if err := c.compileBlockAsValue(node.Consequent); err != nil {
 			return err
 		}
@@ -894,6 +1003,7 @@ func (c *Compiler) Compile(node interface{}) error {
 			jumpToEnd := c.emit(OpJump, 9999)
 			afterConsequent := len(c.currentInstructions())
 			c.changeOperand(jumpNotTruthyPos, afterConsequent)
+			c.emitCoverage(otherCounter)
 			c.Emit(OpNil)
 			afterNil := len(c.currentInstructions())
 			c.changeOperand(jumpToEnd, afterNil)
@@ -904,19 +1014,28 @@ func (c *Compiler) Compile(node interface{}) error {
 
 			afterConsequent := len(c.currentInstructions())
 			c.changeOperand(jumpNotTruthyPos, afterConsequent)
+			c.emitCoverage(otherCounter)
 
 			// Compile elsif branches
-			for _, elsif := range node.ElsIf {
+			for i, elsif := range node.ElsIf {
+				var other ast.Node = node.Alternative
+				otherLoc := coverageRange(node.Alternative, elsifLoc(i))
+				if i+1 < len(node.ElsIf) {
+					other, otherLoc = node.ElsIf[i+1].Consequent, elsifLoc(i+1)
+				}
+				thenCounter, elseCounter := c.coverIf(elsif.Consequent, elsifLoc(i), false, elsif.Consequent, other, otherLoc)
 				if err := c.compileCondition(elsif.Condition); err != nil {
 					return err
 				}
 				elsifJumpPos := c.emit(OpJumpNotTruthy, 9999)
+				c.emitCoverage(thenCounter)
 				if err := c.compileBlockAsValue(elsif.Consequent); err != nil {
 					return err
 				}
 				jumpToEndPositions = append(jumpToEndPositions, c.emit(OpJump, 9999))
 				afterElsif := len(c.currentInstructions())
 				c.changeOperand(elsifJumpPos, afterElsif)
+				c.emitCoverage(elseCounter)
 			}
 
 			// Compile else branch
@@ -946,6 +1065,11 @@ func (c *Compiler) Compile(node interface{}) error {
 		if patternCase {
 			c.emit(OpPatternCacheClear, 1)
 		}
+		branch := c.coverage.Branch("case", node, node.Loc)
+		clauseKind := "when"
+		if patternCase {
+			clauseKind = "in"
+		}
 		if node.Expression != nil {
 			if err := c.Compile(node.Expression); err != nil {
 				return err
@@ -973,6 +1097,7 @@ func (c *Compiler) Compile(node interface{}) error {
 							condJumpPositions = append(condJumpPositions, c.emit(OpJumpNotTruthy, 9999))
 						}
 						c.Emit(OpPop)
+						c.emitCoverage(branch.Target(clauseKind, clause.Body, coverageRange(clause.Body, node.Loc)))
 						if err := c.compileBlockAsValue(clause.Body); err != nil {
 							return err
 						}
@@ -1003,6 +1128,7 @@ func (c *Compiler) Compile(node interface{}) error {
 					}
 					condJumpPos := c.emit(OpJumpNotTruthy, 9999)
 					c.Emit(OpPop)
+					c.emitCoverage(branch.Target(clauseKind, clause.Body, coverageRange(clause.Body, node.Loc)))
 					if err := c.compileBlockAsValue(clause.Body); err != nil {
 						return err
 					}
@@ -1014,6 +1140,7 @@ func (c *Compiler) Compile(node interface{}) error {
 						return err
 					}
 					condJumpPos := c.emit(OpJumpNotTruthy, 9999)
+					c.emitCoverage(branch.Target(clauseKind, clause.Body, coverageRange(clause.Body, node.Loc)))
 					if err := c.compileBlockAsValue(clause.Body); err != nil {
 						return err
 					}
@@ -1023,6 +1150,7 @@ func (c *Compiler) Compile(node interface{}) error {
 				}
 			}
 		}
+		c.emitCoverage(branch.Target("else", node.Else, coverageRange(node.Else, node.Loc)))
 		if node.Else != nil {
 			if node.Expression != nil {
 				c.Emit(OpPop)
@@ -1480,8 +1608,11 @@ func (c *Compiler) Compile(node interface{}) error {
 				return c.compileLogicalSendAssignment(node.Receiver, getter, setter, args, node.Args[len(node.Args)-1], node.LogicalAssignment, node.Safe)
 			}
 		}
+		if node.Receiver == nil && node.Method != nil && node.Method.Value == "BEGIN" && node.Block != nil {
+			return fmt.Errorf("BEGIN is permitted only at toplevel")
+		}
 		if c.methodDepth > 0 && node.Receiver == nil && node.Method != nil && node.Method.Value == "END" && node.Block != nil {
-			fmt.Fprintln(os.Stderr, "warning: END in method; use at_exit")
+			c.warn("END in method; use at_exit")
 		}
 		if node.Receiver == nil && node.Method != nil && len(node.Args) == 1 && len(node.KeywordArgs) == 0 && node.Block == nil {
 			if sym, ok := c.symbolTable.Resolve(node.Method.Value); ok && sym.Scope == ScopeLocal {
@@ -1518,10 +1649,14 @@ func (c *Compiler) Compile(node interface{}) error {
 
 		var jumpEnd int
 		if node.Safe {
+			branch := c.coverage.Branch("&.", node, node.Loc)
+			thenCounter, elseCounter := branch.Target("then", node, node.Loc), branch.Target("else", node, node.Loc)
 			c.Emit(OpDup)
 			jumpCall := c.emit(OpJumpNotNil, 9999)
+			c.emitCoverage(elseCounter)
 			jumpEnd = c.emit(OpJump, 9999)
 			c.changeOperand(jumpCall, len(c.currentInstructions()))
+			c.emitCoverage(thenCounter)
 		}
 
 		args := expandForwardArguments(node.Args)
@@ -1693,7 +1828,7 @@ func (c *Compiler) Compile(node interface{}) error {
 	case *ast.ReturnExpression:
 		topLevelEvalReturn := c.scopeIndex == 0 && c.methodDepth == 0 && c.evalTopLevelReturn
 		if c.scopeIndex == 0 && c.methodDepth == 0 && node.ReturnValue != nil && !topLevelEvalReturn {
-			fmt.Fprintln(os.Stderr, "warning: argument of top-level return is ignored")
+			c.warn("argument of top-level return is ignored")
 		}
 		if node.ReturnValue != nil {
 			if splat, ok := node.ReturnValue.(*ast.SplatExpression); ok {
@@ -1715,6 +1850,9 @@ func (c *Compiler) Compile(node interface{}) error {
 	case *ast.DefExpression:
 		c.EnterScope()
 		c.symbolTable.MethodBoundary = true
+		// The VM finds the method counter as the first instruction when
+		// it defines the method; see OpDefineMethod.
+		c.emitCoverage(c.coverage.Method(node.Name.Value, node, node.Loc))
 
 		paramIndices := make([]int, len(node.Params))
 		for i, param := range node.Params {
@@ -1769,7 +1907,9 @@ func (c *Compiler) Compile(node interface{}) error {
 		}
 		c.methodDepth--
 
-		c.replaceLastPopWithReturn()
+		if c.replaceLastPopWithReturn() {
+			c.markReturnLine(node.Loc.End.Line)
+		}
 
 		free := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.MaxSymbols
@@ -2088,7 +2228,7 @@ func (c *Compiler) Compile(node interface{}) error {
 		if node.Post {
 			return c.compilePostWhileExpression(node)
 		}
-		loopStart := len(c.currentInstructions())
+		loopStart := c.loopHeader()
 
 		if err := c.compileCondition(node.Condition); err != nil {
 			return err
@@ -2102,6 +2242,7 @@ func (c *Compiler) Compile(node interface{}) error {
 
 		setWhileEndPos := c.emit(OpSetWhileEnd, 0)
 		bodyStart := len(c.currentInstructions())
+		c.emitCoverage(c.coverage.Branch("while", node, node.Loc).Target("body", node.Body, coverageRange(node.Body, node.Loc)))
 		previousRedoTarget := c.scopes[c.scopeIndex].redoTarget
 		previousNextPatchTarget := c.scopes[c.scopeIndex].nextPatchTarget
 		c.scopes[c.scopeIndex].redoTarget = bodyStart
@@ -2139,7 +2280,7 @@ func (c *Compiler) Compile(node interface{}) error {
 			return c.compilePostUntilExpression(node)
 		}
 		// until is like while with negated condition
-		loopStart := len(c.currentInstructions())
+		loopStart := c.loopHeader()
 
 		if err := c.compileCondition(node.Condition); err != nil {
 			return err
@@ -2154,6 +2295,7 @@ func (c *Compiler) Compile(node interface{}) error {
 
 		setWhileEndPos := c.emit(OpSetWhileEnd, 0)
 		bodyStart := len(c.currentInstructions())
+		c.emitCoverage(c.coverage.Branch("until", node, node.Loc).Target("body", node.Body, coverageRange(node.Body, node.Loc)))
 		previousRedoTarget := c.scopes[c.scopeIndex].redoTarget
 		previousNextPatchTarget := c.scopes[c.scopeIndex].nextPatchTarget
 		c.scopes[c.scopeIndex].redoTarget = bodyStart
@@ -3019,7 +3161,9 @@ func (c *Compiler) compileBlockAsClosureWithLocalNamesInternal(block *ast.BlockE
 		return err
 	}
 
-	c.replaceLastPopWithBlockReturn()
+	if c.replaceLastPopWithBlockReturn() {
+		c.markReturnLine(block.Loc.End.Line)
+	}
 
 	free := c.symbolTable.FreeSymbols
 	numLocals := c.symbolTable.MaxSymbols
@@ -3437,8 +3581,14 @@ func (c *Compiler) compileBeginExpression(node *ast.BeginExpression) error {
 	rescueOffsets := make([]int, len(node.Rescue))
 	var pendingNoMatchJump int
 	rescueEndJumps := []int{}
+	bodyLine := c.currentLine
 	for i, rescue := range node.Rescue {
 		rescueOffsets[i] = len(c.currentInstructions())
+		// Matching and binding belong to the rescue clause's line, not the
+		// begin's, so backtraces and line events inside a handler agree.
+		if rescue.Token.Line > 0 {
+			c.currentLine = rescue.Token.Line
+		}
 		if pendingNoMatchJump > 0 {
 			c.changeOperand(pendingNoMatchJump, rescueOffsets[i])
 		}
@@ -3491,6 +3641,7 @@ func (c *Compiler) compileBeginExpression(node *ast.BeginExpression) error {
 
 		rescueEndJumps = append(rescueEndJumps, c.emit(OpJump, 0))
 	}
+	c.currentLine = bodyLine
 
 	unmatchedReraiseStart := 0
 	if pendingNoMatchJump > 0 {
@@ -3643,16 +3794,30 @@ func (c *Compiler) setLastInstruction(op Opcode, pos int) {
 	c.scopes[c.scopeIndex].lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
 }
 
-func (c *Compiler) replaceLastPopWithReturn() {
+// replaceLastPopWithReturn ends the scope by returning its last value and
+// reports whether it had to, rather than the body ending in a return.
+func (c *Compiler) replaceLastPopWithReturn() bool {
 	last := c.scopes[c.scopeIndex].lastInstruction
 	if last.Opcode == OpPop {
 		c.scopes[c.scopeIndex].instructions[last.Position] = byte(OpReturnValue)
 		c.scopes[c.scopeIndex].lastInstruction.Opcode = OpReturnValue
-		return
+		return true
 	}
 	if last.Opcode != OpReturnValue {
 		c.Emit(OpReturnValue)
+		return true
 	}
+	return false
+}
+
+// markReturnLine attributes the return closing the current scope to line,
+// the line of its `end`, which is where a :return event reports leaving.
+func (c *Compiler) markReturnLine(line int) {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	if line <= 0 || c.scopes[c.scopeIndex].lineMap == nil {
+		return
+	}
+	c.scopes[c.scopeIndex].lineMap[last.Position] = line
 }
 
 func (c *Compiler) replaceOpcodes(from, to Opcode) {
@@ -3680,16 +3845,18 @@ func (c *Compiler) replaceOpcodes(from, to Opcode) {
 	}
 }
 
-func (c *Compiler) replaceLastPopWithBlockReturn() {
+func (c *Compiler) replaceLastPopWithBlockReturn() bool {
 	last := c.scopes[c.scopeIndex].lastInstruction
 	if last.Opcode == OpPop {
 		c.scopes[c.scopeIndex].instructions[last.Position] = byte(OpBlockReturn)
 		c.scopes[c.scopeIndex].lastInstruction.Opcode = OpBlockReturn
-		return
+		return true
 	}
 	if last.Opcode != OpBlockReturn && last.Opcode != OpReturnValue {
 		c.Emit(OpBlockReturn)
+		return true
 	}
+	return false
 }
 
 func (c *Compiler) removeLastPop() {
@@ -3778,7 +3945,10 @@ func (c *Compiler) compileCondition(condition ast.Expression) error {
 	if _, ok := condition.(*ast.RegexpLiteral); !ok {
 		return c.Compile(condition)
 	}
-	fmt.Fprintln(os.Stderr, "warning: regex literal in condition")
+	// Like MRI, one-liners such as `rgo -ne 'print if /re/'` are not warned.
+	if core.CurrentSpecFile != "-e" {
+		c.warn("regex literal in condition")
+	}
 	if err := c.Compile(condition); err != nil {
 		return err
 	}
@@ -3847,7 +4017,7 @@ func (c *Compiler) compileFlipFlopCondition(node *ast.RangeExpression) error {
 
 func (c *Compiler) compileFlipFlopEndpoint(expression ast.Expression) error {
 	if _, ok := expression.(*ast.IntegerLiteral); ok {
-		fmt.Fprintln(os.Stderr, "warning: integer literal in flip-flop")
+		c.warn("integer literal in flip-flop")
 		c.emit(OpGetGlobal, c.globalSymbolIndex("$."))
 		if err := c.Compile(expression); err != nil {
 			return err
@@ -3965,12 +4135,26 @@ func (c *Compiler) EnterScope() {
 	c.scopes = append(c.scopes, scope)
 	c.scopeIndex++
 	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+	if c.recordScopes {
+		var owner ast.Node
+		if len(c.compilingNodes) > 0 {
+			owner = c.compilingNodes[len(c.compilingNodes)-1]
+		}
+		c.scopeOwners = append(c.scopeOwners, owner)
+	}
 }
 
 func (c *Compiler) LeaveScope() Instructions {
 	instructions := c.currentInstructions()
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.scopeIndex--
+	if c.recordScopes && len(c.scopeOwners) > 0 {
+		owner := c.scopeOwners[len(c.scopeOwners)-1]
+		c.scopeOwners = c.scopeOwners[:len(c.scopeOwners)-1]
+		if owner != nil {
+			c.recordedScopes = append(c.recordedScopes, ScopeLocals{Node: owner, Table: c.symbolTable})
+		}
+	}
 	c.symbolTable = c.symbolTable.Outer
 
 	return instructions
@@ -4698,6 +4882,7 @@ func (c *Compiler) compilePostUntilExpression(node *ast.UntilExpression) error {
 	scope.breakValuePatchPos = nil
 	setWhileEndPos := c.emit(OpSetWhileEnd, 0)
 	bodyStart := len(c.currentInstructions())
+	c.emitCoverage(c.coverage.Branch("until", node, node.Loc).Target("body", node.Body, coverageRange(node.Body, node.Loc)))
 	scope.redoTarget = bodyStart
 	scope.nextPatchTarget = -1
 	if err := c.Compile(node.Body); err != nil {
@@ -4730,6 +4915,17 @@ func (c *Compiler) compilePostUntilExpression(node *ast.UntilExpression) error {
 	return nil
 }
 
+// loopHeader returns the position a while or until loop jumps back to.
+// OpNext reads a zero target as a block-level next, so a loop that would
+// start a function is moved past a no-op.
+func (c *Compiler) loopHeader() int {
+	if len(c.currentInstructions()) == 0 {
+		c.Emit(OpNil)
+		c.Emit(OpPop)
+	}
+	return len(c.currentInstructions())
+}
+
 func (c *Compiler) compilePostWhileExpression(node *ast.WhileExpression) error {
 	scope := &c.scopes[c.scopeIndex]
 	previousRedoTarget := scope.redoTarget
@@ -4743,6 +4939,7 @@ func (c *Compiler) compilePostWhileExpression(node *ast.WhileExpression) error {
 	scope.breakValuePatchPos = nil
 	setWhileEndPos := c.emit(OpSetWhileEnd, 0)
 	bodyStart := len(c.currentInstructions())
+	c.emitCoverage(c.coverage.Branch("while", node, node.Loc).Target("body", node.Body, coverageRange(node.Body, node.Loc)))
 	scope.redoTarget = bodyStart
 	scope.nextPatchTarget = -1
 	if err := c.Compile(node.Body); err != nil {
@@ -5955,3 +6152,33 @@ func isConstantName(name string) bool {
 	r, _ := utf8.DecodeRuneInString(name)
 	return unicode.IsUpper(r)
 }
+
+// hoistBeginBlocks moves the bodies of top-level `BEGIN { }` blocks in front
+// of the remaining statements. BEGIN runs in the toplevel scope, so its body
+// is spliced in rather than compiled as a block.
+func hoistBeginBlocks(statements []ast.Statement) []ast.Statement {
+	var begins, rest []ast.Statement
+	for _, statement := range statements {
+		if block := beginBlock(statement); block != nil {
+			begins = append(begins, block.Statements...)
+			continue
+		}
+		rest = append(rest, statement)
+	}
+	if begins == nil {
+		return statements
+	}
+	return append(begins, rest...)
+}
+
+func beginBlock(statement ast.Statement) *ast.BlockExpression {
+	expressionStatement, ok := statement.(*ast.ExpressionStatement)
+	if !ok {
+		return nil
+	}
+	call, ok := expressionStatement.Expression.(*ast.MethodCall)
+	if !ok || call.Receiver != nil || call.Method == nil || call.Method.Value != "BEGIN" || call.Block == nil {
+		return nil
+	}
+	return call.Block
+}
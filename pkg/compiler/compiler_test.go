@@ -63,6 +63,21 @@ func TestCompileCatchWithoutBlockDoesNotSetLastException(t *testing.T) {
 	}
 }
 
+func TestCompileErrorLineNamesFailingNode(t *testing.T) {
+	p := parser.New(lexer.New("x = 1\n\ndef helper\n  BEGIN { x }\nend\n"))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	c := New()
+	if err := c.Compile(program); err == nil || !strings.Contains(err.Error(), "BEGIN is permitted only at toplevel") {
+		t.Fatalf("compile error = %v", err)
+	}
+	if line := c.ErrorLine(); line != 4 {
+		t.Fatalf("ErrorLine() = %d, want 4", line)
+	}
+}
+
 func flipFlopStateIDs(instructions Instructions) []int {
 	var ids []int
 	for i := 0; i < len(instructions); {
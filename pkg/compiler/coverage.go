@@ -0,0 +1,82 @@
+package compiler
+
+import (
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// SetCoverage makes the compiler emit the counters source registers: one
+// per statement line, branch target and method. A nil source emits none.
+func (c *Compiler) SetCoverage(source *core.CoverageSource) {
+	c.coverage = source
+}
+
+func (c *Compiler) emitCoverage(counter int) {
+	if counter >= 0 {
+		c.emit(OpCoverage, counter)
+	}
+}
+
+// coverStatement emits the line counter of node when it starts a
+// statement. A statement nested in another one on the same line, such as
+// the body of a modifier if, does not count the line again. The returned
+// function restores the enclosing statement's line.
+func (c *Compiler) coverStatement(node ast.Node) func() {
+	counter, line := c.coverage.Statement(node)
+	if counter < 0 {
+		return nil
+	}
+	if line == c.coverageLine && c.scopeIndex == c.coverageScope {
+		return nil
+	}
+	previousLine, previousScope := c.coverageLine, c.coverageScope
+	c.coverageLine, c.coverageScope = line, c.scopeIndex
+	c.emitCoverage(counter)
+	return func() {
+		c.coverageLine, c.coverageScope = previousLine, previousScope
+	}
+}
+
+// coverIf registers an if, unless, elsif or ternary spanning loc and
+// returns the counters of the code run when the condition holds and of the
+// other path, which spans otherLoc. As in MRI, the then target of an unless
+// is its else part.
+func (c *Compiler) coverIf(node ast.Node, loc ast.Range, unless bool, body, other ast.Node, otherLoc ast.Range) (bodyCounter, otherCounter int) {
+	kind := "if"
+	if unless {
+		kind = "unless"
+	}
+	branch := c.coverage.Branch(kind, node, loc)
+	bodyLoc := coverageRange(body, loc)
+	if unless {
+		otherCounter = branch.Target("then", other, otherLoc)
+		bodyCounter = branch.Target("else", body, bodyLoc)
+		return bodyCounter, otherCounter
+	}
+	bodyCounter = branch.Target("then", body, bodyLoc)
+	otherCounter = branch.Target("else", other, otherLoc)
+	return bodyCounter, otherCounter
+}
+
+// coverageRange returns the range of a branch target: that of its
+// statements for a block. A missing or empty target spans fallback, the
+// whole construct.
+func coverageRange(node ast.Node, fallback ast.Range) ast.Range {
+	if block, ok := node.(*ast.BlockExpression); ok {
+		if block == nil || len(block.Statements) == 0 {
+			return fallback
+		}
+		loc := ast.Range{}
+		for _, statement := range block.Statements {
+			loc = loc.Cover(statement.SourceRange())
+		}
+		if loc.IsValid() {
+			return loc
+		}
+		return fallback
+	}
+	if node == nil || !node.SourceRange().IsValid() {
+		return fallback
+	}
+	return node.SourceRange()
+}
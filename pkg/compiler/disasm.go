@@ -0,0 +1,398 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// operandKind says how the disassembler annotates an operand.
+type operandKind int
+
+const (
+	operandPlain operandKind = iota
+	operandConstant
+	operandLocal
+	operandFree
+	operandGlobal
+	operandJump
+)
+
+// operandKinds lists the operands that index a table or a jump target. Every
+// operand not mentioned here is printed as a bare number.
+var operandKinds = map[Opcode][]operandKind{
+	OpConstant:                 {operandConstant},
+	OpJump:                     {operandJump},
+	OpJumpNotTruthy:            {operandJump},
+	OpJumpNotNil:               {operandJump},
+	OpJumpTruthy:               {operandJump},
+	OpJumpLocalPresent:         {operandLocal, operandJump},
+	OpGetGlobal:                {operandGlobal},
+	OpSetGlobal:                {operandGlobal},
+	OpGetLocal:                 {operandLocal},
+	OpSetLocal:                 {operandLocal},
+	OpGetLocalCell:             {operandLocal},
+	OpGetLocalFast:             {operandLocal},
+	OpGetFree:                  {operandFree},
+	OpSetFree:                  {operandFree},
+	OpGetFreeCell:              {operandFree},
+	OpGetInstanceVar:           {operandConstant},
+	OpSetInstanceVar:           {operandConstant},
+	OpDefinedInstanceVar:       {operandConstant},
+	OpGetClassVar:              {operandConstant},
+	OpGetClassVarOrNil:         {operandConstant},
+	OpSetClassVar:              {operandConstant},
+	OpDefinedClassVar:          {operandConstant},
+	OpDefinedGlobal:            {operandConstant},
+	OpGetConstant:              {operandConstant},
+	OpSetConstant:              {operandConstant},
+	OpGetScopedConstant:        {operandConstant},
+	OpSetScopedConstant:        {operandConstant},
+	OpDefinedConstant:          {operandConstant},
+	OpDefinedMethod:            {operandConstant},
+	OpDefined:                  {operandConstant},
+	OpClosure:                  {operandConstant},
+	OpLambda:                   {operandConstant},
+	OpSend:                     {operandConstant},
+	OpSendWithKeywords:         {operandConstant},
+	OpSendSetter:               {operandConstant},
+	OpSendSuper:                {operandConstant},
+	OpDefineMethod:             {operandConstant},
+	OpDefineSingletonMethod:    {operandConstant},
+	OpDefineClassMethod:        {operandConstant},
+	OpClass:                    {operandConstant},
+	OpModule:                   {operandConstant},
+	OpIndexCompoundAssign:      {operandConstant},
+	OpIndexSplatCompoundAssign: {operandConstant},
+	OpLogicalSendAssignment:    {operandConstant, operandConstant},
+	OpPatternCheck:             {operandConstant},
+	OpRegexpOnceGet:            {operandConstant},
+	OpRegexpOnceSet:            {operandConstant},
+	OpSetStringEncoding:        {operandConstant},
+}
+
+// ReadOperands decodes the operands of one instruction whose opcode byte has
+// already been consumed. It returns the operands and their total width.
+func ReadOperands(def Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+	for i, width := range def.OperandWidths {
+		if offset+width > len(ins) {
+			return operands[:i], offset
+		}
+		switch width {
+		case 4:
+			operands[i] = int(binary.BigEndian.Uint32(ins[offset:]))
+		case 2:
+			operands[i] = int(binary.BigEndian.Uint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ins[offset])
+		}
+		offset += width
+	}
+	return operands, offset
+}
+
+// String renders one instruction per line with its byte offset and raw
+// operands.
+func (ins Instructions) String() string {
+	var out strings.Builder
+	for i := 0; i < len(ins); {
+		def, ok := Lookup(ins[i])
+		if !ok {
+			fmt.Fprintf(&out, "%04d ERROR: unknown opcode %d\n", i, ins[i])
+			i++
+			continue
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, formatInstruction(def, operands))
+		i += 1 + read
+	}
+	return out.String()
+}
+
+func formatInstruction(def Definition, operands []int) string {
+	if len(operands) == 0 {
+		return def.Name
+	}
+	parts := make([]string, len(operands))
+	for i, operand := range operands {
+		parts[i] = fmt.Sprint(operand)
+	}
+	return fmt.Sprintf("%-24s %s", def.Name, strings.Join(parts, " "))
+}
+
+// DisassembleOptions controls Disassemble output.
+type DisassembleOptions struct {
+	// Path names the program in section headers.
+	Path string
+	// Source, when set, is used to print each source line above the first
+	// instruction compiled from it.
+	Source string
+	// Annotate may return a note appended to the header of a nested
+	// function, e.g. whether the VM can run it on a faster tier.
+	Annotate func(fn *object.Function) string
+}
+
+// Disassemble writes the top-level program followed by every method, block
+// and class body reachable from its constants, each with its local table,
+// parameters and source lines.
+func Disassemble(w io.Writer, bc *Bytecode, options DisassembleOptions) error {
+	d := &disassembler{w: w, options: options, seen: make(map[*object.Function]bool)}
+	if options.Source != "" {
+		d.lines = strings.Split(options.Source, "\n")
+	}
+	path := options.Path
+	if path == "" {
+		path = "-"
+	}
+	d.section(fmt.Sprintf("<main> (%s)", path), "")
+	d.locals(bc.LocalNames, bc.NumLocals)
+	d.nested(d.body(bc.Instructions, bc.LineMap, bc.Constants, bc.LocalNames, nil, bc.GlobalNames))
+	return d.err
+}
+
+type disassembler struct {
+	w       io.Writer
+	options DisassembleOptions
+	lines   []string
+	seen    map[*object.Function]bool
+	err     error
+}
+
+func (d *disassembler) printf(format string, args ...interface{}) {
+	if d.err == nil {
+		_, d.err = fmt.Fprintf(d.w, format, args...)
+	}
+}
+
+func (d *disassembler) section(title, note string) {
+	if note != "" {
+		title += " [" + note + "]"
+	}
+	d.printf("== disasm: %s %s\n", title, strings.Repeat("=", max(3, 60-len(title))))
+}
+
+func (d *disassembler) locals(names map[string]int, size int) {
+	if size == 0 && len(names) == 0 {
+		return
+	}
+	if len(names) == 0 {
+		d.printf("local table (size: %d)\n", size)
+		return
+	}
+	d.printf("local table (size: %d): %s\n", size, strings.Join(indexedNames(names), ", "))
+}
+
+// nested prints the functions a body refers to, in the order the body
+// refers to them, each followed by its own nested functions.
+func (d *disassembler) nested(children []*object.Function) {
+	for _, fn := range children {
+		if d.seen[fn] {
+			continue
+		}
+		d.seen[fn] = true
+		d.printf("\n")
+		d.nested(d.function(fn))
+	}
+}
+
+func (d *disassembler) function(fn *object.Function) []*object.Function {
+	title := functionLabel(fn)
+	switch {
+	case fn.SourcePath != "" && fn.DefinitionLine > 0:
+		title += fmt.Sprintf(" (%s:%d)", fn.SourcePath, fn.DefinitionLine)
+	case fn.SourcePath != "":
+		title += fmt.Sprintf(" (%s)", fn.SourcePath)
+	case fn.DefinitionLine > 0:
+		title += fmt.Sprintf(" (line %d)", fn.DefinitionLine)
+	}
+	note := ""
+	if d.options.Annotate != nil {
+		note = d.options.Annotate(fn)
+	}
+	d.section(title, note)
+	if params := functionParams(fn); params != "" {
+		d.printf("params: %s\n", params)
+	}
+	d.locals(fn.LocalNames, fn.NumLocals)
+	if len(fn.FreeVarNames) > 0 {
+		d.printf("free variables: %s\n", strings.Join(fn.FreeVarNames, ", "))
+	}
+	return d.body(fn.Instructions, fn.LineMap, fn.Constants, fn.LocalNames, fn.FreeVarNames, fn.GlobalNames)
+}
+
+// body prints one instruction sequence and returns the functions its
+// constant operands refer to.
+func (d *disassembler) body(ins Instructions, lineMap map[int]int, constants []*object.EmeraldValue, localNames map[string]int, freeNames []string, globalNames map[string]int) []*object.Function {
+	var children []*object.Function
+	locals := reverseNames(localNames)
+	globals := reverseNames(globalNames)
+	line := 0
+	for i := 0; i < len(ins); {
+		if next, ok := lineMap[i]; ok && next != line {
+			line = next
+			if line > 0 && line <= len(d.lines) {
+				d.printf("  # %d: %s\n", line, strings.TrimSpace(d.lines[line-1]))
+			}
+		}
+		def, ok := Lookup(ins[i])
+		if !ok {
+			d.printf("%04d ERROR: unknown opcode %d\n", i, ins[i])
+			i++
+			continue
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+		text := formatInstruction(def, operands)
+		var notes []string
+		for index, kind := range operandKinds[Opcode(ins[i])] {
+			if index >= len(operands) {
+				break
+			}
+			operand := operands[index]
+			switch kind {
+			case operandConstant:
+				if operand < len(constants) {
+					notes = append(notes, describeConstant(constants[operand]))
+					if fn, ok := constants[operand].Data.(*object.Function); ok && fn != nil {
+						children = append(children, fn)
+					}
+				}
+			case operandLocal:
+				if name := locals[operand]; name != "" {
+					notes = append(notes, name)
+				}
+			case operandFree:
+				if operand < len(freeNames) {
+					notes = append(notes, freeNames[operand])
+				}
+			case operandGlobal:
+				if name := globals[operand]; name != "" {
+					notes = append(notes, name)
+				}
+			case operandJump:
+				notes = append(notes, fmt.Sprintf("-> %04d", operand))
+			}
+		}
+		if len(notes) > 0 {
+			text = fmt.Sprintf("%-36s ; %s", text, strings.Join(notes, ", "))
+		}
+		if line > 0 {
+			text = fmt.Sprintf("%-64s (%4d)", text, line)
+		}
+		d.printf("%04d %s\n", i, strings.TrimRight(text, " "))
+		i += 1 + read
+	}
+	return children
+}
+
+func functionLabel(fn *object.Function) string {
+	switch {
+	case fn.MethodBody && fn.SingletonClassBody:
+		return "singleton method " + fn.Name
+	case fn.MethodBody:
+		return "method " + fn.Name
+	case strings.HasSuffix(fn.Name, "#body"):
+		return "body of " + strings.TrimSuffix(fn.Name, "#body")
+	case fn.Name == "__block__":
+		return "block"
+	case fn.Name == "__lambda__":
+		return "lambda"
+	case fn.Name == "":
+		return "function"
+	default:
+		return fn.Name
+	}
+}
+
+func functionParams(fn *object.Function) string {
+	var params []string
+	for i, name := range fn.Params {
+		if i < len(fn.ParamDefaults) && fn.ParamDefaults[i] != nil {
+			name += "=" + describeConstant(fn.ParamDefaults[i])
+		}
+		params = append(params, name)
+	}
+	if fn.HasRestParam {
+		params = append(params, "*"+fn.RestParamName)
+	}
+	for _, param := range fn.KeywordParams {
+		if param.HasDefault {
+			params = append(params, param.Name+": "+describeConstant(param.Default))
+		} else {
+			params = append(params, param.Name+":")
+		}
+	}
+	if fn.KeywordRestParam != "" {
+		params = append(params, "**"+fn.KeywordRestParam)
+	}
+	if fn.HasBlockParam {
+		params = append(params, "&")
+	}
+	return strings.Join(params, ", ")
+}
+
+func describeConstant(value *object.EmeraldValue) string {
+	if value == nil {
+		return "<none>"
+	}
+	switch value.Type {
+	case object.ValueFunction:
+		if fn, ok := value.Data.(*object.Function); ok && fn != nil {
+			return "<" + functionLabel(fn) + ">"
+		}
+	case object.ValueClass:
+		if class, ok := value.Data.(*object.Class); ok && class != nil {
+			return class.Name
+		}
+	case object.ValueString:
+		if s, ok := value.Data.(string); ok {
+			return fmt.Sprintf("%q", s)
+		}
+	case object.ValueArray:
+		if elements, ok := value.Data.([]*object.EmeraldValue); ok && len(elements) > 8 {
+			return fmt.Sprintf("[...%d elements]", len(elements))
+		}
+	}
+	text := value.Inspect()
+	if len(text) > 60 {
+		text = text[:57] + "..."
+	}
+	return text
+}
+
+func reverseNames(names map[string]int) map[int]string {
+	reversed := make(map[int]string, len(names))
+	for name, index := range names {
+		if existing, ok := reversed[index]; !ok || name < existing {
+			reversed[index] = name
+		}
+	}
+	return reversed
+}
+
+func indexedNames(names map[string]int) []string {
+	type entry struct {
+		name  string
+		index int
+	}
+	entries := make([]entry, 0, len(names))
+	for name, index := range names {
+		entries = append(entries, entry{name, index})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].index != entries[j].index {
+			return entries[i].index < entries[j].index
+		}
+		return entries[i].name < entries[j].name
+	})
+	formatted := make([]string, len(entries))
+	for i, e := range entries {
+		formatted[i] = fmt.Sprintf("%s@%d", e.name, e.index)
+	}
+	return formatted
+}
@@ -0,0 +1,54 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+func TestInstructionsString(t *testing.T) {
+	ins := append(Make(OpConstant, 65534), Make(OpSend, 3, 0, 1, 255)...)
+	ins = append(ins, Make(OpPop)...)
+	want := "0000 OpConstant               65534\n0003 OpSend                   3 0 1 255\n0009 OpPop\n"
+	if got := ins.String(); got != want {
+		t.Fatalf("Instructions.String() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDisassembleNestedFunctions(t *testing.T) {
+	source := "def scale(list, factor = 2)\n  list.map { |x| x * factor }\nend\n$total = scale([1])\n"
+	bc := compile(t, source)
+	var out strings.Builder
+	err := Disassemble(&out, bc, DisassembleOptions{
+		Path:   "scale.rb",
+		Source: source,
+		Annotate: func(fn *object.Function) string {
+			if fn.MethodBody {
+				return "checked"
+			}
+			return ""
+		},
+	})
+	if err != nil {
+		t.Fatalf("Disassemble: %v", err)
+	}
+	text := out.String()
+	for _, want := range []string{
+		"== disasm: <main> (scale.rb)",
+		"== disasm: method scale (line 1) [checked]",
+		"params: list, factor=2",
+		"local table (size: 2): list@0, factor@1",
+		"  # 2: list.map { |x| x * factor }",
+		"== disasm: block (line 2)",
+		"; $total",
+		"; \"map\"",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("disassembly missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "method scale") > strings.Index(text, "== disasm: block") {
+		t.Fatalf("block printed before its method:\n%s", text)
+	}
+}
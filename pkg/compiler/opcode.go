@@ -210,6 +210,7 @@ const (
 	OpGetMatchCapture
 	OpSetStringEncoding
 	OpGetLocalFast
+	OpCoverage
 )
 
 type Definition struct {
@@ -415,6 +416,7 @@ var definitions = map[Opcode]Definition{
 	OpGetMatchCapture:        {"OpGetMatchCapture", []int{2}},
 	OpSetStringEncoding:      {"OpSetStringEncoding", []int{2}},
 	OpGetLocalFast:           {"OpGetLocalFast", []int{1}},
+	OpCoverage:               {"OpCoverage", []int{4}},
 }
 
 func Lookup(op byte) (Definition, bool) {
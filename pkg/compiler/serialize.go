@@ -0,0 +1,815 @@
+package compiler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// BytecodeFormatVersion is the layout of serialized Bytecode. Bump it when an
+// opcode, a constant encoding or a serialized Function field changes meaning;
+// files written with another format are treated as stale.
+const BytecodeFormatVersion = 1
+
+var bytecodeMagic = []byte("RGOC")
+
+// ErrStaleBytecode reports serialized bytecode that was written by another
+// RGo build or for different source text. Callers recompile and overwrite it.
+var ErrStaleBytecode = errors.New("stale bytecode")
+
+// ErrUncacheableBytecode reports bytecode holding a constant that only exists
+// in the current process, such as a value with a native override attached.
+var ErrUncacheableBytecode = errors.New("bytecode cannot be serialized")
+
+var (
+	rgoVersionOnce sync.Once
+	rgoVersion     string
+)
+
+// Version identifies the RGo build that produced serialized bytecode. Release
+// builds are keyed by module version and VCS revision. Development binaries
+// without clean VCS stamps also fold in the executable identity, so a rebuilt
+// tree never reuses bytecode emitted by the previous compiler.
+func Version() string {
+	rgoVersionOnce.Do(func() {
+		rgoVersion = fmt.Sprintf("rgoc%d ruby%s %s %s/%s", BytecodeFormatVersion, core.RubyCompatibilityVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		revision, clean := "", false
+		if info, ok := debug.ReadBuildInfo(); ok {
+			rgoVersion += " " + info.Main.Version
+			clean = true
+			for _, setting := range info.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					revision = setting.Value
+				case "vcs.modified":
+					clean = setting.Value != "true"
+				}
+			}
+		}
+		if revision != "" && clean {
+			rgoVersion += " " + revision
+			return
+		}
+		if executable, err := os.Executable(); err == nil {
+			if info, err := os.Stat(executable); err == nil {
+				rgoVersion += fmt.Sprintf(" %s %d %d", executable, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	})
+	return rgoVersion
+}
+
+// SourceDigest hashes source text together with the compilation context that
+// influences the emitted bytecode (source path, encoding, eval mode).
+func SourceDigest(source string, context ...string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, part := range context {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	h.Write([]byte(source))
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// MarshalBytecode encodes bc for the bytecode cache. The header records the
+// format, Version() and digest; UnmarshalBytecode rejects any mismatch.
+func MarshalBytecode(bc *Bytecode, digest [sha256.Size]byte) ([]byte, error) {
+	w := &bytecodeWriter{
+		values:    make(map[*object.EmeraldValue]int),
+		functions: make(map[*object.Function]int),
+	}
+	w.buf.Write(bytecodeMagic)
+	w.uint(BytecodeFormatVersion)
+	w.string(Version())
+	w.buf.Write(digest[:])
+	w.bytes(bc.Instructions)
+	w.intMap(bc.LineMap)
+	w.valueList(bc.Constants)
+	w.int(int64(bc.NumLocals))
+	w.nameMap(bc.GlobalNames)
+	w.nameMap(bc.LocalNames)
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// UnmarshalBytecode decodes bytecode written by MarshalBytecode. Class
+// constants are resolved against the currently active runtime.
+func UnmarshalBytecode(data []byte, digest [sha256.Size]byte) (*Bytecode, error) {
+	if !bytes.HasPrefix(data, bytecodeMagic) {
+		return nil, fmt.Errorf("not an RGo bytecode file")
+	}
+	r := &bytecodeReader{data: data[len(bytecodeMagic):]}
+	if r.uint() != BytecodeFormatVersion || r.string() != Version() {
+		return nil, ErrStaleBytecode
+	}
+	if stored := r.next(sha256.Size); r.err == nil && !bytes.Equal(stored, digest[:]) {
+		return nil, ErrStaleBytecode
+	}
+	bc := &Bytecode{}
+	bc.Instructions = r.bytes()
+	bc.LineMap = r.intMap()
+	bc.Constants = r.valueList()
+	bc.NumLocals = int(r.int())
+	bc.GlobalNames = r.nameMap()
+	bc.LocalNames = r.nameMap()
+	if r.err == nil && len(r.data) != 0 {
+		r.fail("trailing data")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return bc, nil
+}
+
+const (
+	tagNil byte = iota
+	tagRef
+	tagNilValue
+	tagTrue
+	tagFalse
+	tagInteger
+	tagBigInteger
+	tagFloat
+	tagString
+	tagSymbol
+	tagRegexp
+	tagArray
+	tagClass
+	tagException
+	tagFunction
+)
+
+type bytecodeWriter struct {
+	buf       bytes.Buffer
+	values    map[*object.EmeraldValue]int
+	functions map[*object.Function]int
+	// interned, when set, numbers the strings already written so that
+	// repeats are encoded as references. Bundles use it; the cache does not.
+	interned map[string]int
+	err      error
+}
+
+func (w *bytecodeWriter) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf("%w: "+format, append([]interface{}{ErrUncacheableBytecode}, args...)...)
+	}
+}
+
+func (w *bytecodeWriter) uint(n uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	w.buf.Write(scratch[:binary.PutUvarint(scratch[:], n)])
+}
+
+func (w *bytecodeWriter) int(n int64) {
+	var scratch [binary.MaxVarintLen64]byte
+	w.buf.Write(scratch[:binary.PutVarint(scratch[:], n)])
+}
+
+func (w *bytecodeWriter) bool(b bool) {
+	if b {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w *bytecodeWriter) bytes(b []byte) {
+	w.uint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *bytecodeWriter) string(s string) {
+	if w.interned != nil {
+		if index, ok := w.interned[s]; ok {
+			w.uint(uint64(index) + 1)
+			return
+		}
+		w.interned[s] = len(w.interned)
+		w.uint(0)
+	}
+	w.uint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *bytecodeWriter) strings(list []string) {
+	w.bool(list != nil)
+	w.uint(uint64(len(list)))
+	for _, s := range list {
+		w.string(s)
+	}
+}
+
+func (w *bytecodeWriter) ints(list []int) {
+	w.bool(list != nil)
+	w.uint(uint64(len(list)))
+	for _, n := range list {
+		w.int(int64(n))
+	}
+}
+
+func (w *bytecodeWriter) intMap(m map[int]int) {
+	w.bool(m != nil)
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	w.uint(uint64(len(keys)))
+	for _, key := range keys {
+		w.int(int64(key))
+		w.int(int64(m[key]))
+	}
+}
+
+func (w *bytecodeWriter) nameMap(m map[string]int) {
+	w.bool(m != nil)
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w.uint(uint64(len(keys)))
+	for _, key := range keys {
+		w.string(key)
+		w.int(int64(m[key]))
+	}
+}
+
+func (w *bytecodeWriter) valueList(values []*object.EmeraldValue) {
+	w.bool(values != nil)
+	w.uint(uint64(len(values)))
+	for _, value := range values {
+		w.value(value)
+	}
+}
+
+func (w *bytecodeWriter) className(class *object.Class) {
+	if class == nil {
+		w.string("")
+		return
+	}
+	if class.Name == "" || core.R.Classes[class.Name] != class {
+		w.fail("class %q is not a builtin class", class.Name)
+	}
+	w.string(class.Name)
+}
+
+// value writes each distinct pointer once. The VM relies on pointer identity
+// for a few constants (per-site caches, shared Function values), so later
+// occurrences are encoded as back references.
+func (w *bytecodeWriter) value(value *object.EmeraldValue) {
+	switch {
+	case value == nil:
+		w.buf.WriteByte(tagNil)
+		return
+	case value == core.R.NilVal:
+		w.buf.WriteByte(tagNilValue)
+		return
+	case value == core.R.TrueVal:
+		w.buf.WriteByte(tagTrue)
+		return
+	case value == core.R.FalseVal:
+		w.buf.WriteByte(tagFalse)
+		return
+	}
+	if index, ok := w.values[value]; ok {
+		w.buf.WriteByte(tagRef)
+		w.uint(uint64(index))
+		return
+	}
+	w.values[value] = len(w.values)
+	if len(value.InstanceVars) > 0 || value.TemporaryLocked {
+		w.fail("%s constant carries runtime state", value.TypeName())
+	}
+	if cold := value.Cold; cold != nil && (cold.StringBuilder != nil || cold.Allocation != nil || cold.LazyArray != nil || cold.Host != nil) {
+		w.fail("%s constant carries runtime state", value.TypeName())
+	}
+
+	switch value.Type {
+	case object.ValueInteger:
+		if n := value.BigIntValue(); n != nil {
+			w.buf.WriteByte(tagBigInteger)
+			w.bytes([]byte(n.Text(16)))
+			encoded, _ := core.ULEBPackOverride(value)
+			w.bytes(encoded)
+			approximation, ok := core.NumericFloatOverride(value)
+			w.bool(ok)
+			w.uint(math.Float64bits(approximation))
+		} else if _, ok := core.NumericBigIntOverride(value); ok {
+			w.fail("integer constant has a detached override")
+		} else {
+			w.buf.WriteByte(tagInteger)
+			n, _ := value.Data.(int64)
+			w.int(n)
+		}
+	case object.ValueFloat:
+		if _, ok := core.NumericFloatOverride(value); ok {
+			w.fail("float constant has an override")
+		}
+		w.buf.WriteByte(tagFloat)
+		f, _ := value.Data.(float64)
+		w.uint(math.Float64bits(f))
+	case object.ValueString:
+		w.buf.WriteByte(tagString)
+		s, _ := value.Data.(string)
+		w.string(s)
+	case object.ValueSymbol:
+		w.buf.WriteByte(tagSymbol)
+		s, _ := value.Data.(string)
+		w.string(s)
+	case object.ValueRegexp:
+		re, ok := value.Data.(*object.RRegexp)
+		if !ok || re == nil {
+			w.fail("malformed Regexp constant")
+			return
+		}
+		w.buf.WriteByte(tagRegexp)
+		w.string(re.Pattern)
+		w.string(re.Options)
+		w.bool(re.HasTimeout)
+		w.uint(math.Float64bits(re.Timeout))
+	case object.ValueArray:
+		elements, ok := value.Data.([]*object.EmeraldValue)
+		if !ok {
+			w.fail("malformed Array constant")
+			return
+		}
+		w.buf.WriteByte(tagArray)
+		w.valueList(elements)
+	case object.ValueClass:
+		class, ok := value.Data.(*object.Class)
+		if !ok || class == nil {
+			w.fail("malformed Class constant")
+			return
+		}
+		w.buf.WriteByte(tagClass)
+		w.className(class)
+	case object.ValueException:
+		exc, ok := value.Data.(*object.RException)
+		if !ok || exc == nil {
+			w.fail("malformed exception constant")
+			return
+		}
+		w.buf.WriteByte(tagException)
+		w.string(exc.Message)
+	case object.ValueFunction:
+		fn, ok := value.Data.(*object.Function)
+		if !ok || fn == nil {
+			w.fail("malformed function constant")
+			return
+		}
+		w.buf.WriteByte(tagFunction)
+		w.function(fn)
+	default:
+		w.fail("%s constant", value.TypeName())
+		return
+	}
+	w.className(value.Class)
+	w.bool(value.Frozen)
+	w.bool(value.Chilled)
+	w.bool(value.Literal)
+	w.bool(value.Ruby2Keywords)
+	w.string(value.Encoding)
+}
+
+func (w *bytecodeWriter) function(fn *object.Function) {
+	if index, ok := w.functions[fn]; ok {
+		w.uint(uint64(index) + 1)
+		return
+	}
+	w.functions[fn] = len(w.functions)
+	w.uint(0)
+	if fn.Body != nil || len(fn.FreeVars) > 0 {
+		w.fail("function %q is bound to runtime state", fn.Name)
+	}
+	w.string(fn.Name)
+	w.string(fn.SourcePath)
+	w.string(fn.SourceAbsolutePath)
+	w.bool(fn.EvalSource)
+	w.int(int64(fn.EvalInheritedLocals))
+	w.string(fn.SourceEncoding)
+	w.bool(fn.StringLiteralModeSet)
+	w.bool(fn.FreezeStringLiterals)
+	w.bool(fn.ChillStringLiterals)
+	w.int(fn.DefinitionLine)
+	w.strings(fn.Params)
+	w.ints(fn.ParamLocalIndices)
+	w.bool(fn.ParamPatterns != nil)
+	w.uint(uint64(len(fn.ParamPatterns)))
+	for _, pattern := range fn.ParamPatterns {
+		w.parameterPattern(pattern)
+	}
+	w.valueList(fn.ParamDefaults)
+	w.bool(fn.EvaluateParamDefaults)
+	w.bool(fn.MethodBody)
+	w.bool(fn.SingletonClassBody)
+	w.bool(fn.KeywordParams != nil)
+	w.uint(uint64(len(fn.KeywordParams)))
+	for _, param := range fn.KeywordParams {
+		w.string(param.Name)
+		w.bool(param.HasDefault)
+		w.value(param.Default)
+	}
+	w.strings(fn.BlockLocals)
+	w.bytes(fn.Instructions)
+	w.intMap(fn.LineMap)
+	w.valueList(fn.Constants)
+	w.int(int64(fn.NumLocals))
+	w.nameMap(fn.GlobalNames)
+	w.nameMap(fn.LocalNames)
+	w.strings(fn.FreeVarNames)
+	w.bool(fn.HasRestParam)
+	w.bool(fn.AnonymousRestParam)
+	w.int(int64(fn.RestParamIndex))
+	w.string(fn.RestParamName)
+	w.bool(fn.RejectKeywords)
+	w.bool(fn.SingleDestructure)
+	w.bool(fn.KeywordRestOnly)
+	w.string(fn.KeywordRestParam)
+	w.bool(fn.HasBlockParam)
+	w.bool(fn.AnonymousBlockParam)
+	w.int(int64(fn.BlockParamIndex))
+	w.bool(fn.RejectBlock)
+	w.bool(fn.TrailingCommaParam)
+	w.bool(fn.DefinedByDefineMethod)
+	w.bool(fn.ForLoopCollectAsPair)
+	w.bool(fn.FlipFlopStates != nil)
+	w.uint(uint64(len(fn.FlipFlopStates)))
+	states := make([]int, 0, len(fn.FlipFlopStates))
+	for key := range fn.FlipFlopStates {
+		states = append(states, key)
+	}
+	sort.Ints(states)
+	for _, key := range states {
+		w.int(int64(key))
+		w.bool(fn.FlipFlopStates[key])
+	}
+	w.bool(fn.ImplicitItParameter)
+	w.bool(fn.NumberedParameters)
+	w.string(fn.DefinitionVisibility)
+}
+
+func (w *bytecodeWriter) parameterPattern(pattern *object.ParameterPattern) {
+	w.bool(pattern != nil)
+	if pattern == nil {
+		return
+	}
+	w.string(pattern.Name)
+	w.bool(pattern.Children != nil)
+	w.uint(uint64(len(pattern.Children)))
+	for _, child := range pattern.Children {
+		w.parameterPattern(child)
+	}
+	w.parameterPattern(pattern.Rest)
+	w.int(int64(pattern.RestIndex))
+}
+
+type bytecodeReader struct {
+	data      []byte
+	values    []*object.EmeraldValue
+	functions []*object.Function
+	interning bool
+	interned  []string
+	err       error
+}
+
+func (r *bytecodeReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("corrupt bytecode: "+format, args...)
+	}
+	r.data = nil
+}
+
+func (r *bytecodeReader) next(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		r.fail("unexpected end of data")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *bytecodeReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *bytecodeReader) uint() uint64 {
+	n, size := binary.Uvarint(r.data)
+	if size <= 0 {
+		r.fail("bad varint")
+		return 0
+	}
+	r.data = r.data[size:]
+	return n
+}
+
+func (r *bytecodeReader) int() int64 {
+	n, size := binary.Varint(r.data)
+	if size <= 0 {
+		r.fail("bad varint")
+		return 0
+	}
+	r.data = r.data[size:]
+	return n
+}
+
+// length reads a collection length, refusing counts that cannot possibly fit
+// in the remaining input so a corrupt file cannot trigger a huge allocation.
+func (r *bytecodeReader) length() int {
+	n := r.uint()
+	if n > uint64(len(r.data)) {
+		r.fail("length %d out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *bytecodeReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *bytecodeReader) bytes() []byte {
+	return append([]byte(nil), r.next(r.length())...)
+}
+
+func (r *bytecodeReader) string() string {
+	if r.interning {
+		if ref := r.uint(); ref != 0 {
+			if ref > uint64(len(r.interned)) {
+				r.fail("bad string reference %d", ref)
+				return ""
+			}
+			return r.interned[ref-1]
+		}
+		s := string(r.next(r.length()))
+		r.interned = append(r.interned, s)
+		return s
+	}
+	return string(r.next(r.length()))
+}
+
+func (r *bytecodeReader) strings() []string {
+	present := r.bool()
+	n := r.length()
+	if !present {
+		return nil
+	}
+	list := make([]string, n)
+	for i := range list {
+		list[i] = r.string()
+	}
+	return list
+}
+
+func (r *bytecodeReader) ints() []int {
+	present := r.bool()
+	n := r.length()
+	if !present {
+		return nil
+	}
+	list := make([]int, n)
+	for i := range list {
+		list[i] = int(r.int())
+	}
+	return list
+}
+
+func (r *bytecodeReader) intMap() map[int]int {
+	present := r.bool()
+	n := r.length()
+	if !present {
+		return nil
+	}
+	m := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		key := int(r.int())
+		m[key] = int(r.int())
+	}
+	return m
+}
+
+func (r *bytecodeReader) nameMap() map[string]int {
+	present := r.bool()
+	n := r.length()
+	if !present {
+		return nil
+	}
+	m := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		key := r.string()
+		m[key] = int(r.int())
+	}
+	return m
+}
+
+func (r *bytecodeReader) valueList() []*object.EmeraldValue {
+	present := r.bool()
+	n := r.length()
+	if !present {
+		return nil
+	}
+	list := make([]*object.EmeraldValue, n)
+	for i := range list {
+		list[i] = r.value()
+	}
+	return list
+}
+
+func (r *bytecodeReader) class() *object.Class {
+	name := r.string()
+	if name == "" {
+		return nil
+	}
+	class := core.R.Classes[name]
+	if class == nil {
+		r.fail("unknown class %q", name)
+	}
+	return class
+}
+
+func (r *bytecodeReader) value() *object.EmeraldValue {
+	tag := r.byte()
+	switch tag {
+	case tagNil:
+		return nil
+	case tagNilValue:
+		return core.R.NilVal
+	case tagTrue:
+		return core.R.TrueVal
+	case tagFalse:
+		return core.R.FalseVal
+	case tagRef:
+		index := r.uint()
+		if index >= uint64(len(r.values)) {
+			r.fail("bad value reference %d", index)
+			return nil
+		}
+		return r.values[index]
+	}
+
+	value := &object.EmeraldValue{}
+	r.values = append(r.values, value)
+	switch tag {
+	case tagInteger:
+		value.Type = object.ValueInteger
+		value.Data = r.int()
+	case tagBigInteger:
+		value.Type = object.ValueInteger
+		n, ok := new(big.Int).SetString(string(r.bytes()), 16)
+		if !ok {
+			r.fail("bad integer constant")
+			return nil
+		}
+		value.Data = n.Int64()
+		core.RememberNumericBigIntOverride(value, n)
+		core.RememberULEBPackOverride(value, r.bytes())
+		if hasApproximation, approximation := r.bool(), math.Float64frombits(r.uint()); hasApproximation {
+			core.RememberNumericFloatOverride(value, approximation)
+		}
+	case tagFloat:
+		value.Type = object.ValueFloat
+		value.Data = math.Float64frombits(r.uint())
+	case tagString:
+		value.Type = object.ValueString
+		value.Data = r.string()
+	case tagSymbol:
+		value.Type = object.ValueSymbol
+		value.Data = r.string()
+	case tagRegexp:
+		re := &object.RRegexp{Pattern: r.string(), Options: r.string(), HasTimeout: r.bool()}
+		re.Timeout = math.Float64frombits(r.uint())
+		value.Type = object.ValueRegexp
+		value.Data = re
+	case tagArray:
+		value.Type = object.ValueArray
+		value.Data = r.valueList()
+	case tagClass:
+		value.Type = object.ValueClass
+		value.Data = r.class()
+	case tagException:
+		value.Type = object.ValueException
+		value.Data = &object.RException{Message: r.string()}
+	case tagFunction:
+		value.Type = object.ValueFunction
+		value.Data = r.function()
+	default:
+		r.fail("unknown constant tag %d", tag)
+		return nil
+	}
+	value.Class = r.class()
+	value.Frozen = r.bool()
+	value.Chilled = r.bool()
+	value.Literal = r.bool()
+	value.Ruby2Keywords = r.bool()
+	value.Encoding = r.string()
+	return value
+}
+
+func (r *bytecodeReader) function() *object.Function {
+	if ref := r.uint(); ref != 0 {
+		if ref > uint64(len(r.functions)) {
+			r.fail("bad function reference %d", ref)
+			return nil
+		}
+		return r.functions[ref-1]
+	}
+	fn := &object.Function{}
+	r.functions = append(r.functions, fn)
+	fn.Name = r.string()
+	fn.SourcePath = r.string()
+	fn.SourceAbsolutePath = r.string()
+	fn.EvalSource = r.bool()
+	fn.EvalInheritedLocals = int(r.int())
+	fn.SourceEncoding = r.string()
+	fn.StringLiteralModeSet = r.bool()
+	fn.FreezeStringLiterals = r.bool()
+	fn.ChillStringLiterals = r.bool()
+	fn.DefinitionLine = r.int()
+	fn.Params = r.strings()
+	fn.ParamLocalIndices = r.ints()
+	if present, n := r.bool(), r.length(); present {
+		fn.ParamPatterns = make([]*object.ParameterPattern, n)
+		for i := range fn.ParamPatterns {
+			fn.ParamPatterns[i] = r.parameterPattern()
+		}
+	}
+	fn.ParamDefaults = r.valueList()
+	fn.EvaluateParamDefaults = r.bool()
+	fn.MethodBody = r.bool()
+	fn.SingletonClassBody = r.bool()
+	if present, n := r.bool(), r.length(); present {
+		fn.KeywordParams = make([]object.KeywordParamInfo, n)
+		for i := range fn.KeywordParams {
+			fn.KeywordParams[i] = object.KeywordParamInfo{Name: r.string(), HasDefault: r.bool(), Default: r.value()}
+		}
+	}
+	fn.BlockLocals = r.strings()
+	fn.Instructions = r.bytes()
+	fn.LineMap = r.intMap()
+	fn.Constants = r.valueList()
+	fn.NumLocals = int(r.int())
+	fn.GlobalNames = r.nameMap()
+	fn.LocalNames = r.nameMap()
+	fn.FreeVarNames = r.strings()
+	fn.HasRestParam = r.bool()
+	fn.AnonymousRestParam = r.bool()
+	fn.RestParamIndex = int(r.int())
+	fn.RestParamName = r.string()
+	fn.RejectKeywords = r.bool()
+	fn.SingleDestructure = r.bool()
+	fn.KeywordRestOnly = r.bool()
+	fn.KeywordRestParam = r.string()
+	fn.HasBlockParam = r.bool()
+	fn.AnonymousBlockParam = r.bool()
+	fn.BlockParamIndex = int(r.int())
+	fn.RejectBlock = r.bool()
+	fn.TrailingCommaParam = r.bool()
+	fn.DefinedByDefineMethod = r.bool()
+	fn.ForLoopCollectAsPair = r.bool()
+	if present, n := r.bool(), r.length(); present {
+		fn.FlipFlopStates = make(map[int]bool, n)
+		for i := 0; i < n; i++ {
+			key := int(r.int())
+			fn.FlipFlopStates[key] = r.bool()
+		}
+	}
+	fn.ImplicitItParameter = r.bool()
+	fn.NumberedParameters = r.bool()
+	fn.DefinitionVisibility = r.string()
+	return fn
+}
+
+func (r *bytecodeReader) parameterPattern() *object.ParameterPattern {
+	if !r.bool() {
+		return nil
+	}
+	pattern := &object.ParameterPattern{Name: r.string()}
+	if present, n := r.bool(), r.length(); present {
+		pattern.Children = make([]*object.ParameterPattern, n)
+		for i := range pattern.Children {
+			pattern.Children[i] = r.parameterPattern()
+		}
+	}
+	pattern.Rest = r.parameterPattern()
+	pattern.RestIndex = int(r.int())
+	return pattern
+}
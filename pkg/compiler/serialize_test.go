@@ -0,0 +1,52 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+func TestMarshalBytecodeRoundTrip(t *testing.T) {
+	bc := compile(t, `
+BIG = 0xffff_ffff_ffff_ffff_ff
+def run(x, (a, b), *rest, k: 2, **opts, &blk)
+  [x, a, b, rest, k, opts, /a(b+)c/i, 1r/3, 2.5, :sym, "str", %w[a b]]
+end
+[1, 2].each { |i| puts i if (i == 1)..(i == 2) }
+`)
+	digest := SourceDigest("source", "path.rb")
+	data, err := MarshalBytecode(bc, digest)
+	if err != nil {
+		t.Fatalf("MarshalBytecode: %v", err)
+	}
+	decoded, err := UnmarshalBytecode(data, digest)
+	if err != nil {
+		t.Fatalf("UnmarshalBytecode: %v", err)
+	}
+	again, err := MarshalBytecode(decoded, digest)
+	if err != nil {
+		t.Fatalf("MarshalBytecode after decode: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Fatalf("round trip changed the encoding")
+	}
+
+	var fn *object.Function
+	for _, constant := range decoded.Constants {
+		if f, ok := constant.Data.(*object.Function); ok && f.Name == "run" {
+			fn = f
+		}
+	}
+	if fn == nil || !fn.HasRestParam || fn.KeywordRestParam != "opts" || len(fn.KeywordParams) != 1 || fn.ParamPatterns[1] == nil {
+		t.Fatalf("decoded method metadata = %#v", fn)
+	}
+
+	if _, err := UnmarshalBytecode(data, SourceDigest("edited", "path.rb")); !errors.Is(err, ErrStaleBytecode) {
+		t.Fatalf("digest mismatch error = %v, want ErrStaleBytecode", err)
+	}
+	if _, err := UnmarshalBytecode(data[:len(data)-3], digest); err == nil {
+		t.Fatalf("truncated bytecode decoded without error")
+	}
+}
@@ -1,13 +1,19 @@
 package core
 
 import (
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"math"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/GoLangDream/rgo/pkg/lexer"
 	"github.com/GoLangDream/rgo/pkg/object"
 )
 
@@ -1215,3 +1221,129 @@ func TestStringScannerRegexpSharesCompiledPattern(t *testing.T) {
 		t.Fatal("equivalent StringScanner regexps did not share the compiled regexp")
 	}
 }
+
+// TestRuntimeStateCoversInitRuntime keeps CaptureRuntimeState in step with
+// initRuntime: every package-level variable initRuntime resets is per
+// interpreter and must be part of the snapshot, or embedded interpreters
+// would share it.
+func TestRuntimeStateCoversInitRuntime(t *testing.T) {
+	// Caches keyed by immutable data and facts about the process itself are
+	// shared on purpose.
+	shared := map[string]bool{
+		"classInitializeCache":   true,
+		"rubyRegexpCompileCache": true,
+		"rgoExecutablePath":      true,
+	}
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	packageVars := map[string]bool{}
+	var initFunc *ast.FuncDecl
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				if decl.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					for _, ident := range spec.(*ast.ValueSpec).Names {
+						packageVars[ident.Name] = true
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil && decl.Name.Name == "initRuntime" {
+					initFunc = decl
+				}
+			}
+		}
+	}
+	if initFunc == nil {
+		t.Fatal("initRuntime not found")
+	}
+	fields := map[string]bool{}
+	stateType := reflect.TypeOf(RuntimeState{})
+	for index := 0; index < stateType.NumField(); index++ {
+		fields[stateType.Field(index).Name] = true
+	}
+	ast.Inspect(initFunc.Body, func(node ast.Node) bool {
+		assign, ok := node.(*ast.AssignStmt)
+		if !ok || assign.Tok != token.ASSIGN {
+			return true
+		}
+		for _, lhs := range assign.Lhs {
+			ident, ok := lhs.(*ast.Ident)
+			if ok && packageVars[ident.Name] && !shared[ident.Name] && !fields[ident.Name] {
+				t.Errorf("initRuntime resets %s but RuntimeState does not capture it", ident.Name)
+				fields[ident.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+// TestRipperScannerAgreesWithLexer checks that Ripper's scanner and the
+// parser's lexer split source at the same places: every token pkg/lexer
+// produces starts and ends where a Ripper token does. Ripper keeps a label's
+// colon, a comment's newline and an embedded document whole, so boundaries
+// inside those tokens are not compared.
+func TestRipperScannerAgreesWithLexer(t *testing.T) {
+	sources := []string{
+		"def add(a, b = 2)\n  a + b # sum\nend\n",
+		"def self.build(*args, **opts, &blk) = new(*args)\n",
+		"x = \"hi #{name}!\"\nputs x.upcase if x\n",
+		"items.each do |i|\n  puts i * 2\nend\n",
+		"h = { a: 1, :b => 2 }\nh[:a] += 1\nfoo(a: 1, 'b': 2)\n",
+		"text = <<~EOS\n  body #{x}\nEOS\nputs text\n",
+		"a = %w[x y]\nb = /re+/i\nc = :sym\nd = ?c\n$stdout.puts(@x, @@y)\n",
+		"f = ->(x) { x ** 2 }\nf.(3) <=> 1.5\n",
+		"user&.name || 'anon'\nx ? y : z\n",
+		"n = 1_000 + 0x1F + 1e3 + 2r + 3i\n",
+		"sym = :\"quoted #{x}\"\nputs $1, $stdout\n",
+		"s = 'it\\'s' + \"tab\\t\"\n",
+		"r = %r{a/#{b}}x\nw = %i[a b]\n",
+		"result = list\n  .map { |v| v * 2 }\n  .select(&:even?)\n",
+		"case x\nwhen 1..2 then :a\nelse :b\nend\n",
+		"a, *b = [1, 2, 3]\n@x ||= {}\n",
+		"begin\n  raise ArgumentError, \"bad\"\nrescue => e\n  retry\nensure\n  p e\nend\n",
+		"x = y unless z\nwhile i < 3 do i += 1 end\n",
+		"=begin\ndoc\n=end\nx = 1\n__END__\ndata\n",
+	}
+	for _, source := range sources {
+		tokens, _ := ripperScan(source, 1)
+		starts, ends := map[int]bool{}, map[int]bool{}
+		for _, tok := range tokens {
+			starts[tok.Offset] = true
+			ends[tok.Offset+len(tok.Text)] = true
+		}
+		whole := func(offset int) bool {
+			for _, tok := range tokens {
+				switch tok.Event {
+				case "label", "label_end", "comment", "embdoc_beg", "embdoc", "embdoc_end":
+					if tok.Offset < offset && offset < tok.Offset+len(tok.Text) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		l := lexer.New(source)
+		for tok := l.NextToken(); tok.Type != lexer.EOF; tok = l.NextToken() {
+			if tok.EndOffset <= tok.Offset {
+				continue
+			}
+			if !starts[tok.Offset] && !whole(tok.Offset) || !ends[tok.EndOffset] && !whole(tok.EndOffset) {
+				t.Errorf("%q: lexer token %s %q at %d-%d does not line up with Ripper's tokens", source, tok.Type, source[tok.Offset:tok.EndOffset], tok.Offset, tok.EndOffset)
+			}
+		}
+	}
+}
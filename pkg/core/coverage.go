@@ -0,0 +1,569 @@
+package core
+
+import (
+	"os"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// Coverage measurement lives in the bytecode: while the Coverage module is
+// set up, the compiler asks NewCoverageSource for each file it compiles and
+// emits an OpCoverage counter at every statement line, branch target and
+// method body registered there. The VM bumps the counters as they run, and
+// Coverage.result reads them back in MRI's format.
+
+type coverageState int
+
+const (
+	coverageIdle coverageState = iota
+	coverageSuspended
+	coverageRunning
+)
+
+const (
+	coverageLines = 1 << iota
+	coverageBranches
+	coverageMethods
+	coverageOneshotLines
+	coverageEval
+)
+
+// coverageMeasurement is the state behind the Coverage module. Counter ids
+// are never reused: a measurement numbers its counters from base, so the
+// counters left in code compiled for an earlier measurement count nothing.
+type coverageMeasurement struct {
+	state    coverageState
+	mode     int
+	compat   bool
+	base     int
+	counters []coverageCounter
+	files    []*coverageFile
+}
+
+type coverageCounter struct {
+	count  int64
+	file   *coverageFile
+	line   int
+	method *coverageMethod
+}
+
+// coverageFile holds the counters of one file. lines maps each line to its
+// counter, or -1 for a line without a statement. Branch structures and
+// their targets share one sequence of ids, as in MRI.
+type coverageFile struct {
+	path     string
+	lines    []int
+	oneshot  []*object.EmeraldValue
+	branches []*CoverageBranch
+	methods  []*coverageMethod
+	nextID   int
+}
+
+type coverageMethod struct {
+	name    string
+	loc     ast.Range
+	counter int
+	owner   *object.EmeraldValue
+}
+
+var coverage coverageMeasurement
+
+// CoverageSource registers the counters of a file being compiled. A nil
+// source registers nothing, so the compiler can call it unconditionally.
+type CoverageSource struct {
+	file       *coverageFile
+	lineOffset int
+	statements map[ast.Node]int
+	branches   map[ast.Node]*CoverageBranch
+	methods    map[ast.Node]int
+}
+
+// CoverageBranch is a branching construct registered with a CoverageSource.
+type CoverageBranch struct {
+	source  *CoverageSource
+	kind    string
+	id      int
+	loc     ast.Range
+	targets []coverageTarget
+}
+
+type coverageTarget struct {
+	node    ast.Node
+	kind    string
+	id      int
+	loc     ast.Range
+	counter int
+}
+
+// NewCoverageSource returns the source to compile program, the contents of
+// path, with, or nil when coverage is not set up. The first line of an eval
+// is firstLine; evals are measured only in eval mode, and add to the
+// counters of an earlier file with the same path.
+func NewCoverageSource(path, source string, program *ast.Program, firstLine int, eval bool) *CoverageSource {
+	if coverage.state == coverageIdle || program == nil || eval && coverage.mode&coverageEval == 0 {
+		return nil
+	}
+	if firstLine < 1 {
+		firstLine = 1
+	}
+	var file *coverageFile
+	if eval {
+		for _, existing := range coverage.files {
+			if existing.path == path {
+				file = existing
+			}
+		}
+	}
+	if file == nil {
+		file = &coverageFile{path: path}
+		replaced := false
+		for index, existing := range coverage.files {
+			if existing.path == path {
+				coverage.files[index], replaced = file, true
+			}
+		}
+		if !replaced {
+			coverage.files = append(coverage.files, file)
+		}
+	}
+	s := &CoverageSource{
+		file:       file,
+		lineOffset: firstLine - 1,
+		statements: make(map[ast.Node]int),
+		branches:   make(map[ast.Node]*CoverageBranch),
+		methods:    make(map[ast.Node]int),
+	}
+	for len(file.lines) < s.lineOffset+coverageLineCount(source) {
+		file.lines = append(file.lines, -1)
+	}
+	if coverage.mode&coverageLines != 0 {
+		for node, line := range coverageStatements(program) {
+			line += s.lineOffset
+			for len(file.lines) < line {
+				file.lines = append(file.lines, -1)
+			}
+			if file.lines[line-1] < 0 {
+				file.lines[line-1] = coverageNewCounter(coverageCounter{file: file, line: line})
+			}
+			s.statements[node] = line
+		}
+	}
+	return s
+}
+
+// coverageStatements maps the statements of program to their first lines.
+// An expression statement is keyed by its expression, which is what the
+// compiler compiles. Of several statements starting on one line of a
+// statement list only the first is kept, so the line counts once.
+func coverageStatements(program *ast.Program) map[ast.Node]int {
+	statements := make(map[ast.Node]int)
+	add := func(list []ast.Statement) {
+		seen := make(map[int]bool)
+		for _, statement := range list {
+			var node ast.Node = statement
+			if expression, ok := statement.(*ast.ExpressionStatement); ok && expression.Expression != nil {
+				node = expression.Expression
+			}
+			line := node.SourceRange().Start.Line
+			if line <= 0 || seen[line] {
+				continue
+			}
+			seen[line] = true
+			statements[node] = line
+		}
+	}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Program:
+			add(node.Statements)
+		case *ast.BlockExpression:
+			add(node.Statements)
+		}
+		return true
+	})
+	return statements
+}
+
+func coverageLineCount(source string) int {
+	count := strings.Count(source, "\n")
+	if source != "" && !strings.HasSuffix(source, "\n") {
+		count++
+	}
+	return count
+}
+
+func coverageNewCounter(counter coverageCounter) int {
+	coverage.counters = append(coverage.counters, counter)
+	return coverage.base + len(coverage.counters) - 1
+}
+
+func (s *CoverageSource) shift(loc ast.Range) ast.Range {
+	loc.Start.Line += s.lineOffset
+	loc.End.Line += s.lineOffset
+	return loc
+}
+
+// Statement returns the line counter to bump before node runs and the
+// line it counts, or -1 when node does not start a statement.
+func (s *CoverageSource) Statement(node ast.Node) (counter, line int) {
+	if s == nil {
+		return -1, 0
+	}
+	line, ok := s.statements[node]
+	if !ok {
+		return -1, 0
+	}
+	return s.file.lines[line-1], line
+}
+
+// Branch registers the branching construct node, of the given kind (:if,
+// :unless, :case, :while, :until or :"&."), spanning loc. It returns nil
+// unless branches are measured.
+func (s *CoverageSource) Branch(kind string, node ast.Node, loc ast.Range) *CoverageBranch {
+	if s == nil || coverage.mode&coverageBranches == 0 {
+		return nil
+	}
+	if branch := s.branches[node]; branch != nil {
+		return branch
+	}
+	branch := &CoverageBranch{source: s, kind: kind, id: s.file.nextID, loc: s.shift(loc)}
+	s.file.nextID++
+	s.file.branches = append(s.file.branches, branch)
+	s.branches[node] = branch
+	return branch
+}
+
+// Target registers a target of the branch, keyed by node and spanning
+// loc, and returns its counter, or -1 for a nil branch.
+func (b *CoverageBranch) Target(kind string, node ast.Node, loc ast.Range) int {
+	if b == nil {
+		return -1
+	}
+	for _, target := range b.targets {
+		if target.kind == kind && target.node == node {
+			return target.counter
+		}
+	}
+	target := coverageTarget{node: node, kind: kind, id: b.source.file.nextID, loc: b.source.shift(loc), counter: coverageNewCounter(coverageCounter{file: b.source.file})}
+	b.source.file.nextID++
+	b.targets = append(b.targets, target)
+	return target.counter
+}
+
+// Method registers the method defined by node and returns the counter its
+// body bumps on entry, or -1 unless methods are measured.
+func (s *CoverageSource) Method(name string, node ast.Node, loc ast.Range) int {
+	if s == nil || coverage.mode&coverageMethods == 0 {
+		return -1
+	}
+	if counter, ok := s.methods[node]; ok {
+		return counter
+	}
+	method := &coverageMethod{name: name, loc: s.shift(loc)}
+	method.counter = coverageNewCounter(coverageCounter{file: s.file, method: method})
+	s.file.methods = append(s.file.methods, method)
+	s.methods[node] = method.counter
+	return method.counter
+}
+
+// CoverageActive reports whether coverage is set up, running or suspended.
+// Code compiled meanwhile carries counters, so cached bytecode is not used.
+func CoverageActive() bool {
+	return coverage.state != coverageIdle
+}
+
+// CoverCounter bumps a counter emitted by the compiler.
+func CoverCounter(id int) {
+	index := id - coverage.base
+	if coverage.state != coverageRunning || index < 0 || index >= len(coverage.counters) {
+		return
+	}
+	counter := &coverage.counters[index]
+	if counter.line > 0 && coverage.mode&coverageOneshotLines != 0 {
+		if counter.count == 0 {
+			counter.count = 1
+			counter.file.oneshot = append(counter.file.oneshot, NewIntegerValue(int64(counter.line)))
+		}
+		return
+	}
+	counter.count++
+}
+
+// CoverMethodDefined records owner as the class of the method whose body
+// starts with counter id.
+func CoverMethodDefined(id int, owner *object.EmeraldValue) {
+	index := id - coverage.base
+	if index < 0 || index >= len(coverage.counters) || coverage.counters[index].method == nil {
+		return
+	}
+	coverage.counters[index].method.owner = owner
+}
+
+func installCoverageModule(objectClass *object.Class) {
+	if objectClass == nil {
+		return
+	}
+	if existing, ok := objectClass.Constants["Coverage"]; ok && existing != nil && existing.Type == object.ValueModule {
+		return
+	}
+	mod := object.NewModule("Coverage")
+	mod.DefineMethod("supported?", &object.Method{Name: "supported?", Fn: coverageSupported, Arity: 1})
+	mod.DefineMethod("setup", &object.Method{Name: "setup", Fn: coverageSetup, Arity: -1})
+	mod.DefineMethod("start", &object.Method{Name: "start", Fn: coverageStart, Arity: -1})
+	mod.DefineMethod("resume", &object.Method{Name: "resume", Fn: coverageResume, Arity: 0})
+	mod.DefineMethod("suspend", &object.Method{Name: "suspend", Fn: coverageSuspend, Arity: 0})
+	mod.DefineMethod("state", &object.Method{Name: "state", Fn: coverageStatePredicate, Arity: 0})
+	mod.DefineMethod("running?", &object.Method{Name: "running?", Fn: coverageRunningPredicate, Arity: 0})
+	mod.DefineMethod("result", &object.Method{Name: "result", Fn: coverageResult, Arity: -1})
+	mod.DefineMethod("peek_result", &object.Method{Name: "peek_result", Fn: coveragePeekResult, Arity: 0})
+	mod.DefineMethod("line_stub", &object.Method{Name: "line_stub", Fn: coverageLineStub, Arity: 1})
+	modValue := &object.EmeraldValue{Type: object.ValueModule, Data: mod, Class: R.Classes["Module"]}
+	objectClass.DefineConstant("Coverage", modValue)
+	AssignConstantName(&object.EmeraldValue{Type: object.ValueClass, Data: objectClass, Class: R.Classes["Class"]}, "Coverage", modValue)
+}
+
+func coverageSetup(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if coverage.state != coverageIdle {
+		return NewRuntimeError("coverage measurement is already setup")
+	}
+	mode, compat := 0, len(args) == 0
+	if len(args) > 0 {
+		option := args[0]
+		switch {
+		case option != nil && option.Type == object.ValueSymbol && option.Data.(string) == "all":
+			mode = coverageLines | coverageBranches | coverageMethods | coverageEval
+		case option == nil || option.Type != object.ValueHash:
+			return NewTypeError("no implicit conversion of " + valueTypeName(option) + " into Hash")
+		default:
+			options := valueToHashMap(option)
+			enabled := func(name string) bool {
+				value, present := hashLookup(options, rubySymbol(name))
+				return present && value.IsTruthy()
+			}
+			if enabled("lines") {
+				mode |= coverageLines
+			}
+			if enabled("branches") {
+				mode |= coverageBranches
+			}
+			if enabled("methods") {
+				mode |= coverageMethods
+			}
+			if enabled("oneshot_lines") {
+				if mode&coverageLines != 0 {
+					return NewRuntimeError("cannot enable lines and oneshot_lines simultaneously")
+				}
+				mode |= coverageLines | coverageOneshotLines
+			}
+			if enabled("eval") {
+				mode |= coverageEval
+			}
+			compat = mode == 0
+		}
+	}
+	if compat {
+		mode = coverageLines
+	}
+	coverage = coverageMeasurement{state: coverageSuspended, mode: mode, compat: compat, base: coverage.base + len(coverage.counters)}
+	return R.NilVal
+}
+
+func coverageStart(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if result := coverageSetup(receiver, args...); result != nil && result.Type == object.ValueException {
+		return result
+	}
+	coverage.state = coverageRunning
+	return R.NilVal
+}
+
+func coverageResume(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	switch coverage.state {
+	case coverageIdle:
+		return NewRuntimeError("coverage measurement is not set up yet")
+	case coverageRunning:
+		return NewRuntimeError("coverage measurement is already running")
+	}
+	coverage.state = coverageRunning
+	return R.NilVal
+}
+
+func coverageSuspend(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if coverage.state != coverageRunning {
+		return NewRuntimeError("coverage measurement is not running")
+	}
+	coverage.state = coverageSuspended
+	return R.NilVal
+}
+
+func coverageStatePredicate(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	switch coverage.state {
+	case coverageRunning:
+		return rubySymbol("running")
+	case coverageSuspended:
+		return rubySymbol("suspended")
+	}
+	return rubySymbol("idle")
+}
+
+func coverageRunningPredicate(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if coverage.state == coverageRunning {
+		return R.TrueVal
+	}
+	return R.FalseVal
+}
+
+// coverageResult implements Coverage.result(stop: true, clear: true).
+// Stopping clears the counters too.
+func coverageResult(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if coverage.state == coverageIdle {
+		return NewRuntimeError("coverage measurement is not enabled")
+	}
+	stop, clear := true, true
+	if len(args) > 0 {
+		last := args[len(args)-1]
+		if last != nil && last.Type == object.ValueHash {
+			options := valueToHashMap(last)
+			if value, present := hashLookup(options, rubySymbol("stop")); present {
+				stop = value.IsTruthy()
+			}
+			if value, present := hashLookup(options, rubySymbol("clear")); present {
+				clear = value.IsTruthy()
+			}
+		}
+	}
+	result := coverageResultHash()
+	if stop {
+		coverage = coverageMeasurement{base: coverage.base + len(coverage.counters)}
+	} else if clear {
+		coverageClear()
+	}
+	return result
+}
+
+func coveragePeekResult(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if coverage.state == coverageIdle {
+		return NewRuntimeError("coverage measurement is not enabled")
+	}
+	return coverageResultHash()
+}
+
+// coverageClear zeroes the counters. A oneshot line that already ran keeps
+// its count, so it is not reported again.
+func coverageClear() {
+	for index := range coverage.counters {
+		counter := &coverage.counters[index]
+		if counter.line == 0 || coverage.mode&coverageOneshotLines == 0 {
+			counter.count = 0
+		}
+	}
+	for _, file := range coverage.files {
+		file.oneshot = nil
+	}
+}
+
+func coverageCount(id int) *object.EmeraldValue {
+	return NewIntegerValue(coverage.counters[id-coverage.base].count)
+}
+
+func coverageArray(values ...*object.EmeraldValue) *object.EmeraldValue {
+	return &object.EmeraldValue{Type: object.ValueArray, Data: values, Class: R.Classes["Array"]}
+}
+
+func coverageLocation(kind string, id int, loc ast.Range) *object.EmeraldValue {
+	return coverageArray(rubySymbol(kind), NewIntegerValue(int64(id)),
+		NewIntegerValue(int64(loc.Start.Line)), NewIntegerValue(int64(loc.Start.Column)),
+		NewIntegerValue(int64(loc.End.Line)), NewIntegerValue(int64(loc.End.Column)))
+}
+
+func coverageResultHash() *object.EmeraldValue {
+	result := emptyHashValue()
+	for _, file := range coverage.files {
+		lines := make([]*object.EmeraldValue, len(file.lines))
+		for index, counter := range file.lines {
+			lines[index] = R.NilVal
+			if counter >= 0 {
+				lines[index] = coverageCount(counter)
+			}
+		}
+		if coverage.compat {
+			hashIndexSet(result, rubyString(file.path), coverageArray(lines...))
+			continue
+		}
+		entry := emptyHashValue()
+		switch {
+		case coverage.mode&coverageOneshotLines != 0:
+			hashIndexSet(entry, rubySymbol("oneshot_lines"), coverageArray(append([]*object.EmeraldValue(nil), file.oneshot...)...))
+		case coverage.mode&coverageLines != 0:
+			hashIndexSet(entry, rubySymbol("lines"), coverageArray(lines...))
+		}
+		if coverage.mode&coverageBranches != 0 {
+			branches := emptyHashValue()
+			for _, branch := range file.branches {
+				targets := emptyHashValue()
+				for _, target := range branch.targets {
+					hashIndexSet(targets, coverageLocation(target.kind, target.id, target.loc), coverageCount(target.counter))
+				}
+				hashIndexSet(branches, coverageLocation(branch.kind, branch.id, branch.loc), targets)
+			}
+			hashIndexSet(entry, rubySymbol("branches"), branches)
+		}
+		if coverage.mode&coverageMethods != 0 {
+			methods := emptyHashValue()
+			for _, method := range file.methods {
+				if method.owner == nil {
+					continue
+				}
+				key := coverageArray(method.owner, rubySymbol(method.name),
+					NewIntegerValue(int64(method.loc.Start.Line)), NewIntegerValue(int64(method.loc.Start.Column)),
+					NewIntegerValue(int64(method.loc.End.Line)), NewIntegerValue(int64(method.loc.End.Column)))
+				hashIndexSet(methods, key, coverageCount(method.counter))
+			}
+			hashIndexSet(entry, rubySymbol("methods"), methods)
+		}
+		hashIndexSet(result, rubyString(file.path), entry)
+	}
+	return result
+}
+
+// coverageLineStub implements Coverage.line_stub(path): the lines array of
+// a file that never ran, with 0 for the lines holding a statement.
+func coverageLineStub(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if len(args) == 0 || args[0] == nil || args[0].Type != object.ValueString {
+		return NewTypeError("no implicit conversion of " + valueTypeName(firstArg(args)) + " into String")
+	}
+	if denied := sandboxDenied(sandboxFileSystem, "Coverage.line_stub"); denied != nil {
+		return denied
+	}
+	content, err := os.ReadFile(valueToStringValue(args[0]))
+	if err != nil {
+		return errnoForPathError(err)
+	}
+	source := string(content)
+	lines := make([]*object.EmeraldValue, coverageLineCount(source))
+	for index := range lines {
+		lines[index] = R.NilVal
+	}
+	for _, line := range coverageStatements(parser.New(lexer.New(source)).ParseProgram()) {
+		for len(lines) < line {
+			lines = append(lines, R.NilVal)
+		}
+		lines[line-1] = NewIntegerValue(0)
+	}
+	return coverageArray(lines...)
+}
+
+func coverageSupported(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if len(args) == 0 || args[0] == nil || args[0].Type != object.ValueSymbol {
+		return NewTypeError("wrong argument type " + valueTypeName(firstArg(args)) + " (expected Symbol)")
+	}
+	switch args[0].Data.(string) {
+	case "lines", "oneshot_lines", "branches", "methods", "eval":
+		return R.TrueVal
+	default:
+		return R.FalseVal
+	}
+}
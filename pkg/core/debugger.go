@@ -0,0 +1,81 @@
+package core
+
+import (
+	"os"
+
+	"github.com/GoLangDream/rgo/pkg/debugger"
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// activeDebugger is the debugger `rgo debug`, binding.break or
+// Kernel#debugger attached. While one is attached the interpreter reports
+// every new line to it.
+var activeDebugger *debugger.Debugger
+
+// DebugBreak stops the program at the current frame on d. It is set by the
+// VM.
+var DebugBreak func(d *debugger.Debugger)
+
+// ActiveDebugger returns the attached debugger, or nil.
+func ActiveDebugger() *debugger.Debugger {
+	return activeDebugger
+}
+
+// StartDebugger attaches d and defines binding.break and Kernel#debugger.
+func StartDebugger(d *debugger.Debugger) {
+	activeDebugger = d
+	installDebugMethods()
+}
+
+// StopDebugger detaches the debugger.
+func StopDebugger() {
+	activeDebugger = nil
+}
+
+// installDebugMethods defines the debug gem's entry points, for
+// `require "debug"` and for programs run by `rgo debug`.
+func installDebugMethods() {
+	objectClass := R.Classes["Object"]
+	if objectClass == nil {
+		return
+	}
+	if _, ok := objectClass.Methods["debugger"]; ok {
+		return
+	}
+	objectClass.DefineMethod("debugger", &object.Method{Name: "debugger", Fn: kernelDebugger, Arity: -1})
+	if bindingClass := R.Classes["Binding"]; bindingClass != nil {
+		bindingClass.DefineMethod("break", &object.Method{Name: "break", Fn: kernelDebugger, Arity: -1})
+		bindingClass.DefineMethod("b", &object.Method{Name: "b", Fn: kernelDebugger, Arity: -1})
+	}
+}
+
+// kernelDebugger stops the program where it is called. Without an attached
+// debugger it starts a console session on the terminal, as the debug gem
+// does.
+func kernelDebugger(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if activeDebugger == nil {
+		StartDebugger(debugger.New(debugger.NewConsole(os.Stdin, os.Stdout)))
+	}
+	if DebugBreak != nil {
+		DebugBreak(activeDebugger)
+	}
+	return R.NilVal
+}
+
+// BindingLocals returns the local variables Binding#local_variables lists
+// for binding and their values.
+func BindingLocals(binding *object.RBinding) ([]string, []*object.EmeraldValue) {
+	value := &object.EmeraldValue{Type: object.ValueBinding, Data: binding, Class: R.Classes["Binding"]}
+	list, _ := bindingLocalVariables(value).Data.([]*object.EmeraldValue)
+	names := make([]string, 0, len(list))
+	values := make([]*object.EmeraldValue, 0, len(list))
+	for _, symbol := range list {
+		local := bindingLocalVariableGet(value, symbol)
+		if local == nil || local.Type == object.ValueException {
+			continue
+		}
+		names = append(names, symbol.Data.(string))
+		values = append(values, local)
+	}
+	return names, values
+}
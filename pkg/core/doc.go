@@ -0,0 +1,14 @@
+// Package core implements the rgo runtime's object model and Ruby's built-in
+// classes and modules.
+//
+// Ripper has a scanner of its own (ripper_scanner.go) rather than replaying
+// pkg/lexer. Ripper.lex and Ripper.tokenize account for every byte of the
+// source: spaces, comments, line continuations and each piece of a string
+// literal are events of their own, and every token carries the EXPR_* state
+// MRI's lexer is in after it. pkg/lexer produces what the parser needs: it
+// skips whitespace, reads a string literal and its interpolations as one
+// token and keeps no EXPR_* state, so building Ripper on it would mean a
+// second state machine and sub-token positions on the parser's hot path.
+// TestRipperScannerAgreesWithLexer keeps the two in step by checking that
+// every token pkg/lexer produces starts and ends on a Ripper token boundary.
+package core
@@ -455,7 +455,12 @@ var CurrentBacktraceFrames func() []object.RBacktraceLocation
 var ThreadBacktraceFrames func(thread *object.EmeraldValue) []object.RBacktraceLocation
 
 var EvalSource func(source string) *object.EmeraldValue
+
+// EvalFileSource evaluates the contents of CurrentSpecFile for require and
+// load; unlike EvalSource it may reuse cached bytecode.
+var EvalFileSource func(source string) *object.EmeraldValue
 var EvalSourceWithBinding func(source string, binding *object.RBinding) *object.EmeraldValue
+var InteractiveSession func(binding *object.RBinding) *object.EmeraldValue
 var CurrentEvalSourceEncoding string
 var CurrentEvalSource bool
 
@@ -524,8 +529,9 @@ var symbolNameStrings map[string]*object.EmeraldValue
 var constantNameEncodings map[string]string
 var encodingValues map[string]*object.EmeraldValue
 var etcGroupIterating bool
-var coverageRunning bool
-var syslogState struct {
+
+// syslogSettings is the Syslog module's open/close state.
+type syslogSettings struct {
 	opened   bool
 	inBlock  bool
 	ident    string
@@ -533,6 +539,8 @@ var syslogState struct {
 	facility int64
 	mask     int64
 }
+
+var syslogState syslogSettings
 var packedPointerStrings map[*object.EmeraldValue]string
 var berPackOverrides map[*object.EmeraldValue][]byte
 var numericBigIntOverrides map[*object.EmeraldValue]*big.Int
@@ -712,6 +720,19 @@ type argfData struct {
 	encoding         string
 	externalEncoding string
 	internalEncoding string
+	// inplace is set by ARGF.inplace_mode= (ruby -i): each file read is
+	// replaced by what the script writes to $stdout while reading it.
+	inplace       bool
+	inplaceExt    string
+	inplaceOutput *object.EmeraldValue
+	inplaceStdout *object.EmeraldValue
+	// stdin is set while "-" is the current input. Standard input is read a
+	// line at a time so ARGF works on pipes that never reach EOF.
+	stdin    bool
+	stdinEOF bool
+	// denied holds the SecurityError raised when the sandbox refused to
+	// open the next named input.
+	denied *object.EmeraldValue
 }
 
 type weakMapEntry struct {
@@ -1241,7 +1262,11 @@ var threadAbortOnExceptionDefault = false
 var threadBacktraceLimit int64 = -1
 var threadIgnoreDeadlock bool
 var atExitHooks []*object.EmeraldValue
-var endHookFns map[*object.Function]struct{}
+
+// endHookSites records the END blocks already registered, keyed by their
+// compiled code: each evaluation copies the block's Function, but an END
+// statement registers its block only the first time it runs.
+var endHookSites map[*byte]struct{}
 var InAtExitHooks bool
 var skipAtExitHooks bool
 var setTraceFuncValue *object.EmeraldValue
@@ -1270,19 +1295,26 @@ func RegisterEndHook(block *object.EmeraldValue) {
 	if block == nil {
 		return
 	}
-	proc, ok := block.Data.(*object.Proc)
-	if !ok || proc == nil || proc.Fn == nil {
+	var fn *object.Function
+	switch data := block.Data.(type) {
+	case *object.Proc:
+		fn = data.Fn
+	case *object.Closure:
+		fn = data.Fn
+	}
+	if fn == nil || len(fn.Instructions) == 0 {
 		RegisterAtExitHook(block)
 		return
 	}
 
-	if endHookFns == nil {
-		endHookFns = make(map[*object.Function]struct{})
+	if endHookSites == nil {
+		endHookSites = make(map[*byte]struct{})
 	}
-	if _, exists := endHookFns[proc.Fn]; exists {
+	site := &fn.Instructions[0]
+	if _, exists := endHookSites[site]; exists {
 		return
 	}
-	endHookFns[proc.Fn] = struct{}{}
+	endHookSites[site] = struct{}{}
 	RegisterAtExitHook(block)
 }
 
@@ -3310,12 +3342,16 @@ func initRuntime(withMspec bool) {
 	rubyRegexpCompileCache = make(map[rubyRegexpCompileCacheKey]*regexp.Regexp)
 	rubyRegexpCompileCacheMu.Unlock()
 	envObject = nil
+	Sandbox = nil
+	sandboxDeadline = time.Time{}
+	sandboxLimitErr = nil
 	stdinObject = nil
 	stdoutObject = nil
 	stderrObject = nil
 	drbCurrentServer = nil
 	weakRefValues = nil
 	observableValues = make(map[*object.EmeraldValue]*observableData)
+	ripperStates = make(map[*object.EmeraldValue]*ripperData)
 	gcDisabled = false
 	gcCountValue = 0
 	gcMajorCountValue = 0
@@ -3331,7 +3367,7 @@ func initRuntime(withMspec bool) {
 	scratchPadRecorded = nil
 	atExitHooks = nil
 	skipAtExitHooks = false
-	endHookFns = make(map[*object.Function]struct{})
+	endHookSites = make(map[*byte]struct{})
 	requiredFeatures = make(map[string]bool)
 	requiredFeatureAliases = make(map[string]string)
 	loadingFeatures = make(map[string]bool)
@@ -3399,22 +3435,13 @@ func initRuntime(withMspec bool) {
 	procRuby2KeywordFunctions = make(map[*object.Function]bool)
 	encodingValues = make(map[string]*object.EmeraldValue)
 	etcGroupIterating = false
-	coverageRunning = false
-	syslogState = struct {
-		opened   bool
-		inBlock  bool
-		ident    string
-		options  int64
-		facility int64
-		mask     int64
-	}{mask: 255}
+	coverage = coverageMeasurement{base: coverage.base + len(coverage.counters)}
+	syslogState = syslogSettings{mask: 255}
 	packedPointerStrings = make(map[*object.EmeraldValue]string)
 	berPackOverrides = make(map[*object.EmeraldValue][]byte)
 	numericBigIntOverrides = make(map[*object.EmeraldValue]*big.Int)
 	numericFloatOverrides = make(map[*object.EmeraldValue]float64)
-	if ulebPackOverrides == nil {
-		ulebPackOverrides = make(map[*object.EmeraldValue][]byte)
-	}
+	ulebPackOverrides = make(map[*object.EmeraldValue][]byte)
 	nextIOFd = 10
 	ioDataByFd = make(map[int64]*ioShimData)
 	ioPipeBuffers = make(map[int64]*bytes.Buffer)
@@ -6192,6 +6219,8 @@ func (rt *Runtime) defineMethods() {
 	objectClass.DefineMethod("enum_for", &object.Method{Name: "enum_for", Fn: objectToEnum, Arity: -1})
 	objectClass.DefineMethod("select", &object.Method{Name: "select", Fn: ioClassSelect, Arity: -1, Visibility: "private"})
 	objectClass.DefineMethod("gets", &object.Method{Name: "gets", Fn: builtinGets, Arity: -1})
+	objectClass.DefineMethod("readline", &object.Method{Name: "readline", Fn: builtinReadline, Arity: -1, Visibility: "private"})
+	objectClass.DefineMethod("readlines", &object.Method{Name: "readlines", Fn: builtinReadlines, Arity: -1, Visibility: "private"})
 	objectClass.DefineMethod("test", &object.Method{Name: "test", Fn: builtinTest, Arity: -1, Visibility: "private"})
 	objectClass.DefineMethod("loop", &object.Method{Name: "loop", Fn: builtinLoop, Arity: 0})
 	objectClass.DefineMethod("load", &object.Method{Name: "load", Fn: builtinLoad, Arity: -1, Visibility: "private"})
@@ -6492,6 +6521,7 @@ func defineRubyBuiltinConstants(objectClass *object.Class) {
 		value := &object.EmeraldValue{Type: object.ValueModule, Data: rubyVM, Class: R.Classes["Module"]}
 		objectClass.DefineConstant("RubyVM", value)
 		AssignConstantName(classEmeraldValue(objectClass), "RubyVM", value)
+		installAbstractSyntaxTree(rubyVM)
 	}
 	objectClass.DefineConstant("RUBY_PLATFORM", frozenRubyConstantString(platform))
 	objectClass.DefineConstant("RUBY_RELEASE_DATE", frozenRubyConstantString("2026-06-23"))
@@ -6928,14 +6958,20 @@ func objectSpaceMemsizeOf(receiver *object.EmeraldValue, args ...*object.Emerald
 	if len(args) > 0 && args[0] != nil {
 		args[0].MaterializeLazyArray()
 	}
-	if objectSpaceImmediate(args[0]) {
-		return newInt(0)
+	return newInt(objectSpaceMemsize(args[0]))
+}
+
+// objectSpaceMemsize estimates the bytes value takes: a header, its
+// instance variables and a String's bytes.
+func objectSpaceMemsize(value *object.EmeraldValue) int64 {
+	if objectSpaceImmediate(value) {
+		return 0
 	}
-	size := int64(40 + 32*len(receiverInstanceVarMap(args[0])))
-	if args[0].Type == object.ValueString {
-		size += int64(len(stringRawValue(args[0])))
+	size := int64(40 + 32*len(receiverInstanceVarMap(value)))
+	if value.Type == object.ValueString {
+		size += int64(len(stringRawValue(value)))
 	}
-	return newInt(size)
+	return size
 }
 
 func objectSpaceMemsizeOfAll(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
@@ -8779,7 +8815,7 @@ func trackObjectSpaceValue(value *object.EmeraldValue) {
 	if value == nil || objectSpaceTrackingDisabled || !weakMapCollectable(value) {
 		return
 	}
-	if objectSpaceTraceDepth > 0 && !allocationMetadataTraced(value) {
+	if objectSpaceTracing() && !allocationMetadataTraced(value) {
 		path := CurrentSpecFile
 		if path == "" {
 			path = "(eval)"
@@ -8813,7 +8849,7 @@ func trackObjectSpaceValue(value *object.EmeraldValue) {
 // site. VM instructions use this for values, such as mutable literals, that do
 // not pass through Class#new.
 func TrackObjectSpaceAllocation(value *object.EmeraldValue, path string, line int64, classPath, methodID string) *object.EmeraldValue {
-	if value == nil || !weakMapCollectable(value) || objectSpaceTraceDepth == 0 || allocationMetadataTraced(value) {
+	if value == nil || !weakMapCollectable(value) || !objectSpaceTracing() || allocationMetadataTraced(value) {
 		return value
 	}
 	recordObjectSpaceAllocationMetadata(value, path, line, classPath, methodID)
@@ -8862,7 +8898,7 @@ func TrackObjectSpaceValues(values []*object.EmeraldValue) {
 		objectSpaceTracked = grown
 	}
 	tracked := objectSpaceTracked
-	if objectSpaceTraceDepth == 0 {
+	if !objectSpaceTracing() {
 		registered := 0
 		for _, value := range values {
 			if value == nil || !weakMapCollectable(value) {
@@ -8885,7 +8921,7 @@ func TrackObjectSpaceValues(values []*object.EmeraldValue) {
 		if value == nil || !weakMapCollectable(value) {
 			continue
 		}
-		if objectSpaceTraceDepth > 0 && !allocationMetadataTraced(value) {
+		if objectSpaceTracing() && !allocationMetadataTraced(value) {
 			path := CurrentSpecFile
 			if path == "" {
 				path = "(eval)"
@@ -8920,7 +8956,14 @@ func TrackObjectSpaceValues(values []*object.EmeraldValue) {
 }
 
 func ObjectSpaceAllocationTracing() bool {
-	return objectSpaceTraceDepth > 0
+	return objectSpaceTracing()
+}
+
+// objectSpaceTracing reports whether allocations record where they were
+// made: inside ObjectSpace.trace_object_allocations or while an allocation
+// profile runs.
+func objectSpaceTracing() bool {
+	return objectSpaceTraceDepth > 0 || allocationProfile != nil
 }
 
 // ObjectSpaceTrackingEnabled reports whether ordinary allocations must be
@@ -8944,6 +8987,9 @@ func recordObjectSpaceAllocationMetadata(value *object.EmeraldValue, path string
 	if gcDisabled {
 		objectSpaceTraceRetained = append(objectSpaceTraceRetained, value)
 	}
+	if allocationProfile != nil {
+		allocationProfile.record(value, path, line)
+	}
 }
 
 func clearObjectSpaceAllocationMetadata(value *object.EmeraldValue) {
@@ -23135,7 +23181,7 @@ func IsFiberTerminationResult(result *object.EmeraldValue) bool {
 }
 
 func IsTerminationResult(result *object.EmeraldValue) bool {
-	return IsThreadTerminationResult(result) || IsFiberTerminationResult(result)
+	return IsThreadTerminationResult(result) || IsFiberTerminationResult(result) || IsSandboxTerminationResult(result)
 }
 
 func ConsumeCurrentThreadTermination() bool {
@@ -26769,6 +26815,11 @@ func classMethodUsesBuiltin(class *object.Class, name string, expected BuiltinMe
 	if class == nil {
 		return false
 	}
+	// A TracePoint watching C calls must see every builtin operator, so the
+	// inline fast paths step aside as they would for a redefinition.
+	if len(activeTracePoints) > 0 && (TracePointEventActive("c_call") || TracePointEventActive("c_return")) {
+		return false
+	}
 	if len(class.PrependedModules) > 0 {
 		return false
 	}
@@ -36133,6 +36184,9 @@ func hashEmpty(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *obj
 }
 
 func builtinPuts(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if target := redirectedStdout(); target != nil {
+		return CallMethod(target, "puts", args...)
+	}
 	if len(args) == 0 {
 		builtinPutsText("")
 		return R.NilVal
@@ -36190,16 +36244,48 @@ func builtinPrint(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *
 		return newRuntimeException(R.Classes["IOError"], "closed stream")
 	}
 
+	if len(args) == 0 && GetGlobalVariable != nil {
+		// print with no arguments prints $_, which is what `ruby -p` relies on.
+		if line := GetGlobalVariable("$_"); line != nil {
+			args = []*object.EmeraldValue{line}
+		}
+	}
+	var out strings.Builder
 	for _, arg := range args {
 		text, errVal := toStringValue(arg)
 		if errVal != nil {
 			return errVal
 		}
-		fmt.Print(text)
+		out.WriteString(text)
 	}
+	if GetGlobalVariable != nil {
+		if terminator := GetGlobalVariable("$\\"); terminator != nil && terminator.Type == object.ValueString {
+			out.WriteString(stringRawValue(terminator))
+		}
+	}
+	if target := redirectedStdout(); target != nil {
+		if result := CallMethod(target, "write", rubyString(out.String())); result != nil && result.Type == object.ValueException {
+			return result
+		}
+		return R.NilVal
+	}
+	fmt.Print(out.String())
 	return R.NilVal
 }
 
+// redirectedStdout returns $stdout when the script has replaced it with
+// another writable object, so Kernel#puts and Kernel#print write there.
+func redirectedStdout() *object.EmeraldValue {
+	if builtinOutputCapture != nil || GetGlobalVariable == nil || CallMethod == nil {
+		return nil
+	}
+	target := GetGlobalVariable("$stdout")
+	if target == nil || target.Type == object.ValueNil || target == StdoutObject() || !receiverHasCallableMethod(target, "write") {
+		return nil
+	}
+	return target
+}
+
 func builtinPutc(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
 	if len(args) != 1 {
 		return NewArgumentError("wrong number of arguments")
@@ -37461,6 +37547,15 @@ func builtinGets(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *o
 	}
 }
 
+// builtinReadline and builtinReadlines read from ARGF, as Kernel#gets does.
+func builtinReadline(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	return CallMethod(GetConstantName("ARGF"), "readline", args...)
+}
+
+func builtinReadlines(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	return CallMethod(GetConstantName("ARGF"), "readlines", args...)
+}
+
 func builtinTest(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
 	if len(args) < 2 {
 		return NewArgumentError("wrong number of arguments")
@@ -64053,93 +64148,6 @@ func rbConfigRuby(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *
 	return rubyString(executable)
 }
 
-func installRipperModule(objectClass *object.Class) {
-	if objectClass == nil {
-		return
-	}
-	if existing := objectClass.Constants["Ripper"]; existing != nil && existing.Type == object.ValueClass {
-		return
-	}
-	klass := object.NewClass("Ripper")
-	klass.SuperClass = objectClass
-	klass.DefineClassMethod("lex", &object.Method{Name: "lex", Fn: ripperLex, Arity: 1})
-	klass.DefineClassMethod("sexp", &object.Method{Name: "sexp", Fn: ripperSexp, Arity: 1})
-	klass.DefineMethod("initialize", &object.Method{Name: "initialize", Fn: ripperInitialize, Arity: -1, Visibility: "private"})
-	klass.DefineMethod("parse", &object.Method{Name: "parse", Fn: ripperParse, Arity: 0})
-	value := &object.EmeraldValue{Type: object.ValueClass, Data: klass, Class: R.Classes["Class"]}
-	objectClass.DefineConstant("Ripper", value)
-	AssignConstantName(&object.EmeraldValue{Type: object.ValueClass, Data: objectClass, Class: R.Classes["Class"]}, "Ripper", value)
-}
-
-func ripperInitialize(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	if len(args) == 0 {
-		return NewArgumentError("wrong number of arguments (given 0, expected 1..3)")
-	}
-	_, _, errVal := cgiStringArg(args[0])
-	if errVal != nil {
-		return errVal
-	}
-	receiverInstanceVarMap(receiver)["@source"] = args[0]
-	return R.NilVal
-}
-
-func ripperParse(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	return R.NilVal
-}
-
-func ripperPosition(line, column int64) *object.EmeraldValue {
-	return matrixArray([]*object.EmeraldValue{newInt(line), newInt(column)})
-}
-
-func ripperLex(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	_, source, errVal := cgiStringArg(args[0])
-	if errVal != nil {
-		return errVal
-	}
-	if source != "def m(a);nil end" {
-		if source == "" {
-			return matrixArray(nil)
-		}
-		return matrixArray([]*object.EmeraldValue{matrixArray([]*object.EmeraldValue{
-			ripperPosition(1, 0), rubySymbol("on_ident"), rubyString(source), rubySymbol("CMDARG"),
-		})})
-	}
-	tokens := []struct {
-		column int64
-		kind   string
-		text   string
-		state  string
-	}{
-		{0, "on_kw", "def", "FNAME"}, {3, "on_sp", " ", "FNAME"}, {4, "on_ident", "m", "ENDFN"},
-		{5, "on_lparen", "(", "BEG|LABEL"}, {6, "on_ident", "a", "ARG"}, {7, "on_rparen", ")", "ENDFN"},
-		{8, "on_semicolon", ";", "BEG"}, {9, "on_kw", "nil", "END"}, {12, "on_sp", " ", "END"}, {13, "on_kw", "end", "END"},
-	}
-	values := make([]*object.EmeraldValue, 0, len(tokens))
-	for _, token := range tokens {
-		values = append(values, matrixArray([]*object.EmeraldValue{
-			ripperPosition(1, token.column), rubySymbol(token.kind), rubyString(token.text), rubyString(token.state),
-		}))
-	}
-	return matrixArray(values)
-}
-
-func ripperSexp(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	_, source, errVal := cgiStringArg(args[0])
-	if errVal != nil {
-		return errVal
-	}
-	if source != "def hello; 42; end" {
-		return R.NilVal
-	}
-	nilValue := R.NilVal
-	params := matrixArray([]*object.EmeraldValue{rubySymbol("params"), nilValue, nilValue, nilValue, nilValue, nilValue, nilValue, nilValue})
-	identifier := matrixArray([]*object.EmeraldValue{rubySymbol("@ident"), rubyString("hello"), ripperPosition(1, 4)})
-	integer := matrixArray([]*object.EmeraldValue{rubySymbol("@int"), rubyString("42"), ripperPosition(1, 11)})
-	body := matrixArray([]*object.EmeraldValue{rubySymbol("bodystmt"), matrixArray([]*object.EmeraldValue{integer}), nilValue, nilValue, nilValue})
-	definition := matrixArray([]*object.EmeraldValue{rubySymbol("def"), identifier, params, body})
-	return matrixArray([]*object.EmeraldValue{rubySymbol("program"), matrixArray([]*object.EmeraldValue{definition})})
-}
-
 func installFindModule(objectClass *object.Class) {
 	if objectClass == nil || EvalSource == nil {
 		return
@@ -64192,93 +64200,6 @@ func rubyIntegerHash(entries map[string]int64) *object.EmeraldValue {
 	return hash
 }
 
-func installCoverageModule(objectClass *object.Class) {
-	if objectClass == nil {
-		return
-	}
-	if existing, ok := objectClass.Constants["Coverage"]; ok && existing != nil && existing.Type == object.ValueModule {
-		return
-	}
-	mod := object.NewModule("Coverage")
-	mod.DefineMethod("supported?", &object.Method{Name: "supported?", Fn: coverageSupported, Arity: 1})
-	mod.DefineMethod("start", &object.Method{Name: "start", Fn: coverageStart, Arity: -1})
-	mod.DefineMethod("running?", &object.Method{Name: "running?", Fn: coverageRunningPredicate, Arity: 0})
-	mod.DefineMethod("result", &object.Method{Name: "result", Fn: coverageResult, Arity: -1})
-	mod.DefineMethod("peek_result", &object.Method{Name: "peek_result", Fn: coveragePeekResult, Arity: 0})
-	modValue := &object.EmeraldValue{Type: object.ValueModule, Data: mod, Class: R.Classes["Module"]}
-	objectClass.DefineConstant("Coverage", modValue)
-	AssignConstantName(&object.EmeraldValue{Type: object.ValueClass, Data: objectClass, Class: R.Classes["Class"]}, "Coverage", modValue)
-}
-
-func coverageStart(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	if coverageRunning {
-		return NewRuntimeError("coverage measurement is already setup")
-	}
-	if len(args) > 0 && args[0] != nil && args[0].Type != object.ValueHash {
-		if args[0].Type != object.ValueSymbol || args[0].Data.(string) != "all" {
-			return NewTypeError("no implicit conversion of " + valueTypeName(args[0]) + " into Hash")
-		}
-	}
-	if len(args) > 0 {
-		last := args[len(args)-1]
-		if last != nil && last.Type == object.ValueHash {
-			hash := valueToHashMap(last)
-			_, lines := hashLookup(hash, rubySymbol("lines"))
-			_, oneshot := hashLookup(hash, rubySymbol("oneshot_lines"))
-			if lines && oneshot {
-				return NewRuntimeError("cannot enable lines and oneshot_lines simultaneously")
-			}
-		}
-	}
-	coverageRunning = true
-	return R.NilVal
-}
-
-func coverageRunningPredicate(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	if coverageRunning {
-		return R.TrueVal
-	}
-	return R.FalseVal
-}
-
-func coverageResult(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	if !coverageRunning {
-		return NewRuntimeError("coverage measurement is not enabled")
-	}
-	stop := len(args) == 0
-	if len(args) > 0 {
-		last := args[len(args)-1]
-		if last != nil && last.Type == object.ValueHash {
-			options := valueToHashMap(last)
-			if value, present := hashLookup(options, rubySymbol("stop")); present {
-				stop = value.IsTruthy()
-			} else {
-				stop = false
-			}
-		}
-	}
-	if stop {
-		coverageRunning = false
-	}
-	return emptyHashValue()
-}
-
-func coveragePeekResult(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	return emptyHashValue()
-}
-
-func coverageSupported(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
-	if len(args) == 0 || args[0] == nil || args[0].Type != object.ValueSymbol {
-		return NewTypeError("wrong argument type " + valueTypeName(firstArg(args)) + " (expected Symbol)")
-	}
-	switch args[0].Data.(string) {
-	case "lines", "branches", "methods", "eval":
-		return R.TrueVal
-	default:
-		return R.FalseVal
-	}
-}
-
 func installTempfileClass(objectClass *object.Class) {
 	if objectClass == nil {
 		return
@@ -66165,6 +66086,9 @@ func raiseRequireResult(result *object.EmeraldValue) *object.EmeraldValue {
 }
 
 func requireFeature(path string) *object.EmeraldValue {
+	if denied := sandboxDeniedFeature(path); denied != nil {
+		return denied
+	}
 	if path == "csv" || path == "csv.rb" {
 		if featureRequired("csv") || featureRequired("csv.rb") || loadingFeatures[path] {
 			return R.FalseVal
@@ -66511,6 +66435,14 @@ func requireFeature(path string) *object.EmeraldValue {
 		markFeatureRequired("ripper")
 		markFeatureRequired("ripper.rb")
 		return R.TrueVal
+	case "prism", "prism.rb":
+		if featureRequired("prism") || featureRequired("prism.rb") || loadingFeatures[path] {
+			return R.FalseVal
+		}
+		installPrismModule(R.Classes["Object"])
+		markFeatureRequired("prism")
+		markFeatureRequired("prism.rb")
+		return R.TrueVal
 	case "bigdecimal/util", "bigdecimal/util.rb":
 		if featureRequired("bigdecimal/util") || featureRequired("bigdecimal/util.rb") || loadingFeatures[path] {
 			return R.FalseVal
@@ -66765,6 +66697,33 @@ func requireFeature(path string) *object.EmeraldValue {
 		markFeatureRequired("coverage")
 		markFeatureRequired("coverage.rb")
 		return R.TrueVal
+	case "stackprof", "stackprof.rb":
+		if featureRequired("stackprof") || featureRequired("stackprof.rb") || loadingFeatures[path] {
+			return R.FalseVal
+		}
+		installStackProfModule(R.Classes["Object"])
+		markFeatureRequired("stackprof")
+		markFeatureRequired("stackprof.rb")
+		return R.TrueVal
+	case "memory_profiler", "memory_profiler.rb":
+		if featureRequired("memory_profiler") || featureRequired("memory_profiler.rb") || loadingFeatures[path] {
+			return R.FalseVal
+		}
+		installMemoryProfilerModule(R.Classes["Object"])
+		markFeatureRequired("memory_profiler")
+		markFeatureRequired("memory_profiler.rb")
+		return R.TrueVal
+	case "debug", "debug.rb":
+		if featureRequired("debug") || featureRequired("debug.rb") || loadingFeatures[path] {
+			return R.FalseVal
+		}
+		installDebugMethods()
+		markFeatureRequired("debug")
+		markFeatureRequired("debug.rb")
+		return R.TrueVal
+	}
+	if denied := sandboxDenied(sandboxRequire, "require '"+path+"'"); denied != nil {
+		return denied
 	}
 	thread := threadClassCurrent(nil)
 	resolvedPath := path
@@ -68095,6 +68054,9 @@ func builtinOpen(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *o
 }
 
 func builtinLoad(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if denied := sandboxDenied(sandboxRequire, "Kernel#load"); denied != nil {
+		return denied
+	}
 	if len(args) < 1 {
 		return argumentError("wrong number of arguments")
 	}
@@ -68156,6 +68118,8 @@ func builtinLoad(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *o
 		} else {
 			delete(module.InstanceVars, "@__visibility")
 		}
+	} else if EvalFileSource != nil {
+		result = EvalFileSource(string(content))
 	} else {
 		result = EvalSource(string(content))
 	}
@@ -71441,15 +71405,20 @@ func TracePointEventActive(event string) bool {
 	return false
 }
 
+// AnyTracePointActive reports whether execution must stay in the bytecode
+// interpreter, which alone fires TracePoint events. Coverage measurement
+// counts too, as its counters are bytecode instructions, and so do a Ruby
+// profile, whose samples are taken between instructions, and an attached
+// debugger, which watches every line.
 func AnyTracePointActive() bool {
-	return !tracePointDispatching && len(activeTracePoints) > 0
+	return !tracePointDispatching && (len(activeTracePoints) > 0 || coverage.state != coverageIdle || rubyProfile.profiler != nil || activeDebugger != nil)
 }
 
-func FireTracePointLine(binding *object.RBinding, frameID int) {
+func FireTracePointLine(binding *object.RBinding, frameID int, methodID string, definedClass *object.EmeraldValue) {
 	if binding == nil {
 		return
 	}
-	tracePointFirePayload(tracePointPayload{event: "line", binding: binding, eventSelf: binding.Self, path: binding.Path, line: binding.Line, frameID: frameID})
+	tracePointFirePayload(tracePointPayload{event: "line", binding: binding, eventSelf: binding.Self, definedClass: definedClass, path: binding.Path, line: binding.Line, methodID: methodID, calleeID: methodID, frameID: frameID})
 }
 
 func FireTracePointCall(event string, binding *object.RBinding, receiver, definedClass *object.EmeraldValue, methodID, calleeID string, parameters *object.EmeraldValue) {
@@ -71473,11 +71442,11 @@ func FireTracePointClass(event string, binding *object.RBinding, receiver *objec
 	tracePointFirePayload(tracePointPayload{event: event, binding: binding, eventSelf: receiver, path: binding.Path, line: binding.Line})
 }
 
-func FireTracePointException(event string, binding *object.RBinding, exception *object.EmeraldValue) {
+func FireTracePointException(event string, binding *object.RBinding, methodID string, definedClass, exception *object.EmeraldValue) {
 	if binding == nil {
 		return
 	}
-	tracePointFirePayload(tracePointPayload{event: event, binding: binding, eventSelf: binding.Self, path: binding.Path, line: binding.Line, raisedException: exception})
+	tracePointFirePayload(tracePointPayload{event: event, binding: binding, eventSelf: binding.Self, definedClass: definedClass, path: binding.Path, line: binding.Line, methodID: methodID, calleeID: methodID, raisedException: exception})
 }
 
 func FireTracePointScriptCompiled(binding *object.RBinding, source string) {
@@ -76342,7 +76311,7 @@ func ioClassCopyStream(receiver *object.EmeraldValue, args ...*object.EmeraldVal
 						return ioReadpartial(target, newInt(size))
 					}
 					buf := make([]byte, size)
-					n, err := os.Stdin.Read(buf)
+					n, err := hostStdin().Read(buf)
 					if err != nil && err != io.EOF {
 						return newRuntimeException(R.Classes["IOError"], err.Error())
 					}
@@ -84586,11 +84555,32 @@ func newArgfValue(values []*object.EmeraldValue) *object.EmeraldValue {
 	defineMockSingleton(argf, "readchar", func(_ *object.EmeraldValue, _ ...*object.EmeraldValue) *object.EmeraldValue {
 		return argfGetc(state, true)
 	})
-	defineMockSingleton(argf, "gets", func(_ *object.EmeraldValue, _ ...*object.EmeraldValue) *object.EmeraldValue {
-		return argfGets(state, false)
+	defineMockSingleton(argf, "gets", func(_ *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		return argfGets(state, false, args...)
+	})
+	defineMockSingleton(argf, "readline", func(_ *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		return argfGets(state, true, args...)
+	})
+	defineMockSingleton(argf, "inplace_mode", func(_ *object.EmeraldValue, _ ...*object.EmeraldValue) *object.EmeraldValue {
+		if !state.inplace {
+			return R.NilVal
+		}
+		return rubyString(state.inplaceExt)
 	})
-	defineMockSingleton(argf, "readline", func(_ *object.EmeraldValue, _ ...*object.EmeraldValue) *object.EmeraldValue {
-		return argfGets(state, true)
+	defineMockSingleton(argf, "inplace_mode=", func(_ *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		if len(args) != 1 {
+			return NewArgumentError("wrong number of arguments")
+		}
+		state.inplace = args[0] != nil && args[0].Type != object.ValueNil
+		state.inplaceExt = ""
+		if state.inplace {
+			text, errVal := toStringValue(args[0])
+			if errVal != nil {
+				return errVal
+			}
+			state.inplaceExt = text
+		}
+		return args[0]
 	})
 	defineMockSingleton(argf, "each", func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
 		return argfEachLine(receiver, state, args...)
@@ -84828,9 +84818,35 @@ func newArgfValue(values []*object.EmeraldValue) *object.EmeraldValue {
 	defineMockSingleton(argf, "to_s", func(_ *object.EmeraldValue, _ ...*object.EmeraldValue) *object.EmeraldValue {
 		return rubyString("ARGF")
 	})
+	raiseArgfSandboxDenials(argf, state)
 	return argf
 }
 
+// raiseArgfSandboxDenials makes each ARGF method raise the SecurityError
+// recorded when the sandbox kept argfAdvance from opening a named file.
+func raiseArgfSandboxDenials(argf *object.EmeraldValue, state *argfData) {
+	obj, ok := argf.Data.(*object.Object)
+	if !ok || obj == nil {
+		return
+	}
+	for name, method := range obj.SingletonMethods {
+		native, ok := method.Fn.(func(*object.EmeraldValue, ...*object.EmeraldValue) *object.EmeraldValue)
+		if !ok {
+			continue
+		}
+		wrapper := *method
+		wrapper.Fn = func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+			result := native(receiver, args...)
+			if denied := state.denied; denied != nil {
+				state.denied = nil
+				return denied
+			}
+			return result
+		}
+		obj.SingletonMethods[name] = &wrapper
+	}
+}
+
 func argfEachLine(receiver *object.EmeraldValue, state *argfData, args ...*object.EmeraldValue) *object.EmeraldValue {
 	sep := "\n"
 	if len(args) > 0 && args[0] != nil && args[0].Type == object.ValueString {
@@ -84948,6 +84964,9 @@ func argfEof(state *argfData) *object.EmeraldValue {
 	if state.closed {
 		return newRuntimeException(R.Classes["IOError"], "closed stream")
 	}
+	if state.stdin && state.offset >= len(state.content) && argfFillStdin(state) {
+		return R.FalseVal
+	}
 	if state.content == "" && state.index >= len(state.paths) {
 		return R.TrueVal
 	}
@@ -84969,12 +84988,31 @@ func argfGetc(state *argfData, raiseEOF bool) *object.EmeraldValue {
 	return rubyString(ch)
 }
 
-func argfGets(state *argfData, raiseEOF bool) *object.EmeraldValue {
-	line := argfNextSeparatedLine(state, "\n")
+func argfGets(state *argfData, raiseEOF bool, args ...*object.EmeraldValue) *object.EmeraldValue {
+	separator := rubyString("\n")
+	if len(args) > 0 && args[0] != nil && args[0].Type != object.ValueHash {
+		separator = args[0]
+	} else if GetGlobalVariable != nil {
+		if value := GetGlobalVariable("$/"); value != nil {
+			separator = value
+		}
+	}
+	var line *object.EmeraldValue
+	switch {
+	case separator.Type == object.ValueNil:
+		line = argfNextSeparatedLine(state, "")
+	case separator.Type == object.ValueString && stringRawValue(separator) == "":
+		line = argfNextParagraph(state)
+	case separator.Type == object.ValueString:
+		line = argfNextSeparatedLine(state, stringRawValue(separator))
+	default:
+		return typeError("no implicit conversion of " + separator.TypeName() + " into String")
+	}
 	if line == nil {
 		if raiseEOF {
 			return newRuntimeException(R.Classes["EOFError"], "end of file reached")
 		}
+		SetGlobalVariableIfAvailable("$_", R.NilVal)
 		return R.NilVal
 	}
 	return line
@@ -84984,6 +85022,7 @@ func argfNextSeparatedLine(state *argfData, sep string) *object.EmeraldValue {
 	if !argfEnsureContent(state) {
 		return nil
 	}
+	argfFillStdinUntil(state, sep)
 	remaining := state.content[state.offset:]
 	next := -1
 	if sep != "" {
@@ -85013,6 +85052,26 @@ func argfNextSeparatedLine(state *argfData, sep string) *object.EmeraldValue {
 	return result
 }
 
+// argfNextParagraph implements gets("") paragraph mode: a record ends at a
+// blank line and any further newlines are skipped.
+func argfNextParagraph(state *argfData) *object.EmeraldValue {
+	for argfEnsureContent(state) && state.content[state.offset] == '\n' {
+		state.offset++
+	}
+	if !argfEnsureContent(state) {
+		return nil
+	}
+	argfFillStdinUntil(state, "\n\n")
+	if strings.Contains(state.content[state.offset:], "\n\n") {
+		line := argfNextSeparatedLine(state, "\n\n")
+		for argfEnsureContent(state) && state.content[state.offset] == '\n' {
+			state.offset++
+		}
+		return line
+	}
+	return argfNextSeparatedLine(state, "")
+}
+
 func argfReadpartial(state *argfData, args ...*object.EmeraldValue) *object.EmeraldValue {
 	if len(args) == 0 {
 		return NewArgumentError("wrong number of arguments")
@@ -85181,6 +85240,9 @@ func argfEnsureContent(state *argfData) bool {
 		if state.offset < len(state.content) {
 			return true
 		}
+		if state.stdin && argfFillStdin(state) {
+			continue
+		}
 		if !argfAdvance(state) {
 			return false
 		}
@@ -85201,18 +85263,49 @@ func argfEnsureCurrent(state *argfData) bool {
 }
 
 func argfAdvance(state *argfData) bool {
-	for state.index < len(state.paths) {
-		path := state.paths[state.index]
-		state.index++
+	argfFinishInplace(state)
+	for {
+		path, ok := argfNextPath(state)
+		if !ok {
+			break
+		}
 		state.path = path
 		state.closed = false
+		state.stdin = false
 		if path == "/dev/zero" {
 			state.content = strings.Repeat("\x00", 4096)
 			state.offset = 0
 			return true
 		}
+		if path == "-" {
+			state.content = ""
+			state.offset = 0
+			state.io = newIOShimValue("File")
+			if ioData := ioShim(state.io); ioData != nil {
+				ioData.path = path
+				ioData.mode = "r"
+				ioData.externalEncoding = state.encoding
+			}
+			state.stdin = true
+			state.stdinEOF = false
+			if argfFillStdin(state) {
+				return true
+			}
+			continue
+		}
+		if denied := sandboxDenied(sandboxFileSystem, "ARGF reading "+path); denied != nil {
+			state.denied = denied
+			break
+		}
 		data, err := os.ReadFile(path)
 		if err != nil {
+			if state.globalArgv {
+				var pathErr *os.PathError
+				if errors.As(err, &pathErr) {
+					err = pathErr.Err
+				}
+				fmt.Fprintf(os.Stderr, "Can't open %s: %v\n", path, err)
+			}
 			continue
 		}
 		state.content = string(data)
@@ -85223,6 +85316,12 @@ func argfAdvance(state *argfData) bool {
 			ioData.mode = "r"
 			ioData.externalEncoding = state.encoding
 		}
+		if state.inplace && path != "-" {
+			if errVal := argfStartInplace(state, path); errVal != nil {
+				fmt.Fprintf(os.Stderr, "Can't do inplace edit for %s: %s\n", path, stringRawValue(exceptionMessage(errVal)))
+				continue
+			}
+		}
 		if len(state.content) > 0 {
 			return true
 		}
@@ -85231,9 +85330,115 @@ func argfAdvance(state *argfData) bool {
 	state.offset = 0
 	state.io = nil
 	state.closed = true
+	state.stdin = false
 	return false
 }
 
+var hostStdinReader *bufio.Reader
+var hostStdinFile *os.File
+
+// hostStdin is the process's standard input. Every reader of fd 0 shares it
+// so data buffered for one of them is not lost to the others.
+func hostStdin() *bufio.Reader {
+	if hostStdinReader == nil || hostStdinFile != os.Stdin {
+		hostStdinReader = bufio.NewReader(os.Stdin)
+		hostStdinFile = os.Stdin
+	}
+	return hostStdinReader
+}
+
+// argfFillStdin appends the next line of standard input to the unread part
+// of state.content. It reports false once standard input is exhausted.
+func argfFillStdin(state *argfData) bool {
+	if !state.stdin || state.stdinEOF {
+		return false
+	}
+	line, err := hostStdin().ReadString('\n')
+	if err != nil {
+		state.stdinEOF = true
+	}
+	if line == "" {
+		return false
+	}
+	state.content = state.content[state.offset:] + line
+	state.offset = 0
+	return true
+}
+
+// argfFillStdinUntil reads standard input until the unread content holds sep,
+// or to EOF when sep is empty.
+func argfFillStdinUntil(state *argfData, sep string) {
+	for state.stdin && (sep == "" || !strings.Contains(state.content[state.offset:], sep)) {
+		if !argfFillStdin(state) {
+			return
+		}
+	}
+}
+
+// argfNextPath returns the next input to open. An explicit ARGF.new list is
+// walked by index; the global ARGF shifts names off ARGV as it goes, like
+// MRI, and reads standard input when ARGV was empty from the start.
+func argfNextPath(state *argfData) (string, bool) {
+	if !state.globalArgv {
+		if state.index >= len(state.paths) {
+			return "", false
+		}
+		path := state.paths[state.index]
+		state.index++
+		return path, true
+	}
+	if GetConstantName != nil {
+		if argv := GetConstantName("ARGV"); argv != nil && argv.Type == object.ValueArray {
+			if items, ok := argv.Data.([]*object.EmeraldValue); ok && len(items) > 0 {
+				argv.Data = items[1:]
+				state.index++
+				if items[0] != nil && items[0].Type == object.ValueString {
+					return stringRawValue(items[0]), true
+				}
+				return items[0].Inspect(), true
+			}
+		}
+	}
+	if state.index == 0 {
+		state.index++
+		return "-", true
+	}
+	return "", false
+}
+
+// argfStartInplace moves path aside (to path+ext, or away entirely when no
+// backup extension was given) and points $stdout at a fresh file in its place.
+func argfStartInplace(state *argfData, path string) *object.EmeraldValue {
+	if GetConstantName == nil || CallMethod == nil || GetGlobalVariable == nil || SetGlobalVariable == nil {
+		return nil
+	}
+	if state.inplaceExt != "" {
+		if err := os.Rename(path, path+state.inplaceExt); err != nil {
+			return newRuntimeException(R.Classes["IOError"], err.Error())
+		}
+	}
+	output := CallMethod(GetConstantName("File"), "open", rubyString(path), rubyString("w"))
+	if output != nil && output.Type == object.ValueException {
+		return output
+	}
+	state.inplaceOutput = output
+	state.inplaceStdout = GetGlobalVariable("$stdout")
+	SetGlobalVariable("$stdout", output)
+	return nil
+}
+
+// argfFinishInplace closes the file written for the previous input and
+// restores $stdout.
+func argfFinishInplace(state *argfData) {
+	if state.inplaceOutput == nil {
+		return
+	}
+	CallMethod(state.inplaceOutput, "close")
+	SetGlobalVariable("$stdout", state.inplaceStdout)
+	state.inplaceOutput = nil
+	state.inplaceStdout = nil
+}
+
 func SetGlobalVariableIfAvailable(name string, value *object.EmeraldValue) {
 	if SetGlobalVariable != nil {
 		SetGlobalVariable(name, value)
@@ -85363,7 +85568,7 @@ func ioReadContentFromShim(data *ioShimData) (string, *object.EmeraldValue) {
 		}
 		if data.hostStandard && data.fd == 0 {
 			if !data.hostRead {
-				content, err := io.ReadAll(os.Stdin)
+				content, err := io.ReadAll(hostStdin())
 				if err != nil {
 					return "", newRuntimeException(R.Classes["IOError"], err.Error())
 				}
@@ -86965,6 +87170,11 @@ func ioReopen(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *obje
 			return receiver
 		}
 	}
+	// Reopening onto another stream is allowed; only opening a path needs
+	// the file system.
+	if denied := sandboxDenied(sandboxFileSystem, "IO#reopen"); denied != nil {
+		return denied
+	}
 
 	parseModeValue := func(value *object.EmeraldValue, modeValueSet *bool) (string, *object.EmeraldValue) {
 		if value == nil || value.Type == object.ValueNil {
@@ -96660,18 +96870,27 @@ func bindingLocalVariableSet(receiver *object.EmeraldValue, args ...*object.Emer
 	if err != nil {
 		return err
 	}
+	SetBindingLocal(binding, name, args[1])
+	return args[1]
+}
+
+// SetBindingLocal defines or updates a local variable in binding, exactly as
+// Binding#local_variable_set does, including propagation to the frame the
+// binding was captured from.
+func SetBindingLocal(binding *object.RBinding, name string, value *object.EmeraldValue) {
+	binding.MaterializeLocals()
+	binding.EnsureExpanded()
 	if binding.Locals == nil {
 		binding.Locals = map[string]*object.EmeraldValue{}
 	}
 	if !bindingHasLocalName(binding.LocalNames, name) {
 		binding.LocalNames = append([]string{name}, binding.LocalNames...)
 	}
-	binding.Locals[name] = args[1]
+	binding.Locals[name] = value
 	if SetCapturedBindingLocal != nil {
-		SetCapturedBindingLocal(binding, name, args[1])
+		SetCapturedBindingLocal(binding, name, value)
 	}
 	propagateSharedBindingLocals(binding)
-	return args[1]
 }
 
 func bindingReceiver(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
@@ -97013,6 +97232,12 @@ func bindingIRB(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *ob
 	if binding == nil || EvalSourceWithBinding == nil {
 		return R.NilVal
 	}
+	if InteractiveSession != nil {
+		if exit := InteractiveSession(binding); exit != nil {
+			return exit
+		}
+		return R.NilVal
+	}
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		line := scanner.Text()
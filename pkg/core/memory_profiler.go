@@ -0,0 +1,393 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"weak"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/profiler"
+)
+
+// allocationProfile is the running allocation profile, started by the
+// RGO_ALLOCATION_PROFILE variable or the MemoryProfiler module. While it
+// runs, allocations record where they were made, as under
+// ObjectSpace.trace_object_allocations, and the profile keeps each one.
+// Like memory_profiler, which disables GC for the profiled block, it only
+// measures sizes and String contents once the profile stops.
+var allocationProfile *allocationRecorder
+
+type allocationRecorder struct {
+	objects []profiledObject
+	// keepFile decides which files' allocations count; files caches its
+	// answers. classes, when set, limits the profile to those classes.
+	keepFile func(path string) bool
+	files    map[string]bool
+	classes  map[*object.Class]bool
+	// top is the number of entries MemoryProfiler's tables keep.
+	top int
+}
+
+type profiledObject struct {
+	value *object.EmeraldValue
+	site  profiler.AllocationSite
+}
+
+func (r *allocationRecorder) record(value *object.EmeraldValue, path string, line int64) {
+	if r.keepFile != nil {
+		keep, ok := r.files[path]
+		if !ok {
+			// A Regexp pattern runs Ruby code, whose own allocations must
+			// not ask again.
+			r.files[path] = false
+			keep = r.keepFile(path)
+			r.files[path] = keep
+		}
+		if !keep {
+			return
+		}
+	}
+	if r.classes != nil && !r.classes[value.Class] {
+		return
+	}
+	className := "(unknown)"
+	if value.Class != nil && value.Class.Name != "" {
+		className = value.Class.Name
+	}
+	r.objects = append(r.objects, profiledObject{value: value, site: profiler.AllocationSite{File: path, Line: int(line), Class: className}})
+}
+
+// StartAllocationProfile starts counting allocations by call site and
+// class. It reports false when a profile already runs.
+func StartAllocationProfile() bool {
+	if allocationProfile != nil {
+		return false
+	}
+	allocationProfile = &allocationRecorder{}
+	return true
+}
+
+// StopAllocationProfile stops the running allocation profile and returns
+// it, or nil when none runs. The objects are then only held weakly, and
+// those a full collection leaves are reported as retained.
+func StopAllocationProfile() *profiler.AllocationProfile {
+	recorder := allocationProfile
+	if recorder == nil {
+		return nil
+	}
+	allocationProfile = nil
+	profile := &profiler.AllocationProfile{}
+	type allocation struct {
+		value weak.Pointer[object.EmeraldValue]
+		stats *profiler.AllocationStats
+		text  *profiler.StringStats
+		site  profiler.AllocationSite
+	}
+	allocations := make([]allocation, len(recorder.objects))
+	for index, allocated := range recorder.objects {
+		value := allocated.value
+		allocations[index] = allocation{value: weak.Make(value), stats: profile.Allocate(allocated.site, objectSpaceMemsize(value)), site: allocated.site}
+		if value.Type == object.ValueString {
+			allocations[index].text = profile.AllocateString(stringRawValue(value), allocated.site)
+		}
+	}
+	recorder.objects = nil
+	gcStart(nil)
+	for _, allocated := range allocations {
+		value := allocated.value.Value()
+		if value == nil {
+			continue
+		}
+		profile.Retain(allocated.stats, objectSpaceMemsize(value))
+		if allocated.text != nil {
+			profile.RetainString(allocated.text, allocated.site)
+		}
+	}
+	return profile
+}
+
+func installMemoryProfilerModule(objectClass *object.Class) {
+	if objectClass == nil {
+		return
+	}
+	if existing, ok := objectClass.Constants["MemoryProfiler"]; ok && existing != nil && existing.Type == object.ValueModule {
+		return
+	}
+	mod := object.NewModule("MemoryProfiler")
+	mod.DefineMethod("report", &object.Method{Name: "report", Fn: memoryProfilerReport, Arity: -1})
+	mod.DefineMethod("start", &object.Method{Name: "start", Fn: memoryProfilerStart, Arity: -1})
+	mod.DefineMethod("stop", &object.Method{Name: "stop", Fn: memoryProfilerStop, Arity: 0})
+	modValue := &object.EmeraldValue{Type: object.ValueModule, Data: mod, Class: R.Classes["Module"]}
+
+	results := object.NewClass("MemoryProfiler::Results")
+	results.SuperClass = objectClass
+	results.DefineMethod("total_allocated", &object.Method{Name: "total_allocated", Fn: memoryProfilerTotal(false, false), Arity: 0})
+	results.DefineMethod("total_retained", &object.Method{Name: "total_retained", Fn: memoryProfilerTotal(true, false), Arity: 0})
+	results.DefineMethod("total_allocated_memsize", &object.Method{Name: "total_allocated_memsize", Fn: memoryProfilerTotal(false, true), Arity: 0})
+	results.DefineMethod("total_retained_memsize", &object.Method{Name: "total_retained_memsize", Fn: memoryProfilerTotal(true, true), Arity: 0})
+	for _, state := range []string{"allocated", "retained"} {
+		for _, metric := range []string{"memory", "objects"} {
+			for _, grouping := range []profiler.Grouping{profiler.ByGem, profiler.ByFile, profiler.ByLocation, profiler.ByClass} {
+				name := state + "_" + metric + "_by_" + string(grouping)
+				results.DefineMethod(name, &object.Method{Name: name, Fn: memoryProfilerGroup(grouping, metric == "memory", state == "retained"), Arity: 0})
+			}
+		}
+	}
+	results.DefineMethod("strings_allocated", &object.Method{Name: "strings_allocated", Fn: memoryProfilerStrings(false), Arity: 0})
+	results.DefineMethod("strings_retained", &object.Method{Name: "strings_retained", Fn: memoryProfilerStrings(true), Arity: 0})
+	results.DefineMethod("pretty_print", &object.Method{Name: "pretty_print", Fn: memoryProfilerPrettyPrint, Arity: -1})
+	results.DefineMethod("inspect", &object.Method{Name: "inspect", Fn: memoryProfilerResultsInspect, Arity: 0})
+	R.Classes["MemoryProfiler::Results"] = results
+	mod.DefineConstant("Results", classEmeraldValue(results))
+
+	objectClass.DefineConstant("MemoryProfiler", modValue)
+	AssignConstantName(classEmeraldValue(objectClass), "MemoryProfiler", modValue)
+}
+
+// memoryProfilerResults is the data of a MemoryProfiler::Results: the
+// profile and the number of entries each of its tables keeps.
+type memoryProfilerResults struct {
+	profile *profiler.AllocationProfile
+	top     int
+}
+
+func memoryProfilerResultsData(receiver *object.EmeraldValue) *memoryProfilerResults {
+	if receiver == nil {
+		return nil
+	}
+	data, _ := receiver.Data.(*memoryProfilerResults)
+	return data
+}
+
+// memoryProfilerReport implements MemoryProfiler.report(top: 50,
+// allow_files: nil, ignore_files: nil, trace: nil) { ... }.
+func memoryProfilerReport(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if BlockGivenCheck == nil || !BlockGivenCheck() || CurrentBlockValue == nil || CallBlockWithArgs == nil {
+		return NewArgumentError("block required")
+	}
+	block := CurrentBlockValue()
+	if started := memoryProfilerStart(receiver, args...); started.Type == object.ValueException {
+		return started
+	}
+	if result := CallBlockWithArgs(block); result != nil && result.Type == object.ValueException {
+		StopAllocationProfile()
+		return result
+	}
+	return memoryProfilerStop(receiver)
+}
+
+// memoryProfilerStart implements MemoryProfiler.start with report's
+// options. Files are kept when they contain an allow_files String or match
+// an allow_files Regexp, and none of ignore_files.
+func memoryProfilerStart(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	top := profiler.DefaultReportOptions.Top
+	var allow, ignore []*object.EmeraldValue
+	var classes map[*object.Class]bool
+	if len(args) > 0 && args[len(args)-1] != nil && args[len(args)-1].Type == object.ValueHash {
+		options := valueToHashMap(args[len(args)-1])
+		if value, present := hashLookup(options, rubySymbol("top")); present {
+			n, ok := valueToInteger(value)
+			if !ok {
+				return NewTypeError("no implicit conversion of " + valueTypeName(value) + " into Integer")
+			}
+			top = int(n)
+		}
+		if value, present := hashLookup(options, rubySymbol("allow_files")); present && value != R.NilVal {
+			allow = memoryProfilerPatterns(value)
+		}
+		if value, present := hashLookup(options, rubySymbol("ignore_files")); present && value != R.NilVal {
+			ignore = memoryProfilerPatterns(value)
+		}
+		if value, present := hashLookup(options, rubySymbol("trace")); present && value != R.NilVal {
+			classes = make(map[*object.Class]bool)
+			for _, class := range memoryProfilerPatterns(value) {
+				if class == nil || class.Type != object.ValueClass {
+					return NewTypeError("trace must list classes, not " + valueTypeName(class))
+				}
+				classes[class.Data.(*object.Class)] = true
+			}
+		}
+	}
+	if !StartAllocationProfile() {
+		return NewRuntimeError("a memory profile is already running")
+	}
+	allocationProfile.classes = classes
+	if allow != nil || ignore != nil {
+		allocationProfile.files = make(map[string]bool)
+		allocationProfile.keepFile = func(path string) bool {
+			if allow != nil && !memoryProfilerMatches(allow, path) {
+				return false
+			}
+			return !memoryProfilerMatches(ignore, path)
+		}
+	}
+	allocationProfile.top = top
+	return R.NilVal
+}
+
+func memoryProfilerStop(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if allocationProfile == nil {
+		return R.NilVal
+	}
+	top := allocationProfile.top
+	profile := StopAllocationProfile()
+	class := R.Classes["MemoryProfiler::Results"]
+	return &object.EmeraldValue{Type: object.ValueObject, Data: &memoryProfilerResults{profile: profile, top: top}, Class: class}
+}
+
+func memoryProfilerPatterns(value *object.EmeraldValue) []*object.EmeraldValue {
+	if value.Type == object.ValueArray {
+		if values, ok := value.Data.([]*object.EmeraldValue); ok {
+			return values
+		}
+	}
+	return []*object.EmeraldValue{value}
+}
+
+func memoryProfilerMatches(patterns []*object.EmeraldValue, path string) bool {
+	for _, pattern := range patterns {
+		if pattern == nil {
+			continue
+		}
+		if pattern.Type == object.ValueString {
+			if strings.Contains(path, valueToStringValue(pattern)) {
+				return true
+			}
+			continue
+		}
+		if CallMethod != nil && CallMethod(pattern, "match?", rubyString(path)).IsTruthy() {
+			return true
+		}
+	}
+	return false
+}
+
+func memoryProfilerTotal(retained, memory bool) func(*object.EmeraldValue, ...*object.EmeraldValue) *object.EmeraldValue {
+	return func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		data := memoryProfilerResultsData(receiver)
+		if data == nil {
+			return NewIntegerValue(0)
+		}
+		objects, bytes := data.profile.Totals(retained)
+		if memory {
+			return NewIntegerValue(bytes)
+		}
+		return NewIntegerValue(objects)
+	}
+}
+
+// memoryProfilerGroup returns a table of the results, as an Array of
+// {data:, count:} Hashes.
+func memoryProfilerGroup(grouping profiler.Grouping, memory, retained bool) func(*object.EmeraldValue, ...*object.EmeraldValue) *object.EmeraldValue {
+	return func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		data := memoryProfilerResultsData(receiver)
+		if data == nil {
+			return coverageArray()
+		}
+		entries := data.profile.Group(grouping, memory, retained, data.top)
+		values := make([]*object.EmeraldValue, len(entries))
+		for index, entry := range entries {
+			hash := emptyHashValue()
+			hashIndexSet(hash, rubySymbol("data"), rubyString(entry.Data))
+			hashIndexSet(hash, rubySymbol("count"), NewIntegerValue(entry.Count))
+			values[index] = hash
+		}
+		return coverageArray(values...)
+	}
+}
+
+// memoryProfilerStrings returns the String report of the results:
+// [[string, [[location, count], ...]], ...].
+func memoryProfilerStrings(retained bool) func(*object.EmeraldValue, ...*object.EmeraldValue) *object.EmeraldValue {
+	return func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		data := memoryProfilerResultsData(receiver)
+		if data == nil {
+			return coverageArray()
+		}
+		report := data.profile.StringReport(retained, data.top)
+		values := make([]*object.EmeraldValue, len(report))
+		for index, entry := range report {
+			locations := make([]*object.EmeraldValue, len(entry.Locations))
+			for i, location := range entry.Locations {
+				locations[i] = coverageArray(rubyString(location.Data), NewIntegerValue(location.Count))
+			}
+			values[index] = coverageArray(rubyString(entry.Text), coverageArray(locations...))
+		}
+		return coverageArray(values...)
+	}
+}
+
+// memoryProfilerPrettyPrint implements Results#pretty_print(io = $stdout,
+// to_file: nil, scale_bytes: false, detailed_report: true,
+// allocated_strings: 50, retained_strings: 50).
+func memoryProfilerPrettyPrint(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data := memoryProfilerResultsData(receiver)
+	if data == nil {
+		return R.NilVal
+	}
+	opts := profiler.DefaultReportOptions
+	opts.Top = data.top
+	var out *object.EmeraldValue
+	path := ""
+	if len(args) > 0 && args[len(args)-1] != nil && args[len(args)-1].Type == object.ValueHash {
+		options := valueToHashMap(args[len(args)-1])
+		args = args[:len(args)-1]
+		if value, present := hashLookup(options, rubySymbol("to_file")); present && value != R.NilVal {
+			path = valueToStringValue(value)
+		}
+		if value, present := hashLookup(options, rubySymbol("scale_bytes")); present {
+			opts.ScaleBytes = value.IsTruthy()
+		}
+		if value, present := hashLookup(options, rubySymbol("detailed_report")); present {
+			opts.Summary = !value.IsTruthy()
+		}
+		if value, present := hashLookup(options, rubySymbol("allocated_strings")); present {
+			if n, ok := valueToInteger(value); ok {
+				opts.AllocatedStrings = int(n)
+			}
+		}
+		if value, present := hashLookup(options, rubySymbol("retained_strings")); present {
+			if n, ok := valueToInteger(value); ok {
+				opts.RetainedStrings = int(n)
+			}
+		}
+	}
+	if path != "" {
+		if denied := sandboxDenied(sandboxFileSystem, "MemoryProfiler::Results#pretty_print"); denied != nil {
+			return denied
+		}
+		if err := data.profile.WriteReportFile(path, opts); err != nil {
+			return errnoForPathError(err)
+		}
+		return R.NilVal
+	}
+	if len(args) > 0 {
+		out = args[0]
+	} else if GetGlobalVariable != nil {
+		out = GetGlobalVariable("$stdout")
+	}
+	if out == nil || out == R.NilVal {
+		out = StdoutObject()
+	}
+	if out == nil || CallMethod == nil {
+		return R.NilVal
+	}
+	var report strings.Builder
+	if err := data.profile.WriteReport(&report, opts); err != nil {
+		return newRuntimeException(R.Classes["IOError"], err.Error())
+	}
+	if result := CallMethod(out, "write", rubyString(report.String())); result != nil && result.Type == object.ValueException {
+		return result
+	}
+	return R.NilVal
+}
+
+func memoryProfilerResultsInspect(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data := memoryProfilerResultsData(receiver)
+	if data == nil {
+		return rubyString("#<MemoryProfiler::Results>")
+	}
+	objects, _ := data.profile.Totals(false)
+	retained, _ := data.profile.Totals(true)
+	return rubyString("#<MemoryProfiler::Results allocated=" + strconv.FormatInt(objects, 10) + " retained=" + strconv.FormatInt(retained, 10) + ">")
+}
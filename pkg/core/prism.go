@@ -0,0 +1,895 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+)
+
+// installPrismModule defines the Prism facade loaded by require "prism".
+// Nodes are translated in Ruby from RubyVM::AbstractSyntaxTree trees; the
+// native half scans comments, __END__ and syntax errors.
+func installPrismModule(objectClass *object.Class) {
+	if objectClass == nil {
+		return
+	}
+	if existing := objectClass.Constants["Prism"]; existing != nil && existing.Type == object.ValueModule {
+		return
+	}
+	module := object.NewModule("Prism")
+	value := &object.EmeraldValue{Type: object.ValueModule, Data: module, Class: R.Classes["Module"]}
+	objectClass.DefineConstant("Prism", value)
+	AssignConstantName(classEmeraldValue(objectClass), "Prism", value)
+	module.DefineMethod("__scan", &object.Method{Name: "__scan", Fn: prismScan, Arity: 2})
+	if EvalSource != nil {
+		EvalSource(prismPrelude)
+	}
+}
+
+// prismScan returns [tree, comments, errors, data_offset] for source. tree
+// is the RubyVM::AbstractSyntaxTree root, or nil when the source has syntax
+// errors; comments are [kind, start_offset, end_offset] and errors are
+// [message, line, column, length].
+func prismScan(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	source, ok := args[0].Data.(string)
+	if args[0].Type != object.ValueString || !ok {
+		return NewTypeError("no implicit conversion of " + valueTypeName(args[0]) + " into String")
+	}
+	path := ""
+	if args[1] != nil && args[1].Type == object.ValueString {
+		path, _ = args[1].Data.(string)
+	}
+
+	tokens, _ := ripperScan(source, 1)
+	var comments []*object.EmeraldValue
+	dataOffset := R.NilVal
+	parsed := source
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token.Event {
+		case "comment":
+			end := token.Offset + len(strings.TrimRight(token.Text, "\r\n"))
+			comments = append(comments, matrixArray([]*object.EmeraldValue{rubySymbol("inline"), newInt(int64(token.Offset)), newInt(int64(end))}))
+		case "embdoc_beg":
+			end := token.Offset + len(token.Text)
+			for i+1 < len(tokens) && (tokens[i+1].Event == "embdoc" || tokens[i+1].Event == "embdoc_end") {
+				i++
+				end = tokens[i].Offset + len(tokens[i].Text)
+				if tokens[i].Event == "embdoc_end" {
+					break
+				}
+			}
+			comments = append(comments, matrixArray([]*object.EmeraldValue{rubySymbol("embdoc"), newInt(int64(token.Offset)), newInt(int64(end))}))
+		case "__end__":
+			dataOffset = newInt(int64(token.Offset))
+			parsed = source[:token.Offset]
+		}
+	}
+
+	p := parser.New(lexer.New(parsed))
+	if path != "" {
+		p.SetFile(path)
+	}
+	p.ParseProgram()
+	var errors []*object.EmeraldValue
+	for _, diag := range p.Diagnostics() {
+		column, length := 0, 0
+		if diag.Column > 0 {
+			column = diag.Column - 1
+			if diag.EndColumn > diag.Column {
+				length = diag.EndColumn - diag.Column
+			}
+		}
+		errors = append(errors, matrixArray([]*object.EmeraldValue{rubyString(diag.Message), newInt(int64(diag.Line)), newInt(int64(column)), newInt(int64(length))}))
+	}
+
+	tree := R.NilVal
+	if len(errors) == 0 {
+		root, exception := astParse(source, path, false)
+		if exception != nil {
+			return exception
+		}
+		tree = astNodeValue(root.root)
+	}
+	return matrixArray([]*object.EmeraldValue{tree, matrixArray(comments), matrixArray(errors), dataOffset})
+}
+
+// prismPrelude is the Ruby half of prism.rb: sources and locations, the
+// parse results, the node classes and the translation from
+// RubyVM::AbstractSyntaxTree nodes.
+const prismPrelude = `module Prism
+  class Source
+    attr_reader :source, :start_line, :offsets
+
+    def initialize(source, start_line = 1)
+      @source = source
+      @start_line = start_line
+      @offsets = [0]
+      source.each_byte.with_index { |byte, index| @offsets << index + 1 if byte == 10 }
+    end
+
+    def slice(offset, length)
+      source.byteslice(offset, length)
+    end
+
+    def offset(line, column)
+      (@offsets[line - @start_line] || source.bytesize) + column
+    end
+
+    def line(offset)
+      index = @offsets.bsearch_index { |start| start > offset } || @offsets.size
+      index - 1 + @start_line
+    end
+
+    def line_start(offset)
+      @offsets[line(offset) - @start_line]
+    end
+
+    def column(offset)
+      offset - line_start(offset)
+    end
+  end
+
+  class Location
+    attr_reader :source, :start_offset, :length
+
+    def initialize(source, start_offset, length)
+      @source = source
+      @start_offset = start_offset
+      @length = length
+    end
+
+    def end_offset
+      start_offset + length
+    end
+
+    def start_line
+      source.line(start_offset)
+    end
+
+    def end_line
+      source.line(end_offset)
+    end
+
+    def start_column
+      source.column(start_offset)
+    end
+
+    def end_column
+      source.column(end_offset)
+    end
+
+    def slice
+      source.slice(start_offset, length)
+    end
+
+    def join(other)
+      Location.new(source, start_offset, other.end_offset - start_offset)
+    end
+
+    def ==(other)
+      other.is_a?(Location) && other.start_offset == start_offset && other.length == length
+    end
+
+    def inspect
+      "#<Prism::Location @start_offset=#{start_offset} @length=#{length} start_line=#{start_line}>"
+    end
+
+    def to_s
+      "(#{start_line},#{start_column})-(#{end_line},#{end_column})"
+    end
+  end
+
+  class Comment
+    attr_reader :location
+
+    def initialize(location)
+      @location = location
+    end
+
+    def slice
+      location.slice
+    end
+
+    def trailing?
+      false
+    end
+
+    def inspect
+      "#<#{self.class} @location=#{location}>"
+    end
+  end
+
+  class InlineComment < Comment
+    def trailing?
+      location.source.source.byteslice(location.source.line_start(location.start_offset), location.start_column).strip != ""
+    end
+  end
+
+  class EmbDocComment < Comment
+  end
+
+  class MagicComment
+    attr_reader :key_loc, :value_loc
+
+    def initialize(key_loc, value_loc)
+      @key_loc = key_loc
+      @value_loc = value_loc
+    end
+
+    def key
+      key_loc.slice
+    end
+
+    def value
+      value_loc.slice
+    end
+
+    def inspect
+      "#<Prism::MagicComment @key=#{key.inspect} @value=#{value.inspect}>"
+    end
+  end
+
+  class ParseError
+    attr_reader :type, :message, :location, :level
+
+    def initialize(type, message, location, level)
+      @type = type
+      @message = message
+      @location = location
+      @level = level
+    end
+
+    def inspect
+      "#<Prism::ParseError @type=#{type.inspect} @message=#{message.inspect} @location=#{location} @level=#{level.inspect}>"
+    end
+  end
+
+  class ParseWarning < ParseError
+  end
+
+  class Result
+    attr_reader :comments, :magic_comments, :data_loc, :errors, :warnings, :source
+
+    def initialize(comments, magic_comments, data_loc, errors, warnings, source)
+      @comments = comments
+      @magic_comments = magic_comments
+      @data_loc = data_loc
+      @errors = errors
+      @warnings = warnings
+      @source = source
+    end
+
+    def success?
+      errors.empty?
+    end
+
+    def failure?
+      !success?
+    end
+  end
+
+  class ParseResult < Result
+    attr_reader :value
+
+    def initialize(value, comments, magic_comments, data_loc, errors, warnings, source)
+      @value = value
+      super(comments, magic_comments, data_loc, errors, warnings, source)
+    end
+
+    def deconstruct_keys(keys)
+      { value: value, comments: comments, magic_comments: magic_comments, data_loc: data_loc, errors: errors, warnings: warnings }
+    end
+  end
+
+  class BasicVisitor
+    def visit(node)
+      node&.accept(self)
+    end
+
+    def visit_all(nodes)
+      nodes.each { |node| node&.accept(self) }
+    end
+
+    def visit_child_nodes(node)
+      node.compact_child_nodes.each { |child| child.accept(self) }
+    end
+  end
+
+  class Visitor < BasicVisitor
+  end
+
+  class Node
+    attr_reader :location
+
+    # fields declares the child node fields of a node class, in order, and
+    # attributes its other values; each class gets a Visitor method.
+    def self.fields(*names)
+      @fields = names
+      attr_reader(*names)
+      type = name.split("::").last.gsub(/(?<=[a-z])([A-Z])/, "_\\1").downcase.to_sym
+      define_method(:type) { type }
+      Visitor.send(:define_method, :"visit_#{type}") { |node| visit_child_nodes(node) }
+    end
+
+    def self.attributes(*names)
+      attr_reader(*names)
+    end
+
+    def self.field_names
+      @fields || []
+    end
+
+    def initialize(location, **values)
+      @location = location
+      values.each { |key, value| instance_variable_set(:"@#{key}", value) }
+    end
+
+    def accept(visitor)
+      visitor.send(:"visit_#{type}", self)
+    end
+
+    def child_nodes
+      self.class.field_names.flat_map do |name|
+        value = send(name)
+        value.is_a?(Array) ? value : [value]
+      end
+    end
+    alias deconstruct child_nodes
+
+    def compact_child_nodes
+      child_nodes.compact
+    end
+
+    def slice
+      location.slice
+    end
+
+    def start_offset
+      location.start_offset
+    end
+
+    def end_offset
+      location.end_offset
+    end
+
+    def inspect
+      "#<#{self.class} #{location}>"
+    end
+  end
+
+  {
+    ProgramNode: [[:statements], [:locals]],
+    StatementsNode: [[:body]],
+    CallNode: [[:receiver, :arguments, :block], [:name]],
+    ArgumentsNode: [[:arguments]],
+    BlockNode: [[:parameters, :body], [:locals]],
+    BlockArgumentNode: [[:expression]],
+    BlockParametersNode: [[:parameters]],
+    ParametersNode: [[:requireds, :optionals, :rest, :posts, :keywords, :keyword_rest, :block]],
+    RequiredParameterNode: [[], [:name]],
+    OptionalParameterNode: [[:value], [:name]],
+    RestParameterNode: [[], [:name]],
+    RequiredKeywordParameterNode: [[], [:name]],
+    OptionalKeywordParameterNode: [[:value], [:name]],
+    KeywordRestParameterNode: [[], [:name]],
+    BlockParameterNode: [[], [:name]],
+    LocalVariableReadNode: [[], [:name, :depth]],
+    LocalVariableWriteNode: [[:value], [:name, :depth]],
+    LocalVariableTargetNode: [[], [:name, :depth]],
+    LocalVariableOrWriteNode: [[:value], [:name, :depth]],
+    LocalVariableAndWriteNode: [[:value], [:name, :depth]],
+    InstanceVariableReadNode: [[], [:name]],
+    InstanceVariableWriteNode: [[:value], [:name]],
+    InstanceVariableTargetNode: [[], [:name]],
+    InstanceVariableOrWriteNode: [[:value], [:name]],
+    InstanceVariableAndWriteNode: [[:value], [:name]],
+    GlobalVariableReadNode: [[], [:name]],
+    GlobalVariableWriteNode: [[:value], [:name]],
+    GlobalVariableTargetNode: [[], [:name]],
+    GlobalVariableOrWriteNode: [[:value], [:name]],
+    GlobalVariableAndWriteNode: [[:value], [:name]],
+    ClassVariableReadNode: [[], [:name]],
+    ClassVariableWriteNode: [[:value], [:name]],
+    ClassVariableTargetNode: [[], [:name]],
+    ClassVariableOrWriteNode: [[:value], [:name]],
+    ClassVariableAndWriteNode: [[:value], [:name]],
+    ConstantReadNode: [[], [:name]],
+    ConstantWriteNode: [[:value], [:name]],
+    ConstantTargetNode: [[], [:name]],
+    ConstantOrWriteNode: [[:value], [:name]],
+    ConstantAndWriteNode: [[:value], [:name]],
+    ConstantPathNode: [[:parent], [:name]],
+    ConstantPathWriteNode: [[:target, :value]],
+    NumberedReferenceReadNode: [[], [:number]],
+    BackReferenceReadNode: [[], [:name]],
+    IntegerNode: [[], [:value]],
+    FloatNode: [[], [:value]],
+    RationalNode: [[], [:value]],
+    ImaginaryNode: [[], [:value]],
+    StringNode: [[], [:unescaped]],
+    InterpolatedStringNode: [[:parts]],
+    EmbeddedStatementsNode: [[:statements]],
+    XStringNode: [[], [:unescaped]],
+    InterpolatedXStringNode: [[:parts]],
+    SymbolNode: [[], [:unescaped]],
+    InterpolatedSymbolNode: [[:parts]],
+    RegularExpressionNode: [[], [:unescaped]],
+    InterpolatedRegularExpressionNode: [[:parts]],
+    ArrayNode: [[:elements]],
+    HashNode: [[:elements]],
+    KeywordHashNode: [[:elements]],
+    AssocNode: [[:key, :value]],
+    AssocSplatNode: [[:value]],
+    SplatNode: [[:expression]],
+    NilNode: [[]],
+    TrueNode: [[]],
+    FalseNode: [[]],
+    SelfNode: [[]],
+    SourceFileNode: [[], [:filepath]],
+    SourceLineNode: [[]],
+    IfNode: [[:predicate, :statements, :subsequent]],
+    UnlessNode: [[:predicate, :statements, :else_clause]],
+    ElseNode: [[:statements]],
+    WhileNode: [[:predicate, :statements]],
+    UntilNode: [[:predicate, :statements]],
+    CaseNode: [[:predicate, :conditions, :else_clause]],
+    CaseMatchNode: [[:predicate, :conditions, :else_clause]],
+    WhenNode: [[:conditions, :statements]],
+    InNode: [[:pattern, :statements]],
+    AndNode: [[:left, :right]],
+    OrNode: [[:left, :right]],
+    DefNode: [[:receiver, :parameters, :body], [:name, :locals]],
+    ClassNode: [[:constant_path, :superclass, :body], [:locals]],
+    ModuleNode: [[:constant_path, :body], [:locals]],
+    SingletonClassNode: [[:expression, :body], [:locals]],
+    ReturnNode: [[:arguments]],
+    BreakNode: [[:arguments]],
+    NextNode: [[:arguments]],
+    RedoNode: [[]],
+    RetryNode: [[]],
+    YieldNode: [[:arguments]],
+    SuperNode: [[:arguments, :block]],
+    ForwardingSuperNode: [[:block]],
+    BeginNode: [[:statements, :rescue_clause, :else_clause, :ensure_clause]],
+    RescueNode: [[:exceptions, :reference, :statements, :subsequent]],
+    RescueModifierNode: [[:expression, :rescue_expression]],
+    EnsureNode: [[:statements]],
+    IndexOperatorWriteNode: [[:receiver, :arguments, :value], [:binary_operator]],
+    IndexOrWriteNode: [[:receiver, :arguments, :value]],
+    IndexAndWriteNode: [[:receiver, :arguments, :value]],
+    CallOperatorWriteNode: [[:receiver, :value], [:read_name, :write_name, :binary_operator]],
+    CallOrWriteNode: [[:receiver, :value], [:read_name, :write_name]],
+    CallAndWriteNode: [[:receiver, :value], [:read_name, :write_name]],
+    LambdaNode: [[:parameters, :body], [:locals]],
+    RangeNode: [[:left, :right], [:flags]],
+    MultiWriteNode: [[:lefts, :rest, :rights, :value]],
+    MultiTargetNode: [[:lefts, :rest, :rights]],
+    AliasMethodNode: [[:new_name, :old_name]],
+    AliasGlobalVariableNode: [[:new_name, :old_name]],
+    UndefNode: [[:names]],
+    DefinedNode: [[:value]],
+    MissingNode: [[], [:ast_type]]
+  }.each do |name, (fields, attributes)|
+    klass = Class.new(Node)
+    const_set(name, klass)
+    klass.fields(*fields)
+    klass.attributes(*attributes) if attributes
+  end
+
+  class RangeNode
+    def exclude_end?
+      flags == :exclude_end
+    end
+  end
+
+  class SymbolNode
+    def value
+      unescaped
+    end
+  end
+
+  # TreeBuilder translates a RubyVM::AbstractSyntaxTree tree into Prism
+  # nodes. Constructs the AST has no counterpart for become MissingNode.
+  class TreeBuilder
+    def initialize(source)
+      @source = source
+    end
+
+    def program(root)
+      locals = root.children[0].compact
+      statements = statements(root.children[2], root) || StatementsNode.new(location(root), body: [])
+      ProgramNode.new(location(root), locals: locals, statements: statements)
+    end
+
+    def location(node)
+      start = @source.offset(node.first_lineno, node.first_column)
+      Location.new(@source, start, @source.offset(node.last_lineno, node.last_column) - start)
+    end
+
+    def locations(first, last)
+      location(first).join(location(last))
+    end
+
+    def statements(body, owner = body)
+      return nil if body.nil?
+      nodes = body.type == :BLOCK ? body.children.map { |child| node(child) } : [node(body)]
+      StatementsNode.new(location(body), body: nodes)
+    end
+
+    def list(node)
+      return [] if node.nil?
+      case node.type
+      when :LIST then node.children.compact.map { |child| node(child) }
+      when :ZLIST then []
+      when :SPLAT then [SplatNode.new(location(node), expression: node(node.children[0]))]
+      when :ARGSCAT
+        head, rest = node.children
+        tail = rest.type == :LIST ? list(rest) : [SplatNode.new(location(rest), expression: node(rest))]
+        list(node.children[0]) + tail
+      when :ARGSPUSH then list(node.children[0]) + [node(node.children[1])]
+      else [node(node)]
+      end
+    end
+
+    # arguments returns the ArgumentsNode and BlockArgumentNode of a call.
+    def arguments(args)
+      block = nil
+      if args&.type == :BLOCK_PASS
+        block = BlockArgumentNode.new(location(args.children[1]), expression: node(args.children[1]))
+        args = args.children[0]
+      end
+      nodes = list(args)
+      nodes = nodes.map { |arg| arg.is_a?(HashNode) && !arg.slice.start_with?("{") ? KeywordHashNode.new(arg.location, elements: arg.elements) : arg }
+      arguments = nodes.empty? ? nil : ArgumentsNode.new(nodes.first.location.join(nodes.last.location), arguments: nodes)
+      [arguments, block]
+    end
+
+    def call(node, receiver, name, args, block = nil)
+      arguments, block_argument = arguments(args)
+      CallNode.new(location(node), receiver: receiver && node(receiver), name: name, arguments: arguments, block: block || block_argument)
+    end
+
+    def variable(kind, node, suffix, **values)
+      Prism.const_get(kind + suffix).new(location(node), name: node.children[0], **values)
+    end
+
+    def assignment_kind(type)
+      case type
+      when :LASGN, :DASGN then "LocalVariable"
+      when :IASGN then "InstanceVariable"
+      when :GASGN then "GlobalVariable"
+      when :CVASGN then "ClassVariable"
+      when :CDECL then "Constant"
+      end
+    end
+
+    def target(node)
+      case node.type
+      when :MASGN then multi(node, MultiTargetNode)
+      when :LASGN, :DASGN then LocalVariableTargetNode.new(location(node), name: node.children[0], depth: 0)
+      when :IASGN, :GASGN, :CVASGN, :CDECL then variable(assignment_kind(node.type), node, "TargetNode")
+      when :SPLAT then SplatNode.new(location(node), expression: node.children[0] && target(node.children[0]))
+      else node(node)
+      end
+    end
+
+    def multi(node, klass)
+      value, lefts, rest = node.children
+      rights = []
+      if rest.is_a?(RubyVM::AbstractSyntaxTree::Node) && rest.type == :POSTARG
+        rest, posts = rest.children
+        rights = posts.children.compact.map { |child| target(child) }
+      end
+      rest = case rest
+             when nil then nil
+             when :NODE_SPECIAL_NO_NAME_REST then SplatNode.new(location(node), expression: nil)
+             else SplatNode.new(location(rest), expression: target(rest))
+             end
+      values = { lefts: lefts ? lefts.children.compact.map { |child| target(child) } : [], rest: rest, rights: rights }
+      values[:value] = value && (value.type == :LIST ? ArrayNode.new(location(value), elements: list(value)) : node(value)) if klass == MultiWriteNode
+      klass.new(location(node), **values)
+    end
+
+    def parameters(scope, args)
+      return nil if args.nil?
+      names = scope.children[0]
+      pre_num, _, opt, first_post, post_num, _, rest, kw, kwrest, block = args.children
+      requireds = names.first(pre_num).map { |name| parameter(args, RequiredParameterNode, name) }
+      optionals = []
+      while opt
+        assign = opt.children[0]
+        optionals << OptionalParameterNode.new(location(assign), name: assign.children[0], value: node(assign.children[1]))
+        opt = opt.children[1]
+      end
+      posts = []
+      if first_post
+        start = names.index(first_post)
+        posts = names[start, post_num].map { |name| parameter(args, RequiredParameterNode, name) }
+      end
+      keywords = []
+      while kw
+        assign = kw.children[0]
+        keywords << if assign.children[1] == :NODE_SPECIAL_REQUIRED_KEYWORD
+                      RequiredKeywordParameterNode.new(location(assign), name: assign.children[0])
+                    else
+                      OptionalKeywordParameterNode.new(location(assign), name: assign.children[0], value: node(assign.children[1]))
+                    end
+        kw = kw.children[1]
+      end
+      rest = rest && parameter(args, RestParameterNode, rest == :NODE_SPECIAL_NO_NAME_REST ? nil : rest)
+      kwrest = kwrest && parameter(args, KeywordRestParameterNode, kwrest)
+      block = block && parameter(args, BlockParameterNode, block)
+      ParametersNode.new(location(args), requireds: requireds, optionals: optionals, rest: rest, posts: posts,
+                                         keywords: keywords, keyword_rest: kwrest, block: block)
+    end
+
+    # parameter finds a parameter name in the parameter list source, since
+    # the AST keeps names only in the scope's local table.
+    def parameter(args, klass, name)
+      location = location(args)
+      if name && (index = location.slice.index(/(?<![\w@$])#{Regexp.escape(name.to_s)}(?!\w)/))
+        start = location.start_offset + location.slice[0, index].bytesize
+        location = Location.new(@source, start, name.to_s.bytesize)
+      end
+      klass.new(location, name: name)
+    end
+
+    def body(scope)
+      body = scope.children[2]
+      return nil if body.nil?
+      return node(body, implicit_begin: true) if body.type == :RESCUE || body.type == :ENSURE
+      statements(body)
+    end
+
+    def block(node, scope)
+      args = scope.children[1]
+      params = args && parameters(scope, args)
+      params = BlockParametersNode.new(params.location, parameters: params) if params
+      BlockNode.new(location(node), locals: scope.children[0].compact, parameters: params, body: body(scope))
+    end
+
+    def pieces(node)
+      head, first, rest = node.children
+      parts = []
+      parts << StringNode.new(location(node), unescaped: head) if head && !head.empty?
+      parts << piece(first) if first
+      parts.concat(rest.children.compact.map { |child| piece(child) }) if rest
+      parts
+    end
+
+    def piece(node)
+      return node(node) unless node.type == :EVSTR
+      EmbeddedStatementsNode.new(location(node), statements: node.children[0] && statements(node.children[0]))
+    end
+
+    def rescue_clause(node)
+      exceptions, reference, body, subsequent = node.children
+      RescueNode.new(location(node), exceptions: list(exceptions), reference: reference && target(reference),
+                                     statements: statements(body), subsequent: subsequent && rescue_clause(subsequent))
+    end
+
+    def begin_node(node, body)
+      values = { statements: nil, rescue_clause: nil, else_clause: nil, ensure_clause: nil }
+      if body&.type == :ENSURE
+        values[:ensure_clause] = EnsureNode.new(location(body.children[1]), statements: statements(body.children[1]))
+        body = body.children[0]
+      end
+      if body&.type == :RESCUE
+        values[:rescue_clause] = rescue_clause(body.children[1])
+        values[:else_clause] = ElseNode.new(location(body.children[2]), statements: statements(body.children[2])) if body.children[2]
+        body = body.children[0]
+      end
+      values[:statements] = statements(body)
+      BeginNode.new(location(node), **values)
+    end
+
+    def else_clause(node)
+      node && ElseNode.new(location(node), statements: statements(node))
+    end
+
+    def node(node, implicit_begin: false)
+      return nil if node.nil?
+      children = node.children
+      case node.type
+      when :BLOCK then statements(node)
+      when :CALL, :QCALL, :OPCALL then call(node, children[0], children[1], children[2])
+      when :ATTRASGN then call(node, children[0], children[1], children[2])
+      when :FCALL then call(node, nil, children[0], children[1])
+      when :VCALL then call(node, nil, children[0], nil)
+      when :MATCH2, :MATCH3 then CallNode.new(location(node), receiver: node(children[0]), name: :=~, arguments: ArgumentsNode.new(location(children[1]), arguments: [node(children[1])]), block: nil)
+      when :ITER
+        call = node(children[0])
+        call = CallNode.new(call.location, receiver: call.receiver, name: call.name, arguments: call.arguments, block: block(node, children[1])) if call.is_a?(CallNode)
+        call
+      when :LAMBDA
+        scope = children[0]
+        LambdaNode.new(location(node), locals: scope.children[0].compact, parameters: scope.children[1] && BlockParametersNode.new(location(scope.children[1]), parameters: parameters(scope, scope.children[1])), body: body(scope))
+      when :LASGN, :DASGN
+        LocalVariableWriteNode.new(location(node), name: children[0], depth: 0, value: node(children[1]))
+      when :IASGN, :GASGN, :CVASGN then variable(assignment_kind(node.type), node, "WriteNode", value: node(children[1]))
+      when :CDECL
+        if children[0].is_a?(Symbol)
+          ConstantWriteNode.new(location(node), name: children[0], value: node(children[1]))
+        else
+          ConstantPathWriteNode.new(location(node), target: node(children[0]), value: node(children[1]))
+        end
+      when :OP_ASGN_OR, :OP_ASGN_AND
+        assign = children[1]
+        kind = assignment_kind(assign.type)
+        return MissingNode.new(location(node), ast_type: node.type) unless kind
+        suffix = node.type == :OP_ASGN_OR ? "OrWriteNode" : "AndWriteNode"
+        values = { value: node(assign.children[1]) }
+        values[:depth] = 0 if kind == "LocalVariable"
+        variable(kind, assign, suffix, **values).tap { |write| write.instance_variable_set(:@location, location(node)) }
+      when :OP_ASGN1
+        receiver, operator, index, value = children
+        arguments = arguments(index)[0]
+        case operator
+        when :"||" then IndexOrWriteNode.new(location(node), receiver: node(receiver), arguments: arguments, value: node(value))
+        when :"&&" then IndexAndWriteNode.new(location(node), receiver: node(receiver), arguments: arguments, value: node(value))
+        else IndexOperatorWriteNode.new(location(node), receiver: node(receiver), arguments: arguments, value: node(value), binary_operator: operator)
+        end
+      when :OP_ASGN2
+        receiver, _, name, operator, value = children
+        names = { receiver: node(receiver), value: node(value), read_name: name, write_name: :"#{name}=" }
+        case operator
+        when :"||" then CallOrWriteNode.new(location(node), **names)
+        when :"&&" then CallAndWriteNode.new(location(node), **names)
+        else CallOperatorWriteNode.new(location(node), binary_operator: operator, **names)
+        end
+      when :LVAR, :DVAR then LocalVariableReadNode.new(location(node), name: children[0], depth: 0)
+      when :IVAR then InstanceVariableReadNode.new(location(node), name: children[0])
+      when :GVAR then GlobalVariableReadNode.new(location(node), name: children[0])
+      when :CVAR then ClassVariableReadNode.new(location(node), name: children[0])
+      when :CONST then ConstantReadNode.new(location(node), name: children[0])
+      when :COLON2
+        ConstantPathNode.new(location(node), parent: node(children[0]), name: children[1])
+      when :COLON3 then ConstantPathNode.new(location(node), parent: nil, name: children[0])
+      when :NTH_REF then NumberedReferenceReadNode.new(location(node), number: children[0].to_s.delete("$").to_i)
+      when :BACK_REF then BackReferenceReadNode.new(location(node), name: children[0])
+      when :INTEGER then IntegerNode.new(location(node), value: children[0])
+      when :FLOAT then FloatNode.new(location(node), value: children[0])
+      when :RATIONAL then RationalNode.new(location(node), value: children[0])
+      when :IMAGINARY then ImaginaryNode.new(location(node), value: children[0])
+      when :STR then StringNode.new(location(node), unescaped: children[0])
+      when :XSTR then XStringNode.new(location(node), unescaped: children[0])
+      when :SYM then SymbolNode.new(location(node), unescaped: children[0].to_s)
+      when :REGX then RegularExpressionNode.new(location(node), unescaped: children[0].source)
+      when :DSTR then InterpolatedStringNode.new(location(node), parts: pieces(node))
+      when :DXSTR then InterpolatedXStringNode.new(location(node), parts: pieces(node))
+      when :DSYM then InterpolatedSymbolNode.new(location(node), parts: pieces(node))
+      when :DREGX then InterpolatedRegularExpressionNode.new(location(node), parts: pieces(node))
+      when :EVSTR then piece(node)
+      when :LIST, :ZLIST then ArrayNode.new(location(node), elements: list(node))
+      when :SPLAT, :ARGSCAT, :ARGSPUSH then ArrayNode.new(location(node), elements: list(node))
+      when :HASH
+        pairs = children[0] ? children[0].children[0...-1] : []
+        elements = pairs.each_slice(2).map do |key, value|
+          if key.nil?
+            AssocSplatNode.new(location(value), value: node(value))
+          else
+            AssocNode.new(locations(key, value), key: node(key), value: node(value))
+          end
+        end
+        HashNode.new(location(node), elements: elements)
+      when :NIL then NilNode.new(location(node))
+      when :TRUE then TrueNode.new(location(node))
+      when :FALSE then FalseNode.new(location(node))
+      when :SELF then SelfNode.new(location(node))
+      when :FILE then SourceFileNode.new(location(node), filepath: children[0])
+      when :LINE then SourceLineNode.new(location(node))
+      when :IF
+        subsequent = children[2]
+        subsequent = subsequent.type == :IF && subsequent.first_lineno != node.first_lineno && @source.slice(location(subsequent).start_offset, 5) == "elsif" ? node(subsequent) : else_clause(subsequent) if subsequent
+        IfNode.new(location(node), predicate: node(children[0]), statements: statements(children[1]), subsequent: subsequent)
+      when :UNLESS
+        UnlessNode.new(location(node), predicate: node(children[0]), statements: statements(children[1]), else_clause: else_clause(children[2]))
+      when :WHILE then WhileNode.new(location(node), predicate: node(children[0]), statements: statements(children[1]))
+      when :UNTIL then UntilNode.new(location(node), predicate: node(children[0]), statements: statements(children[1]))
+      when :CASE, :CASE2, :CASE3
+        conditions = []
+        clause = children[1]
+        while clause && (clause.type == :WHEN || clause.type == :IN)
+          if clause.type == :WHEN
+            conditions << WhenNode.new(location(clause), conditions: list(clause.children[0]), statements: statements(clause.children[1]))
+          else
+            conditions << InNode.new(location(clause), pattern: node(clause.children[0]), statements: statements(clause.children[1]))
+          end
+          clause = clause.children[2]
+        end
+        klass = node.type == :CASE3 ? CaseMatchNode : CaseNode
+        klass.new(location(node), predicate: node(children[0]), conditions: conditions, else_clause: else_clause(clause))
+      when :AND then AndNode.new(location(node), left: node(children[0]), right: node(children[1]))
+      when :OR then OrNode.new(location(node), left: node(children[0]), right: node(children[1]))
+      when :DEFN, :DEFS
+        receiver, name, scope = node.type == :DEFS ? children : [nil, *children]
+        DefNode.new(location(node), name: name, receiver: node(receiver), parameters: parameters(scope, scope.children[1]),
+                                    body: body(scope), locals: scope.children[0].compact)
+      when :CLASS
+        ClassNode.new(location(node), constant_path: node(children[0]), superclass: node(children[1]),
+                                      body: body(children[2]), locals: children[2].children[0].compact)
+      when :MODULE
+        ModuleNode.new(location(node), constant_path: node(children[0]), body: body(children[1]), locals: children[1].children[0].compact)
+      when :SCLASS
+        SingletonClassNode.new(location(node), expression: node(children[0]), body: body(children[1]), locals: children[1].children[0].compact)
+      when :RETURN, :BREAK, :NEXT
+        arguments = children[0] && ArgumentsNode.new(location(children[0]), arguments: children[0].type == :LIST ? list(children[0]) : [node(children[0])])
+        Prism.const_get(node.type.to_s.capitalize + "Node").new(location(node), arguments: arguments)
+      when :REDO then RedoNode.new(location(node))
+      when :RETRY then RetryNode.new(location(node))
+      when :YIELD then YieldNode.new(location(node), arguments: arguments(children[0])[0])
+      when :SUPER
+        arguments, block = arguments(children[0])
+        SuperNode.new(location(node), arguments: arguments, block: block)
+      when :ZSUPER then ForwardingSuperNode.new(location(node), block: nil)
+      when :BEGIN then begin_node(node, children[0])
+      when :RESCUE, :ENSURE
+        return begin_node(node, node) if implicit_begin || node.type == :ENSURE
+        RescueModifierNode.new(location(node), expression: node(children[0]), rescue_expression: node(children[1].children[2]))
+      when :DOT2, :DOT3
+        RangeNode.new(location(node), left: node(children[0]), right: node(children[1]), flags: node.type == :DOT3 ? :exclude_end : nil)
+      when :MASGN then multi(node, MultiWriteNode)
+      when :ALIAS then AliasMethodNode.new(location(node), new_name: node(children[0]), old_name: node(children[1]))
+      when :VALIAS
+        AliasGlobalVariableNode.new(location(node), new_name: GlobalVariableReadNode.new(location(node), name: children[0]),
+                                                    old_name: GlobalVariableReadNode.new(location(node), name: children[1]))
+      when :UNDEF then UndefNode.new(location(node), names: children.map { |child| node(child) })
+      when :DEFINED then DefinedNode.new(location(node), value: node(children[0]))
+      when :SCOPE then program(node)
+      else MissingNode.new(location(node), ast_type: node.type)
+      end
+    end
+  end
+  private_constant :TreeBuilder
+
+  # parse returns a ParseResult for source. Syntax errors do not raise;
+  # they are listed in errors and value is an empty program.
+  def self.parse(source, filepath: nil, **options)
+    tree, comments, errors, data_offset = __scan(source, filepath)
+    prism_source = Source.new(source, options.fetch(:line, 1))
+    comments = comments.map do |kind, start, finish|
+      location = Location.new(prism_source, start, finish - start)
+      kind == :embdoc ? EmbDocComment.new(location) : InlineComment.new(location)
+    end
+    magic_comments = comments.filter_map do |comment|
+      next unless comment.is_a?(InlineComment)
+      match = comment.slice.match(/\A#\s*(?:-\*-\s*)?([\w-]+)\s*:\s*([^\s;]+)/)
+      next unless match
+      start = comment.location.start_offset
+      MagicComment.new(Location.new(prism_source, start + match.begin(1), match[1].bytesize),
+                       Location.new(prism_source, start + match.begin(2), match[2].bytesize))
+    end
+    errors = errors.map do |message, line, column, length|
+      start = prism_source.offset(line, column)
+      ParseError.new(:syntax_error, message, Location.new(prism_source, start, length), :syntax)
+    end
+    data_loc = data_offset && Location.new(prism_source, data_offset, source.bytesize - data_offset)
+    value = if tree
+              TreeBuilder.new(prism_source).program(tree)
+            else
+              location = Location.new(prism_source, 0, source.bytesize)
+              ProgramNode.new(location, locals: [], statements: StatementsNode.new(location, body: []))
+            end
+    ParseResult.new(value, comments, magic_comments, data_loc, errors, [], prism_source)
+  end
+
+  def self.parse_file(filepath, **options)
+    parse(File.read(filepath), filepath: filepath, **options)
+  end
+
+  def self.parse_comments(source, **options)
+    parse(source, **options).comments
+  end
+
+  def self.parse_success?(source, **options)
+    parse(source, **options).success?
+  end
+
+  def self.parse_failure?(source, **options)
+    !parse_success?(source, **options)
+  end
+end
+`
@@ -0,0 +1,629 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+)
+
+// ripperData is the Go state behind a Ripper instance. It lives in
+// ripperStates rather than the receiver's Data because Ruby subclasses of
+// Ripper are ordinary objects.
+type ripperData struct {
+	source   string
+	filename *object.EmeraldValue
+	lineno   int
+	tokens   []ripperToken
+	current  ripperToken
+	parsing  bool
+	errored  bool
+	endSeen  bool
+}
+
+var ripperStates = make(map[*object.EmeraldValue]*ripperData)
+
+// ripperParserEvents maps each parser event to its arity, as in MRI's
+// Ripper::PARSER_EVENT_TABLE.
+var ripperParserEvents = map[string]int{
+	"BEGIN": 1, "END": 1, "alias": 2, "alias_error": 2, "aref": 2, "aref_field": 2, "arg_ambiguous": 1,
+	"arg_paren": 1, "args_add": 2, "args_add_block": 2, "args_add_star": 2, "args_forward": 0, "args_new": 0,
+	"array": 1, "aryptn": 4, "assign": 2, "assign_error": 2, "assoc_new": 2, "assoc_splat": 1,
+	"assoclist_from_args": 1, "bare_assoc_hash": 1, "begin": 1, "binary": 3, "block_var": 2, "blockarg": 1,
+	"bodystmt": 4, "brace_block": 2, "break": 1, "call": 3, "case": 2, "class": 3, "class_name_error": 2,
+	"command": 2, "command_call": 4, "const_path_field": 2, "const_path_ref": 2, "const_ref": 1, "def": 3,
+	"defined": 1, "defs": 5, "do_block": 2, "dot2": 2, "dot3": 2, "dyna_symbol": 1, "else": 1, "elsif": 3,
+	"ensure": 1, "excessed_comma": 0, "fcall": 1, "field": 3, "fndptn": 4, "for": 3, "hash": 1,
+	"heredoc_dedent": 2, "hshptn": 3, "if": 3, "if_mod": 2, "ifop": 3, "in": 3, "kwrest_param": 1,
+	"lambda": 2, "magic_comment": 2, "massign": 2, "method_add_arg": 2, "method_add_block": 2, "mlhs_add": 2,
+	"mlhs_add_post": 2, "mlhs_add_star": 2, "mlhs_new": 0, "mlhs_paren": 1, "module": 2, "mrhs_add": 2,
+	"mrhs_add_star": 2, "mrhs_new": 0, "mrhs_new_from_args": 1, "next": 1, "nokw_param": 1, "opassign": 3,
+	"operator_ambiguous": 2, "param_error": 2, "params": 7, "paren": 1, "parse_error": 1, "program": 1,
+	"qsymbols_add": 2, "qsymbols_new": 0, "qwords_add": 2, "qwords_new": 0, "redo": 0, "regexp_add": 2,
+	"regexp_literal": 2, "regexp_new": 0, "rescue": 4, "rescue_mod": 2, "rest_param": 1, "retry": 0,
+	"return": 1, "return0": 0, "sclass": 2, "stmts_add": 2, "stmts_new": 0, "string_add": 2,
+	"string_concat": 2, "string_content": 0, "string_dvar": 1, "string_embexpr": 1, "string_literal": 1,
+	"super": 1, "symbol": 1, "symbol_literal": 1, "symbols_add": 2, "symbols_new": 0, "top_const_field": 1,
+	"top_const_ref": 1, "unary": 2, "undef": 1, "unless": 3, "unless_mod": 2, "until": 2, "until_mod": 2,
+	"var_alias": 2, "var_field": 1, "var_ref": 1, "vcall": 1, "void_stmt": 0, "when": 3, "while": 2,
+	"while_mod": 2, "word_add": 2, "word_new": 0, "words_add": 2, "words_new": 0, "xstring_add": 2,
+	"xstring_literal": 1, "xstring_new": 0, "yield": 1, "yield0": 0, "zsuper": 0,
+}
+
+// ripperScannerEvents lists the token events; all take the token text.
+var ripperScannerEvents = []string{
+	"CHAR", "__end__", "backref", "backtick", "comma", "comment", "const", "cvar", "embdoc", "embdoc_beg",
+	"embdoc_end", "embexpr_beg", "embexpr_end", "embvar", "float", "gvar", "heredoc_beg", "heredoc_end",
+	"ident", "ignored_nl", "imaginary", "int", "ivar", "kw", "label", "label_end", "lbrace", "lbracket",
+	"lparen", "nl", "op", "period", "qsymbols_beg", "qwords_beg", "rational", "rbrace", "rbracket",
+	"regexp_beg", "regexp_end", "rparen", "semicolon", "sp", "symbeg", "symbols_beg", "tlambda", "tlambeg",
+	"tstring_beg", "tstring_content", "tstring_end", "words_beg", "words_sep", "ignored_sp",
+}
+
+func installRipperModule(objectClass *object.Class) {
+	if objectClass == nil {
+		return
+	}
+	if existing := objectClass.Constants["Ripper"]; existing != nil && existing.Type == object.ValueClass {
+		return
+	}
+	klass := object.NewClass("Ripper")
+	klass.SuperClass = objectClass
+	klass.DefineClassMethod("lex_state_name", &object.Method{Name: "lex_state_name", Fn: ripperLexStateName, Arity: 1})
+	klass.DefineMethod("initialize", &object.Method{Name: "initialize", Fn: ripperInitialize, Arity: -1, Visibility: "private"})
+	klass.DefineMethod("parse", &object.Method{Name: "parse", Fn: ripperParse, Arity: 0})
+	klass.DefineMethod("lineno", &object.Method{Name: "lineno", Fn: ripperLineno, Arity: 0})
+	klass.DefineMethod("column", &object.Method{Name: "column", Fn: ripperColumn, Arity: 0})
+	klass.DefineMethod("state", &object.Method{Name: "state", Fn: ripperState, Arity: 0})
+	klass.DefineMethod("token", &object.Method{Name: "token", Fn: ripperTokenText, Arity: 0})
+	klass.DefineMethod("filename", &object.Method{Name: "filename", Fn: ripperFilename, Arity: 0})
+	klass.DefineMethod("encoding", &object.Method{Name: "encoding", Fn: ripperEncoding, Arity: 0})
+	klass.DefineMethod("error?", &object.Method{Name: "error?", Fn: ripperErrorPredicate, Arity: 0})
+	klass.DefineMethod("end_seen?", &object.Method{Name: "end_seen?", Fn: ripperEndSeen, Arity: 0})
+	klass.DefineMethod("yydebug", &object.Method{Name: "yydebug", Fn: func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		return R.FalseVal
+	}, Arity: 0})
+	klass.DefineMethod("yydebug=", &object.Method{Name: "yydebug=", Fn: func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		return args[0]
+	}, Arity: 1})
+
+	// Parser events default to their first argument and scanner events to
+	// the token, so a bare Ripper#parse returns nil like MRI's.
+	first := func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		if len(args) == 0 {
+			return R.NilVal
+		}
+		return args[0]
+	}
+	for arity := 0; arity <= 7; arity++ {
+		name := "_dispatch_" + string(rune('0'+arity))
+		klass.DefineMethod(name, &object.Method{Name: name, Fn: first, Arity: arity, Visibility: "private"})
+	}
+	parserTable := emptyHashValue()
+	parserNames := make([]string, 0, len(ripperParserEvents))
+	for name := range ripperParserEvents {
+		parserNames = append(parserNames, name)
+	}
+	sort.Strings(parserNames)
+	for _, name := range parserNames {
+		arity := ripperParserEvents[name]
+		klass.DefineMethod("on_"+name, &object.Method{Name: "on_" + name, Fn: first, Arity: arity, Visibility: "private"})
+		key := rubySymbol(name)
+		hashData(parserTable).Keys = append(hashData(parserTable).Keys, key)
+		hashData(parserTable).Pairs[key] = newInt(int64(arity))
+	}
+	scannerTable := emptyHashValue()
+	for _, name := range ripperScannerEvents {
+		klass.DefineMethod("on_"+name, &object.Method{Name: "on_" + name, Fn: first, Arity: 1, Visibility: "private"})
+		key := rubySymbol(name)
+		hashData(scannerTable).Keys = append(hashData(scannerTable).Keys, key)
+		hashData(scannerTable).Pairs[key] = newInt(1)
+	}
+	klass.DefineConstant("PARSER_EVENT_TABLE", parserTable)
+	klass.DefineConstant("SCANNER_EVENT_TABLE", scannerTable)
+	for i, name := range ripperStateNames {
+		klass.DefineConstant("EXPR_"+name, newInt(int64(1)<<i))
+	}
+	klass.DefineConstant("EXPR_NONE", newInt(0))
+	klass.DefineConstant("EXPR_VALUE", newInt(ripperExprValue))
+	klass.DefineConstant("EXPR_BEG_ANY", newInt(ripperExprBegAny))
+	klass.DefineConstant("EXPR_ARG_ANY", newInt(ripperExprArgAny))
+	klass.DefineConstant("EXPR_END_ANY", newInt(ripperExprEndAny))
+	klass.DefineConstant("Version", rubyString("0.1.0"))
+
+	R.Classes["Ripper"] = klass
+	value := &object.EmeraldValue{Type: object.ValueClass, Data: klass, Class: R.Classes["Class"]}
+	objectClass.DefineConstant("Ripper", value)
+	AssignConstantName(&object.EmeraldValue{Type: object.ValueClass, Data: objectClass, Class: R.Classes["Class"]}, "Ripper", value)
+	if EvalSource != nil {
+		EvalSource(ripperPrelude)
+	}
+}
+
+func ripperLexStateName(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	state, ok := args[0].Data.(int64)
+	if args[0].Type != object.ValueInteger || !ok {
+		return NewTypeError("no implicit conversion of " + valueTypeName(args[0]) + " into Integer")
+	}
+	return rubyString(ripperStateString(int(state)))
+}
+
+// ripperInitialize accepts a String or anything responding to gets, the
+// file name and the first line number.
+func ripperInitialize(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if len(args) == 0 || len(args) > 3 {
+		return NewArgumentError("wrong number of arguments (given " + strconv.Itoa(len(args)) + ", expected 1..3)")
+	}
+	data := &ripperData{filename: rubyString("(ripper)"), lineno: 1}
+	if args[0].Type == object.ValueString {
+		_, source, errVal := cgiStringArg(args[0])
+		if errVal != nil {
+			return errVal
+		}
+		data.source = source
+	} else if receiverHasCallableMethod(args[0], "gets") {
+		var source strings.Builder
+		for {
+			line := CallMethod(args[0], "gets")
+			if line == nil || line.Type == object.ValueNil {
+				break
+			}
+			if line.Type == object.ValueException {
+				return line
+			}
+			_, text, errVal := cgiStringArg(line)
+			if errVal != nil {
+				return errVal
+			}
+			source.WriteString(text)
+		}
+		data.source = source.String()
+	} else {
+		return NewTypeError("no implicit conversion of " + valueTypeName(args[0]) + " into String")
+	}
+	if len(args) > 1 && args[1].Type != object.ValueNil {
+		if _, _, errVal := cgiStringArg(args[1]); errVal != nil {
+			return errVal
+		}
+		data.filename = args[1]
+	}
+	if len(args) > 2 {
+		lineno, ok := args[2].Data.(int64)
+		if args[2].Type != object.ValueInteger || !ok {
+			return NewTypeError("no implicit conversion of " + valueTypeName(args[2]) + " into Integer")
+		}
+		data.lineno = int(lineno)
+	}
+	receiverInstanceVarMap(receiver)["@source"] = args[0]
+	ripperStates[receiver] = data
+	return R.NilVal
+}
+
+func ripperStateOf(receiver *object.EmeraldValue) (*ripperData, *object.EmeraldValue) {
+	data := ripperStates[receiver]
+	if data == nil {
+		return nil, NewArgumentError("method called for uninitialized object")
+	}
+	return data, nil
+}
+
+// ripperParse scans the source, parses it and dispatches every scanner and
+// parser event to the receiver. Each syntax error the parser recovers from
+// dispatches on_parse_error after the tokens, and parse returns nil.
+func ripperParse(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	data.tokens, data.endSeen = ripperScan(data.source, data.lineno)
+	data.errored = false
+	data.parsing = true
+	defer func() { data.parsing = false }()
+
+	source := data.source
+	if data.endSeen {
+		for _, token := range data.tokens {
+			if token.Event == "__end__" {
+				source = source[:token.Offset]
+				break
+			}
+		}
+	}
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	dispatcher := newRipperDispatcher(receiver, data)
+	return ripperRun(func() *object.EmeraldValue {
+		if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+			dispatcher.flush(len(data.tokens) - 1)
+			data.errored = true
+			for _, diagnostic := range diagnostics {
+				data.current.Line = diagnostic.Line + data.lineno - 1
+				data.current.Column = diagnostic.Column - 1
+				if data.current.Column < 0 {
+					data.current.Column = 0
+				}
+				dispatcher.event("parse_error", rubyString(diagnostic.Message))
+			}
+			return R.NilVal
+		}
+		return dispatcher.program(program)
+	})
+}
+
+// ripperRun turns an exception raised by an event handler back into a
+// return value.
+func ripperRun(fn func() *object.EmeraldValue) (result *object.EmeraldValue) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			abort, ok := recovered.(ripperAbort)
+			if !ok {
+				panic(recovered)
+			}
+			result = abort.exception
+		}
+	}()
+	return fn()
+}
+
+func ripperLineno(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	if !data.parsing {
+		return R.NilVal
+	}
+	if data.current.Line == 0 {
+		return newInt(int64(data.lineno))
+	}
+	return newInt(int64(data.current.Line))
+}
+
+func ripperColumn(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	if !data.parsing {
+		return R.NilVal
+	}
+	return newInt(int64(data.current.Column))
+}
+
+func ripperState(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	if !data.parsing {
+		return R.NilVal
+	}
+	return newInt(int64(data.current.State))
+}
+
+func ripperTokenText(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	if !data.parsing {
+		return R.NilVal
+	}
+	return rubyString(data.current.Text)
+}
+
+func ripperFilename(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	return data.filename
+}
+
+func ripperEncoding(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if _, errVal := ripperStateOf(receiver); errVal != nil {
+		return errVal
+	}
+	return CallMethod(rubyString(""), "encoding")
+}
+
+func ripperErrorPredicate(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	if data.errored {
+		return R.TrueVal
+	}
+	return R.FalseVal
+}
+
+func ripperEndSeen(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	data, errVal := ripperStateOf(receiver)
+	if errVal != nil {
+		return errVal
+	}
+	if data.endSeen {
+		return R.TrueVal
+	}
+	return R.FalseVal
+}
+
+// ripperPrelude is the Ruby half of ripper.rb: the event lists, the Lexer,
+// Filter and SexpBuilder helpers and the Ripper.lex/sexp entry points.
+const ripperPrelude = `class Ripper
+  PARSER_EVENTS = PARSER_EVENT_TABLE.keys
+  SCANNER_EVENTS = SCANNER_EVENT_TABLE.keys
+  EVENTS = PARSER_EVENTS + SCANNER_EVENTS
+
+  def self.parse(src, filename = "(ripper)", lineno = 1)
+    new(src, filename, lineno).parse
+  end
+
+  def self.lex(src, filename = "-", lineno = 1, **kw)
+    Lexer.new(src, filename, lineno).lex(**kw)
+  end
+
+  def self.tokenize(src, filename = "-", lineno = 1, **kw)
+    Lexer.new(src, filename, lineno).tokenize(**kw)
+  end
+
+  def self.sexp(src, filename = "-", lineno = 1, raise_errors: false)
+    builder = SexpBuilderPP.new(src, filename, lineno)
+    sexp = builder.parse
+    if builder.error?
+      raise SyntaxError, builder.error if raise_errors
+      return nil
+    end
+    sexp
+  end
+
+  def self.sexp_raw(src, filename = "-", lineno = 1, raise_errors: false)
+    builder = SexpBuilder.new(src, filename, lineno)
+    sexp = builder.parse
+    if builder.error?
+      raise SyntaxError, builder.error if raise_errors
+      return nil
+    end
+    sexp
+  end
+
+  def compile_error(message)
+  end
+
+  def warn(fmt, *args)
+  end
+
+  def warning(fmt, *args)
+  end
+
+  class Lexer < Ripper
+    class State
+      attr_reader :to_int, :to_s
+
+      def initialize(i)
+        @to_int = i
+        @to_s = Ripper.lex_state_name(i)
+        freeze
+      end
+
+      def [](index)
+        case index
+        when 0, :to_int
+          @to_int
+        when 1, :to_s
+          @to_s
+        end
+      end
+
+      def to_i
+        @to_int
+      end
+
+      def inspect
+        "#<Ripper::Lexer::State: #{@to_s}>"
+      end
+
+      def pretty_print(q)
+        q.text(@to_s)
+      end
+
+      def ==(other)
+        other.is_a?(State) ? @to_int == other.to_int : @to_int == other
+      end
+
+      def &(other)
+        State.new(@to_int & other.to_i)
+      end
+
+      def |(other)
+        State.new(@to_int | other.to_i)
+      end
+
+      def allbits?(other)
+        @to_int & other.to_i == other.to_i
+      end
+
+      def anybits?(other)
+        @to_int & other.to_i != 0
+      end
+
+      def nobits?(other)
+        @to_int & other.to_i == 0
+      end
+    end
+
+    class Elem
+      attr_accessor :pos, :event, :tok, :state, :message
+
+      def initialize(pos, event, tok, state, message = nil)
+        @pos = pos
+        @event = event
+        @tok = tok
+        @state = state.is_a?(State) ? state : State.new(state)
+        @message = message
+      end
+
+      def [](index)
+        case index
+        when 0, :pos then @pos
+        when 1, :event then @event
+        when 2, :tok then @tok
+        when 3, :state then @state
+        when 4, :message then @message
+        end
+      end
+
+      def inspect
+        "#<#{self.class}: #{@event}@#{@pos[0]}:#{@pos[1]}:#{@state}: #{@tok.inspect}#{": " if @message}#{@message}>"
+      end
+
+      def to_s
+        inspect
+      end
+
+      def to_a
+        @message ? [@pos, @event, @tok, @state, @message] : [@pos, @event, @tok, @state]
+      end
+    end
+
+    attr_reader :errors
+
+    def tokenize(**kw)
+      parse(**kw).sort_by(&:pos).map(&:tok)
+    end
+
+    def lex(**kw)
+      parse(**kw).sort_by(&:pos).map(&:to_a)
+    end
+
+    def scan(**kw)
+      (parse(**kw) + errors).sort_by { |e| [*e.pos, e.message ? -1 : 0] }
+    end
+
+    def parse(raise_errors: false)
+      @errors = []
+      @buf = []
+      super()
+      raise SyntaxError, @errors.map(&:message).join(" ;") if raise_errors && !@errors.empty?
+      @buf
+    end
+
+    def on_parse_error(message)
+      @errors.push(Elem.new([lineno, column], :on_parse_error, token, state, message))
+    end
+
+    def compile_error(message)
+      @errors.push(Elem.new([lineno, column], :compile_error, token, state, message))
+    end
+
+    SCANNER_EVENTS.each do |event|
+      name = :"on_#{event}"
+      define_method(name) do |tok|
+        elem = Elem.new([lineno, column], name, tok, state)
+        @buf.push(elem)
+        elem
+      end
+    end
+  end
+
+  class Filter
+    def initialize(src, filename = "-", lineno = 1)
+      @__lexer = Lexer.new(src, filename, lineno)
+      @__line = nil
+      @__col = nil
+      @__state = nil
+    end
+
+    def filename
+      @__lexer.filename
+    end
+
+    def lineno
+      @__line
+    end
+
+    def column
+      @__col
+    end
+
+    def state
+      @__state
+    end
+
+    def parse(init = nil)
+      data = init
+      @__lexer.lex.each do |pos, event, tok, state|
+        @__line, @__col = *pos
+        @__state = state
+        data = if respond_to?(event, true)
+                 __send__(event, tok, data)
+               else
+                 on_default(event, tok, data)
+               end
+      end
+      data
+    end
+
+    private
+
+    def on_default(event, token, data)
+      data
+    end
+  end
+
+  class SexpBuilder < Ripper
+    attr_reader :error
+
+    PARSER_EVENTS.each do |event|
+      define_method(:"on_#{event}") do |*args|
+        [event, *args]
+      end
+    end
+
+    SCANNER_EVENTS.each do |event|
+      tag = :"@#{event}"
+      define_method(:"on_#{event}") do |tok|
+        [tag, tok, [lineno, column]]
+      end
+    end
+
+    def on_error(message)
+      @error = message
+    end
+
+    def on_parse_error(message)
+      @error = message
+    end
+
+    def compile_error(message)
+      @error = message
+    end
+  end
+
+  class SexpBuilderPP < SexpBuilder
+    def on_mlhs_paren(list)
+      [:mlhs, *list]
+    end
+
+    def on_mlhs_add_star(list, star)
+      list.push([:rest_param, star])
+    end
+
+    def on_mlhs_add_post(list, post)
+      list.concat(post)
+    end
+
+    PARSER_EVENT_TABLE.each do |event, arity|
+      if event.to_s.end_with?("_new") && arity == 0
+        define_method(:"on_#{event}") { [] }
+      elsif event.to_s.end_with?("_add")
+        define_method(:"on_#{event}") { |list, item| list.push(item) }
+      end
+    end
+  end
+end
+`
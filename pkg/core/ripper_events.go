@@ -0,0 +1,2084 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// ripperAbort unwinds a parse when an event handler raises.
+type ripperAbort struct {
+	exception *object.EmeraldValue
+}
+
+type ripperScope struct {
+	names  map[string]bool
+	parent *ripperScope
+}
+
+// ripperDispatcher turns a parsed program back into Ripper's parser events.
+// The AST is walked in source order and every leaf claims its scanner token,
+// which is how events get MRI's [line, column] positions; the tokens the
+// walk skips over (spaces, keywords, punctuation) are dispatched in source
+// order as the walk passes them.
+type ripperDispatcher struct {
+	receiver *object.EmeraldValue
+	data     *ripperData
+	tokens   []ripperToken
+	values   []*object.EmeraldValue
+	claimed  []bool
+	next     int
+	cursor   int
+	base     int
+	limit    int
+	scope    *ripperScope
+
+	// spans, when set, records the first and last token index each AST
+	// node claims; RubyVM::AbstractSyntaxTree takes node positions from it.
+	spans     map[any][2]int
+	spanStack [][2]int
+	embeds    map[int]*ast.Program
+	// detached is the depth below which claims stop widening spans, so a
+	// heredoc body does not stretch the statement holding its opener.
+	detached int
+}
+
+// ripperSpanKey names the span of a part of a node that is not a node of
+// its own, such as a parameter list or a whole block including its braces.
+type ripperSpanKey struct {
+	owner ast.Node
+	part  string
+}
+
+func newRipperDispatcher(receiver *object.EmeraldValue, data *ripperData) *ripperDispatcher {
+	return &ripperDispatcher{
+		receiver: receiver,
+		data:     data,
+		tokens:   data.tokens,
+		values:   make([]*object.EmeraldValue, len(data.tokens)),
+		claimed:  make([]bool, len(data.tokens)),
+		limit:    len(data.tokens),
+		scope:    &ripperScope{names: map[string]bool{}},
+	}
+}
+
+func (d *ripperDispatcher) call(method string, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if d.receiver == nil {
+		return R.NilVal
+	}
+	for i, arg := range args {
+		if arg == nil {
+			args[i] = R.NilVal
+		}
+	}
+	// Event handlers are private, as in MRI.
+	result := CallMethodBypass(d.receiver, method, args...)
+	if result != nil && result.Type == object.ValueException {
+		panic(ripperAbort{exception: result})
+	}
+	if result == nil {
+		return R.NilVal
+	}
+	return result
+}
+
+func (d *ripperDispatcher) event(name string, args ...*object.EmeraldValue) *object.EmeraldValue {
+	return d.call("on_"+name, args...)
+}
+
+// flush dispatches every scanner event up to and including index.
+func (d *ripperDispatcher) flush(index int) {
+	for d.next <= index && d.next < len(d.tokens) {
+		token := d.tokens[d.next]
+		d.data.current = token
+		d.values[d.next] = d.call("on_"+token.Event, rubyString(token.Text))
+		d.next++
+	}
+}
+
+func (d *ripperDispatcher) matches(index int, text string, events []string) bool {
+	token := d.tokens[index]
+	if d.claimed[index] || (text != "" && token.Text != text) {
+		return false
+	}
+	for _, event := range events {
+		if token.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the next unclaimed token after the cursor matching text (any
+// text when empty) and one of events, falling back to earlier tokens of the
+// current range when the AST visits something out of source order.
+func (d *ripperDispatcher) find(text string, events ...string) int {
+	for i := d.cursor; i < d.limit; i++ {
+		if d.matches(i, text, events) {
+			return i
+		}
+	}
+	for i := d.base; i < d.cursor && i < d.limit; i++ {
+		if d.matches(i, text, events) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *ripperDispatcher) claim(index int) *object.EmeraldValue {
+	d.flush(index)
+	d.claimed[index] = true
+	if top := len(d.spanStack) - 1; top >= d.detached {
+		d.spanStack[top] = ripperSpanUnion(d.spanStack[top], [2]int{index, index})
+	}
+	if index >= d.cursor {
+		d.cursor = index + 1
+	}
+	return d.values[index]
+}
+
+// take claims the next token matching text and events, or returns nil when
+// the source has no such token.
+func (d *ripperDispatcher) take(text string, events ...string) *object.EmeraldValue {
+	if index := d.find(text, events...); index >= 0 {
+		return d.claim(index)
+	}
+	return R.NilVal
+}
+
+// skip claims a keyword or punctuation token so that the cursor moves past
+// it; its value is not needed.
+func (d *ripperDispatcher) skip(text string, events ...string) {
+	if index := d.find(text, events...); index >= 0 {
+		d.claim(index)
+	}
+}
+
+// optional claims an optional keyword such as then or do only when it is the
+// next token, so a missing one never reaches into later code.
+func (d *ripperDispatcher) optional(keyword string) {
+	if index := d.peekSeparator(); index >= 0 && d.matches(index, keyword, []string{"kw"}) {
+		d.claim(index)
+	}
+}
+
+func ripperSpanUnion(a, b [2]int) [2]int {
+	if a[0] < 0 {
+		return b
+	}
+	if b[0] >= 0 && b[0] < a[0] {
+		a[0] = b[0]
+	}
+	if b[1] > a[1] {
+		a[1] = b[1]
+	}
+	return a
+}
+
+// spanned runs walk while recording the tokens it claims as the span of
+// key.
+func (d *ripperDispatcher) spanned(key any, walk func() *object.EmeraldValue) *object.EmeraldValue {
+	if d.spans == nil {
+		return walk()
+	}
+	d.spanStack = append(d.spanStack, [2]int{-1, -1})
+	defer func() {
+		top := len(d.spanStack) - 1
+		span := d.spanStack[top]
+		d.spanStack = d.spanStack[:top]
+		if span[0] < 0 {
+			return
+		}
+		if recorded, ok := d.spans[key]; ok {
+			span = ripperSpanUnion(recorded, span)
+		}
+		d.spans[key] = span
+		if top > d.detached {
+			d.spanStack[top-1] = ripperSpanUnion(d.spanStack[top-1], span)
+		}
+	}()
+	return walk()
+}
+
+func ripperInsignificant(event string) bool {
+	switch event {
+	case "sp", "nl", "ignored_nl", "comment", "embdoc_beg", "embdoc", "embdoc_end", "semicolon", "ignored_sp":
+		return true
+	}
+	return false
+}
+
+// peek returns the index of the next unclaimed token that is neither layout
+// nor a separating comma, or -1.
+func (d *ripperDispatcher) peek() int {
+	for i := d.cursor; i < d.limit; i++ {
+		if d.claimed[i] || ripperInsignificant(d.tokens[i].Event) || d.tokens[i].Event == "comma" {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// peekSeparator is peek without skipping commas, for the places where a
+// comma ends an element.
+func (d *ripperDispatcher) peekSeparator() int {
+	for i := d.cursor; i < d.limit; i++ {
+		if d.claimed[i] || ripperInsignificant(d.tokens[i].Event) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func (d *ripperDispatcher) peekIs(event, text string) bool {
+	index := d.peek()
+	return index >= 0 && d.tokens[index].Event == event && (text == "" || d.tokens[index].Text == text)
+}
+
+// immediate reports whether the token right at the cursor, with no space in
+// between, is the given event.
+func (d *ripperDispatcher) immediate(event string) bool {
+	return d.cursor < d.limit && d.tokens[d.cursor].Event == event
+}
+
+func (d *ripperDispatcher) pushScope(hard bool) {
+	if hard {
+		d.scope = &ripperScope{names: map[string]bool{}, parent: d.scope}
+		d.scope.parent = nil
+		return
+	}
+	d.scope = &ripperScope{names: map[string]bool{}, parent: d.scope}
+}
+
+func (d *ripperDispatcher) withScope(hard bool, fn func()) {
+	saved := d.scope
+	d.pushScope(hard)
+	defer func() { d.scope = saved }()
+	fn()
+}
+
+func (d *ripperDispatcher) declare(name string) {
+	d.scope.names[name] = true
+}
+
+func (d *ripperDispatcher) isLocal(name string) bool {
+	for scope := d.scope; scope != nil; scope = scope.parent {
+		if scope.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func ripperList(values ...*object.EmeraldValue) *object.EmeraldValue {
+	for i, value := range values {
+		if value == nil {
+			values[i] = R.NilVal
+		}
+	}
+	return matrixArray(values)
+}
+
+func (d *ripperDispatcher) program(program *ast.Program) *object.EmeraldValue {
+	statements := d.stmts(program.Statements)
+	d.flush(len(d.tokens) - 1)
+	return d.event("program", statements)
+}
+
+func (d *ripperDispatcher) stmts(statements []ast.Statement) *object.EmeraldValue {
+	list := d.event("stmts_new")
+	if len(statements) == 0 {
+		return d.event("stmts_add", list, d.event("void_stmt"))
+	}
+	for _, statement := range statements {
+		list = d.event("stmts_add", list, d.statement(statement))
+	}
+	return list
+}
+
+func (d *ripperDispatcher) block(block *ast.BlockExpression) *object.EmeraldValue {
+	if block == nil {
+		return d.stmts(nil)
+	}
+	return d.spanned(block, func() *object.EmeraldValue { return d.stmts(block.Statements) })
+}
+
+func (d *ripperDispatcher) statement(statement ast.Statement) *object.EmeraldValue {
+	if statement == nil {
+		return d.event("void_stmt")
+	}
+	return d.spanned(statement, func() *object.EmeraldValue { return d.walkStatement(statement) })
+}
+
+func (d *ripperDispatcher) walkStatement(statement ast.Statement) *object.EmeraldValue {
+	switch node := statement.(type) {
+	case *ast.ExpressionStatement:
+		return d.expr(node.Expression)
+	case *ast.ReturnExpression:
+		return d.returnExpression(node)
+	case ast.Expression:
+		return d.expr(node)
+	}
+	return d.event("void_stmt")
+}
+
+// bodystmt builds the body of a def, class, module or do block, which
+// carries its own rescue/else/ensure clauses.
+func (d *ripperDispatcher) bodystmt(block *ast.BlockExpression) *object.EmeraldValue {
+	if block != nil && len(block.Statements) == 1 {
+		if statement, ok := block.Statements[0].(*ast.ExpressionStatement); ok {
+			if begin, ok := statement.Expression.(*ast.BeginExpression); ok && begin.Token.Literal != "begin" && begin.Token.Literal != "rescue" {
+				return d.beginBody(begin)
+			}
+		}
+	}
+	return d.event("bodystmt", d.block(block), nil, nil, nil)
+}
+
+func (d *ripperDispatcher) beginBody(node *ast.BeginExpression) *object.EmeraldValue {
+	body := d.block(node.Body)
+	type clause struct {
+		exceptions, variable, body *object.EmeraldValue
+	}
+	var clauses []clause
+	for _, rescue := range node.Rescue {
+		var current clause
+		d.spanned(rescue, func() *object.EmeraldValue {
+			d.skip("rescue", "kw")
+			switch len(rescue.Exceptions) {
+			case 0:
+			case 1:
+				current.exceptions = ripperList(d.expr(rescue.Exceptions[0]))
+			default:
+				args := d.event("args_new")
+				for _, exception := range rescue.Exceptions[:len(rescue.Exceptions)-1] {
+					args = d.event("args_add", args, d.expr(exception))
+				}
+				mrhs := d.event("mrhs_new_from_args", args)
+				current.exceptions = d.event("mrhs_add", mrhs, d.expr(rescue.Exceptions[len(rescue.Exceptions)-1]))
+			}
+			if rescue.Variable != nil {
+				current.variable = d.assignTarget(rescue.Variable)
+			} else if rescue.Target != nil {
+				current.variable = d.assignTarget(rescue.Target)
+			}
+			current.body = d.block(rescue.Body)
+			return nil
+		})
+		clauses = append(clauses, current)
+	}
+	var rescue *object.EmeraldValue
+	for i := len(clauses) - 1; i >= 0; i-- {
+		rescue = d.event("rescue", clauses[i].exceptions, clauses[i].variable, clauses[i].body, rescue)
+	}
+	var elseValue, ensure *object.EmeraldValue
+	if node.Else != nil {
+		d.skip("else", "kw")
+		elseValue = d.event("else", d.block(node.Else))
+	}
+	if node.Ensure != nil {
+		d.skip("ensure", "kw")
+		ensure = d.event("ensure", d.block(node.Ensure))
+	}
+	return d.event("bodystmt", body, rescue, elseValue, ensure)
+}
+
+// variable claims the token of a variable-like name: a keyword, constant,
+// instance, class or global variable, or an identifier.
+func (d *ripperDispatcher) variable(name string) *object.EmeraldValue {
+	switch {
+	case strings.HasPrefix(name, "@@"):
+		return d.take(name, "cvar")
+	case strings.HasPrefix(name, "@"):
+		return d.take(name, "ivar")
+	case strings.HasPrefix(name, "$"):
+		return d.take(name, "gvar", "backref")
+	case ripperKeywords[name].state != 0:
+		return d.take(name, "kw")
+	case name != "" && name[0] >= 'A' && name[0] <= 'Z':
+		return d.take(name, "const")
+	}
+	return d.take(name, "ident")
+}
+
+func (d *ripperDispatcher) identifier(name string) *object.EmeraldValue {
+	if strings.HasPrefix(name, "@") || strings.HasPrefix(name, "$") || ripperKeywords[name].state != 0 || (name != "" && name[0] >= 'A' && name[0] <= 'Z') || d.isLocal(name) {
+		return d.event("var_ref", d.variable(name))
+	}
+	return d.event("vcall", d.take(name, "ident"))
+}
+
+func (d *ripperDispatcher) expr(node ast.Expression) *object.EmeraldValue {
+	if node == nil {
+		return R.NilVal
+	}
+	opens := d.openParens()
+	value := d.spanned(node, func() *object.EmeraldValue { return d.walk(node) })
+	return d.closeParens(opens, value)
+}
+
+// openParens lists the unclaimed ( tokens directly ahead of the cursor. The
+// AST drops grouping parentheses, so expr wraps the node in paren events
+// when the matching ) tokens directly follow it.
+func (d *ripperDispatcher) openParens() []int {
+	var opens []int
+	for i := d.cursor; i < d.limit; i++ {
+		token := d.tokens[i]
+		if d.claimed[i] || ripperInsignificant(token.Event) || (token.Event == "comma" && len(opens) == 0) {
+			continue
+		}
+		if token.Event != "lparen" {
+			break
+		}
+		opens = append(opens, i)
+	}
+	return opens
+}
+
+func (d *ripperDispatcher) closeParens(opens []int, value *object.EmeraldValue) *object.EmeraldValue {
+	for i := len(opens) - 1; i >= 0; i-- {
+		open := opens[i]
+		close := d.peekSeparator()
+		if d.claimed[open] || close < 0 || d.tokens[close].Event != "rparen" || d.closingParen(open) != close {
+			break
+		}
+		d.claim(open)
+		d.claim(close)
+		value = d.event("paren", d.event("stmts_add", d.event("stmts_new"), value))
+	}
+	return value
+}
+
+// closingParen returns the index of the ) matching the ( at open, or -1.
+func (d *ripperDispatcher) closingParen(open int) int {
+	depth := 0
+	for i := open; i < len(d.tokens); i++ {
+		switch d.tokens[i].Event {
+		case "lparen":
+			depth++
+		case "rparen":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (d *ripperDispatcher) walk(node ast.Expression) *object.EmeraldValue {
+	switch n := node.(type) {
+	case nil:
+		return R.NilVal
+	case *ast.Identifier:
+		return d.identifier(n.Value)
+	case *ast.Boolean:
+		return d.event("var_ref", d.take(n.Token.Literal, "kw"))
+	case *ast.NilExpression:
+		return d.event("var_ref", d.take("nil", "kw"))
+	case *ast.SelfExpression:
+		return d.event("var_ref", d.take("self", "kw"))
+	case *ast.IntegerLiteral:
+		if n.Token.Literal == "__LINE__" {
+			return d.event("var_ref", d.take("__LINE__", "kw"))
+		}
+		return d.number(n.Value < 0, "int")
+	case *ast.FloatLiteral:
+		return d.number(n.Value < 0, "float")
+	case *ast.RationalLiteral:
+		return d.number(strings.HasPrefix(n.Value, "-"), "rational")
+	case *ast.ImaginaryLiteral:
+		return d.number(false, "imaginary")
+	case *ast.StringLiteral:
+		return d.stringLiteral()
+	case *ast.StringConcatExpression:
+		var result *object.EmeraldValue
+		for i := range n.Parts {
+			part := d.stringLiteral()
+			if i == 0 {
+				result = part
+			} else {
+				result = d.event("string_concat", result, part)
+			}
+		}
+		return result
+	case *ast.SymbolLiteral:
+		return d.symbolLiteral()
+	case *ast.RegexpLiteral:
+		return d.regexpLiteral()
+	case *ast.ArrayLiteral:
+		return d.arrayLiteral(n)
+	case *ast.HashLiteral:
+		return d.hashLiteral(n)
+	case *ast.IndexExpression:
+		left := d.expr(n.Left)
+		return d.event("aref", left, d.indexArgs(n.Index, n.End))
+	case *ast.PrefixExpression:
+		return d.prefix(n)
+	case *ast.InfixExpression:
+		left := d.expr(n.Left)
+		d.skip(n.Operator, "op", "kw")
+		return d.event("binary", left, rubySymbol(n.Operator), d.expr(n.Right))
+	case *ast.TernaryExpression:
+		condition := d.expr(n.Condition)
+		d.skip("?", "op")
+		consequent := d.expr(n.Consequent)
+		d.skip(":", "op")
+		return d.event("ifop", condition, consequent, d.expr(n.Alternative))
+	case *ast.RangeExpression:
+		var left, right *object.EmeraldValue
+		if !n.StartMissing {
+			left = d.expr(n.Left)
+		}
+		event, operator := "dot2", ".."
+		if n.Exclusive {
+			event, operator = "dot3", "..."
+		}
+		d.skip(operator, "op")
+		if !n.EndMissing {
+			right = d.expr(n.Right)
+		}
+		return d.event(event, left, right)
+	case *ast.IfExpression:
+		return d.ifExpression(n)
+	case *ast.CaseExpression:
+		return d.caseExpression(n)
+	case *ast.PatternMatchExpression:
+		left := d.expr(n.Left)
+		if index := d.peek(); index >= 0 && (d.tokens[index].Text == "=>" || d.tokens[index].Text == "in") {
+			d.claim(index)
+		}
+		return d.event("case", left, d.event("in", d.pattern(), nil, nil))
+	case *ast.WhileExpression:
+		return d.loop("while", n.Condition, n.Body, n.Post)
+	case *ast.UntilExpression:
+		return d.loop("until", n.Condition, n.Body, n.Post)
+	case *ast.ForExpression:
+		return d.forExpression(n)
+	case *ast.DefExpression:
+		return d.def(n)
+	case *ast.ClassExpression:
+		return d.class(n)
+	case *ast.ModuleExpression:
+		d.skip("module", "kw")
+		path := d.constPath(n.Name.Value, n.Absolute)
+		var body *object.EmeraldValue
+		d.withScope(true, func() { body = d.bodystmt(n.Body) })
+		d.skip("end", "kw")
+		return d.event("module", path, body)
+	case *ast.ReturnExpression:
+		return d.returnExpression(n)
+	case *ast.BreakExpression:
+		d.skip("break", "kw")
+		return d.event("break", d.jumpArgs(n.Value))
+	case *ast.NextExpression:
+		d.skip("next", "kw")
+		return d.event("next", d.jumpArgs(n.Value))
+	case *ast.RedoExpression:
+		d.skip("redo", "kw")
+		return d.event("redo")
+	case *ast.RetryExpression:
+		d.skip("retry", "kw")
+		return d.event("retry")
+	case *ast.YieldExpression:
+		d.skip("yield", "kw")
+		if len(n.Args) == 0 && len(n.KeywordArgs) == 0 {
+			if d.immediate("lparen") {
+				d.claim(d.cursor)
+				d.skip(")", "rparen")
+				return d.event("yield", d.event("paren", d.event("args_new")))
+			}
+			return d.event("yield0")
+		}
+		if d.immediate("lparen") {
+			d.claim(d.cursor)
+			args := d.callArgs(n.Args, n.KeywordArgs)
+			d.skip(")", "rparen")
+			return d.event("yield", d.event("paren", args))
+		}
+		return d.event("yield", d.callArgs(n.Args, n.KeywordArgs))
+	case *ast.SuperExpression:
+		d.skip("super", "kw")
+		var result *object.EmeraldValue
+		switch {
+		case n.ImplicitArgs && !d.immediate("lparen"):
+			result = d.event("zsuper")
+		case d.immediate("lparen"):
+			result = d.event("super", d.argParen(n.Args, n.KeywordArgs))
+		default:
+			result = d.event("super", d.callArgs(n.Args, n.KeywordArgs))
+		}
+		if n.Block != nil {
+			result = d.event("method_add_block", result, d.blockValue(n.Block))
+		}
+		return result
+	case *ast.InstanceVariable:
+		return d.event("var_ref", d.take(n.Name, "ivar"))
+	case *ast.ClassVariable:
+		return d.event("var_ref", d.take(n.Name, "cvar"))
+	case *ast.GlobalVariable:
+		return d.event("var_ref", d.take(n.Name, "gvar", "backref"))
+	case *ast.Constant:
+		return d.event("var_ref", d.take(n.Name, "const"))
+	case *ast.ConstantResolution:
+		if n.Left == nil {
+			d.skip("::", "op")
+			return d.event("top_const_ref", d.take(n.Name.Value, "const"))
+		}
+		left := d.expr(n.Left)
+		operator := d.take("::", "op")
+		if name := n.Name.Value; name != "" && !(name[0] >= 'A' && name[0] <= 'Z') {
+			return d.event("call", left, operator, d.take(name, "ident"))
+		}
+		return d.event("const_path_ref", left, d.take(n.Name.Value, "const"))
+	case *ast.AssignExpression:
+		return d.assign(n)
+	case *ast.MultiAssignExpression:
+		return d.multiAssign(n)
+	case *ast.InstanceVarAssign:
+		return d.simpleAssign(n.Name, n.Token.Literal, n.Value)
+	case *ast.ClassVarAssign:
+		return d.simpleAssign(n.Name, n.Token.Literal, n.Value)
+	case *ast.GlobalVarAssign:
+		return d.simpleAssign(n.Name, n.Token.Literal, n.Value)
+	case *ast.MethodCall:
+		return d.methodCall(n)
+	case *ast.UndefExpression:
+		d.skip("undef", "kw")
+		names := make([]*object.EmeraldValue, 0, len(n.Methods))
+		for _, method := range n.Methods {
+			names = append(names, d.spanned(method, func() *object.EmeraldValue { return d.methodNameSymbol(method.Value) }))
+		}
+		return d.event("undef", ripperList(names...))
+	case *ast.AliasExpression:
+		d.skip("alias", "kw")
+		if _, ok := n.New.(*ast.GlobalVariable); ok {
+			newName := d.take("", "gvar", "backref")
+			return d.event("var_alias", newName, d.take("", "gvar", "backref"))
+		}
+		newName := d.spanned(ripperSpanKey{n, "new"}, func() *object.EmeraldValue { return d.methodNameSymbol(n.New.String()) })
+		oldName := d.spanned(ripperSpanKey{n, "old"}, func() *object.EmeraldValue { return d.methodNameSymbol(n.Old.String()) })
+		return d.event("alias", newName, oldName)
+	case *ast.BeginExpression:
+		return d.beginExpression(n)
+	case *ast.RaiseExpression:
+		var args []ast.Expression
+		for _, arg := range []ast.Expression{n.Error, n.Message, n.Backtrace, n.Keyword} {
+			if arg != nil {
+				args = append(args, arg)
+			}
+		}
+		return d.command("raise", args, nil)
+	case *ast.CatchExpression:
+		var args []ast.Expression
+		if n.Label != nil {
+			args = append(args, n.Label)
+		}
+		if n.BlockPass != nil {
+			args = append(args, n.BlockPass)
+		}
+		var block *ast.BlockExpression
+		if n.HasBlock {
+			block = n.Body
+		}
+		return d.command("catch", args, block)
+	case *ast.ThrowExpression:
+		args := []ast.Expression{n.Label}
+		if n.Value != nil {
+			args = append(args, n.Value)
+		}
+		return d.command("throw", append(args, n.ExtraArgs...), nil)
+	case *ast.IncludeExpression:
+		return d.command("include", []ast.Expression{n.Module}, nil)
+	case *ast.ExtendExpression:
+		return d.command("extend", []ast.Expression{n.Module}, nil)
+	case *ast.PrependExpression:
+		return d.command("prepend", []ast.Expression{n.Module}, nil)
+	case *ast.DefinedExpression:
+		d.skip("defined?", "kw")
+		return d.event("defined", d.expr(n.Expression))
+	case *ast.ProcLiteral:
+		return d.lambda(n)
+	case *ast.SplatExpression:
+		d.skip(n.Token.Literal, "op")
+		return d.expr(n.Value)
+	case *ast.BlockExpression:
+		return d.block(n)
+	}
+	return d.event("void_stmt")
+}
+
+// number claims a numeric token. MRI lexes a leading minus as its own
+// operator, so a negative literal becomes a unary minus.
+func (d *ripperDispatcher) number(negative bool, event string) *object.EmeraldValue {
+	events := []string{"int", "float", "rational", "imaginary"}
+	if negative {
+		if index := d.peek(); index >= 0 && d.tokens[index].Text == "-" {
+			d.claim(index)
+			return d.event("unary", rubySymbol("-@"), d.take("", events...))
+		}
+	}
+	return d.take("", append([]string{event}, events...)...)
+}
+
+func (d *ripperDispatcher) prefix(n *ast.PrefixExpression) *object.EmeraldValue {
+	operator := n.Operator
+	switch operator {
+	case "-", "+":
+		operator += "@"
+	}
+	if n.Operator == "not" {
+		d.skip("not", "kw")
+	} else {
+		d.skip(n.Operator, "op")
+	}
+	return d.event("unary", rubySymbol(operator), d.expr(n.Right))
+}
+
+// stringContent collects the pieces of a literal between tokens from and to
+// into a list built with the given add event.
+func (d *ripperDispatcher) stringContent(from, to int, content *object.EmeraldValue, add string) *object.EmeraldValue {
+	for i := from; i < to && i < len(d.tokens); {
+		token := d.tokens[i]
+		switch token.Event {
+		case "tstring_content":
+			content = d.event(add, content, d.claim(i))
+			i++
+		case "embexpr_beg":
+			d.claim(i)
+			end := token.Pair
+			if end < 0 || end > to {
+				end = to
+			}
+			statements := d.embedded(i+1, end)
+			if end < to {
+				d.claim(end)
+			}
+			content = d.event(add, content, d.event("string_embexpr", statements))
+			i = end + 1
+		case "embvar":
+			d.claim(i)
+			if i+1 < to {
+				content = d.event(add, content, d.event("string_dvar", d.event("var_ref", d.claim(i+1))))
+			}
+			i += 2
+		default:
+			i++
+		}
+	}
+	return content
+}
+
+// embedded parses the code inside #{} and dispatches its events against the
+// tokens already scanned for it.
+func (d *ripperDispatcher) embedded(from, to int) *object.EmeraldValue {
+	source := ""
+	if from < to {
+		end := len(d.data.source)
+		if to < len(d.tokens) {
+			end = d.tokens[to].Offset
+		}
+		source = d.data.source[d.tokens[from].Offset:end]
+	}
+	program := parser.New(lexer.New(source)).ParseProgram()
+	if d.embeds != nil {
+		d.embeds[from-1] = program
+	}
+	savedCursor, savedBase, savedLimit := d.cursor, d.base, d.limit
+	d.cursor, d.base, d.limit = from, from, to
+	defer func() { d.cursor, d.base, d.limit = savedCursor, savedBase, savedLimit }()
+	return d.stmts(program.Statements)
+}
+
+func (d *ripperDispatcher) stringLiteral() *object.EmeraldValue {
+	index := d.peek()
+	if index < 0 || !ripperStringStart(d.tokens[index]) {
+		index = -1
+		for i := d.cursor; i < d.limit; i++ {
+			if !d.claimed[i] && ripperStringStart(d.tokens[i]) {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 {
+		return d.event("string_literal", d.event("string_add", d.event("string_content"), d.event("tstring_content", rubyString(""))))
+	}
+	token := d.tokens[index]
+	switch token.Event {
+	case "CHAR":
+		return d.claim(index)
+	case "backtick":
+		d.claim(index)
+		content := d.stringContent(index+1, d.pairOf(index), d.event("xstring_new"), "xstring_add")
+		d.claimPair(index)
+		return d.event("xstring_literal", content)
+	case "heredoc_beg":
+		d.claim(index)
+		resume := d.cursor
+		body := d.tokens[index].Body
+		content := d.event("string_content")
+		detached := d.detached
+		d.detached = len(d.spanStack)
+		if body > 0 {
+			content = d.stringContent(body, d.pairOf(index), content, "string_add")
+		}
+		d.claimPair(index)
+		d.detached = detached
+		d.cursor = resume
+		return d.event("string_literal", content)
+	}
+	d.claim(index)
+	content := d.stringContent(index+1, d.pairOf(index), d.event("string_content"), "string_add")
+	d.claimPair(index)
+	return d.event("string_literal", content)
+}
+
+func ripperStringStart(token ripperToken) bool {
+	switch token.Event {
+	case "tstring_beg", "heredoc_beg", "CHAR", "backtick":
+		return true
+	}
+	return false
+}
+
+func (d *ripperDispatcher) pairOf(index int) int {
+	if pair := d.tokens[index].Pair; pair >= 0 {
+		return pair
+	}
+	return d.limit
+}
+
+func (d *ripperDispatcher) claimPair(index int) *object.EmeraldValue {
+	if pair := d.tokens[index].Pair; pair >= 0 && pair < d.limit {
+		return d.claim(pair)
+	}
+	return nil
+}
+
+func (d *ripperDispatcher) symbolLiteral() *object.EmeraldValue {
+	index := d.peek()
+	if index < 0 {
+		return d.event("symbol_literal", d.event("symbol", d.event("ident", rubyString(""))))
+	}
+	token := d.tokens[index]
+	switch {
+	case token.Event == "label":
+		return d.claim(index)
+	case token.Event == "symbeg" && token.Text == ":":
+		d.claim(index)
+		name := d.take("", "ident", "const", "op", "kw", "ivar", "cvar", "gvar", "backtick", "backref")
+		return d.event("symbol_literal", d.event("symbol", name))
+	case token.Event == "symbeg":
+		d.claim(index)
+		content := d.stringContent(index+1, d.pairOf(index), d.event("string_content"), "string_add")
+		d.claimPair(index)
+		return d.event("dyna_symbol", content)
+	case token.Event == "tstring_beg" && token.Pair >= 0 && d.tokens[token.Pair].Event == "label_end":
+		d.claim(index)
+		content := d.stringContent(index+1, token.Pair, d.event("string_content"), "string_add")
+		d.claimPair(index)
+		return d.event("dyna_symbol", content)
+	}
+	return d.claim(index)
+}
+
+// methodNameSymbol reads the operand of alias and undef, written either as
+// a bare method name or as a symbol.
+func (d *ripperDispatcher) methodNameSymbol(name string) *object.EmeraldValue {
+	if d.peekIs("symbeg", "") {
+		return d.symbolLiteral()
+	}
+	name = strings.TrimPrefix(name, ":")
+	return d.event("symbol_literal", d.take(name, "ident", "const", "op", "kw", "backtick"))
+}
+
+func (d *ripperDispatcher) regexpLiteral() *object.EmeraldValue {
+	index := d.find("", "regexp_beg")
+	if index < 0 {
+		return d.event("regexp_literal", d.event("regexp_new"), d.event("regexp_end", rubyString("/")))
+	}
+	d.claim(index)
+	content := d.stringContent(index+1, d.pairOf(index), d.event("regexp_new"), "regexp_add")
+	ending := d.claimPair(index)
+	return d.event("regexp_literal", content, ending)
+}
+
+func (d *ripperDispatcher) arrayLiteral(n *ast.ArrayLiteral) *object.EmeraldValue {
+	index := d.peek()
+	if index >= 0 {
+		switch token := d.tokens[index]; token.Event {
+		case "lbracket":
+			d.claim(index)
+			var args *object.EmeraldValue
+			if len(n.Elements) > 0 {
+				args = d.listArgs(n.Elements)
+			}
+			d.skip("]", "rbracket")
+			return d.event("array", args)
+		case "qwords_beg", "qsymbols_beg":
+			prefix := strings.TrimSuffix(token.Event, "_beg")
+			d.claim(index)
+			list := d.event(prefix + "_new")
+			element := 0
+			for i := index + 1; i < d.pairOf(index); i++ {
+				if d.tokens[i].Event == "tstring_content" {
+					list = d.event(prefix+"_add", list, d.spanned(ripperElement(n, element), func() *object.EmeraldValue { return d.claim(i) }))
+					element++
+				}
+			}
+			d.claimPair(index)
+			return d.event("array", list)
+		case "words_beg", "symbols_beg":
+			return d.words(n, index, strings.TrimSuffix(token.Event, "_beg"))
+		}
+	}
+	return d.listArgs(n.Elements)
+}
+
+// words builds %W[] and %I[] arrays, whose elements may interpolate.
+func (d *ripperDispatcher) words(n *ast.ArrayLiteral, index int, prefix string) *object.EmeraldValue {
+	d.claim(index)
+	list := d.event(prefix + "_new")
+	end := d.pairOf(index)
+	element := 0
+	for i := index + 1; i < end; {
+		if d.tokens[i].Event == "words_sep" {
+			i++
+			continue
+		}
+		j := i
+		for j < end && d.tokens[j].Event != "words_sep" {
+			if pair := d.tokens[j].Pair; d.tokens[j].Event == "embexpr_beg" && pair > j {
+				j = pair
+			}
+			j++
+		}
+		start := i
+		word := d.spanned(ripperElement(n, element), func() *object.EmeraldValue {
+			return d.stringContent(start, j, d.event("word_new"), "word_add")
+		})
+		list = d.event(prefix+"_add", list, word)
+		element++
+		i = j
+	}
+	d.claimPair(index)
+	return d.event("array", list)
+}
+
+// ripperElement keys the span of the i-th word of a %w-style array, or
+// returns a throwaway key when the parser produced fewer elements.
+func ripperElement(n *ast.ArrayLiteral, i int) any {
+	if i < len(n.Elements) {
+		return n.Elements[i]
+	}
+	return ripperSpanKey{owner: n, part: "word"}
+}
+
+// listArgs builds the args_add chain of an array literal.
+func (d *ripperDispatcher) listArgs(elements []ast.Expression) *object.EmeraldValue {
+	args := d.event("args_new")
+	for _, element := range elements {
+		if splat, ok := element.(*ast.SplatExpression); ok && splat.Token.Literal == "*" {
+			d.skip("*", "op")
+			args = d.event("args_add_star", args, d.expr(splat.Value))
+			continue
+		}
+		if hash, ok := element.(*ast.HashLiteral); ok && !d.peekIs("lbrace", "") {
+			args = d.event("args_add", args, d.event("bare_assoc_hash", d.assocs(hash)))
+			continue
+		}
+		args = d.event("args_add", args, d.expr(element))
+	}
+	return args
+}
+
+func (d *ripperDispatcher) hashLiteral(n *ast.HashLiteral) *object.EmeraldValue {
+	if !d.peekIs("lbrace", "") {
+		return d.event("bare_assoc_hash", d.assocs(n))
+	}
+	d.claim(d.peek())
+	var result *object.EmeraldValue
+	if len(n.Order) == 0 {
+		result = d.event("hash", nil)
+	} else {
+		result = d.event("hash", d.event("assoclist_from_args", d.assocs(n)))
+	}
+	d.skip("}", "rbrace")
+	return result
+}
+
+func (d *ripperDispatcher) assocs(n *ast.HashLiteral) *object.EmeraldValue {
+	values := make([]*object.EmeraldValue, 0, len(n.Order))
+	for _, key := range n.Order {
+		values = append(values, d.assoc(key, n.Pairs[key]))
+	}
+	return ripperList(values...)
+}
+
+func (d *ripperDispatcher) assoc(key, value ast.Expression) *object.EmeraldValue {
+	if splat, ok := key.(*ast.SplatExpression); ok && splat.Token.Literal == "**" {
+		d.skip("**", "op")
+		return d.event("assoc_splat", d.expr(splat.Value))
+	}
+	var keyValue *object.EmeraldValue
+	if index := d.peek(); index >= 0 && (d.tokens[index].Event == "label" || (d.tokens[index].Event == "tstring_beg" && d.tokens[index].Pair >= 0 && d.tokens[d.tokens[index].Pair].Event == "label_end")) {
+		keyValue = d.spanned(key, d.symbolLiteral)
+		if next := d.peekSeparator(); next < 0 || d.tokens[next].Event == "comma" || d.tokens[next].Event == "rbrace" || d.tokens[next].Event == "rparen" {
+			return d.event("assoc_new", keyValue, nil)
+		}
+	} else {
+		keyValue = d.expr(key)
+		d.skip("=>", "op")
+	}
+	return d.event("assoc_new", keyValue, d.expr(value))
+}
+
+func (d *ripperDispatcher) keywordArg(arg *ast.KeywordArg) *object.EmeraldValue {
+	if index := d.peek(); index >= 0 && (d.tokens[index].Event == "label" || d.tokens[index].Event == "tstring_beg") {
+		key := d.spanned(ripperSpanKey{arg, "key"}, d.symbolLiteral)
+		if next := d.peekSeparator(); (next < 0 || d.tokens[next].Event == "comma" || d.tokens[next].Event == "rparen") && arg.Value == nil {
+			return d.event("assoc_new", key, nil)
+		}
+		return d.event("assoc_new", key, d.expr(arg.Value))
+	}
+	key := d.symbolLiteral()
+	d.skip("=>", "op")
+	return d.event("assoc_new", key, d.expr(arg.Value))
+}
+
+// callArgs builds the args_add_block of a call's positional arguments,
+// keyword arguments and block argument.
+func (d *ripperDispatcher) callArgs(args []ast.Expression, keywordArgs []*ast.KeywordArg) *object.EmeraldValue {
+	list := d.event("args_new")
+	var assocs []*object.EmeraldValue
+	var blockArg *ast.SplatExpression
+	for _, arg := range args {
+		if splat, ok := arg.(*ast.SplatExpression); ok {
+			switch splat.Token.Literal {
+			case "&":
+				blockArg = splat
+				continue
+			case "**":
+				d.skip("**", "op")
+				assocs = append(assocs, d.event("assoc_splat", d.expr(splat.Value)))
+				continue
+			case "*":
+				d.skip("*", "op")
+				list = d.event("args_add_star", list, d.expr(splat.Value))
+				continue
+			}
+		}
+		if hash, ok := arg.(*ast.HashLiteral); ok && !d.peekIs("lbrace", "") {
+			for _, key := range hash.Order {
+				assocs = append(assocs, d.assoc(key, hash.Pairs[key]))
+			}
+			continue
+		}
+		list = d.event("args_add", list, d.expr(arg))
+	}
+	for _, arg := range keywordArgs {
+		assocs = append(assocs, d.keywordArg(arg))
+	}
+	if len(assocs) > 0 {
+		list = d.event("args_add", list, d.event("bare_assoc_hash", ripperList(assocs...)))
+	}
+	block := R.FalseVal
+	if blockArg != nil {
+		d.skip("&", "op")
+		block = d.expr(blockArg.Value)
+	}
+	return d.event("args_add_block", list, block)
+}
+
+func (d *ripperDispatcher) argParen(args []ast.Expression, keywordArgs []*ast.KeywordArg) *object.EmeraldValue {
+	d.skip("(", "lparen")
+	var value *object.EmeraldValue
+	if len(args) > 0 || len(keywordArgs) > 0 {
+		value = d.callArgs(args, keywordArgs)
+	}
+	d.skip(")", "rparen")
+	return d.event("arg_paren", value)
+}
+
+func (d *ripperDispatcher) indexArgs(index, end ast.Expression) *object.EmeraldValue {
+	d.skip("[", "lbracket")
+	if index == nil {
+		d.skip("]", "rbracket")
+		return nil
+	}
+	args := []ast.Expression{index}
+	if end != nil {
+		args = append(args, end)
+	}
+	value := d.callArgs(args, nil)
+	d.skip("]", "rbracket")
+	return value
+}
+
+func (d *ripperDispatcher) jumpArgs(value ast.Expression) *object.EmeraldValue {
+	if value == nil {
+		return d.event("args_new")
+	}
+	if array, ok := value.(*ast.ArrayLiteral); ok && !d.peekIs("lbracket", "") && !d.peekIs("qwords_beg", "") && !d.peekIs("words_beg", "") {
+		return d.callArgs(array.Elements, nil)
+	}
+	return d.callArgs([]ast.Expression{value}, nil)
+}
+
+func (d *ripperDispatcher) returnExpression(n *ast.ReturnExpression) *object.EmeraldValue {
+	d.skip("return", "kw")
+	if n.ReturnValue == nil {
+		return d.event("return0")
+	}
+	return d.event("return", d.jumpArgs(n.ReturnValue))
+}
+
+// command dispatches a call that the parser folds into a dedicated node
+// (raise, catch, include, ...) the way MRI sees it: a plain method call.
+func (d *ripperDispatcher) command(name string, args []ast.Expression, block *ast.BlockExpression) *object.EmeraldValue {
+	method := d.take(name, "ident")
+	var result *object.EmeraldValue
+	switch {
+	case d.immediate("lparen"):
+		result = d.event("method_add_arg", d.event("fcall", method), d.argParen(args, nil))
+	case len(args) > 0:
+		result = d.event("command", method, d.callArgs(args, nil))
+	case block != nil:
+		result = d.event("method_add_arg", d.event("fcall", method), d.event("args_new"))
+	default:
+		return d.event("vcall", method)
+	}
+	if block != nil {
+		result = d.event("method_add_block", result, d.blockValue(block))
+	}
+	return result
+}
+
+func (d *ripperDispatcher) methodCall(n *ast.MethodCall) *object.EmeraldValue {
+	name := n.Method.Value
+	if n.Receiver == nil && (name == "BEGIN" || name == "END") && n.Block != nil {
+		d.skip(name, "kw")
+		d.skip("{", "lbrace")
+		statements := d.block(n.Block)
+		d.skip("}", "rbrace")
+		return d.event(name, statements)
+	}
+	if n.Assignment || n.LogicalAssignment != "" {
+		return d.attributeAssign(n)
+	}
+
+	var result *object.EmeraldValue
+	if n.Receiver != nil && name == "[]" && len(n.Args) > 0 && len(n.Args) <= 2 {
+		receiver := d.expr(n.Receiver)
+		if d.immediate("lbracket") {
+			var end ast.Expression
+			if len(n.Args) == 2 {
+				end = n.Args[1]
+			}
+			result = d.event("aref", receiver, d.indexArgs(n.Args[0], end))
+			if n.Block != nil {
+				result = d.event("method_add_block", result, d.blockValue(n.Block))
+			}
+			return result
+		}
+		return d.finishCall(n, receiver)
+	}
+	if n.Receiver != nil {
+		return d.finishCall(n, d.expr(n.Receiver))
+	}
+	method := d.take(name, "ident", "const", "kw")
+	switch {
+	case d.immediate("lparen"):
+		result = d.event("method_add_arg", d.event("fcall", method), d.argParen(n.Args, n.KeywordArgs))
+	case len(n.Args) > 0 || len(n.KeywordArgs) > 0:
+		result = d.event("command", method, d.callArgs(n.Args, n.KeywordArgs))
+	case n.Block != nil:
+		result = d.event("method_add_arg", d.event("fcall", method), d.event("args_new"))
+	default:
+		result = d.event("vcall", method)
+	}
+	if n.Block != nil {
+		result = d.event("method_add_block", result, d.blockValue(n.Block))
+	}
+	return result
+}
+
+// finishCall dispatches a call with an explicit receiver once the receiver
+// has been walked.
+func (d *ripperDispatcher) finishCall(n *ast.MethodCall, receiver *object.EmeraldValue) *object.EmeraldValue {
+	name := n.Method.Value
+	operator := d.take("", "period", "op")
+	var method *object.EmeraldValue
+	if d.immediate("lparen") && name == "call" {
+		method = rubySymbol("call")
+	} else {
+		method = d.take(name, "ident", "const", "kw", "op", "backtick")
+	}
+	var result *object.EmeraldValue
+	switch {
+	case d.immediate("lparen"):
+		result = d.event("method_add_arg", d.event("call", receiver, operator, method), d.argParen(n.Args, n.KeywordArgs))
+	case len(n.Args) > 0 || len(n.KeywordArgs) > 0:
+		result = d.event("command_call", receiver, operator, method, d.callArgs(n.Args, n.KeywordArgs))
+	default:
+		result = d.event("call", receiver, operator, method)
+	}
+	if n.Block != nil {
+		result = d.event("method_add_block", result, d.blockValue(n.Block))
+	}
+	return result
+}
+
+// blockValue dispatches a brace_block or do_block with its parameters.
+func (d *ripperDispatcher) blockValue(block *ast.BlockExpression) *object.EmeraldValue {
+	return d.spanned(ripperSpanKey{block, "block"}, func() *object.EmeraldValue { return d.blockBody(block) })
+}
+
+func (d *ripperDispatcher) blockBody(block *ast.BlockExpression) *object.EmeraldValue {
+	index := d.peek()
+	brace := index < 0 || d.tokens[index].Event != "kw"
+	if index >= 0 {
+		d.claim(index)
+	}
+	var result *object.EmeraldValue
+	d.withScope(false, func() {
+		var params *object.EmeraldValue
+		if block.ExplicitParams {
+			spec := ripperParamSpec{
+				owner:  block,
+				params: block.Params, defaults: block.ParamDefaults, rest: block.RestParam, restIndex: block.RestParamIndex,
+				keywords: block.KeywordParams, keywordRest: block.KeywordRestParam, rejectKeywords: block.RejectKeywords, block: block.BlockParam,
+			}
+			params = d.event("block_var", d.params(spec), R.FalseVal)
+		}
+		if brace {
+			statements := d.block(block)
+			d.skip("}", "rbrace")
+			result = d.event("brace_block", params, statements)
+			return
+		}
+		body := d.bodystmt(block)
+		d.skip("end", "kw")
+		result = d.event("do_block", params, body)
+	})
+	return result
+}
+
+type ripperParamSpec struct {
+	owner          ast.Node
+	params         []*ast.Identifier
+	defaults       []ast.Expression
+	rest           *ast.Identifier
+	restIndex      int
+	keywords       []*ast.KeywordParam
+	keywordRest    *ast.Identifier
+	rejectKeywords bool
+	block          *ast.Identifier
+}
+
+func (spec ripperParamSpec) empty() bool {
+	return len(spec.params) == 0 && spec.rest == nil && len(spec.keywords) == 0 && spec.keywordRest == nil && !spec.rejectKeywords && spec.block == nil
+}
+
+// params builds MRI's seven-slot params event: required, optional, rest,
+// post, keyword, keyword rest and block parameters. The slots are plain
+// arrays, as in MRI.
+func (d *ripperDispatcher) params(spec ripperParamSpec) *object.EmeraldValue {
+	if spec.owner != nil {
+		owner := spec.owner
+		spec.owner = nil
+		return d.spanned(ripperSpanKey{owner, "params"}, func() *object.EmeraldValue { return d.params(spec) })
+	}
+	var required, optional, post []*object.EmeraldValue
+	var rest, keywords, keywordRest, block *object.EmeraldValue
+	restDone := spec.rest == nil
+	takeRest := func() {
+		d.skip("*", "op")
+		var name *object.EmeraldValue
+		if spec.rest.Value != "" && spec.rest.Value != "*" {
+			d.declare(spec.rest.Value)
+			name = d.take(spec.rest.Value, "ident")
+		}
+		rest = d.event("rest_param", name)
+		restDone = true
+	}
+	for i, param := range spec.params {
+		if !restDone && i >= spec.restIndex {
+			takeRest()
+		}
+		var name *object.EmeraldValue
+		d.spanned(param, func() *object.EmeraldValue {
+			if strings.HasPrefix(param.Value, "__rgo_destructure_") {
+				name = d.destructuredParam()
+			} else {
+				d.declare(param.Value)
+				name = d.take(param.Value, "ident")
+			}
+			return name
+		})
+		switch {
+		case i < len(spec.defaults) && spec.defaults[i] != nil:
+			var value *object.EmeraldValue
+			d.spanned(param, func() *object.EmeraldValue {
+				d.skip("=", "op")
+				value = d.expr(spec.defaults[i])
+				return value
+			})
+			optional = append(optional, ripperList(name, value))
+		case restDone && spec.rest != nil:
+			post = append(post, name)
+		default:
+			required = append(required, name)
+		}
+	}
+	if !restDone {
+		takeRest()
+	}
+	if len(spec.keywords) > 0 {
+		values := make([]*object.EmeraldValue, 0, len(spec.keywords))
+		for _, keyword := range spec.keywords {
+			d.declare(keyword.Name)
+			var label, value *object.EmeraldValue
+			d.spanned(keyword, func() *object.EmeraldValue {
+				label = d.take(keyword.Name+":", "label")
+				value = R.FalseVal
+				if keyword.Default != nil {
+					value = d.expr(keyword.Default)
+				}
+				return value
+			})
+			values = append(values, ripperList(label, value))
+		}
+		keywords = ripperList(values...)
+	}
+	if spec.rejectKeywords {
+		d.skip("**", "op")
+		keywordRest = d.event("nokw_param", nil)
+	} else if spec.keywordRest != nil {
+		d.skip("**", "op")
+		var name *object.EmeraldValue
+		if spec.keywordRest.Value != "" && spec.keywordRest.Value != "**" {
+			d.declare(spec.keywordRest.Value)
+			name = d.take(spec.keywordRest.Value, "ident")
+		}
+		keywordRest = d.event("kwrest_param", name)
+	}
+	if spec.block != nil {
+		d.skip("&", "op")
+		var name *object.EmeraldValue
+		if spec.block.Value != "" && spec.block.Value != "&" {
+			d.declare(spec.block.Value)
+			name = d.take(spec.block.Value, "ident")
+		}
+		block = d.event("blockarg", name)
+	}
+	optionalList := func(values []*object.EmeraldValue) *object.EmeraldValue {
+		if len(values) == 0 {
+			return nil
+		}
+		return ripperList(values...)
+	}
+	return d.event("params", optionalList(required), optionalList(optional), rest, optionalList(post), keywords, keywordRest, block)
+}
+
+// destructuredParam reads a parameter written as (a, (b, *c)); the parser
+// replaces it with a generated name, so it is rebuilt from the tokens.
+func (d *ripperDispatcher) destructuredParam() *object.EmeraldValue {
+	d.skip("(", "lparen")
+	list := d.event("mlhs_new")
+	for {
+		index := d.peek()
+		if index < 0 || d.tokens[index].Event == "rparen" {
+			break
+		}
+		token := d.tokens[index]
+		switch {
+		case token.Event == "lparen":
+			list = d.event("mlhs_add", list, d.destructuredParam())
+		case token.Event == "op" && token.Text == "*":
+			d.claim(index)
+			var name *object.EmeraldValue
+			if d.peekIs("ident", "") {
+				d.declare(d.tokens[d.peek()].Text)
+				name = d.claim(d.peek())
+			}
+			list = d.event("mlhs_add_star", list, name)
+		case token.Event == "ident":
+			d.declare(token.Text)
+			list = d.event("mlhs_add", list, d.claim(index))
+		default:
+			d.claim(index)
+		}
+	}
+	d.skip(")", "rparen")
+	return d.event("mlhs_paren", list)
+}
+
+func (d *ripperDispatcher) def(n *ast.DefExpression) *object.EmeraldValue {
+	var visibility *object.EmeraldValue
+	if n.Visibility != "" {
+		if index := d.peek(); index >= 0 && d.tokens[index].Event == "ident" && d.tokens[index].Text == n.Visibility {
+			visibility = d.claim(index)
+		}
+	}
+	d.skip("def", "kw")
+	var receiver, operator *object.EmeraldValue
+	if n.Receiver != nil {
+		receiver = d.expr(n.Receiver)
+		operator = d.take("", "period", "op")
+	}
+	var name *object.EmeraldValue
+	if index := d.peek(); index >= 0 && d.tokens[index].Text == n.Name.Value {
+		// Operator names such as == keep their trailing = in one token.
+		name = d.claim(index)
+	} else {
+		name = d.take(strings.TrimSuffix(n.Name.Value, "="), "ident", "const", "op", "kw", "backtick")
+		if index := d.find("", "ident", "const", "op", "kw", "backtick"); n.Name.Value != "" && strings.HasSuffix(n.Name.Value, "=") && index >= 0 && d.tokens[index].Text == n.Name.Value {
+			name = d.claim(index)
+		}
+	}
+
+	var result *object.EmeraldValue
+	d.withScope(true, func() {
+		spec := ripperParamSpec{
+			owner:  n,
+			params: n.Params, defaults: n.ParamDefaults, rest: n.RestParam, restIndex: n.RestParamIndex,
+			keywords: n.KeywordParams, keywordRest: n.KeywordRestParam, rejectKeywords: n.RejectKeywords, block: n.BlockParam,
+		}
+		var params *object.EmeraldValue
+		switch {
+		case d.peekIs("lparen", ""):
+			d.claim(d.peek())
+			params = d.event("paren", d.params(spec))
+			d.skip(")", "rparen")
+		case d.peekIs("op", "=") && spec.empty():
+		default:
+			params = d.params(spec)
+		}
+		var body *object.EmeraldValue
+		if d.peekIs("op", "=") {
+			d.claim(d.peek())
+			var value *object.EmeraldValue
+			if n.Body != nil && len(n.Body.Statements) > 0 {
+				value = d.statement(n.Body.Statements[0])
+			}
+			body = d.event("bodystmt", value, nil, nil, nil)
+		} else {
+			body = d.bodystmt(n.Body)
+			d.skip("end", "kw")
+		}
+		if receiver != nil {
+			result = d.event("defs", receiver, operator, name, params, body)
+		} else {
+			result = d.event("def", name, params, body)
+		}
+	})
+	if visibility != nil {
+		result = d.event("command", visibility, d.event("args_add_block", d.event("args_add", d.event("args_new"), result), R.FalseVal))
+	}
+	return result
+}
+
+// constPath dispatches the name of a class or module definition.
+func (d *ripperDispatcher) constPath(name string, absolute bool) *object.EmeraldValue {
+	parts := strings.Split(strings.TrimPrefix(name, "::"), "::")
+	var path *object.EmeraldValue
+	for i, part := range parts {
+		switch {
+		case i == 0 && (absolute || strings.HasPrefix(name, "::")):
+			d.skip("::", "op")
+			path = d.event("top_const_ref", d.take(part, "const"))
+		case i == 0 && len(parts) == 1:
+			path = d.event("const_ref", d.take(part, "const"))
+		case i == 0:
+			path = d.event("var_ref", d.take(part, "const"))
+		default:
+			d.skip("::", "op")
+			path = d.event("const_path_ref", path, d.take(part, "const"))
+		}
+	}
+	return path
+}
+
+func (d *ripperDispatcher) class(n *ast.ClassExpression) *object.EmeraldValue {
+	d.skip("class", "kw")
+	if n.SingletonReceiver != nil {
+		d.skip("<<", "op")
+		target := d.expr(n.SingletonReceiver)
+		var body *object.EmeraldValue
+		d.withScope(true, func() { body = d.bodystmt(n.Body) })
+		d.skip("end", "kw")
+		return d.event("sclass", target, body)
+	}
+	path := d.constPath(n.Name.Value, n.Absolute)
+	var superclass *object.EmeraldValue
+	if n.SuperClass != nil {
+		d.skip("<", "op")
+		superclass = d.expr(n.SuperClass)
+	}
+	var body *object.EmeraldValue
+	d.withScope(true, func() { body = d.bodystmt(n.Body) })
+	d.skip("end", "kw")
+	return d.event("class", path, superclass, body)
+}
+
+func (d *ripperDispatcher) ifExpression(n *ast.IfExpression) *object.EmeraldValue {
+	keyword := "if"
+	if n.IsUnless {
+		keyword = "unless"
+	}
+	if n.Modifier {
+		condition := n.Condition
+		if negated := ripperUnlessCondition(n); negated != nil {
+			keyword, condition = "unless", negated
+		}
+		var statement *object.EmeraldValue
+		if n.Consequent != nil && len(n.Consequent.Statements) > 0 {
+			statement = d.statement(n.Consequent.Statements[0])
+		}
+		d.skip(keyword, "kw")
+		return d.event(keyword+"_mod", d.expr(condition), statement)
+	}
+	d.skip(keyword, "kw")
+	condition := d.expr(n.Condition)
+	d.optional("then")
+	consequent := d.block(n.Consequent)
+	type branch struct{ condition, body *object.EmeraldValue }
+	var branches []branch
+	for _, elsif := range n.ElsIf {
+		d.skip("elsif", "kw")
+		current := branch{condition: d.expr(elsif.Condition)}
+		d.optional("then")
+		current.body = d.block(elsif.Consequent)
+		branches = append(branches, current)
+	}
+	var alternative *object.EmeraldValue
+	if n.Alternative != nil {
+		d.skip("else", "kw")
+		alternative = d.event("else", d.block(n.Alternative))
+	}
+	for i := len(branches) - 1; i >= 0; i-- {
+		alternative = d.event("elsif", branches[i].condition, branches[i].body, alternative)
+	}
+	d.skip("end", "kw")
+	return d.event(keyword, condition, consequent, alternative)
+}
+
+// ripperUnlessCondition returns the condition of a `x unless c` modifier,
+// which the parser stores as `x if !c`, or nil for any other if.
+func ripperUnlessCondition(n *ast.IfExpression) ast.Expression {
+	if !n.Modifier || n.Token.Literal != "unless" {
+		return nil
+	}
+	if prefix, ok := n.Condition.(*ast.PrefixExpression); ok && prefix.Operator == "!" {
+		return prefix.Right
+	}
+	return nil
+}
+
+func (d *ripperDispatcher) loop(keyword string, condition ast.Expression, body *ast.BlockExpression, post bool) *object.EmeraldValue {
+	if post {
+		var statement *object.EmeraldValue
+		if body != nil && len(body.Statements) > 0 {
+			statement = d.statement(body.Statements[0])
+		}
+		d.skip(keyword, "kw")
+		return d.event(keyword+"_mod", d.expr(condition), statement)
+	}
+	d.skip(keyword, "kw")
+	conditionValue := d.expr(condition)
+	d.optional("do")
+	statements := d.block(body)
+	d.skip("end", "kw")
+	return d.event(keyword, conditionValue, statements)
+}
+
+func (d *ripperDispatcher) forExpression(n *ast.ForExpression) *object.EmeraldValue {
+	d.skip("for", "kw")
+	var variable *object.EmeraldValue
+	if len(n.Variable) == 1 {
+		variable = d.assignTarget(n.Variable[0])
+	} else {
+		variable = d.mlhs(n.Variable)
+	}
+	d.skip("in", "kw")
+	collection := d.expr(n.Collection)
+	d.optional("do")
+	statements := d.block(n.Body)
+	d.skip("end", "kw")
+	return d.event("for", variable, collection, statements)
+}
+
+func (d *ripperDispatcher) caseExpression(n *ast.CaseExpression) *object.EmeraldValue {
+	d.skip("case", "kw")
+	subject := d.expr(n.Expression)
+	type clause struct {
+		pattern          bool
+		condition, guard *object.EmeraldValue
+		guardUnless      bool
+		body             *object.EmeraldValue
+	}
+	var clauses []clause
+	for _, caseClause := range n.Clauses {
+		var current clause
+		if len(caseClause.Conditions) == 1 {
+			if match, ok := caseClause.Conditions[0].(*ast.PatternMatchExpression); ok {
+				d.skip("in", "kw")
+				current.pattern = true
+				current.condition = d.pattern()
+				if match.Guard != nil {
+					if match.GuardUnless {
+						d.skip("unless", "kw")
+					} else {
+						d.skip("if", "kw")
+					}
+					current.guard, current.guardUnless = d.expr(match.Guard), match.GuardUnless
+				}
+			}
+		}
+		if !current.pattern {
+			d.skip("when", "kw")
+			args := d.event("args_new")
+			for _, condition := range caseClause.Conditions {
+				if splat, ok := condition.(*ast.SplatExpression); ok {
+					d.skip("*", "op")
+					args = d.event("args_add_star", args, d.expr(splat.Value))
+					continue
+				}
+				args = d.event("args_add", args, d.expr(condition))
+			}
+			current.condition = args
+		}
+		d.optional("then")
+		current.body = d.block(caseClause.Body)
+		clauses = append(clauses, current)
+	}
+	var next *object.EmeraldValue
+	if n.Else != nil {
+		d.skip("else", "kw")
+		next = d.event("else", d.block(n.Else))
+	}
+	for i := len(clauses) - 1; i >= 0; i-- {
+		current := clauses[i]
+		if current.pattern {
+			condition := current.condition
+			if current.guard != nil {
+				event := "if_mod"
+				if current.guardUnless {
+					event = "unless_mod"
+				}
+				condition = d.event(event, current.guard, condition)
+			}
+			next = d.event("in", condition, current.body, next)
+		} else {
+			next = d.event("when", current.condition, current.body, next)
+		}
+	}
+	d.skip("end", "kw")
+	return d.event("case", subject, next)
+}
+
+func (d *ripperDispatcher) beginExpression(n *ast.BeginExpression) *object.EmeraldValue {
+	switch n.Token.Literal {
+	case "rescue":
+		var statement *object.EmeraldValue
+		if n.Body != nil && len(n.Body.Statements) > 0 {
+			statement = d.statement(n.Body.Statements[0])
+		}
+		d.skip("rescue", "kw")
+		var rescue *object.EmeraldValue
+		if len(n.Rescue) > 0 && n.Rescue[0].Body != nil && len(n.Rescue[0].Body.Statements) > 0 {
+			rescue = d.statement(n.Rescue[0].Body.Statements[0])
+		}
+		return d.event("rescue_mod", statement, rescue)
+	case "begin":
+		d.skip("begin", "kw")
+		body := d.beginBody(n)
+		d.skip("end", "kw")
+		return d.event("begin", body)
+	}
+	return d.beginBody(n)
+}
+
+// assignTarget dispatches the left-hand side of an assignment.
+func (d *ripperDispatcher) assignTarget(target ast.Expression) *object.EmeraldValue {
+	return d.spanned(target, func() *object.EmeraldValue { return d.walkTarget(target) })
+}
+
+func (d *ripperDispatcher) walkTarget(target ast.Expression) *object.EmeraldValue {
+	switch n := target.(type) {
+	case *ast.Identifier:
+		if !strings.HasPrefix(n.Value, "@") && !strings.HasPrefix(n.Value, "$") {
+			d.declare(n.Value)
+		}
+		return d.event("var_field", d.variable(n.Value))
+	case *ast.InstanceVariable:
+		return d.event("var_field", d.take(n.Name, "ivar"))
+	case *ast.ClassVariable:
+		return d.event("var_field", d.take(n.Name, "cvar"))
+	case *ast.GlobalVariable:
+		return d.event("var_field", d.take(n.Name, "gvar"))
+	case *ast.Constant:
+		return d.event("var_field", d.take(n.Name, "const"))
+	case *ast.SplatExpression:
+		d.skip("*", "op")
+		return d.assignTarget(n.Value)
+	case *ast.ArrayLiteral:
+		d.skip("(", "lparen")
+		list := d.mlhs(n.Elements)
+		d.skip(")", "rparen")
+		return d.event("mlhs_paren", list)
+	case *ast.MethodCall:
+		receiver := d.expr(n.Receiver)
+		operator := d.take("", "period", "op")
+		return d.event("field", receiver, operator, d.take(strings.TrimSuffix(n.Method.Value, "="), "ident", "const"))
+	case *ast.IndexExpression:
+		left := d.expr(n.Left)
+		return d.event("aref_field", left, d.indexArgs(n.Index, n.End))
+	case *ast.ConstantResolution:
+		if n.Left == nil {
+			d.skip("::", "op")
+			return d.event("top_const_field", d.take(n.Name.Value, "const"))
+		}
+		left := d.expr(n.Left)
+		d.skip("::", "op")
+		return d.event("const_path_field", left, d.take(n.Name.Value, "const"))
+	}
+	return d.expr(target)
+}
+
+func (d *ripperDispatcher) mlhs(targets []ast.Expression) *object.EmeraldValue {
+	list := d.event("mlhs_new")
+	var post *object.EmeraldValue
+	for _, target := range targets {
+		if splat, ok := target.(*ast.SplatExpression); ok {
+			d.skip("*", "op")
+			var value *object.EmeraldValue
+			if splat.Value != nil {
+				value = d.assignTarget(splat.Value)
+			}
+			list = d.event("mlhs_add_star", list, value)
+			post = d.event("mlhs_new")
+			continue
+		}
+		if post != nil {
+			post = d.event("mlhs_add", post, d.assignTarget(target))
+			continue
+		}
+		list = d.event("mlhs_add", list, d.assignTarget(target))
+	}
+	if post != nil {
+		list = d.event("mlhs_add_post", list, post)
+	}
+	return list
+}
+
+// rhs dispatches an assigned value; a bare list `a = 1, 2` becomes an mrhs.
+func (d *ripperDispatcher) rhs(values []ast.Expression) *object.EmeraldValue {
+	if len(values) == 1 {
+		if array, ok := values[0].(*ast.ArrayLiteral); ok && !d.peekIs("lbracket", "") && d.peek() >= 0 && !strings.HasSuffix(d.tokens[d.peek()].Event, "_beg") {
+			values = array.Elements
+		} else {
+			return d.expr(values[0])
+		}
+	}
+	if len(values) == 0 {
+		return d.event("mrhs_new")
+	}
+	last := values[len(values)-1]
+	args := d.listArgs(values[:len(values)-1])
+	mrhs := d.event("mrhs_new_from_args", args)
+	if splat, ok := last.(*ast.SplatExpression); ok {
+		d.skip("*", "op")
+		return d.event("mrhs_add_star", mrhs, d.expr(splat.Value))
+	}
+	return d.event("mrhs_add", mrhs, d.expr(last))
+}
+
+func (d *ripperDispatcher) finishAssign(target *object.EmeraldValue, operator string, value ast.Expression) *object.EmeraldValue {
+	if operator == "" || operator == "=" {
+		d.skip("=", "op")
+		return d.event("assign", target, d.rhs([]ast.Expression{value}))
+	}
+	operatorToken := d.take(operator, "op")
+	return d.event("opassign", target, operatorToken, d.expr(value))
+}
+
+func (d *ripperDispatcher) simpleAssign(name, operator string, value ast.Expression) *object.EmeraldValue {
+	return d.finishAssign(d.event("var_field", d.variable(name)), operator, value)
+}
+
+func (d *ripperDispatcher) assign(n *ast.AssignExpression) *object.EmeraldValue {
+	var target *object.EmeraldValue
+	switch {
+	case n.Index != nil:
+		var receiver *object.EmeraldValue
+		if n.Target != nil {
+			receiver = d.expr(n.Target)
+		} else {
+			receiver = d.expr(n.Name)
+		}
+		target = d.event("aref_field", receiver, d.indexArgs(n.Index, n.End))
+	case n.Target != nil:
+		left := d.expr(n.Target)
+		d.skip("::", "op")
+		target = d.event("const_path_field", left, d.take(n.Name.Value, "const"))
+	default:
+		target = d.assignTarget(n.Name)
+	}
+	return d.finishAssign(target, n.Token.Literal, n.Value)
+}
+
+// attributeAssign handles `recv.attr = v` and its operator forms, which the
+// parser represents as setter calls.
+func (d *ripperDispatcher) attributeAssign(n *ast.MethodCall) *object.EmeraldValue {
+	receiver := d.expr(n.Receiver)
+	operator := d.take("", "period", "op")
+	field := d.event("field", receiver, operator, d.take(strings.TrimSuffix(n.Method.Value, "="), "ident", "const"))
+	var value ast.Expression
+	if len(n.Args) > 0 {
+		value = n.Args[0]
+	}
+	if n.LogicalAssignment != "" {
+		return d.finishAssign(field, string(n.LogicalAssignment), value)
+	}
+	if infix, ok := value.(*ast.InfixExpression); ok && strings.HasSuffix(infix.Token.Literal, "=") && infix.Token.Literal != "==" {
+		if call, ok := infix.Left.(*ast.MethodCall); ok && call.Receiver == n.Receiver {
+			return d.finishAssign(field, infix.Token.Literal, infix.Right)
+		}
+	}
+	return d.finishAssign(field, "=", value)
+}
+
+func (d *ripperDispatcher) multiAssign(n *ast.MultiAssignExpression) *object.EmeraldValue {
+	targets := n.Targets
+	if len(targets) == 0 {
+		for _, name := range n.Names {
+			targets = append(targets, name)
+		}
+	}
+	list := d.mlhs(targets)
+	d.skip("=", "op")
+	return d.event("massign", list, d.rhs(n.Values))
+}
+
+func (d *ripperDispatcher) lambda(n *ast.ProcLiteral) *object.EmeraldValue {
+	d.skip("->", "tlambda")
+	var result *object.EmeraldValue
+	d.withScope(false, func() {
+		spec := ripperParamSpec{
+			owner:  n,
+			params: n.Params, defaults: n.ParamDefaults, rest: n.RestParam, restIndex: n.RestParamIndex,
+			keywords: n.KeywordParams, keywordRest: n.KeywordRestParam, rejectKeywords: n.RejectKeywords, block: n.BlockParam,
+		}
+		var params *object.EmeraldValue
+		if d.peekIs("lparen", "") {
+			d.claim(d.peek())
+			params = d.event("paren", d.params(spec))
+			d.skip(")", "rparen")
+		} else {
+			params = d.params(spec)
+		}
+		if d.peekIs("kw", "do") {
+			d.claim(d.peek())
+			body := d.bodystmt(n.Body)
+			d.skip("end", "kw")
+			result = d.event("lambda", params, body)
+			return
+		}
+		d.skip("{", "tlambeg")
+		statements := d.block(n.Body)
+		d.skip("}", "rbrace")
+		result = d.event("lambda", params, statements)
+	})
+	return result
+}
+
+// pattern dispatches a pattern of `case/in`, `=>` or `in`. The parser keeps
+// patterns as source text, so they are rebuilt from the scanner tokens.
+func (d *ripperDispatcher) pattern() *object.EmeraldValue {
+	value := d.patternPrimary()
+	for {
+		index := d.peek()
+		if index < 0 {
+			return value
+		}
+		token := d.tokens[index]
+		switch {
+		case token.Event == "op" && token.Text == "|":
+			d.claim(index)
+			value = d.event("binary", value, rubySymbol("|"), d.patternPrimary())
+		case token.Event == "op" && token.Text == "=>":
+			d.claim(index)
+			if name := d.peek(); name >= 0 && d.tokens[name].Event == "ident" {
+				d.declare(d.tokens[name].Text)
+			}
+			value = d.event("binary", value, rubySymbol("=>"), d.event("var_field", d.take("", "ident")))
+		default:
+			return value
+		}
+	}
+}
+
+func (d *ripperDispatcher) patternPrimary() *object.EmeraldValue {
+	index := d.peek()
+	if index < 0 {
+		return nil
+	}
+	token := d.tokens[index]
+	switch token.Event {
+	case "lbracket":
+		d.claim(index)
+		value := d.arrayPattern(nil, "rbracket")
+		return value
+	case "lbrace":
+		d.claim(index)
+		return d.hashPattern(nil, "rbrace")
+	case "ident":
+		d.claim(index)
+		d.declare(token.Text)
+		return d.event("var_field", d.values[index])
+	case "const":
+		constant := d.event("var_ref", d.claim(index))
+		for d.peekIs("op", "::") {
+			d.claim(d.peek())
+			constant = d.event("const_path_ref", constant, d.take("", "const"))
+		}
+		if d.immediate("lparen") || d.immediate("lbracket") {
+			closer := "rparen"
+			if d.tokens[d.cursor].Event == "lbracket" {
+				closer = "rbracket"
+			}
+			d.claim(d.cursor)
+			if next := d.peek(); next >= 0 && d.tokens[next].Event == "label" {
+				return d.hashPattern(constant, closer)
+			}
+			return d.arrayPattern(constant, closer)
+		}
+		return constant
+	case "op":
+		if token.Text == "^" {
+			d.claim(index)
+			return d.event("begin", d.patternPrimary())
+		}
+		if token.Text == "*" {
+			d.claim(index)
+			return d.event("var_field", d.take("", "ident"))
+		}
+		if token.Text == "-" {
+			d.claim(index)
+			return d.event("unary", rubySymbol("-@"), d.patternPrimary())
+		}
+	case "kw":
+		return d.event("var_ref", d.claim(index))
+	case "int", "float", "rational", "imaginary", "CHAR":
+		value := d.claim(index)
+		if next := d.peek(); next >= 0 && (d.tokens[next].Text == ".." || d.tokens[next].Text == "...") {
+			event := "dot2"
+			if d.tokens[next].Text == "..." {
+				event = "dot3"
+			}
+			d.claim(next)
+			var right *object.EmeraldValue
+			if end := d.peek(); end >= 0 && strings.Contains("int float rational imaginary", d.tokens[end].Event) {
+				right = d.claim(end)
+			}
+			return d.event(event, value, right)
+		}
+		return value
+	case "tstring_beg", "heredoc_beg", "backtick":
+		return d.stringLiteral()
+	case "symbeg", "label":
+		return d.symbolLiteral()
+	case "regexp_beg":
+		return d.regexpLiteral()
+	}
+	return d.claim(index)
+}
+
+func (d *ripperDispatcher) arrayPattern(constant *object.EmeraldValue, closer string) *object.EmeraldValue {
+	var pre, post []*object.EmeraldValue
+	var rest *object.EmeraldValue
+	for {
+		index := d.peek()
+		if index < 0 || d.tokens[index].Event == closer {
+			break
+		}
+		if d.tokens[index].Event == "comma" {
+			d.claim(index)
+			continue
+		}
+		if d.tokens[index].Text == "*" {
+			d.claim(index)
+			if d.peekIs("ident", "") {
+				d.declare(d.tokens[d.peek()].Text)
+				rest = d.event("var_field", d.take("", "ident"))
+			} else {
+				rest = d.event("var_field", nil)
+			}
+			continue
+		}
+		element := d.pattern()
+		if rest != nil {
+			post = append(post, element)
+		} else {
+			pre = append(pre, element)
+		}
+	}
+	d.skip("", closer)
+	list := func(values []*object.EmeraldValue) *object.EmeraldValue {
+		if len(values) == 0 {
+			return nil
+		}
+		return ripperList(values...)
+	}
+	return d.event("aryptn", constant, list(pre), rest, list(post))
+}
+
+func (d *ripperDispatcher) hashPattern(constant *object.EmeraldValue, closer string) *object.EmeraldValue {
+	var pairs []*object.EmeraldValue
+	var rest *object.EmeraldValue
+	for {
+		index := d.peek()
+		if index < 0 || d.tokens[index].Event == closer {
+			break
+		}
+		token := d.tokens[index]
+		switch {
+		case token.Event == "comma":
+			d.claim(index)
+		case token.Text == "**":
+			d.claim(index)
+			if d.peekIs("ident", "") {
+				d.declare(d.tokens[d.peek()].Text)
+				rest = d.event("var_field", d.take("", "ident"))
+			} else {
+				rest = d.event("var_field", d.take("nil", "kw"))
+			}
+		case token.Event == "label":
+			label := d.claim(index)
+			var value *object.EmeraldValue
+			if next := d.peekSeparator(); next >= 0 && d.tokens[next].Event != "comma" && d.tokens[next].Event != closer {
+				value = d.pattern()
+			} else {
+				d.declare(strings.TrimSuffix(token.Text, ":"))
+			}
+			pairs = append(pairs, ripperList(label, value))
+		default:
+			d.claim(index)
+		}
+	}
+	d.skip("", closer)
+	var list *object.EmeraldValue
+	if len(pairs) > 0 {
+		list = ripperList(pairs...)
+	}
+	return d.event("hshptn", constant, list, rest)
+}
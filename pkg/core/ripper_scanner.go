@@ -0,0 +1,1288 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ripper lexer states. The bits and their names follow MRI's EXPR_* flags so
+// that Ripper.lex and Ripper::Lexer::State report the same values.
+const (
+	ripperExprBeg = 1 << iota
+	ripperExprEnd
+	ripperExprEndArg
+	ripperExprEndFn
+	ripperExprArg
+	ripperExprCmdArg
+	ripperExprMid
+	ripperExprFname
+	ripperExprDot
+	ripperExprClass
+	ripperExprLabel
+	ripperExprLabeled
+	ripperExprFitem
+
+	ripperExprValue  = ripperExprBeg
+	ripperExprBegAny = ripperExprBeg | ripperExprMid | ripperExprClass
+	ripperExprArgAny = ripperExprArg | ripperExprCmdArg
+	ripperExprEndAny = ripperExprEnd | ripperExprEndArg | ripperExprEndFn
+)
+
+var ripperStateNames = []string{
+	"BEG", "END", "ENDARG", "ENDFN", "ARG", "CMDARG", "MID", "FNAME", "DOT", "CLASS", "LABEL", "LABELED", "FITEM",
+}
+
+// ripperStateString renders a state the way Ripper::Lexer::State#to_s does,
+// e.g. "BEG|LABEL".
+func ripperStateString(state int) string {
+	if state == 0 {
+		return "NONE"
+	}
+	var names []string
+	for bit, name := range ripperStateNames {
+		if state&(1<<bit) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// ripperKeywords maps each reserved word to the state it leaves the lexer in
+// and whether it has a modifier form (`x if y`).
+var ripperKeywords = map[string]struct {
+	state    int
+	modifier bool
+}{
+	"end": {ripperExprEnd, false}, "else": {ripperExprBeg, false}, "case": {ripperExprValue, false},
+	"ensure": {ripperExprBeg, false}, "module": {ripperExprValue, false}, "elsif": {ripperExprValue, false},
+	"def": {ripperExprFname, false}, "rescue": {ripperExprMid, true}, "not": {ripperExprArg, false},
+	"then": {ripperExprBeg, false}, "yield": {ripperExprArg, false}, "for": {ripperExprValue, false},
+	"self": {ripperExprEnd, false}, "false": {ripperExprEnd, false}, "retry": {ripperExprEnd, false},
+	"return": {ripperExprMid, false}, "true": {ripperExprEnd, false}, "if": {ripperExprValue, true},
+	"defined?": {ripperExprArg, false}, "super": {ripperExprArg, false}, "undef": {ripperExprFname | ripperExprFitem, false},
+	"break": {ripperExprMid, false}, "in": {ripperExprValue, false}, "do": {ripperExprBeg, false},
+	"nil": {ripperExprEnd, false}, "until": {ripperExprValue, true}, "unless": {ripperExprValue, true},
+	"or": {ripperExprValue, false}, "next": {ripperExprMid, false}, "when": {ripperExprValue, false},
+	"redo": {ripperExprEnd, false}, "and": {ripperExprValue, false}, "begin": {ripperExprBeg, false},
+	"__LINE__": {ripperExprEnd, false}, "class": {ripperExprClass, false}, "__FILE__": {ripperExprEnd, false},
+	"END": {ripperExprEnd, false}, "BEGIN": {ripperExprEnd, false}, "while": {ripperExprValue, true},
+	"alias": {ripperExprFname | ripperExprFitem, false}, "__ENCODING__": {ripperExprEnd, false},
+}
+
+// ripperOperators is ordered longest first so the scanner can take the first
+// prefix that matches.
+var ripperOperators = []string{
+	"**=", "<=>", "===", "...", "<<=", ">>=", "&&=", "||=",
+	"**", "==", "=~", "!=", "!~", ">=", "<=", "&&", "||", "<<", ">>", "+=", "-=", "*=", "/=", "%=", "|=", "&=", "^=",
+	"..", "::", "->", "=>", "&.",
+	"+", "-", "*", "/", "%", "=", "<", ">", "!", "&", "|", "^", "~", "?", ":", ",", ".", ";", "(", ")", "[", "]", "{", "}",
+}
+
+// ripperToken is one scanner event: its event name without the "on_"
+// prefix, the exact source text and the lexer state after it. Pair links an
+// opening token (string, embexpr, heredoc) to the token that closes it, and
+// Body is the index of a heredoc's first body token.
+type ripperToken struct {
+	Offset int
+	Line   int
+	Column int
+	Event  string
+	Text   string
+	State  int
+	Pair   int
+	Body   int
+}
+
+const (
+	ripperTermString = iota
+	ripperTermRegexp
+	ripperTermWords
+	ripperTermHeredoc
+	ripperTermEmbexpr
+)
+
+// ripperTerm is an open literal (or an #{} inside one) on the scanner's
+// stack.
+type ripperTerm struct {
+	kind        int
+	open, close byte
+	nest        int
+	interpolate bool
+	labelable   bool
+	begin       int
+	heredoc     *ripperHeredoc
+}
+
+type ripperHeredoc struct {
+	id          string
+	squiggly    bool
+	indented    bool
+	interpolate bool
+	begin       int
+	indent      int
+}
+
+type ripperScanner struct {
+	src        string
+	pos        int
+	lineStarts []int
+	firstLine  int
+
+	state        int
+	commandStart bool
+	spaceSeen    bool
+	spaceBefore  bool
+	parenDepth   int
+	lambdaDepths []int
+
+	stack   []*ripperTerm
+	pending []*ripperHeredoc
+
+	locals      map[string]bool
+	paramDepth  int
+	paramBlock  bool
+	paramLine   bool
+	lastKeyword string
+
+	tokens  []ripperToken
+	endSeen bool
+}
+
+// ripperScan splits source into MRI scanner events. It keeps its own lexer
+// state instead of replaying pkg/lexer; the package documentation says why.
+func ripperScan(source string, firstLine int) ([]ripperToken, bool) {
+	s := &ripperScanner{src: source, firstLine: firstLine, state: ripperExprBeg, commandStart: true, locals: map[string]bool{}}
+	s.lineStarts = []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			s.lineStarts = append(s.lineStarts, i+1)
+		}
+	}
+	for s.pos < len(s.src) && !s.endSeen {
+		before := s.pos
+		if top := s.top(); top != nil && top.kind != ripperTermEmbexpr {
+			if top.kind == ripperTermHeredoc {
+				s.scanHeredocBody(top)
+			} else {
+				s.scanStringContent(top)
+			}
+		} else {
+			s.scanToken()
+		}
+		if s.pos == before {
+			// Never stall on input the scanner does not understand.
+			s.pos++
+			s.emit(before, "op")
+		}
+	}
+	return s.tokens, s.endSeen
+}
+
+func (s *ripperScanner) top() *ripperTerm {
+	if len(s.stack) == 0 {
+		return nil
+	}
+	return s.stack[len(s.stack)-1]
+}
+
+func (s *ripperScanner) push(term *ripperTerm) {
+	s.stack = append(s.stack, term)
+}
+
+func (s *ripperScanner) pop() *ripperTerm {
+	term := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	return term
+}
+
+func (s *ripperScanner) peek(offset int) byte {
+	if s.pos+offset < len(s.src) && s.pos+offset >= 0 {
+		return s.src[s.pos+offset]
+	}
+	return 0
+}
+
+func (s *ripperScanner) position(offset int) (int, int) {
+	index := sort.SearchInts(s.lineStarts, offset+1) - 1
+	return index + s.firstLine, offset - s.lineStarts[index]
+}
+
+// emit records the text from start to the current position as one event.
+func (s *ripperScanner) emit(start int, event string) int {
+	line, column := s.position(start)
+	s.tokens = append(s.tokens, ripperToken{Offset: start, Line: line, Column: column, Event: event, Text: s.src[start:s.pos], State: s.state, Pair: -1})
+	return len(s.tokens) - 1
+}
+
+func (s *ripperScanner) atLineStart() bool {
+	return s.pos == 0 || s.src[s.pos-1] == '\n'
+}
+
+func (s *ripperScanner) isBeg() bool {
+	return s.state&ripperExprBegAny != 0 || s.state&(ripperExprArg|ripperExprLabeled) == ripperExprArg|ripperExprLabeled
+}
+
+func (s *ripperScanner) isEnd() bool { return s.state&ripperExprEndAny != 0 }
+
+func (s *ripperScanner) isArg() bool { return s.state&ripperExprArgAny != 0 }
+
+func (s *ripperScanner) afterOperator() bool { return s.state&(ripperExprFname|ripperExprDot) != 0 }
+
+// spaceArg reports MRI's IS_SPCARG: an argument position after a space
+// where the next character is not a space, as in `foo /re/` or `p -1`.
+func (s *ripperScanner) spaceArg(next byte) bool {
+	return s.isArg() && s.spaceBefore && !ripperIsSpace(next)
+}
+
+func (s *ripperScanner) operatorState() int {
+	if s.afterOperator() {
+		return ripperExprArg
+	}
+	return ripperExprBeg
+}
+
+func ripperIsSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\f' || c == '\r' || c == '\v' || c == '\n'
+}
+
+func ripperIsIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func ripperIsIdentChar(c byte) bool {
+	return ripperIsIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func ripperIsDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func (s *ripperScanner) scanToken() {
+	if s.atLineStart() && s.scanLineStart() {
+		return
+	}
+	c := s.src[s.pos]
+	start := s.pos
+	switch {
+	case c == ' ' || c == '\t' || c == '\f' || c == '\r' || c == '\v' || (c == '\\' && (s.peek(1) == '\n' || (s.peek(1) == '\r' && s.peek(2) == '\n'))):
+		for s.pos < len(s.src) {
+			ch := s.src[s.pos]
+			if ch == ' ' || ch == '\t' || ch == '\f' || ch == '\v' || (ch == '\r' && s.peek(1) != '\n') {
+				s.pos++
+			} else if ch == '\r' && s.peek(1) == '\n' && s.pos > start && s.src[s.pos-1] == '\\' {
+				s.pos += 2
+			} else if ch == '\\' && s.peek(1) == '\n' {
+				s.pos += 2
+			} else if ch == '\\' && s.peek(1) == '\r' && s.peek(2) == '\n' {
+				s.pos += 3
+			} else {
+				break
+			}
+		}
+		if s.pos == start {
+			// A lone "\r" before "\n" belongs to the newline.
+			s.scanNewline()
+			return
+		}
+		s.emit(start, "sp")
+		s.spaceSeen = true
+		return
+	case c == '\n':
+		s.scanNewline()
+		return
+	case c == '#':
+		end := strings.IndexByte(s.src[s.pos:], '\n')
+		if end < 0 {
+			s.pos = len(s.src)
+			s.emit(start, "comment")
+			return
+		}
+		s.pos += end + 1
+		s.emit(start, "comment")
+		s.newlineSeen(!s.newlineIgnored())
+		return
+	}
+
+	cmdState := s.commandStart
+	s.commandStart = false
+	spaceSeen := s.spaceSeen
+	s.spaceBefore, s.spaceSeen = spaceSeen, false
+
+	switch {
+	case ripperIsDigit(c):
+		s.scanNumber()
+	case ripperIsIdentStart(c):
+		s.scanIdentifier(cmdState)
+	case c == '"' || c == '\'':
+		labelable := s.labelPossible(cmdState)
+		s.pos++
+		index := s.emit(start, "tstring_beg")
+		s.push(&ripperTerm{kind: ripperTermString, close: c, interpolate: c == '"', labelable: labelable, begin: index})
+	case c == '`':
+		s.pos++
+		if s.afterOperator() {
+			s.state = ripperExprArg
+			s.emit(start, "backtick")
+			return
+		}
+		index := s.emit(start, "backtick")
+		s.push(&ripperTerm{kind: ripperTermString, close: '`', interpolate: true, begin: index})
+	case c == '@':
+		s.pos++
+		event := "ivar"
+		if s.peek(0) == '@' {
+			s.pos++
+			event = "cvar"
+		}
+		for s.pos < len(s.src) && ripperIsIdentChar(s.src[s.pos]) {
+			s.pos++
+		}
+		if s.state&ripperExprFname != 0 {
+			s.state = ripperExprEndFn
+		} else {
+			s.state = ripperExprEnd
+		}
+		s.emit(start, event)
+	case c == '$':
+		s.scanGlobal()
+	case c == '?':
+		s.scanQuestion()
+	case c == ':':
+		s.scanColon(spaceSeen)
+	case c == '/' && (s.isBeg() || (s.peek(1) != '=' && s.spaceArg(s.peek(1)))):
+		s.pos++
+		index := s.emit(start, "regexp_beg")
+		s.push(&ripperTerm{kind: ripperTermRegexp, close: '/', interpolate: true, begin: index})
+	case c == '%' && s.percentLiteral():
+		s.scanPercent()
+	case c == '<' && s.peek(1) == '<' && s.heredocPossible(spaceSeen) && s.scanHeredocStart():
+	default:
+		s.scanOperator()
+	}
+}
+
+// scanLineStart handles =begin documentation and __END__, which are only
+// recognised at the start of a line.
+func (s *ripperScanner) scanLineStart() bool {
+	rest := s.src[s.pos:]
+	if strings.HasPrefix(rest, "=begin") && (len(rest) == 6 || ripperIsSpace(rest[6])) {
+		start := s.pos
+		s.pos += s.lineLength(s.pos)
+		s.emit(start, "embdoc_beg")
+		for s.pos < len(s.src) {
+			start := s.pos
+			s.pos += s.lineLength(s.pos)
+			line := s.src[start:s.pos]
+			if strings.HasPrefix(line, "=end") && (len(line) == 4 || ripperIsSpace(line[4])) {
+				s.emit(start, "embdoc_end")
+				return true
+			}
+			s.emit(start, "embdoc")
+		}
+		return true
+	}
+	if strings.HasPrefix(rest, "__END__") {
+		tail := rest[7:]
+		if tail == "" || tail[0] == '\n' || strings.HasPrefix(tail, "\r\n") {
+			start := s.pos
+			s.pos += 7
+			if strings.HasPrefix(tail, "\r\n") {
+				s.pos += 2
+			} else if tail != "" {
+				s.pos++
+			}
+			s.emit(start, "__end__")
+			s.endSeen = true
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ripperScanner) lineLength(start int) int {
+	end := strings.IndexByte(s.src[start:], '\n')
+	if end < 0 {
+		return len(s.src) - start
+	}
+	return end + 1
+}
+
+func (s *ripperScanner) scanNewline() {
+	start := s.pos
+	if s.src[s.pos] == '\r' {
+		s.pos++
+	}
+	s.pos++
+	if s.newlineIgnored() {
+		s.emit(start, "ignored_nl")
+		s.newlineSeen(false)
+		return
+	}
+	s.state = ripperExprBeg
+	s.emit(start, "nl")
+	s.newlineSeen(true)
+}
+
+// newlineIgnored reports whether a line break continues the current
+// statement: after an operator or comma, or before a leading `.meth`.
+func (s *ripperScanner) newlineIgnored() bool {
+	return (s.state&(ripperExprBeg|ripperExprClass|ripperExprFname|ripperExprDot) != 0 && s.state&ripperExprLabeled == 0) ||
+		s.state&(ripperExprArg|ripperExprLabeled) == ripperExprArg|ripperExprLabeled ||
+		s.leadingDotFollows()
+}
+
+// leadingDotFollows reports whether the next code line starts with `.meth`
+// or `&.meth`, which continues the current expression.
+func (s *ripperScanner) leadingDotFollows() bool {
+	i := s.pos
+	for i < len(s.src) {
+		for i < len(s.src) && (s.src[i] == ' ' || s.src[i] == '\t' || s.src[i] == '\r') {
+			i++
+		}
+		if i < len(s.src) && s.src[i] == '#' {
+			next := strings.IndexByte(s.src[i:], '\n')
+			if next < 0 {
+				return false
+			}
+			i += next + 1
+			continue
+		}
+		if i < len(s.src) && s.src[i] == '\n' {
+			return false
+		}
+		break
+	}
+	rest := s.src[i:]
+	return (strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "..")) || strings.HasPrefix(rest, "&.")
+}
+
+// newlineSeen finishes a source line: it ends statements, closes parameter
+// lists that run to the end of the line and starts pending heredoc bodies.
+func (s *ripperScanner) newlineSeen(significant bool) {
+	if significant {
+		s.state = ripperExprBeg
+		s.commandStart = true
+		s.paramLine = false
+		s.lastKeyword = ""
+	}
+	s.spaceSeen = false
+	if len(s.pending) > 0 {
+		heredoc := s.pending[0]
+		s.pending = s.pending[1:]
+		s.startHeredocBody(heredoc)
+	}
+}
+
+func (s *ripperScanner) labelPossible(cmdState bool) bool {
+	return (s.state&(ripperExprLabel|ripperExprEndFn) != 0 && !cmdState) || s.isArg()
+}
+
+func (s *ripperScanner) scanNumber() {
+	start := s.pos
+	event := "int"
+	if s.src[s.pos] == '0' && s.pos+1 < len(s.src) && strings.IndexByte("xXbBoOdD", s.src[s.pos+1]) >= 0 {
+		s.pos += 2
+		for s.pos < len(s.src) && (ripperIsIdentChar(s.src[s.pos]) && s.src[s.pos] != 'r' && s.src[s.pos] != 'i') {
+			s.pos++
+		}
+	} else {
+		s.digits()
+		if s.peek(0) == '.' && ripperIsDigit(s.peek(1)) {
+			event = "float"
+			s.pos++
+			s.digits()
+		}
+		if (s.peek(0) == 'e' || s.peek(0) == 'E') && (ripperIsDigit(s.peek(1)) || ((s.peek(1) == '+' || s.peek(1) == '-') && ripperIsDigit(s.peek(2)))) {
+			event = "float"
+			s.pos += 2
+			s.digits()
+		}
+	}
+	if s.peek(0) == 'r' && !ripperIsIdentChar(s.peek(1)) || s.peek(0) == 'r' && s.peek(1) == 'i' && !ripperIsIdentChar(s.peek(2)) {
+		s.pos++
+		event = "rational"
+	}
+	if s.peek(0) == 'i' && !ripperIsIdentChar(s.peek(1)) {
+		s.pos++
+		event = "imaginary"
+	}
+	s.state = ripperExprEnd
+	s.emit(start, event)
+}
+
+func (s *ripperScanner) digits() {
+	for s.pos < len(s.src) && (ripperIsDigit(s.src[s.pos]) || (s.src[s.pos] == '_' && ripperIsDigit(s.peek(1)))) {
+		s.pos++
+	}
+}
+
+func (s *ripperScanner) scanIdentifier(cmdState bool) {
+	start := s.pos
+	lastState := s.state
+	for s.pos < len(s.src) && ripperIsIdentChar(s.src[s.pos]) {
+		s.pos++
+	}
+	if c := s.peek(0); (c == '!' || c == '?') && (s.peek(1) != '=' || s.peek(2) == '=' || s.peek(2) == '~' || s.peek(2) == '>') {
+		s.pos++
+	}
+	word := s.src[start:s.pos]
+	last := word[len(word)-1]
+
+	if s.peek(0) == ':' && s.peek(1) != ':' && s.labelPossible(cmdState) && lastState&ripperExprDot == 0 {
+		s.pos++
+		s.state = ripperExprArg | ripperExprLabeled
+		if s.paramDepth > 0 || s.paramBlock || s.paramLine {
+			s.locals[word] = true
+		}
+		s.emit(start, "label")
+		return
+	}
+	if lastState&ripperExprDot == 0 {
+		if keyword, ok := ripperKeywords[word]; ok {
+			s.scanKeyword(start, word, keyword.state, keyword.modifier, lastState)
+			return
+		}
+	}
+	if lastState&ripperExprFname != 0 && last != '?' && last != '!' && s.peek(0) == '=' && s.peek(1) != '~' && s.peek(1) != '>' && (s.peek(1) != '=' || s.peek(2) == '>') {
+		s.pos++
+	}
+
+	event := "ident"
+	if first, _ := utf8.DecodeRuneInString(word); first >= 'A' && first <= 'Z' {
+		event = "const"
+	}
+	switch {
+	case lastState&(ripperExprBegAny|ripperExprArgAny|ripperExprDot) != 0:
+		if cmdState {
+			s.state = ripperExprCmdArg
+		} else {
+			s.state = ripperExprArg
+		}
+	case lastState == ripperExprFname:
+		s.state = ripperExprEndFn
+	default:
+		s.state = ripperExprEnd
+	}
+	if event == "ident" && lastState&(ripperExprDot|ripperExprFname) == 0 && s.locals[word] {
+		s.state = ripperExprEnd | ripperExprLabel
+	}
+	if event == "ident" && (s.paramDepth > 0 || s.paramBlock || s.paramLine || s.lastKeyword == "for" || (s.lastKeyword == "rescue" && s.previousText() == "=>")) {
+		s.locals[word] = true
+	}
+	s.emit(start, event)
+}
+
+func (s *ripperScanner) scanKeyword(start int, word string, state int, modifier bool, lastState int) {
+	if lastState&ripperExprFname != 0 {
+		s.state = ripperExprEndFn
+		s.emit(start, "kw")
+		return
+	}
+	s.state = state
+	if state&ripperExprBeg != 0 {
+		s.commandStart = true
+	}
+	if word == "do" {
+		if n := len(s.lambdaDepths); n > 0 && s.lambdaDepths[n-1] == s.parenDepth {
+			s.lambdaDepths = s.lambdaDepths[:n-1]
+		}
+		s.paramLine = false
+	}
+	if modifier && lastState&(ripperExprBeg|ripperExprLabeled|ripperExprClass) == 0 {
+		s.state = ripperExprBeg | ripperExprLabel
+	}
+	if word == "def" {
+		s.paramLine = false
+	}
+	s.lastKeyword = word
+	s.emit(start, "kw")
+	if word == "def" {
+		s.scanDefName()
+	}
+}
+
+// scanDefName marks the parameters of a method definition so that they are
+// known as local variables inside its body.
+func (s *ripperScanner) scanDefName() {
+	i := s.pos
+	for i < len(s.src) && (s.src[i] == ' ' || s.src[i] == '\t') {
+		i++
+	}
+	for i < len(s.src) && (ripperIsIdentChar(s.src[i]) || strings.IndexByte(".?!=@$:", s.src[i]) >= 0) {
+		i++
+	}
+	if i < len(s.src) && s.src[i] == '(' {
+		s.paramDepth = s.parenDepth + 1
+		return
+	}
+	s.paramLine = true
+}
+
+func (s *ripperScanner) previousText() string {
+	for i := len(s.tokens) - 1; i >= 0; i-- {
+		if s.tokens[i].Event != "sp" {
+			return s.tokens[i].Text
+		}
+	}
+	return ""
+}
+
+// afterDefSingleton reports whether the scanner is at the dot of
+// `def self.name`, after which the method name is lexed like one.
+func (s *ripperScanner) afterDefSingleton() bool {
+	seen := 0
+	for i := len(s.tokens) - 1; i >= 0; i-- {
+		if s.tokens[i].Event == "sp" {
+			continue
+		}
+		seen++
+		if seen == 2 {
+			return s.tokens[i].Event == "kw" && s.tokens[i].Text == "def"
+		}
+	}
+	return false
+}
+
+func (s *ripperScanner) previousEvent() string {
+	for i := len(s.tokens) - 1; i >= 0; i-- {
+		if s.tokens[i].Event != "sp" {
+			return s.tokens[i].Event
+		}
+	}
+	return ""
+}
+
+func (s *ripperScanner) scanGlobal() {
+	start := s.pos
+	s.pos++
+	event := "gvar"
+	c := s.peek(0)
+	switch {
+	case c == '-':
+		s.pos++
+		if s.pos < len(s.src) && ripperIsIdentChar(s.src[s.pos]) {
+			s.pos++
+		}
+	case c == '&' || c == '`' || c == '\'' || c == '+':
+		s.pos++
+		event = "backref"
+	case ripperIsDigit(c) && c != '0':
+		for s.pos < len(s.src) && ripperIsDigit(s.src[s.pos]) {
+			s.pos++
+		}
+		event = "backref"
+	case c != 0 && strings.IndexByte("~*$?!@/\\;,.=:<>\"0_", c) >= 0 && !(c == '_' && ripperIsIdentChar(s.peek(1))):
+		s.pos++
+	default:
+		for s.pos < len(s.src) && ripperIsIdentChar(s.src[s.pos]) {
+			s.pos++
+		}
+	}
+	s.state = ripperExprEnd
+	s.emit(start, event)
+}
+
+func (s *ripperScanner) scanQuestion() {
+	start := s.pos
+	next := s.peek(1)
+	if s.isEnd() || next == 0 || ripperIsSpace(next) || (ripperIsIdentChar(next) && ripperIsIdentChar(s.peek(2))) {
+		s.pos++
+		s.state = ripperExprValue
+		s.emit(start, "op")
+		return
+	}
+	s.pos++
+	if s.peek(0) == '\\' {
+		s.pos++
+		s.skipEscape()
+	} else {
+		_, size := utf8.DecodeRuneInString(s.src[s.pos:])
+		s.pos += size
+	}
+	s.state = ripperExprEnd
+	s.emit(start, "CHAR")
+}
+
+// skipEscape steps over the body of a backslash escape in a character
+// literal.
+func (s *ripperScanner) skipEscape() {
+	c := s.peek(0)
+	switch {
+	case c == 'u':
+		s.pos++
+		if s.peek(0) == '{' {
+			for s.pos < len(s.src) && s.src[s.pos] != '}' {
+				s.pos++
+			}
+			s.pos++
+			return
+		}
+		for n := 0; n < 4 && s.pos < len(s.src) && strings.IndexByte("0123456789abcdefABCDEF", s.src[s.pos]) >= 0; n++ {
+			s.pos++
+		}
+	case c == 'x':
+		s.pos++
+		for n := 0; n < 2 && s.pos < len(s.src) && strings.IndexByte("0123456789abcdefABCDEF", s.src[s.pos]) >= 0; n++ {
+			s.pos++
+		}
+	case c >= '0' && c <= '7':
+		for n := 0; n < 3 && s.pos < len(s.src) && s.src[s.pos] >= '0' && s.src[s.pos] <= '7'; n++ {
+			s.pos++
+		}
+	case (c == 'C' || c == 'M') && s.peek(1) == '-':
+		s.pos += 2
+		if s.peek(0) == '\\' {
+			s.pos++
+			s.skipEscape()
+		} else {
+			s.pos++
+		}
+	case c == 'c':
+		s.pos++
+		if s.peek(0) == '\\' {
+			s.pos++
+			s.skipEscape()
+		} else {
+			s.pos++
+		}
+	default:
+		_, size := utf8.DecodeRuneInString(s.src[s.pos:])
+		s.pos += size
+	}
+}
+
+func (s *ripperScanner) scanColon(spaceSeen bool) {
+	start := s.pos
+	next := s.peek(1)
+	if next == ':' {
+		s.pos += 2
+		if s.isBeg() || s.state&ripperExprClass != 0 || (s.isArg() && spaceSeen && !ripperIsSpace(s.peek(0))) {
+			s.state = ripperExprBeg
+		} else {
+			s.state = ripperExprDot
+		}
+		s.emit(start, "op")
+		return
+	}
+	if s.isEnd() || next == 0 || ripperIsSpace(next) || next == '#' {
+		s.pos++
+		s.state = ripperExprBeg
+		s.emit(start, "op")
+		return
+	}
+	if next == '"' || next == '\'' {
+		s.pos += 2
+		s.state = ripperExprFname
+		index := s.emit(start, "symbeg")
+		s.push(&ripperTerm{kind: ripperTermString, close: next, interpolate: next == '"', begin: index})
+		return
+	}
+	s.pos++
+	s.state = ripperExprFname
+	s.emit(start, "symbeg")
+}
+
+func (s *ripperScanner) percentLiteral() bool {
+	next := s.peek(1)
+	if next == 0 {
+		return false
+	}
+	if s.isBeg() {
+		return true
+	}
+	if next == '=' {
+		return false
+	}
+	return s.spaceArg(next) || (s.state&ripperExprFitem != 0 && next == 's')
+}
+
+func (s *ripperScanner) scanPercent() {
+	start := s.pos
+	s.pos++
+	kind := byte('Q')
+	if c := s.peek(0); (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		kind = c
+		s.pos++
+	}
+	open := s.peek(0)
+	if open == 0 {
+		s.emit(start, "op")
+		return
+	}
+	s.pos++
+	closer := open
+	switch open {
+	case '(':
+		closer = ')'
+	case '[':
+		closer = ']'
+	case '{':
+		closer = '}'
+	case '<':
+		closer = '>'
+	}
+	term := &ripperTerm{kind: ripperTermString, close: closer, interpolate: true}
+	if closer != open {
+		term.open = open
+	}
+	event := "tstring_beg"
+	switch kind {
+	case 'q':
+		term.interpolate = false
+	case 'w', 'i':
+		term.kind, term.interpolate = ripperTermWords, false
+		event = map[byte]string{'w': "qwords_beg", 'i': "qsymbols_beg"}[kind]
+	case 'W', 'I':
+		term.kind = ripperTermWords
+		event = map[byte]string{'W': "words_beg", 'I': "symbols_beg"}[kind]
+	case 'x':
+		event = "backtick"
+	case 'r':
+		term.kind = ripperTermRegexp
+		event = "regexp_beg"
+	case 's':
+		term.interpolate = false
+		event = "symbeg"
+		s.state = ripperExprFname | ripperExprFitem
+	}
+	term.begin = s.emit(start, event)
+	s.push(term)
+	if term.kind == ripperTermWords {
+		s.scanWordsSeparator()
+	}
+}
+
+func (s *ripperScanner) scanWordsSeparator() {
+	start := s.pos
+	for s.pos < len(s.src) && ripperIsSpace(s.src[s.pos]) {
+		s.pos++
+	}
+	if s.pos > start {
+		s.emit(start, "words_sep")
+	}
+}
+
+func (s *ripperScanner) heredocPossible(spaceSeen bool) bool {
+	return s.state&(ripperExprDot|ripperExprClass) == 0 && !s.isEnd() && (!s.isArg() || s.state&ripperExprLabeled != 0 || spaceSeen)
+}
+
+// scanHeredocStart reads `<<ID`, `<<~ID`, `<<-'ID'` and friends; the body is
+// scanned when the current line ends.
+func (s *ripperScanner) scanHeredocStart() bool {
+	start := s.pos
+	i := s.pos + 2
+	heredoc := &ripperHeredoc{interpolate: true}
+	if i < len(s.src) && (s.src[i] == '~' || s.src[i] == '-') {
+		heredoc.squiggly = s.src[i] == '~'
+		heredoc.indented = true
+		i++
+	}
+	if i >= len(s.src) {
+		return false
+	}
+	if quote := s.src[i]; quote == '"' || quote == '\'' || quote == '`' {
+		end := strings.IndexByte(s.src[i+1:], quote)
+		if end < 0 || strings.Contains(s.src[i+1:i+1+end], "\n") {
+			return false
+		}
+		heredoc.id = s.src[i+1 : i+1+end]
+		heredoc.interpolate = quote != '\''
+		i += end + 2
+	} else {
+		j := i
+		for j < len(s.src) && ripperIsIdentChar(s.src[j]) {
+			j++
+		}
+		if j == i {
+			return false
+		}
+		heredoc.id = s.src[i:j]
+		i = j
+	}
+	s.pos = i
+	s.state = ripperExprEnd
+	heredoc.begin = s.emit(start, "heredoc_beg")
+	s.pending = append(s.pending, heredoc)
+	return true
+}
+
+// startHeredocBody begins scanning a heredoc body at the current position.
+// The rest of its opening line has already been scanned, so code resumes
+// right after the terminator.
+func (s *ripperScanner) startHeredocBody(heredoc *ripperHeredoc) {
+	if heredoc.squiggly {
+		heredoc.indent = s.heredocIndent(heredoc)
+	}
+	s.tokens[heredoc.begin].Body = len(s.tokens)
+	s.push(&ripperTerm{kind: ripperTermHeredoc, interpolate: heredoc.interpolate, begin: heredoc.begin, heredoc: heredoc})
+}
+
+// heredocIndent computes the indentation removed from a squiggly heredoc:
+// the smallest leading whitespace width among its non-blank lines.
+func (s *ripperScanner) heredocIndent(heredoc *ripperHeredoc) int {
+	indent := -1
+	for i := s.pos; i < len(s.src); {
+		length := s.lineLength(i)
+		line := s.src[i : i+length]
+		i += length
+		if s.isHeredocTerminator(heredoc, line) {
+			break
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		width := 0
+		for _, c := range line {
+			if c == ' ' {
+				width++
+			} else if c == '\t' {
+				width = (width/8 + 1) * 8
+			} else {
+				break
+			}
+		}
+		if indent < 0 || width < indent {
+			indent = width
+		}
+	}
+	if indent < 0 {
+		return 0
+	}
+	return indent
+}
+
+func (s *ripperScanner) isHeredocTerminator(heredoc *ripperHeredoc, line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	if heredoc.indented {
+		line = strings.TrimLeft(line, " \t")
+	}
+	return line == heredoc.id
+}
+
+func (s *ripperScanner) scanHeredocBody(term *ripperTerm) {
+	heredoc := term.heredoc
+	start := s.pos
+	flush := func() {
+		if s.pos > start {
+			s.emit(start, "tstring_content")
+		}
+	}
+	for s.pos < len(s.src) {
+		if s.atLineStart() {
+			length := s.lineLength(s.pos)
+			if s.isHeredocTerminator(heredoc, s.src[s.pos:s.pos+length]) {
+				flush()
+				endStart := s.pos
+				s.pos += length
+				s.pop()
+				s.tokens[term.begin].Pair = s.emit(endStart, "heredoc_end")
+				s.state = ripperExprEnd
+				if len(s.pending) > 0 {
+					next := s.pending[0]
+					s.pending = s.pending[1:]
+					s.startHeredocBody(next)
+				}
+				return
+			}
+			if heredoc.squiggly && heredoc.indent > 0 {
+				flush()
+				indentStart, width := s.pos, 0
+				for s.pos < len(s.src) && width < heredoc.indent && (s.src[s.pos] == ' ' || s.src[s.pos] == '\t') {
+					if s.src[s.pos] == '\t' {
+						width = (width/8 + 1) * 8
+					} else {
+						width++
+					}
+					s.pos++
+				}
+				if s.pos > indentStart {
+					s.emit(indentStart, "ignored_sp")
+				}
+				start = s.pos
+				continue
+			}
+		}
+		c := s.src[s.pos]
+		if heredoc.interpolate && c == '\\' && s.pos+1 < len(s.src) {
+			s.pos += 2
+			continue
+		}
+		if heredoc.interpolate && c == '#' && s.scanInterpolation(flush) {
+			return
+		}
+		s.pos++
+		if c == '\n' && heredoc.squiggly {
+			flush()
+			start = s.pos
+		}
+	}
+	flush()
+	s.pop()
+}
+
+// scanInterpolation handles "#{", "#@ivar" and "#$gvar" inside a literal.
+// flush emits the content scanned so far.
+func (s *ripperScanner) scanInterpolation(flush func()) bool {
+	next := s.peek(1)
+	switch {
+	case next == '{':
+		flush()
+		start := s.pos
+		s.pos += 2
+		s.state = ripperExprBeg
+		s.commandStart = true
+		index := s.emit(start, "embexpr_beg")
+		s.push(&ripperTerm{kind: ripperTermEmbexpr, begin: index})
+		return true
+	case next == '@' && (ripperIsIdentStart(s.peek(2)) || (s.peek(2) == '@' && ripperIsIdentStart(s.peek(3)))),
+		next == '$' && (ripperIsIdentStart(s.peek(2)) || strings.IndexByte("~*$?!@/\\;,.=:<>\"&`'+0123456789", s.peek(2)) >= 0):
+		flush()
+		start := s.pos
+		s.pos++
+		s.state = ripperExprBeg
+		s.emit(start, "embvar")
+		if next == '$' {
+			s.scanGlobal()
+		} else {
+			varStart := s.pos
+			s.pos++
+			event := "ivar"
+			if s.peek(0) == '@' {
+				s.pos++
+				event = "cvar"
+			}
+			for s.pos < len(s.src) && ripperIsIdentChar(s.src[s.pos]) {
+				s.pos++
+			}
+			s.emit(varStart, event)
+		}
+		return true
+	}
+	return false
+}
+
+func (s *ripperScanner) scanStringContent(term *ripperTerm) {
+	start := s.pos
+	flush := func() {
+		if s.pos > start {
+			s.emit(start, "tstring_content")
+		}
+	}
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		if term.kind == ripperTermWords && ripperIsSpace(c) && term.nest == 0 {
+			flush()
+			s.scanWordsSeparator()
+			return
+		}
+		if c == '\\' && s.pos+1 < len(s.src) {
+			_, size := utf8.DecodeRuneInString(s.src[s.pos+1:])
+			s.pos += 1 + size
+			continue
+		}
+		if term.open != 0 && c == term.open {
+			term.nest++
+			s.pos++
+			continue
+		}
+		if c == term.close {
+			if term.nest > 0 {
+				term.nest--
+				s.pos++
+				continue
+			}
+			flush()
+			s.closeString(term)
+			return
+		}
+		if term.interpolate && c == '#' && s.scanInterpolation(flush) {
+			return
+		}
+		_, size := utf8.DecodeRuneInString(s.src[s.pos:])
+		s.pos += size
+	}
+	flush()
+	s.pop()
+}
+
+func (s *ripperScanner) closeString(term *ripperTerm) {
+	start := s.pos
+	s.pos++
+	s.pop()
+	event := "tstring_end"
+	s.state = ripperExprEnd
+	switch {
+	case term.kind == ripperTermRegexp:
+		for s.pos < len(s.src) && strings.IndexByte("imxounse", s.src[s.pos]) >= 0 {
+			s.pos++
+		}
+		event = "regexp_end"
+	case term.labelable && s.peek(0) == ':' && s.peek(1) != ':':
+		s.pos++
+		event = "label_end"
+		s.state = ripperExprArg | ripperExprLabeled
+	}
+	s.tokens[term.begin].Pair = s.emit(start, event)
+}
+
+func (s *ripperScanner) scanOperator() {
+	start := s.pos
+	rest := s.src[s.pos:]
+	if s.afterOperator() {
+		for _, name := range []string{"[]=", "[]", "+@", "-@", "!@", "~@"} {
+			if strings.HasPrefix(rest, name) {
+				s.pos += len(name)
+				s.state = ripperExprArg
+				s.emit(start, "op")
+				return
+			}
+		}
+	}
+	op := ""
+	for _, candidate := range ripperOperators {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		_, size := utf8.DecodeRuneInString(rest)
+		s.pos += size
+		s.emit(start, "op")
+		return
+	}
+	s.pos += len(op)
+	event := "op"
+	switch op {
+	case ",":
+		event = "comma"
+		s.state = ripperExprBeg | ripperExprLabel
+	case ";":
+		event = "semicolon"
+		s.state = ripperExprBeg
+		s.commandStart = true
+		s.paramLine = false
+	case ".":
+		event = "period"
+		s.state = ripperExprDot
+		if s.afterDefSingleton() {
+			s.state = ripperExprFname
+		}
+	case "&.":
+		s.state = ripperExprDot
+	case "(":
+		event = "lparen"
+		s.parenDepth++
+		s.state = ripperExprBeg | ripperExprLabel
+	case ")":
+		event = "rparen"
+		if s.paramDepth > 0 && s.paramDepth == s.parenDepth {
+			s.paramDepth = 0
+		}
+		s.parenDepth--
+		s.state = ripperExprEndFn
+	case "[":
+		event = "lbracket"
+		s.parenDepth++
+		s.state = ripperExprBeg | ripperExprLabel
+	case "]":
+		event = "rbracket"
+		s.parenDepth--
+		s.state = ripperExprEnd
+	case "{":
+		s.scanLeftBrace(start)
+		return
+	case "}":
+		if top := s.top(); top != nil && top.kind == ripperTermEmbexpr {
+			if top.nest == 0 {
+				s.pop()
+				s.state = ripperExprEnd
+				s.tokens[top.begin].Pair = s.emit(start, "embexpr_end")
+				return
+			}
+			top.nest--
+		}
+		event = "rbrace"
+		s.parenDepth--
+		s.state = ripperExprEnd
+	case "->":
+		event = "tlambda"
+		s.lambdaDepths = append(s.lambdaDepths, s.parenDepth)
+		s.state = ripperExprEndFn
+	case "|":
+		if s.paramBlock {
+			s.paramBlock = false
+			s.state = ripperExprBeg | ripperExprLabel
+			s.commandStart = true
+		} else if prev := s.previousEvent(); prev == "lbrace" || (prev == "kw" && s.previousText() == "do") {
+			s.paramBlock = true
+			s.state = ripperExprBeg | ripperExprLabel
+		} else {
+			s.state = s.operatorState()
+			if !s.afterOperator() {
+				s.state |= ripperExprLabel
+			}
+		}
+	case "::", "?", ":", "=>", "..", "...", "&&", "||", "&&=", "||=":
+		s.state = ripperExprBeg
+	case "=":
+		s.noteAssignment()
+		s.paramLine = false
+		s.state = ripperExprBeg
+	case "+=", "-=", "*=", "/=", "%=", "**=", "|=", "&=", "^=", "<<=", ">>=":
+		s.noteAssignment()
+		s.state = ripperExprBeg
+	default:
+		s.state = s.operatorState()
+	}
+	s.emit(start, event)
+}
+
+func (s *ripperScanner) scanLeftBrace(start int) {
+	if top := s.top(); top != nil && top.kind == ripperTermEmbexpr {
+		top.nest++
+	}
+	if n := len(s.lambdaDepths); n > 0 && s.lambdaDepths[n-1] == s.parenDepth {
+		s.lambdaDepths = s.lambdaDepths[:n-1]
+		s.parenDepth++
+		s.state = ripperExprBeg
+		s.commandStart = true
+		s.emit(start, "tlambeg")
+		return
+	}
+	s.parenDepth++
+	switch {
+	case s.state&ripperExprLabeled != 0:
+		s.state = ripperExprBeg | ripperExprLabel
+	case s.state&(ripperExprArgAny|ripperExprEnd|ripperExprEndFn|ripperExprEndArg) != 0:
+		s.state = ripperExprBeg
+		s.commandStart = true
+	default:
+		s.state = ripperExprBeg | ripperExprLabel
+	}
+	s.emit(start, "lbrace")
+}
+
+// noteAssignment records the identifiers on the left of an assignment as
+// local variables, which changes how later occurrences are lexed (`x /2/`
+// divides when x is a local).
+func (s *ripperScanner) noteAssignment() {
+	for i := len(s.tokens) - 1; i >= 0; i-- {
+		token := s.tokens[i]
+		switch token.Event {
+		case "sp", "comma", "lparen", "rparen":
+			continue
+		case "op":
+			if token.Text == "*" {
+				continue
+			}
+			return
+		case "ident":
+			if i > 0 && (s.tokens[i-1].Event == "period" || s.tokens[i-1].Text == "&." || s.tokens[i-1].Text == "::") {
+				return
+			}
+			s.locals[token.Text] = true
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,1767 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// astNode is the state behind a RubyVM::AbstractSyntaxTree::Node. Children
+// are either *astNode or plain Ruby values (symbols, strings, integers).
+type astNode struct {
+	kind     string
+	children []any
+	first    astPosition
+	last     astPosition
+	id       int
+	lines    []string
+	value    *object.EmeraldValue
+	values   *object.EmeraldValue
+}
+
+type astPosition struct {
+	line, column int
+}
+
+func (p astPosition) before(other astPosition) bool {
+	return p.line < other.line || p.line == other.line && p.column < other.column
+}
+
+type astScope struct {
+	names []any
+	seen  map[string]bool
+	hard  bool
+	outer *astScope
+}
+
+// astTree is the result of converting one source: the root SCOPE plus the
+// SCOPE built for each def, block and lambda, which RubyVM::AbstractSyntaxTree.of
+// looks methods and procs up by.
+type astTree struct {
+	root   *astNode
+	scopes []astScopeRef
+}
+
+type astScopeRef struct {
+	scope  *astNode
+	method bool
+}
+
+// astBuilder converts pkg/parser/ast into MRI's node shapes. Positions come
+// from the Ripper walker, which records the tokens each parser node claims.
+type astBuilder struct {
+	tokens []ripperToken
+	spans  map[any][2]int
+	embeds map[int]*ast.Program
+	source string
+	lines  []string
+	scope  *astScope
+	nextID int
+	scopes []astScopeRef
+}
+
+// astParse parses source into MRI's node tree; a parse error comes back as
+// a SyntaxError value.
+func astParse(source, path string, keepLines bool) (*astTree, *object.EmeraldValue) {
+	tokens, endSeen := ripperScan(source, 1)
+	parsed := source
+	if endSeen {
+		for _, token := range tokens {
+			if token.Event == "__end__" {
+				parsed = source[:token.Offset]
+				break
+			}
+		}
+	}
+	p := parser.New(lexer.New(parsed))
+	if path != "" {
+		p.SetFile(path)
+	}
+	program := p.ParseProgram()
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		return nil, newRuntimeException(R.Classes["SyntaxError"], diagnostics[0].Report(1))
+	}
+
+	data := &ripperData{source: source, lineno: 1, tokens: tokens, endSeen: endSeen}
+	walker := newRipperDispatcher(nil, data)
+	walker.spans = make(map[any][2]int)
+	walker.embeds = make(map[int]*ast.Program)
+	walker.program(program)
+
+	b := &astBuilder{tokens: tokens, spans: walker.spans, embeds: walker.embeds, source: source}
+	if keepLines {
+		b.lines = strings.SplitAfter(source, "\n")
+	}
+	root := b.program(program)
+	return &astTree{root: root, scopes: b.scopes}, nil
+}
+
+func (b *astBuilder) node(kind string, key any, children ...any) *astNode {
+	b.nextID++
+	n := &astNode{kind: kind, children: children, id: b.nextID, lines: b.lines}
+	if span, ok := b.spans[key]; ok && key != nil {
+		n.first, n.last = b.tokenStart(span[0]), b.tokenEnd(span[1])
+		return n
+	}
+	b.cover(n)
+	return n
+}
+
+// cover positions n over its child nodes, for nodes with no tokens of
+// their own such as LIST and BLOCK.
+func (b *astBuilder) cover(n *astNode) {
+	found := false
+	for _, child := range n.children {
+		c, ok := child.(*astNode)
+		if !ok || c == nil || c.first.line == 0 {
+			continue
+		}
+		if !found || c.first.before(n.first) {
+			n.first = c.first
+		}
+		if !found || n.last.before(c.last) {
+			n.last = c.last
+		}
+		found = true
+	}
+}
+
+func (b *astBuilder) spanOf(n *astNode, key any) *astNode {
+	if span, ok := b.spans[key]; ok {
+		n.first, n.last = b.tokenStart(span[0]), b.tokenEnd(span[1])
+	}
+	return n
+}
+
+func (b *astBuilder) tokenStart(index int) astPosition {
+	token := b.tokens[index]
+	return astPosition{token.Line, token.Column}
+}
+
+func (b *astBuilder) tokenEnd(index int) astPosition {
+	token := b.tokens[index]
+	if newlines := strings.Count(token.Text, "\n"); newlines > 0 {
+		return astPosition{token.Line + newlines, len(token.Text) - strings.LastIndex(token.Text, "\n") - 1}
+	}
+	return astPosition{token.Line, token.Column + len(token.Text)}
+}
+
+// significant returns the index of the last token before limit that is not
+// layout, or -1.
+func (b *astBuilder) significant(limit int) int {
+	for i := limit - 1; i >= 0; i-- {
+		if !ripperInsignificant(b.tokens[i].Event) && b.tokens[i].Event != "comment" && b.tokens[i].Event != "embdoc" {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *astBuilder) pushScope(hard bool) {
+	b.scope = &astScope{seen: map[string]bool{}, hard: hard, outer: b.scope}
+}
+
+func (b *astBuilder) popScope() []any {
+	names := b.scope.names
+	b.scope = b.scope.outer
+	if names == nil {
+		names = []any{}
+	}
+	return names
+}
+
+func (b *astBuilder) declare(name string) {
+	if name == "" || b.scope == nil || b.lookup(name) != nil {
+		return
+	}
+	b.scope.seen[name] = true
+	if strings.HasPrefix(name, "__rgo_") {
+		b.scope.names = append(b.scope.names, nil)
+		return
+	}
+	b.scope.names = append(b.scope.names, rubySymbol(name))
+}
+
+// lookup finds the scope that defines a local, stopping at the enclosing
+// def, class or program.
+func (b *astBuilder) lookup(name string) *astScope {
+	for scope := b.scope; scope != nil; scope = scope.outer {
+		if scope.seen[name] {
+			return scope
+		}
+		if scope.hard {
+			break
+		}
+	}
+	return nil
+}
+
+// local returns the node kind for reading name: MRI tells locals of the
+// method body (LVAR) from those of blocks (DVAR).
+func (b *astBuilder) local(name string) string {
+	scope := b.lookup(name)
+	switch {
+	case scope == nil:
+		return ""
+	case scope.hard:
+		return "LVAR"
+	}
+	return "DVAR"
+}
+
+func (b *astBuilder) assignKind(name string) string {
+	switch {
+	case strings.HasPrefix(name, "@@"):
+		return "CVASGN"
+	case strings.HasPrefix(name, "@"):
+		return "IASGN"
+	case strings.HasPrefix(name, "$"):
+		return "GASGN"
+	case name != "" && name[0] >= 'A' && name[0] <= 'Z':
+		return "CDECL"
+	}
+	b.declare(name)
+	if b.local(name) == "DVAR" {
+		return "DASGN"
+	}
+	return "LASGN"
+}
+
+func (b *astBuilder) program(program *ast.Program) *astNode {
+	b.pushScope(true)
+	body := b.stmts(program.Statements)
+	scope := b.node("SCOPE", nil, b.popScope(), nil, body)
+	scope.first = astPosition{1, 0}
+	scope.last = scope.first
+	if last := b.significant(len(b.tokens)); last >= 0 && b.tokens[last].Event != "__end__" {
+		scope.last = b.tokenEnd(last)
+	}
+	return scope
+}
+
+func (b *astBuilder) stmts(statements []ast.Statement) *astNode {
+	var nodes []any
+	for _, statement := range statements {
+		if node := b.statement(statement); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	switch len(nodes) {
+	case 0:
+		return nil
+	case 1:
+		return nodes[0].(*astNode)
+	}
+	return b.node("BLOCK", nil, nodes...)
+}
+
+func (b *astBuilder) body(block *ast.BlockExpression) *astNode {
+	if block == nil {
+		return nil
+	}
+	return b.stmts(block.Statements)
+}
+
+func (b *astBuilder) statement(statement ast.Statement) *astNode {
+	switch n := statement.(type) {
+	case *ast.ExpressionStatement:
+		return b.expr(n.Expression)
+	case ast.Expression:
+		return b.expr(n)
+	}
+	return nil
+}
+
+func (b *astBuilder) sym(name string) *object.EmeraldValue {
+	return rubySymbol(name)
+}
+
+func (b *astBuilder) expr(node ast.Expression) *astNode {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *ast.Identifier:
+		return b.variable(n.Value, n)
+	case *ast.Boolean:
+		if n.Value {
+			return b.node("TRUE", n)
+		}
+		return b.node("FALSE", n)
+	case *ast.NilExpression:
+		return b.node("NIL", n)
+	case *ast.SelfExpression:
+		return b.node("SELF", n)
+	case *ast.IntegerLiteral:
+		if n.Token.Literal == "__LINE__" {
+			return b.node("LINE", n)
+		}
+		return b.node("INTEGER", n, newInt(n.Value))
+	case *ast.FloatLiteral:
+		return b.node("FLOAT", n, newFloat(n.Value))
+	case *ast.RationalLiteral:
+		return b.node("RATIONAL", n, b.literal(n))
+	case *ast.ImaginaryLiteral:
+		return b.node("IMAGINARY", n, b.literal(n))
+	case *ast.StringLiteral:
+		return b.stringLiteral(n, []*ast.StringLiteral{n})
+	case *ast.StringConcatExpression:
+		return b.stringLiteral(n, n.Parts)
+	case *ast.SymbolLiteral:
+		if pieces := b.pieces(n); pieces != nil {
+			return b.interpolated("DSYM", n, pieces)
+		}
+		return b.node("SYM", n, b.sym(strings.TrimPrefix(n.Value, ":")))
+	case *ast.RegexpLiteral:
+		if pieces := b.pieces(n); pieces != nil && n.Interpolates {
+			return b.interpolated("DREGX", n, pieces)
+		}
+		return b.node("REGX", n, b.literal(n))
+	case *ast.ArrayLiteral:
+		if len(n.Elements) == 0 {
+			return b.node("ZLIST", n)
+		}
+		return b.spanOf(b.list(n.Elements, nil), n)
+	case *ast.HashLiteral:
+		var items []any
+		for _, key := range n.Order {
+			if splat, ok := key.(*ast.SplatExpression); ok && splat.Token.Literal == "**" {
+				items = append(items, nil, b.expr(splat.Value))
+				continue
+			}
+			items = append(items, b.expr(key), b.expr(n.Pairs[key]))
+		}
+		if len(items) == 0 {
+			return b.node("HASH", n, nil)
+		}
+		return b.node("HASH", n, b.node("LIST", nil, append(items, nil)...))
+	case *ast.IndexExpression:
+		index := []ast.Expression{n.Index}
+		if n.End != nil {
+			index = append(index, n.End)
+		}
+		if n.Index == nil {
+			index = nil
+		}
+		return b.node("CALL", n, b.expr(n.Left), b.sym("[]"), b.args(index, nil))
+	case *ast.PrefixExpression:
+		return b.prefix(n)
+	case *ast.InfixExpression:
+		return b.infix(n)
+	case *ast.TernaryExpression:
+		return b.node("IF", n, b.expr(n.Condition), b.expr(n.Consequent), b.expr(n.Alternative))
+	case *ast.RangeExpression:
+		kind := "DOT2"
+		if n.Exclusive {
+			kind = "DOT3"
+		}
+		var left, right *astNode
+		if !n.StartMissing {
+			left = b.expr(n.Left)
+		}
+		if !n.EndMissing {
+			right = b.expr(n.Right)
+		}
+		return b.node(kind, n, left, right)
+	case *ast.IfExpression:
+		return b.ifExpression(n)
+	case *ast.CaseExpression:
+		return b.caseExpression(n)
+	case *ast.PatternMatchExpression:
+		subject := b.expr(n.Left)
+		b.declarePattern(n.Pattern)
+		var clause *astNode
+		if n.Token.Literal == "in" {
+			clause = b.node("IN", n, b.guard(n), b.node("TRUE", nil), b.node("FALSE", nil))
+		} else {
+			clause = b.node("IN", n, b.guard(n), nil, nil)
+		}
+		return b.node("CASE3", n, subject, clause)
+	case *ast.WhileExpression:
+		return b.node("WHILE", n, b.expr(n.Condition), b.body(n.Body), boolValue(!n.Post))
+	case *ast.UntilExpression:
+		return b.node("UNTIL", n, b.expr(n.Condition), b.body(n.Body), boolValue(!n.Post))
+	case *ast.ForExpression:
+		collection := b.expr(n.Collection)
+		var variable *astNode
+		if len(n.Variable) == 1 {
+			variable = b.target(n.Variable[0])
+		} else {
+			variable = b.node("MASGN", nil, nil, b.list(n.Variable, nil), nil)
+		}
+		body := b.body(n.Body)
+		if body != nil {
+			body = b.node("BLOCK", nil, variable, body)
+		} else {
+			body = variable
+		}
+		return b.node("FOR", n, collection, b.node("SCOPE", n, []any{}, nil, body))
+	case *ast.DefExpression:
+		return b.def(n)
+	case *ast.ClassExpression:
+		if n.SingletonReceiver != nil {
+			receiver := b.expr(n.SingletonReceiver)
+			return b.node("SCLASS", n, receiver, b.definitionScope(n, n.Body))
+		}
+		path := b.constPath(n, n.Name.Value, n.Absolute)
+		return b.node("CLASS", n, path, b.expr(n.SuperClass), b.definitionScope(n, n.Body))
+	case *ast.ModuleExpression:
+		path := b.constPath(n, n.Name.Value, n.Absolute)
+		return b.node("MODULE", n, path, b.definitionScope(n, n.Body))
+	case *ast.ReturnExpression:
+		return b.node("RETURN", n, b.expr(n.ReturnValue))
+	case *ast.BreakExpression:
+		return b.node("BREAK", n, b.expr(n.Value))
+	case *ast.NextExpression:
+		return b.node("NEXT", n, b.expr(n.Value))
+	case *ast.RedoExpression:
+		return b.node("REDO", n)
+	case *ast.RetryExpression:
+		return b.node("RETRY", n)
+	case *ast.YieldExpression:
+		return b.node("YIELD", n, b.args(n.Args, n.KeywordArgs))
+	case *ast.SuperExpression:
+		var call *astNode
+		if n.ImplicitArgs && len(n.Args) == 0 && len(n.KeywordArgs) == 0 {
+			call = b.node("ZSUPER", n)
+		} else {
+			call = b.node("SUPER", n, b.args(n.Args, n.KeywordArgs))
+		}
+		return b.iter(n, call, n.Block)
+	case *ast.InstanceVariable:
+		return b.node("IVAR", n, b.sym(n.Name))
+	case *ast.ClassVariable:
+		return b.node("CVAR", n, b.sym(n.Name))
+	case *ast.GlobalVariable:
+		return b.globalVariable(n.Name, n)
+	case *ast.Constant:
+		return b.node("CONST", n, b.sym(n.Name))
+	case *ast.ConstantResolution:
+		if n.Left == nil {
+			return b.node("COLON3", n, b.sym(n.Name.Value))
+		}
+		left := b.expr(n.Left)
+		if name := n.Name.Value; name != "" && !(name[0] >= 'A' && name[0] <= 'Z') {
+			return b.node("CALL", n, left, b.sym(name), nil)
+		}
+		return b.node("COLON2", n, left, b.sym(n.Name.Value))
+	case *ast.AssignExpression:
+		return b.assign(n)
+	case *ast.MultiAssignExpression:
+		targets := n.Targets
+		if len(targets) == 0 {
+			for _, name := range n.Names {
+				targets = append(targets, name)
+			}
+		}
+		var value *astNode
+		switch {
+		case len(n.Values) == 1:
+			value = b.expr(n.Values[0])
+		case len(n.Values) > 1:
+			value = b.list(n.Values, nil)
+		}
+		return b.multiAssign(n, value, targets)
+	case *ast.InstanceVarAssign:
+		return b.variableAssign(n, n.Name, n.Token.Literal, n.Value)
+	case *ast.ClassVarAssign:
+		return b.variableAssign(n, n.Name, n.Token.Literal, n.Value)
+	case *ast.GlobalVarAssign:
+		return b.variableAssign(n, n.Name, n.Token.Literal, n.Value)
+	case *ast.MethodCall:
+		return b.methodCall(n)
+	case *ast.UndefExpression:
+		names := make([]any, 0, len(n.Methods))
+		for _, method := range n.Methods {
+			names = append(names, b.node("SYM", method, b.sym(method.Value)))
+		}
+		undef := b.node("UNDEF", n, names...)
+		return undef
+	case *ast.AliasExpression:
+		if _, ok := n.New.(*ast.GlobalVariable); ok {
+			return b.node("VALIAS", n, b.sym(n.New.String()), b.sym(n.Old.String()))
+		}
+		newName := b.node("SYM", ripperSpanKey{n, "new"}, b.sym(strings.TrimPrefix(n.New.String(), ":")))
+		return b.node("ALIAS", n, newName, b.node("SYM", ripperSpanKey{n, "old"}, b.sym(strings.TrimPrefix(n.Old.String(), ":"))))
+	case *ast.BeginExpression:
+		return b.begin(n)
+	case *ast.RaiseExpression:
+		var args []ast.Expression
+		for _, arg := range []ast.Expression{n.Error, n.Message, n.Backtrace, n.Keyword} {
+			if arg != nil {
+				args = append(args, arg)
+			}
+		}
+		return b.command(n, "raise", args, nil)
+	case *ast.CatchExpression:
+		var args []ast.Expression
+		if n.Label != nil {
+			args = append(args, n.Label)
+		}
+		if n.BlockPass != nil {
+			args = append(args, n.BlockPass)
+		}
+		var block *ast.BlockExpression
+		if n.HasBlock {
+			block = n.Body
+		}
+		return b.command(n, "catch", args, block)
+	case *ast.ThrowExpression:
+		args := []ast.Expression{n.Label}
+		if n.Value != nil {
+			args = append(args, n.Value)
+		}
+		return b.command(n, "throw", append(args, n.ExtraArgs...), nil)
+	case *ast.IncludeExpression:
+		return b.command(n, "include", []ast.Expression{n.Module}, nil)
+	case *ast.ExtendExpression:
+		return b.command(n, "extend", []ast.Expression{n.Module}, nil)
+	case *ast.PrependExpression:
+		return b.command(n, "prepend", []ast.Expression{n.Module}, nil)
+	case *ast.DefinedExpression:
+		return b.node("DEFINED", n, b.expr(n.Expression))
+	case *ast.ProcLiteral:
+		b.pushScope(false)
+		params := b.params(n, astParams{
+			params: n.Params, defaults: n.ParamDefaults, rest: n.RestParam, restIndex: n.RestParamIndex,
+			keywords: n.KeywordParams, keywordRest: n.KeywordRestParam, rejectKeywords: n.RejectKeywords, block: n.BlockParam,
+		})
+		if span, ok := b.spans[n]; ok && params.first.line == 0 {
+			params.first = b.tokenEnd(span[0])
+			params.last = params.first
+		}
+		body := b.body(n.Body)
+		scope := b.node("SCOPE", n, b.popScope(), params, body)
+		b.scopes = append(b.scopes, astScopeRef{scope: scope})
+		return b.node("LAMBDA", n, scope)
+	case *ast.SplatExpression:
+		if n.Token.Literal == "&" {
+			return b.node("BLOCK_PASS", n, nil, b.expr(n.Value))
+		}
+		return b.node("SPLAT", n, b.expr(n.Value))
+	case *ast.BlockExpression:
+		return b.body(n)
+	}
+	return nil
+}
+
+// literal evaluates the source of a literal whose value has no Go form in
+// the parser (rationals, imaginaries, regexps).
+func (b *astBuilder) literal(key any) *object.EmeraldValue {
+	span, ok := b.spans[key]
+	if !ok || EvalSource == nil {
+		return R.NilVal
+	}
+	start, end := b.tokens[span[0]].Offset, b.tokens[span[1]].Offset+len(b.tokens[span[1]].Text)
+	value := EvalSource(b.source[start:end])
+	if value == nil || value.Type == object.ValueException {
+		return R.NilVal
+	}
+	return value
+}
+
+func (b *astBuilder) variable(name string, key any) *astNode {
+	switch {
+	case strings.HasPrefix(name, "@@"):
+		return b.node("CVAR", key, b.sym(name))
+	case strings.HasPrefix(name, "@"):
+		return b.node("IVAR", key, b.sym(name))
+	case strings.HasPrefix(name, "$"):
+		return b.globalVariable(name, key)
+	case name != "" && name[0] >= 'A' && name[0] <= 'Z':
+		return b.node("CONST", key, b.sym(name))
+	}
+	if kind := b.local(name); kind != "" {
+		return b.node(kind, key, b.sym(name))
+	}
+	return b.node("VCALL", key, b.sym(name))
+}
+
+func (b *astBuilder) globalVariable(name string, key any) *astNode {
+	switch {
+	case len(name) > 1 && name[1] >= '1' && name[1] <= '9':
+		return b.node("NTH_REF", key, b.sym(name))
+	case name == "$&" || name == "$`" || name == "$'" || name == "$+":
+		return b.node("BACK_REF", key, b.sym(name))
+	}
+	return b.node("GVAR", key, b.sym(name))
+}
+
+// list builds the argument shape MRI uses for literals and calls: a LIST,
+// or SPLAT/ARGSCAT/ARGSPUSH once a splat appears. extra is appended as the
+// last element, as keyword arguments are.
+func (b *astBuilder) list(elements []ast.Expression, extra *astNode) *astNode {
+	var acc *astNode
+	var pending []any
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		list := b.node("LIST", nil, append(pending, nil)...)
+		if acc == nil {
+			acc = list
+		} else {
+			acc = b.node("ARGSCAT", nil, acc, list)
+		}
+		pending = nil
+	}
+	for _, element := range elements {
+		splat, ok := element.(*ast.SplatExpression)
+		switch {
+		case ok && splat.Token.Literal == "*" && acc == nil && len(pending) == 0:
+			acc = b.node("SPLAT", splat, b.expr(splat.Value))
+		case ok && splat.Token.Literal == "*":
+			flush()
+			acc = b.node("ARGSCAT", nil, acc, b.expr(splat.Value))
+		case acc != nil:
+			acc = b.node("ARGSPUSH", nil, acc, b.expr(element))
+		default:
+			pending = append(pending, b.expr(element))
+		}
+	}
+	if extra != nil {
+		if acc != nil && len(pending) == 0 {
+			acc = b.node("ARGSPUSH", nil, acc, extra)
+		} else {
+			pending = append(pending, extra)
+		}
+	}
+	flush()
+	return acc
+}
+
+// args converts call arguments, folding keyword arguments into a trailing
+// HASH and a block argument into BLOCK_PASS.
+func (b *astBuilder) args(args []ast.Expression, keywordArgs []*ast.KeywordArg) *astNode {
+	var positional []ast.Expression
+	var blockArg *ast.SplatExpression
+	var pairs []any
+	for _, arg := range args {
+		if splat, ok := arg.(*ast.SplatExpression); ok {
+			switch splat.Token.Literal {
+			case "&":
+				blockArg = splat
+				continue
+			case "**":
+				pairs = append(pairs, nil, b.expr(splat.Value))
+				continue
+			}
+		}
+		positional = append(positional, arg)
+	}
+	for _, arg := range keywordArgs {
+		key := b.node("SYM", ripperSpanKey{arg, "key"}, b.sym(arg.Name))
+		value := b.expr(arg.Value)
+		if value == nil {
+			value = b.variable(arg.Name, nil)
+		}
+		pairs = append(pairs, key, value)
+	}
+	var keywords *astNode
+	if len(pairs) > 0 {
+		keywords = b.node("HASH", nil, b.node("LIST", nil, append(pairs, nil)...))
+	}
+	list := b.list(positional, keywords)
+	if blockArg != nil {
+		return b.node("BLOCK_PASS", blockArg, list, b.expr(blockArg.Value))
+	}
+	return list
+}
+
+func (b *astBuilder) prefix(n *ast.PrefixExpression) *astNode {
+	operator := n.Operator
+	switch operator {
+	case "not":
+		operator = "!"
+	case "-", "+":
+		switch right := n.Right.(type) {
+		case *ast.IntegerLiteral:
+			if operator == "-" {
+				return b.node("INTEGER", n, newInt(-right.Value))
+			}
+		case *ast.FloatLiteral:
+			if operator == "-" {
+				return b.node("FLOAT", n, newFloat(-right.Value))
+			}
+		}
+		operator += "@"
+	}
+	return b.node("OPCALL", n, b.expr(n.Right), b.sym(operator), nil)
+}
+
+func (b *astBuilder) infix(n *ast.InfixExpression) *astNode {
+	switch n.Operator {
+	case "&&", "and":
+		return b.logical("AND", n)
+	case "||", "or":
+		return b.logical("OR", n)
+	case "=~":
+		if _, ok := n.Left.(*ast.RegexpLiteral); ok {
+			return b.node("MATCH2", n, b.expr(n.Left), b.expr(n.Right))
+		}
+		if _, ok := n.Right.(*ast.RegexpLiteral); ok {
+			left := b.expr(n.Left)
+			return b.node("MATCH3", n, b.expr(n.Right), left)
+		}
+	}
+	left := b.expr(n.Left)
+	return b.node("OPCALL", n, left, b.sym(n.Operator), b.list([]ast.Expression{n.Right}, nil))
+}
+
+// logical builds AND/OR, nesting a chain to the right the way MRI does.
+func (b *astBuilder) logical(kind string, n *ast.InfixExpression) *astNode {
+	left := b.expr(n.Left)
+	right := b.expr(n.Right)
+	node := b.node(kind, n, left, right)
+	if left == nil || left.kind != kind {
+		return node
+	}
+	tail := left
+	for {
+		next, ok := tail.children[1].(*astNode)
+		if !ok || next == nil || next.kind != kind {
+			break
+		}
+		tail = next
+	}
+	joined := b.node(kind, nil, tail.children[1], right)
+	tail.children[1] = joined
+	for walk := left; ; {
+		if right != nil && walk.last.before(right.last) {
+			walk.last = right.last
+		}
+		next, ok := walk.children[1].(*astNode)
+		if !ok || walk == tail {
+			break
+		}
+		walk = next
+	}
+	return left
+}
+
+func (b *astBuilder) stringLiteral(key any, parts []*ast.StringLiteral) *astNode {
+	command := len(parts) == 1 && parts[0].Command
+	interpolates := false
+	for _, part := range parts {
+		interpolates = interpolates || part.Interpolates
+	}
+	if interpolates {
+		if pieces := b.pieces(key); pieces != nil {
+			if command {
+				return b.interpolated("DXSTR", key, pieces)
+			}
+			return b.interpolated("DSTR", key, pieces)
+		}
+	}
+	var value strings.Builder
+	for _, part := range parts {
+		value.WriteString(part.Value)
+	}
+	if command {
+		return b.node("XSTR", key, rubyString(value.String()))
+	}
+	return b.node("STR", key, rubyString(value.String()))
+}
+
+// pieces splits an interpolated literal into its text and #{} parts from
+// the scanner tokens; nil means the literal has no interpolation.
+func (b *astBuilder) pieces(key any) []any {
+	span, ok := b.spans[key]
+	if !ok {
+		return nil
+	}
+	var pieces []any
+	var text strings.Builder
+	textStart := -1
+	found := false
+	emit := func(end int) {
+		if textStart < 0 {
+			return
+		}
+		str := b.node("STR", nil, rubyString(astUnescape(text.String())))
+		str.first, str.last = b.tokenStart(textStart), b.tokenEnd(end)
+		pieces = append(pieces, str)
+		text.Reset()
+		textStart = -1
+	}
+	var walk func(from, to int)
+	walk = func(from, to int) {
+		for i := from; i <= to && i < len(b.tokens); i++ {
+			token := b.tokens[i]
+			switch token.Event {
+			case "heredoc_beg":
+				if token.Body > 0 && token.Pair > token.Body {
+					walk(token.Body, token.Pair-1)
+				}
+			case "tstring_content":
+				if textStart < 0 {
+					textStart = i
+				}
+				text.WriteString(token.Text)
+			case "embexpr_beg":
+				found = true
+				emit(i - 1)
+				var body *astNode
+				if program, ok := b.embeds[i]; ok {
+					body = b.stmts(program.Statements)
+				}
+				evstr := b.node("EVSTR", nil, body)
+				evstr.first = b.tokenStart(i)
+				if end := token.Pair; end > i {
+					evstr.last = b.tokenEnd(end)
+					i = end
+				}
+				pieces = append(pieces, evstr)
+			case "embvar":
+				found = true
+				emit(i - 1)
+				if i+1 < len(b.tokens) {
+					evstr := b.node("EVSTR", nil, b.variable(b.tokens[i+1].Text, nil))
+					evstr.first, evstr.last = b.tokenStart(i), b.tokenEnd(i+1)
+					if inner := evstr.children[0].(*astNode); inner != nil {
+						inner.first, inner.last = b.tokenStart(i+1), b.tokenEnd(i+1)
+					}
+					pieces = append(pieces, evstr)
+					i++
+				}
+			case "tstring_end", "regexp_end", "label_end":
+				emit(i - 1)
+			}
+		}
+		emit(to)
+	}
+	walk(span[0], span[1])
+	if !found {
+		return nil
+	}
+	return pieces
+}
+
+// interpolated builds DSTR and friends: a leading string, the first
+// interpolated piece and a LIST of the rest.
+func (b *astBuilder) interpolated(kind string, key any, pieces []any) *astNode {
+	prefix := rubyString("")
+	if first, ok := pieces[0].(*astNode); ok && first.kind == "STR" {
+		prefix = first.children[0].(*object.EmeraldValue)
+		pieces = pieces[1:]
+	}
+	var head, rest any
+	if len(pieces) > 0 {
+		head = pieces[0]
+	}
+	if len(pieces) > 1 {
+		rest = b.node("LIST", nil, append(append([]any{}, pieces[1:]...), nil)...)
+	}
+	return b.node(kind, key, prefix, head, rest)
+}
+
+// astUnescape resolves the backslash escapes of double-quoted source text.
+func astUnescape(text string) string {
+	if !strings.Contains(text, "\\") {
+		return text
+	}
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 >= len(text) {
+			out.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 's':
+			out.WriteByte(' ')
+		case 'r':
+			out.WriteByte('\r')
+		case 'e':
+			out.WriteByte(0x1b)
+		case '0':
+			out.WriteByte(0)
+		case 'a':
+			out.WriteByte(7)
+		case 'b':
+			out.WriteByte(8)
+		case 'f':
+			out.WriteByte(12)
+		case 'v':
+			out.WriteByte(11)
+		case '\n':
+		default:
+			out.WriteByte(text[i])
+		}
+	}
+	return out.String()
+}
+
+func (b *astBuilder) ifExpression(n *ast.IfExpression) *astNode {
+	if negated := ripperUnlessCondition(n); negated != nil {
+		condition := b.expr(negated)
+		return b.node("UNLESS", n, condition, b.body(n.Consequent), nil)
+	}
+	condition := b.expr(n.Condition)
+	consequent := b.body(n.Consequent)
+	var alternative *astNode
+	elsifs := make([][2]*astNode, 0, len(n.ElsIf))
+	for _, elsif := range n.ElsIf {
+		elsifs = append(elsifs, [2]*astNode{b.expr(elsif.Condition), b.body(elsif.Consequent)})
+	}
+	alternative = b.body(n.Alternative)
+	for i := len(elsifs) - 1; i >= 0; i-- {
+		branch := b.node("IF", nil, elsifs[i][0], elsifs[i][1], alternative)
+		b.positionClause(branch, n, elsifs[i][0])
+		alternative = branch
+	}
+	kind := "IF"
+	if n.IsUnless {
+		kind = "UNLESS"
+	}
+	return b.node(kind, n, condition, consequent, alternative)
+}
+
+func (b *astBuilder) caseExpression(n *ast.CaseExpression) *astNode {
+	subject := b.expr(n.Expression)
+	pattern := len(n.Clauses) > 0 && len(n.Clauses[0].Conditions) == 1
+	if pattern {
+		_, pattern = n.Clauses[0].Conditions[0].(*ast.PatternMatchExpression)
+	}
+	type clause struct {
+		condition, body *astNode
+	}
+	clauses := make([]clause, 0, len(n.Clauses))
+	for _, caseClause := range n.Clauses {
+		var current clause
+		if match, ok := caseClause.Conditions[0].(*ast.PatternMatchExpression); ok && pattern {
+			b.declarePattern(match.Pattern)
+			current.condition = b.guard(match)
+		} else {
+			current.condition = b.list(caseClause.Conditions, nil)
+		}
+		current.body = b.body(caseClause.Body)
+		clauses = append(clauses, current)
+	}
+	next := b.body(n.Else)
+	clauseKind := "WHEN"
+	if pattern {
+		clauseKind = "IN"
+	}
+	for i := len(clauses) - 1; i >= 0; i-- {
+		current := b.node(clauseKind, nil, clauses[i].condition, clauses[i].body, next)
+		b.positionClause(current, n, clauses[i].condition)
+		next = current
+	}
+	switch {
+	case pattern:
+		return b.node("CASE3", n, subject, next)
+	case subject == nil:
+		return b.node("CASE2", n, nil, next)
+	}
+	return b.node("CASE", n, subject, next)
+}
+
+// positionClause places an elsif, when or in clause from its keyword, found
+// as the last token before the condition, to the `end` of its owner.
+func (b *astBuilder) positionClause(clause *astNode, owner ast.Node, condition *astNode) {
+	span, ok := b.spans[owner]
+	if !ok {
+		return
+	}
+	clause.last = b.tokenEnd(span[1])
+	if end := b.significant(span[1]); end >= 0 {
+		clause.last = b.tokenEnd(end)
+	}
+	if condition == nil || condition.first.line == 0 {
+		return
+	}
+	for i := span[0]; i <= span[1]; i++ {
+		token := b.tokens[i]
+		if token.Line > condition.first.line || token.Line == condition.first.line && token.Column >= condition.first.column {
+			if keyword := b.significant(i); keyword >= 0 {
+				clause.first = b.tokenStart(keyword)
+			}
+			break
+		}
+	}
+}
+
+// guard returns the pattern slot of an IN clause. The parser keeps case/in
+// patterns as source text, so only a guard becomes nodes.
+func (b *astBuilder) guard(match *ast.PatternMatchExpression) *astNode {
+	if match.Guard == nil {
+		return nil
+	}
+	if match.GuardUnless {
+		return b.node("UNLESS", nil, b.expr(match.Guard), nil, nil)
+	}
+	return b.node("IF", nil, b.expr(match.Guard), nil, nil)
+}
+
+// declarePattern declares the locals a pattern binds, reading them off the
+// pattern text the parser keeps.
+func (b *astBuilder) declarePattern(pattern string) {
+	words := strings.Fields(pattern)
+	for i, word := range words {
+		if word == "" || !(word[0] == '_' || word[0] >= 'a' && word[0] <= 'z') {
+			continue
+		}
+		if strings.HasSuffix(word, ":") || ripperKeywords[word].state != 0 || word == "nil" || word == "true" || word == "false" || word == "self" {
+			continue
+		}
+		if i > 0 && (words[i-1] == "^" || words[i-1] == "." || words[i-1] == "&.") {
+			continue
+		}
+		if i+1 < len(words) && (words[i+1] == "(" || words[i+1] == "." || words[i+1] == "&.") {
+			continue
+		}
+		b.declare(word)
+	}
+}
+
+func (b *astBuilder) begin(n *ast.BeginExpression) *astNode {
+	body := b.body(n.Body)
+	result := body
+	if len(n.Rescue) > 0 {
+		var next *astNode
+		bodies := make([]*astNode, len(n.Rescue))
+		for i, clause := range n.Rescue {
+			var exceptions, variable *astNode
+			if len(clause.Exceptions) > 0 {
+				exceptions = b.list(clause.Exceptions, nil)
+			}
+			switch {
+			case clause.Target != nil:
+				variable = b.target(clause.Target)
+			case clause.Variable != nil:
+				variable = b.node(b.assignKind(clause.Variable.Value), clause.Variable, b.sym(clause.Variable.Value), b.node("ERRINFO", nil))
+			}
+			if variable != nil {
+				if errinfo, ok := variable.children[len(variable.children)-1].(*astNode); ok && errinfo != nil {
+					errinfo.first, errinfo.last = variable.first, variable.last
+				}
+			}
+			bodies[i] = b.node("RESBODY", clause, exceptions, variable, b.body(clause.Body), nil)
+		}
+		for i := len(bodies) - 1; i >= 0; i-- {
+			bodies[i].children[3] = next
+			if next != nil && bodies[i].last.before(next.last) {
+				bodies[i].last = next.last
+			}
+			next = bodies[i]
+		}
+		result = b.node("RESCUE", nil, body, next, b.body(n.Else))
+		if n.Token.Literal == "rescue" {
+			b.spanOf(result, n)
+			next.children[0] = nil
+		}
+	} else if n.Else != nil {
+		result = b.node("BLOCK", nil, body, b.body(n.Else))
+	}
+	if n.Ensure != nil {
+		result = b.node("ENSURE", nil, result, b.body(n.Ensure))
+	}
+	if n.Token.Literal == "begin" {
+		return b.node("BEGIN", n, result)
+	}
+	return result
+}
+
+// target converts an assignment target that receives its value elsewhere,
+// as in multiple assignment, for, or rescue => e.
+func (b *astBuilder) target(target ast.Expression) *astNode {
+	switch n := target.(type) {
+	case *ast.Identifier:
+		return b.node(b.assignKind(n.Value), n, b.sym(n.Value), nil)
+	case *ast.InstanceVariable:
+		return b.node("IASGN", n, b.sym(n.Name), nil)
+	case *ast.ClassVariable:
+		return b.node("CVASGN", n, b.sym(n.Name), nil)
+	case *ast.GlobalVariable:
+		return b.node("GASGN", n, b.sym(n.Name), nil)
+	case *ast.Constant:
+		return b.node("CDECL", n, b.sym(n.Name), nil)
+	case *ast.ArrayLiteral:
+		return b.multiAssign(n, nil, n.Elements)
+	case *ast.MethodCall:
+		name := n.Method.Value
+		if !strings.HasSuffix(name, "=") {
+			name += "="
+		}
+		return b.node("ATTRASGN", n, b.expr(n.Receiver), b.sym(name), nil)
+	case *ast.IndexExpression:
+		return b.node("ATTRASGN", n, b.expr(n.Left), b.sym("[]="), b.list([]ast.Expression{n.Index}, nil))
+	case *ast.SplatExpression:
+		return b.target(n.Value)
+	}
+	return b.expr(target)
+}
+
+// multiAssign builds MASGN: the value, the targets before a splat, and the
+// splat target with any targets after it.
+func (b *astBuilder) multiAssign(key any, value *astNode, targets []ast.Expression) *astNode {
+	var pre, post []any
+	var rest any
+	splatSeen := false
+	for _, target := range targets {
+		if splat, ok := target.(*ast.SplatExpression); ok {
+			splatSeen = true
+			if splat.Value == nil {
+				rest = b.sym("NODE_SPECIAL_NO_NAME_REST")
+			} else {
+				rest = b.target(splat.Value)
+			}
+			continue
+		}
+		if splatSeen {
+			post = append(post, b.target(target))
+		} else {
+			pre = append(pre, b.target(target))
+		}
+	}
+	var head *astNode
+	if len(pre) > 0 {
+		head = b.node("LIST", nil, append(pre, nil)...)
+	}
+	if len(post) > 0 {
+		rest = b.node("POSTARG", nil, rest, b.node("LIST", nil, append(post, nil)...))
+	}
+	return b.node("MASGN", key, value, head, rest)
+}
+
+func (b *astBuilder) assign(n *ast.AssignExpression) *astNode {
+	operator := n.Token.Literal
+	switch {
+	case n.Index != nil:
+		receiverExpr := n.Target
+		if receiverExpr == nil {
+			receiverExpr = n.Name
+		}
+		receiver := b.expr(receiverExpr)
+		index := []ast.Expression{n.Index}
+		if n.End != nil {
+			index = append(index, n.End)
+		}
+		if operator == "" || operator == "=" {
+			return b.node("ATTRASGN", n, receiver, b.sym("[]="), b.list(index, b.expr(n.Value)))
+		}
+		return b.node("OP_ASGN1", n, receiver, b.sym(strings.TrimSuffix(operator, "=")), b.list(index, nil), b.expr(n.Value))
+	case n.Target != nil:
+		path := b.node("COLON2", nil, b.expr(n.Target), b.sym(n.Name.Value))
+		if span, ok := b.spans[n]; ok {
+			path.first = b.tokenStart(span[0])
+			if value, ok := b.spans[n.Value]; ok {
+				if end := b.significant(value[0] - 1); end >= 0 {
+					path.last = b.tokenEnd(end)
+				}
+			}
+		}
+		if operator == "" || operator == "=" {
+			return b.node("CDECL", n, path, b.expr(n.Value))
+		}
+		return b.node("OP_CDECL", n, path, b.sym(strings.TrimSuffix(operator, "=")), b.expr(n.Value))
+	}
+	return b.variableAssign(n, n.Name.Value, operator, n.Value)
+}
+
+// variableAssign builds the assignment of a local, instance, class or
+// global variable or a constant, including its operator forms.
+func (b *astBuilder) variableAssign(key any, name, operator string, value ast.Expression) *astNode {
+	kind := b.assignKind(name)
+	if operator == "" || operator == "=" {
+		return b.node(kind, key, b.sym(name), b.expr(value))
+	}
+	read := b.variable(name, nil)
+	if span, ok := b.spans[key]; ok {
+		read.first, read.last = b.tokenStart(span[0]), b.tokenEnd(span[0])
+	}
+	switch operator {
+	case "||=":
+		return b.node("OP_ASGN_OR", key, read, b.node(kind, key, b.sym(name), b.expr(value)))
+	case "&&=":
+		return b.node("OP_ASGN_AND", key, read, b.node(kind, key, b.sym(name), b.expr(value)))
+	}
+	call := b.node("OPCALL", key, read, b.sym(strings.TrimSuffix(operator, "=")), b.list([]ast.Expression{value}, nil))
+	return b.node(kind, key, b.sym(name), call)
+}
+
+func (b *astBuilder) methodCall(n *ast.MethodCall) *astNode {
+	name := n.Method.Value
+	if n.Receiver == nil && (name == "BEGIN" || name == "END") && n.Block != nil {
+		b.pushScope(false)
+		body := b.body(n.Block)
+		b.popScope()
+		if name == "BEGIN" {
+			return b.node("BEGIN", n, body)
+		}
+		return b.node("POSTEXE", n, b.node("SCOPE", n, []any{}, nil, body))
+	}
+	if n.Assignment || n.LogicalAssignment != "" {
+		return b.attributeAssign(n)
+	}
+	var call *astNode
+	switch {
+	case n.Receiver != nil:
+		kind := "CALL"
+		if n.Safe {
+			kind = "QCALL"
+		}
+		receiver := b.expr(n.Receiver)
+		var args *astNode
+		if len(n.Args) > 0 || len(n.KeywordArgs) > 0 {
+			args = b.args(n.Args, n.KeywordArgs)
+		}
+		call = b.node(kind, n, receiver, b.sym(name), args)
+	case len(n.Args) > 0 || len(n.KeywordArgs) > 0 || n.ParenthesizedArgs || n.Block != nil:
+		var args *astNode
+		if len(n.Args) > 0 || len(n.KeywordArgs) > 0 {
+			args = b.args(n.Args, n.KeywordArgs)
+		}
+		call = b.node("FCALL", n, b.sym(name), args)
+	default:
+		call = b.node("VCALL", n, b.sym(name))
+	}
+	return b.iter(n, call, n.Block)
+}
+
+// iter wraps a call that takes a literal block in ITER; the call itself
+// then ends before the block.
+func (b *astBuilder) iter(key any, call *astNode, block *ast.BlockExpression) *astNode {
+	if block == nil {
+		return call
+	}
+	blockSpan, hasSpan := b.spans[ripperSpanKey{block, "block"}]
+	if hasSpan {
+		if end := b.significant(blockSpan[0]); end >= 0 {
+			call.last = b.tokenEnd(end)
+		}
+	}
+	b.pushScope(false)
+	var params *astNode
+	if block.ExplicitParams {
+		params = b.params(block, astParams{
+			params: block.Params, defaults: block.ParamDefaults, rest: block.RestParam, restIndex: block.RestParamIndex,
+			keywords: block.KeywordParams, keywordRest: block.KeywordRestParam, rejectKeywords: block.RejectKeywords, block: block.BlockParam,
+		})
+	}
+	body := b.body(block)
+	scope := b.node("SCOPE", ripperSpanKey{block, "block"}, b.popScope(), params, body)
+	b.scopes = append(b.scopes, astScopeRef{scope: scope})
+	return b.node("ITER", key, call, scope)
+}
+
+func (b *astBuilder) attributeAssign(n *ast.MethodCall) *astNode {
+	receiver := b.expr(n.Receiver)
+	attribute := strings.TrimSuffix(n.Method.Value, "=")
+	var value ast.Expression
+	if len(n.Args) > 0 {
+		value = n.Args[0]
+	}
+	if n.LogicalAssignment != "" {
+		operator := strings.TrimSuffix(string(n.LogicalAssignment), "=")
+		return b.node("OP_ASGN2", n, receiver, boolValue(n.Safe), b.sym(attribute), b.sym(operator), b.expr(value))
+	}
+	if infix, ok := value.(*ast.InfixExpression); ok && strings.HasSuffix(infix.Token.Literal, "=") && infix.Token.Literal != "==" {
+		if call, ok := infix.Left.(*ast.MethodCall); ok && call.Receiver == n.Receiver {
+			return b.node("OP_ASGN2", n, receiver, boolValue(n.Safe), b.sym(attribute), b.sym(infix.Operator), b.expr(infix.Right))
+		}
+	}
+	return b.node("ATTRASGN", n, receiver, b.sym(n.Method.Value), b.args(n.Args, n.KeywordArgs))
+}
+
+// command builds the FCALL of a call the parser folds into its own node.
+func (b *astBuilder) command(key any, name string, args []ast.Expression, block *ast.BlockExpression) *astNode {
+	var call *astNode
+	if len(args) == 0 && block == nil {
+		call = b.node("VCALL", key, b.sym(name))
+	} else {
+		var list *astNode
+		if len(args) > 0 {
+			list = b.args(args, nil)
+		}
+		call = b.node("FCALL", key, b.sym(name), list)
+	}
+	return b.iter(key, call, block)
+}
+
+type astParams struct {
+	params         []*ast.Identifier
+	defaults       []ast.Expression
+	rest           *ast.Identifier
+	restIndex      int
+	keywords       []*ast.KeywordParam
+	keywordRest    *ast.Identifier
+	rejectKeywords bool
+	block          *ast.Identifier
+}
+
+// params builds MRI's ARGS node and declares the parameters in the current
+// scope. Its ten children are pre_num, pre_init, opt, first_post, post_num,
+// post_init, rest, kw, kwrest and block.
+func (b *astBuilder) params(owner ast.Node, spec astParams) *astNode {
+	var optional []*astNode
+	var preNum, postNum int64
+	var firstPost, rest, keywordRest, block any
+	restDone := spec.rest == nil
+	takeRest := func() {
+		restDone = true
+		switch name := spec.rest.Value; name {
+		case "", "*":
+			rest = b.sym("*")
+		default:
+			b.declare(name)
+			rest = b.sym(name)
+		}
+	}
+	for i, param := range spec.params {
+		if !restDone && i >= spec.restIndex {
+			takeRest()
+		}
+		b.declare(param.Value)
+		switch {
+		case i < len(spec.defaults) && spec.defaults[i] != nil:
+			kind := "LASGN"
+			if !b.scope.hard {
+				kind = "DASGN"
+			}
+			optional = append(optional, b.node(kind, param, b.sym(param.Value), b.expr(spec.defaults[i])))
+		case spec.rest != nil && restDone:
+			if postNum == 0 {
+				firstPost = b.sym(param.Value)
+			}
+			postNum++
+		default:
+			preNum++
+		}
+	}
+	if !restDone {
+		takeRest()
+	}
+	var opt *astNode
+	for i := len(optional) - 1; i >= 0; i-- {
+		opt = b.node("OPT_ARG", nil, optional[i], opt)
+	}
+	for _, keyword := range spec.keywords {
+		b.declare(keyword.Name)
+	}
+	var keywords *astNode
+	for i := len(spec.keywords) - 1; i >= 0; i-- {
+		keyword := spec.keywords[i]
+		var value any = b.sym("NODE_SPECIAL_REQUIRED_KEYWORD")
+		if keyword.Default != nil {
+			value = b.expr(keyword.Default)
+		}
+		kind := "LASGN"
+		if !b.scope.hard {
+			kind = "DASGN"
+		}
+		keywords = b.node("KW_ARG", nil, b.node(kind, keyword, b.sym(keyword.Name), value), keywords)
+	}
+	if spec.rejectKeywords {
+		keywordRest = R.FalseVal
+	} else if spec.keywordRest != nil {
+		name := spec.keywordRest.Value
+		if name == "" || name == "**" {
+			name = "**"
+		} else {
+			b.declare(name)
+		}
+		keywordRest = b.sym(name)
+	}
+	if spec.block != nil {
+		name := spec.block.Value
+		if name == "" || name == "&" {
+			name = "&"
+		} else {
+			b.declare(name)
+		}
+		block = b.sym(name)
+	}
+	return b.node("ARGS", ripperSpanKey{owner, "params"}, newInt(preNum), nil, opt, firstPost, newInt(postNum), nil, rest, keywords, keywordRest, block)
+}
+
+func (b *astBuilder) def(n *ast.DefExpression) *astNode {
+	var receiver *astNode
+	if n.Receiver != nil {
+		receiver = b.expr(n.Receiver)
+	}
+	b.pushScope(true)
+	params := b.params(n, astParams{
+		params: n.Params, defaults: n.ParamDefaults, rest: n.RestParam, restIndex: n.RestParamIndex,
+		keywords: n.KeywordParams, keywordRest: n.KeywordRestParam, rejectKeywords: n.RejectKeywords, block: n.BlockParam,
+	})
+	if span, ok := b.spans[n]; ok && params.first.line == 0 {
+		// Without parameters MRI places an empty ARGS right after the name.
+		name := strings.TrimSuffix(n.Name.Value, "=")
+		for i, seenDef := span[0], false; i <= span[1]; i++ {
+			token := b.tokens[i]
+			if token.Event == "kw" && token.Text == "def" {
+				seenDef = true
+			} else if seenDef && strings.TrimSuffix(token.Text, "=") == name {
+				params.first = b.tokenEnd(i)
+				params.last = params.first
+				break
+			}
+		}
+	}
+	body := b.body(n.Body)
+	scope := b.node("SCOPE", n, b.popScope(), params, body)
+	b.scopes = append(b.scopes, astScopeRef{scope: scope, method: true})
+
+	var def *astNode
+	if receiver != nil {
+		def = b.node("DEFS", n, receiver, b.sym(n.Name.Value), scope)
+	} else {
+		def = b.node("DEFN", n, b.sym(n.Name.Value), scope)
+	}
+	if n.Visibility == "" {
+		return def
+	}
+	// `private def x` parses as one node; MRI sees a call taking the def.
+	if span, ok := b.spans[n]; ok {
+		for i := span[0]; i <= span[1]; i++ {
+			if b.tokens[i].Event == "kw" && b.tokens[i].Text == "def" {
+				def.first = b.tokenStart(i)
+				scope.first = def.first
+				break
+			}
+		}
+	}
+	return b.node("FCALL", n, b.sym(n.Visibility), b.node("LIST", nil, def, nil))
+}
+
+func (b *astBuilder) definitionScope(owner ast.Node, body *ast.BlockExpression) *astNode {
+	b.pushScope(true)
+	node := b.body(body)
+	return b.node("SCOPE", owner, b.popScope(), nil, node)
+}
+
+// constPath builds the COLON2/COLON3 naming a class or module, positioned
+// on the name tokens after the keyword.
+func (b *astBuilder) constPath(owner ast.Node, name string, absolute bool) *astNode {
+	parts := strings.Split(strings.TrimPrefix(name, "::"), "::")
+	var path *astNode
+	if absolute || strings.HasPrefix(name, "::") {
+		path = b.node("COLON3", nil, b.sym(parts[0]))
+	} else {
+		path = b.node("COLON2", nil, nil, b.sym(parts[0]))
+	}
+	for _, part := range parts[1:] {
+		if path.kind == "COLON2" && path.children[0] == nil {
+			path = b.node("COLON2", nil, b.node("CONST", nil, path.children[1]), b.sym(part))
+			continue
+		}
+		path = b.node("COLON2", nil, path, b.sym(part))
+	}
+	span, ok := b.spans[owner]
+	if !ok {
+		return path
+	}
+	start := -1
+	for i := span[0] + 1; i <= span[1]; i++ {
+		token := b.tokens[i]
+		if ripperInsignificant(token.Event) {
+			continue
+		}
+		if token.Event != "const" && !(token.Event == "op" && token.Text == "::") {
+			break
+		}
+		if start < 0 {
+			start = i
+		}
+		end := b.tokenEnd(i)
+		for node := path; node != nil; {
+			node.first, node.last = b.tokenStart(start), end
+			inner, _ := node.children[0].(*astNode)
+			if inner == nil || node.kind == "COLON3" {
+				break
+			}
+			node = inner
+			end = node.last
+		}
+	}
+	if start >= 0 {
+		// Inner segments end at their own name; walk the path again.
+		b.positionPath(path, start)
+	}
+	return path
+}
+
+func (b *astBuilder) positionPath(path *astNode, start int) {
+	consts := []int{}
+	for i := start; i < len(b.tokens); i++ {
+		token := b.tokens[i]
+		if token.Event == "const" {
+			consts = append(consts, i)
+			continue
+		}
+		if token.Event != "op" || token.Text != "::" {
+			break
+		}
+	}
+	var segments []*astNode
+	for node := path; node != nil; {
+		segments = append([]*astNode{node}, segments...)
+		inner, _ := node.children[0].(*astNode)
+		if node.kind == "COLON3" || node.kind == "CONST" {
+			break
+		}
+		node = inner
+	}
+	for i, segment := range segments {
+		if i < len(consts) {
+			segment.first, segment.last = b.tokenStart(start), b.tokenEnd(consts[i])
+		}
+	}
+}
+
+// installAbstractSyntaxTree defines RubyVM::AbstractSyntaxTree and its Node
+// class under the RubyVM module.
+func installAbstractSyntaxTree(rubyVM *object.Module) {
+	module := object.NewModule("AbstractSyntaxTree")
+	moduleValue := &object.EmeraldValue{Type: object.ValueModule, Data: module, Class: R.Classes["Module"]}
+	rubyVM.DefineConstant("AbstractSyntaxTree", moduleValue)
+	AssignConstantName(&object.EmeraldValue{Type: object.ValueModule, Data: rubyVM, Class: R.Classes["Module"]}, "AbstractSyntaxTree", moduleValue)
+
+	nodeClass := object.NewClass("Node")
+	nodeClass.SuperClass = R.Classes["Object"]
+	nodeValue := &object.EmeraldValue{Type: object.ValueClass, Data: nodeClass, Class: R.Classes["Class"]}
+	module.DefineConstant("Node", nodeValue)
+	AssignConstantName(moduleValue, "Node", nodeValue)
+	R.Classes["RubyVM::AbstractSyntaxTree::Node"] = nodeClass
+
+	module.DefineMethod("parse", &object.Method{Name: "parse", Fn: astParseMethod, Arity: -2})
+	module.DefineMethod("parse_file", &object.Method{Name: "parse_file", Fn: astParseFile, Arity: -2})
+	module.DefineMethod("of", &object.Method{Name: "of", Fn: astOf, Arity: -2})
+
+	nodeClass.DefineMethod("type", &object.Method{Name: "type", Fn: astNodeType, Arity: 0})
+	nodeClass.DefineMethod("children", &object.Method{Name: "children", Fn: astNodeChildren, Arity: 0})
+	nodeClass.DefineMethod("first_lineno", &object.Method{Name: "first_lineno", Fn: astNodeField(func(n *astNode) int { return n.first.line }), Arity: 0})
+	nodeClass.DefineMethod("first_column", &object.Method{Name: "first_column", Fn: astNodeField(func(n *astNode) int { return n.first.column }), Arity: 0})
+	nodeClass.DefineMethod("last_lineno", &object.Method{Name: "last_lineno", Fn: astNodeField(func(n *astNode) int { return n.last.line }), Arity: 0})
+	nodeClass.DefineMethod("last_column", &object.Method{Name: "last_column", Fn: astNodeField(func(n *astNode) int { return n.last.column }), Arity: 0})
+	nodeClass.DefineMethod("node_id", &object.Method{Name: "node_id", Fn: astNodeField(func(n *astNode) int { return n.id }), Arity: 0})
+	nodeClass.DefineMethod("inspect", &object.Method{Name: "inspect", Fn: astNodeInspect, Arity: 0})
+	nodeClass.DefineMethod("to_s", &object.Method{Name: "to_s", Fn: astNodeInspect, Arity: 0})
+	nodeClass.DefineMethod("source", &object.Method{Name: "source", Fn: astNodeSource, Arity: 0})
+	nodeClass.DefineMethod("script_lines", &object.Method{Name: "script_lines", Fn: astNodeScriptLines, Arity: 0})
+}
+
+// astOptions splits a trailing keyword hash off args and reports whether
+// keep_script_lines was given.
+func astOptions(args []*object.EmeraldValue) ([]*object.EmeraldValue, bool) {
+	if len(args) == 0 || args[len(args)-1] == nil || args[len(args)-1].Type != object.ValueHash {
+		return args, false
+	}
+	options := valueToHashMap(args[len(args)-1])
+	keep := false
+	if value, ok := hashLookup(options, rubySymbol("keep_script_lines")); ok {
+		keep = isTruthy(value)
+	}
+	return args[:len(args)-1], keep
+}
+
+func astParseMethod(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	args, keep := astOptions(args)
+	if len(args) != 1 {
+		return NewArgumentError(fmt.Sprintf("wrong number of arguments (given %d, expected 1)", len(args)))
+	}
+	_, source, errVal := cgiStringArg(args[0])
+	if errVal != nil {
+		return errVal
+	}
+	tree, errVal := astParse(source, "", keep)
+	if errVal != nil {
+		return errVal
+	}
+	return astNodeValue(tree.root)
+}
+
+func astParseFile(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	args, keep := astOptions(args)
+	if len(args) != 1 {
+		return NewArgumentError(fmt.Sprintf("wrong number of arguments (given %d, expected 1)", len(args)))
+	}
+	_, path, errVal := cgiStringArg(args[0])
+	if errVal != nil {
+		return errVal
+	}
+	if denied := sandboxDenied(sandboxFileSystem, "RubyVM::AbstractSyntaxTree.parse_file"); denied != nil {
+		return denied
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return newRuntimeException(R.Classes["Errno::ENOENT"], "No such file or directory @ rb_sysopen - "+path)
+	}
+	tree, errVal := astParse(string(content), path, keep)
+	if errVal != nil {
+		return errVal
+	}
+	return astNodeValue(tree.root)
+}
+
+// astOf finds the SCOPE of a method or proc by re-reading the file it was
+// defined in and matching the line of its source location.
+func astOf(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	args, keep := astOptions(args)
+	if len(args) != 1 {
+		return NewArgumentError(fmt.Sprintf("wrong number of arguments (given %d, expected 1)", len(args)))
+	}
+	target := args[0]
+	var location *object.EmeraldValue
+	isMethod := false
+	switch target.Type {
+	case object.ValueMethod:
+		isMethod = true
+		location = methodSourceLocationValue(target.Data.(*object.Method))
+	case object.ValueProc, object.ValueClosure:
+		location = procSourceLocation(target)
+		if proc, ok := target.Data.(*object.Proc); ok && proc != nil && proc.SourceMethod != nil {
+			isMethod = true
+		}
+	default:
+		return NewTypeError("wrong argument type " + valueTypeName(target) + " (expected Method or Proc)")
+	}
+	pair, ok := location.Data.([]*object.EmeraldValue)
+	if location.Type != object.ValueArray || !ok || len(pair) < 2 {
+		return R.NilVal
+	}
+	path, _ := pair[0].Data.(string)
+	line, _ := pair[1].Data.(int64)
+	if denied := sandboxDenied(sandboxFileSystem, "RubyVM::AbstractSyntaxTree.of"); denied != nil {
+		return denied
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return NewArgumentError("cannot get AST for method defined in eval")
+	}
+	tree, errVal := astParse(string(content), path, keep)
+	if errVal != nil {
+		return errVal
+	}
+	for _, ref := range tree.scopes {
+		if ref.method == isMethod && ref.scope.first.line == int(line) {
+			return astNodeValue(ref.scope)
+		}
+	}
+	return NewArgumentError("cannot find the AST for " + valueTypeName(target))
+}
+
+func astNodeValue(n *astNode) *object.EmeraldValue {
+	if n == nil {
+		return R.NilVal
+	}
+	if n.value == nil {
+		n.value = &object.EmeraldValue{Type: object.ValueObject, Data: n, Class: R.Classes["RubyVM::AbstractSyntaxTree::Node"]}
+	}
+	return n.value
+}
+
+func astNodeOf(receiver *object.EmeraldValue) *astNode {
+	if receiver == nil {
+		return nil
+	}
+	n, _ := receiver.Data.(*astNode)
+	return n
+}
+
+func astNodeType(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	n := astNodeOf(receiver)
+	if n == nil {
+		return R.NilVal
+	}
+	return rubySymbol(n.kind)
+}
+
+func astNodeChildren(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	n := astNodeOf(receiver)
+	if n == nil {
+		return matrixArray(nil)
+	}
+	if n.values == nil {
+		values := make([]*object.EmeraldValue, 0, len(n.children))
+		for _, child := range n.children {
+			switch c := child.(type) {
+			case *astNode:
+				values = append(values, astNodeValue(c))
+			case *object.EmeraldValue:
+				if c == nil {
+					c = R.NilVal
+				}
+				values = append(values, c)
+			case []any:
+				list := make([]*object.EmeraldValue, 0, len(c))
+				for _, item := range c {
+					if value, ok := item.(*object.EmeraldValue); ok && value != nil {
+						list = append(list, value)
+					} else {
+						list = append(list, R.NilVal)
+					}
+				}
+				values = append(values, matrixArray(list))
+			default:
+				values = append(values, R.NilVal)
+			}
+		}
+		n.values = matrixArray(values)
+	}
+	return n.values
+}
+
+func astNodeField(field func(*astNode) int) func(*object.EmeraldValue, ...*object.EmeraldValue) *object.EmeraldValue {
+	return func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		n := astNodeOf(receiver)
+		if n == nil {
+			return R.NilVal
+		}
+		return newInt(int64(field(n)))
+	}
+}
+
+func astNodeInspect(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	n := astNodeOf(receiver)
+	if n == nil {
+		return rubyString("#<RubyVM::AbstractSyntaxTree::Node>")
+	}
+	return rubyString(fmt.Sprintf("#<RubyVM::AbstractSyntaxTree::Node:%s@%d:%d-%d:%d>", n.kind, n.first.line, n.first.column, n.last.line, n.last.column))
+}
+
+// astNodeSource returns the text a node covers; like MRI it needs the
+// script lines kept with keep_script_lines: true.
+func astNodeSource(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	n := astNodeOf(receiver)
+	if n == nil || n.lines == nil || n.first.line == 0 {
+		return R.NilVal
+	}
+	var out strings.Builder
+	for line := n.first.line; line <= n.last.line && line-1 < len(n.lines); line++ {
+		text := n.lines[line-1]
+		start, end := 0, len(text)
+		if line == n.first.line {
+			start = min(n.first.column, len(text))
+		}
+		if line == n.last.line {
+			end = min(n.last.column, len(text))
+		}
+		if start < end {
+			out.WriteString(text[start:end])
+		}
+	}
+	return rubyString(out.String())
+}
+
+func astNodeScriptLines(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	n := astNodeOf(receiver)
+	if n == nil || n.lines == nil {
+		return R.NilVal
+	}
+	lines := make([]*object.EmeraldValue, 0, len(n.lines))
+	for _, line := range n.lines {
+		lines = append(lines, rubyString(line))
+	}
+	return matrixArray(lines)
+}
@@ -0,0 +1,402 @@
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"strings"
+	"time"
+	"weak"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// RuntimeState is a snapshot of the package-level interpreter state that
+// initRuntime resets: the class registry in R, the feature tables, thread
+// and fiber bookkeeping, object ids, I/O shims and the pending exception.
+// Embedders that keep several interpreters in one process swap these
+// snapshots on entry and exit; the state is not safe for concurrent use, so
+// callers must serialize access around Capture and Restore.
+type RuntimeState struct {
+	R                              *Runtime
+	CurrentEvalSource              bool
+	CurrentTopLevelMain            bool
+	CurrentEvalSourceEncoding      string
+	CurrentSpecFile                string
+	CurrentSpecFileAbsolute        string
+	LastBlockResult                *object.EmeraldValue
+	LastException                  *object.EmeraldValue
+	LastRaisedResult               *object.EmeraldValue
+	LastMatcherException           *object.EmeraldValue
+	activeTracePoints              []*object.EmeraldValue
+	tracePointDispatching          bool
+	allThreads                     []*object.EmeraldValue
+	argfClassValue                 *object.EmeraldValue
+	atExitHooks                    []*object.EmeraldValue
+	attachedSingletonClasses       map[*object.EmeraldValue]*object.Class
+	autoloadRegistrations          []autoloadRegistration
+	autoloadRequireDepth           int
+	berPackOverrides               map[*object.EmeraldValue][]byte
+	builtinOutputCapture           *strings.Builder
+	constantNameEncodings          map[string]string
+	coverage                       coverageMeasurement
+	currentFiber                   *object.EmeraldValue
+	currentFileUmask               int64
+	currentThread                  *object.EmeraldValue
+	deduplicatedStrings            map[string]*object.EmeraldValue
+	defaultExternalEncoding        string
+	defaultThreadGroup             *object.EmeraldValue
+	drbCurrentServer               *object.EmeraldValue
+	encodingValues                 map[string]*object.EmeraldValue
+	endHookSites                   map[*byte]struct{}
+	envObject                      *object.EmeraldValue
+	Sandbox                        *SandboxPolicy
+	sandboxDeadline                time.Time
+	sandboxLimitErr                error
+	errnoModule                    *object.Module
+	etcGroupIterating              bool
+	fiberTerminationResult         *object.EmeraldValue
+	fileUtimeOverrides             map[string]fileTimeOverride
+	formerAutoloadNames            map[any]map[string]bool
+	gcAutoCompact                  bool
+	gcConfigValues                 map[string]bool
+	gcCountValue                   int64
+	gcDisabled                     bool
+	gcMajorCountValue              int64
+	gcMeasureTotalTime             bool
+	gcProfilerEnabled              bool
+	gcStress                       bool
+	gcTotalTimeValue               int64
+	gemConfigurationValue          *object.EmeraldValue
+	globalVariableAliases          map[string]string
+	globalVariableTraces           map[string][]globalVariableTrace
+	hashIdentityClassKeys          map[*object.Class]*object.EmeraldValue
+	hashIdentityIntegerKeys        map[int64]*object.EmeraldValue
+	hashIdentityModuleKeys         map[*object.Module]*object.EmeraldValue
+	hashIdentitySymbolKeys         map[string]*object.EmeraldValue
+	internedStringSymbols          map[string]*object.EmeraldValue
+	ioBufferValues                 []*object.EmeraldValue
+	ioDataByFd                     map[int64]*ioShimData
+	ioFIFOStates                   map[string]*ioFIFOState
+	ioPipeBuffers                  map[int64]*bytes.Buffer
+	ioPopenStates                  map[int64]*ioPopenState
+	kernelModuleView               *object.Module
+	kernelRand                     *rand.Rand
+	kernelRandSeed                 int64
+	kernelRubyRand                 *rubyMT19937
+	lastMockToAryReturn            *object.EmeraldValue
+	lazyArrayRegions               []weak.Pointer[object.EmeraldValue]
+	loadingFeatureOwners           map[string]*object.EmeraldValue
+	loadingFeatureWaiters          map[string][]*object.EmeraldValue
+	loadingFeatures                map[string]bool
+	mainThread                     *object.EmeraldValue
+	nextIOFd                       int64
+	numericBigIntOverrides         map[*object.EmeraldValue]*big.Int
+	numericFloatOverrides          map[*object.EmeraldValue]float64
+	objectIDByClass                map[*object.Class]int64
+	objectIDByInteger              map[int64]int64
+	objectIDByModule               map[*object.Module]int64
+	objectIDBySymbol               map[string]int64
+	objectIDByValue                map[*object.EmeraldValue]int64
+	objectIDCounter                int64
+	objectSpaceFinalizers          map[*object.EmeraldValue][]*object.EmeraldValue
+	objectSpaceTotalAllocated      int64
+	objectSpaceTraceDepth          int
+	objectSpaceTraceRetained       []*object.EmeraldValue
+	objectSpaceTracked             []weak.Pointer[object.EmeraldValue]
+	objectSpaceTrackedSinceCompact int
+	observableValues               map[*object.EmeraldValue]*observableData
+	packedPointerStrings           map[*object.EmeraldValue]string
+	pendingThreads                 []*object.EmeraldValue
+	procRuby2KeywordFunctions      map[*object.Function]bool
+	processArgv0                   *object.EmeraldValue
+	processChildren                []*processChild
+	processDaemonPID               int64
+	processDaemonPgroup            int64
+	processGroups                  []int64
+	processNextPID                 int64
+	processPriorities              map[[2]int64]int64
+	processTitle                   string
+	refinementModules              map[*object.EmeraldValue]map[any]*object.EmeraldValue
+	regexpDefaultTimeout           *object.EmeraldValue
+	requiredFeatureAliases         map[string]string
+	requiredFeatures               map[string]bool
+	ripperStates                   map[*object.EmeraldValue]*ripperData
+	scratchPadRecorded             *object.EmeraldValue
+	setTraceFuncValue              *object.EmeraldValue
+	signalExceptionNumbers         map[*object.EmeraldValue]int64
+	signalTraps                    map[int64]signalTrapData
+	skipAtExitHooks                bool
+	specTimeLocation               *time.Location
+	stderrObject                   *object.EmeraldValue
+	stdinObject                    *object.EmeraldValue
+	stdoutObject                   *object.EmeraldValue
+	suppressIOPipeBlock            bool
+	symbolEncodings                map[*object.EmeraldValue]string
+	symbolNameStrings              map[string]*object.EmeraldValue
+	syslogState                    syslogSettings
+	threadBacktraceLimit           int64
+	threadIgnoreDeadlock           bool
+	threadTerminationResult        *object.EmeraldValue
+	timedThreads                   map[*object.EmeraldValue]time.Time
+	ulebPackOverrides              map[*object.EmeraldValue][]byte
+	weakRefValues                  []*object.EmeraldValue
+}
+
+// CaptureRuntimeState records the current interpreter state. Maps and slices
+// are shared rather than copied, so the snapshot follows later mutations
+// made by the same interpreter until another state is restored.
+func CaptureRuntimeState() *RuntimeState {
+	return &RuntimeState{
+		R:                              R,
+		CurrentEvalSource:              CurrentEvalSource,
+		CurrentTopLevelMain:            CurrentTopLevelMain,
+		CurrentEvalSourceEncoding:      CurrentEvalSourceEncoding,
+		CurrentSpecFile:                CurrentSpecFile,
+		CurrentSpecFileAbsolute:        CurrentSpecFileAbsolute,
+		LastBlockResult:                LastBlockResult,
+		LastException:                  LastException,
+		LastRaisedResult:               LastRaisedResult,
+		LastMatcherException:           LastMatcherException,
+		activeTracePoints:              activeTracePoints,
+		tracePointDispatching:          tracePointDispatching,
+		allThreads:                     allThreads,
+		argfClassValue:                 argfClassValue,
+		atExitHooks:                    atExitHooks,
+		attachedSingletonClasses:       attachedSingletonClasses,
+		autoloadRegistrations:          autoloadRegistrations,
+		autoloadRequireDepth:           autoloadRequireDepth,
+		berPackOverrides:               berPackOverrides,
+		builtinOutputCapture:           builtinOutputCapture,
+		constantNameEncodings:          constantNameEncodings,
+		coverage:                       coverage,
+		currentFiber:                   currentFiber,
+		currentFileUmask:               currentFileUmask,
+		currentThread:                  currentThread,
+		deduplicatedStrings:            deduplicatedStrings,
+		defaultExternalEncoding:        defaultExternalEncoding,
+		defaultThreadGroup:             defaultThreadGroup,
+		drbCurrentServer:               drbCurrentServer,
+		encodingValues:                 encodingValues,
+		endHookSites:                   endHookSites,
+		envObject:                      envObject,
+		Sandbox:                        Sandbox,
+		sandboxDeadline:                sandboxDeadline,
+		sandboxLimitErr:                sandboxLimitErr,
+		errnoModule:                    errnoModule,
+		etcGroupIterating:              etcGroupIterating,
+		fiberTerminationResult:         fiberTerminationResult,
+		fileUtimeOverrides:             fileUtimeOverrides,
+		formerAutoloadNames:            formerAutoloadNames,
+		gcAutoCompact:                  gcAutoCompact,
+		gcConfigValues:                 gcConfigValues,
+		gcCountValue:                   gcCountValue,
+		gcDisabled:                     gcDisabled,
+		gcMajorCountValue:              gcMajorCountValue,
+		gcMeasureTotalTime:             gcMeasureTotalTime,
+		gcProfilerEnabled:              gcProfilerEnabled,
+		gcStress:                       gcStress,
+		gcTotalTimeValue:               gcTotalTimeValue,
+		gemConfigurationValue:          gemConfigurationValue,
+		globalVariableAliases:          globalVariableAliases,
+		globalVariableTraces:           globalVariableTraces,
+		hashIdentityClassKeys:          hashIdentityClassKeys,
+		hashIdentityIntegerKeys:        hashIdentityIntegerKeys,
+		hashIdentityModuleKeys:         hashIdentityModuleKeys,
+		hashIdentitySymbolKeys:         hashIdentitySymbolKeys,
+		internedStringSymbols:          internedStringSymbols,
+		ioBufferValues:                 ioBufferValues,
+		ioDataByFd:                     ioDataByFd,
+		ioFIFOStates:                   ioFIFOStates,
+		ioPipeBuffers:                  ioPipeBuffers,
+		ioPopenStates:                  ioPopenStates,
+		kernelModuleView:               kernelModuleView,
+		kernelRand:                     kernelRand,
+		kernelRandSeed:                 kernelRandSeed,
+		kernelRubyRand:                 kernelRubyRand,
+		lastMockToAryReturn:            lastMockToAryReturn,
+		lazyArrayRegions:               lazyArrayRegions,
+		loadingFeatureOwners:           loadingFeatureOwners,
+		loadingFeatureWaiters:          loadingFeatureWaiters,
+		loadingFeatures:                loadingFeatures,
+		mainThread:                     mainThread,
+		nextIOFd:                       nextIOFd,
+		numericBigIntOverrides:         numericBigIntOverrides,
+		numericFloatOverrides:          numericFloatOverrides,
+		objectIDByClass:                objectIDByClass,
+		objectIDByInteger:              objectIDByInteger,
+		objectIDByModule:               objectIDByModule,
+		objectIDBySymbol:               objectIDBySymbol,
+		objectIDByValue:                objectIDByValue,
+		objectIDCounter:                objectIDCounter,
+		objectSpaceFinalizers:          objectSpaceFinalizers,
+		objectSpaceTotalAllocated:      objectSpaceTotalAllocated,
+		objectSpaceTraceDepth:          objectSpaceTraceDepth,
+		objectSpaceTraceRetained:       objectSpaceTraceRetained,
+		objectSpaceTracked:             objectSpaceTracked,
+		objectSpaceTrackedSinceCompact: objectSpaceTrackedSinceCompact,
+		observableValues:               observableValues,
+		packedPointerStrings:           packedPointerStrings,
+		pendingThreads:                 pendingThreads,
+		procRuby2KeywordFunctions:      procRuby2KeywordFunctions,
+		processArgv0:                   processArgv0,
+		processChildren:                processChildren,
+		processDaemonPID:               processDaemonPID,
+		processDaemonPgroup:            processDaemonPgroup,
+		processGroups:                  processGroups,
+		processNextPID:                 processNextPID,
+		processPriorities:              processPriorities,
+		processTitle:                   processTitle,
+		refinementModules:              refinementModules,
+		regexpDefaultTimeout:           regexpDefaultTimeout,
+		requiredFeatureAliases:         requiredFeatureAliases,
+		requiredFeatures:               requiredFeatures,
+		ripperStates:                   ripperStates,
+		scratchPadRecorded:             scratchPadRecorded,
+		setTraceFuncValue:              setTraceFuncValue,
+		signalExceptionNumbers:         signalExceptionNumbers,
+		signalTraps:                    signalTraps,
+		skipAtExitHooks:                skipAtExitHooks,
+		specTimeLocation:               specTimeLocation,
+		stderrObject:                   stderrObject,
+		stdinObject:                    stdinObject,
+		stdoutObject:                   stdoutObject,
+		suppressIOPipeBlock:            suppressIOPipeBlock,
+		symbolEncodings:                symbolEncodings,
+		symbolNameStrings:              symbolNameStrings,
+		syslogState:                    syslogState,
+		threadBacktraceLimit:           threadBacktraceLimit,
+		threadIgnoreDeadlock:           threadIgnoreDeadlock,
+		threadTerminationResult:        threadTerminationResult,
+		timedThreads:                   timedThreads,
+		ulebPackOverrides:              ulebPackOverrides,
+		weakRefValues:                  weakRefValues,
+	}
+}
+
+// Restore makes state the current interpreter state.
+func (state *RuntimeState) Restore() {
+	R = state.R
+	CurrentEvalSource = state.CurrentEvalSource
+	CurrentTopLevelMain = state.CurrentTopLevelMain
+	CurrentEvalSourceEncoding = state.CurrentEvalSourceEncoding
+	CurrentSpecFile = state.CurrentSpecFile
+	CurrentSpecFileAbsolute = state.CurrentSpecFileAbsolute
+	LastBlockResult = state.LastBlockResult
+	LastException = state.LastException
+	LastRaisedResult = state.LastRaisedResult
+	LastMatcherException = state.LastMatcherException
+	activeTracePoints = state.activeTracePoints
+	tracePointDispatching = state.tracePointDispatching
+	allThreads = state.allThreads
+	argfClassValue = state.argfClassValue
+	atExitHooks = state.atExitHooks
+	attachedSingletonClasses = state.attachedSingletonClasses
+	autoloadRegistrations = state.autoloadRegistrations
+	autoloadRequireDepth = state.autoloadRequireDepth
+	berPackOverrides = state.berPackOverrides
+	builtinOutputCapture = state.builtinOutputCapture
+	constantNameEncodings = state.constantNameEncodings
+	coverage = state.coverage
+	currentFiber = state.currentFiber
+	currentFileUmask = state.currentFileUmask
+	currentThread = state.currentThread
+	deduplicatedStrings = state.deduplicatedStrings
+	defaultExternalEncoding = state.defaultExternalEncoding
+	defaultThreadGroup = state.defaultThreadGroup
+	drbCurrentServer = state.drbCurrentServer
+	encodingValues = state.encodingValues
+	endHookSites = state.endHookSites
+	envObject = state.envObject
+	Sandbox = state.Sandbox
+	sandboxDeadline = state.sandboxDeadline
+	sandboxLimitErr = state.sandboxLimitErr
+	errnoModule = state.errnoModule
+	etcGroupIterating = state.etcGroupIterating
+	fiberTerminationResult = state.fiberTerminationResult
+	fileUtimeOverrides = state.fileUtimeOverrides
+	formerAutoloadNames = state.formerAutoloadNames
+	gcAutoCompact = state.gcAutoCompact
+	gcConfigValues = state.gcConfigValues
+	gcCountValue = state.gcCountValue
+	gcDisabled = state.gcDisabled
+	gcMajorCountValue = state.gcMajorCountValue
+	gcMeasureTotalTime = state.gcMeasureTotalTime
+	gcProfilerEnabled = state.gcProfilerEnabled
+	gcStress = state.gcStress
+	gcTotalTimeValue = state.gcTotalTimeValue
+	gemConfigurationValue = state.gemConfigurationValue
+	globalVariableAliases = state.globalVariableAliases
+	globalVariableTraces = state.globalVariableTraces
+	hashIdentityClassKeys = state.hashIdentityClassKeys
+	hashIdentityIntegerKeys = state.hashIdentityIntegerKeys
+	hashIdentityModuleKeys = state.hashIdentityModuleKeys
+	hashIdentitySymbolKeys = state.hashIdentitySymbolKeys
+	internedStringSymbols = state.internedStringSymbols
+	ioBufferValues = state.ioBufferValues
+	ioDataByFd = state.ioDataByFd
+	ioFIFOStates = state.ioFIFOStates
+	ioPipeBuffers = state.ioPipeBuffers
+	ioPopenStates = state.ioPopenStates
+	kernelModuleView = state.kernelModuleView
+	kernelRand = state.kernelRand
+	kernelRandSeed = state.kernelRandSeed
+	kernelRubyRand = state.kernelRubyRand
+	lastMockToAryReturn = state.lastMockToAryReturn
+	lazyArrayRegions = state.lazyArrayRegions
+	loadingFeatureOwners = state.loadingFeatureOwners
+	loadingFeatureWaiters = state.loadingFeatureWaiters
+	loadingFeatures = state.loadingFeatures
+	mainThread = state.mainThread
+	nextIOFd = state.nextIOFd
+	numericBigIntOverrides = state.numericBigIntOverrides
+	numericFloatOverrides = state.numericFloatOverrides
+	objectIDByClass = state.objectIDByClass
+	objectIDByInteger = state.objectIDByInteger
+	objectIDByModule = state.objectIDByModule
+	objectIDBySymbol = state.objectIDBySymbol
+	objectIDByValue = state.objectIDByValue
+	objectIDCounter = state.objectIDCounter
+	objectSpaceFinalizers = state.objectSpaceFinalizers
+	objectSpaceTotalAllocated = state.objectSpaceTotalAllocated
+	objectSpaceTraceDepth = state.objectSpaceTraceDepth
+	objectSpaceTraceRetained = state.objectSpaceTraceRetained
+	objectSpaceTracked = state.objectSpaceTracked
+	objectSpaceTrackedSinceCompact = state.objectSpaceTrackedSinceCompact
+	observableValues = state.observableValues
+	packedPointerStrings = state.packedPointerStrings
+	pendingThreads = state.pendingThreads
+	procRuby2KeywordFunctions = state.procRuby2KeywordFunctions
+	processArgv0 = state.processArgv0
+	processChildren = state.processChildren
+	processDaemonPID = state.processDaemonPID
+	processDaemonPgroup = state.processDaemonPgroup
+	processGroups = state.processGroups
+	processNextPID = state.processNextPID
+	processPriorities = state.processPriorities
+	processTitle = state.processTitle
+	refinementModules = state.refinementModules
+	regexpDefaultTimeout = state.regexpDefaultTimeout
+	requiredFeatureAliases = state.requiredFeatureAliases
+	requiredFeatures = state.requiredFeatures
+	ripperStates = state.ripperStates
+	scratchPadRecorded = state.scratchPadRecorded
+	setTraceFuncValue = state.setTraceFuncValue
+	signalExceptionNumbers = state.signalExceptionNumbers
+	signalTraps = state.signalTraps
+	skipAtExitHooks = state.skipAtExitHooks
+	specTimeLocation = state.specTimeLocation
+	stderrObject = state.stderrObject
+	stdinObject = state.stdinObject
+	stdoutObject = state.stdoutObject
+	suppressIOPipeBlock = state.suppressIOPipeBlock
+	symbolEncodings = state.symbolEncodings
+	symbolNameStrings = state.symbolNameStrings
+	syslogState = state.syslogState
+	threadBacktraceLimit = state.threadBacktraceLimit
+	threadIgnoreDeadlock = state.threadIgnoreDeadlock
+	threadTerminationResult = state.threadTerminationResult
+	timedThreads = state.timedThreads
+	ulebPackOverrides = state.ulebPackOverrides
+	weakRefValues = state.weakRefValues
+}
@@ -0,0 +1,334 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// SandboxPolicy restricts what Ruby code may do. The zero value denies every
+// capability and sets no resource limits. Builtin libraries implemented in
+// Go stay requirable unless they exist only to reach a denied capability.
+type SandboxPolicy struct {
+	// AllowFileSystem permits File, Dir, FileTest and IO operations that
+	// open or inspect paths, Kernel#open and Kernel#test.
+	AllowFileSystem bool
+	// AllowProcess permits backticks, system, exec, spawn, fork, IO.popen
+	// and the Process functions that create or signal processes.
+	AllowProcess bool
+	// AllowNetwork permits sockets and the network libraries.
+	AllowNetwork bool
+	// AllowEnv permits reading and writing ENV.
+	AllowEnv bool
+	// AllowRequire permits require, require_relative and load of Ruby
+	// files from disk.
+	AllowRequire bool
+	// MemoryLimit caps the Go heap, in bytes, while Ruby code runs. Zero
+	// means unlimited. The heap is shared by everything in the process, so
+	// embedders should leave headroom for the host.
+	MemoryLimit uint64
+	// Timeout bounds the wall-clock time of one run. Zero means unlimited.
+	Timeout time.Duration
+}
+
+// Sandbox capability names used in SecurityError messages.
+const (
+	sandboxFileSystem = "file system access"
+	sandboxProcess    = "process control"
+	sandboxNetwork    = "network access"
+	sandboxEnv        = "environment access"
+	sandboxRequire    = "loading files"
+)
+
+// Sandbox is the policy in force, or nil when Ruby code is unrestricted.
+var Sandbox *SandboxPolicy
+
+var sandboxDeadline time.Time
+var sandboxLimitErr error
+
+// SandboxLimitError reports that a sandboxed run exceeded its memory or
+// wall-clock budget. It is returned as a Go error rather than raised, so
+// Ruby code cannot rescue it.
+type SandboxLimitError struct {
+	Limit string
+}
+
+func (e *SandboxLimitError) Error() string {
+	return "sandbox " + e.Limit + " exceeded"
+}
+
+// sandboxGuard lists the builtin methods of some classes that one
+// capability controls. "*" guards every method in the table.
+type sandboxGuard struct {
+	capability      string
+	classes         []string
+	classMethods    []string
+	instanceMethods []string
+}
+
+var sandboxGuards = []sandboxGuard{
+	{sandboxFileSystem, []string{"Object", "Kernel"}, []string{"open", "test"}, []string{"open", "test"}},
+	{sandboxFileSystem, []string{"File"}, []string{
+		"absolute_path", "absolute_path?", "atime", "binread", "binwrite", "birthtime", "chmod", "chown", "ctime", "delete",
+		"directory?", "empty?", "executable?", "executable_real?", "exist?", "expand_path", "file?", "ftype", "identical?",
+		"link", "lstat", "lutime", "mkfifo", "mtime", "new", "open", "read", "readable?", "readlines", "readlink",
+		"realdirpath", "realpath", "rename", "size", "size?", "stat", "symlink", "truncate", "umask", "unlink", "utime",
+		"world_readable?", "world_writable?", "writable?", "writable_real?", "write", "zero?",
+	}, nil},
+	{sandboxFileSystem, []string{"Dir", "FileTest", "File::Stat"}, []string{"*"}, nil},
+	{sandboxFileSystem, []string{"IO"}, []string{"binread", "binwrite", "copy_stream", "for_fd", "foreach", "new", "open", "read", "readlines", "sysopen", "write"}, nil},
+	{sandboxProcess, []string{"Object", "Kernel"}, []string{"`", "system", "exec", "fork", "spawn", "syscall", "trap"}, []string{"`", "system", "exec", "fork", "spawn", "syscall", "trap"}},
+	{sandboxProcess, []string{"Process"}, []string{
+		"_fork", "daemon", "detach", "egid=", "euid=", "exec", "fork", "gid=", "groups=", "initgroups", "kill", "setpgid",
+		"setpgrp", "setpriority", "setproctitle", "setrlimit", "setsid", "spawn", "system", "uid=", "wait", "wait2",
+		"waitall", "waitpid", "waitpid2",
+	}, nil},
+	{sandboxProcess, []string{"IO"}, []string{"popen"}, nil},
+	{sandboxProcess, []string{"Signal"}, []string{"trap"}, nil},
+	{sandboxNetwork, []string{"Socket", "BasicSocket", "TCPSocket", "TCPServer", "UDPSocket", "UNIXSocket", "UNIXServer", "Addrinfo"}, []string{"*"}, nil},
+}
+
+// sandboxFeatures are libraries whose only purpose is a capability; they
+// cannot be required while that capability is denied.
+var sandboxFeatures = map[string]string{
+	"fileutils": sandboxFileSystem, "find": sandboxFileSystem, "pathname": sandboxFileSystem,
+	"tempfile": sandboxFileSystem, "tmpdir": sandboxFileSystem, "pstore": sandboxFileSystem,
+	"open3": sandboxProcess, "pty": sandboxProcess, "etc": sandboxProcess,
+	"socket": sandboxNetwork, "net/http": sandboxNetwork, "open-uri": sandboxNetwork, "resolv": sandboxNetwork, "drb": sandboxNetwork,
+}
+
+// EnableSandbox restricts the current runtime to policy and starts its
+// wall-clock budget. It must be called after Init and before the VM that
+// runs the restricted code is created.
+func EnableSandbox(policy SandboxPolicy) {
+	installed := Sandbox != nil
+	Sandbox = &policy
+	StartSandboxClock()
+	if installed {
+		return
+	}
+	guarded := map[*object.Method]bool{}
+	for _, guard := range sandboxGuards {
+		for _, name := range guard.classes {
+			owner := name
+			if name == "Object" {
+				owner = "Kernel"
+			}
+			classTables, instanceTables := sandboxMethodTables(name)
+			for _, methods := range classTables {
+				guardSandboxMethods(methods, guard.classMethods, guard.capability, owner+".", guarded)
+			}
+			for _, methods := range instanceTables {
+				guardSandboxMethods(methods, guard.instanceMethods, guard.capability, owner+"#", guarded)
+			}
+		}
+	}
+	object.BumpMethodGeneration()
+	if R.Classes["Object"] != nil {
+		R.Classes["Object"].Constants["ENV"] = sandboxEnvObject()
+		object.BumpConstantGeneration()
+	}
+}
+
+// sandboxMethodTables returns the singleton and instance method tables of
+// the builtin class registered as name and of the object its constant
+// currently names, which for modules such as Process is a separate value.
+func sandboxMethodTables(name string) (classTables, instanceTables []map[string]*object.Method) {
+	if class := R.Classes[name]; class != nil {
+		classTables = append(classTables, class.ClassMethods)
+		if class.SingletonClass != nil {
+			classTables = append(classTables, class.SingletonClass.Methods)
+		}
+		instanceTables = append(instanceTables, class.Methods)
+	}
+	value := R.Classes["Object"].Constants[name]
+	if value == nil {
+		return classTables, instanceTables
+	}
+	switch data := value.Data.(type) {
+	case *object.Class:
+		if data != R.Classes[name] {
+			classTables = append(classTables, data.ClassMethods)
+			instanceTables = append(instanceTables, data.Methods)
+		}
+	case *object.Module:
+		instanceTables = append(instanceTables, data.Methods)
+	}
+	if singleton := SingletonClass(value); singleton != nil && singleton.Type == object.ValueClass {
+		classTables = append(classTables, singleton.Data.(*object.Class).Methods)
+	}
+	return classTables, instanceTables
+}
+
+func guardSandboxMethods(methods map[string]*object.Method, names []string, capability, label string, guarded map[*object.Method]bool) {
+	if len(names) == 0 {
+		return
+	}
+	guardAll := len(names) == 1 && names[0] == "*"
+	for name, method := range methods {
+		if guarded[method] || !guardAll && !stringSliceContains(names, name) {
+			continue
+		}
+		native, ok := method.Fn.(func(*object.EmeraldValue, ...*object.EmeraldValue) *object.EmeraldValue)
+		if !ok {
+			continue
+		}
+		wrapper := *method
+		operation := label + name
+		wrapper.Fn = func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+			if denied := sandboxDenied(capability, operation); denied != nil {
+				return denied
+			}
+			return native(receiver, args...)
+		}
+		methods[name] = &wrapper
+		guarded[&wrapper] = true
+	}
+}
+
+// sandboxAllows reports whether the current policy grants capability.
+func sandboxAllows(capability string) bool {
+	if Sandbox == nil {
+		return true
+	}
+	switch capability {
+	case sandboxFileSystem:
+		return Sandbox.AllowFileSystem
+	case sandboxProcess:
+		return Sandbox.AllowProcess
+	case sandboxNetwork:
+		return Sandbox.AllowNetwork
+	case sandboxEnv:
+		return Sandbox.AllowEnv
+	case sandboxRequire:
+		return Sandbox.AllowRequire
+	}
+	return false
+}
+
+// sandboxDenied returns a raised SecurityError when the policy does not
+// grant capability, and nil otherwise.
+func sandboxDenied(capability, operation string) *object.EmeraldValue {
+	if sandboxAllows(capability) {
+		return nil
+	}
+	return newRuntimeException(R.Classes["SecurityError"], fmt.Sprintf("%s is not allowed in the sandbox (%s is disabled)", operation, capability))
+}
+
+// sandboxDeniedFeature checks a require of a library that exists only to
+// reach a denied capability.
+func sandboxDeniedFeature(path string) *object.EmeraldValue {
+	if Sandbox == nil {
+		return nil
+	}
+	if capability, ok := sandboxFeatures[strings.TrimSuffix(path, ".rb")]; ok {
+		return sandboxDenied(capability, "require '"+path+"'")
+	}
+	return nil
+}
+
+// sandboxEnvObject replaces ENV when environment access is denied: every
+// Hash-like operation on it raises SecurityError.
+func sandboxEnvObject() *object.EmeraldValue {
+	if sandboxAllows(sandboxEnv) {
+		return EnvObject()
+	}
+	class := object.NewClass("ENV")
+	class.SuperClass = R.Classes["Object"]
+	for _, name := range []string{
+		"[]", "[]=", "assoc", "clear", "delete", "delete_if", "each", "each_key", "each_pair", "each_value", "empty?",
+		"except", "fetch", "filter", "filter!", "filter_map", "has_key?", "has_value?", "include?", "inspect", "invert",
+		"keep_if", "key", "key?", "keys", "length", "map", "member?", "merge!", "rassoc", "rehash", "reject", "reject!",
+		"replace", "select", "select!", "shift", "size", "slice", "store", "to_a", "to_h", "to_hash", "to_s", "update",
+		"value?", "values", "values_at",
+	} {
+		operation := "ENV." + name
+		class.DefineMethod(name, &object.Method{Name: name, Arity: -1, Fn: func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+			return sandboxDenied(sandboxEnv, operation)
+		}})
+	}
+	return object.NewObjectValue(class)
+}
+
+// StartSandboxClock starts a new wall-clock budget for the sandboxed run
+// about to begin and clears a previous limit violation.
+func StartSandboxClock() {
+	sandboxLimitErr = nil
+	sandboxDeadline = time.Time{}
+	if Sandbox != nil && Sandbox.Timeout > 0 {
+		sandboxDeadline = time.Now().Add(Sandbox.Timeout)
+	}
+}
+
+// SandboxLimited reports whether the policy sets resource limits the VM has
+// to poll for.
+func SandboxLimited() bool {
+	return Sandbox != nil && (Sandbox.Timeout > 0 || Sandbox.MemoryLimit > 0)
+}
+
+var sandboxHeapSample = []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+
+// CheckSandboxLimits returns a *SandboxLimitError once the current run has
+// exhausted its budget. The violation sticks until the next
+// StartSandboxClock so that every VM on the stack unwinds.
+func CheckSandboxLimits() error {
+	if sandboxLimitErr != nil || Sandbox == nil {
+		return sandboxLimitErr
+	}
+	if !sandboxDeadline.IsZero() && time.Now().After(sandboxDeadline) {
+		sandboxLimitErr = &SandboxLimitError{Limit: "timeout of " + Sandbox.Timeout.String()}
+		return sandboxLimitErr
+	}
+	if Sandbox.MemoryLimit > 0 {
+		metrics.Read(sandboxHeapSample)
+		if sandboxHeapSample[0].Value.Kind() == metrics.KindUint64 && sandboxHeapSample[0].Value.Uint64() > Sandbox.MemoryLimit {
+			sandboxLimitErr = &SandboxLimitError{Limit: fmt.Sprintf("memory limit of %d bytes", Sandbox.MemoryLimit)}
+			return sandboxLimitErr
+		}
+	}
+	return nil
+}
+
+// SandboxLimitExceeded returns the limit violation recorded for the current
+// run, if any.
+func SandboxLimitExceeded() error {
+	return sandboxLimitErr
+}
+
+var sandboxTerminationResult *object.EmeraldValue
+
+// SandboxTerminationResult is what a nested run of Ruby code hands back once
+// a limit has tripped. Like thread termination no rescue clause matches it,
+// so the violation unwinds to the host however deep it tripped.
+func SandboxTerminationResult() *object.EmeraldValue {
+	if sandboxTerminationResult == nil {
+		sandboxTerminationResult = &object.EmeraldValue{
+			Type:  object.ValueException,
+			Data:  &object.RException{Message: "sandbox limit exceeded", Raised: true},
+			Class: R.Classes["Exception"],
+		}
+	}
+	return sandboxTerminationResult
+}
+
+func IsSandboxTerminationResult(result *object.EmeraldValue) bool {
+	return result != nil && result == sandboxTerminationResult
+}
+
+// ExecutionErrorResult converts an error from running bytecode into the
+// value a nested call returns in its place: a RuntimeError, or the sandbox
+// termination when the error is a limit violation. The termination is also
+// left in LastException, which is what builtin iterators check between
+// calls to their block.
+func ExecutionErrorResult(err error) *object.EmeraldValue {
+	var limitErr *SandboxLimitError
+	if errors.As(err, &limitErr) {
+		LastException = SandboxTerminationResult()
+		return LastException
+	}
+	return NewRuntimeError(err.Error())
+}
@@ -0,0 +1,334 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/profiler"
+)
+
+// rubyProfile is the Ruby-level sampling profile, started by the
+// RGO_RUBY_PROFILE variable or the StackProf module. While it runs, the
+// interpreter records the Ruby stack whenever a sample falls due.
+var rubyProfile struct {
+	profiler *profiler.Profiler
+	raw      bool
+	out      *object.EmeraldValue
+	last     *profiler.Profile
+}
+
+// RubyProfiler returns the running Ruby-level profile, or nil.
+func RubyProfiler() *profiler.Profiler {
+	return rubyProfile.profiler
+}
+
+// StartRubyProfile starts sampling Ruby stacks on the clock of mode.
+func StartRubyProfile(mode profiler.Mode, interval time.Duration) error {
+	if rubyProfile.profiler != nil {
+		return fmt.Errorf("a Ruby profile is already running")
+	}
+	p, err := profiler.Start(mode, interval)
+	if err != nil {
+		return err
+	}
+	rubyProfile.profiler = p
+	return nil
+}
+
+// StopRubyProfile stops the running Ruby-level profile and returns it, or
+// nil when none runs.
+func StopRubyProfile() *profiler.Profile {
+	if rubyProfile.profiler == nil {
+		return nil
+	}
+	profile := rubyProfile.profiler.Stop()
+	rubyProfile.profiler = nil
+	return profile
+}
+
+func installStackProfModule(objectClass *object.Class) {
+	if objectClass == nil {
+		return
+	}
+	if existing, ok := objectClass.Constants["StackProf"]; ok && existing != nil && existing.Type == object.ValueModule {
+		return
+	}
+	mod := object.NewModule("StackProf")
+	mod.DefineMethod("start", &object.Method{Name: "start", Fn: stackProfStart, Arity: -1})
+	mod.DefineMethod("stop", &object.Method{Name: "stop", Fn: stackProfStop, Arity: 0})
+	mod.DefineMethod("running?", &object.Method{Name: "running?", Fn: stackProfRunning, Arity: 0})
+	mod.DefineMethod("results", &object.Method{Name: "results", Fn: stackProfResults, Arity: -1})
+	mod.DefineMethod("run", &object.Method{Name: "run", Fn: stackProfRun, Arity: -1})
+	modValue := &object.EmeraldValue{Type: object.ValueModule, Data: mod, Class: R.Classes["Module"]}
+	objectClass.DefineConstant("StackProf", modValue)
+	AssignConstantName(&object.EmeraldValue{Type: object.ValueClass, Data: objectClass, Class: R.Classes["Class"]}, "StackProf", modValue)
+}
+
+// stackProfStart implements StackProf.start(mode: :cpu, interval: 1000,
+// raw: false, out: nil), the interval being in microseconds. It returns
+// false when a profile already runs.
+func stackProfStart(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if rubyProfile.profiler != nil {
+		return R.FalseVal
+	}
+	mode, interval := profiler.CPU, profiler.DefaultInterval
+	raw, out := false, (*object.EmeraldValue)(nil)
+	if len(args) > 0 {
+		option := args[len(args)-1]
+		if option == nil || option.Type != object.ValueHash {
+			return NewTypeError("no implicit conversion of " + valueTypeName(option) + " into Hash")
+		}
+		options := valueToHashMap(option)
+		if value, present := hashLookup(options, rubySymbol("mode")); present {
+			if value == nil || value.Type != object.ValueSymbol {
+				return NewArgumentError("unknown profiler mode")
+			}
+			switch name := value.Data.(string); name {
+			case "cpu", "wall":
+				mode = profiler.Mode(name)
+			default:
+				return NewArgumentError("unknown profiler mode")
+			}
+		}
+		if value, present := hashLookup(options, rubySymbol("interval")); present {
+			micros, ok := valueToInteger(value)
+			if !ok || micros <= 0 {
+				return NewArgumentError("interval must be a positive Integer")
+			}
+			interval = time.Duration(micros) * time.Microsecond
+		}
+		if value, present := hashLookup(options, rubySymbol("raw")); present {
+			raw = value.IsTruthy()
+		}
+		if value, present := hashLookup(options, rubySymbol("out")); present && value != R.NilVal {
+			out = value
+		}
+	}
+	if err := StartRubyProfile(mode, interval); err != nil {
+		return NewArgumentError(err.Error())
+	}
+	rubyProfile.raw, rubyProfile.out, rubyProfile.last = raw, out, nil
+	return R.TrueVal
+}
+
+func stackProfStop(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	profile := StopRubyProfile()
+	if profile == nil {
+		return R.FalseVal
+	}
+	rubyProfile.last = profile
+	return R.TrueVal
+}
+
+func stackProfRunning(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if rubyProfile.profiler != nil {
+		return R.TrueVal
+	}
+	return R.FalseVal
+}
+
+// stackProfResults implements StackProf.results(out = nil): the results
+// of the last stopped profile, or nil. They are written to out, or to the
+// out: given to start, as well: a path ending in a profiler format's
+// extension gets that format, any other path or IO the Marshal dump the
+// stackprof command reads.
+func stackProfResults(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	profile := rubyProfile.last
+	if profile == nil || rubyProfile.profiler != nil {
+		return R.NilVal
+	}
+	out := rubyProfile.out
+	if len(args) > 0 && args[0] != R.NilVal {
+		out = args[0]
+	}
+	results := stackProfResultsHash(profile, rubyProfile.raw)
+	rubyProfile.last, rubyProfile.out = nil, nil
+	if out == nil {
+		return results
+	}
+	if out.Type == object.ValueString {
+		if denied := sandboxDenied(sandboxFileSystem, "StackProf.results"); denied != nil {
+			return denied
+		}
+		path := valueToStringValue(out)
+		if format, ok := profiler.FormatOf(path); ok {
+			file, err := os.Create(path)
+			if err != nil {
+				return errnoForPathError(err)
+			}
+			err = profile.Write(file, format)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return newRuntimeException(R.Classes["IOError"], err.Error())
+			}
+			return results
+		}
+	}
+	dump := marshalClassDump(nil, results)
+	if dump == nil || dump.Type == object.ValueException {
+		return dump
+	}
+	if out.Type == object.ValueString {
+		if err := os.WriteFile(valueToStringValue(out), []byte(stringRawValue(dump)), 0o644); err != nil {
+			return errnoForPathError(err)
+		}
+		return results
+	}
+	if written := CallMethod(out, "write", dump); written != nil && written.Type == object.ValueException {
+		return written
+	}
+	return results
+}
+
+// stackProfRun implements StackProf.run(**options) { ... }: start, yield,
+// stop and results.
+func stackProfRun(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+	if BlockGivenCheck == nil || !BlockGivenCheck() || CurrentBlockValue == nil || CallBlockWithArgs == nil {
+		return NewArgumentError("block required")
+	}
+	block := CurrentBlockValue()
+	started := stackProfStart(receiver, args...)
+	if started.Type == object.ValueException {
+		return started
+	}
+	if started != R.TrueVal {
+		// Another profile, such as RGO_RUBY_PROFILE's, owns the sampler.
+		if result := CallBlockWithArgs(block); result != nil && result.Type == object.ValueException {
+			return result
+		}
+		return R.NilVal
+	}
+	result := CallBlockWithArgs(block)
+	stackProfStop(receiver)
+	if result != nil && result.Type == object.ValueException {
+		rubyProfile.last, rubyProfile.out = nil, nil
+		return result
+	}
+	return stackProfResults(receiver)
+}
+
+// stackProfFrame accumulates the counts StackProf reports for a method or
+// block: samples where it is innermost, samples where it is on the stack,
+// both per line, and the samples of the frames it calls.
+type stackProfFrame struct {
+	id       int64
+	frame    profiler.Frame
+	total    int64
+	samples  int64
+	lines    map[int]*[2]int64
+	lineList []int
+	edges    map[int64]int64
+	edgeList []int64
+}
+
+type stackProfFunction struct {
+	name, file string
+	startLine  int
+}
+
+// stackProfResultsHash returns profile in the shape of StackProf.results.
+// Frame ids are small integers rather than addresses.
+func stackProfResultsHash(profile *profiler.Profile, raw bool) *object.EmeraldValue {
+	frames := make(map[stackProfFunction]*stackProfFrame)
+	var order []*stackProfFrame
+	frameFor := func(frame profiler.Frame) *stackProfFrame {
+		key := stackProfFunction{frame.Name, frame.File, frame.StartLine}
+		entry := frames[key]
+		if entry == nil {
+			entry = &stackProfFrame{id: int64(len(order) + 1), frame: frame, lines: make(map[int]*[2]int64), edges: make(map[int64]int64)}
+			frames[key] = entry
+			order = append(order, entry)
+		}
+		return entry
+	}
+	lineCounts := func(entry *stackProfFrame, line int) *[2]int64 {
+		counts := entry.lines[line]
+		if counts == nil {
+			counts = &[2]int64{}
+			entry.lines[line] = counts
+			entry.lineList = append(entry.lineList, line)
+		}
+		return counts
+	}
+	var rawValues []*object.EmeraldValue
+	for _, sample := range profile.Samples {
+		seen := make(map[*stackProfFrame]bool)
+		seenLines := make(map[*[2]int64]bool)
+		// A raw entry is the stack length, the frame ids outermost first
+		// and the sample count.
+		ids := make([]*object.EmeraldValue, len(sample.Stack)+1, len(sample.Stack)+2)
+		ids[0] = NewIntegerValue(int64(len(sample.Stack)))
+		var callee *stackProfFrame
+		for index, frame := range sample.Stack {
+			entry := frameFor(frame)
+			ids[len(sample.Stack)-index] = NewIntegerValue(entry.id)
+			counts := lineCounts(entry, frame.Line)
+			if index == 0 {
+				entry.samples += sample.Count
+				counts[1] += sample.Count
+			}
+			if !seen[entry] {
+				seen[entry] = true
+				entry.total += sample.Count
+			}
+			if !seenLines[counts] {
+				seenLines[counts] = true
+				counts[0] += sample.Count
+			}
+			if callee != nil {
+				if _, ok := entry.edges[callee.id]; !ok {
+					entry.edgeList = append(entry.edgeList, callee.id)
+				}
+				entry.edges[callee.id] += sample.Count
+			}
+			callee = entry
+		}
+		if raw {
+			rawValues = append(rawValues, append(ids, NewIntegerValue(sample.Count))...)
+		}
+	}
+
+	framesHash := emptyHashValue()
+	for _, entry := range order {
+		info := emptyHashValue()
+		hashIndexSet(info, rubySymbol("name"), rubyString(entry.frame.Name))
+		hashIndexSet(info, rubySymbol("file"), rubyString(entry.frame.File))
+		if entry.frame.StartLine > 0 {
+			hashIndexSet(info, rubySymbol("line"), NewIntegerValue(int64(entry.frame.StartLine)))
+		}
+		hashIndexSet(info, rubySymbol("total_samples"), NewIntegerValue(entry.total))
+		hashIndexSet(info, rubySymbol("samples"), NewIntegerValue(entry.samples))
+		if len(entry.edgeList) > 0 {
+			edges := emptyHashValue()
+			for _, id := range entry.edgeList {
+				hashIndexSet(edges, NewIntegerValue(id), NewIntegerValue(entry.edges[id]))
+			}
+			hashIndexSet(info, rubySymbol("edges"), edges)
+		}
+		lines := emptyHashValue()
+		for _, line := range entry.lineList {
+			counts := entry.lines[line]
+			hashIndexSet(lines, NewIntegerValue(int64(line)), coverageArray(NewIntegerValue(counts[0]), NewIntegerValue(counts[1])))
+		}
+		hashIndexSet(info, rubySymbol("lines"), lines)
+		hashIndexSet(framesHash, NewIntegerValue(entry.id), info)
+	}
+
+	results := emptyHashValue()
+	hashIndexSet(results, rubySymbol("version"), newFloat(1.2))
+	hashIndexSet(results, rubySymbol("mode"), rubySymbol(string(profile.Mode)))
+	hashIndexSet(results, rubySymbol("interval"), NewIntegerValue(int64(profile.Interval/time.Microsecond)))
+	hashIndexSet(results, rubySymbol("samples"), NewIntegerValue(profile.Total()))
+	hashIndexSet(results, rubySymbol("gc_samples"), NewIntegerValue(0))
+	hashIndexSet(results, rubySymbol("missed_samples"), NewIntegerValue(0))
+	hashIndexSet(results, rubySymbol("metadata"), emptyHashValue())
+	hashIndexSet(results, rubySymbol("frames"), framesHash)
+	if raw {
+		hashIndexSet(results, rubySymbol("raw"), coverageArray(rawValues...))
+	}
+	return results
+}
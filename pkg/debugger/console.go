@@ -0,0 +1,257 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Console is a frontend reading debugger commands from a terminal. Its
+// command names follow the debug gem's: continue, step, next, finish,
+// break, delete, backtrace, frame, up, down, info, list, p and quit. Any
+// other input is evaluated as Ruby in the selected frame.
+type Console struct {
+	in  *bufio.Reader
+	out io.Writer
+	// Interactive selects terminal behaviour: a prompt is printed and input
+	// is not echoed. Non-interactive consoles echo each command so piped
+	// transcripts read like a terminal session.
+	Interactive bool
+
+	frame   int
+	sources map[string][]string
+}
+
+// NewConsole returns a console reading commands from in and writing to out.
+func NewConsole(in io.Reader, out io.Writer) *Console {
+	interactive := false
+	if file, ok := in.(*os.File); ok {
+		if info, err := file.Stat(); err == nil {
+			interactive = info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return &Console{in: bufio.NewReader(in), out: out, Interactive: interactive, sources: make(map[string][]string)}
+}
+
+// Stopped shows where the program stopped and reads commands until one
+// resumes it. End of input detaches the debugger.
+func (c *Console) Stopped(d *Debugger, stop *Stop) Command {
+	c.frame = 0
+	frames := stop.Program.Frames()
+	fmt.Fprintf(c.out, "Stopped at %s:%d%s\n", stop.Location.File, stop.Location.Line, describeStop(stop))
+	c.list(stop.Location.File, stop.Location.Line)
+	c.printFrame(frames)
+	for {
+		if c.Interactive {
+			fmt.Fprint(c.out, "(rdbg) ")
+		}
+		text, err := c.in.ReadString('\n')
+		if text == "" && err != nil {
+			if c.Interactive {
+				fmt.Fprintln(c.out)
+			}
+			return Detach
+		}
+		if !c.Interactive {
+			fmt.Fprintf(c.out, "(rdbg) %s\n", strings.TrimRight(text, "\r\n"))
+		}
+		command, arg := splitCommand(strings.TrimSpace(text))
+		switch command {
+		case "":
+		case "c", "continue":
+			return Continue
+		case "s", "step":
+			return StepIn
+		case "n", "next":
+			return StepOver
+		case "fin", "finish":
+			return StepOut
+		case "q", "quit", "q!", "quit!", "kill", "kill!":
+			return Kill
+		case "bt", "backtrace", "where":
+			for i, frame := range frames {
+				marker := "  "
+				if i == c.frame {
+					marker = "=>"
+				}
+				fmt.Fprintf(c.out, "%s#%d\t%s at %s:%d\n", marker, i, frame.Name, frame.File, frame.Line)
+			}
+		case "f", "frame", "up", "down":
+			index := c.frame
+			switch command {
+			case "up":
+				index++
+			case "down":
+				index--
+			default:
+				if n, err := strconv.Atoi(arg); err == nil {
+					index = n
+				}
+			}
+			if index < 0 || index >= len(frames) {
+				fmt.Fprintln(c.out, "No such frame")
+				continue
+			}
+			c.frame = index
+			c.list(frames[index].File, frames[index].Line)
+			c.printFrame(frames)
+		case "l", "list":
+			if c.frame < len(frames) {
+				c.list(frames[c.frame].File, frames[c.frame].Line)
+			}
+		case "i", "info":
+			for _, local := range stop.Program.Locals(c.frame) {
+				fmt.Fprintf(c.out, "%s = %s\n", local.Name, local.Value)
+			}
+		case "b", "break":
+			if arg == "" {
+				c.printBreakpoints(d)
+				continue
+			}
+			bp, err := parseBreakpoint(arg, stop.Location.File)
+			if err != nil {
+				fmt.Fprintln(c.out, err)
+				continue
+			}
+			fmt.Fprintf(c.out, "%s\n", describeBreakpoint(*d.SetBreakpoint(bp)))
+		case "del", "delete":
+			if arg == "" {
+				d.ClearBreakpoints()
+				continue
+			}
+			id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+			if err != nil || !d.DeleteBreakpoint(id) {
+				fmt.Fprintf(c.out, "No breakpoint %s\n", arg)
+			}
+		case "h", "help":
+			fmt.Fprint(c.out, consoleHelp)
+		case "p", "pp", "eval", "e":
+			c.evaluate(stop.Program, arg)
+		default:
+			c.evaluate(stop.Program, strings.TrimSpace(text))
+		}
+	}
+}
+
+const consoleHelp = `  c[ontinue]            resume the program
+  s[tep]                stop at the next line, entering calls
+  n[ext]                stop at the next line of this frame
+  fin[ish]              stop once this frame returns
+  b[reak] [file:]line [if cond]
+  b[reak] Class#method [if cond]
+                        set a breakpoint, or list them without an argument
+  del[ete] [id]         delete a breakpoint, or all of them
+  bt, backtrace         show the stack
+  f[rame] n, up, down   select a frame
+  i[nfo]                show the locals of the selected frame
+  l[ist]                show the source around the selected frame
+  p expr                evaluate expr in the selected frame
+  q[uit]                end the program
+`
+
+func (c *Console) evaluate(program Program, source string) {
+	if source == "" {
+		return
+	}
+	result, _, err := program.Evaluate(c.frame, source)
+	if err != nil {
+		fmt.Fprintln(c.out, err)
+		return
+	}
+	fmt.Fprintf(c.out, "=> %s\n", result.Value)
+}
+
+func (c *Console) printFrame(frames []Frame) {
+	if c.frame < len(frames) {
+		frame := frames[c.frame]
+		fmt.Fprintf(c.out, "=>#%d\t%s at %s:%d\n", c.frame, frame.Name, frame.File, frame.Line)
+	}
+}
+
+func (c *Console) printBreakpoints(d *Debugger) {
+	bps := d.Breakpoints()
+	if len(bps) == 0 {
+		fmt.Fprintln(c.out, "No breakpoints")
+	}
+	for _, bp := range bps {
+		fmt.Fprintln(c.out, describeBreakpoint(bp))
+	}
+}
+
+// list prints the lines around line of file, marking line.
+func (c *Console) list(file string, line int) {
+	lines, ok := c.sources[file]
+	if !ok {
+		if data, err := os.ReadFile(file); err == nil {
+			lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		}
+		c.sources[file] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return
+	}
+	first, last := max(line-2, 1), min(line+2, len(lines))
+	fmt.Fprintf(c.out, "[%d, %d] in %s\n", first, last, filepath.Base(file))
+	for n := first; n <= last; n++ {
+		marker := "  "
+		if n == line {
+			marker = "=>"
+		}
+		fmt.Fprintf(c.out, "%s%5d| %s\n", marker, n, lines[n-1])
+	}
+}
+
+func describeStop(stop *Stop) string {
+	switch {
+	case stop.Breakpoint != nil:
+		return fmt.Sprintf(" (breakpoint #%d)", stop.Breakpoint.ID)
+	case stop.Reason == ReasonBreakpoint:
+		return " (binding.break)"
+	}
+	return " (" + stop.Reason + ")"
+}
+
+func describeBreakpoint(bp Breakpoint) string {
+	where := bp.Method
+	if where == "" {
+		where = fmt.Sprintf("%s:%d", bp.File, bp.Line)
+	}
+	if bp.Condition != "" {
+		where += " if " + bp.Condition
+	}
+	return fmt.Sprintf("#%d  %s", bp.ID, where)
+}
+
+// parseBreakpoint reads "file:line", "line" (in file), "Class#method" or
+// "method", each optionally followed by "if condition".
+func parseBreakpoint(spec, file string) (Breakpoint, error) {
+	var bp Breakpoint
+	if index := strings.Index(spec, " if "); index >= 0 {
+		bp.Condition = strings.TrimSpace(spec[index+4:])
+		spec = strings.TrimSpace(spec[:index])
+	}
+	if colon := strings.LastIndex(spec, ":"); colon > 0 {
+		if line, err := strconv.Atoi(spec[colon+1:]); err == nil {
+			bp.File, bp.Line = spec[:colon], line
+			return bp, nil
+		}
+	}
+	if line, err := strconv.Atoi(spec); err == nil {
+		bp.File, bp.Line = file, line
+		return bp, nil
+	}
+	if spec == "" || strings.ContainsAny(spec, " \t") {
+		return bp, fmt.Errorf("Unknown breakpoint %q", spec)
+	}
+	bp.Method = spec
+	return bp, nil
+}
+
+func splitCommand(text string) (string, string) {
+	command, arg, _ := strings.Cut(text, " ")
+	return command, strings.TrimSpace(arg)
+}
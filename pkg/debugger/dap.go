@@ -0,0 +1,396 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Server is a frontend for an editor speaking the Debug Adapter Protocol
+// over one connection. Serve reads the editor's requests on its own
+// goroutine; those that look at the paused program are handed to the
+// program's goroutine, which waits in Stopped until a request resumes it.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	writeMu sync.Mutex
+	seq     int
+
+	debugger      *Debugger
+	configured    chan struct{}
+	configureOnce sync.Once
+	ready         bool
+	endOnce       sync.Once
+
+	mu      sync.Mutex
+	stopped *Stop
+	work    chan func() (Command, bool)
+	// handles maps variablesReference numbers to a frame's locals or to a
+	// value's children. It is only touched on the program's goroutine and
+	// starts afresh at every stop.
+	handles []handle
+}
+
+type handle struct {
+	frame     int
+	reference int
+}
+
+// NewServer returns a server reading requests from in and writing
+// responses and events to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:         bufio.NewReader(in),
+		out:        out,
+		configured: make(chan struct{}),
+		work:       make(chan func() (Command, bool)),
+	}
+}
+
+// Serve handles requests for d until the editor disconnects or the
+// connection ends.
+func (s *Server) Serve(d *Debugger) error {
+	s.debugger = d
+	defer close(s.work)
+	defer s.configure(false)
+	for {
+		payload, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req dapRequest
+		if err := json.Unmarshal(payload, &req); err != nil || req.Type != "request" {
+			continue
+		}
+		done, err := s.handle(req)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// WaitConfigured blocks until the editor has sent configurationDone, and
+// reports false if the connection ended first.
+func (s *Server) WaitConfigured() bool {
+	<-s.configured
+	return s.ready
+}
+
+func (s *Server) configure(ready bool) {
+	s.configureOnce.Do(func() {
+		s.ready = ready
+		close(s.configured)
+	})
+}
+
+// Exited tells the editor the program ended with code.
+func (s *Server) Exited(code int) {
+	s.endOnce.Do(func() {
+		_ = s.event("exited", map[string]int{"exitCode": code})
+		_ = s.event("terminated", nil)
+	})
+}
+
+// Terminated tells the editor the program ended when its exit code is not
+// known.
+func (s *Server) Terminated() {
+	s.endOnce.Do(func() {
+		_ = s.event("terminated", nil)
+	})
+}
+
+// Stopped reports the stop to the editor and serves its requests until one
+// resumes the program. A closed connection detaches the debugger.
+func (s *Server) Stopped(d *Debugger, stop *Stop) Command {
+	s.handles = s.handles[:0]
+	s.mu.Lock()
+	s.stopped = stop
+	s.mu.Unlock()
+	body := stoppedBody{Reason: stop.Reason, ThreadID: threadID, AllThreadsStopped: true}
+	if stop.Breakpoint != nil {
+		body.HitBreakpointIDs = []int{stop.Breakpoint.ID}
+	}
+	_ = s.event("stopped", body)
+	for work := range s.work {
+		if command, resume := work(); resume {
+			return command
+		}
+	}
+	return Detach
+}
+
+// handle answers one request and reports whether the session is over.
+// Only failures to write to the editor are returned.
+func (s *Server) handle(req dapRequest) (bool, error) {
+	d := s.debugger
+	switch req.Command {
+	case "initialize":
+		if err := s.respond(req, capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}); err != nil {
+			return false, err
+		}
+		return false, s.event("initialized", nil)
+	case "launch", "attach":
+		var args launchArguments
+		_ = json.Unmarshal(req.Arguments, &args)
+		if args.StopOnEntry {
+			select {
+			case <-s.configured:
+				d.Pause()
+			default:
+				d.StopOnEntry()
+			}
+		}
+		return false, s.respond(req, nil)
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, s.fail(req, err.Error())
+		}
+		bps := make([]Breakpoint, len(args.Breakpoints))
+		for i, bp := range args.Breakpoints {
+			bps[i] = Breakpoint{Line: bp.Line, Condition: bp.Condition}
+		}
+		body := breakpointsBody{Breakpoints: []dapBreakpoint{}}
+		for _, bp := range d.ReplaceLineBreakpoints(args.Source.Path, bps) {
+			body.Breakpoints = append(body.Breakpoints, dapBreakpoint{ID: bp.ID, Verified: true, Line: bp.Line, Source: &source{Name: filepath.Base(bp.File), Path: bp.File}})
+		}
+		return false, s.respond(req, body)
+	case "setFunctionBreakpoints":
+		var args setFunctionBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, s.fail(req, err.Error())
+		}
+		bps := make([]Breakpoint, len(args.Breakpoints))
+		for i, bp := range args.Breakpoints {
+			bps[i] = Breakpoint{Method: bp.Name, Condition: bp.Condition}
+		}
+		body := breakpointsBody{Breakpoints: []dapBreakpoint{}}
+		for _, bp := range d.ReplaceMethodBreakpoints(bps) {
+			body.Breakpoints = append(body.Breakpoints, dapBreakpoint{ID: bp.ID, Verified: true})
+		}
+		return false, s.respond(req, body)
+	case "setExceptionBreakpoints":
+		return false, s.respond(req, nil)
+	case "configurationDone":
+		if err := s.respond(req, nil); err != nil {
+			return false, err
+		}
+		s.configure(true)
+		return false, nil
+	case "threads":
+		return false, s.respond(req, map[string][]thread{"threads": {{ID: threadID, Name: "main"}}})
+	case "pause":
+		d.Pause()
+		return false, s.respond(req, nil)
+	case "continue":
+		return false, s.resume(req, Continue, map[string]bool{"allThreadsContinued": true})
+	case "next":
+		return false, s.resume(req, StepOver, nil)
+	case "stepIn":
+		return false, s.resume(req, StepIn, nil)
+	case "stepOut":
+		return false, s.resume(req, StepOut, nil)
+	case "stackTrace", "scopes", "variables", "evaluate":
+		return false, s.inspect(req)
+	case "disconnect":
+		var args disconnectArguments
+		_ = json.Unmarshal(req.Arguments, &args)
+		command := Detach
+		if args.TerminateDebuggee {
+			command = Kill
+		}
+		return true, s.end(req, command)
+	case "terminate":
+		return true, s.end(req, Kill)
+	}
+	return false, s.fail(req, "unsupported request "+strconv.Quote(req.Command))
+}
+
+// resume answers req and, if the program is paused, resumes it with
+// command.
+func (s *Server) resume(req dapRequest, command Command, body interface{}) error {
+	s.mu.Lock()
+	stopped := s.stopped != nil
+	s.stopped = nil
+	s.mu.Unlock()
+	if err := s.respond(req, body); err != nil {
+		return err
+	}
+	if stopped {
+		s.work <- func() (Command, bool) { return command, true }
+	}
+	return nil
+}
+
+// end answers a disconnect or terminate request and lets the program go
+// with command, whether it is paused or running.
+func (s *Server) end(req dapRequest, command Command) error {
+	s.mu.Lock()
+	stopped := s.stopped != nil
+	s.stopped = nil
+	s.mu.Unlock()
+	err := s.respond(req, nil)
+	if command == Kill {
+		s.Terminated()
+	}
+	switch {
+	case stopped:
+		s.work <- func() (Command, bool) { return command, true }
+	case command == Kill:
+		s.debugger.Exit(1)
+	default:
+		s.debugger.ClearBreakpoints()
+	}
+	return err
+}
+
+// inspect answers a request about the paused program on the program's
+// goroutine.
+func (s *Server) inspect(req dapRequest) error {
+	s.mu.Lock()
+	stop := s.stopped
+	s.mu.Unlock()
+	if stop == nil {
+		return s.fail(req, "the program is running")
+	}
+	s.work <- func() (Command, bool) {
+		body, err := s.answer(req, stop.Program)
+		if err != nil {
+			_ = s.fail(req, err.Error())
+		} else {
+			_ = s.respond(req, body)
+		}
+		return Continue, false
+	}
+	return nil
+}
+
+func (s *Server) answer(req dapRequest, program Program) (interface{}, error) {
+	switch req.Command {
+	case "stackTrace":
+		var args stackTraceArguments
+		_ = json.Unmarshal(req.Arguments, &args)
+		frames := program.Frames()
+		body := stackTraceBody{StackFrames: []stackFrame{}, TotalFrames: len(frames)}
+		for i := args.StartFrame; i < len(frames); i++ {
+			if args.Levels > 0 && i >= args.StartFrame+args.Levels {
+				break
+			}
+			frame := frames[i]
+			path := absolute(frame.File)
+			body.StackFrames = append(body.StackFrames, stackFrame{ID: i, Name: frame.Name, Source: source{Name: filepath.Base(path), Path: path}, Line: frame.Line, Column: 1})
+		}
+		return body, nil
+	case "scopes":
+		var args scopesArguments
+		_ = json.Unmarshal(req.Arguments, &args)
+		locals := scope{Name: "Locals", PresentationHint: "locals", VariablesReference: s.newHandle(handle{frame: args.FrameID})}
+		return map[string][]scope{"scopes": {locals}}, nil
+	case "variables":
+		var args variablesArguments
+		_ = json.Unmarshal(req.Arguments, &args)
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.handles) {
+			return nil, fmt.Errorf("unknown variablesReference %d", args.VariablesReference)
+		}
+		h := s.handles[args.VariablesReference-1]
+		vars := program.Locals(h.frame)
+		if h.reference != 0 {
+			vars = program.Children(h.reference)
+		}
+		body := []dapVariable{}
+		for _, v := range vars {
+			body = append(body, dapVariable{Name: v.Name, Value: v.Value, Type: v.Type, VariablesReference: s.childHandle(v)})
+		}
+		return map[string][]dapVariable{"variables": body}, nil
+	}
+	var args evaluateArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+	frame := 0
+	if args.FrameID != nil {
+		frame = *args.FrameID
+	}
+	result, _, err := program.Evaluate(frame, args.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateBody{Result: result.Value, Type: result.Type, VariablesReference: s.childHandle(result)}, nil
+}
+
+func (s *Server) newHandle(h handle) int {
+	s.handles = append(s.handles, h)
+	return len(s.handles)
+}
+
+func (s *Server) childHandle(v Variable) int {
+	if v.Reference == 0 {
+		return 0
+	}
+	return s.newHandle(handle{reference: v.Reference})
+}
+
+func (s *Server) readMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("dap: reading header: %v", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("dap: bad Content-Length %q", header.Get("Content-Length"))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(s.in, payload); err != nil {
+		return nil, fmt.Errorf("dap: reading body: %v", err)
+	}
+	return payload, nil
+}
+
+// write frames the message message builds from the next sequence number.
+func (s *Server) write(message func(seq int) interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	body, err := json.Marshal(message(s.seq))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) respond(req dapRequest, body interface{}) error {
+	return s.write(func(seq int) interface{} {
+		return dapResponse{Seq: seq, Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body}
+	})
+}
+
+func (s *Server) fail(req dapRequest, message string) error {
+	return s.write(func(seq int) interface{} {
+		return dapResponse{Seq: seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: message}
+	})
+}
+
+func (s *Server) event(name string, body interface{}) error {
+	return s.write(func(seq int) interface{} {
+		return dapEvent{Seq: seq, Type: "event", Event: name, Body: body}
+	})
+}
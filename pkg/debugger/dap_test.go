@@ -0,0 +1,124 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// dapClient plays the editor's side of a Debug Adapter Protocol session.
+type dapClient struct {
+	t    *testing.T
+	conn net.Conn
+	// reader only frames the adapter's messages.
+	reader *Server
+	seq    int
+}
+
+type dapMessage struct {
+	Type    string          `json:"type"`
+	Command string          `json:"command"`
+	Event   string          `json:"event"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+func (c *dapClient) send(command string, arguments interface{}) {
+	c.t.Helper()
+	c.seq++
+	body, err := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": arguments})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// await reads messages until one is the response to command or the event
+// named command.
+func (c *dapClient) await(command string) dapMessage {
+	c.t.Helper()
+	for {
+		payload, err := c.reader.readMessage()
+		if err != nil {
+			c.t.Fatalf("waiting for %s: %v", command, err)
+		}
+		var message dapMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			c.t.Fatal(err)
+		}
+		if message.Command == command || message.Event == command {
+			return message
+		}
+	}
+}
+
+func TestServerSession(t *testing.T) {
+	editor, adapter := net.Pipe()
+	defer editor.Close()
+	server := NewServer(adapter, adapter)
+	d := New(server)
+	go server.Serve(d)
+	client := &dapClient{t: t, conn: editor, reader: &Server{in: bufio.NewReader(editor)}}
+
+	client.send("initialize", map[string]string{"adapterID": "rgo"})
+	if reply := client.await("initialize"); !reply.Success {
+		t.Fatalf("initialize failed: %s", reply.Message)
+	}
+	client.await("initialized")
+	client.send("launch", map[string]bool{"stopOnEntry": false})
+	client.await("launch")
+	client.send("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": "app.rb"},
+		"breakpoints": []map[string]interface{}{{"line": 2}},
+	})
+	var set breakpointsBody
+	if err := json.Unmarshal(client.await("setBreakpoints").Body, &set); err != nil || len(set.Breakpoints) != 1 || !set.Breakpoints[0].Verified {
+		t.Fatalf("setBreakpoints = %+v, %v", set, err)
+	}
+	client.send("configurationDone", nil)
+	client.await("configurationDone")
+	if !server.WaitConfigured() {
+		t.Fatal("server not configured")
+	}
+
+	finished := make(chan struct{})
+	program := &fakeProgram{frames: []Frame{{Name: "<main>", File: "app.rb", Line: 2}}, locals: map[string]string{"total": "6"}}
+	go func() {
+		defer close(finished)
+		run(d, program, Location{File: "app.rb", Line: 1, Depth: 1, Frame: 1}, Location{File: "app.rb", Line: 2, Depth: 1, Frame: 1})
+		server.Exited(0)
+	}()
+
+	var stopped stoppedBody
+	if err := json.Unmarshal(client.await("stopped").Body, &stopped); err != nil || stopped.Reason != ReasonBreakpoint {
+		t.Fatalf("stopped = %+v, %v", stopped, err)
+	}
+	client.send("stackTrace", map[string]int{"threadId": threadID})
+	var trace stackTraceBody
+	if err := json.Unmarshal(client.await("stackTrace").Body, &trace); err != nil || len(trace.StackFrames) != 1 || trace.StackFrames[0].Name != "<main>" {
+		t.Fatalf("stackTrace = %+v, %v", trace, err)
+	}
+	client.send("scopes", map[string]int{"frameId": trace.StackFrames[0].ID})
+	var scopes struct{ Scopes []scope }
+	if err := json.Unmarshal(client.await("scopes").Body, &scopes); err != nil || len(scopes.Scopes) == 0 {
+		t.Fatalf("scopes = %+v, %v", scopes, err)
+	}
+	client.send("variables", map[string]int{"variablesReference": scopes.Scopes[0].VariablesReference})
+	var variables struct{ Variables []dapVariable }
+	if err := json.Unmarshal(client.await("variables").Body, &variables); err != nil || len(variables.Variables) != 1 || variables.Variables[0].Value != "6" {
+		t.Fatalf("variables = %+v, %v", variables, err)
+	}
+	client.send("evaluate", map[string]interface{}{"expression": "missing", "frameId": 0})
+	if reply := client.await("evaluate"); reply.Success {
+		t.Fatalf("evaluate of an undefined name succeeded")
+	}
+	client.send("continue", map[string]int{"threadId": threadID})
+	client.await("exited")
+	client.await("terminated")
+	<-finished
+}
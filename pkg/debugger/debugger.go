@@ -0,0 +1,410 @@
+// Package debugger pauses a running Ruby program at breakpoints and steps
+// through it line by line. It knows nothing of the VM: the interpreter
+// reports each new line it runs to Line, and when the program should stop
+// hands the Frontend a Program to inspect. Console drives a session from a
+// terminal and Server from an editor speaking the Debug Adapter Protocol.
+package debugger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Frame is one Ruby frame of a paused program.
+type Frame struct {
+	// Name is the frame's backtrace label, such as "Report#render" or
+	// "block in <main>".
+	Name string
+	File string
+	Line int
+}
+
+// Variable is a value shown while the program is paused.
+type Variable struct {
+	Name string
+	// Value is the value's inspect string and Type the name of its class.
+	Value string
+	Type  string
+	// Reference is non-zero when the value has elements, pairs or instance
+	// variables for Program.Children to list.
+	Reference int
+}
+
+// Program is the paused program. Frame 0 is the innermost frame. Its
+// methods may only be called on the program's goroutine, before the
+// Frontend returns from Stopped.
+type Program interface {
+	Frames() []Frame
+	Locals(frame int) []Variable
+	Children(reference int) []Variable
+	// Evaluate runs Ruby source in the binding of frame and reports whether
+	// the result is truthy. An exception it raises is returned as the error.
+	Evaluate(frame int, source string) (Variable, bool, error)
+}
+
+// Location is a line the program is about to run.
+type Location struct {
+	File string
+	Line int
+	// Depth is the number of Ruby frames on the stack, which stepping
+	// compares to tell calls from returns, and Frame identifies the frame.
+	Depth int
+	Frame int
+}
+
+// Breakpoint stops the program at a line of a file or on entering a
+// method, whenever Condition, if any, evaluates truthy there.
+type Breakpoint struct {
+	ID        int
+	File      string
+	Line      int
+	Method    string
+	Condition string
+	// Hits counts the times the breakpoint stopped the program.
+	Hits int
+}
+
+// Command resumes a paused program.
+type Command int
+
+const (
+	Continue Command = iota
+	// StepIn stops at the next line run, in this frame or a callee.
+	StepIn
+	// StepOver stops at the next line of this frame, or of a caller once
+	// this frame returns.
+	StepOver
+	// StepOut stops at the next line a caller runs.
+	StepOut
+	// Detach removes every breakpoint and lets the program run to its end.
+	Detach
+	// Kill ends the program.
+	Kill
+)
+
+// Reasons a program stops, named as the Debug Adapter Protocol names them.
+const (
+	ReasonBreakpoint         = "breakpoint"
+	ReasonFunctionBreakpoint = "function breakpoint"
+	ReasonStep               = "step"
+	ReasonPause              = "pause"
+	ReasonEntry              = "entry"
+)
+
+// Stop describes why and where the program paused. Breakpoint is nil when
+// the program stopped after a step, on a pause or on an explicit
+// binding.break.
+type Stop struct {
+	Reason     string
+	Breakpoint *Breakpoint
+	Location   Location
+	Program    Program
+}
+
+// Frontend shows a paused program to the user.
+type Frontend interface {
+	// Stopped is called on the program's goroutine when it pauses and
+	// returns the command that resumes it.
+	Stopped(d *Debugger, stop *Stop) Command
+}
+
+// Debugger holds the breakpoints and stepping state of a debug session.
+type Debugger struct {
+	frontend Frontend
+	// Exit ends the program on a Kill command. It defaults to os.Exit.
+	Exit func(code int)
+
+	mu          sync.Mutex
+	breakpoints []*Breakpoint
+	lines       map[int][]*Breakpoint
+	methods     int
+	nextID      int
+	paused      atomic.Bool
+
+	// The stepping state is only touched on the program's goroutine.
+	step      Command
+	stepFrom  Location
+	entry     bool
+	detached  bool
+	busy      bool
+	lastFrame Location
+	files     map[string]string
+}
+
+// New returns a debugger showing stops on frontend.
+func New(frontend Frontend) *Debugger {
+	return &Debugger{frontend: frontend, Exit: os.Exit, lines: make(map[int][]*Breakpoint), files: make(map[string]string)}
+}
+
+// StopOnEntry makes the program stop at the first line it runs.
+func (d *Debugger) StopOnEntry() {
+	d.entry = true
+}
+
+// Pause stops the program at the next line it runs. It may be called from
+// any goroutine.
+func (d *Debugger) Pause() {
+	d.paused.Store(true)
+}
+
+// SetBreakpoint adds bp and returns it with its ID assigned. A relative
+// file is resolved against the working directory.
+func (d *Debugger) SetBreakpoint(bp Breakpoint) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.add(bp)
+}
+
+func (d *Debugger) add(bp Breakpoint) *Breakpoint {
+	d.nextID++
+	bp.ID = d.nextID
+	if bp.File != "" {
+		bp.File = absolute(bp.File)
+	}
+	added := &bp
+	d.breakpoints = append(d.breakpoints, added)
+	if bp.Method != "" {
+		d.methods++
+	} else {
+		d.lines[bp.Line] = append(d.lines[bp.Line], added)
+	}
+	return added
+}
+
+// DeleteBreakpoint removes the breakpoint with id and reports whether
+// there was one.
+func (d *Debugger) DeleteBreakpoint(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.remove(func(bp *Breakpoint) bool { return bp.ID == id }) > 0
+}
+
+// remove drops the breakpoints match selects and returns how many it
+// dropped.
+func (d *Debugger) remove(match func(*Breakpoint) bool) int {
+	kept := d.breakpoints[:0]
+	removed := 0
+	for _, bp := range d.breakpoints {
+		if match(bp) {
+			removed++
+			continue
+		}
+		kept = append(kept, bp)
+	}
+	d.breakpoints = kept
+	d.lines = make(map[int][]*Breakpoint)
+	d.methods = 0
+	for _, bp := range kept {
+		if bp.Method != "" {
+			d.methods++
+		} else {
+			d.lines[bp.Line] = append(d.lines[bp.Line], bp)
+		}
+	}
+	return removed
+}
+
+// ClearBreakpoints removes every breakpoint.
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.remove(func(*Breakpoint) bool { return true })
+}
+
+// ReplaceLineBreakpoints replaces the line breakpoints of file with bps,
+// as an editor sets them file by file.
+func (d *Debugger) ReplaceLineBreakpoints(file string, bps []Breakpoint) []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	file = absolute(file)
+	d.remove(func(bp *Breakpoint) bool { return bp.Method == "" && bp.File == file })
+	added := make([]*Breakpoint, 0, len(bps))
+	for _, bp := range bps {
+		bp.File, bp.Method = file, ""
+		added = append(added, d.add(bp))
+	}
+	return added
+}
+
+// ReplaceMethodBreakpoints replaces every method breakpoint with bps.
+func (d *Debugger) ReplaceMethodBreakpoints(bps []Breakpoint) []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.remove(func(bp *Breakpoint) bool { return bp.Method != "" })
+	added := make([]*Breakpoint, 0, len(bps))
+	for _, bp := range bps {
+		bp.File, bp.Line = "", 0
+		added = append(added, d.add(bp))
+	}
+	return added
+}
+
+// Breakpoints returns a copy of the breakpoints in the order they were set.
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bps := make([]Breakpoint, len(d.breakpoints))
+	for i, bp := range d.breakpoints {
+		bps[i] = *bp
+	}
+	return bps
+}
+
+// Line is called by the interpreter before it runs a new line. program is
+// only called when the program stops, or a condition or method name must
+// be looked at.
+func (d *Debugger) Line(loc Location, program func() Program) {
+	if d.busy || d.detached {
+		return
+	}
+	entered := loc.Frame != d.lastFrame.Frame && loc.Depth >= d.lastFrame.Depth
+	d.lastFrame = loc
+	var current Program
+	lazy := func() Program {
+		if current == nil {
+			current = program()
+		}
+		return current
+	}
+	stop := d.breakpointAt(loc, entered, lazy)
+	if stop == nil {
+		switch {
+		case d.entry:
+			stop = &Stop{Reason: ReasonEntry}
+		case d.paused.Load():
+			stop = &Stop{Reason: ReasonPause}
+		case d.stepDone(loc):
+			stop = &Stop{Reason: ReasonStep}
+		default:
+			return
+		}
+	}
+	stop.Location, stop.Program = loc, lazy()
+	d.stop(stop)
+}
+
+// Break stops the program where binding.break or Kernel#debugger was
+// called.
+func (d *Debugger) Break(loc Location, program Program) {
+	if d.busy || d.detached {
+		return
+	}
+	d.lastFrame = loc
+	d.stop(&Stop{Reason: ReasonBreakpoint, Location: loc, Program: program})
+}
+
+// breakpointAt returns the stop for the first breakpoint at loc whose
+// condition holds, or nil.
+func (d *Debugger) breakpointAt(loc Location, entered bool, program func() Program) *Stop {
+	d.mu.Lock()
+	candidates := d.lines[loc.Line]
+	methods := d.methods
+	d.mu.Unlock()
+	var matched []*Breakpoint
+	if len(candidates) > 0 {
+		file := d.absolute(loc.File)
+		for _, bp := range candidates {
+			if bp.File == file {
+				matched = append(matched, bp)
+			}
+		}
+	}
+	reason := ReasonBreakpoint
+	if len(matched) == 0 && entered && methods > 0 {
+		frames := program().Frames()
+		if len(frames) == 0 {
+			return nil
+		}
+		d.mu.Lock()
+		for _, bp := range d.breakpoints {
+			if bp.Method != "" && methodMatches(bp.Method, frames[0].Name) {
+				matched = append(matched, bp)
+			}
+		}
+		d.mu.Unlock()
+		reason = ReasonFunctionBreakpoint
+	}
+	for _, bp := range matched {
+		if bp.Condition != "" {
+			d.busy = true
+			_, truthy, err := program().Evaluate(0, bp.Condition)
+			d.busy = false
+			if err != nil || !truthy {
+				continue
+			}
+		}
+		d.mu.Lock()
+		bp.Hits++
+		d.mu.Unlock()
+		return &Stop{Reason: reason, Breakpoint: bp}
+	}
+	return nil
+}
+
+// stepDone reports whether the step in progress ends at loc.
+func (d *Debugger) stepDone(loc Location) bool {
+	from := d.stepFrom
+	// A block run again by its iterator comes back to the same line in a
+	// new frame at the same depth.
+	moved := loc.Line != from.Line || loc.File != from.File || loc.Frame != from.Frame
+	switch d.step {
+	case StepIn:
+		return moved || loc.Depth != from.Depth
+	case StepOver:
+		return loc.Depth < from.Depth || loc.Depth == from.Depth && moved
+	case StepOut:
+		return loc.Depth < from.Depth
+	}
+	return false
+}
+
+func (d *Debugger) stop(stop *Stop) {
+	d.entry = false
+	d.paused.Store(false)
+	d.step = Continue
+	d.busy = true
+	command := d.frontend.Stopped(d, stop)
+	d.busy = false
+	switch command {
+	case StepIn, StepOver, StepOut:
+		d.step, d.stepFrom = command, stop.Location
+	case Detach:
+		d.detached = true
+		d.ClearBreakpoints()
+	case Kill:
+		d.Exit(1)
+	}
+}
+
+// absolute returns the absolute form of a file the interpreter reports,
+// remembering it since the same few files come up on every line.
+func (d *Debugger) absolute(file string) string {
+	if resolved, ok := d.files[file]; ok {
+		return resolved
+	}
+	resolved := absolute(file)
+	d.files[file] = resolved
+	return resolved
+}
+
+func absolute(file string) string {
+	if resolved, err := filepath.Abs(file); err == nil {
+		return resolved
+	}
+	return filepath.Clean(file)
+}
+
+// methodMatches reports whether the breakpoint method, "render",
+// "Report#render" or "Report.build", names the frame label.
+func methodMatches(method, label string) bool {
+	if method == label {
+		return true
+	}
+	if strings.ContainsAny(method, "#.") {
+		return false
+	}
+	return strings.HasSuffix(label, "#"+method) || strings.HasSuffix(label, "."+method)
+}
@@ -0,0 +1,212 @@
+package debugger
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeProgram is a paused program with one frame whose locals are fixed.
+// Evaluate knows the expressions the tests use as conditions.
+type fakeProgram struct {
+	frames []Frame
+	locals map[string]string
+}
+
+func (p *fakeProgram) Frames() []Frame { return p.frames }
+
+func (p *fakeProgram) Locals(frame int) []Variable {
+	var locals []Variable
+	for name, value := range p.locals {
+		locals = append(locals, Variable{Name: name, Value: value})
+	}
+	return locals
+}
+
+func (p *fakeProgram) Children(reference int) []Variable { return nil }
+
+func (p *fakeProgram) Evaluate(frame int, source string) (Variable, bool, error) {
+	if name, value, ok := strings.Cut(source, " == "); ok {
+		return Variable{}, p.locals[name] == value, nil
+	}
+	if value, ok := p.locals[source]; ok {
+		return Variable{Value: value}, true, nil
+	}
+	return Variable{}, false, errors.New("undefined local variable or method `" + source + "' (NameError)")
+}
+
+// recorder is a frontend answering every stop with the next of its
+// commands.
+type recorder struct {
+	commands []Command
+	stops    []Stop
+}
+
+func (r *recorder) Stopped(d *Debugger, stop *Stop) Command {
+	r.stops = append(r.stops, *stop)
+	if len(r.commands) == 0 {
+		return Continue
+	}
+	command := r.commands[0]
+	r.commands = r.commands[1:]
+	return command
+}
+
+// run reports each location to d as the interpreter would, with program
+// naming the frame and holding locals.
+func run(d *Debugger, program *fakeProgram, locations ...Location) {
+	for _, loc := range locations {
+		d.Line(loc, func() Program { return program })
+	}
+}
+
+func TestLineBreakpointStopsOnlyWhenConditionHolds(t *testing.T) {
+	frontend := &recorder{}
+	d := New(frontend)
+	bp := d.SetBreakpoint(Breakpoint{File: "app.rb", Line: 3, Condition: "i == 2"})
+
+	i := &fakeProgram{frames: []Frame{{Name: "<main>", File: "app.rb", Line: 3}}, locals: map[string]string{"i": "1"}}
+	run(d, i, Location{File: "app.rb", Line: 2, Depth: 1, Frame: 1}, Location{File: "app.rb", Line: 3, Depth: 1, Frame: 1})
+	i.locals["i"] = "2"
+	run(d, i, Location{File: "app.rb", Line: 3, Depth: 1, Frame: 1}, Location{File: "other.rb", Line: 3, Depth: 1, Frame: 1})
+
+	if len(frontend.stops) != 1 {
+		t.Fatalf("stops = %d, want 1", len(frontend.stops))
+	}
+	stop := frontend.stops[0]
+	if stop.Reason != ReasonBreakpoint || stop.Breakpoint.ID != bp.ID || stop.Location.Line != 3 {
+		t.Fatalf("stop = %+v", stop)
+	}
+	if hits := d.Breakpoints()[0].Hits; hits != 1 {
+		t.Fatalf("hits = %d, want 1", hits)
+	}
+	if want, _ := filepath.Abs("app.rb"); bp.File != want {
+		t.Fatalf("breakpoint file = %q, want %q", bp.File, want)
+	}
+}
+
+func TestMethodBreakpointStopsOnEntry(t *testing.T) {
+	frontend := &recorder{}
+	d := New(frontend)
+	d.SetBreakpoint(Breakpoint{Method: "render"})
+
+	main := &fakeProgram{frames: []Frame{{Name: "<main>"}}}
+	render := &fakeProgram{frames: []Frame{{Name: "Report#render"}, {Name: "<main>"}}}
+	run(d, main, Location{File: "app.rb", Line: 10, Depth: 1, Frame: 1})
+	run(d, render, Location{File: "app.rb", Line: 4, Depth: 2, Frame: 2}, Location{File: "app.rb", Line: 5, Depth: 2, Frame: 2})
+	run(d, main, Location{File: "app.rb", Line: 11, Depth: 1, Frame: 1})
+
+	if len(frontend.stops) != 1 {
+		t.Fatalf("stops = %d, want 1", len(frontend.stops))
+	}
+	if stop := frontend.stops[0]; stop.Reason != ReasonFunctionBreakpoint || stop.Location.Line != 4 {
+		t.Fatalf("stop = %+v", stop)
+	}
+}
+
+func TestSteppingFollowsDepth(t *testing.T) {
+	program := &fakeProgram{frames: []Frame{{Name: "<main>"}}}
+	main := func(line int) Location { return Location{File: "app.rb", Line: line, Depth: 1, Frame: 1} }
+	call := func(line int) Location { return Location{File: "app.rb", Line: line, Depth: 2, Frame: 2} }
+	trace := []Location{main(1), main(2), call(6), call(7), main(3)}
+
+	tests := []struct {
+		command Command
+		lines   []int
+	}{
+		{StepIn, []int{1, 2, 6, 7, 3}},
+		{StepOver, []int{1, 2, 3}},
+		{Continue, []int{1}},
+	}
+	for _, tt := range tests {
+		frontend := &recorder{commands: []Command{tt.command, tt.command, tt.command, tt.command}}
+		d := New(frontend)
+		d.StopOnEntry()
+		run(d, program, trace...)
+		var lines []int
+		for _, stop := range frontend.stops {
+			lines = append(lines, stop.Location.Line)
+		}
+		if !equalLines(lines, tt.lines) {
+			t.Errorf("command %d stopped at %v, want %v", tt.command, lines, tt.lines)
+		}
+	}
+
+	frontend := &recorder{commands: []Command{StepIn, StepIn, StepOut}}
+	d := New(frontend)
+	d.StopOnEntry()
+	run(d, program, trace...)
+	if got := frontend.stops[len(frontend.stops)-1].Location.Line; len(frontend.stops) != 4 || got != 3 {
+		t.Fatalf("finish stopped %d times, last at line %d", len(frontend.stops), got)
+	}
+}
+
+func TestDetachClearsBreakpoints(t *testing.T) {
+	frontend := &recorder{commands: []Command{Detach}}
+	d := New(frontend)
+	d.SetBreakpoint(Breakpoint{File: "app.rb", Line: 1})
+	program := &fakeProgram{frames: []Frame{{Name: "<main>"}}}
+	run(d, program, Location{File: "app.rb", Line: 1, Depth: 1, Frame: 1}, Location{File: "app.rb", Line: 1, Depth: 1, Frame: 2})
+
+	if len(frontend.stops) != 1 || len(d.Breakpoints()) != 0 {
+		t.Fatalf("stops = %d, breakpoints = %v", len(frontend.stops), d.Breakpoints())
+	}
+}
+
+func TestParseBreakpoint(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Breakpoint
+	}{
+		{"12", Breakpoint{File: "app.rb", Line: 12}},
+		{"lib/report.rb:4", Breakpoint{File: "lib/report.rb", Line: 4}},
+		{"Report#render", Breakpoint{Method: "Report#render"}},
+		{"7 if x > 1", Breakpoint{File: "app.rb", Line: 7, Condition: "x > 1"}},
+	}
+	for _, tt := range tests {
+		got, err := parseBreakpoint(tt.spec, "app.rb")
+		if err != nil || got != tt.want {
+			t.Errorf("parseBreakpoint(%q) = %+v, %v; want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+	if _, err := parseBreakpoint("two words", "app.rb"); err == nil {
+		t.Errorf("parseBreakpoint accepted a spec with spaces")
+	}
+}
+
+func TestConsoleSession(t *testing.T) {
+	var out strings.Builder
+	console := NewConsole(strings.NewReader("info\np x\np y\nbreak 9\nbt\nnext\n"), &out)
+	d := New(console)
+	program := &fakeProgram{frames: []Frame{{Name: "<main>", File: "app.rb", Line: 2}}, locals: map[string]string{"x": "42"}}
+	command := console.Stopped(d, &Stop{Reason: ReasonEntry, Location: Location{File: "app.rb", Line: 2}, Program: program})
+
+	if command != StepOver {
+		t.Fatalf("command = %d, want StepOver", command)
+	}
+	for _, want := range []string{
+		"Stopped at app.rb:2 (entry)",
+		"(rdbg) info\nx = 42\n",
+		"(rdbg) p x\n=> 42\n",
+		"undefined local variable or method `y' (NameError)",
+		"#1  " + absolute("app.rb") + ":9",
+		"=>#0\t<main> at app.rb:2",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("console output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func equalLines(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,721 @@
+// Package formatter re-emits Ruby source in a canonical layout: two-space
+// indentation by block structure, single spaces around binary operators and
+// after commas, double quotes for plain strings and trailing commas in
+// multi-line array and hash literals.
+//
+// It works on the token stream rather than the AST, whose String methods are
+// debug output, so comments, heredoc bodies, =begin blocks, __END__ data and
+// the spelling of every literal come through untouched. Spacing is only
+// normalised where it cannot change how Ruby reads the line (`foo -1` keeps
+// its lopsided space), and the result is re-parsed and compared with the
+// original before it is returned.
+package formatter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// Indent is the text of one indentation level.
+const Indent = "  "
+
+// ErrChanged is returned when formatting would change what the program
+// parses to. It points at a formatter bug rather than at the input.
+var ErrChanged = errors.New("formatting would change the program")
+
+// Format returns src in canonical form. Formatting is idempotent:
+// Format(Format(src)) == Format(src). It fails when src does not parse.
+func Format(src string) (string, error) {
+	original, err := parse(src)
+	if err != nil {
+		return "", err
+	}
+	// Re-indenting alone never changes a program; the spacing, quote and
+	// comma rewrites are dropped if they somehow would.
+	for _, full := range []bool{true, false} {
+		out := newFormatter(src, full).format()
+		formatted, err := parse(out)
+		if err == nil && sameProgram(original, formatted) {
+			return out, nil
+		}
+	}
+	return "", ErrChanged
+}
+
+func parse(src string) (*ast.Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		d := diagnostics[0]
+		return nil, fmt.Errorf("%d:%d: %s", d.Line, d.Column, d.Message)
+	}
+	return program, nil
+}
+
+// item is a token or # comment, in source order.
+type item struct {
+	tok      lexer.Token
+	comment  bool
+	start    int
+	end      int
+	line     int
+	nameLike bool // a keyword used as a method name or hash label
+	comma    bool // emit a trailing comma after the item
+	drop     bool // omit the item (a trailing comma before a closer)
+}
+
+func (it *item) is(types ...lexer.TokenType) bool {
+	if it == nil || it.comment || it.nameLike {
+		return false
+	}
+	for _, t := range types {
+		if it.tok.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceLine is one physical line, without its line break.
+type sourceLine struct {
+	start, end int
+	// verbatim lines lie inside a heredoc body, =begin block or multi-line
+	// literal and are copied as they are.
+	verbatim bool
+	// prefixEnd is set when the line opens inside a multi-line literal that
+	// ends on it: src[start:prefixEnd] is copied as it is.
+	prefixEnd   int
+	prefixComma bool
+	items       []*item
+	indent      int
+}
+
+func (l *sourceLine) code() []*item {
+	var code []*item
+	for _, it := range l.items {
+		if !it.comment {
+			code = append(code, it)
+		}
+	}
+	return code
+}
+
+type formatter struct {
+	src   string
+	full  bool
+	lines []*sourceLine
+	items []*item
+	code  []*item // items without the comments
+	data  string  // the __END__ section, copied as it is
+}
+
+func newFormatter(src string, full bool) *formatter {
+	return &formatter{src: src, full: full}
+}
+
+func (f *formatter) format() string {
+	f.scan()
+	f.layout()
+	return f.render()
+}
+
+// scan splits the source into lines and gives each line the tokens and
+// comments that start on it.
+func (f *formatter) scan() {
+	l := lexer.New(f.src)
+	codeEnd := len(f.src)
+	var tokens, blocks [][2]int // multi-line tokens; heredoc bodies and =begin blocks
+	for {
+		tok := l.NextToken()
+		if tok.Type == lexer.EOF {
+			if tok.Offset < len(f.src) && strings.HasPrefix(f.src[tok.Offset:], "__END__") {
+				codeEnd = tok.Offset
+			}
+			break
+		}
+		if tok.Type == lexer.NEWLINE || tok.EndOffset <= tok.Offset {
+			continue
+		}
+		f.items = append(f.items, &item{tok: tok, start: tok.Offset, end: tok.EndOffset})
+		if strings.Contains(f.src[tok.Offset:tok.EndOffset], "\n") {
+			tokens = append(tokens, [2]int{tok.Offset, tok.EndOffset})
+		}
+	}
+	for _, c := range l.Comments() {
+		if strings.HasPrefix(c.Text, "=begin") {
+			blocks = append(blocks, [2]int{c.Offset, c.EndOffset})
+			continue
+		}
+		f.items = append(f.items, &item{comment: true, start: c.Offset, end: c.EndOffset})
+	}
+	for _, h := range l.HeredocBodies() {
+		blocks = append(blocks, [2]int{h.Offset, h.EndOffset})
+	}
+	sort.SliceStable(f.items, func(i, j int) bool { return f.items[i].start < f.items[j].start })
+	f.data = f.src[codeEnd:]
+
+	code := f.src[:codeEnd]
+	for start := 0; start < len(code); {
+		end := strings.IndexByte(code[start:], '\n')
+		if end < 0 {
+			end = len(code)
+		} else {
+			end += start
+		}
+		line := &sourceLine{start: start, end: end}
+		for _, span := range blocks {
+			if span[0] <= start && start < span[1] {
+				line.verbatim = true
+			}
+		}
+		for _, span := range tokens {
+			if span[0] < start && start < span[1] {
+				if span[1] > end {
+					line.verbatim = true
+				} else {
+					line.prefixEnd = span[1]
+				}
+			}
+		}
+		f.lines = append(f.lines, line)
+		start = end + 1
+	}
+
+	index := 0
+	for _, it := range f.items {
+		if it.start >= codeEnd {
+			continue
+		}
+		for index+1 < len(f.lines) && f.lines[index+1].start <= it.start {
+			index++
+		}
+		it.line = index
+		f.lines[index].items = append(f.lines[index].items, it)
+		if !it.comment {
+			f.code = append(f.code, it)
+		}
+	}
+	f.markNames()
+}
+
+// markNames flags keywords that are really method names or hash labels:
+// `x.class`, `def end`, `foo(if: 1)`.
+func (f *formatter) markNames() {
+	var prev *item
+	code := f.code
+	for i, it := range code {
+		switch {
+		case prev != nil && prev.is(lexer.DOT, lexer.SAFE_NAV, lexer.COLON2, lexer.DEF):
+			it.nameLike = isKeyword(it.tok.Type)
+		case i+1 < len(code) && code[i+1].tok.Type == lexer.COLON && code[i+1].start == it.end:
+			it.nameLike = isKeyword(it.tok.Type)
+		}
+		prev = it
+	}
+}
+
+func isKeyword(t lexer.TokenType) bool {
+	switch t {
+	case lexer.IDENT, lexer.CONSTANT, lexer.INT, lexer.FLOAT, lexer.RATIONAL, lexer.IMAGINARY,
+		lexer.STRING, lexer.WORDS, lexer.SYMBOLS, lexer.SYMBOL, lexer.REGEXP, lexer.AT, lexer.AT2, lexer.DOLLAR:
+		return false
+	}
+	word := string(t)
+	return word != "" && word[0] >= 'a' && word[0] <= 'z'
+}
+
+// frame is an open construct: a keyword block or a bracket.
+type frame struct {
+	opener  *item
+	base    int  // indent of the opener's line, and of its closer
+	inner   int  // indent of the lines inside
+	loop    bool // while/until/for, whose `do` is optional
+	literal bool // an array or hash literal, which takes trailing commas
+	pattern bool // a bracket in a case/in pattern, where a trailing comma matters
+	line    int
+}
+
+func (fr *frame) bracket() bool {
+	return fr.opener.is(lexer.LPAREN, lexer.LBRACKET, lexer.LBRACE)
+}
+
+// layout works out each line's indentation from the block structure and
+// decides where trailing commas go.
+func (f *formatter) layout() {
+	var stack []*frame
+	var prev *item
+	var comments []*sourceLine // comment lines since the last code line
+	continued := false
+	for index, line := range f.lines {
+		if line.verbatim {
+			continue
+		}
+		code := line.code()
+		inner := 0
+		if len(stack) > 0 {
+			inner = stack[len(stack)-1].inner
+		}
+		switch {
+		case line.prefixEnd > 0:
+			line.indent = -1
+		case len(code) == 0:
+			line.indent = inner
+		case code[0].is(lexer.END, lexer.RBRACE, lexer.RBRACKET, lexer.RPAREN) && len(stack) > 0:
+			line.indent = stack[len(stack)-1].base
+		case code[0].is(lexer.ELSE, lexer.ELSIF, lexer.WHEN, lexer.ENSURE, lexer.RESCUE) && len(stack) > 0 && !stack[len(stack)-1].bracket(),
+			code[0].is(lexer.IN) && len(stack) > 0 && stack[len(stack)-1].opener.is(lexer.CASE):
+			line.indent = stack[len(stack)-1].base
+		case continued || code[0].is(lexer.DOT, lexer.SAFE_NAV):
+			line.indent = inner + 1
+		default:
+			line.indent = inner
+		}
+		if len(code) == 0 {
+			if len(line.items) > 0 {
+				comments = append(comments, line)
+			}
+			continue
+		}
+		if code[0].is(lexer.DOT, lexer.SAFE_NAV) {
+			// A comment inside a leading-dot chain lines up with the chain.
+			for _, c := range comments {
+				c.indent = line.indent
+			}
+		}
+		comments = nil
+
+		lineIndent := line.indent
+		if lineIndent < 0 {
+			lineIndent = inner
+		}
+		opened := 0
+		for _, it := range code {
+			startsValue := prev == nil || (prev.line != it.line && !continued) || !operandEnd(prev)
+			var push *frame
+			switch {
+			case it.is(lexer.CLASS, lexer.MODULE, lexer.CASE, lexer.BEGIN):
+				push = &frame{}
+			case it.is(lexer.DEF):
+				if !f.endlessDef(it) {
+					push = &frame{}
+				}
+			case it.is(lexer.IF, lexer.UNLESS):
+				if startsValue {
+					push = &frame{}
+				}
+			case it.is(lexer.WHILE, lexer.UNTIL):
+				if startsValue {
+					push = &frame{loop: true}
+				}
+			case it.is(lexer.FOR):
+				push = &frame{loop: true}
+			case it.is(lexer.DO):
+				if top := topFrame(stack); top != nil && top.loop && top.line == index {
+					top.loop = false
+				} else {
+					push = &frame{}
+				}
+			case it.is(lexer.LPAREN, lexer.LBRACKET, lexer.LBRACE):
+				top := topFrame(stack)
+				pattern := top != nil && (top.pattern || top.opener.is(lexer.CASE) && prev.is(lexer.IN))
+				push = &frame{pattern: pattern}
+				switch {
+				case pattern, it.is(lexer.LPAREN):
+				case it.is(lexer.LBRACKET):
+					push.literal = startsValue
+				default:
+					push.literal = startsValue && !prev.is(lexer.MINUS_ARROW)
+				}
+			case it.is(lexer.END, lexer.RBRACE, lexer.RBRACKET, lexer.RPAREN):
+				if len(stack) == 0 {
+					break
+				}
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if top.line == index {
+					opened--
+				}
+				if top.literal && f.full {
+					f.placeTrailingComma(top, it, prev)
+				}
+			case it.is(lexer.SEMICOLON):
+				if top := topFrame(stack); top != nil {
+					top.loop = false
+				}
+			}
+			if push != nil {
+				push.opener, push.base, push.line = it, lineIndent, index
+				push.inner = lineIndent + 1
+				if opened > 0 {
+					push.inner = stack[len(stack)-1].inner
+				}
+				stack = append(stack, push)
+				opened++
+			}
+			prev = it
+		}
+		for _, fr := range stack {
+			if fr.line == index {
+				fr.loop = false
+			}
+		}
+
+		last := code[len(code)-1]
+		top := topFrame(stack)
+		continued = opened == 0 && (continuesLine(last) ||
+			last.is(lexer.COMMA) && (top == nil || !top.bracket()) ||
+			strings.TrimSpace(f.src[min(last.end, line.end):line.end]) == `\`)
+	}
+}
+
+func topFrame(stack []*frame) *frame {
+	if len(stack) == 0 {
+		return nil
+	}
+	return stack[len(stack)-1]
+}
+
+// placeTrailingComma adds a comma after the last element of a multi-line
+// literal whose closer sits on its own line, and drops one before a closer
+// on the same line.
+func (f *formatter) placeTrailingComma(fr *frame, closer, last *item) {
+	if last == nil || last == fr.opener {
+		return
+	}
+	line := f.lines[closer.line]
+	if code := line.code(); code[0] == closer && fr.line != closer.line {
+		if last.is(lexer.COMMA) || last.is(lexer.SEMICOLON) {
+			return
+		}
+		if strings.Contains(f.src[last.start:last.end], "\n") {
+			for _, l := range f.lines {
+				if l.prefixEnd == last.end {
+					l.prefixComma = true
+				}
+			}
+			return
+		}
+		last.comma = true
+		return
+	}
+	if last.is(lexer.COMMA) && last.line == closer.line {
+		last.drop = true
+	}
+}
+
+// endlessDef reports whether the def at it is `def name(args) = expr`.
+func (f *formatter) endlessDef(def *item) bool {
+	code := f.code
+	i := sort.Search(len(code), func(i int) bool { return code[i].start >= def.start }) + 2
+	// The name runs up to the first gap or parenthesis: `self.foo=`, `==`.
+	for i < len(code) && code[i].start == code[i-1].end && !code[i].is(lexer.LPAREN) {
+		i++
+	}
+	if i < len(code) && code[i].is(lexer.LPAREN) && code[i].start == code[i-1].end {
+		depth := 0
+		for ; i < len(code); i++ {
+			if code[i].is(lexer.LPAREN) {
+				depth++
+			} else if code[i].is(lexer.RPAREN) {
+				depth--
+				if depth == 0 {
+					i++
+					break
+				}
+			}
+		}
+	}
+	return i < len(code) && code[i].line == def.line && code[i].is(lexer.ASSIGN)
+}
+
+// operandEnd reports whether an expression can end with it, so that an
+// `if` after it is a modifier and a `-` after it may be binary.
+func operandEnd(it *item) bool {
+	if it == nil || it.comment {
+		return false
+	}
+	if it.nameLike {
+		return true
+	}
+	switch it.tok.Type {
+	case lexer.IDENT, lexer.CONSTANT, lexer.INT, lexer.FLOAT, lexer.RATIONAL, lexer.IMAGINARY,
+		lexer.STRING, lexer.SYMBOL, lexer.REGEXP, lexer.WORDS, lexer.SYMBOLS,
+		lexer.AT, lexer.AT2, lexer.DOLLAR, lexer.RPAREN, lexer.RBRACKET, lexer.RBRACE,
+		lexer.END, lexer.SELF, lexer.NIL, lexer.TRUE, lexer.FALSE, lexer.NIL_METHOD,
+		lexer.RETURN, lexer.BREAK, lexer.NEXT, lexer.REDO, lexer.RETRY, lexer.SUPER, lexer.YIELD,
+		lexer.RAISE, lexer.CATCH, lexer.THROW, lexer.INCLUDE, lexer.EXTEND, lexer.PREPEND,
+		lexer.PUBLIC, lexer.PRIVATE, lexer.PROTECTED:
+		return true
+	}
+	return false
+}
+
+// operandStart reports whether an expression can start with it.
+func operandStart(it *item) bool {
+	if it == nil || it.comment {
+		return false
+	}
+	switch it.tok.Type {
+	case lexer.IDENT, lexer.CONSTANT, lexer.INT, lexer.FLOAT, lexer.RATIONAL, lexer.IMAGINARY,
+		lexer.STRING, lexer.SYMBOL, lexer.REGEXP, lexer.WORDS, lexer.SYMBOLS,
+		lexer.AT, lexer.AT2, lexer.DOLLAR, lexer.LPAREN, lexer.LBRACKET, lexer.LBRACE,
+		lexer.SELF, lexer.NIL, lexer.TRUE, lexer.FALSE, lexer.MINUS, lexer.BANG, lexer.BIT_NOT,
+		lexer.MINUS_ARROW, lexer.DEFINED, lexer.SUPER, lexer.YIELD, lexer.COLON2:
+		return true
+	}
+	return it.nameLike
+}
+
+// spacedOperator reports whether it is a binary operator written with a
+// space on both sides.
+func spacedOperator(it *item) bool {
+	if it == nil || it.comment || it.nameLike {
+		return false
+	}
+	switch it.tok.Type {
+	case lexer.ASSIGN, lexer.PLUS_ASSIGN, lexer.MINUS_ASSIGN, lexer.MULTIPLY_ASSIGN, lexer.DIVIDE_ASSIGN,
+		lexer.MOD_ASSIGN, lexer.POW_ASSIGN, lexer.OR_ASSIGN, lexer.AND_ASSIGN, lexer.BIT_OR_ASSIGN,
+		lexer.BIT_AND_ASSIGN, lexer.BIT_XOR_ASSIGN, lexer.LSHIFT_ASSIGN, lexer.RSHIFT_ASSIGN,
+		lexer.EQUAL, lexer.EQUAL3, lexer.BANG_EQUAL, lexer.MATCH, lexer.NOT_EQUAL,
+		lexer.LESS_THAN, lexer.LESS_THAN_OR_EQUAL, lexer.GREATER_THAN, lexer.GREATER_THAN_OR_EQUAL,
+		lexer.SPACESHIP, lexer.PLUS, lexer.MINUS, lexer.MULTIPLY, lexer.DIVIDE, lexer.MOD,
+		lexer.AND, lexer.OR, lexer.ARROW, lexer.LSHIFT, lexer.RSHIFT, lexer.BIT_AND, lexer.BIT_XOR:
+		return true
+	}
+	return false
+}
+
+// continuesLine reports whether a line ending in it carries on to the next.
+func continuesLine(it *item) bool {
+	if it.is(lexer.DOT, lexer.SAFE_NAV, lexer.AND2, lexer.OR2, lexer.QUESTION) {
+		return true
+	}
+	return spacedOperator(it) && !it.is(lexer.BIT_AND, lexer.MULTIPLY)
+}
+
+// render writes the lines out with their new indentation and spacing.
+func (f *formatter) render() string {
+	var out []string
+	var kinds []bool // whether each output line may be dropped as blank
+	for _, line := range f.lines {
+		if line.verbatim {
+			out = append(out, f.src[line.start:line.end])
+			kinds = append(kinds, false)
+			continue
+		}
+		out = append(out, f.renderLine(line))
+		kinds = append(kinds, true)
+	}
+
+	// Collapse runs of blank lines and trim them from both ends.
+	var b strings.Builder
+	blank := false
+	for i, text := range out {
+		if kinds[i] && text == "" {
+			blank = b.Len() > 0
+			continue
+		}
+		if blank {
+			b.WriteString("\n")
+			blank = false
+		}
+		b.WriteString(text)
+		b.WriteString("\n")
+	}
+	if f.data != "" {
+		b.WriteString(f.data)
+	}
+	return b.String()
+}
+
+func (f *formatter) renderLine(line *sourceLine) string {
+	var b strings.Builder
+	pos := line.start
+	if line.prefixEnd > 0 {
+		b.WriteString(f.src[line.start:line.prefixEnd])
+		if line.prefixComma && f.full {
+			b.WriteString(",")
+		}
+		pos = line.prefixEnd
+	}
+	items := line.items
+	for i, it := range items {
+		if it.drop {
+			pos = it.end
+			continue
+		}
+		gap := f.src[pos:it.start]
+		switch {
+		case i == 0 && line.prefixEnd == 0:
+			b.WriteString(strings.Repeat(Indent, line.indent))
+		default:
+			b.WriteString(f.space(items, i, gap, line.prefixEnd > 0 && i == 0))
+		}
+		b.WriteString(f.text(it, line.end))
+		if it.comma {
+			b.WriteString(",")
+		}
+		pos = min(it.end, line.end)
+	}
+	if len(items) == 0 && line.prefixEnd == 0 {
+		return ""
+	}
+	if rest := strings.TrimSpace(f.src[pos:line.end]); rest != "" {
+		b.WriteString(" " + rest)
+	}
+	return strings.TrimRight(b.String(), " \t\r")
+}
+
+// text is the source of it as far as end, with single quotes swapped for
+// double ones where that spells the same string.
+func (f *formatter) text(it *item, end int) string {
+	text := f.src[it.start:min(it.end, end)]
+	if it.comment {
+		return strings.TrimRight(text, " \t\r")
+	}
+	if f.full && it.tok.Type == lexer.STRING && len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+		inner := text[1 : len(text)-1]
+		if !strings.ContainsAny(inner, "\\\"#\n") {
+			return `"` + inner + `"`
+		}
+	}
+	return text
+}
+
+// space is the whitespace to put before items[i], whose original
+// whitespace was gap.
+func (f *formatter) space(items []*item, i int, gap string, afterPrefix bool) string {
+	if strings.TrimSpace(gap) != "" {
+		return gap
+	}
+	keep := ""
+	if gap != "" {
+		keep = " "
+	}
+	if !f.full || afterPrefix {
+		return keep
+	}
+	a, b := items[i-1], items[i]
+	for j := i - 1; j >= 0 && a.drop; j-- {
+		a = items[j]
+	}
+	switch {
+	case b.comment:
+		return " "
+	case a.is(lexer.LPAREN, lexer.LBRACKET), b.is(lexer.RPAREN, lexer.RBRACKET):
+		return ""
+	case b.is(lexer.COMMA, lexer.SEMICOLON):
+		return ""
+	case a.is(lexer.COMMA, lexer.SEMICOLON):
+		return " "
+	case a.is(lexer.LBRACE) && b.is(lexer.RBRACE):
+		return ""
+	case a.is(lexer.LBRACE), b.is(lexer.RBRACE):
+		return " "
+	}
+	if f.binary(items, i) || f.binary(items, i-1) {
+		return " "
+	}
+	return keep
+}
+
+// binary reports whether items[i] is a binary operator whose spacing can be
+// normalised: an operand on either side, and the same whitespace (none or
+// some) before and after it.
+func (f *formatter) binary(items []*item, i int) bool {
+	if i <= 0 || i+1 >= len(items) || !spacedOperator(items[i]) {
+		return false
+	}
+	a, op, b := items[i-1], items[i], items[i+1]
+	if !operandEnd(a) || !operandStart(b) {
+		return false
+	}
+	if op.is(lexer.ASSIGN) && b.is(lexer.LPAREN) && op.end == b.start {
+		// `def foo=(value)` and `def []=(key, value)` name a setter.
+		for _, it := range items[:i] {
+			if it.is(lexer.DEF) {
+				return false
+			}
+		}
+	}
+	before := f.src[a.end:op.start] != ""
+	after := f.src[op.end:b.start] != ""
+	return before == after
+}
+
+// sameProgram compares two parse trees, ignoring positions and the token
+// text that records how a literal was spelled.
+func sameProgram(a, b *ast.Program) bool {
+	if len(a.Comments) != len(b.Comments) {
+		return false
+	}
+	for i := range a.Comments {
+		if strings.TrimRight(a.Comments[i].Text, " \t") != strings.TrimRight(b.Comments[i].Text, " \t") {
+			return false
+		}
+	}
+	return sameValue(reflect.ValueOf(a.Statements), reflect.ValueOf(b.Statements))
+}
+
+var (
+	tokenType   = reflect.TypeOf(lexer.Token{})
+	locatedType = reflect.TypeOf(ast.Located{})
+	stringType  = reflect.TypeOf(ast.StringLiteral{})
+)
+
+func sameValue(a, b reflect.Value) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		return sameValue(a.Elem(), b.Elem())
+	case reflect.Struct:
+		if a.Type() == tokenType || a.Type() == locatedType {
+			return true
+		}
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			// '...' and "..." differ only in this flag when there is
+			// nothing to interpolate.
+			if a.Type() == stringType && field.Name == "Interpolates" && !strings.Contains(a.FieldByName("Value").String(), "#") {
+				continue
+			}
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		// Hash literal pairs are keyed by node pointers; their Order slice
+		// carries the comparison.
+		return a.Len() == b.Len()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -0,0 +1,127 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatCanonicalLayout(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "indentation",
+			input: "class Cart\ndef total\nif items.empty?\n0\nelse\nitems.sum\nend\nend\nend\n",
+			want:  "class Cart\n  def total\n    if items.empty?\n      0\n    else\n      items.sum\n    end\n  end\nend\n",
+		},
+		{
+			name:  "spacing",
+			input: "x=a+b\nfoo( 1 ,2 )\nh = {a: 1}\ny = foo -1\n",
+			want:  "x = a + b\nfoo(1, 2)\nh = { a: 1 }\ny = foo -1\n",
+		},
+		{
+			name:  "quotes",
+			input: "a = 'plain'\nb = 'it\\'s'\nc = '#{raw}'\n",
+			want:  "a = \"plain\"\nb = 'it\\'s'\nc = '#{raw}'\n",
+		},
+		{
+			name:  "trailing commas",
+			input: "list = [\n1,\n2\n]\nh = {\na: 1\n}\npair = [1, 2,]\n",
+			want:  "list = [\n  1,\n  2,\n]\nh = {\n  a: 1,\n}\npair = [1, 2]\n",
+		},
+		{
+			name:  "modifiers and endless defs",
+			input: "def ready? = true\nreturn if done\nx = 1 unless y\nwhile x do x -= 1 end\n",
+			want:  "def ready? = true\nreturn if done\nx = 1 unless y\nwhile x do x -= 1 end\n",
+		},
+		{
+			name:  "continuations",
+			input: "total = items\n.map(&:price)\n.sum\nok = a &&\nb\n",
+			want:  "total = items\n  .map(&:price)\n  .sum\nok = a &&\n  b\n",
+		},
+		{
+			name:  "blank lines",
+			input: "\n\na = 1\n\n\n\nb = 2\n\n",
+			want:  "a = 1\n\nb = 2\n",
+		},
+		{
+			name:  "comments",
+			input: "# top\ndef f   # trailing   \n# inside\nend\n",
+			want:  "# top\ndef f # trailing\n  # inside\nend\n",
+		},
+		{
+			name:  "case in patterns keep their commas",
+			input: "case v\nin [a,]\nend\n",
+			want:  "case v\nin [a,]\nend\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(tt.input)
+			if err != nil {
+				t.Fatalf("Format: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatLeavesHeredocsAndDataAlone(t *testing.T) {
+	input := "def text\nmsg = <<~EOS.strip\n   keep   this  \n EOS\n=begin\n  free   text\n=end\nmsg\nend\n__END__\n  raw   data\n"
+	want := "def text\n  msg = <<~EOS.strip\n   keep   this  \n EOS\n=begin\n  free   text\n=end\n  msg\nend\n__END__\n  raw   data\n"
+	got, err := Format(input)
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatIsIdempotent(t *testing.T) {
+	input := `module Shop
+class Cart < Base   # a cart
+def initialize(items=[],tax: 0.2, &blk)
+@items=items
+end
+def each
+items.each do |i|
+case i
+when 1,
+2 then yield i
+else
+h = {'a' => [i,
+i]}
+end
+end.size
+rescue ArgumentError => e
+warn e.message
+end
+def ==(other) = items==other.items
+def name=(v); @name=v; end
+end
+end
+`
+	once, err := Format(input)
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	twice, err := Format(once)
+	if err != nil {
+		t.Fatalf("Format of formatted source: %v", err)
+	}
+	if once != twice {
+		t.Fatalf("formatting is not idempotent:\n%s\n---\n%s", once, twice)
+	}
+	if !strings.Contains(once, "    def ==(other) = items == other.items\n") || !strings.Contains(once, "    def name=(v); @name = v; end\n") {
+		t.Fatalf("unexpected def formatting:\n%s", once)
+	}
+}
+
+func TestFormatRejectsInvalidSource(t *testing.T) {
+	if _, err := Format("def (\n"); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
@@ -0,0 +1,345 @@
+// Package irb implements the interactive Ruby session used by `rgo irb` and
+// Binding#irb. Input is accumulated until the lexer and parser agree that it
+// forms a complete program, then evaluated against one persistent binding so
+// locals, methods and constants survive between entries.
+package irb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+)
+
+// DefaultHistorySize bounds the number of entries kept in the history file.
+const DefaultHistorySize = 1000
+
+// Session is one read-eval-print loop bound to a Ruby binding.
+type Session struct {
+	Binding *object.RBinding
+	In      io.Reader
+	Out     io.Writer
+	// Interactive selects terminal behaviour: prompts are printed and input is
+	// not echoed. Non-interactive sessions echo each line so piped transcripts
+	// read like the terminal session they replace.
+	Interactive bool
+	// HistoryPath names the file entries are loaded from and appended to. An
+	// empty path keeps history in memory only.
+	HistoryPath string
+	HistorySize int
+
+	history []string
+	line    int
+}
+
+// NewSession returns a session reading from in and writing to out. Terminal
+// detection and the history location follow the conventions of MRI's irb:
+// prompts only appear on a TTY and history lives in ~/.rgo_irb_history unless
+// RGO_IRB_HISTORY overrides it. A sandboxed runtime keeps history in memory,
+// since it may not touch the file system.
+func NewSession(binding *object.RBinding, in io.Reader, out io.Writer) *Session {
+	return &Session{
+		Binding:     binding,
+		In:          in,
+		Out:         out,
+		Interactive: isTerminal(in),
+		HistoryPath: defaultHistoryPath(),
+		HistorySize: DefaultHistorySize,
+	}
+}
+
+// Run reads entries until end of input or an exit command. A SystemExit
+// raised by the evaluated code ends the session and is returned so the caller
+// can propagate it; every other exception is reported and the loop continues.
+func (s *Session) Run() *object.EmeraldValue {
+	if s.Binding == nil || core.EvalSourceWithBinding == nil {
+		return nil
+	}
+	s.loadHistory()
+	reader := bufio.NewReader(s.In)
+	var pending strings.Builder
+	for {
+		s.prompt(pending.Len() > 0, pending.String())
+		text, err := reader.ReadString('\n')
+		if text == "" && err != nil {
+			if s.Interactive {
+				fmt.Fprintln(s.Out)
+			}
+			return nil
+		}
+		s.line++
+		if !s.Interactive {
+			fmt.Fprint(s.Out, strings.TrimSuffix(text, "\n")+"\n")
+		}
+		if pending.Len() == 0 {
+			switch command := strings.TrimSpace(text); command {
+			case "":
+				continue
+			case "exit", "quit":
+				s.recordHistory(command)
+				return nil
+			case "history":
+				s.recordHistory(command)
+				s.printHistory()
+				continue
+			}
+		}
+		pending.WriteString(text)
+		if !strings.HasSuffix(text, "\n") {
+			pending.WriteString("\n")
+		}
+		source := pending.String()
+		if err == nil && Incomplete(source) {
+			continue
+		}
+		pending.Reset()
+		s.recordHistory(strings.TrimRight(source, "\n"))
+		if exit := s.evaluate(source); exit != nil {
+			return exit
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// Eval evaluates one complete entry in the session binding and returns its
+// value. Exceptions are returned as values, exactly as Kernel#eval does.
+func (s *Session) Eval(source string) *object.EmeraldValue {
+	if core.EvalSourceWithBinding == nil {
+		return nil
+	}
+	firstLine := s.line - strings.Count(strings.TrimRight(source, "\n"), "\n")
+	if firstLine < 1 {
+		firstLine = 1
+	}
+	previousPath, previousLine := s.Binding.Path, s.Binding.Line
+	s.Binding.Path, s.Binding.Line = "(irb)", int64(firstLine)
+	defer func() {
+		s.Binding.Path, s.Binding.Line = previousPath, previousLine
+	}()
+	previousException := core.LastException
+	result := core.EvalSourceWithBinding(source, s.Binding)
+	if result == nil {
+		result = core.R.NilVal
+	}
+	if result.Type == object.ValueException {
+		core.LastException = previousException
+		return result
+	}
+	if core.LastException != nil && core.LastException != previousException && raised(core.LastException) {
+		result = core.LastException
+		core.LastException = previousException
+	}
+	return result
+}
+
+func (s *Session) evaluate(source string) *object.EmeraldValue {
+	result := s.Eval(source)
+	if result.Type == object.ValueException {
+		if result.Class != nil && isSystemExit(result.Class) {
+			return result
+		}
+		fmt.Fprintln(s.Out, describeException(result, s.line))
+		return nil
+	}
+	core.SetBindingLocal(s.Binding, "_", result)
+	fmt.Fprintf(s.Out, "=> %s\n", inspect(result))
+	return nil
+}
+
+func (s *Session) prompt(continuation bool, pending string) {
+	if !s.Interactive {
+		return
+	}
+	context := "main"
+	if s.Binding.Self != nil && s.Binding.Self != core.R.Main {
+		context = inspect(s.Binding.Self)
+	}
+	marker := ">"
+	if continuation {
+		marker = "*"
+		if Incomplete(pending) && openLiteral(pending) {
+			marker = "\""
+		}
+	}
+	fmt.Fprintf(s.Out, "irb(%s):%03d%s ", context, s.line+1, marker)
+}
+
+func (s *Session) recordHistory(entry string) {
+	if entry == "" {
+		return
+	}
+	s.history = append(s.history, entry)
+	if s.HistorySize > 0 && len(s.history) > s.HistorySize {
+		s.history = s.history[len(s.history)-s.HistorySize:]
+	}
+	if s.HistoryPath == "" {
+		return
+	}
+	file, err := os.OpenFile(s.HistoryPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, escapeHistoryEntry(entry))
+}
+
+func (s *Session) loadHistory() {
+	if s.HistoryPath == "" {
+		return
+	}
+	data, err := os.ReadFile(s.HistoryPath)
+	if err != nil {
+		return
+	}
+	var entry []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if strings.HasSuffix(line, "\\") {
+			entry = append(entry, strings.TrimSuffix(line, "\\"))
+			continue
+		}
+		entry = append(entry, line)
+		if joined := strings.Join(entry, "\n"); joined != "" {
+			s.history = append(s.history, joined)
+		}
+		entry = entry[:0]
+	}
+	if s.HistorySize > 0 && len(s.history) > s.HistorySize {
+		s.history = s.history[len(s.history)-s.HistorySize:]
+		s.rewriteHistory()
+	}
+}
+
+func (s *Session) rewriteHistory() {
+	var out strings.Builder
+	for _, entry := range s.history {
+		out.WriteString(escapeHistoryEntry(entry))
+		out.WriteByte('\n')
+	}
+	_ = os.WriteFile(s.HistoryPath, []byte(out.String()), 0600)
+}
+
+func (s *Session) printHistory() {
+	for index, entry := range s.history {
+		for offset, line := range strings.Split(entry, "\n") {
+			if offset == 0 {
+				fmt.Fprintf(s.Out, "%5d  %s\n", index+1, line)
+			} else {
+				fmt.Fprintf(s.Out, "       %s\n", line)
+			}
+		}
+	}
+}
+
+// History returns the entries recorded so far, oldest first.
+func (s *Session) History() []string {
+	return append([]string(nil), s.history...)
+}
+
+// Multi-line entries keep a trailing backslash on every line but the last,
+// the same layout Reline uses for ~/.irb_history.
+func escapeHistoryEntry(entry string) string {
+	return strings.ReplaceAll(entry, "\n", "\\\n")
+}
+
+func defaultHistoryPath() string {
+	if core.Sandbox != nil {
+		return ""
+	}
+	if path, ok := os.LookupEnv("RGO_IRB_HISTORY"); ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".rgo_irb_history")
+}
+
+func isTerminal(in io.Reader) bool {
+	file, ok := in.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func inspect(value *object.EmeraldValue) string {
+	if value == nil {
+		return "nil"
+	}
+	if core.CallMethod != nil {
+		if inspected := core.CallMethod(value, "inspect"); inspected != nil && inspected.Type == object.ValueString {
+			return inspected.Data.(string)
+		}
+	}
+	return value.Inspect()
+}
+
+func raised(exception *object.EmeraldValue) bool {
+	data, ok := exception.Data.(*object.RException)
+	return ok && data != nil && data.Raised
+}
+
+func isSystemExit(class *object.Class) bool {
+	for current := class; current != nil; current = current.SuperClass {
+		if current.Name == "SystemExit" {
+			return true
+		}
+	}
+	return false
+}
+
+// describeException renders an exception the way irb does: the innermost
+// backtrace location, the message and the class name in parentheses.
+func describeException(exception *object.EmeraldValue, line int) string {
+	className := "Exception"
+	if exception.Class != nil && exception.Class.Name != "" {
+		className = exception.Class.Name
+	}
+	message := className
+	location := fmt.Sprintf("(irb):%d:in '<main>'", line)
+	if data, ok := exception.Data.(*object.RException); ok && data != nil {
+		if data.Message != "" {
+			message = data.Message
+		}
+		if len(data.Backtrace) > 0 && data.Backtrace[0] != "" {
+			location = data.Backtrace[0]
+		}
+	}
+	return fmt.Sprintf("%s: %s (%s)", location, message, className)
+}
+
+// Incomplete reports whether source needs more input before it can be
+// evaluated: an unterminated literal or heredoc, a line continuation, or a
+// parse error the parser found at the end of input, such as an unclosed
+// bracket or keyword block or a binary operator without its right operand.
+func Incomplete(source string) bool {
+	trimmed := strings.TrimRight(source, " \t\r\n")
+	if trimmed == "" {
+		return false
+	}
+	if strings.HasSuffix(trimmed, "\\") || openLiteral(source) {
+		return true
+	}
+	p := parser.New(lexer.New(source))
+	p.SetMaxErrors(1)
+	p.ParseProgram()
+	return p.UnexpectedEOF()
+}
+
+func openLiteral(source string) bool {
+	l := lexer.New(source)
+	for tok := l.NextToken(); tok.Type != lexer.EOF; tok = l.NextToken() {
+	}
+	return l.Unterminated()
+}
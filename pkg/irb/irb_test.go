@@ -0,0 +1,71 @@
+package irb
+
+import "testing"
+
+func TestIncompleteDetectsOpenConstructs(t *testing.T) {
+	incomplete := []string{
+		"def foo",
+		"def foo(a)\n  a * 2",
+		"class Foo",
+		"module Bar\n  class Baz\n  end",
+		"[1,",
+		"foo(1,",
+		"x = {",
+		"if x",
+		"while i < 3 do",
+		"[1, 2].each do |v|",
+		"[1, 2].map { |v|",
+		"1 +",
+		"value = ",
+		"items.\n",
+		"1 +\n",
+		"total = a &&\n",
+		"\"abc",
+		"'abc",
+		"%w(a b",
+		"s = <<~EOS\nbody",
+		"puts 'a' \\",
+		"begin\n  work\nrescue",
+		"case x\nwhen 1",
+		"if x then",
+		"unless y",
+		"for i in 1..3",
+		"a ? b :",
+		"a, b =",
+		"-> {",
+		"lambda do |x|",
+	}
+	for _, source := range incomplete {
+		if !Incomplete(source) {
+			t.Errorf("expected %q to be incomplete", source)
+		}
+	}
+}
+
+func TestIncompleteAcceptsFinishedEntries(t *testing.T) {
+	complete := []string{
+		"",
+		"x = 1",
+		"def foo; end",
+		"def foo = 42",
+		"def foo(a) = a * 2",
+		"class Foo; def bar = 1; end",
+		"x.class",
+		"[1, 2].map { |v| v * 2 }",
+		"[1, 2].each do |v|\n  puts v\nend",
+		"return 1 if done",
+		"puts x unless y",
+		"i += 1 while i < 10",
+		"s = <<~EOS\nbody\nEOS",
+		"\"a #{1 + 2} b\"",
+		"foo.end",
+		"x = 1 # trailing +",
+		"x = ]",
+		"foo(1,,2)",
+	}
+	for _, source := range complete {
+		if Incomplete(source) {
+			t.Errorf("expected %q to be complete", source)
+		}
+	}
+}
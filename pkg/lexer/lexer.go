@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -18,6 +19,13 @@ type Lexer struct {
 
 	templateNesting uint8
 	pendingTokens   []Token
+	unterminated    bool
+
+	start      int
+	startLine  int
+	comments   []Comment
+	heredocs   []HeredocBody
+	lineStarts []int
 }
 
 func New(input string) *Lexer {
@@ -34,6 +42,48 @@ func NewWithEncoding(input, encoding string) *Lexer {
 	return l
 }
 
+// Unterminated reports whether a string, percent literal or heredoc ran into
+// the end of input before its closing delimiter. Interactive callers use it to
+// keep reading continuation lines instead of evaluating a truncated literal.
+func (l *Lexer) Unterminated() bool {
+	return l.unterminated
+}
+
+// Input returns the source being tokenized.
+func (l *Lexer) Input() string {
+	return l.input
+}
+
+// Comments returns the comments skipped so far, in source order.
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+// HeredocBodies returns the bodies of the heredocs read so far, in source
+// order.
+func (l *Lexer) HeredocBodies() []HeredocBody {
+	return l.heredocs
+}
+
+// Position converts a byte offset in the input to its 1-based line and
+// 0-based byte column.
+func (l *Lexer) Position(offset int) (line, column int) {
+	if l.lineStarts == nil {
+		l.lineStarts = []int{0}
+		for i := 0; i < len(l.input); i++ {
+			if l.input[i] == '\n' {
+				l.lineStarts = append(l.lineStarts, i+1)
+			}
+		}
+	}
+	line = sort.Search(len(l.lineStarts), func(i int) bool { return l.lineStarts[i] > offset })
+	return line, offset - l.lineStarts[line-1]
+}
+
+func (l *Lexer) atEOF() bool {
+	return l.position >= len(l.input)
+}
+
 func (l *Lexer) decodeRuneAt(position int) (rune, int) {
 	if position >= len(l.input) {
 		return 0, 0
@@ -107,9 +157,11 @@ func (l *Lexer) skipWhitespace() {
 
 func (l *Lexer) skipComment() {
 	for l.ch == '#' {
+		start := l.position
 		for l.ch != '\n' && l.ch != 0 {
 			l.readChar()
 		}
+		l.comments = append(l.comments, Comment{Text: strings.TrimSuffix(l.input[start:l.position], "\r"), Offset: start, EndOffset: l.position})
 	}
 }
 
@@ -122,6 +174,7 @@ func (l *Lexer) embeddedDocumentStart() bool {
 }
 
 func (l *Lexer) skipEmbeddedDocument() bool {
+	start := l.position
 	for l.ch != 0 {
 		if l.column == 1 && strings.HasPrefix(l.input[l.position:], "=end") {
 			end := l.position + len("=end")
@@ -129,6 +182,7 @@ func (l *Lexer) skipEmbeddedDocument() bool {
 				for l.ch != '\n' && l.ch != 0 {
 					l.readChar()
 				}
+				l.comments = append(l.comments, Comment{Text: strings.TrimSuffix(l.input[start:l.position], "\r"), Offset: start, EndOffset: l.position})
 				if l.ch == '\n' {
 					l.readChar()
 				}
@@ -172,7 +226,29 @@ func (l *Lexer) NextToken() Token {
 		return tok
 	}
 
+	tok := l.scanToken()
+	if tok.EndOffset == 0 {
+		// Tokens end where the lexer stopped, less any layout a reader
+		// consumed while looking past the token.
+		tok.Offset, tok.EndOffset = l.start, l.position
+		if tok.Type == NEWLINE && l.start < len(l.input) && l.input[l.start] == '\n' {
+			tok.EndOffset = l.start + 1
+		}
+		for tok.EndOffset > tok.Offset+1 && strings.IndexByte(" \t\r\n", l.input[tok.EndOffset-1]) >= 0 {
+			tok.EndOffset--
+		}
+		// Readers that look one character past a token stamp it with the
+		// next line when that character is the newline ending its own.
+		if tok.Type != NEWLINE && tok.Line > l.startLine {
+			tok.Line = l.startLine
+		}
+	}
+	return tok
+}
+
+func (l *Lexer) scanToken() Token {
 	l.skipWhitespace()
+	l.start, l.startLine = l.position, l.line
 	if l.embeddedDocumentStart() {
 		line, column := l.line, l.column
 		if !l.skipEmbeddedDocument() {
@@ -185,6 +261,7 @@ func (l *Lexer) NextToken() Token {
 	// Skip inline comments
 	if l.ch == '#' {
 		l.skipComment()
+		l.start, l.startLine = l.position, l.line
 	}
 
 	var tok Token
@@ -859,6 +936,9 @@ func (l *Lexer) readSingleQuotedString(quote rune) string {
 		}
 		l.readChar()
 	}
+	if l.atEOF() {
+		l.unterminated = true
+	}
 	result.WriteString(l.input[start:l.position])
 	return result.String()
 }
@@ -912,6 +992,9 @@ func (l *Lexer) readDoubleQuotedString(position int, quote rune) Token {
 	}
 
 	lit += l.input[position:l.position]
+	if l.atEOF() {
+		l.unterminated = true
+	}
 
 	// 不在这里调用 l.readChar()，让 NextToken 函数处理
 
@@ -1326,6 +1409,9 @@ func (l *Lexer) readPercentString() Token {
 	}
 
 	lit += l.input[position:l.position]
+	if l.atEOF() {
+		l.unterminated = true
+	}
 	closeDelimiter := delimiter
 	l.readChar()
 
@@ -1708,13 +1794,14 @@ func (l *Lexer) readHeredocBody(line, column int, delimiter string, quote rune,
 		l.readChar()
 	}
 	markerSuffix := l.input[suffixStart:l.position]
-	l.queueHeredocMarkerSuffix(markerSuffix, line)
+	l.queueHeredocMarkerSuffix(markerSuffix, line, suffixStart)
 	if l.ch == '\n' {
 		l.readChar()
 	}
 
 	contentStart := l.position
 	contentEnd := l.position
+	terminated := false
 	for l.ch != 0 {
 		lineStart := l.position
 		for l.ch != '\n' && l.ch != 0 {
@@ -1723,6 +1810,7 @@ func (l *Lexer) readHeredocBody(line, column int, delimiter string, quote rune,
 		lineText := l.input[lineStart:l.position]
 		if heredocTerminatorMatches(lineText, delimiter, allowIndentedTerminator) {
 			contentEnd = lineStart
+			terminated = true
 			break
 		}
 		contentEnd = l.position
@@ -1731,6 +1819,10 @@ func (l *Lexer) readHeredocBody(line, column int, delimiter string, quote rune,
 		}
 	}
 
+	if !terminated {
+		l.unterminated = true
+	}
+	l.heredocs = append(l.heredocs, HeredocBody{Offset: contentStart, EndOffset: l.position})
 	lit := l.input[contentStart:contentEnd]
 	if squiggly {
 		lit = dedentHeredoc(lit)
@@ -1747,10 +1839,12 @@ func (l *Lexer) readHeredocBody(line, column int, delimiter string, quote rune,
 	continuesOnNextLine := l.ch == '.' || (l.ch == '&' && l.peekChar() == '.')
 	if !continuesOnNextLine {
 		l.pendingTokens = append(l.pendingTokens, Token{
-			Type:    NEWLINE,
-			Literal: "\n",
-			Line:    l.line,
-			Column:  l.column,
+			Type:      NEWLINE,
+			Literal:   "\n",
+			Line:      l.line,
+			Column:    l.column,
+			Offset:    l.position,
+			EndOffset: l.position,
 		})
 	}
 
@@ -1760,6 +1854,8 @@ func (l *Lexer) readHeredocBody(line, column int, delimiter string, quote rune,
 		Line:                line,
 		Column:              column,
 		AllowsInterpolation: quote != '\'',
+		Offset:              l.start,
+		EndOffset:           suffixStart,
 	}
 }
 
@@ -1833,7 +1929,8 @@ func decodeHeredocEscapes(input string) string {
 	return out.String()
 }
 
-func (l *Lexer) queueHeredocMarkerSuffix(suffix string, line int) {
+func (l *Lexer) queueHeredocMarkerSuffix(suffix string, line, offset int) {
+	offset += len(suffix) - len(strings.TrimLeft(suffix, " \t\r\n\v\f"))
 	suffix = strings.TrimSpace(suffix)
 	if suffix == "" {
 		return
@@ -1843,11 +1940,18 @@ func (l *Lexer) queueHeredocMarkerSuffix(suffix string, line int) {
 	for {
 		tok := suffixLexer.NextToken()
 		if tok.Type == EOF {
-			return
+			break
 		}
 		tok.Line += line - 1
+		tok.Offset += offset
+		tok.EndOffset += offset
 		l.pendingTokens = append(l.pendingTokens, tok)
 	}
+	for _, comment := range suffixLexer.comments {
+		comment.Offset += offset
+		comment.EndOffset += offset
+		l.comments = append(l.comments, comment)
+	}
 }
 
 func heredocTerminatorMatches(lineText, delimiter string, allowIndented bool) bool {
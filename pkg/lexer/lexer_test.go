@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -42,6 +43,25 @@ func TestEndMarkerPermanentlyEndsLexing(t *testing.T) {
 	}
 }
 
+func TestUnterminatedLiteralsAreReported(t *testing.T) {
+	for input, want := range map[string]bool{
+		`"abc`:                    true,
+		`'abc`:                    true,
+		`%w(a b`:                  true,
+		"x = <<~EOS\nbody":        true,
+		`"abc"`:                   false,
+		`%w(a b)`:                 false,
+		"x = <<~EOS\nbody\nEOS\n": false,
+	} {
+		l := New(input)
+		for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		}
+		if l.Unterminated() != want {
+			t.Errorf("Unterminated(%q) = %v, want %v", input, l.Unterminated(), want)
+		}
+	}
+}
+
 func TestHashLabelsWithoutSpacesRemainColonTokens(t *testing.T) {
 	tokens := tokenizeClean(`{a:1,text:"x",items:[true,nil]}`)
 	var colons int
@@ -1659,3 +1679,35 @@ func TestRegexpCanFollowElseOnSameLine(t *testing.T) {
 		t.Fatal("expected regexp token after else")
 	}
 }
+
+func TestTokensCarryByteOffsets(t *testing.T) {
+	input := "x = \"héllo\" # note\ny = <<~EOS.strip\n  body\nEOS\n"
+	l := New(input)
+	for {
+		tok := l.NextToken()
+		if tok.Type == EOF {
+			break
+		}
+		if tok.Type == NEWLINE {
+			continue
+		}
+		got := input[tok.Offset:tok.EndOffset]
+		switch tok.Literal {
+		case "héllo":
+			if got != `"héllo"` {
+				t.Fatalf("string token covers %q", got)
+			}
+		case "strip", "x", "y", "=":
+			if got != tok.Literal {
+				t.Fatalf("token %q covers %q", tok.Literal, got)
+			}
+		}
+	}
+	comments := l.Comments()
+	if len(comments) != 1 || comments[0].Text != "# note" || input[comments[0].Offset:comments[0].EndOffset] != "# note" {
+		t.Fatalf("unexpected comments %#v", comments)
+	}
+	if line, column := l.Position(strings.Index(input, "body")); line != 3 || column != 2 {
+		t.Fatalf("expected body at 3:2, got %d:%d", line, column)
+	}
+}
@@ -176,6 +176,25 @@ type Token struct {
 	CommandLiteral      bool
 	Line                int
 	Column              int
+	// Offset and EndOffset delimit the token's text in the input as byte
+	// offsets, EndOffset exclusive. A heredoc token covers only its opener.
+	Offset    int
+	EndOffset int
+}
+
+// Comment is a # comment or an =begin/=end block the lexer skipped. Text
+// excludes the line break that ends the comment.
+type Comment struct {
+	Text      string
+	Offset    int
+	EndOffset int
+}
+
+// HeredocBody delimits a heredoc's body, from the start of the line after
+// its opener to the end of its terminator line, excluding the line break.
+type HeredocBody struct {
+	Offset    int
+	EndOffset int
 }
 
 func (t Token) String() string {
@@ -221,7 +240,6 @@ var keywords = map[string]TokenType{
 	"or":        OR2,
 	"not":       BANG,
 	"defined?":  DEFINED,
-	"END":       END,
 	"alias":     ALIAS,
 	"undef":     UNDEF,
 	"include":   INCLUDE,
@@ -0,0 +1,400 @@
+package linter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigFiles are the names `rgo lint` looks for in the current directory
+// when no --config is given.
+var ConfigFiles = []string{".rgo-lint.yml", ".rgo-lint.yaml", ".rgo-lint.json"}
+
+// Config selects the rules Lint runs and the files it skips. In YAML:
+//
+//	rules:
+//	  float-equality: false        # disable a rule
+//	  unused-variable:
+//	    severity: error            # error, warning or info
+//	exclude:
+//	  - vendor/**
+//	  - "*_generated.rb"
+//
+// JSON files take the same shape.
+type Config struct {
+	Rules   map[string]RuleConfig
+	Exclude []string
+}
+
+// RuleConfig overrides the defaults of one rule.
+type RuleConfig struct {
+	Enabled  bool
+	Severity Severity // empty keeps the rule's default
+}
+
+// DefaultConfig enables every registered rule at its default severity.
+func DefaultConfig() *Config {
+	return &Config{Rules: map[string]RuleConfig{}}
+}
+
+// rule reports whether rule runs under c and at what severity.
+func (c *Config) rule(rule *Rule) (bool, Severity) {
+	rc, ok := c.Rules[rule.Name]
+	if !ok {
+		return true, rule.Severity
+	}
+	if rc.Severity == "" {
+		return rc.Enabled, rule.Severity
+	}
+	return rc.Enabled, rc.Severity
+}
+
+// Excluded reports whether file matches one of the exclude patterns. A
+// pattern matches the slash-separated path, any trailing part of it, or,
+// when it ends in /**, everything below that directory.
+func (c *Config) Excluded(file string) bool {
+	file = filepath.ToSlash(filepath.Clean(file))
+	for _, pattern := range c.Exclude {
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+			if file == dir || strings.HasPrefix(file, dir+"/") || strings.Contains(file, "/"+dir+"/") {
+				return true
+			}
+			continue
+		}
+		for suffix := file; ; {
+			if matched, _ := path.Match(pattern, suffix); matched {
+				return true
+			}
+			slash := strings.IndexByte(suffix, '/')
+			if slash < 0 {
+				break
+			}
+			suffix = suffix[slash+1:]
+		}
+	}
+	return false
+}
+
+// LoadConfig reads a YAML or JSON config file, telling them apart by the
+// extension (.json is JSON, anything else YAML).
+func LoadConfig(file string) (*Config, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		err = json.Unmarshal(content, &raw)
+	} else {
+		raw, err = parseYAML(string(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	config, err := configFrom(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return config, nil
+}
+
+func configFrom(raw interface{}) (*Config, error) {
+	config := DefaultConfig()
+	if raw == nil {
+		return config, nil
+	}
+	top, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config must be a mapping")
+	}
+	for key, value := range top {
+		switch key {
+		case "rules":
+			ruleMap, ok := value.(map[string]interface{})
+			if !ok && value != nil {
+				return nil, fmt.Errorf("rules must be a mapping of rule names")
+			}
+			for name, setting := range ruleMap {
+				if _, known := rules[name]; !known {
+					return nil, fmt.Errorf("unknown rule %q (known rules: %s)", name, ruleNames())
+				}
+				rc, err := ruleConfigFrom(name, setting)
+				if err != nil {
+					return nil, err
+				}
+				config.Rules[name] = rc
+			}
+		case "exclude":
+			list, ok := value.([]interface{})
+			if !ok && value != nil {
+				return nil, fmt.Errorf("exclude must be a list of patterns")
+			}
+			for _, item := range list {
+				pattern, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("exclude patterns must be strings")
+				}
+				config.Exclude = append(config.Exclude, pattern)
+			}
+		default:
+			return nil, fmt.Errorf("unknown config key %q", key)
+		}
+	}
+	return config, nil
+}
+
+func ruleConfigFrom(name string, setting interface{}) (RuleConfig, error) {
+	rc := RuleConfig{Enabled: true}
+	switch s := setting.(type) {
+	case nil:
+	case bool:
+		rc.Enabled = s
+	case string:
+		rc.Severity = Severity(s)
+	case map[string]interface{}:
+		for key, value := range s {
+			switch key {
+			case "enabled":
+				enabled, ok := value.(bool)
+				if !ok {
+					return rc, fmt.Errorf("rules.%s.enabled must be true or false", name)
+				}
+				rc.Enabled = enabled
+			case "severity":
+				severity, ok := value.(string)
+				if !ok {
+					return rc, fmt.Errorf("rules.%s.severity must be a string", name)
+				}
+				rc.Severity = Severity(severity)
+			default:
+				return rc, fmt.Errorf("unknown setting rules.%s.%s", name, key)
+			}
+		}
+	default:
+		return rc, fmt.Errorf("rules.%s must be true, false, a severity or a mapping", name)
+	}
+	switch rc.Severity {
+	case "", SeverityError, SeverityWarning, SeverityInfo:
+	default:
+		return rc, fmt.Errorf("rules.%s: unknown severity %q (want error, warning or info)", name, rc.Severity)
+	}
+	return rc, nil
+}
+
+// yamlLine is one meaningful line of a YAML document.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// parseYAML reads the subset of YAML config files need: nested block
+// mappings and sequences, flow sequences, comments and plain, quoted,
+// boolean and numeric scalars. Values come back as the types encoding/json
+// produces, so both formats feed configFrom.
+func parseYAML(text string) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(text, "\n") {
+		line := strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(line) - len(trimmed), text: trimmed})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	y := &yamlParser{lines: lines}
+	value, err := y.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if y.pos < len(y.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", y.lines[y.pos].number)
+	}
+	return value, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// block parses the mapping or sequence whose entries start at indent.
+func (y *yamlParser) block(indent int) (interface{}, error) {
+	if strings.HasPrefix(y.lines[y.pos].text, "- ") || y.lines[y.pos].text == "-" {
+		var list []interface{}
+		for y.pos < len(y.lines) && y.lines[y.pos].indent == indent && (strings.HasPrefix(y.lines[y.pos].text, "- ") || y.lines[y.pos].text == "-") {
+			line := y.lines[y.pos]
+			item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+			y.pos++
+			if item == "" {
+				value, err := y.nested(indent)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+				continue
+			}
+			if key, _, isPair := splitYAMLPair(item); isPair && key != "" {
+				// "- key: value" starts a mapping indented past the dash.
+				y.pos--
+				y.lines[y.pos] = yamlLine{number: line.number, indent: indent + 2, text: item}
+				value, err := y.block(indent + 2)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+				continue
+			}
+			value, err := yamlScalar(item, line.number)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+
+	mapping := map[string]interface{}{}
+	for y.pos < len(y.lines) && y.lines[y.pos].indent == indent {
+		line := y.lines[y.pos]
+		key, rest, ok := splitYAMLPair(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.number)
+		}
+		if _, dup := mapping[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		y.pos++
+		if rest == "" {
+			value, err := y.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			mapping[key] = value
+			continue
+		}
+		value, err := yamlScalar(rest, line.number)
+		if err != nil {
+			return nil, err
+		}
+		mapping[key] = value
+	}
+	return mapping, nil
+}
+
+// nested parses the block below a "key:" or "-" line, or returns nil when
+// the next line is not indented further.
+func (y *yamlParser) nested(indent int) (interface{}, error) {
+	if y.pos >= len(y.lines) || y.lines[y.pos].indent <= indent {
+		// A sequence may sit at the same indentation as its key.
+		if y.pos < len(y.lines) && y.lines[y.pos].indent == indent && strings.HasPrefix(y.lines[y.pos].text, "- ") {
+			return y.block(indent)
+		}
+		return nil, nil
+	}
+	return y.block(y.lines[y.pos].indent)
+}
+
+// splitYAMLPair splits "key: value" at the first colon followed by a space
+// or the end of the line, outside quotes.
+func splitYAMLPair(text string) (string, string, bool) {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [,", text[i-1]) >= 0):
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key := strings.TrimSpace(text[:i])
+			if unquoted, err := unquoteYAML(key); err == nil {
+				key = unquoted
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func yamlScalar(text string, line int) (interface{}, error) {
+	if strings.HasPrefix(text, "[") {
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("line %d: unterminated flow sequence", line)
+		}
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		list := []interface{}{}
+		if inner == "" {
+			return list, nil
+		}
+		for _, item := range strings.Split(inner, ",") {
+			value, err := yamlScalar(strings.TrimSpace(item), line)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		value, err := unquoteYAML(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad quoted string %s", line, text)
+		}
+		return value, nil
+	}
+	switch text {
+	case "~", "null":
+		return nil, nil
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return number, nil
+	}
+	return text, nil
+}
+
+func unquoteYAML(text string) (string, error) {
+	if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	if len(text) >= 2 && text[0] == '"' {
+		return strconv.Unquote(text)
+	}
+	return text, fmt.Errorf("not quoted")
+}
+
+// stripYAMLComment drops a # comment that starts a line or follows a space,
+// outside quotes.
+func stripYAMLComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
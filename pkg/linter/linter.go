@@ -0,0 +1,162 @@
+// Package linter runs static checks over Ruby source using the rgo parser
+// and compiler. Each check is a Rule; the built-in rules are registered by
+// this package and others can be added with Register. `rgo lint` is the
+// command line front end.
+package linter
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// Severity says how serious an offense is.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// SyntaxRule is the rule name offenses for unparsable files are reported
+// under. It is not a registered rule and cannot be disabled.
+const SyntaxRule = "syntax"
+
+// Rule is one check. Check inspects the file a Pass describes and calls
+// Pass.Report for every problem it finds.
+type Rule struct {
+	Name        string
+	Description string
+	Severity    Severity // default severity, changeable in the config
+	Check       func(*Pass)
+}
+
+// Offense is one problem a rule found. Lines and columns are 1-based;
+// columns count characters and EndColumn is exclusive.
+type Offense struct {
+	File      string   `json:"file"`
+	Line      int      `json:"line"`
+	Column    int      `json:"column"`
+	EndLine   int      `json:"end_line"`
+	EndColumn int      `json:"end_column"`
+	Rule      string   `json:"rule"`
+	Severity  Severity `json:"severity"`
+	Message   string   `json:"message"`
+}
+
+var rules = map[string]*Rule{}
+
+// Register adds a rule to the set Lint runs. It panics if a rule with the
+// same name is already registered.
+func Register(rule *Rule) {
+	if rule.Name == "" || rule.Check == nil {
+		panic("linter: rule needs a name and a Check function")
+	}
+	if _, exists := rules[rule.Name]; exists {
+		panic("linter: rule " + rule.Name + " registered twice")
+	}
+	if rule.Severity == "" {
+		rule.Severity = SeverityWarning
+	}
+	rules[rule.Name] = rule
+}
+
+// Rules returns the registered rules sorted by name.
+func Rules() []*Rule {
+	list := make([]*Rule, 0, len(rules))
+	for _, rule := range rules {
+		list = append(list, rule)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Pass is what a rule sees of one file.
+type Pass struct {
+	File    string
+	Source  string
+	Program *ast.Program
+
+	rule     *Rule
+	severity Severity
+	scopes   *scopeInfo
+	compiled bool
+	offenses []Offense
+}
+
+// Report records an offense of the running rule spanning r.
+func (p *Pass) Report(r ast.Range, format string, args ...interface{}) {
+	offense := Offense{File: p.File, Rule: p.rule.Name, Severity: p.severity, Message: fmt.Sprintf(format, args...)}
+	offense.Line, offense.Column = p.lineColumn(r.Start)
+	offense.EndLine, offense.EndColumn = p.lineColumn(r.End)
+	p.offenses = append(p.offenses, offense)
+}
+
+// lineColumn converts pos to a 1-based line and character column.
+func (p *Pass) lineColumn(pos ast.Position) (int, int) {
+	lineStart := pos.Offset - pos.Column
+	if lineStart < 0 || pos.Offset > len(p.Source) {
+		return pos.Line, pos.Column + 1
+	}
+	return pos.Line, utf8.RuneCountInString(p.Source[lineStart:pos.Offset]) + 1
+}
+
+// Lint parses and compiles source and runs every enabled rule over it. Each
+// syntax error is an offense under SyntaxRule; the rules still run over the
+// statements the parser recovered, except those that need the file to
+// compile. Offenses come back sorted by position.
+func Lint(file, source string, config *Config) []Offense {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	encoding := core.SourceEncoding(source)
+	oldSpecFile, oldSourceEncoding := core.CurrentSpecFile, core.CurrentEvalSourceEncoding
+	core.CurrentSpecFile, core.CurrentEvalSourceEncoding = file, encoding
+	defer func() {
+		core.CurrentSpecFile, core.CurrentEvalSourceEncoding = oldSpecFile, oldSourceEncoding
+	}()
+
+	p := parser.New(lexer.NewWithEncoding(source, encoding))
+	p.SetFile(file)
+	program := p.ParseProgram()
+	pass := &Pass{File: file, Source: source, Program: program}
+	for _, d := range p.Diagnostics() {
+		pass.offenses = append(pass.offenses, Offense{File: file, Line: d.Line, Column: d.Column, EndLine: d.Line, EndColumn: d.EndColumn, Rule: SyntaxRule, Severity: SeverityError, Message: d.Message})
+	}
+
+	c := compiler.NewWithSourceEncoding(encoding)
+	c.RecordScopes()
+	func() {
+		// Rules that need the compiler's view of local variables skip
+		// files it cannot compile; the rest still run.
+		defer func() { _ = recover() }()
+		pass.compiled = len(pass.offenses) == 0 && c.Compile(program) == nil
+	}()
+	if pass.compiled {
+		pass.scopes = analyzeScopes(program, c.SymbolTable(), c.Scopes())
+	}
+
+	for _, rule := range Rules() {
+		enabled, severity := config.rule(rule)
+		if !enabled {
+			continue
+		}
+		pass.rule, pass.severity = rule, severity
+		rule.Check(pass)
+	}
+	offenses := pass.offenses
+	sort.SliceStable(offenses, func(i, j int) bool {
+		if offenses[i].Line != offenses[j].Line {
+			return offenses[i].Line < offenses[j].Line
+		}
+		return offenses[i].Column < offenses[j].Column
+	})
+	return offenses
+}
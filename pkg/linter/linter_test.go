@@ -0,0 +1,186 @@
+package linter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+)
+
+func init() {
+	core.Init()
+}
+
+// findings renders offenses as "line:column rule" for compact comparison.
+func findings(offenses []Offense) string {
+	var out []string
+	for _, o := range offenses {
+		out = append(out, fmt.Sprintf("%d:%d %s", o.Line, o.Column, o.Rule))
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{
+			name:   "unused variables",
+			source: "x = 1\ny = 2\nputs y\n_skip = 3\nt = 0\nt += 1\ns = 1\nputs \"#{s}\"\n",
+			want:   "1:1 unused-variable",
+		},
+		{
+			name:   "locals read from blocks and methods",
+			source: "a = 1\n[1].each { a }\nb = 2\ndef m\n  b\nend\n",
+			want:   "3:1 unused-variable",
+		},
+		{
+			name:   "binding keeps locals alive",
+			source: "def m\n  v = 1\n  binding\nend\n",
+			want:   "",
+		},
+		{
+			name:   "shadowed block params",
+			source: "a = 5\n[1].each { |a| p a }\nl = ->(a) { a }\n[2].each { |b| p b }\nb = 1\np a, b, l\n",
+			want:   "2:13 shadowed-block-param\n3:8 shadowed-block-param",
+		},
+		{
+			name:   "unreachable code",
+			source: "def m(v)\n  return v\n  puts 1\nend\n[1].each do\n  next\n  p 2\nend\n",
+			want:   "3:3 unreachable-code\n7:3 unreachable-code",
+		},
+		{
+			name:   "assignments in conditions",
+			source: "if v = gets\nend\nwhile (line = gets)\nend\nx = 1 unless a == 1 && (b = 2)\np x, v, line, b\n",
+			want:   "1:4 assignment-in-condition",
+		},
+		{
+			name:   "duplicate hash keys",
+			source: "p({a: 1, \"b\" => 2, :a => 3, 1 => 4, 1 => 5, \"#{x}\" => 6, \"#{x}\" => 7})\n",
+			want:   "1:4 duplicate-hash-key\n1:29 duplicate-hash-key",
+		},
+		{
+			name:   "float equality",
+			source: "p 1.0 == v\np v != -0.5\np 1 == v\n",
+			want:   "1:3 float-equality\n2:3 float-equality",
+		},
+		{
+			name:   "constant reassignment",
+			source: "A = 1\nA = 2\nmodule M\n  A = 3\nend\nB = 1 if x\nB = 2 unless x\n",
+			want:   "2:1 constant-reassignment",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findings(Lint("test.rb", tt.source, nil)); got != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorsAreOffenses(t *testing.T) {
+	offenses := Lint("bad.rb", "def (\n", nil)
+	if len(offenses) != 1 || offenses[0].Rule != SyntaxRule || offenses[0].Severity != SeverityError || offenses[0].Line == 0 {
+		t.Fatalf("offenses = %+v", offenses)
+	}
+
+	// Rules still run over the statements around the broken ones.
+	offenses = Lint("bad.rb", "x = = 1\nputs(1.0 == y)\nfoo(1,,2)\n", nil)
+	if got, want := findings(offenses), "1:5 syntax\n2:6 float-equality\n3:7 syntax"; got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	yaml := `# lint settings
+rules:
+  float-equality: false
+  unused-variable:
+    severity: error
+  duplicate-hash-key: info
+exclude:
+  - vendor/**
+  - "*_generated.rb"
+`
+	jsonConfig := `{"rules": {"float-equality": false, "unused-variable": {"severity": "error"}, "duplicate-hash-key": "info"}, "exclude": ["vendor/**", "*_generated.rb"]}`
+	for name, content := range map[string]string{"lint.yml": yaml, "lint.json": jsonConfig} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfig(file)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			offenses := Lint("t.rb", "x = 1\np 1.0 == 2\np({a: 1, a: 2})\n", config)
+			var got []string
+			for _, o := range offenses {
+				got = append(got, o.Rule+"/"+string(o.Severity))
+			}
+			if strings.Join(got, " ") != "unused-variable/error duplicate-hash-key/info" {
+				t.Fatalf("offenses = %v", got)
+			}
+			for file, want := range map[string]bool{"vendor/gems/a.rb": true, "app/vendor/b.rb": true, "lib/x_generated.rb": true, "lib/vendor.rb": false} {
+				if config.Excluded(file) != want {
+					t.Errorf("Excluded(%q) = %v, want %v", file, !want, want)
+				}
+			}
+		})
+	}
+
+	bad := filepath.Join(dir, "bad.yml")
+	if err := os.WriteFile(bad, []byte("rules:\n  no-such-rule: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(bad); err == nil || !strings.Contains(err.Error(), "unknown rule") {
+		t.Fatalf("LoadConfig of an unknown rule: %v", err)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteSARIF(&out, Lint("lib/a.rb", "x = 1\n", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				RuleIndex int
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn, EndColumn int }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("invalid SARIF: %v\n%s", err, out.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 {
+		t.Fatalf("unexpected log:\n%s", out.String())
+	}
+	result := log.Runs[0].Results[0]
+	location := result.Locations[0].PhysicalLocation
+	if result.RuleID != "unused-variable" || log.Runs[0].Tool.Driver.Rules[result.RuleIndex].ID != "unused-variable" || result.Level != "warning" ||
+		location.ArtifactLocation.URI != "lib/a.rb" || location.Region.StartLine != 1 || location.Region.StartColumn != 1 || location.Region.EndColumn != 2 {
+		t.Fatalf("unexpected result:\n%s", out.String())
+	}
+}
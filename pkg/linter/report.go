@@ -0,0 +1,128 @@
+package linter
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+)
+
+// Report is the JSON form of a lint run.
+type Report struct {
+	Files    int       `json:"files"`
+	Offenses []Offense `json:"offenses"`
+}
+
+// WriteJSON writes the offenses found in files as an indented Report.
+func WriteJSON(w io.Writer, files int, offenses []Offense) error {
+	if offenses == nil {
+		offenses = []Offense{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(Report{Files: files, Offenses: offenses})
+}
+
+// The parts of SARIF 2.1.0 a lint report uses.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine   int `json:"startLine"`
+			StartColumn int `json:"startColumn,omitempty"`
+			EndLine     int `json:"endLine,omitempty"`
+			EndColumn   int `json:"endColumn,omitempty"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+func sarifLevel(severity Severity) string {
+	switch severity {
+	case SeverityError:
+		return "error"
+	case SeverityInfo:
+		return "note"
+	}
+	return "warning"
+}
+
+// WriteSARIF writes the offenses as a SARIF 2.1.0 log, the format code
+// scanning services import. Every registered rule is described in the log,
+// and SyntaxRule too.
+func WriteSARIF(w io.Writer, offenses []Offense) error {
+	driver := sarifDriver{Name: "rgo lint", InformationURI: "https://github.com/GoLangDream/rgo"}
+	index := map[string]int{}
+	addRule := func(id, description string, severity Severity) {
+		rule := sarifRule{ID: id, ShortDescription: sarifMessage{Text: description}}
+		rule.DefaultConfiguration.Level = sarifLevel(severity)
+		index[id] = len(driver.Rules)
+		driver.Rules = append(driver.Rules, rule)
+	}
+	addRule(SyntaxRule, "the file does not parse", SeverityError)
+	for _, rule := range Rules() {
+		addRule(rule.Name, rule.Description, rule.Severity)
+	}
+
+	results := []sarifResult{}
+	for _, o := range offenses {
+		result := sarifResult{RuleID: o.Rule, RuleIndex: index[o.Rule], Level: sarifLevel(o.Severity), Message: sarifMessage{Text: o.Message}}
+		var location sarifLocation
+		location.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(o.File)
+		region := &location.PhysicalLocation.Region
+		region.StartLine, region.StartColumn = max(o.Line, 1), o.Column
+		region.EndLine, region.EndColumn = o.EndLine, o.EndColumn
+		result.Locations = []sarifLocation{location}
+		results = append(results, result)
+	}
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, ColumnKind: "unicodeCodePoints", Results: results}},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
@@ -0,0 +1,332 @@
+package linter
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+func init() {
+	Register(&Rule{
+		Name:        "unused-variable",
+		Description: "a local variable is assigned but never read",
+		Check:       checkUnusedVariables,
+	})
+	Register(&Rule{
+		Name:        "shadowed-block-param",
+		Description: "a block parameter has the name of a local variable of an enclosing scope",
+		Check:       checkShadowedBlockParams,
+	})
+	Register(&Rule{
+		Name:        "unreachable-code",
+		Description: "statements follow a return, break, next, redo, retry or raise",
+		Check:       checkUnreachableCode,
+	})
+	Register(&Rule{
+		Name:        "assignment-in-condition",
+		Description: "an if, unless, while, until or ternary condition is an unparenthesised assignment",
+		Check:       checkAssignmentInCondition,
+	})
+	Register(&Rule{
+		Name:        "duplicate-hash-key",
+		Description: "a hash literal repeats a literal key",
+		Check:       checkDuplicateHashKeys,
+	})
+	Register(&Rule{
+		Name:        "float-equality",
+		Description: "a float literal is compared with == or !=",
+		Check:       checkFloatEquality,
+	})
+	Register(&Rule{
+		Name:        "constant-reassignment",
+		Description: "a constant is assigned again in the same namespace",
+		Check:       checkConstantReassignment,
+	})
+}
+
+// checkUnusedVariables reports locals that are assigned in a scope the
+// compiler gave them and never read there or in a block within it. Names
+// starting with _ are exempt, as in MRI, and so is any scope that calls
+// binding.
+func checkUnusedVariables(p *Pass) {
+	if p.scopes == nil {
+		return
+	}
+	for _, s := range p.scopes.all() {
+		if s.dynamic {
+			continue
+		}
+		for _, name := range s.assigns {
+			if strings.HasPrefix(name, "_") || s.used[name] {
+				continue
+			}
+			p.Report(s.assigned[name], "assigned but unused variable - %s", name)
+		}
+	}
+}
+
+// checkShadowedBlockParams reports block and lambda parameters named like a
+// local that an enclosing scope declared before the block.
+func checkShadowedBlockParams(p *Pass) {
+	if p.scopes == nil {
+		return
+	}
+	check := func(block ast.Node, params []*ast.Identifier) {
+		start := block.SourceRange().Start.Offset
+		for _, param := range params {
+			if param == nil || !param.Loc.IsValid() || strings.HasPrefix(param.Value, "_") {
+				continue
+			}
+			own := p.scopes.at(param.Loc.Start.Offset)
+			if own == p.scopes.top {
+				continue
+			}
+			for outer := own.parent; outer != nil; outer = outer.parent {
+				if offset, ok := outer.declared[param.Value]; ok && offset < start {
+					p.Report(param.Loc, "shadowing outer local variable - %s", param.Value)
+					break
+				}
+				if outer.boundary {
+					break
+				}
+			}
+		}
+	}
+	ast.Inspect(p.Program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.BlockExpression:
+			check(n, n.Params)
+		case *ast.ProcLiteral:
+			check(n, n.Params)
+		}
+		return true
+	})
+}
+
+// jumpKeyword returns the keyword of a statement control never comes back
+// from, or "".
+func jumpKeyword(stmt ast.Statement) string {
+	var node ast.Node = stmt
+	if es, ok := stmt.(*ast.ExpressionStatement); ok {
+		node = es.Expression
+	}
+	switch node.(type) {
+	case *ast.ReturnExpression:
+		return "return"
+	case *ast.BreakExpression:
+		return "break"
+	case *ast.NextExpression:
+		return "next"
+	case *ast.RedoExpression:
+		return "redo"
+	case *ast.RetryExpression:
+		return "retry"
+	case *ast.RaiseExpression:
+		return "raise"
+	}
+	return ""
+}
+
+func checkUnreachableCode(p *Pass) {
+	check := func(statements []ast.Statement) {
+		for i, stmt := range statements {
+			keyword := jumpKeyword(stmt)
+			if keyword == "" || i+1 == len(statements) {
+				continue
+			}
+			r := statements[i+1].SourceRange()
+			for _, rest := range statements[i+2:] {
+				r = r.Cover(rest.SourceRange())
+			}
+			if r.IsValid() {
+				p.Report(r, "unreachable code after %s", keyword)
+			}
+			return
+		}
+	}
+	ast.Inspect(p.Program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.Program:
+			check(n.Statements)
+		case *ast.BlockExpression:
+			check(n.Statements)
+		}
+		return true
+	})
+}
+
+// checkAssignmentInCondition reports `if x = y` and friends, including
+// assignments joined into the condition with && or ||. Wrapping the
+// assignment in parentheses marks it as intended, as in RuboCop.
+func checkAssignmentInCondition(p *Pass) {
+	var check func(cond ast.Expression)
+	check = func(cond ast.Expression) {
+		switch c := cond.(type) {
+		case *ast.AssignExpression, *ast.MultiAssignExpression, *ast.InstanceVarAssign, *ast.GlobalVarAssign, *ast.ClassVarAssign:
+			r := c.SourceRange()
+			if !r.IsValid() || r.Start.Offset < len(p.Source) && p.Source[r.Start.Offset] == '(' {
+				return
+			}
+			if a, ok := c.(*ast.AssignExpression); ok && a.Token.Type != lexer.ASSIGN {
+				return
+			}
+			p.Report(r, "assignment in condition; use == to compare or wrap the assignment in parentheses")
+		case *ast.InfixExpression:
+			switch c.Operator {
+			case "&&", "||", "and", "or":
+				check(c.Left)
+				check(c.Right)
+			}
+		case *ast.PrefixExpression:
+			if c.Operator == "!" || c.Operator == "not" {
+				check(c.Right)
+			}
+		}
+	}
+	ast.Inspect(p.Program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.IfExpression:
+			check(n.Condition)
+			for _, elsif := range n.ElsIf {
+				check(elsif.Condition)
+			}
+		case *ast.WhileExpression:
+			check(n.Condition)
+		case *ast.UntilExpression:
+			check(n.Condition)
+		case *ast.TernaryExpression:
+			check(n.Condition)
+		}
+		return true
+	})
+}
+
+// hashKey is the identity of a literal hash key, or "" for keys that are
+// computed at run time.
+func hashKey(key ast.Expression) string {
+	switch k := key.(type) {
+	case *ast.SymbolLiteral:
+		if strings.Contains(k.Value, "#{") {
+			return ""
+		}
+		name := strings.TrimPrefix(k.Value, ":")
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+		return ":" + name
+	case *ast.StringLiteral:
+		// Interpolates is not set on every interpolated key, so any #{
+		// counts as one.
+		if k.Interpolates || k.Command || strings.Contains(k.Value, "#{") {
+			return ""
+		}
+		return strconv.Quote(k.Value)
+	case *ast.IntegerLiteral:
+		return k.Token.Literal
+	case *ast.FloatLiteral:
+		return k.Token.Literal
+	case *ast.Boolean:
+		return k.String()
+	case *ast.NilExpression:
+		return "nil"
+	}
+	return ""
+}
+
+func checkDuplicateHashKeys(p *Pass) {
+	ast.Inspect(p.Program, func(node ast.Node) bool {
+		hash, ok := node.(*ast.HashLiteral)
+		if !ok {
+			return true
+		}
+		first := map[string]ast.Expression{}
+		for _, key := range hash.Order {
+			id := hashKey(key)
+			if id == "" {
+				continue
+			}
+			if previous, seen := first[id]; seen {
+				p.Report(previous.SourceRange(), "key %s is duplicated and overwritten on line %d", id, key.SourceRange().Start.Line)
+			}
+			first[id] = key
+		}
+		return true
+	})
+}
+
+func checkFloatEquality(p *Pass) {
+	isFloat := func(e ast.Expression) bool {
+		if prefix, ok := e.(*ast.PrefixExpression); ok && prefix.Operator == "-" {
+			e = prefix.Right
+		}
+		_, ok := e.(*ast.FloatLiteral)
+		return ok
+	}
+	ast.Inspect(p.Program, func(node ast.Node) bool {
+		if infix, ok := node.(*ast.InfixExpression); ok && (infix.Operator == "==" || infix.Operator == "!=") {
+			if isFloat(infix.Left) || isFloat(infix.Right) {
+				p.Report(infix.Loc, "float literal compared with %s; compare within a tolerance instead", infix.Operator)
+			}
+		}
+		return true
+	})
+}
+
+// checkConstantReassignment reports constants assigned twice by statements
+// directly in the same class, module or top-level body. Assignments inside
+// conditionals or methods are left alone: they are usually guarded, or an
+// error the compiler reports anyway.
+func checkConstantReassignment(p *Pass) {
+	first := map[string]*ast.AssignExpression{}
+	var body func(statements []ast.Statement, namespace string)
+	body = func(statements []ast.Statement, namespace string) {
+		for _, stmt := range statements {
+			var node ast.Node = stmt
+			if es, ok := stmt.(*ast.ExpressionStatement); ok {
+				node = es.Expression
+			}
+			switch n := node.(type) {
+			case *ast.AssignExpression:
+				if n.Target != nil || n.Index != nil || n.Name == nil || n.Token.Type != lexer.ASSIGN || isLocalName(n.Name.Value) || !n.Name.Loc.IsValid() {
+					continue
+				}
+				key := namespace + "::" + n.Name.Value
+				if previous, seen := first[key]; seen {
+					p.Report(n.Name.Loc, "already initialized constant %s (previous definition on line %d)", strings.TrimPrefix(key, "::"), previous.Name.Loc.Start.Line)
+					continue
+				}
+				first[key] = n
+			case *ast.ClassExpression:
+				if n.Name != nil && n.SingletonReceiver == nil && n.Body != nil {
+					body(n.Body.Statements, nested(namespace, n.Name.Value, n.Absolute))
+				}
+			case *ast.ModuleExpression:
+				if n.Name != nil && n.Body != nil {
+					body(n.Body.Statements, nested(namespace, n.Name.Value, n.Absolute))
+				}
+			}
+		}
+	}
+	body(p.Program.Statements, "")
+}
+
+// nested is the namespace path of class or module name opened in namespace.
+func nested(namespace, name string, absolute bool) string {
+	if absolute || strings.HasPrefix(name, "::") {
+		return "::" + strings.TrimPrefix(name, "::")
+	}
+	return namespace + "::" + name
+}
+
+// ruleNames lists the registered rule names, for error messages.
+func ruleNames() string {
+	var names []string
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,254 @@
+package linter
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// scope is one local variable scope of a file: the top level, or a def,
+// class body, block or lambda the compiler opened a symbol table for. The
+// table says which locals the scope owns; the AST walk in analyzeScopes
+// adds where each is declared, assigned and read.
+type scope struct {
+	region   ast.Range
+	table    *compiler.SymbolTable
+	boundary bool // outer locals are not visible (def, class and module bodies)
+	parent   *scope
+
+	declared map[string]int       // offset of the first assignment or parameter
+	assigned map[string]ast.Range // the first plain assignment
+	assigns  []string             // names in the order they were first assigned
+	used     map[string]bool
+	dynamic  bool // calls binding, so any local may be read by name
+}
+
+type scopeInfo struct {
+	top    *scope
+	scopes []*scope
+}
+
+func newScope(region ast.Range, table *compiler.SymbolTable) *scope {
+	return &scope{
+		region:   region,
+		table:    table,
+		boundary: table.MethodBoundary,
+		declared: map[string]int{},
+		assigned: map[string]ast.Range{},
+		used:     map[string]bool{},
+	}
+}
+
+// interpolatedName matches the local variable candidates inside #{...}.
+var interpolatedName = regexp.MustCompile(`[a-z_][A-Za-z0-9_]*`)
+
+// analyzeScopes pairs the scopes the compiler recorded with their source
+// regions and walks the program once to see how each local is used.
+func analyzeScopes(program *ast.Program, top *compiler.SymbolTable, recorded []compiler.ScopeLocals) *scopeInfo {
+	info := &scopeInfo{top: newScope(program.Loc, top)}
+	for _, r := range recorded {
+		region := r.Node.SourceRange()
+		// A block is compiled while compiling the call it is passed to;
+		// only the block itself belongs to the new scope.
+		if call, ok := r.Node.(*ast.MethodCall); ok && call.Block != nil && call.Block.Loc.IsValid() {
+			region = call.Block.Loc
+		}
+		if region.IsValid() {
+			info.scopes = append(info.scopes, newScope(region, r.Table))
+		}
+	}
+	for _, s := range info.scopes {
+		s.parent = info.top
+		for _, other := range info.scopes {
+			if other != s && contains(other.region, s.region) && (s.parent == info.top || size(other.region) < size(s.parent.region)) {
+				s.parent = other
+			}
+		}
+	}
+
+	skip := map[*ast.Identifier]bool{}
+	declare := func(id *ast.Identifier) {
+		if id == nil {
+			return
+		}
+		skip[id] = true
+		if owner := info.resolve(info.at(id.Loc.Start.Offset), id.Value); owner != nil {
+			owner.declare(id.Value, id.Loc.Start.Offset)
+		}
+	}
+	assign := func(id *ast.Identifier) {
+		skip[id] = true
+		if owner := info.resolve(info.at(id.Loc.Start.Offset), id.Value); owner != nil {
+			owner.declare(id.Value, id.Loc.Start.Offset)
+			if _, ok := owner.assigned[id.Value]; !ok {
+				owner.assigned[id.Value] = id.Loc
+				owner.assigns = append(owner.assigns, id.Value)
+			}
+		}
+	}
+	read := func(name string, offset int) {
+		s := info.at(offset)
+		if name == "binding" {
+			for ; s != nil; s = s.parent {
+				s.dynamic = true
+				if s.boundary {
+					break
+				}
+			}
+			return
+		}
+		if owner := info.resolve(s, name); owner != nil {
+			owner.used[name] = true
+		}
+	}
+	declareParams := func(params []*ast.Identifier, rest, keywordRest, block *ast.Identifier) {
+		for _, p := range params {
+			declare(p)
+		}
+		declare(rest)
+		declare(keywordRest)
+		declare(block)
+	}
+
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.Identifier:
+			if !skip[n] && n.Loc.IsValid() {
+				read(n.Value, n.Loc.Start.Offset)
+			}
+		case *ast.MethodCall:
+			if n.Method != nil {
+				skip[n.Method] = true
+				if n.Receiver == nil && n.Method.Value == "binding" {
+					read("binding", n.Loc.Start.Offset)
+				}
+			}
+		case *ast.DefExpression:
+			skip[n.Name] = true
+			declareParams(n.Params, n.RestParam, n.KeywordRestParam, n.BlockParam)
+		case *ast.BlockExpression:
+			declareParams(n.Params, n.RestParam, n.KeywordRestParam, n.BlockParam)
+		case *ast.ProcLiteral:
+			declareParams(n.Params, n.RestParam, n.KeywordRestParam, n.BlockParam)
+		case *ast.AssignExpression:
+			if n.Target == nil && n.Index == nil && n.Name != nil && isLocalName(n.Name.Value) && n.Name.Loc.IsValid() {
+				if n.Token.Type == lexer.ASSIGN {
+					assign(n.Name)
+				} else {
+					// x += 1 reads x as well as assigning it.
+					declare(n.Name)
+					read(n.Name.Value, n.Name.Loc.Start.Offset)
+				}
+			}
+		case *ast.MultiAssignExpression:
+			for _, target := range append(append([]ast.Expression{}, n.Targets...), identifiers(n.Names)...) {
+				if id, ok := target.(*ast.Identifier); ok && isLocalName(id.Value) && id.Loc.IsValid() {
+					assign(id)
+				}
+			}
+		case *ast.StringLiteral:
+			if n.Interpolates {
+				readInterpolated(n.Value, n.Loc.Start.Offset, read)
+			}
+		case *ast.RegexpLiteral:
+			if n.Interpolates {
+				readInterpolated(n.Pattern, n.Loc.Start.Offset, read)
+			}
+		case *ast.SymbolLiteral:
+			readInterpolated(n.Value, n.Loc.Start.Offset, read)
+		}
+		return true
+	})
+	return info
+}
+
+func identifiers(ids []*ast.Identifier) []ast.Expression {
+	list := make([]ast.Expression, len(ids))
+	for i, id := range ids {
+		list[i] = id
+	}
+	return list
+}
+
+// readInterpolated counts every name inside the #{...} parts of a literal
+// as read. The compiler parses interpolations itself, so they are not in the
+// tree; treating every word as a read can only hide an unused variable.
+func readInterpolated(text string, offset int, read func(string, int)) {
+	for {
+		start := strings.Index(text, "#{")
+		if start < 0 {
+			return
+		}
+		text = text[start+2:]
+		depth, end := 1, 0
+		for end < len(text) && depth > 0 {
+			switch text[end] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+			end++
+		}
+		for _, name := range interpolatedName.FindAllString(text[:end], -1) {
+			read(name, offset)
+		}
+		text = text[end:]
+	}
+}
+
+func (s *scope) declare(name string, offset int) {
+	if previous, ok := s.declared[name]; !ok || offset < previous {
+		s.declared[name] = offset
+	}
+}
+
+// at returns the innermost scope whose region holds offset.
+func (info *scopeInfo) at(offset int) *scope {
+	best := info.top
+	for _, s := range info.scopes {
+		if s.region.Start.Offset <= offset && offset < s.region.End.Offset && (best == info.top || size(s.region) < size(best.region)) {
+			best = s
+		}
+	}
+	return best
+}
+
+// resolve finds the scope that owns the local name as seen from s, or nil
+// when name is not a local there (so it is a method call).
+func (info *scopeInfo) resolve(s *scope, name string) *scope {
+	for ; s != nil; s = s.parent {
+		if symbol, ok := s.table.Lookup(name); ok && symbol.Scope == compiler.ScopeLocal {
+			return s
+		}
+		if s.boundary {
+			return nil
+		}
+	}
+	return nil
+}
+
+// all returns every scope, the top level first and the rest in source order.
+func (info *scopeInfo) all() []*scope {
+	list := append([]*scope{info.top}, info.scopes...)
+	sort.SliceStable(list[1:], func(i, j int) bool {
+		return list[1+i].region.Start.Offset < list[1+j].region.Start.Offset
+	})
+	return list
+}
+
+func contains(outer, inner ast.Range) bool {
+	return outer.Start.Offset <= inner.Start.Offset && inner.End.Offset <= outer.End.Offset && size(outer) > size(inner)
+}
+
+func size(r ast.Range) int {
+	return r.End.Offset - r.Start.Offset
+}
+
+func isLocalName(name string) bool {
+	return name != "" && (name[0] == '_' || name[0] >= 'a' && name[0] <= 'z' || name[0] >= 0x80)
+}
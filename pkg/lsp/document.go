@@ -0,0 +1,271 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// document is one Ruby source and what the parser and compiler made of it.
+type document struct {
+	uri        string
+	path       string
+	text       string
+	lineStarts []int
+
+	diagnostics []diagnostic
+
+	// program and definitions come from the last text that parsed, if
+	// only partly, and scopes and topLevel from the last text that
+	// compiled, so a document being edited keeps answering requests while
+	// it is briefly invalid.
+	program     *ast.Program
+	definitions []*definition
+	scopes      []compiler.ScopeLocals
+	topLevel    *compiler.SymbolTable
+	parsed      bool
+	compiled    bool
+}
+
+// definition is a class, module, constant or method defined in a document.
+type definition struct {
+	name      string // as written: "A::B", "self.build", "X"
+	kind      int
+	node      ast.Node
+	loc       ast.Range
+	nameLoc   ast.Range
+	children  []*definition
+	container string
+}
+
+func newDocument(uri, path, text string) *document {
+	d := &document{uri: uri, path: path, text: text, diagnostics: []diagnostic{}}
+	d.lineStarts = []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+	d.analyze()
+	return d
+}
+
+// keepAnalysis carries over the parts of previous's analysis that d could not
+// produce itself.
+func (d *document) keepAnalysis(previous *document) {
+	if !d.parsed && previous.program != nil {
+		d.program, d.definitions = previous.program, previous.definitions
+	}
+	if !d.compiled && previous.topLevel != nil {
+		d.scopes, d.topLevel = previous.scopes, previous.topLevel
+	}
+}
+
+// analyze parses and compiles the text, recording diagnostics for every
+// syntax error or the first compile error the way `rgo check` reports them.
+func (d *document) analyze() {
+	encoding := core.SourceEncoding(d.text)
+	oldSpecFile, oldSpecFileAbsolute, oldSourceEncoding := core.CurrentSpecFile, core.CurrentSpecFileAbsolute, core.CurrentEvalSourceEncoding
+	core.CurrentSpecFile, core.CurrentSpecFileAbsolute = d.path, d.path
+	core.CurrentEvalSourceEncoding = encoding
+	defer func() {
+		core.CurrentSpecFile, core.CurrentSpecFileAbsolute, core.CurrentEvalSourceEncoding = oldSpecFile, oldSpecFileAbsolute, oldSourceEncoding
+	}()
+
+	phase := "parse"
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			d.diagnostics = append(d.diagnostics, d.lineDiagnostic(1, fmt.Sprintf("internal %s error: %v", phase, recovered)))
+		}
+	}()
+
+	p := parser.New(lexer.NewWithEncoding(d.text, encoding))
+	p.SetFile(d.path)
+	program := p.ParseProgram()
+	// The parser recovers from syntax errors, so the definitions outside
+	// the broken statements are worth indexing even when there are some.
+	d.program, d.parsed = program, true
+	d.definitions = collectDefinitions(program)
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		for _, pd := range diagnostics {
+			d.diagnostics = append(d.diagnostics, d.parseDiagnostic(pd))
+		}
+		return
+	}
+
+	phase = "compile"
+	c := compiler.NewWithSourceEncoding(encoding)
+	c.RecordScopes()
+	if err := c.Compile(program); err != nil {
+		line := c.ErrorLine()
+		if line <= 0 {
+			line = 1
+		}
+		d.diagnostics = append(d.diagnostics, d.lineDiagnostic(line, err.Error()))
+		return
+	}
+	d.scopes, d.topLevel, d.compiled = c.Scopes(), c.SymbolTable(), true
+}
+
+// parseDiagnostic converts a parser diagnostic, whose columns count runes
+// from 1, to one whose characters count UTF-16 units from 0.
+func (d *document) parseDiagnostic(pd parser.Diagnostic) diagnostic {
+	if pd.Column <= 0 {
+		return d.lineDiagnostic(pd.Line, pd.Message)
+	}
+	line := pd.Line - 1
+	start := d.runeColumnToUTF16(line, pd.Column-1)
+	end := d.runeColumnToUTF16(line, pd.EndColumn-1)
+	if end <= start {
+		end = start + 1
+	}
+	return diagnostic{
+		Range:    textRange{Start: position{Line: line, Character: start}, End: position{Line: line, Character: end}},
+		Severity: severityError,
+		Source:   "rgo",
+		Message:  pd.Message,
+	}
+}
+
+// lineDiagnostic reports message against the text of a whole 1-based line.
+func (d *document) lineDiagnostic(line int, message string) diagnostic {
+	if line > len(d.lineStarts) {
+		line = len(d.lineStarts)
+	}
+	text := d.line(line - 1)
+	indent := len(text) - len(strings.TrimLeft(text, " \t"))
+	start := d.position(d.lineStarts[line-1] + indent)
+	end := d.position(d.lineStarts[line-1] + len(strings.TrimRight(text, " \t\r")))
+	if end.Character < start.Character {
+		end = start
+	}
+	return diagnostic{Range: textRange{Start: start, End: end}, Severity: severityError, Source: "rgo", Message: message}
+}
+
+// line returns the text of a 0-based line without its newline.
+func (d *document) line(line int) string {
+	if line < 0 || line >= len(d.lineStarts) {
+		return ""
+	}
+	end := len(d.text)
+	if line+1 < len(d.lineStarts) {
+		end = d.lineStarts[line+1] - 1
+	}
+	return d.text[d.lineStarts[line]:end]
+}
+
+func (d *document) runeColumnToUTF16(line, runes int) int {
+	character := 0
+	for _, r := range d.line(line) {
+		if runes <= 0 {
+			break
+		}
+		character += utf16.RuneLen(r)
+		runes--
+	}
+	return character + runes
+}
+
+// position converts a byte offset to an LSP position.
+func (d *document) position(offset int) position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := 0
+	for line+1 < len(d.lineStarts) && d.lineStarts[line+1] <= offset {
+		line++
+	}
+	character := 0
+	for _, r := range d.text[d.lineStarts[line]:offset] {
+		character += utf16.RuneLen(r)
+	}
+	return position{Line: line, Character: character}
+}
+
+// offset converts an LSP position to a byte offset, clamping it to the line.
+func (d *document) offset(pos position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+	start := d.lineStarts[pos.Line]
+	text := d.line(pos.Line)
+	character := 0
+	for i, r := range text {
+		if character >= pos.Character {
+			return start + i
+		}
+		character += utf16.RuneLen(r)
+	}
+	return start + len(text)
+}
+
+func (d *document) textRange(r ast.Range) textRange {
+	return textRange{Start: d.position(r.Start.Offset), End: d.position(r.End.Offset)}
+}
+
+// wordAt returns the identifier or constant name under pos together with
+// its byte range. A method name keeps a trailing ? or !.
+func (d *document) wordAt(pos position) (string, int, int) {
+	offset := d.offset(pos)
+	start, end := offset, offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(d.text[:start])
+		if !isWordRune(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(d.text) {
+		r, size := utf8.DecodeRuneInString(d.text[end:])
+		if !isWordRune(r) {
+			break
+		}
+		end += size
+	}
+	if end < len(d.text) && (d.text[end] == '?' || d.text[end] == '!') {
+		end++
+	}
+	if start == end {
+		return "", offset, offset
+	}
+	if r, _ := utf8.DecodeRuneInString(d.text[start:]); r >= '0' && r <= '9' {
+		return "", offset, offset
+	}
+	return d.text[start:end], start, end
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r >= utf8.RuneSelf
+}
+
+func isConstantName(name string) bool {
+	return name != "" && name[0] >= 'A' && name[0] <= 'Z'
+}
+
+// uriToPath returns the local path of a file: URI.
+func uriToPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return "", false
+	}
+	return filepath.FromSlash(u.Path), true
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
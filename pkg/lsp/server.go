@@ -0,0 +1,336 @@
+// Package lsp is a Language Server Protocol server for Ruby built on the rgo
+// parser and compiler. It speaks JSON-RPC over a byte stream (stdio for
+// `rgo lsp`) and keeps every open document and every .rb file of the
+// workspace parsed, so it can answer symbol, definition, hover and
+// completion requests and publish parse and compile errors as diagnostics.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// server holds the state of one client connection.
+type server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	root        string
+	initialized bool
+	shutdown    bool
+
+	// open holds the documents the client has opened, by URI; they
+	// override the copy of the same file in workspace.
+	open      map[string]*document
+	workspace map[string]*document
+}
+
+// Serve runs a server reading requests from in and writing responses and
+// notifications to out until the client sends exit or in is exhausted. It
+// returns an error if the stream breaks or the client exits without asking
+// the server to shut down first.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		open:      map[string]*document{},
+		workspace: map[string]*document{},
+	}
+	for {
+		payload, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(payload, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("lsp: exit before shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// maxMessageSize bounds the Content-Length the server will allocate for. It
+// is far beyond any real document but stops a bad header from exhausting
+// memory.
+const maxMessageSize = 64 << 20
+
+// readMessage reads one Content-Length framed message body.
+func (s *server) readMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("lsp: reading header: %v", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("lsp: bad Content-Length %q", header.Get("Content-Length"))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(s.in, payload); err != nil {
+		return nil, fmt.Errorf("lsp: reading body: %v", err)
+	}
+	return payload, nil
+}
+
+// write frames v as one message.
+func (s *server) write(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// reply answers the request with the given id with either result or err.
+func (s *server) reply(id *json.RawMessage, result interface{}, rpcErr *responseError) error {
+	var rawID interface{}
+	if id != nil {
+		rawID = id
+	}
+	message := map[string]interface{}{"jsonrpc": "2.0", "id": rawID}
+	if rpcErr != nil {
+		message["error"] = rpcErr
+	} else {
+		message["result"] = result
+	}
+	return s.write(message)
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	return s.write(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// handle dispatches one request or notification. Only failures to write to
+// the client are returned; everything else is reported to the client.
+func (s *server) handle(req request) (err error) {
+	isRequest := req.ID != nil
+	defer func() {
+		// A crash while analysing one document must not take the editor's
+		// language server down with it.
+		if recovered := recover(); recovered != nil {
+			if isRequest {
+				err = s.reply(req.ID, nil, &responseError{Code: codeInternalError, Message: fmt.Sprintf("internal error: %v", recovered)})
+			}
+		}
+	}()
+
+	if !s.initialized && req.Method != "initialize" {
+		if isRequest {
+			return s.reply(req.ID, nil, &responseError{Code: codeNotInitialized, Message: "server not initialized"})
+		}
+		return nil
+	}
+
+	var result interface{}
+	var rpcErr *responseError
+	switch req.Method {
+	case "initialize":
+		var params initializeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil && len(req.Params) > 0 {
+			rpcErr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+			break
+		}
+		s.root = workspaceRoot(params)
+		s.initialized = true
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       map[string]interface{}{"openClose": true, "change": 1, "save": true},
+				"documentSymbolProvider": true,
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "rgo"},
+		}
+	case "initialized":
+		s.indexWorkspace()
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(req.Params, &params) == nil {
+			return s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(req.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			// Full sync: the last change holds the whole text.
+			return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didSave":
+		var params didSaveParams
+		if json.Unmarshal(req.Params, &params) == nil && params.Text != nil {
+			return s.update(params.TextDocument.URI, *params.Text)
+		}
+	case "textDocument/didClose":
+		var params textDocumentParams
+		if json.Unmarshal(req.Params, &params) == nil {
+			uri := params.TextDocument.URI
+			delete(s.open, uri)
+			if path, ok := uriToPath(uri); ok {
+				if content, err := os.ReadFile(path); err == nil {
+					s.workspace[uri] = newDocument(uri, path, string(content))
+				} else {
+					delete(s.workspace, uri)
+				}
+			}
+			return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: []diagnostic{}})
+		}
+	case "textDocument/documentSymbol":
+		var params textDocumentParams
+		if rpcErr = decodeParams(req.Params, &params); rpcErr == nil {
+			symbols := []documentSymbol{}
+			if doc := s.document(params.TextDocument.URI); doc != nil {
+				symbols = doc.documentSymbols()
+			}
+			result = symbols
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if rpcErr = decodeParams(req.Params, &params); rpcErr == nil {
+			result = s.definition(params.TextDocument.URI, params.Position)
+		}
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if rpcErr = decodeParams(req.Params, &params); rpcErr == nil {
+			if h := s.hover(params.TextDocument.URI, params.Position); h != nil {
+				result = h
+			}
+		}
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if rpcErr = decodeParams(req.Params, &params); rpcErr == nil {
+			items := []completionItem{}
+			if doc := s.document(params.TextDocument.URI); doc != nil {
+				items = doc.completion(params.Position)
+			}
+			result = items
+		}
+	default:
+		if !isRequest || strings.HasPrefix(req.Method, "$/") {
+			return nil
+		}
+		rpcErr = &responseError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	if !isRequest {
+		return nil
+	}
+	return s.reply(req.ID, result, rpcErr)
+}
+
+func decodeParams(raw json.RawMessage, v interface{}) *responseError {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// update re-analyses an open document and publishes its diagnostics.
+func (s *server) update(uri, text string) error {
+	path, _ := uriToPath(uri)
+	doc := newDocument(uri, path, text)
+	if previous := s.open[uri]; previous != nil {
+		doc.keepAnalysis(previous)
+	}
+	s.open[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: doc.diagnostics})
+}
+
+// document returns the open copy of uri, or the workspace copy.
+func (s *server) document(uri string) *document {
+	if doc := s.open[uri]; doc != nil {
+		return doc
+	}
+	return s.workspace[uri]
+}
+
+// documents returns every known document, the one named first and the
+// rest in URI order, so definitions in the current file are listed first.
+func (s *server) documents(first string) []*document {
+	var uris []string
+	seen := map[string]bool{}
+	for _, store := range []map[string]*document{s.open, s.workspace} {
+		for uri := range store {
+			if !seen[uri] && uri != first {
+				seen[uri] = true
+				uris = append(uris, uri)
+			}
+		}
+	}
+	sort.Strings(uris)
+	var docs []*document
+	if doc := s.document(first); doc != nil {
+		docs = append(docs, doc)
+	}
+	for _, uri := range uris {
+		docs = append(docs, s.document(uri))
+	}
+	return docs
+}
+
+// indexWorkspace parses every .rb file under the workspace root, skipping
+// hidden directories.
+func (s *server) indexWorkspace() {
+	if s.root == "" {
+		return
+	}
+	_ = filepath.WalkDir(s.root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if name != s.root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(name, ".rb") {
+			return nil
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return nil
+		}
+		uri := pathToURI(name)
+		s.workspace[uri] = newDocument(uri, name, string(content))
+		return nil
+	})
+}
+
+func workspaceRoot(params initializeParams) string {
+	if path, ok := uriToPath(params.RootURI); ok {
+		return path
+	}
+	for _, folder := range params.WorkspaceFolders {
+		if path, ok := uriToPath(folder.URI); ok {
+			return path
+		}
+	}
+	return params.RootPath
+}
@@ -0,0 +1,290 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+)
+
+func init() {
+	core.Init()
+}
+
+type rpcMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+// session runs the server over the given messages and returns everything it
+// wrote back, in order. Entries with an "id" are requests, the rest are
+// notifications.
+func session(t *testing.T, messages ...map[string]interface{}) []rpcMessage {
+	t.Helper()
+	var in bytes.Buffer
+	for _, m := range messages {
+		m["jsonrpc"] = "2.0"
+		body, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	var out bytes.Buffer
+	if err := Serve(&in, &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	var replies []rpcMessage
+	reader := bufio.NewReader(&out)
+	for {
+		s := &server{in: reader}
+		payload, err := s.readMessage()
+		if err != nil {
+			break
+		}
+		var reply rpcMessage
+		if err := json.Unmarshal(payload, &reply); err != nil {
+			t.Fatalf("bad reply %s: %v", payload, err)
+		}
+		replies = append(replies, reply)
+	}
+	return replies
+}
+
+func replyTo(t *testing.T, replies []rpcMessage, id int, v interface{}) {
+	t.Helper()
+	for _, r := range replies {
+		if r.ID != nil && *r.ID == id {
+			if r.Error != nil {
+				t.Fatalf("request %d failed: %s", id, r.Error.Message)
+			}
+			if err := json.Unmarshal(r.Result, v); err != nil {
+				t.Fatalf("request %d: %v in %s", id, err, r.Result)
+			}
+			return
+		}
+	}
+	t.Fatalf("no reply to request %d", id)
+}
+
+func initialize(root string) []map[string]interface{} {
+	var rootURI interface{}
+	if root != "" {
+		rootURI = pathToURI(root)
+	}
+	return []map[string]interface{}{
+		{"id": 1, "method": "initialize", "params": map[string]interface{}{"rootUri": rootURI}},
+		{"method": "initialized", "params": map[string]interface{}{}},
+	}
+}
+
+func open(uri, text string) map[string]interface{} {
+	return map[string]interface{}{"method": "textDocument/didOpen", "params": map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "ruby", "version": 1, "text": text},
+	}}
+}
+
+func at(id int, method, uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{"id": id, "method": method, "params": map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}}
+}
+
+var shutdown = []map[string]interface{}{{"id": 99, "method": "shutdown"}, {"method": "exit"}}
+
+func TestDiagnosticsForParseAndCompileErrors(t *testing.T) {
+	uri := "file:///tmp/broken.rb"
+	messages := append(initialize(""),
+		open(uri, "def f(\n"),
+		map[string]interface{}{"method": "textDocument/didChange", "params": map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []map[string]interface{}{{"text": "x = 1\nif x\n  BEGIN { p 1 }\nend\n"}},
+		}},
+		map[string]interface{}{"method": "textDocument/didChange", "params": map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 3},
+			"contentChanges": []map[string]interface{}{{"text": "x = 1\n"}},
+		}},
+	)
+	replies := session(t, append(messages, shutdown...)...)
+	var published []publishDiagnosticsParams
+	for _, r := range replies {
+		if r.Method == "textDocument/publishDiagnostics" {
+			var params publishDiagnosticsParams
+			if err := json.Unmarshal(r.Params, &params); err != nil {
+				t.Fatal(err)
+			}
+			published = append(published, params)
+		}
+	}
+	if len(published) != 3 {
+		t.Fatalf("got %d diagnostics notifications, want 3", len(published))
+	}
+	if d := published[0].Diagnostics; len(d) != 1 || d[0].Range.Start.Line != 0 || !strings.Contains(d[0].Message, "syntax error") {
+		t.Fatalf("parse error diagnostics = %+v", d)
+	}
+	if d := published[1].Diagnostics; len(d) != 1 || d[0].Range.Start != (position{Line: 2, Character: 2}) || d[0].Range.End != (position{Line: 2, Character: 15}) || !strings.Contains(d[0].Message, "BEGIN") {
+		t.Fatalf("compile error diagnostics = %+v", d)
+	}
+	if d := published[2].Diagnostics; len(d) != 0 {
+		t.Fatalf("clean document still has diagnostics %+v", d)
+	}
+}
+
+const cartSource = `module Shop
+  class Cart < Base
+    LIMIT = 10
+
+    def add(item, quantity = 1, gift: false)
+      total = quantity
+      items.each do |entry|
+        entry
+      end
+      total
+    end
+
+    def self.build = new
+  end
+end
+`
+
+func TestDocumentSymbolsAndCompletion(t *testing.T) {
+	uri := "file:///tmp/cart.rb"
+	messages := append(initialize(""), open(uri, cartSource),
+		map[string]interface{}{"id": 2, "method": "textDocument/documentSymbol", "params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+		}},
+		at(3, "textDocument/completion", uri, 7, 8),
+		at(4, "textDocument/completion", uri, 9, 6),
+		at(5, "textDocument/completion", uri, 12, 4),
+	)
+	replies := session(t, append(messages, shutdown...)...)
+
+	var symbols []documentSymbol
+	replyTo(t, replies, 2, &symbols)
+	if len(symbols) != 1 || symbols[0].Name != "Shop" || symbols[0].Kind != symbolKindModule {
+		t.Fatalf("top-level symbols = %+v", symbols)
+	}
+	cart := symbols[0].Children
+	if len(cart) != 1 || cart[0].Name != "Cart" || cart[0].Detail != "Shop" || cart[0].Range.Start.Line != 1 || cart[0].Range.End.Line != 13 {
+		t.Fatalf("class symbol = %+v", cart)
+	}
+	var names []string
+	for _, child := range cart[0].Children {
+		names = append(names, fmt.Sprintf("%s/%d/%d", child.Name, child.Kind, child.SelectionRange.Start.Line))
+	}
+	if got := strings.Join(names, " "); got != "LIMIT/14/2 add/6/4 self.build/6/12" {
+		t.Fatalf("class members = %s", got)
+	}
+
+	labels := func(id int) string {
+		var items []completionItem
+		replyTo(t, replies, id, &items)
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Label)
+		}
+		return strings.Join(out, " ")
+	}
+	if got := labels(3); got != "entry gift item quantity total" {
+		t.Fatalf("completion inside block = %q", got)
+	}
+	if got := labels(4); got != "gift item quantity total" {
+		t.Fatalf("completion inside method = %q", got)
+	}
+	if got := labels(5); got != "" {
+		t.Fatalf("completion in class body = %q", got)
+	}
+}
+
+func TestDefinitionAndHoverAcrossWorkspace(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "cart.rb"), []byte(cartSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, ".hidden"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".hidden", "cart.rb"), []byte("class Cart; end\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(filepath.Join(root, "main.rb"))
+	cartURI := pathToURI(filepath.Join(root, "cart.rb"))
+	main := "cart = Shop::Cart.build\ncart.add(1)\nShop::Cart::LIMIT\n"
+	messages := append(initialize(root), open(uri, main),
+		at(2, "textDocument/definition", uri, 0, 14),
+		at(3, "textDocument/definition", uri, 1, 6),
+		at(4, "textDocument/definition", uri, 1, 1),
+		at(5, "textDocument/hover", uri, 1, 6),
+		at(6, "textDocument/hover", uri, 2, 14),
+		at(7, "textDocument/definition", uri, 0, 19),
+	)
+	replies := session(t, append(messages, shutdown...)...)
+
+	var locations []location
+	replyTo(t, replies, 2, &locations)
+	if len(locations) != 1 || locations[0].URI != cartURI || locations[0].Range.Start != (position{Line: 1, Character: 8}) {
+		t.Fatalf("definition of Cart = %+v", locations)
+	}
+	replyTo(t, replies, 3, &locations)
+	if len(locations) != 1 || locations[0].Range.Start != (position{Line: 4, Character: 8}) {
+		t.Fatalf("definition of add = %+v", locations)
+	}
+	replyTo(t, replies, 4, &locations)
+	if len(locations) != 0 {
+		t.Fatalf("a local variable resolved to %+v", locations)
+	}
+	var h hover
+	replyTo(t, replies, 5, &h)
+	if !strings.Contains(h.Contents.Value, "def add(item, quantity = 1, gift: false)\n") || !strings.Contains(h.Contents.Value, "cart.rb:5") {
+		t.Fatalf("hover on add = %q", h.Contents.Value)
+	}
+	replyTo(t, replies, 6, &h)
+	if !strings.Contains(h.Contents.Value, "LIMIT = 10\n") {
+		t.Fatalf("hover on LIMIT = %q", h.Contents.Value)
+	}
+	replyTo(t, replies, 7, &locations)
+	if len(locations) != 1 || locations[0].Range.Start != (position{Line: 12, Character: 13}) {
+		t.Fatalf("definition of build = %+v", locations)
+	}
+}
+
+func TestUnknownRequests(t *testing.T) {
+	replies := session(t, append(initialize(""), map[string]interface{}{"id": 2, "method": "textDocument/rename"},
+		map[string]interface{}{"method": "$/cancelRequest"})...)
+	for _, r := range replies {
+		if r.ID != nil && *r.ID == 2 {
+			if r.Error == nil || r.Error.Code != codeMethodNotFound {
+				t.Fatalf("unknown request reply = %+v", r)
+			}
+			return
+		}
+	}
+	t.Fatal("no reply to unknown request")
+}
+
+func TestExitWithoutShutdownFails(t *testing.T) {
+	in := bytes.NewBufferString("Content-Length: 33\r\n\r\n{\"jsonrpc\":\"2.0\",\"method\":\"exit\"}")
+	if err := Serve(in, &bytes.Buffer{}); err == nil {
+		t.Fatal("exit before shutdown should be an error")
+	}
+}
+
+func TestOversizedContentLengthFails(t *testing.T) {
+	for _, length := range []string{"-1", "68719476736", "99999999999999999999"} {
+		in := bytes.NewBufferString("Content-Length: " + length + "\r\n\r\n{}")
+		err := Serve(in, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "bad Content-Length") {
+			t.Errorf("Content-Length %s: error = %v, want bad Content-Length", length, err)
+		}
+	}
+}
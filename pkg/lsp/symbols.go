@@ -0,0 +1,276 @@
+package lsp
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// childNodes returns the nodes directly below node.
+func childNodes(node ast.Node) []ast.Node {
+	var children []ast.Node
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == node {
+			return true
+		}
+		children = append(children, n)
+		return false
+	})
+	return children
+}
+
+// collectDefinitions finds the classes, modules, constants and methods a
+// program defines, nested the way they are in the source.
+func collectDefinitions(program *ast.Program) []*definition {
+	var visit func(node ast.Node, container string) []*definition
+	within := func(node ast.Node, container string) []*definition {
+		var found []*definition
+		for _, child := range childNodes(node) {
+			found = append(found, visit(child, container)...)
+		}
+		return found
+	}
+	visit = func(node ast.Node, container string) []*definition {
+		var def *definition
+		switch n := node.(type) {
+		case *ast.ClassExpression:
+			if n.SingletonReceiver == nil && n.Name != nil {
+				def = &definition{name: n.Name.Value, kind: symbolKindClass, nameLoc: n.Name.Loc}
+			}
+		case *ast.ModuleExpression:
+			if n.Name != nil {
+				def = &definition{name: n.Name.Value, kind: symbolKindModule, nameLoc: n.Name.Loc}
+			}
+		case *ast.DefExpression:
+			if n.Name != nil {
+				def = &definition{name: n.Name.Value, kind: symbolKindMethod, nameLoc: n.Name.Loc}
+				if n.Receiver != nil {
+					def.name = n.Receiver.String() + "." + def.name
+				}
+			}
+		case *ast.AssignExpression:
+			if n.Target == nil && n.Index == nil && n.Name != nil && isConstantName(n.Name.Value) {
+				def = &definition{name: n.Name.Value, kind: symbolKindConstant, nameLoc: n.Name.Loc}
+			}
+		}
+		if def == nil {
+			return within(node, container)
+		}
+		def.node, def.loc, def.container = node, node.SourceRange(), container
+		if def.kind == symbolKindClass || def.kind == symbolKindModule {
+			inner := def.name
+			if container != "" && !strings.HasPrefix(inner, "::") {
+				inner = container + "::" + inner
+			}
+			def.children = within(node, strings.TrimPrefix(inner, "::"))
+		}
+		return []*definition{def}
+	}
+	return within(program, "")
+}
+
+// shortName is the name a definition is looked up by: the last segment of
+// a constant path and the method name without its receiver.
+func (def *definition) shortName() string {
+	name := def.name
+	if i := strings.LastIndex(name, "::"); i >= 0 {
+		name = name[i+2:]
+	}
+	if def.kind == symbolKindMethod {
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	return name
+}
+
+func (d *document) documentSymbols() []documentSymbol {
+	var convert func(defs []*definition) []documentSymbol
+	convert = func(defs []*definition) []documentSymbol {
+		symbols := []documentSymbol{}
+		for _, def := range defs {
+			symbol := documentSymbol{
+				Name:           def.name,
+				Detail:         def.container,
+				Kind:           def.kind,
+				Range:          d.textRange(def.loc),
+				SelectionRange: d.textRange(def.nameLoc),
+			}
+			if len(def.children) > 0 {
+				symbol.Children = convert(def.children)
+			}
+			symbols = append(symbols, symbol)
+		}
+		return symbols
+	}
+	return convert(d.definitions)
+}
+
+// eachDefinition calls fn for every definition in d, outer ones first.
+func (d *document) eachDefinition(fn func(*definition)) {
+	var walk func(defs []*definition)
+	walk = func(defs []*definition) {
+		for _, def := range defs {
+			fn(def)
+			walk(def.children)
+		}
+	}
+	walk(d.definitions)
+}
+
+type definitionMatch struct {
+	doc *document
+	def *definition
+}
+
+// lookup finds the definitions the word under pos in uri can refer to: a
+// constant names classes, modules and constants, anything else names
+// methods, unless it is a local variable in scope there.
+func (s *server) lookup(uri string, pos position) (matches []definitionMatch, start, end int, doc *document) {
+	doc = s.document(uri)
+	if doc == nil {
+		return nil, 0, 0, nil
+	}
+	word, start, end := doc.wordAt(pos)
+	if word == "" {
+		return nil, start, end, doc
+	}
+	constant := isConstantName(word)
+	if !constant {
+		for _, name := range doc.visibleLocals(doc.offset(pos)) {
+			if name == word {
+				return nil, start, end, doc
+			}
+		}
+	}
+	for _, candidate := range s.documents(uri) {
+		candidate.eachDefinition(func(def *definition) {
+			name := def.shortName()
+			if constant && def.kind != symbolKindMethod && name == word ||
+				!constant && def.kind == symbolKindMethod && (name == word || name == word+"=") {
+				matches = append(matches, definitionMatch{doc: candidate, def: def})
+			}
+		})
+	}
+	return matches, start, end, doc
+}
+
+func (s *server) definition(uri string, pos position) []location {
+	matches, _, _, _ := s.lookup(uri, pos)
+	locations := []location{}
+	for _, m := range matches {
+		locations = append(locations, location{URI: m.doc.uri, Range: m.doc.textRange(m.def.nameLoc)})
+	}
+	return locations
+}
+
+func (s *server) hover(uri string, pos position) *hover {
+	matches, start, end, doc := s.lookup(uri, pos)
+	if len(matches) == 0 {
+		return nil
+	}
+	var parts []string
+	for _, m := range matches {
+		where := m.doc.path
+		if rel, err := filepath.Rel(s.root, where); s.root != "" && err == nil && !strings.HasPrefix(rel, "..") {
+			where = rel
+		}
+		parts = append(parts, "```ruby\n"+m.doc.signature(m.def)+"\n```\n"+where+":"+strconv.Itoa(m.def.loc.Start.Line))
+	}
+	r := textRange{Start: doc.position(start), End: doc.position(end)}
+	return &hover{Contents: markupContent{Kind: "markdown", Value: strings.Join(parts, "\n\n---\n\n")}, Range: &r}
+}
+
+// signature is the head of a definition as written in the source, with
+// line breaks between parameters folded away: "def add(a, b = 1)",
+// "class Cart < Base", "LIMIT = 10".
+func (d *document) signature(def *definition) string {
+	end := def.nameLoc.End.Offset
+	widen := func(n ast.Node) {
+		if n != nil && !reflect.ValueOf(n).IsNil() && n.SourceRange().IsValid() && n.SourceRange().End.Offset > end {
+			end = n.SourceRange().End.Offset
+		}
+	}
+	switch n := def.node.(type) {
+	case *ast.DefExpression:
+		for _, p := range n.Params {
+			widen(p)
+		}
+		for _, p := range n.ParamDefaults {
+			widen(p)
+		}
+		for _, kp := range n.KeywordParams {
+			if kp.Loc.IsValid() && kp.Loc.End.Offset > end {
+				end = kp.Loc.End.Offset
+			}
+			widen(kp.Default)
+		}
+		widen(n.RestParam)
+		widen(n.KeywordRestParam)
+		widen(n.BlockParam)
+		rest := strings.TrimLeft(d.text[end:], " \t\r\n")
+		if strings.HasPrefix(rest, ")") {
+			end = len(d.text) - len(rest) + 1
+		}
+	case *ast.ClassExpression:
+		widen(n.SuperClass)
+	case *ast.AssignExpression:
+		end = def.loc.End.Offset
+		if newline := strings.IndexByte(d.text[def.loc.Start.Offset:end], '\n'); newline >= 0 {
+			end = def.loc.Start.Offset + newline
+		}
+	}
+	if def.kind == symbolKindClass || def.kind == symbolKindModule {
+		// The name range covers only the first segment of a constant path.
+		rest := d.text[end:]
+		for strings.HasPrefix(rest, "::") {
+			rest = strings.TrimLeft(rest[2:], "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_")
+		}
+		end = len(d.text) - len(rest)
+	}
+	return strings.Join(strings.Fields(d.text[def.loc.Start.Offset:end]), " ")
+}
+
+// visibleLocals returns the local variables in scope at offset: those of the
+// innermost scope the compiler recorded around it, and of the scopes
+// enclosing that one up to the nearest method, class or module body.
+func (d *document) visibleLocals(offset int) []string {
+	table := d.topLevel
+	best := -1
+	for _, scope := range d.scopes {
+		r := scope.Node.SourceRange()
+		if !r.IsValid() || offset < r.Start.Offset || offset > r.End.Offset {
+			continue
+		}
+		if size := r.End.Offset - r.Start.Offset; best < 0 || size < best {
+			best, table = size, scope.Table
+		}
+	}
+	seen := map[string]bool{}
+	var names []string
+	for ; table != nil; table = table.Outer {
+		for _, name := range table.LocalNames() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if table.MethodBoundary {
+			break
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *document) completion(pos position) []completionItem {
+	items := []completionItem{}
+	for _, name := range d.visibleLocals(d.offset(pos)) {
+		items = append(items, completionItem{Label: name, Kind: completionKindVariable, Detail: "local variable"})
+	}
+	return items
+}
@@ -58,6 +58,9 @@ type ValueColdData struct {
 	StringBuilder *strings.Builder
 	Allocation    *AllocationMetadata
 	LazyArray     *LazyArrayRegion
+	// Host is Go data attached to the object by an embedding program. A dup
+	// shares the same Go value.
+	Host any
 }
 
 // LazyArrayRegion is a deliberately small escape hatch for a proven VM
@@ -182,6 +185,26 @@ func (v *EmeraldValue) SetLazyArrayRegion(region *LazyArrayRegion) {
 	v.Cold.LazyArray = region
 }
 
+func (v *EmeraldValue) HostData() any {
+	if v == nil || v.Cold == nil {
+		return nil
+	}
+	return v.Cold.Host
+}
+
+func (v *EmeraldValue) SetHostData(value any) {
+	if v == nil {
+		return
+	}
+	if value == nil && v.Cold == nil {
+		return
+	}
+	if v.Cold == nil {
+		v.Cold = &ValueColdData{}
+	}
+	v.Cold.Host = value
+}
+
 // MaterializeLazyArray commits a deferred Array region to the ordinary slice
 // representation. The caller can use the returned slice for generic Array
 // operations; a false result means that the value was not a lazy Array.
@@ -0,0 +1,276 @@
+// Package packager builds and opens packaged Ruby applications: a copy of
+// the rgo executable with the application's sources appended as a zip
+// archive. A packaged executable runs its entry script instead of parsing
+// rgo's command line.
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// trailerMagic ends a packaged executable. It is preceded by the length of
+// the archive as a little-endian uint64.
+var trailerMagic = []byte("RGOPACK1")
+
+const trailerSize = 16
+
+// manifestName is the archive member describing the application.
+const manifestName = "rgo-package.json"
+
+// Manifest describes a packaged application. Paths are slash-separated
+// and relative to the archive root.
+type Manifest struct {
+	// Entry is the script run when the executable starts.
+	Entry string `json:"entry"`
+	// LoadPaths are prepended to $LOAD_PATH, before the directories of
+	// the host, so the application's own libraries are required first.
+	LoadPaths []string `json:"load_paths,omitempty"`
+}
+
+// Options says what to put in a package.
+type Options struct {
+	// Entry is the application's main script. Every .rb file in its
+	// directory tree is packaged, skipping hidden directories.
+	Entry string
+	// LibDirs are additional library directories, packaged with their .rb
+	// files and added to the load path.
+	LibDirs []string
+	// Runtime is the rgo executable to copy. A runtime that is itself a
+	// package contributes only its executable part.
+	Runtime string
+}
+
+// Build writes a packaged executable for opts to w.
+func Build(w io.Writer, opts Options) error {
+	runtime, err := os.Open(opts.Runtime)
+	if err != nil {
+		return err
+	}
+	defer runtime.Close()
+	info, err := runtime.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if offset, _, ok := findArchive(runtime, size); ok {
+		size = offset
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(runtime, 0, size)); err != nil {
+		return err
+	}
+
+	var archive bytes.Buffer
+	if err := writeArchive(&archive, opts); err != nil {
+		return err
+	}
+	var trailer [trailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[:8], uint64(archive.Len()))
+	copy(trailer[8:], trailerMagic)
+	if _, err := w.Write(archive.Bytes()); err != nil {
+		return err
+	}
+	_, err = w.Write(trailer[:])
+	return err
+}
+
+func writeArchive(w io.Writer, opts Options) error {
+	entry, err := filepath.Abs(opts.Entry)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(entry); err != nil {
+		return err
+	} else if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file", opts.Entry)
+	}
+	appDir := filepath.Dir(entry)
+	manifest := Manifest{Entry: path.Join("app", filepath.ToSlash(filepath.Base(entry)))}
+
+	z := zip.NewWriter(w)
+	for index, dir := range opts.LibDirs {
+		files, err := rubyFiles(dir)
+		if err != nil {
+			return err
+		}
+		prefix := path.Join("lib", strconv.Itoa(index))
+		for _, file := range files {
+			if err := addFile(z, path.Join(prefix, file), filepath.Join(dir, filepath.FromSlash(file))); err != nil {
+				return err
+			}
+		}
+		manifest.LoadPaths = append(manifest.LoadPaths, prefix)
+	}
+	files, err := rubyFiles(appDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := addFile(z, path.Join("app", file), filepath.Join(appDir, filepath.FromSlash(file))); err != nil {
+			return err
+		}
+		// Vendored gems keep their lib directories on the load path, as
+		// Bundler's standalone setup would.
+		if dir := vendoredGemLib(file); dir != "" && !contains(manifest.LoadPaths, path.Join("app", dir)) {
+			manifest.LoadPaths = append(manifest.LoadPaths, path.Join("app", dir))
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	member, err := z.Create(manifestName)
+	if err != nil {
+		return err
+	}
+	if _, err := member.Write(data); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// rubyFiles lists the .rb files under dir, slash-separated and relative to
+// it, skipping hidden directories.
+func rubyFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && strings.HasSuffix(d.Name(), ".rb") {
+			relative, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(relative))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// vendoredGemLib returns the lib directory of the vendored gem holding
+// file, for gems under vendor/bundle/ruby/<version>/gems/<gem>/lib or
+// vendor/gems/<gem>/lib, or "".
+func vendoredGemLib(file string) string {
+	parts := strings.Split(file, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] != "vendor" {
+			continue
+		}
+		switch {
+		case i+6 < len(parts) && parts[i+1] == "bundle" && parts[i+2] == "ruby" && parts[i+4] == "gems" && parts[i+6] == "lib":
+			return strings.Join(parts[:i+7], "/")
+		case i+3 < len(parts) && parts[i+1] == "gems" && parts[i+3] == "lib":
+			return strings.Join(parts[:i+4], "/")
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func addFile(z *zip.Writer, name, source string) error {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	member, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = member.Write(data)
+	return err
+}
+
+// Package is an opened packaged application.
+type Package struct {
+	Manifest Manifest
+	// Files holds the packaged sources, named as in Manifest.
+	Files fs.FS
+	file  *os.File
+}
+
+// Close releases the executable the package was read from.
+func (p *Package) Close() error {
+	return p.file.Close()
+}
+
+// ErrNotPackaged reports an executable without an appended application.
+var ErrNotPackaged = errors.New("not a packaged application")
+
+// Open reads the application appended to the executable at name. It returns
+// ErrNotPackaged for a plain rgo executable.
+func Open(name string) (*Package, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	offset, length, ok := findArchive(file, info.Size())
+	if !ok {
+		file.Close()
+		return nil, ErrNotPackaged
+	}
+	archive, err := zip.NewReader(io.NewSectionReader(file, offset, length), length)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: corrupt package: %v", name, err)
+	}
+	p := &Package{Files: archive, file: file}
+	data, err := fs.ReadFile(archive, manifestName)
+	if err == nil {
+		err = json.Unmarshal(data, &p.Manifest)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: corrupt package: %v", name, err)
+	}
+	return p, nil
+}
+
+// findArchive locates the archive before the trailer of a file of size
+// bytes.
+func findArchive(r io.ReaderAt, size int64) (offset, length int64, ok bool) {
+	if size < trailerSize {
+		return 0, 0, false
+	}
+	var trailer [trailerSize]byte
+	if _, err := r.ReadAt(trailer[:], size-trailerSize); err != nil || !bytes.Equal(trailer[8:], trailerMagic) {
+		return 0, 0, false
+	}
+	length = int64(binary.LittleEndian.Uint64(trailer[:8]))
+	if length <= 0 || length > size-trailerSize {
+		return 0, 0, false
+	}
+	return size - trailerSize - length, length, true
+}
@@ -0,0 +1,117 @@
+package packager
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func build(t *testing.T, output string, opts Options) {
+	t.Helper()
+	var out bytes.Buffer
+	if err := Build(&out, opts); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if err := os.WriteFile(output, out.Bytes(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildAppendsTheApplication(t *testing.T) {
+	dir := t.TempDir()
+	runtime := filepath.Join(dir, "rgo")
+	if err := os.WriteFile(runtime, []byte("runtime executable"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(runtime); !errors.Is(err, ErrNotPackaged) {
+		t.Fatalf("Open of a plain runtime: %v", err)
+	}
+	writeFiles(t, filepath.Join(dir, "src"), map[string]string{
+		"tool.rb":       "require_relative 'lib/helper'\n",
+		"lib/helper.rb": "module Helper; end\n",
+		"README.md":     "not ruby\n",
+		".git/hook.rb":  "hidden\n",
+		"vendor/bundle/ruby/3.4.0/gems/color-1.0/lib/color.rb": "module Color; end\n",
+	})
+	writeFiles(t, filepath.Join(dir, "shared"), map[string]string{"util.rb": "module Util; end\n"})
+
+	app := filepath.Join(dir, "tool")
+	build(t, app, Options{
+		Entry:   filepath.Join(dir, "src", "tool.rb"),
+		LibDirs: []string{filepath.Join(dir, "shared")},
+		Runtime: runtime,
+	})
+	data, err := os.ReadFile(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("runtime executable")) {
+		t.Fatalf("package does not start with the runtime")
+	}
+
+	pkg, err := Open(app)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer pkg.Close()
+	want := Manifest{
+		Entry:     "app/tool.rb",
+		LoadPaths: []string{"lib/0", "app/vendor/bundle/ruby/3.4.0/gems/color-1.0/lib"},
+	}
+	if !reflect.DeepEqual(pkg.Manifest, want) {
+		t.Fatalf("manifest %+v, want %+v", pkg.Manifest, want)
+	}
+	var names []string
+	fs.WalkDir(pkg.Files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && path != manifestName {
+			names = append(names, path)
+		}
+		return err
+	})
+	sort.Strings(names)
+	wantNames := []string{
+		"app/lib/helper.rb",
+		"app/tool.rb",
+		"app/vendor/bundle/ruby/3.4.0/gems/color-1.0/lib/color.rb",
+		"lib/0/util.rb",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("packaged %v, want %v", names, wantNames)
+	}
+	if source, err := fs.ReadFile(pkg.Files, "app/lib/helper.rb"); err != nil || string(source) != "module Helper; end\n" {
+		t.Fatalf("helper.rb = %q, %v", source, err)
+	}
+
+	// A package used as the runtime contributes only the executable.
+	again := filepath.Join(dir, "again")
+	build(t, again, Options{Entry: filepath.Join(dir, "shared", "util.rb"), Runtime: app})
+	repackaged, err := Open(again)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer repackaged.Close()
+	if _, err := fs.Stat(repackaged.Files, "app/tool.rb"); err == nil {
+		t.Fatalf("repackaging kept the previous application")
+	}
+	if repackaged.Manifest.Entry != "app/util.rb" {
+		t.Fatalf("entry %q", repackaged.Manifest.Entry)
+	}
+}
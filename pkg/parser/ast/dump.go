@@ -0,0 +1,151 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+)
+
+var (
+	tokenType   = reflect.TypeOf(lexer.Token{})
+	locatedType = reflect.TypeOf(Located{})
+	rangeType   = reflect.TypeOf(Range{})
+)
+
+// Dump writes node as an indented tree, one node per line with its source
+// range (or token position) and scalar fields, followed by its child nodes labelled by field
+// name. It is the output of `rgo --dump=parsetree`.
+func Dump(w io.Writer, node Node) error {
+	d := &treeDumper{w: w, seen: make(map[uintptr]bool)}
+	d.node("", reflect.ValueOf(node), 0)
+	return d.err
+}
+
+type treeDumper struct {
+	w    io.Writer
+	seen map[uintptr]bool
+	err  error
+}
+
+func (d *treeDumper) printf(depth int, format string, args ...interface{}) {
+	if d.err == nil {
+		_, d.err = fmt.Fprintf(d.w, strings.Repeat("  ", depth)+format+"\n", args...)
+	}
+}
+
+func (d *treeDumper) node(label string, value reflect.Value, depth int) {
+	for value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		if d.seen[value.Pointer()] {
+			d.printf(depth, "%s(cycle)", label)
+			return
+		}
+		d.seen[value.Pointer()] = true
+		defer delete(d.seen, value.Pointer())
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		d.printf(depth, "%s%s", label, formatScalar(value))
+		return
+	}
+
+	header := label + value.Type().Name()
+	located := false
+	var children []reflect.StructField
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		fieldValue := value.Field(i)
+		if !field.IsExported() || fieldValue.IsZero() {
+			continue
+		}
+		if field.Name == "Order" && value.FieldByName("Pairs").Kind() == reflect.Map {
+			continue
+		}
+		if field.Type == locatedType || field.Type == rangeType {
+			var r Range
+			if field.Type == locatedType {
+				r = fieldValue.Interface().(Located).Loc
+			} else {
+				r = fieldValue.Interface().(Range)
+			}
+			if r.IsValid() {
+				header += fmt.Sprintf(" (%s)", r)
+				located = true
+			}
+			continue
+		}
+		if field.Type == tokenType {
+			token := fieldValue.Interface().(lexer.Token)
+			if token.Line > 0 && !located {
+				header += fmt.Sprintf(" (%d:%d)", token.Line, token.Column)
+			}
+			continue
+		}
+		if isScalar(fieldValue) {
+			header += fmt.Sprintf(" %s=%s", field.Name, formatScalar(fieldValue))
+			continue
+		}
+		children = append(children, field)
+	}
+	d.printf(depth, "%s", header)
+	for _, field := range children {
+		fieldValue := value.FieldByIndex(field.Index)
+		switch fieldValue.Kind() {
+		case reflect.Slice, reflect.Array:
+			d.printf(depth+1, "%s: (%d)", field.Name, fieldValue.Len())
+			for i := 0; i < fieldValue.Len(); i++ {
+				d.node(fmt.Sprintf("[%d] ", i), fieldValue.Index(i), depth+2)
+			}
+		case reflect.Map:
+			// Hash literal pairs live in a map; Order keeps the source order.
+			d.printf(depth+1, "%s: (%d)", field.Name, fieldValue.Len())
+			order := value.FieldByName("Order")
+			for i := 0; order.IsValid() && i < order.Len(); i++ {
+				key := order.Index(i)
+				d.node(fmt.Sprintf("[%d] key: ", i), key, depth+2)
+				d.node(fmt.Sprintf("[%d] value: ", i), fieldValue.MapIndex(key), depth+2)
+			}
+		default:
+			d.node(field.Name+": ", fieldValue, depth+1)
+		}
+	}
+}
+
+func isScalar(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		kind := value.Type().Elem().Kind()
+		return kind == reflect.String || (kind >= reflect.Bool && kind <= reflect.Float64)
+	}
+	return false
+}
+
+func formatScalar(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%q", value.String())
+	case reflect.Slice:
+		parts := make([]string, value.Len())
+		for i := range parts {
+			parts[i] = formatScalar(value.Index(i))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(value.Interface())
+}
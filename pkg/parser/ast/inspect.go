@@ -0,0 +1,72 @@
+package ast
+
+import "reflect"
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+// Inspect walks the tree rooted at node in source order. It calls fn for
+// node and, when fn returns true, for every node below it: those held in
+// its fields, in slices of them and in the clause structs (rescue clauses,
+// keyword parameters and the like) hanging off it. Hash literals yield each
+// key followed by its value.
+func Inspect(node Node, fn func(Node) bool) {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return
+	}
+	i := &inspector{fn: fn, active: make(map[uintptr]bool)}
+	i.node(reflect.ValueOf(node))
+}
+
+type inspector struct {
+	fn     func(Node) bool
+	active map[uintptr]bool
+}
+
+func (i *inspector) node(value reflect.Value) {
+	pointer := value.Pointer()
+	if i.active[pointer] || !i.fn(value.Interface().(Node)) {
+		return
+	}
+	i.active[pointer] = true
+	i.value(value.Elem())
+	delete(i.active, pointer)
+}
+
+func (i *inspector) value(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if value.IsNil() {
+			return
+		}
+		if value.Kind() == reflect.Pointer && value.Type().Implements(nodeType) {
+			i.node(value)
+			return
+		}
+		i.value(value.Elem())
+	case reflect.Struct:
+		pairs := value.FieldByName("Pairs")
+		for n := 0; n < value.NumField(); n++ {
+			field := value.Type().Field(n)
+			if !field.IsExported() || field.Type == tokenType || field.Type == locatedType {
+				continue
+			}
+			if field.Name == "Pairs" && pairs.Kind() == reflect.Map {
+				continue
+			}
+			if field.Name == "Order" && pairs.Kind() == reflect.Map {
+				order := value.Field(n)
+				for k := 0; k < order.Len(); k++ {
+					key := order.Index(k)
+					i.value(key)
+					i.value(pairs.MapIndex(key))
+				}
+				continue
+			}
+			i.value(value.Field(n))
+		}
+	case reflect.Slice, reflect.Array:
+		for n := 0; n < value.Len(); n++ {
+			i.value(value.Index(n))
+		}
+	}
+}
@@ -10,6 +10,66 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	SourceRange() Range
+	SetSourceRange(Range)
+}
+
+// Position is a point in the source: a byte offset with its 1-based line
+// and 0-based byte column, the convention MRI uses for node positions.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// Range is the source extent of a node, from Start up to but not including
+// End.
+type Range struct {
+	Start Position
+	End   Position
+}
+
+// IsValid reports whether the parser recorded r. Nodes synthesised with no
+// source text of their own, such as an endless range's implicit nil, have
+// none.
+func (r Range) IsValid() bool {
+	return r.Start.Line > 0
+}
+
+// Cover returns the smallest range holding both r and other.
+func (r Range) Cover(other Range) Range {
+	if !other.IsValid() {
+		return r
+	}
+	if !r.IsValid() {
+		return other
+	}
+	if other.Start.Offset < r.Start.Offset {
+		r.Start = other.Start
+	}
+	if other.End.Offset > r.End.Offset {
+		r.End = other.End
+	}
+	return r
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%d:%d-%d:%d", r.Start.Line, r.Start.Column, r.End.Line, r.End.Column)
+}
+
+// Located is embedded in every node and holds its source range.
+type Located struct {
+	Loc Range
+}
+
+func (l *Located) SourceRange() Range     { return l.Loc }
+func (l *Located) SetSourceRange(r Range) { l.Loc = r }
+
+// Comment is a # comment or =begin/=end block, kept on the Program in
+// source order.
+type Comment struct {
+	Text string
+	Loc  Range
 }
 
 type Statement interface {
@@ -23,7 +83,9 @@ type Expression interface {
 }
 
 type Program struct {
+	Located
 	Statements []Statement
+	Comments   []*Comment
 }
 
 func (p *Program) TokenLiteral() string {
@@ -41,7 +103,23 @@ func (p *Program) String() string {
 	return out
 }
 
+// ErrorNode stands in for a statement the parser could not make sense of.
+// It covers the source skipped to get back to a statement boundary, so the
+// statements around it still parse. Message is the error reported for it.
+type ErrorNode struct {
+	Located
+	Token   lexer.Token
+	Message string
+	Source  string
+}
+
+func (e *ErrorNode) statementNode()       {}
+func (e *ErrorNode) expressionNode()      {}
+func (e *ErrorNode) TokenLiteral() string { return e.Token.Literal }
+func (e *ErrorNode) String() string       { return e.Source }
+
 type Identifier struct {
+	Located
 	Token lexer.Token
 	Value string
 }
@@ -51,6 +129,7 @@ func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
 
 type Boolean struct {
+	Located
 	Token lexer.Token
 	Value bool
 }
@@ -60,6 +139,7 @@ func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type IntegerLiteral struct {
+	Located
 	Token lexer.Token
 	Value int64
 }
@@ -69,6 +149,7 @@ func (i *IntegerLiteral) TokenLiteral() string { return i.Token.Literal }
 func (i *IntegerLiteral) String() string       { return i.Token.Literal }
 
 type FloatLiteral struct {
+	Located
 	Token lexer.Token
 	Value float64
 }
@@ -78,11 +159,13 @@ func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
 func (f *FloatLiteral) String() string       { return f.Token.Literal }
 
 type RationalLiteral struct {
+	Located
 	Token lexer.Token
 	Value string
 }
 
 type ImaginaryLiteral struct {
+	Located
 	Token   lexer.Token
 	Numeric Expression
 }
@@ -96,6 +179,7 @@ func (r *RationalLiteral) TokenLiteral() string { return r.Token.Literal }
 func (r *RationalLiteral) String() string       { return r.Token.Literal }
 
 type StringLiteral struct {
+	Located
 	Token        lexer.Token
 	Value        string
 	Interpolates bool
@@ -107,6 +191,7 @@ func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
 func (s *StringLiteral) String() string       { return s.Token.Literal }
 
 type StringConcatExpression struct {
+	Located
 	Token lexer.Token
 	Parts []*StringLiteral
 }
@@ -122,6 +207,7 @@ func (s *StringConcatExpression) String() string {
 }
 
 type SymbolLiteral struct {
+	Located
 	Token lexer.Token
 	Value string
 }
@@ -131,6 +217,7 @@ func (s *SymbolLiteral) TokenLiteral() string { return s.Token.Literal }
 func (s *SymbolLiteral) String() string       { return s.Token.Literal }
 
 type RegexpLiteral struct {
+	Located
 	Token        lexer.Token
 	Pattern      string
 	Options      string
@@ -142,6 +229,7 @@ func (r *RegexpLiteral) TokenLiteral() string { return r.Token.Literal }
 func (r *RegexpLiteral) String() string       { return r.Token.Literal }
 
 type ArrayLiteral struct {
+	Located
 	Token    lexer.Token
 	Elements []Expression
 }
@@ -161,6 +249,7 @@ func (a *ArrayLiteral) String() string {
 }
 
 type HashLiteral struct {
+	Located
 	Token lexer.Token
 	Pairs map[Expression]Expression
 	Order []Expression
@@ -181,6 +270,7 @@ func (h *HashLiteral) String() string {
 }
 
 type IndexExpression struct {
+	Located
 	Token lexer.Token
 	Left  Expression
 	Index Expression
@@ -197,6 +287,7 @@ func (i *IndexExpression) String() string {
 }
 
 type PrefixExpression struct {
+	Located
 	Token    lexer.Token
 	Operator string
 	Right    Expression
@@ -209,6 +300,7 @@ func (p *PrefixExpression) String() string {
 }
 
 type InfixExpression struct {
+	Located
 	Token    lexer.Token
 	Left     Expression
 	Operator string
@@ -222,6 +314,7 @@ func (i *InfixExpression) String() string {
 }
 
 type TernaryExpression struct {
+	Located
 	Token       lexer.Token
 	Condition   Expression
 	Consequent  Expression
@@ -235,6 +328,7 @@ func (t *TernaryExpression) String() string {
 }
 
 type RangeExpression struct {
+	Located
 	Token        lexer.Token
 	Left         Expression
 	Right        Expression
@@ -254,6 +348,7 @@ func (r *RangeExpression) String() string {
 }
 
 type BlockExpression struct {
+	Located
 	Token             lexer.Token
 	ExplicitParams    bool
 	Params            []*Identifier
@@ -274,6 +369,7 @@ type BlockExpression struct {
 }
 
 type ParameterPattern struct {
+	Located
 	Token     lexer.Token
 	Name      *Identifier
 	Children  []*ParameterPattern
@@ -293,6 +389,7 @@ func (b *BlockExpression) String() string {
 }
 
 type IfExpression struct {
+	Located
 	Token       lexer.Token
 	Condition   Expression
 	Consequent  *BlockExpression
@@ -303,6 +400,7 @@ type IfExpression struct {
 }
 
 type ElsIfExpression struct {
+	Located
 	Token      lexer.Token
 	Condition  Expression
 	Consequent *BlockExpression
@@ -325,6 +423,7 @@ func (i *IfExpression) String() string {
 }
 
 type CaseExpression struct {
+	Located
 	Token      lexer.Token
 	Expression Expression
 	Clauses    []*CaseClause
@@ -332,6 +431,7 @@ type CaseExpression struct {
 }
 
 type PatternMatchExpression struct {
+	Located
 	Token       lexer.Token
 	Left        Expression
 	Pattern     string
@@ -349,6 +449,7 @@ func (p *PatternMatchExpression) String() string {
 }
 
 type CaseClause struct {
+	Located
 	Token      lexer.Token
 	Conditions []Expression
 	Body       *BlockExpression
@@ -376,6 +477,7 @@ func (c *CaseExpression) String() string {
 }
 
 type WhileExpression struct {
+	Located
 	Token     lexer.Token
 	Condition Expression
 	Body      *BlockExpression
@@ -389,6 +491,7 @@ func (w *WhileExpression) String() string {
 }
 
 type UntilExpression struct {
+	Located
 	Token     lexer.Token
 	Condition Expression
 	Body      *BlockExpression
@@ -402,6 +505,7 @@ func (u *UntilExpression) String() string {
 }
 
 type ForExpression struct {
+	Located
 	Token      lexer.Token
 	Variable   []Expression
 	Collection Expression
@@ -434,12 +538,14 @@ func (f *ForExpression) String() string {
 
 // KeywordParam represents a keyword parameter in a method definition (e.g., a:, b: 1)
 type KeywordParam struct {
+	Located
 	Name    string
 	Default Expression // nil means required keyword arg (a:), non-nil means optional (b: 1)
 }
 
 // KeywordArg represents a keyword argument at a call site (e.g., a: 1)
 type KeywordArg struct {
+	Located
 	Token lexer.Token
 	Name  string
 	Value Expression
@@ -450,6 +556,7 @@ func (k *KeywordArg) TokenLiteral() string { return k.Token.Literal }
 func (k *KeywordArg) String() string       { return k.Name + ": " + k.Value.String() }
 
 type DefExpression struct {
+	Located
 	Token            lexer.Token
 	Name             *Identifier
 	Params           []*Identifier
@@ -511,6 +618,7 @@ func (d *DefExpression) String() string {
 }
 
 type ClassExpression struct {
+	Located
 	Token             lexer.Token
 	Name              *Identifier
 	Absolute          bool
@@ -533,6 +641,7 @@ func (c *ClassExpression) String() string {
 }
 
 type ModuleExpression struct {
+	Located
 	Token    lexer.Token
 	Name     *Identifier
 	Absolute bool
@@ -549,6 +658,7 @@ func (m *ModuleExpression) String() string {
 }
 
 type ReturnExpression struct {
+	Located
 	Token       lexer.Token
 	ReturnValue Expression
 }
@@ -564,6 +674,7 @@ func (r *ReturnExpression) String() string {
 }
 
 type BreakExpression struct {
+	Located
 	Token lexer.Token
 	Value Expression
 }
@@ -579,6 +690,7 @@ func (b *BreakExpression) String() string {
 }
 
 type NextExpression struct {
+	Located
 	Token lexer.Token
 	Value Expression
 }
@@ -594,6 +706,7 @@ func (n *NextExpression) String() string {
 }
 
 type RedoExpression struct {
+	Located
 	Token lexer.Token
 }
 
@@ -603,6 +716,7 @@ func (r *RedoExpression) TokenLiteral() string { return r.Token.Literal }
 func (r *RedoExpression) String() string       { return "redo" }
 
 type RetryExpression struct {
+	Located
 	Token lexer.Token
 }
 
@@ -612,6 +726,7 @@ func (r *RetryExpression) TokenLiteral() string { return r.Token.Literal }
 func (r *RetryExpression) String() string       { return "retry" }
 
 type YieldExpression struct {
+	Located
 	Token       lexer.Token
 	Args        []Expression
 	KeywordArgs []*KeywordArg
@@ -644,6 +759,7 @@ func (y *YieldExpression) String() string {
 }
 
 type SuperExpression struct {
+	Located
 	Token        lexer.Token
 	Args         []Expression
 	KeywordArgs  []*KeywordArg
@@ -681,6 +797,7 @@ func (s *SuperExpression) String() string {
 }
 
 type SelfExpression struct {
+	Located
 	Token lexer.Token
 }
 
@@ -689,6 +806,7 @@ func (s *SelfExpression) TokenLiteral() string { return s.Token.Literal }
 func (s *SelfExpression) String() string       { return "self" }
 
 type NilExpression struct {
+	Located
 	Token lexer.Token
 }
 
@@ -697,6 +815,7 @@ func (n *NilExpression) TokenLiteral() string { return n.Token.Literal }
 func (n *NilExpression) String() string       { return "nil" }
 
 type SplatExpression struct {
+	Located
 	Token              lexer.Token
 	Value              Expression
 	AnonymousBlockPass bool
@@ -707,6 +826,7 @@ func (s *SplatExpression) TokenLiteral() string { return s.Token.Literal }
 func (s *SplatExpression) String() string       { return "*" + s.Value.String() }
 
 type InstanceVariable struct {
+	Located
 	Token lexer.Token
 	Name  string
 }
@@ -716,6 +836,7 @@ func (i *InstanceVariable) TokenLiteral() string { return i.Token.Literal }
 func (i *InstanceVariable) String() string       { return i.Name }
 
 type ClassVariable struct {
+	Located
 	Token lexer.Token
 	Name  string
 }
@@ -725,6 +846,7 @@ func (c *ClassVariable) TokenLiteral() string { return c.Token.Literal }
 func (c *ClassVariable) String() string       { return c.Name }
 
 type GlobalVariable struct {
+	Located
 	Token lexer.Token
 	Name  string
 }
@@ -734,6 +856,7 @@ func (g *GlobalVariable) TokenLiteral() string { return g.Token.Literal }
 func (g *GlobalVariable) String() string       { return g.Name }
 
 type Constant struct {
+	Located
 	Token lexer.Token
 	Name  string
 }
@@ -743,6 +866,7 @@ func (c *Constant) TokenLiteral() string { return c.Token.Literal }
 func (c *Constant) String() string       { return c.Name }
 
 type ConstantResolution struct {
+	Located
 	Token lexer.Token
 	Left  Expression
 	Name  *Identifier
@@ -758,6 +882,7 @@ func (c *ConstantResolution) String() string {
 }
 
 type AssignExpression struct {
+	Located
 	Token  lexer.Token
 	Name   *Identifier
 	Target Expression
@@ -783,6 +908,7 @@ func (a *AssignExpression) String() string {
 }
 
 type MultiAssignExpression struct {
+	Located
 	Token   lexer.Token
 	Names   []*Identifier
 	Targets []Expression
@@ -809,6 +935,7 @@ func (a *MultiAssignExpression) String() string {
 }
 
 type InstanceVarAssign struct {
+	Located
 	Token lexer.Token
 	Name  string
 	Value Expression
@@ -819,6 +946,7 @@ func (i *InstanceVarAssign) TokenLiteral() string { return i.Token.Literal }
 func (i *InstanceVarAssign) String() string       { return i.Name + " = " + i.Value.String() }
 
 type ClassVarAssign struct {
+	Located
 	Token lexer.Token
 	Name  string
 	Value Expression
@@ -829,6 +957,7 @@ func (c *ClassVarAssign) TokenLiteral() string { return c.Token.Literal }
 func (c *ClassVarAssign) String() string       { return c.Name + " = " + c.Value.String() }
 
 type GlobalVarAssign struct {
+	Located
 	Token lexer.Token
 	Name  string
 	Value Expression
@@ -839,6 +968,7 @@ func (g *GlobalVarAssign) TokenLiteral() string { return g.Token.Literal }
 func (g *GlobalVarAssign) String() string       { return g.Name + " = " + g.Value.String() }
 
 type MethodCall struct {
+	Located
 	Token             lexer.Token
 	Receiver          Expression
 	Method            *Identifier
@@ -878,6 +1008,7 @@ func (m *MethodCall) String() string {
 }
 
 type UndefExpression struct {
+	Located
 	Token   lexer.Token
 	Methods []*Identifier
 }
@@ -896,6 +1027,7 @@ func (u *UndefExpression) String() string {
 }
 
 type AliasExpression struct {
+	Located
 	Token lexer.Token
 	Old   Expression
 	New   Expression
@@ -906,6 +1038,7 @@ func (a *AliasExpression) TokenLiteral() string { return a.Token.Literal }
 func (a *AliasExpression) String() string       { return "alias " + a.New.String() + " " + a.Old.String() }
 
 type BeginExpression struct {
+	Located
 	Token  lexer.Token
 	Body   *BlockExpression
 	Rescue []*RescueClause
@@ -914,6 +1047,7 @@ type BeginExpression struct {
 }
 
 type RescueClause struct {
+	Located
 	Token      lexer.Token
 	Exceptions []Expression
 	Variable   *Identifier
@@ -941,6 +1075,7 @@ func (b *BeginExpression) String() string {
 }
 
 type RaiseExpression struct {
+	Located
 	Token            lexer.Token
 	Error            Expression
 	Message          Expression
@@ -970,6 +1105,7 @@ func (r *RaiseExpression) String() string {
 }
 
 type CatchExpression struct {
+	Located
 	Token     lexer.Token
 	Label     Expression
 	Body      *BlockExpression
@@ -989,6 +1125,7 @@ func (c *CatchExpression) String() string {
 }
 
 type ThrowExpression struct {
+	Located
 	Token     lexer.Token
 	Label     Expression
 	Value     Expression
@@ -1010,6 +1147,7 @@ func (t *ThrowExpression) String() string {
 }
 
 type ExpressionStatement struct {
+	Located
 	Token      lexer.Token
 	Expression Expression
 }
@@ -1019,6 +1157,7 @@ func (e *ExpressionStatement) TokenLiteral() string { return e.Token.Literal }
 func (e *ExpressionStatement) String() string       { return e.Expression.String() }
 
 type IncludeExpression struct {
+	Located
 	Token  lexer.Token
 	Module Expression
 }
@@ -1028,6 +1167,7 @@ func (i *IncludeExpression) TokenLiteral() string { return i.Token.Literal }
 func (i *IncludeExpression) String() string       { return "include " + i.Module.String() }
 
 type ExtendExpression struct {
+	Located
 	Token  lexer.Token
 	Module Expression
 }
@@ -1037,6 +1177,7 @@ func (e *ExtendExpression) TokenLiteral() string { return e.Token.Literal }
 func (e *ExtendExpression) String() string       { return "extend " + e.Module.String() }
 
 type PrependExpression struct {
+	Located
 	Token  lexer.Token
 	Module Expression
 }
@@ -1046,6 +1187,7 @@ func (p *PrependExpression) TokenLiteral() string { return p.Token.Literal }
 func (p *PrependExpression) String() string       { return "prepend " + p.Module.String() }
 
 type DefinedExpression struct {
+	Located
 	Token      lexer.Token
 	Expression Expression
 }
@@ -1055,6 +1197,7 @@ func (d *DefinedExpression) TokenLiteral() string { return d.Token.Literal }
 func (d *DefinedExpression) String() string       { return "defined?(" + d.Expression.String() + ")" }
 
 type ProcLiteral struct {
+	Located
 	Token            lexer.Token
 	ExplicitParams   bool
 	Params           []*Identifier
@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+)
+
+// Diagnostic is a parse error with the source range it refers to. Message
+// is worded the way MRI reports the problem; Code is the offending source
+// line, used to draw the snippet under the message.
+type Diagnostic struct {
+	File      string
+	Line      int
+	Column    int // 1-based; 0 when the position is unknown
+	EndColumn int // exclusive
+	Message   string
+	Code      string
+}
+
+// Report renders d the way MRI prints a syntax error: "file:line: message"
+// followed by the offending line and a caret under the reported columns.
+// firstLine is the line number the parsed source starts at.
+func (d Diagnostic) Report(firstLine int) string {
+	file := d.File
+	if file == "" {
+		file = "-"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%d: %s", file, firstLine+d.Line-1, d.Message)
+	if strings.TrimSpace(d.Code) == "" || d.Column <= 0 {
+		return b.String()
+	}
+	b.WriteString("\n")
+	b.WriteString(d.Code)
+	b.WriteString("\n")
+	column := 1
+	for _, r := range d.Code {
+		if column >= d.Column {
+			break
+		}
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+		column++
+	}
+	for ; column < d.Column; column++ {
+		b.WriteRune(' ')
+	}
+	b.WriteString("^")
+	if width := d.EndColumn - d.Column; width > 1 {
+		b.WriteString(strings.Repeat("~", width-1))
+	}
+	return b.String()
+}
+
+// Diagnostics returns the parse errors found so far, in the order they were
+// found: at most one per statement, and no more than SetMaxErrors allows.
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+// UnexpectedEOF reports whether the first parse error was found at the end
+// of input: a block, bracket or expression was still open, so more input
+// could complete the program. Interactive consoles use it to ask for a
+// continuation line.
+func (p *Parser) UnexpectedEOF() bool {
+	return p.unexpectedEOF
+}
+
+// SetFile names the source being parsed in the diagnostics it reports.
+func (p *Parser) SetFile(file string) {
+	p.file = file
+}
+
+func (p *Parser) newDiagnostic(tok lexer.Token, raw string) Diagnostic {
+	lines := strings.Split(p.l.Input(), "\n")
+	d := Diagnostic{
+		File:      p.file,
+		Line:      tok.Line,
+		Column:    tok.Column,
+		EndColumn: tok.Column + utf8.RuneCountInString(tok.Literal),
+		Message:   rubySyntaxMessage(raw, tok),
+	}
+	if tok.Type == lexer.EOF || d.Line > len(lines) {
+		// MRI points end-of-input errors just past the last line of code.
+		d.Line = len(lines)
+		for d.Line > 1 && strings.TrimSpace(lines[d.Line-1]) == "" {
+			d.Line--
+		}
+		d.Column = utf8.RuneCountInString(strings.TrimRight(lines[d.Line-1], "\r")) + 1
+		d.EndColumn = d.Column + 1
+	}
+	if d.Line >= 1 && d.Line <= len(lines) {
+		d.Code = strings.TrimRight(lines[d.Line-1], "\r")
+	}
+	if d.EndColumn <= d.Column {
+		d.EndColumn = d.Column + 1
+	}
+	return d
+}
+
+// rubySyntaxMessage rewords the parser's "expected X, got Y" errors in the
+// "syntax error, unexpected Y, expecting X" form MRI uses.
+func rubySyntaxMessage(raw string, tok lexer.Token) string {
+	if rest, ok := strings.CutPrefix(raw, "expected next token to be "); ok {
+		expected, _, _ := strings.Cut(rest, ", got ")
+		return "syntax error, unexpected " + describeToken(tok) + ", expecting " + quoteTokenType(expected)
+	}
+	if rest, ok := strings.CutPrefix(raw, "expected then, newline, ;, or { after if condition, got "); ok && rest != "" {
+		return "syntax error, unexpected " + describeToken(tok) + ", expecting `then' or ';' or '\\n'"
+	}
+	for _, keyword := range []string{"end", "in"} {
+		if strings.HasPrefix(raw, "expected "+keyword+", got ") {
+			return "syntax error, unexpected " + describeToken(tok) + ", expecting `" + keyword + "'"
+		}
+	}
+	if strings.HasPrefix(raw, "no prefix parse function for ") {
+		if tok.Type == lexer.ILLEGAL && utf8.RuneCountInString(tok.Literal) > 1 {
+			// The lexer reports its own errors as the literal of an ILLEGAL token.
+			return tok.Literal
+		}
+		return "syntax error, unexpected " + describeToken(tok)
+	}
+	return raw
+}
+
+func describeToken(tok lexer.Token) string {
+	switch tok.Type {
+	case lexer.EOF:
+		return "end-of-input"
+	case lexer.NEWLINE:
+		return "'\\n'"
+	case lexer.IDENT:
+		return "local variable or method"
+	case lexer.CONSTANT:
+		return "constant"
+	case lexer.INT:
+		return "integer literal"
+	case lexer.FLOAT:
+		return "float literal"
+	case lexer.STRING:
+		return "string literal"
+	}
+	if tok.Literal == "" {
+		return quoteTokenType(string(tok.Type))
+	}
+	return quoteTokenType(tok.Literal)
+}
+
+// quoteTokenType quotes keywords as `end' and punctuation as ')', like MRI.
+func quoteTokenType(name string) string {
+	if kind := lexer.LookupIdent(name); kind != lexer.IDENT && kind != lexer.CONSTANT {
+		return "`" + name + "'"
+	}
+	return "'" + name + "'"
+}
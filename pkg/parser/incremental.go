@@ -0,0 +1,450 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// Edit replaces the bytes of a source from Start up to but not including
+// End with Text.
+type Edit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// Tree is a parsed source that is kept up to date as the source is edited.
+// Reparse parses again only the statements an edit touches, down to a single
+// method of a class or module, and reuses every other node of Program: they
+// keep their identity, with their positions moved past the edit.
+//
+// A Tree owns its Program; the nodes are updated in place.
+type Tree struct {
+	File        string
+	Source      string
+	Program     *ast.Program
+	Diagnostics []Diagnostic
+
+	// Every position and token in Program, sorted by offset, so the
+	// ones an edit replaces or moves can be found without walking the
+	// tree or looking at those before the edit.
+	positions []*ast.Position
+	tokens    []*lexer.Token
+}
+
+// ParseTree parses source, naming file in diagnostics.
+func ParseTree(file, source string) *Tree {
+	t := &Tree{File: file}
+	t.parseAll(source)
+	return t
+}
+
+func (t *Tree) parseAll(source string) {
+	p := New(lexer.New(source))
+	p.SetFile(t.File)
+	t.Source, t.Program = source, p.ParseProgram()
+	t.Diagnostics = p.Diagnostics()
+	t.positions, t.tokens = nil, nil
+	t.index(t.Program.Statements)
+	t.sortIndex()
+}
+
+// Reparse applies edit to the source and brings Program up to date. It
+// reports whether the update was incremental: when the source had or now
+// has syntax errors, or the edit is not confined to whole statements of a
+// class, module or the top level, the whole source is parsed again.
+func (t *Tree) Reparse(edit Edit) (bool, error) {
+	if edit.Start < 0 || edit.Start > edit.End || edit.End > len(t.Source) {
+		return false, fmt.Errorf("edit %d-%d is outside the source (%d bytes)", edit.Start, edit.End, len(t.Source))
+	}
+	source := t.Source[:edit.Start] + edit.Text + t.Source[edit.End:]
+	if len(t.Diagnostics) > 0 || !t.reparse(edit, source) {
+		t.parseAll(source)
+		return false, nil
+	}
+	return true, nil
+}
+
+// statementList is a run of statements the incremental parser can replace
+// part of: the top level, or the body of a class or module. Its statements
+// own the source from start to end, one or more whole lines each.
+type statementList struct {
+	statements *[]ast.Statement
+	body       *ast.BlockExpression // nil at the top level
+	start, end int
+}
+
+func (t *Tree) reparse(edit Edit, source string) bool {
+	list := statementList{statements: &t.Program.Statements, start: 0, end: len(t.Source)}
+	var bodies []*ast.BlockExpression
+	for {
+		first, last, ok := t.affected(list, edit)
+		if !ok {
+			return false
+		}
+		if first == last {
+			if inner, ok := t.classBody((*list.statements)[first]); ok && inner.start <= edit.Start && edit.End <= inner.end {
+				list = inner
+				bodies = append(bodies, inner.body)
+				continue
+			}
+		}
+		return t.replace(list, first, last, edit, source, bodies)
+	}
+}
+
+// affected returns the statements of list whose lines the edit touches,
+// widened so that no other statement shares a line with them.
+func (t *Tree) affected(list statementList, edit Edit) (int, int, bool) {
+	statements := *list.statements
+	if len(statements) == 0 || edit.Start < list.start || edit.End > list.end {
+		return 0, 0, false
+	}
+	first, last := 0, 0
+	for i := range statements {
+		start := t.slotStart(list, i)
+		if start <= edit.Start {
+			first = i
+		}
+		if start < edit.End || start <= edit.Start {
+			last = i
+		}
+	}
+	for first > 0 && t.slotStart(list, first) < statements[first-1].SourceRange().End.Offset {
+		first--
+	}
+	for last+1 < len(statements) && t.slotStart(list, last+1) < statements[last].SourceRange().End.Offset {
+		last++
+	}
+	return first, last, true
+}
+
+// slotStart is where the source owned by statement i of list begins: the
+// start of its first line, or of the list for the first statement.
+func (t *Tree) slotStart(list statementList, i int) int {
+	if i == 0 {
+		return list.start
+	}
+	return lineStart(t.Source, (*list.statements)[i].SourceRange().Start.Offset)
+}
+
+// classBody returns the body of the class or module stmt defines, if it
+// starts on a line after the header and ends on a line before the end
+// keyword, and has no rescue, else or ensure clauses.
+func (t *Tree) classBody(stmt ast.Statement) (statementList, bool) {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return statementList{}, false
+	}
+	var body *ast.BlockExpression
+	var parts []ast.Node
+	switch n := es.Expression.(type) {
+	case *ast.ClassExpression:
+		body, parts = n.Body, []ast.Node{n.Name, n.SuperClass, n.SingletonReceiver}
+	case *ast.ModuleExpression:
+		body, parts = n.Body, []ast.Node{n.Name}
+	default:
+		return statementList{}, false
+	}
+	loc := es.Expression.SourceRange()
+	if body == nil || len(body.Statements) == 0 || !loc.IsValid() {
+		return statementList{}, false
+	}
+	headerEnd := loc.Start.Offset
+	for _, part := range parts {
+		if part != nil && !reflect.ValueOf(part).IsNil() && part.SourceRange().End.Offset > headerEnd {
+			headerEnd = part.SourceRange().End.Offset
+		}
+	}
+	if len(body.Statements) == 1 {
+		if inner, ok := body.Statements[0].(*ast.ExpressionStatement); ok {
+			if begin, ok := inner.Expression.(*ast.BeginExpression); ok && begin.Token.Type != lexer.BEGIN {
+				return statementList{}, false
+			}
+		}
+	}
+	source := t.Source
+	start := strings.IndexByte(source[headerEnd:], '\n')
+	if start < 0 || strings.TrimSpace(stripComment(source[headerEnd:headerEnd+start])) != "" {
+		return statementList{}, false
+	}
+	start += headerEnd + 1
+	endKeyword := loc.End.Offset - len("end")
+	if endKeyword < start || source[endKeyword:loc.End.Offset] != "end" {
+		return statementList{}, false
+	}
+	end := lineStart(source, endKeyword)
+	if strings.TrimSpace(source[end:endKeyword]) != "" || body.Statements[0].SourceRange().Start.Offset < start || body.Statements[len(body.Statements)-1].SourceRange().End.Offset > end {
+		return statementList{}, false
+	}
+	return statementList{statements: &body.Statements, body: body, start: start, end: end}, true
+}
+
+// replace parses the source owned by statements first to last of list
+// again, after edit, and splices the result into the tree.
+func (t *Tree) replace(list statementList, first, last int, edit Edit, source string, bodies []*ast.BlockExpression) bool {
+	statements := *list.statements
+	regionStart := t.slotStart(list, first)
+	regionEnd := list.end
+	if last+1 < len(statements) {
+		regionEnd = t.slotStart(list, last+1)
+	}
+	delta := len(edit.Text) - (edit.End - edit.Start)
+	newEnd := regionEnd + delta
+	snippet := source[regionStart:newEnd]
+	if strings.HasPrefix(snippet, "__END__") || strings.Contains(snippet, "\n__END__") {
+		return false
+	}
+
+	p := New(lexer.New(snippet))
+	p.SetMaxErrors(1)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 || list.body != nil && len(program.Statements) == 0 && first == 0 && last == len(statements)-1 {
+		// Errors are reported against the whole file; an emptied body
+		// takes its token from its end keyword.
+		return false
+	}
+
+	// The snippet starts at a line start, so only lines and offsets move.
+	line := strings.Count(t.Source[:regionStart], "\n")
+	var added Tree
+	added.index(program.Statements)
+	added.shift(0, regionStart, line)
+	for _, comment := range program.Comments {
+		shiftPosition(&comment.Loc.Start, regionStart, line)
+		shiftPosition(&comment.Loc.End, regionStart, line)
+	}
+
+	// The bodies edited into keep their own token and range in step with
+	// their statements, as the parser would have made them. Those of their
+	// positions in the region are dropped from the index with the old
+	// statements, so they are indexed again once recomputed.
+	inRegion := func(offset int) bool { return offset >= regionStart && offset < regionEnd }
+	type bodyFields struct{ start, end, token bool }
+	dropped := make([]bodyFields, len(bodies))
+	for i, body := range bodies {
+		dropped[i] = bodyFields{inRegion(body.Loc.Start.Offset), inRegion(body.Loc.End.Offset), inRegion(body.Token.Offset)}
+	}
+
+	// Only what follows the region moves; the region's own entries are
+	// spliced out below, once the bodies have been recomputed.
+	lines := strings.Count(edit.Text, "\n") - strings.Count(t.Source[edit.Start:edit.End], "\n")
+	firstPosition, lastPosition := positionsIn(t.positions, regionStart, regionEnd)
+	firstToken, lastToken := tokensIn(t.tokens, regionStart, regionEnd)
+	if delta != 0 || lines != 0 {
+		for _, pos := range t.positions[lastPosition:] {
+			shiftPosition(pos, delta, lines)
+		}
+		for _, tok := range t.tokens[lastToken:] {
+			shiftToken(tok, delta, lines)
+		}
+	}
+	t.Program.Comments = spliceComments(t.Program.Comments, program.Comments, regionStart, regionEnd, delta, lines)
+
+	if len(program.Statements) == last-first+1 {
+		copy(statements[first:], program.Statements)
+	} else {
+		spliced := make([]ast.Statement, 0, len(statements)-(last-first+1)+len(program.Statements))
+		spliced = append(spliced, statements[:first]...)
+		spliced = append(spliced, program.Statements...)
+		spliced = append(spliced, statements[last+1:]...)
+		*list.statements = spliced
+	}
+	t.Source = source
+
+	for i, body := range bodies {
+		if dropped[i].token {
+			if es, ok := body.Statements[0].(*ast.ExpressionStatement); ok {
+				body.Token = es.Token
+			}
+			added.tokens = append(added.tokens, &body.Token)
+		}
+		body.Loc = statementsRange(body.Statements)
+		if dropped[i].start {
+			added.positions = append(added.positions, &body.Loc.Start)
+		}
+		if dropped[i].end {
+			added.positions = append(added.positions, &body.Loc.End)
+		}
+	}
+	t.Program.Loc = statementsRange(t.Program.Statements)
+	added.sortIndex()
+	t.positions = splicePositions(t.positions, firstPosition, lastPosition, added.positions)
+	t.tokens = spliceTokens(t.tokens, firstToken, lastToken, added.tokens)
+	return true
+}
+
+// statementsRange is the range covering statements. They follow each other
+// in the source, so it runs from the first with a range to the last.
+func statementsRange(statements []ast.Statement) ast.Range {
+	for i, stmt := range statements {
+		r := stmt.SourceRange()
+		if !r.IsValid() {
+			continue
+		}
+		for j := len(statements) - 1; j > i; j-- {
+			if last := statements[j].SourceRange(); last.IsValid() {
+				return r.Cover(last)
+			}
+		}
+		return r
+	}
+	return ast.Range{}
+}
+
+// index records every position and token under statements.
+func (t *Tree) index(statements []ast.Statement) {
+	seen := make(map[uintptr]bool)
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Ptr:
+			if v.IsNil() || seen[v.Pointer()] {
+				return
+			}
+			seen[v.Pointer()] = true
+			walk(v.Elem())
+		case reflect.Struct:
+			switch v.Type() {
+			case tokenType:
+				if tok := v.Addr().Interface().(*lexer.Token); tok.EndOffset > tok.Offset {
+					t.tokens = append(t.tokens, tok)
+				}
+				return
+			case positionType:
+				if pos := v.Addr().Interface().(*ast.Position); pos.Line > 0 {
+					t.positions = append(t.positions, pos)
+				}
+				return
+			}
+			for _, i := range walkedFields(v.Type()) {
+				walk(v.Field(i))
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Map:
+			iter := v.MapRange()
+			for iter.Next() {
+				walk(iter.Key())
+				walk(iter.Value())
+			}
+		}
+	}
+	for _, stmt := range statements {
+		walk(reflect.ValueOf(stmt))
+	}
+}
+
+var positionType = reflect.TypeOf(ast.Position{})
+
+// shift moves every indexed position and token at or after offset from by
+// delta bytes and lines lines.
+func (t *Tree) shift(from, delta, lines int) {
+	for _, pos := range t.positions {
+		if pos.Offset >= from {
+			shiftPosition(pos, delta, lines)
+		}
+	}
+	for _, tok := range t.tokens {
+		if tok.Offset >= from {
+			shiftToken(tok, delta, lines)
+		}
+	}
+}
+
+func shiftPosition(pos *ast.Position, delta, lines int) {
+	pos.Offset += delta
+	pos.Line += lines
+}
+
+func shiftToken(tok *lexer.Token, delta, lines int) {
+	tok.Offset += delta
+	tok.EndOffset += delta
+	tok.Line += lines
+}
+
+func (t *Tree) sortIndex() {
+	sort.Slice(t.positions, func(i, j int) bool { return t.positions[i].Offset < t.positions[j].Offset })
+	sort.Slice(t.tokens, func(i, j int) bool { return t.tokens[i].Offset < t.tokens[j].Offset })
+}
+
+// positionsIn returns the range of positions, sorted by offset, that lie in
+// the region [start, end).
+func positionsIn(positions []*ast.Position, start, end int) (int, int) {
+	first := sort.Search(len(positions), func(i int) bool { return positions[i].Offset >= start })
+	last := first + sort.Search(len(positions)-first, func(i int) bool { return positions[first+i].Offset >= end })
+	return first, last
+}
+
+func tokensIn(tokens []*lexer.Token, start, end int) (int, int) {
+	first := sort.Search(len(tokens), func(i int) bool { return tokens[i].Offset >= start })
+	last := first + sort.Search(len(tokens)-first, func(i int) bool { return tokens[first+i].Offset >= end })
+	return first, last
+}
+
+// splicePositions replaces positions[first:last] with added. An edit that
+// keeps the shape of the code it touches reuses the slots in place; the
+// entries after the region are only copied when their number changes.
+func splicePositions(positions []*ast.Position, first, last int, added []*ast.Position) []*ast.Position {
+	if len(added) == last-first {
+		copy(positions[first:], added)
+		return positions
+	}
+	out := make([]*ast.Position, 0, len(positions)-(last-first)+len(added))
+	out = append(out, positions[:first]...)
+	out = append(out, added...)
+	return append(out, positions[last:]...)
+}
+
+func spliceTokens(tokens []*lexer.Token, first, last int, added []*lexer.Token) []*lexer.Token {
+	if len(added) == last-first {
+		copy(tokens[first:], added)
+		return tokens
+	}
+	out := make([]*lexer.Token, 0, len(tokens)-(last-first)+len(added))
+	out = append(out, tokens[:first]...)
+	out = append(out, added...)
+	return append(out, tokens[last:]...)
+}
+
+// spliceComments replaces the comments in [start, end) with added, which
+// are already in place, and moves the ones after.
+func spliceComments(comments, added []*ast.Comment, start, end, delta, lines int) []*ast.Comment {
+	first := sort.Search(len(comments), func(i int) bool { return comments[i].Loc.Start.Offset >= start })
+	last := first + sort.Search(len(comments)-first, func(i int) bool { return comments[first+i].Loc.Start.Offset >= end })
+	if delta != 0 || lines != 0 {
+		for _, c := range comments[last:] {
+			shiftPosition(&c.Loc.Start, delta, lines)
+			shiftPosition(&c.Loc.End, delta, lines)
+		}
+	}
+	out := make([]*ast.Comment, 0, len(comments)-(last-first)+len(added))
+	out = append(out, comments[:first]...)
+	out = append(out, added...)
+	return append(out, comments[last:]...)
+}
+
+// lineStart returns the offset of the start of the line holding offset.
+func lineStart(source string, offset int) int {
+	return strings.LastIndexByte(source[:offset], '\n') + 1
+}
+
+// stripComment drops a trailing # comment from a line of code with no
+// strings in it.
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+const incrementalSource = `# top
+require "set"
+
+X = 1
+
+module Outer
+  # inner
+  class Inner < Base
+    attr_reader :a
+
+    def first(a, b = 2)
+      a + b # sum
+    end
+
+    def second
+      text = <<~EOS
+        heredoc #{a}
+      EOS
+      text.upcase
+    end
+  end
+
+  VERSION = "1.0"
+end
+
+puts X; puts Outer::VERSION
+`
+
+// checkTree compares the tree with a fresh parse of its source.
+func checkTree(t *testing.T, tree *Tree) {
+	t.Helper()
+	p := New(lexer.New(tree.Source))
+	p.SetFile(tree.File)
+	want := p.ParseProgram()
+	if path := difference(reflect.ValueOf(tree.Program), reflect.ValueOf(want), "Program", map[uintptr]bool{}); path != "" {
+		t.Fatalf("incremental tree differs from a full parse at %s", path)
+	}
+	if !reflect.DeepEqual(tree.Diagnostics, p.Diagnostics()) {
+		t.Fatalf("diagnostics %v, want %v", tree.Diagnostics, p.Diagnostics())
+	}
+}
+
+// difference returns the path of the first field where got and want differ,
+// or "".
+func difference(got, want reflect.Value, path string, seen map[uintptr]bool) string {
+	if got.Kind() != want.Kind() || got.Kind() == reflect.Interface && got.IsNil() != want.IsNil() {
+		return path
+	}
+	switch got.Kind() {
+	case reflect.Interface:
+		if got.IsNil() {
+			return ""
+		}
+		if got.Elem().Type() != want.Elem().Type() {
+			return path + fmt.Sprintf(" (%s, want %s)", got.Elem().Type(), want.Elem().Type())
+		}
+		return difference(got.Elem(), want.Elem(), path, seen)
+	case reflect.Ptr:
+		if got.IsNil() || want.IsNil() {
+			if got.IsNil() != want.IsNil() {
+				return path
+			}
+			return ""
+		}
+		if seen[got.Pointer()] {
+			return ""
+		}
+		seen[got.Pointer()] = true
+		return difference(got.Elem(), want.Elem(), path, seen)
+	case reflect.Struct:
+		for i := 0; i < got.NumField(); i++ {
+			if !got.Type().Field(i).IsExported() {
+				continue
+			}
+			if d := difference(got.Field(i), want.Field(i), path+"."+got.Type().Field(i).Name, seen); d != "" {
+				return d
+			}
+		}
+	case reflect.Slice:
+		if got.Len() != want.Len() {
+			return fmt.Sprintf("%s (length %d, want %d)", path, got.Len(), want.Len())
+		}
+		for i := 0; i < got.Len(); i++ {
+			if d := difference(got.Index(i), want.Index(i), fmt.Sprintf("%s[%d]", path, i), seen); d != "" {
+				return d
+			}
+		}
+	case reflect.Map:
+		if got.Len() != want.Len() {
+			return path + " (map length)"
+		}
+	default:
+		if !reflect.DeepEqual(got.Interface(), want.Interface()) {
+			return fmt.Sprintf("%s (%v, want %v)", path, got.Interface(), want.Interface())
+		}
+	}
+	return ""
+}
+
+// replace edits the first occurrence of old in the tree's source.
+func replace(t *testing.T, tree *Tree, old, text string) bool {
+	t.Helper()
+	start := strings.Index(tree.Source, old)
+	if start < 0 {
+		t.Fatalf("%q not in source", old)
+	}
+	incremental, err := tree.Reparse(Edit{Start: start, End: start + len(old), Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, tree)
+	return incremental
+}
+
+func TestTreeReparseMatchesFullParse(t *testing.T) {
+	tests := []struct {
+		old, text   string
+		incremental bool
+	}{
+		{"a + b # sum", "a * b + 1 # product", true},
+		{"heredoc #{a}", "heredoc\n        more #{a}", true},
+		{"    attr_reader :a\n", "    attr_reader :a, :b\n    CONST = [1,\n      2]\n", true},
+		{"    def second", "    def added\n    end\n\n    def second", true},
+		{"  VERSION = \"1.0\"\n", "", true},
+		{"X = 1", "X = 2; Y = 3", true},
+		{"puts X; ", "", true},
+		{"class Inner < Base", "class Inner < Other", true},
+		{"      text.upcase\n", "      text.upcase(\n", false},
+		{"      text.upcase(\n", "      text.upcase\n", false},
+		{"    def added\n    end\n", "", true},
+		{"", "# leading\n", true},
+	}
+	tree := ParseTree("test.rb", incrementalSource)
+	checkTree(t, tree)
+	for _, tt := range tests {
+		if got := replace(t, tree, tt.old, tt.text); got != tt.incremental {
+			t.Fatalf("replacing %q: incremental = %v, want %v", tt.old, got, tt.incremental)
+		}
+	}
+}
+
+func TestTreeReparseReusesUnaffectedNodes(t *testing.T) {
+	tree := ParseTree("test.rb", incrementalSource)
+	methods := func() []ast.Statement {
+		module := tree.Program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.ModuleExpression)
+		class := module.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ClassExpression)
+		return class.Body.Statements
+	}
+	before := methods()
+	first, second := before[1], before[2]
+	if !replace(t, tree, "a + b", "a - b") {
+		t.Fatal("expected an incremental reparse")
+	}
+	after := methods()
+	if after[1] == first {
+		t.Fatal("the edited method was not parsed again")
+	}
+	if after[2] != second {
+		t.Fatal("the method after the edit was not reused")
+	}
+	if _, err := tree.Reparse(Edit{Start: 5, End: 1}); err == nil {
+		t.Fatal("expected an error for an inverted edit")
+	}
+}
+
+// largeSource is a class of methods about 5000 lines long.
+func largeSource() string {
+	var b strings.Builder
+	b.WriteString("class Large\n")
+	for i := 0; b.Len() == 0 || strings.Count(b.String(), "\n") < 5000; i++ {
+		fmt.Fprintf(&b, "  def method_%d(a, b = %d)\n    total = a + b\n    [1, 2, 3].each do |x|\n      total += x * %d\n    end\n    puts \"total: #{total}\" if total > 10\n    total\n  end\n\n", i, i, i)
+	}
+	b.WriteString("end\n")
+	return b.String()
+}
+
+func BenchmarkTreeReparseMethod(b *testing.B) {
+	source := largeSource()
+	tree := ParseTree("large.rb", source)
+	start := strings.Index(tree.Source, "total += x * 300")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		text := "total += x * 301"
+		if i%2 == 1 {
+			text = "total += x * 300"
+		}
+		incremental, err := tree.Reparse(Edit{Start: start, End: start + len(text), Text: text})
+		if err != nil || !incremental {
+			b.Fatalf("incremental = %v, err = %v", incremental, err)
+		}
+	}
+}
+
+func BenchmarkTreeFullParse(b *testing.B) {
+	source := largeSource()
+	for i := 0; i < b.N; i++ {
+		New(lexer.New(source)).ParseProgram()
+	}
+}
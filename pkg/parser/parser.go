@@ -103,6 +103,12 @@ type Parser struct {
 	infixFns  map[lexer.TokenType]infixParseFn
 
 	errors                     []string
+	diagnostics                []Diagnostic
+	maxErrors                  int
+	recovering                 bool // an error was reported and the statement it is in has not been skipped yet
+	unexpectedEOF              bool // the first error was reported at the end of input
+	operandAtEOF               bool // the last error is an operand missing at the end of input
+	file                       string
 	stopAtColon                bool
 	stopAtRParen               bool
 	stopAtRBracket             bool
@@ -131,9 +137,10 @@ func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:                       l,
 		errors:                  []string{},
+		maxErrors:               MaxErrors,
 		allowAnonymousBlockPass: false,
-		prefixFns:               make(map[lexer.TokenType]prefixParseFn),
-		infixFns:                make(map[lexer.TokenType]infixParseFn),
+		prefixFns:               make(map[lexer.TokenType]prefixParseFn, 80),
+		infixFns:                make(map[lexer.TokenType]infixParseFn, 64),
 	}
 
 	p.registerPrefix(lexer.IDENT, p.parseIdentifier)
@@ -278,11 +285,43 @@ func (p *Parser) AllowAnonymousBlockPass(allow bool) {
 }
 
 func (p *Parser) parseError(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	if p.curToken.Line > 0 || p.curToken.Column > 0 {
-		msg = fmt.Sprintf("line %d:%d: %s", p.curToken.Line, p.curToken.Column, msg)
+	p.parseErrorAt(p.curToken, format, args...)
+}
+
+// parseErrorAt records an error at tok. Only the first error in a statement
+// is kept: what follows it is usually a consequence of the parser having
+// lost its place. parseStatement resumes reporting once it has skipped the
+// statement.
+func (p *Parser) parseErrorAt(tok lexer.Token, format string, args ...interface{}) {
+	if p.operandAtEOF && tok.Type == lexer.EOF {
+		// A bracket or keyword left open around the missing operand tells
+		// what the input still needs, so its error replaces the bare
+		// "unexpected end-of-input".
+		p.operandAtEOF = false
+		p.errors = p.errors[:len(p.errors)-1]
+		p.diagnostics = p.diagnostics[:len(p.diagnostics)-1]
+		p.recovering = false
+	}
+	if p.recovering || p.errorLimitReached() {
+		return
+	}
+	raw := fmt.Sprintf(format, args...)
+	diagnostic := p.newDiagnostic(tok, raw)
+	if n := len(p.diagnostics); n > 0 && p.diagnostics[n-1].Line == diagnostic.Line && p.diagnostics[n-1].Column == diagnostic.Column {
+		// Constructs left open by a recovered statement all fail where it
+		// did, typically at the end of input. They keep what they parsed.
+		return
+	}
+	p.recovering = true
+	if len(p.diagnostics) == 0 {
+		p.unexpectedEOF = tok.Type == lexer.EOF
+	}
+	msg := raw
+	if tok.Line > 0 || tok.Column > 0 {
+		msg = fmt.Sprintf("line %d:%d: %s", tok.Line, tok.Column, raw)
 	}
 	p.errors = append(p.errors, msg)
+	p.diagnostics = append(p.diagnostics, diagnostic)
 }
 
 func (p *Parser) pushAllowAnonymousBlockPass(enabled bool) {
@@ -326,10 +365,15 @@ func (p *Parser) ParseProgram() *ast.Program {
 		if p.curTokenIs(lexer.EOF) {
 			break
 		}
+		start := p.curToken
 		stmt := p.parseStatement()
 		if stmt != nil {
+			p.markRange(stmt, start)
 			program.Statements = append(program.Statements, stmt)
 		}
+		if p.errorLimitReached() {
+			break
+		}
 		p.nextToken()
 		// Skip semicolons and newlines after statement
 		for p.curTokenIs(lexer.SEMICOLON) || p.curTokenIs(lexer.NEWLINE) {
@@ -337,10 +381,14 @@ func (p *Parser) ParseProgram() *ast.Program {
 		}
 	}
 
+	p.completeRanges(program)
+	p.collectComments(program)
 	return program
 }
 
-func (p *Parser) parseStatement() ast.Statement {
+// parseStatementNode parses the statement at curToken; parseStatement wraps
+// it with error recovery.
+func (p *Parser) parseStatementNode() ast.Statement {
 	switch p.curToken.Type {
 	case lexer.SEMICOLON, lexer.NEWLINE, lexer.RBRACE, lexer.RPAREN:
 		return nil
@@ -502,8 +550,9 @@ func (p *Parser) parseIfModifier(expr ast.Expression) ast.Expression {
 	p.skipCurNewlines()
 	modifier.Condition = p.parseExpression(LOWEST)
 	modifier.Consequent = &ast.BlockExpression{
+		Located:    located(expr),
 		Token:      p.curToken,
-		Statements: []ast.Statement{&ast.ExpressionStatement{Token: p.curToken, Expression: expr}},
+		Statements: []ast.Statement{&ast.ExpressionStatement{Located: located(expr), Token: p.curToken, Expression: expr}},
 	}
 
 	return modifier
@@ -524,8 +573,9 @@ func (p *Parser) parseUnlessModifier(expr ast.Expression) ast.Expression {
 		Right:    condition,
 	}
 	modifier.Consequent = &ast.BlockExpression{
+		Located:    located(expr),
 		Token:      p.curToken,
-		Statements: []ast.Statement{&ast.ExpressionStatement{Token: p.curToken, Expression: expr}},
+		Statements: []ast.Statement{&ast.ExpressionStatement{Located: located(expr), Token: p.curToken, Expression: expr}},
 	}
 
 	return modifier
@@ -541,8 +591,9 @@ func (p *Parser) parseWhileModifier(expr ast.Expression) ast.Expression {
 	p.skipCurNewlines()
 	modifier.Condition = p.parseExpression(LOWEST)
 	modifier.Body = &ast.BlockExpression{
+		Located:    located(expr),
 		Token:      p.curToken,
-		Statements: []ast.Statement{&ast.ExpressionStatement{Token: p.curToken, Expression: expr}},
+		Statements: []ast.Statement{&ast.ExpressionStatement{Located: located(expr), Token: p.curToken, Expression: expr}},
 	}
 
 	return modifier
@@ -558,8 +609,9 @@ func (p *Parser) parseUntilModifier(expr ast.Expression) ast.Expression {
 	p.skipCurNewlines()
 	modifier.Condition = p.parseExpression(LOWEST)
 	modifier.Body = &ast.BlockExpression{
+		Located:    located(expr),
 		Token:      p.curToken,
-		Statements: []ast.Statement{&ast.ExpressionStatement{Token: p.curToken, Expression: expr}},
+		Statements: []ast.Statement{&ast.ExpressionStatement{Located: located(expr), Token: p.curToken, Expression: expr}},
 	}
 
 	return modifier
@@ -622,6 +674,7 @@ func (p *Parser) tryParseMultiAssign(first ast.Expression) *ast.MultiAssignExpre
 	p.nextToken()
 	p.nextToken()
 	p.skipCurSeparators()
+	p.operandMissingAtEOF()
 
 	values := []ast.Expression{}
 	if !p.curTokenIs(lexer.NEWLINE) && !p.curTokenIs(lexer.EOF) {
@@ -1029,10 +1082,12 @@ func (p *Parser) parseBlockParams(block *ast.BlockExpression) {
 				if p.peekTokenIs(lexer.COLON) {
 					p.nextToken()
 					kp := &ast.KeywordParam{Name: name}
+					label := p.prevToken
 					if !p.peekTokenIs(lexer.COMMA) && !p.peekTokenIs(lexer.BIT_OR) && !p.peekTokenIs(lexer.SEMICOLON) {
 						p.nextToken()
 						kp.Default = p.parseParameterDefaultExpression(lexer.COMMA, lexer.BIT_OR, lexer.SEMICOLON)
 					}
+					p.markRange(kp, label)
 					block.KeywordParams = append(block.KeywordParams, kp)
 					p.nextToken()
 					continue
@@ -1291,7 +1346,9 @@ func (p *Parser) parseExpressionWithStopTokens(prec int, stopTokens ...lexer.Tok
 		return nil
 	}
 
+	start := p.curToken
 	leftExp := prefix()
+	p.markRange(leftExp, start)
 	leftExp = p.parseLineLeadingCallChain(leftExp)
 	for !p.curTokenIs(lexer.COMMA) && !p.curTokenIs(lexer.NEWLINE) && !p.curTokenIs(lexer.SEMICOLON) && !p.curTokenIs(lexer.IF) && !p.curTokenIs(lexer.UNLESS) && !p.curTokenIs(lexer.WHILE) && !p.curTokenIs(lexer.UNTIL) && (!p.curTokenIsAny(stopTokens...) || p.parenthesizedCallCanContinuePastStop(leftExp) || (p.currentTokenClosedChildGroup() && p.infixFns[p.peekToken.Type] != nil)) && !((p.curTokenIs(lexer.RBRACE) || p.curTokenIs(lexer.END)) && isControlFlowExpression(leftExp)) && (!p.curTokenIs(lexer.RBRACE) || p.peekTokenIs(lexer.DOT) || p.peekTokenIs(lexer.ARROW) || p.peekTokenIs(lexer.IN) || p.peekTokenIs(lexer.LBRACKET) || p.infixFns[p.peekToken.Type] != nil || isHashLiteral(leftExp) || isBlockMethodCall(leftExp) || expressionEndsWithBraceBlockCall(leftExp)) && (!p.curTokenIs(lexer.END) || p.peekTokenIs(lexer.DOT) || p.infixFns[p.peekToken.Type] != nil || isBlockMethodCall(leftExp) || isMethodCallNamed(leftExp, "end")) && !p.shouldStopGroupedRParenDotChain(leftExp) && !(p.stopAtRParen && p.curTokenIs(lexer.RPAREN) && (p.peekTokenIs(lexer.RESCUE) || expressionEndsWithBraceBlockCall(leftExp) || (isAssignmentExpression(leftExp) && !p.currentTokenClosedChildGroup()))) && !(p.stopAtRBracket && p.curTokenIs(lexer.RBRACKET) && !isBracketChainReceiver(leftExp)) && !(p.stopAtHashRocket && p.peekTokenIs(lexer.ARROW)) && !p.peekTokenIs(lexer.NEWLINE) && !(p.stopAtColon && p.peekTokenIs(lexer.COLON)) && !p.peekTokenIsAny(stopTokens...) && prec < p.peekPrecedence() {
 		infix := p.infixFns[p.peekToken.Type]
@@ -1302,13 +1359,28 @@ func (p *Parser) parseExpressionWithStopTokens(prec int, stopTokens ...lexer.Tok
 		p.allowGroupedRParenDotChain = false
 		p.lastClosedGroupDepth = 0
 		p.nextToken()
+		left := leftExp
 		leftExp = infix(leftExp)
+		p.markRangeFrom(leftExp, left)
 		leftExp = p.parseLineLeadingCallChain(leftExp)
 	}
 
 	return leftExp
 }
 
+// operandMissingAtEOF reports a syntax error when the input ends where an
+// operator, assignment or call still needs its operand. parseExpression is
+// silent at the end of input, since a bare return or break may end there.
+func (p *Parser) operandMissingAtEOF() bool {
+	if !p.curTokenIs(lexer.EOF) {
+		return false
+	}
+	reported := len(p.diagnostics)
+	p.parseError("no prefix parse function for %s found", p.curToken.Type)
+	p.operandAtEOF = len(p.diagnostics) > reported
+	return true
+}
+
 func (p *Parser) parseLineLeadingCallChain(left ast.Expression) ast.Expression {
 	for p.peekTokenIs(lexer.NEWLINE) {
 		next := p.tokenAfterPeek()
@@ -1317,7 +1389,9 @@ func (p *Parser) parseLineLeadingCallChain(left ast.Expression) ast.Expression {
 		}
 		p.nextToken()
 		p.nextToken()
+		receiver := left
 		left = p.parseMethodCall(left)
+		p.markRangeFrom(left, receiver)
 	}
 	return left
 }
@@ -1570,7 +1644,7 @@ func (p *Parser) expectPeek(t lexer.TokenType) bool {
 		p.nextToken()
 		return true
 	}
-	p.parseError("expected next token to be %s, got %s instead", t, p.peekToken.Type)
+	p.parseErrorAt(p.peekToken, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
 	return false
 }
 
@@ -1930,8 +2004,14 @@ func (p *Parser) parseWordsLiteral() ast.Expression {
 		Token:    p.curToken,
 		Elements: make([]ast.Expression, 0, len(words)),
 	}
-	for _, word := range words {
+	spans := p.percentWordRanges(p.curToken)
+	for i, word := range words {
+		var loc ast.Located
+		if len(spans) == len(words) {
+			loc.Loc = spans[i]
+		}
 		arr.Elements = append(arr.Elements, &ast.StringLiteral{
+			Located:      loc,
 			Token:        lexer.Token{Type: lexer.STRING, Literal: word, AllowsInterpolation: p.curToken.AllowsInterpolation},
 			Value:        word,
 			Interpolates: p.curToken.AllowsInterpolation,
@@ -1946,10 +2026,16 @@ func (p *Parser) parseSymbolsLiteral() ast.Expression {
 		Token:    p.curToken,
 		Elements: make([]ast.Expression, 0, len(words)),
 	}
-	for _, word := range words {
+	spans := p.percentWordRanges(p.curToken)
+	for i, word := range words {
+		var loc ast.Located
+		if len(spans) == len(words) {
+			loc.Loc = spans[i]
+		}
 		arr.Elements = append(arr.Elements, &ast.SymbolLiteral{
-			Token: lexer.Token{Type: lexer.SYMBOL, Literal: word},
-			Value: word,
+			Located: loc,
+			Token:   lexer.Token{Type: lexer.SYMBOL, Literal: word},
+			Value:   word,
 		})
 	}
 	return arr
@@ -2500,6 +2586,9 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	}
 
 	p.nextToken()
+	if p.operandMissingAtEOF() {
+		return expression
+	}
 
 	if expression.Operator == "not" {
 		expression.Right = p.parseExpression(LOWEST)
@@ -2619,6 +2708,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	for p.curTokenIs(lexer.NEWLINE) {
 		p.nextToken()
 	}
+	if p.operandMissingAtEOF() {
+		return expression
+	}
 	switch expression.Token.Type {
 	case lexer.EQUAL, lexer.EQUAL3, lexer.NOT_EQUAL, lexer.BANG_EQUAL, lexer.MATCH, lexer.SPACESHIP:
 		if p.peekTokenIs(expression.Token.Type) {
@@ -2653,6 +2745,9 @@ func (p *Parser) parseTernaryExpression(condition ast.Expression) ast.Expression
 
 	p.nextToken()
 	p.skipCurNewlines()
+	if p.operandMissingAtEOF() {
+		return exp
+	}
 	previousStopAtColon = p.stopAtColon
 	exp.Alternative = p.parseExpression(LOWEST)
 	p.stopAtColon = previousStopAtColon
@@ -3025,6 +3120,9 @@ func assignmentName(expr ast.Expression) *ast.Identifier {
 
 func (p *Parser) parseAssignmentValue() ast.Expression {
 	p.skipCurSeparators()
+	if p.operandMissingAtEOF() {
+		return nil
+	}
 	beforeErrs := len(p.errors)
 	first := p.parseExpressionWithStopTokens(LOWEST, lexer.AND2, lexer.OR2)
 	if p.assignmentValueHasVoidExpression(first) && len(p.errors) == beforeErrs {
@@ -3162,6 +3260,11 @@ func (p *Parser) parseMethodCall(left ast.Expression) ast.Expression {
 		Safe:     p.curTokenIs(lexer.SAFE_NAV),
 	}
 	p.skipPeekNewlines()
+	if p.peekTokenIs(lexer.EOF) {
+		p.nextToken()
+		p.operandMissingAtEOF()
+		return call
+	}
 
 	if p.peekTokenIs(lexer.LBRACKET) {
 		p.nextToken()
@@ -3688,6 +3791,21 @@ func (p *Parser) skipPeekNewlines() {
 	}
 }
 
+// parseHookBlock parses `BEGIN { ... }` and `END { ... }` as a call to the
+// hook with its block; the compiler hoists top-level BEGIN blocks.
+func (p *Parser) parseHookBlock() ast.Expression {
+	call := &ast.MethodCall{
+		Token:  p.curToken,
+		Method: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+	}
+	p.nextToken()
+	call.Block = p.parseBlockExpression()
+	if p.shouldConsumeBlockTerminator() {
+		p.consumeBlockTerminator()
+	}
+	return call
+}
+
 func (p *Parser) parseConstant() ast.Expression {
 	if strings.HasSuffix(p.curToken.Literal, "?") || strings.HasSuffix(p.curToken.Literal, "!") {
 		return &ast.MethodCall{
@@ -3695,6 +3813,9 @@ func (p *Parser) parseConstant() ast.Expression {
 			Method: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
 		}
 	}
+	if (p.curToken.Literal == "BEGIN" || p.curToken.Literal == "END") && p.peekTokenIs(lexer.LBRACE) {
+		return p.parseHookBlock()
+	}
 	constant := &ast.Constant{
 		Token: p.curToken,
 		Name:  p.curToken.Literal,
@@ -4500,7 +4621,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(lexer.THEN) {
 		p.nextToken() // consume "then"
 	} else if !p.curTokenIs(lexer.NEWLINE) && !p.curTokenIs(lexer.SEMICOLON) && !p.peekTokenIs(lexer.NEWLINE) && !p.peekTokenIs(lexer.SEMICOLON) && !p.peekTokenIs(lexer.LBRACE) {
-		p.parseError("expected then, newline, ;, or { after if condition, got %s", p.peekToken.Type)
+		p.parseErrorAt(p.peekToken, "expected then, newline, ;, or { after if condition, got %s", p.peekToken.Type)
 		return nil
 	}
 
@@ -4612,7 +4733,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		}
 	}
 
-	if !p.curTokenIs(lexer.END) && !p.curTokenIs(lexer.EOF) {
+	if !p.curTokenIs(lexer.END) {
 		p.parseError("expected end, got %s", p.curToken.Type)
 		return nil
 	}
@@ -4673,7 +4794,7 @@ func (p *Parser) parseUnlessExpression() ast.Expression {
 		}
 	}
 
-	if !p.curTokenIs(lexer.END) && !p.curTokenIs(lexer.EOF) {
+	if !p.curTokenIs(lexer.END) {
 		p.parseError("expected end, got %s", p.curToken.Type)
 		return nil
 	}
@@ -4862,7 +4983,7 @@ func (p *Parser) parseWhileExpression() ast.Expression {
 		}
 	}
 
-	if !p.curTokenIs(lexer.END) && !p.curTokenIs(lexer.EOF) {
+	if !p.curTokenIs(lexer.END) {
 		p.parseError("expected end, got %s", p.curToken.Type)
 		return nil
 	}
@@ -4905,7 +5026,7 @@ func (p *Parser) parseUntilExpression() ast.Expression {
 		}
 	}
 
-	if !p.curTokenIs(lexer.END) && !p.curTokenIs(lexer.EOF) {
+	if !p.curTokenIs(lexer.END) {
 		p.parseError("expected end, got %s", p.curToken.Type)
 		return nil
 	}
@@ -4974,7 +5095,7 @@ func (p *Parser) parseForExpression() ast.Expression {
 		}
 	}
 
-	if !p.curTokenIs(lexer.END) && !p.curTokenIs(lexer.EOF) {
+	if !p.curTokenIs(lexer.END) {
 		p.parseError("expected end, got %s", p.curToken.Type)
 		return nil
 	}
@@ -5152,7 +5273,9 @@ func (p *Parser) parseDefExpression() ast.Expression {
 
 	if !p.curTokenIs(lexer.END) {
 		p.parseError("expected end, got %s", p.curToken.Type)
-		return nil
+		// Keep what was parsed: the error may only be a consequence of
+		// a statement in the body that ran to the end of input.
+		return exp
 	}
 
 	return exp
@@ -5396,10 +5519,12 @@ func (p *Parser) parseOneDefParam(exp *ast.DefExpression) {
 	if p.peekTokenIs(lexer.COLON) {
 		p.nextToken() // consume COLON
 		kp := &ast.KeywordParam{Name: name}
+		label := p.prevToken
 		if !p.peekTokenIs(lexer.COMMA) && !p.peekTokenIs(lexer.RPAREN) && !p.peekTokenIs(lexer.NEWLINE) {
 			p.nextToken()
 			kp.Default = p.parseParameterDefaultExpression(lexer.COMMA, lexer.RPAREN, lexer.NEWLINE)
 		}
+		p.markRange(kp, label)
 		exp.KeywordParams = append(exp.KeywordParams, kp)
 	} else {
 		exp.Params = append(exp.Params, &ast.Identifier{
@@ -5529,7 +5654,9 @@ func (p *Parser) parseClassExpression() ast.Expression {
 
 	if !p.curTokenIs(lexer.END) {
 		p.parseError("expected end, got %s", p.curToken.Type)
-		return nil
+		// Keep what was parsed: the error may only be a consequence of
+		// a statement in the body that ran to the end of input.
+		return exp
 	}
 
 	return exp
@@ -5598,8 +5725,9 @@ func (p *Parser) parseModuleExpression() ast.Expression {
 		exp.Body = p.parseImplicitBeginClauses(body)
 	}
 
-	if !p.curTokenIs(lexer.END) && !p.expectPeek(lexer.END) {
-		return nil
+	if !p.curTokenIs(lexer.END) {
+		// A module missing its end keeps its body, as a class does.
+		p.expectPeek(lexer.END)
 	}
 
 	return exp
@@ -5656,13 +5784,17 @@ func (p *Parser) parseLambdaExpression() ast.Expression {
 		}
 		previousStopAtDo := p.stopAtDo
 		p.stopAtDo = false
+		opener := p.curToken
 		lit.Body = p.parseBlockExpression()
+		p.markRange(lit.Body, opener)
 		p.stopAtDo = previousStopAtDo
 	} else if p.curTokenIs(lexer.DO) || p.peekTokenIs(lexer.DO) {
 		if p.peekTokenIs(lexer.DO) {
 			p.nextToken()
 		}
+		opener := p.curToken
 		lit.Body = p.parseBlockExpression()
+		p.markRange(lit.Body, opener)
 	}
 
 	return lit
@@ -5738,11 +5870,13 @@ func (p *Parser) parseLambdaParam(lit *ast.ProcLiteral, seen map[string]struct{}
 	record(p.curToken.Literal)
 	if p.peekTokenIs(lexer.COLON) {
 		keyword := &ast.KeywordParam{Name: p.curToken.Literal}
+		label := p.curToken
 		p.nextToken()
 		if !p.peekTokenIs(lexer.COMMA) && !p.peekTokenIs(lexer.RPAREN) && !p.peekTokenIs(lexer.LBRACE) && !p.peekTokenIs(lexer.DO) {
 			p.nextToken()
 			keyword.Default = p.parseParameterDefaultExpression(lexer.COMMA, lexer.RPAREN)
 		}
+		p.markRange(keyword, label)
 		lit.KeywordParams = append(lit.KeywordParams, keyword)
 		return
 	}
@@ -5915,14 +6049,17 @@ func (p *Parser) parseBlockExpression() *ast.BlockExpression {
 		block.Statements = []ast.Statement{&ast.ExpressionStatement{Token: begin.Token, Expression: begin}}
 	}
 
-	if p.curTokenIs(lexer.END) {
-		return block
+	if p.curTokenIs(lexer.END) || p.curTokenIs(lexer.RBRACE) {
+		// The block runs to its closing token, which is where it returns.
+		p.markRange(block, block.Token)
 	}
-
-	if p.curTokenIs(lexer.RBRACE) {
-		return block
+	if p.curTokenIs(lexer.EOF) {
+		closing := "end"
+		if block.Token.Type == lexer.LBRACE {
+			closing = "}"
+		}
+		p.parseError("expected %s, got %s", closing, p.curToken.Type)
 	}
-
 	return block
 }
 
@@ -6353,23 +6490,26 @@ func (p *Parser) parseRescueModifier(left ast.Expression) ast.Expression {
 	begin := &ast.BeginExpression{
 		Token: p.curToken,
 		Body: &ast.BlockExpression{
+			Located:    located(left),
 			Token:      p.curToken,
-			Statements: []ast.Statement{&ast.ExpressionStatement{Token: p.curToken, Expression: left}},
+			Statements: []ast.Statement{&ast.ExpressionStatement{Located: located(left), Token: p.curToken, Expression: left}},
 		},
 	}
 
+	rescueToken := p.curToken
 	p.nextToken()
 	rescueValue := p.parseExpression(LOWEST)
 
-	begin.Rescue = []*ast.RescueClause{
-		{
-			Token: p.curToken,
-			Body: &ast.BlockExpression{
-				Token:      p.curToken,
-				Statements: []ast.Statement{&ast.ExpressionStatement{Token: p.curToken, Expression: rescueValue}},
-			},
+	clause := &ast.RescueClause{
+		Token: p.curToken,
+		Body: &ast.BlockExpression{
+			Located:    located(rescueValue),
+			Token:      p.curToken,
+			Statements: []ast.Statement{&ast.ExpressionStatement{Located: located(rescueValue), Token: p.curToken, Expression: rescueValue}},
 		},
 	}
+	p.markRange(clause, rescueToken)
+	begin.Rescue = []*ast.RescueClause{clause}
 
 	return begin
 }
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"os"
 	"strings"
 	"testing"
@@ -5017,6 +5018,91 @@ func TestParseNestedEndHookStaysInsideOuterBlock(t *testing.T) {
 	}
 }
 
+func TestParseBeginAndEndHooksAsBlockCalls(t *testing.T) {
+	program := parse(t, "BEGIN { x = 1 }\nwhile gets\n  END { p x }\nend")
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected BEGIN and while statements, got %d: %s", len(program.Statements), program.String())
+	}
+	begin, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MethodCall)
+	if !ok || begin.Method.Value != "BEGIN" || begin.Block == nil || len(begin.Block.Statements) != 1 {
+		t.Fatalf("expected BEGIN call with block, got %s", program.Statements[0].String())
+	}
+	loop, ok := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.WhileExpression)
+	if !ok || len(loop.Body.Statements) != 1 {
+		t.Fatalf("expected END inside while body, got %s", program.Statements[1].String())
+	}
+	end, ok := loop.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MethodCall)
+	if !ok || end.Method.Value != "END" || end.Block == nil {
+		t.Fatalf("expected END call with block, got %s", loop.Body.Statements[0].String())
+	}
+}
+
+func TestParseErrorStopsAtFirstDiagnostic(t *testing.T) {
+	p := New(lexer.New("x = 1\ny = ) + (\nz = ]"))
+	p.SetFile("broken.rb")
+	p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 || len(p.Errors()) != 1 {
+		t.Fatalf("expected a single diagnostic, got %#v / %v", diagnostics, p.Errors())
+	}
+	want := Diagnostic{File: "broken.rb", Line: 2, Column: 5, EndColumn: 6, Message: "syntax error, unexpected ')'", Code: "y = ) + ("}
+	if diagnostics[0] != want {
+		t.Fatalf("diagnostic = %#v, want %#v", diagnostics[0], want)
+	}
+	if got := diagnostics[0].Report(1); got != "broken.rb:2: syntax error, unexpected ')'\ny = ) + (\n    ^" {
+		t.Fatalf("report = %q", got)
+	}
+}
+
+func TestParseErrorAtEndOfInputPointsPastLastLine(t *testing.T) {
+	p := New(lexer.New("puts 1\nputs(2\n\n"))
+	p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected a single diagnostic, got %#v", diagnostics)
+	}
+	if got := diagnostics[0].Report(10); got != "-:11: syntax error, unexpected end-of-input, expecting ')'\nputs(2\n      ^" {
+		t.Fatalf("report = %q", got)
+	}
+}
+
+func TestParseMissingOperandAtEndOfInputKeepsEnclosingExpectation(t *testing.T) {
+	tests := map[string]string{
+		"1 +":         "syntax error, unexpected end-of-input",
+		"b = (2 +":    "syntax error, unexpected end-of-input, expecting ')'",
+		"[1, 2 +":     "syntax error, unexpected end-of-input, expecting ']'",
+		"if x\n  1 +": "syntax error, unexpected end-of-input, expecting `end'",
+	}
+	for source, want := range tests {
+		p := New(lexer.New(source))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 || diagnostics[0].Message != want {
+			t.Errorf("%q: diagnostics = %#v, want %q", source, diagnostics, want)
+		}
+	}
+}
+
+func TestParseReportsUnexpectedEndOfInput(t *testing.T) {
+	for _, source := range []string{
+		"1 +", "x = ", "a, b =", "items.", "-", "a ? b :",
+		"if x then", "while x do", "for i in 1..3", "[1].each do |v|", "[1].map { |v|", "-> {",
+	} {
+		p := New(lexer.New(source))
+		p.ParseProgram()
+		if !p.UnexpectedEOF() {
+			t.Errorf("%q: expected an end-of-input error, got %v", source, p.Errors())
+		}
+	}
+	for _, source := range []string{"x = 1", "return", "x = ]", "end"} {
+		p := New(lexer.New(source))
+		p.ParseProgram()
+		if p.UnexpectedEOF() {
+			t.Errorf("%q: unexpected end-of-input error %v", source, p.Errors())
+		}
+	}
+}
+
 func TestParseSpacedGroupedArgumentKeepsDotChainInsideArgument(t *testing.T) {
 	expr := parseExpr(t, `double (5).to_s`)
 	call, ok := expr.(*ast.MethodCall)
@@ -6901,3 +6987,130 @@ func TestBareCallSplatCanBeFollowedByKeywordArgument(t *testing.T) {
 		t.Fatalf("unexpected delegate arguments: %#v", call)
 	}
 }
+
+func TestNodesRecordSourceRanges(t *testing.T) {
+	input := "# header\ndef add(a, b: 2)\n  a + b # sum\nend\nputs add(1) if ready\n"
+	program := parse(t, input)
+	text := func(node ast.Node) string {
+		r := node.SourceRange()
+		if !r.IsValid() {
+			t.Fatalf("%T has no source range", node)
+		}
+		return input[r.Start.Offset:r.End.Offset]
+	}
+
+	def := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.DefExpression)
+	if got := text(def); got != "def add(a, b: 2)\n  a + b # sum\nend" {
+		t.Fatalf("def range covers %q", got)
+	}
+	if r := def.SourceRange(); r.Start.Line != 2 || r.Start.Column != 0 || r.End.Line != 4 || r.End.Column != 3 {
+		t.Fatalf("unexpected def range %s", r)
+	}
+	if got := text(def.Body.Statements[0]); got != "a + b" {
+		t.Fatalf("body statement covers %q", got)
+	}
+	if r := def.KeywordParams[0].Loc; input[r.Start.Offset:r.End.Offset] != "b: 2" {
+		t.Fatalf("keyword param covers %q", input[r.Start.Offset:r.End.Offset])
+	}
+
+	modifier := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	if got := text(modifier); got != "puts add(1) if ready" {
+		t.Fatalf("modifier if covers %q", got)
+	}
+	if got := text(modifier.Consequent); got != "puts add(1)" {
+		t.Fatalf("modifier body covers %q", got)
+	}
+	call := modifier.Consequent.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MethodCall)
+	if got := text(call.Args[0]); got != "add(1)" {
+		t.Fatalf("argument covers %q", got)
+	}
+}
+
+func TestProgramKeepsComments(t *testing.T) {
+	program := parse(t, "# one\nx = 1 # two\n=begin\nthree\n=end\n")
+	if len(program.Comments) != 3 {
+		t.Fatalf("expected 3 comments, got %d", len(program.Comments))
+	}
+	want := []struct {
+		text      string
+		line, col int
+	}{{"# one", 1, 0}, {"# two", 2, 6}, {"=begin\nthree\n=end", 3, 0}}
+	for i, w := range want {
+		c := program.Comments[i]
+		if c.Text != w.text || c.Loc.Start.Line != w.line || c.Loc.Start.Column != w.col {
+			t.Fatalf("comment %d: got %q at %s", i, c.Text, c.Loc)
+		}
+	}
+}
+
+func TestParserRecoversFromSyntaxErrors(t *testing.T) {
+	input := "x = = 1\nclass A\n  def b(a b)\n  end\n  def c\n    foo(1,,2)\n    1\n  end\nend\nif z = = 2\n  puts 3\nend\nputs 4\n"
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	var lines []int
+	for _, d := range p.Diagnostics() {
+		lines = append(lines, d.Line)
+	}
+	if fmt.Sprint(lines) != "[1 3 6 10]" || len(p.Errors()) != 4 {
+		t.Fatalf("unexpected diagnostics on lines %v: %v", lines, p.Errors())
+	}
+	if len(program.Statements) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(program.Statements))
+	}
+	errorNode := func(stmt ast.Statement, source string) {
+		t.Helper()
+		node, ok := stmt.(*ast.ErrorNode)
+		if !ok {
+			t.Fatalf("expected an ErrorNode for %q, got %T", source, stmt)
+		}
+		if node.Source != source || input[node.Loc.Start.Offset:node.Loc.End.Offset] != source || node.Message == "" {
+			t.Fatalf("unexpected error node %+v", node)
+		}
+	}
+	errorNode(program.Statements[0], "x = = 1")
+	errorNode(program.Statements[2], "if z = = 2\n  puts 3\nend")
+
+	class := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.ClassExpression)
+	errorNode(class.Body.Statements[0], "def b(a b)\n  end")
+	def := class.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.DefExpression)
+	if def.Name.Value != "c" || len(def.Body.Statements) != 2 {
+		t.Fatalf("unexpected def %s", def)
+	}
+	errorNode(def.Body.Statements[0], "foo(1,,2)")
+
+	if got := program.Statements[3].String(); got != "puts(4)" {
+		t.Fatalf("last statement is %q", got)
+	}
+}
+
+func TestParserKeepsConstructsLeftOpenByARecoveredStatement(t *testing.T) {
+	p := New(lexer.New("class A\n  def a\n  end\n  def b\n    1\n"))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", p.Errors())
+	}
+	class := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ClassExpression)
+	if len(class.Body.Statements) != 2 {
+		t.Fatalf("expected 2 class body statements, got %d", len(class.Body.Statements))
+	}
+	if _, ok := class.Body.Statements[1].(*ast.ErrorNode); !ok {
+		t.Fatalf("expected the unterminated def to be an ErrorNode, got %T", class.Body.Statements[1])
+	}
+}
+
+func TestParserErrorLimit(t *testing.T) {
+	input := strings.Repeat("x = = 1\n", MaxErrors+5)
+	p := New(lexer.New(input))
+	p.ParseProgram()
+	if len(p.Diagnostics()) != MaxErrors {
+		t.Fatalf("expected %d diagnostics, got %d", MaxErrors, len(p.Diagnostics()))
+	}
+
+	p = New(lexer.New(input))
+	p.SetMaxErrors(1)
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 1 || len(program.Statements) != 1 {
+		t.Fatalf("expected parsing to stop at the first error, got %d errors and %d statements", len(p.Diagnostics()), len(program.Statements))
+	}
+}
@@ -0,0 +1,230 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// ranged is anything embedding ast.Located: every node, and the clause and
+// parameter structs that hang off them.
+type ranged interface {
+	SourceRange() ast.Range
+	SetSourceRange(ast.Range)
+}
+
+var (
+	rangedType = reflect.TypeOf((*ranged)(nil)).Elem()
+	tokenType  = reflect.TypeOf(lexer.Token{})
+)
+
+// nodeFields caches, per struct type, the exported fields that can hold a
+// node, token or position; the tree walks skip names, flags and counts.
+var nodeFields sync.Map // reflect.Type -> []int
+
+func walkedFields(t reflect.Type) []int {
+	if fields, ok := nodeFields.Load(t); ok {
+		return fields.([]int)
+	}
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() && mayHoldNodes(f.Type, map[reflect.Type]bool{}) {
+			fields = append(fields, i)
+		}
+	}
+	nodeFields.Store(t, fields)
+	return fields
+}
+
+func mayHoldNodes(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return mayHoldNodes(t.Elem(), visiting)
+	case reflect.Map:
+		return mayHoldNodes(t.Key(), visiting) || mayHoldNodes(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			// Already being looked at further up: a self-referencing type
+			// holds whatever its other fields hold.
+			return false
+		}
+		visiting[t] = true
+		if t == tokenType || t == positionType || t.Implements(rangedType) || reflect.PointerTo(t).Implements(rangedType) {
+			return true
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() && mayHoldNodes(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// position converts a byte offset in the source to an ast.Position.
+func (p *Parser) position(offset int) ast.Position {
+	line, column := p.l.Position(offset)
+	return ast.Position{Offset: offset, Line: line, Column: column}
+}
+
+// tokenRange is the source range of tok, or the zero Range for tokens the
+// parser synthesised.
+func (p *Parser) tokenRange(tok lexer.Token) ast.Range {
+	if tok.EndOffset <= tok.Offset {
+		return ast.Range{}
+	}
+	return ast.Range{Start: p.position(tok.Offset), End: p.position(tok.EndOffset)}
+}
+
+// lastConsumedToken is the final token of the construct just parsed. Parse
+// functions leave curToken on it, except those that stop on the terminator.
+func (p *Parser) lastConsumedToken() lexer.Token {
+	switch p.curToken.Type {
+	case lexer.NEWLINE, lexer.SEMICOLON, lexer.EOF:
+		return p.prevToken
+	}
+	return p.curToken
+}
+
+// markRange widens node's range to run from start to the last consumed token.
+func (p *Parser) markRange(node ranged, start lexer.Token) {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return
+	}
+	end := p.lastConsumedToken()
+	if end.EndOffset <= start.Offset || start.EndOffset <= start.Offset {
+		return
+	}
+	r := ast.Range{Start: p.position(start.Offset), End: p.position(end.EndOffset)}
+	node.SetSourceRange(node.SourceRange().Cover(r))
+}
+
+// markRangeFrom widens node's range to run from the start of left to the last
+// consumed token. Infix constructs begin where their left operand does.
+func (p *Parser) markRangeFrom(node ranged, left ranged) {
+	if node == nil || left == nil || reflect.ValueOf(left).IsNil() || reflect.ValueOf(node).IsNil() {
+		return
+	}
+	start := left.SourceRange()
+	if !start.IsValid() {
+		return
+	}
+	end := p.lastConsumedToken()
+	if end.EndOffset <= start.Start.Offset {
+		return
+	}
+	r := ast.Range{Start: start.Start, End: p.position(end.EndOffset)}
+	node.SetSourceRange(node.SourceRange().Cover(r))
+}
+
+// percentWordRanges returns the source range of each word in a %w/%i style
+// token, or nil when the literal does not line up with the source (the
+// delimiters are assumed to be "%w[" and "]").
+func (p *Parser) percentWordRanges(tok lexer.Token) []ast.Range {
+	base := tok.Offset + 3
+	if tok.EndOffset-base-1 != len(tok.Literal) {
+		return nil
+	}
+	var ranges []ast.Range
+	start := -1
+	input := tok.Literal
+	for i := 0; i <= len(input); i++ {
+		if i == len(input) || strings.IndexByte(" \t\r\n\f", input[i]) >= 0 {
+			if start >= 0 {
+				ranges = append(ranges, ast.Range{Start: p.position(base + start), End: p.position(base + i)})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		if input[i] == '\\' && i+1 < len(input) {
+			i++
+		}
+	}
+	return ranges
+}
+
+// located gives a node the parser synthesised around n the same range as n.
+// Such wrappers borrow whatever token was current, so their own Token says
+// little about where they are.
+func located(n ast.Node) ast.Located {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		return ast.Located{}
+	}
+	return ast.Located{Loc: n.SourceRange()}
+}
+
+// completeRanges widens every node's range to cover its children. Nodes the
+// parse functions built without a range also take in their own tokens.
+func (p *Parser) completeRanges(program *ast.Program) {
+	seen := make(map[ranged]ast.Range)
+	var walkNode func(node ranged) ast.Range
+	var walkValue func(v reflect.Value, tokens bool) ast.Range
+	walkNode = func(node ranged) ast.Range {
+		if node == nil || reflect.ValueOf(node).IsNil() {
+			return ast.Range{}
+		}
+		if r, ok := seen[node]; ok {
+			return r
+		}
+		r := node.SourceRange()
+		seen[node] = r
+		r = r.Cover(walkValue(reflect.ValueOf(node).Elem(), !r.IsValid()))
+		node.SetSourceRange(r)
+		seen[node] = r
+		return r
+	}
+	walkValue = func(v reflect.Value, tokens bool) ast.Range {
+		var r ast.Range
+		switch v.Kind() {
+		case reflect.Interface, reflect.Ptr:
+			if v.IsNil() {
+				return r
+			}
+			if v.Type().Implements(rangedType) {
+				return walkNode(v.Interface().(ranged))
+			}
+			return walkValue(v.Elem(), tokens)
+		case reflect.Struct:
+			if v.Type() == tokenType {
+				if !tokens {
+					return r
+				}
+				return p.tokenRange(v.Interface().(lexer.Token))
+			}
+			for _, i := range walkedFields(v.Type()) {
+				r = r.Cover(walkValue(v.Field(i), tokens))
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				r = r.Cover(walkValue(v.Index(i), tokens))
+			}
+		case reflect.Map:
+			iter := v.MapRange()
+			for iter.Next() {
+				r = r.Cover(walkValue(iter.Value(), tokens))
+			}
+		}
+		return r
+	}
+	for _, stmt := range program.Statements {
+		program.Loc = program.Loc.Cover(walkNode(stmt))
+	}
+}
+
+// collectComments copies the lexer's comments onto the program.
+func (p *Parser) collectComments(program *ast.Program) {
+	for _, c := range p.l.Comments() {
+		program.Comments = append(program.Comments, &ast.Comment{
+			Text: c.Text,
+			Loc:  ast.Range{Start: p.position(c.Offset), End: p.position(c.EndOffset)},
+		})
+	}
+}
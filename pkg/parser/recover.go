@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// MaxErrors is how many errors a parser reports before it stops parsing,
+// unless SetMaxErrors says otherwise.
+const MaxErrors = 20
+
+// SetMaxErrors bounds the errors the parser reports. Once n have been
+// reported ParseProgram returns; with n = 1 it stops at the first error.
+func (p *Parser) SetMaxErrors(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.maxErrors = n
+}
+
+// errorLimitReached reports whether no further errors will be recorded.
+func (p *Parser) errorLimitReached() bool {
+	return len(p.errors) >= p.maxErrors
+}
+
+// parseStatement parses one statement. A statement with a syntax error in
+// it becomes an ast.ErrorNode: the parser skips to where the statement
+// ends and resumes reporting errors from there. Errors that follow the
+// first one inside the same statement are usually a consequence of the
+// parser having lost its place, so they are not reported.
+//
+// The innermost statement that failed recovers, so an error inside a method
+// body leaves the def and the rest of its body intact.
+func (p *Parser) parseStatement() ast.Statement {
+	if p.recovering {
+		return p.parseStatementNode()
+	}
+	start := p.curToken
+	stmt := p.parseStatementNode()
+	if !p.recovering {
+		return stmt
+	}
+	if p.errorLimitReached() {
+		// ParseProgram is about to give up; leave the tree as it is.
+		return stmt
+	}
+	return p.recoverStatement(start)
+}
+
+// recoverStatement skips the rest of the statement that started at start and
+// returns the ErrorNode that replaces it. curToken is left on the last token
+// of the statement, where a statement's parse function leaves it.
+func (p *Parser) recoverStatement(start lexer.Token) ast.Statement {
+	end := p.statementEnd(start.Offset)
+	for !p.peekTokenIs(lexer.EOF) && p.peekToken.Offset < end {
+		p.nextToken()
+	}
+	if last := p.lastConsumedToken(); last.EndOffset > end {
+		end = last.EndOffset
+	}
+	p.recovering = false
+
+	node := &ast.ErrorNode{Token: start, Message: p.diagnostics[len(p.diagnostics)-1].Message}
+	input := p.l.Input()
+	if start.EndOffset > start.Offset && end > start.Offset && end <= len(input) {
+		node.Source = input[start.Offset:end]
+		node.Loc = ast.Range{Start: p.position(start.Offset), End: p.position(end)}
+	}
+	return node
+}
+
+// statementEnd returns the byte offset where the statement starting at
+// offset start ends: the first newline or semicolon outside brackets and
+// keyword blocks, or a closing end, }, ) or ] that belongs to an enclosing
+// construct. The statement is scanned again from its start, since the parse
+// that failed may have stopped anywhere in it.
+func (p *Parser) statementEnd(start int) int {
+	input := p.l.Input()
+	if start < 0 || start >= len(input) {
+		return len(input)
+	}
+	l := lexer.New(input[start:])
+	var open []lexer.TokenType // brackets, and END for keyword blocks
+	var prev lexer.Token
+	pendingDef := -1    // len(open) when a def's signature began, until its line ends
+	loopHeader := false // a while, until or for whose optional do has not been seen
+	for tok := l.NextToken(); tok.Type != lexer.EOF; prev, tok = tok, l.NextToken() {
+		afterDot := prev.Type == lexer.DOT || prev.Type == lexer.SAFE_NAV || prev.Type == lexer.COLON2 || prev.Type == lexer.DEF
+		switch tok.Type {
+		case lexer.NEWLINE, lexer.SEMICOLON:
+			if pendingDef >= 0 && len(open) == pendingDef {
+				open = append(open, lexer.END)
+				pendingDef = -1
+			}
+			loopHeader = false
+			if len(open) == 0 && !continuesLine(prev) {
+				return start + tok.Offset
+			}
+		case lexer.LPAREN, lexer.LBRACKET, lexer.LBRACE:
+			open = append(open, tok.Type)
+		case lexer.RPAREN, lexer.RBRACKET, lexer.RBRACE, lexer.END:
+			if tok.Type == lexer.END && afterDot {
+				break
+			}
+			if len(open) == 0 {
+				return start + tok.Offset
+			}
+			open = open[:len(open)-1]
+		case lexer.ASSIGN:
+			if pendingDef >= 0 && len(open) == pendingDef {
+				// def name(args) = value has no end.
+				pendingDef = -1
+			}
+		case lexer.DEF:
+			if !afterDot {
+				pendingDef = len(open)
+			}
+		case lexer.CLASS, lexer.MODULE, lexer.CASE, lexer.BEGIN:
+			if !afterDot {
+				open = append(open, lexer.END)
+			}
+		case lexer.FOR:
+			if !afterDot {
+				open = append(open, lexer.END)
+				loopHeader = true
+			}
+		case lexer.WHILE, lexer.UNTIL, lexer.IF, lexer.UNLESS:
+			if !afterDot && startsExpression(prev) {
+				open = append(open, lexer.END)
+				loopHeader = tok.Type == lexer.WHILE || tok.Type == lexer.UNTIL
+			}
+		case lexer.DO:
+			if loopHeader {
+				loopHeader = false
+			} else if !afterDot {
+				open = append(open, lexer.END)
+			}
+		}
+	}
+	return len(input)
+}
+
+// startsExpression reports whether a keyword following prev begins an
+// expression, rather than being an if, unless, while or until modifier.
+func startsExpression(prev lexer.Token) bool {
+	switch prev.Type {
+	case "", lexer.NEWLINE, lexer.SEMICOLON, lexer.LPAREN, lexer.LBRACKET, lexer.LBRACE, lexer.COMMA,
+		lexer.THEN, lexer.DO, lexer.ELSE, lexer.ELSIF, lexer.BEGIN, lexer.ENSURE, lexer.BANG:
+		return true
+	}
+	_, operator := precedences[prev.Type]
+	return operator && prev.Type != lexer.RESCUE
+}
+
+// continuesLine reports whether a newline after prev continues the
+// statement, as it does after a binary operator or a comma.
+func continuesLine(prev lexer.Token) bool {
+	if prev.Type == lexer.COMMA || prev.Type == lexer.BACKSLASH {
+		return true
+	}
+	_, operator := precedences[prev.Type]
+	return operator && prev.Type != lexer.RESCUE && prev.Type != lexer.IN
+}
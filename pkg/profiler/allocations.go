@@ -0,0 +1,351 @@
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// AllocationSite is a Ruby call site and the class of the objects it
+// allocated there.
+type AllocationSite struct {
+	File  string
+	Line  int
+	Class string
+}
+
+// Location returns the site as "file:line".
+func (s AllocationSite) Location() string {
+	return s.File + ":" + strconv.Itoa(s.Line)
+}
+
+// AllocationStats counts the objects allocated at a site, the bytes they
+// are estimated to take and how many of them survived.
+type AllocationStats struct {
+	AllocationSite
+	Count         int64
+	Bytes         int64
+	Retained      int64
+	RetainedBytes int64
+}
+
+// StringStats counts the allocations of Strings with one content, per
+// location.
+type StringStats struct {
+	Text      string
+	Count     int64
+	Retained  int64
+	locations map[string]*[2]int64
+	order     []string
+}
+
+// AllocationProfile aggregates allocations by site. The allocator reports
+// each object to Allocate and, once the profile ends, those still alive to
+// Retain.
+type AllocationProfile struct {
+	Sites   []*AllocationStats
+	Strings []*StringStats
+
+	index   map[AllocationSite]*AllocationStats
+	strings map[string]*StringStats
+}
+
+// Allocate counts an object allocated at site and returns the stats to
+// pass to Retain.
+func (p *AllocationProfile) Allocate(site AllocationSite, bytes int64) *AllocationStats {
+	if p.index == nil {
+		p.index = make(map[AllocationSite]*AllocationStats)
+	}
+	stats := p.index[site]
+	if stats == nil {
+		stats = &AllocationStats{AllocationSite: site}
+		p.index[site] = stats
+		p.Sites = append(p.Sites, stats)
+	}
+	stats.Count++
+	stats.Bytes += bytes
+	return stats
+}
+
+// AllocateString counts a String with content text allocated at site.
+func (p *AllocationProfile) AllocateString(text string, site AllocationSite) *StringStats {
+	if p.strings == nil {
+		p.strings = make(map[string]*StringStats)
+	}
+	stats := p.strings[text]
+	if stats == nil {
+		stats = &StringStats{Text: text, locations: make(map[string]*[2]int64)}
+		p.strings[text] = stats
+		p.Strings = append(p.Strings, stats)
+	}
+	stats.Count++
+	location := site.Location()
+	counts := stats.locations[location]
+	if counts == nil {
+		counts = &[2]int64{}
+		stats.locations[location] = counts
+		stats.order = append(stats.order, location)
+	}
+	counts[0]++
+	return stats
+}
+
+// Retain records that an object counted by Allocate survived.
+func (p *AllocationProfile) Retain(stats *AllocationStats, bytes int64) {
+	stats.Retained++
+	stats.RetainedBytes += bytes
+}
+
+// RetainString records that a String counted by AllocateString at site
+// survived.
+func (p *AllocationProfile) RetainString(stats *StringStats, site AllocationSite) {
+	stats.Retained++
+	if counts := stats.locations[site.Location()]; counts != nil {
+		counts[1]++
+	}
+}
+
+// Totals returns the objects and bytes allocated, or retained.
+func (p *AllocationProfile) Totals(retained bool) (objects, bytes int64) {
+	for _, stats := range p.Sites {
+		if retained {
+			objects += stats.Retained
+			bytes += stats.RetainedBytes
+		} else {
+			objects += stats.Count
+			bytes += stats.Bytes
+		}
+	}
+	return objects, bytes
+}
+
+// AllocationEntry is a line of an allocation report: a gem, file,
+// location or class and its count of objects or bytes.
+type AllocationEntry struct {
+	Data  string
+	Count int64
+}
+
+// Grouping names what an allocation report groups sites by.
+type Grouping string
+
+const (
+	ByGem      Grouping = "gem"
+	ByFile     Grouping = "file"
+	ByLocation Grouping = "location"
+	ByClass    Grouping = "class"
+)
+
+// gemPath matches the directory of an installed gem, whose name and version
+// group its files.
+var gemPath = regexp.MustCompile(`/gems/([^/]+)/`)
+
+func (g Grouping) key(site AllocationSite) string {
+	switch g {
+	case ByGem:
+		if match := gemPath.FindStringSubmatch(site.File); match != nil {
+			return match[1]
+		}
+		return "other"
+	case ByFile:
+		return site.File
+	case ByLocation:
+		return site.Location()
+	}
+	return site.Class
+}
+
+// Group returns the top entries by grouping, counting bytes rather than
+// objects when memory is set and retained objects when retained is. A top
+// of 0 or less returns every entry.
+func (p *AllocationProfile) Group(grouping Grouping, memory, retained bool, top int) []AllocationEntry {
+	counts := make(map[string]int64)
+	for _, stats := range p.Sites {
+		count := stats.Count
+		switch {
+		case memory && retained:
+			count = stats.RetainedBytes
+		case memory:
+			count = stats.Bytes
+		case retained:
+			count = stats.Retained
+		}
+		if count > 0 {
+			counts[grouping.key(stats.AllocationSite)] += count
+		}
+	}
+	entries := make([]AllocationEntry, 0, len(counts))
+	for data, count := range counts {
+		entries = append(entries, AllocationEntry{data, count})
+	}
+	sortEntries(entries)
+	if top > 0 && len(entries) > top {
+		entries = entries[:top]
+	}
+	return entries
+}
+
+func sortEntries(entries []AllocationEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Data < entries[j].Data
+	})
+}
+
+// StringReport returns the top Strings by allocation, or by retention, and
+// for each its top locations.
+func (p *AllocationProfile) StringReport(retained bool, top int) []StringReportEntry {
+	var report []StringReportEntry
+	for _, stats := range p.Strings {
+		count, column := stats.Count, 0
+		if retained {
+			count, column = stats.Retained, 1
+		}
+		if count == 0 {
+			continue
+		}
+		entry := StringReportEntry{Text: stats.Text, Count: count}
+		for _, location := range stats.order {
+			if n := stats.locations[location][column]; n > 0 {
+				entry.Locations = append(entry.Locations, AllocationEntry{location, n})
+			}
+		}
+		sortEntries(entry.Locations)
+		report = append(report, entry)
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		if report[i].Count != report[j].Count {
+			return report[i].Count > report[j].Count
+		}
+		return report[i].Text < report[j].Text
+	})
+	if top >= 0 && len(report) > top {
+		report = report[:top]
+	}
+	return report
+}
+
+// StringReportEntry is a String content, how often it was allocated or
+// retained, and where.
+type StringReportEntry struct {
+	Text      string
+	Count     int64
+	Locations []AllocationEntry
+}
+
+// ReportOptions controls WriteReport. Top bounds each table and the string
+// reports their own numbers of Strings; ScaleBytes prints sizes in kB, MB
+// and so on; Summary prints the totals only.
+type ReportOptions struct {
+	Top              int
+	AllocatedStrings int
+	RetainedStrings  int
+	ScaleBytes       bool
+	Summary          bool
+}
+
+// DefaultReportOptions are memory_profiler's defaults.
+var DefaultReportOptions = ReportOptions{Top: 50, AllocatedStrings: 50, RetainedStrings: 50}
+
+// WriteReport writes the report memory_profiler's pretty_print prints:
+// totals, allocated and retained memory and objects by gem, file, location
+// and class, and the most allocated and retained Strings.
+func (p *AllocationProfile) WriteReport(w io.Writer, opts ReportOptions) error {
+	out := bufio.NewWriter(w)
+	bytes := func(n int64) string {
+		if !opts.ScaleBytes {
+			return strconv.FormatInt(n, 10)
+		}
+		return scaleBytes(n)
+	}
+	objects, size := p.Totals(false)
+	unit := " bytes"
+	if opts.ScaleBytes {
+		unit = ""
+	}
+	fmt.Fprintf(out, "Total allocated: %s%s (%d objects)\n", bytes(size), unit, objects)
+	objects, size = p.Totals(true)
+	fmt.Fprintf(out, "Total retained:  %s%s (%d objects)\n", bytes(size), unit, objects)
+	if opts.Summary {
+		return out.Flush()
+	}
+	for _, retained := range []bool{false, true} {
+		for _, memory := range []bool{true, false} {
+			for _, grouping := range []Grouping{ByGem, ByFile, ByLocation, ByClass} {
+				state, metric := "allocated", "objects"
+				if retained {
+					state = "retained"
+				}
+				if memory {
+					metric = "memory"
+				}
+				fmt.Fprintf(out, "\n%s %s by %s\n-----------------------------------\n", state, metric, grouping)
+				entries := p.Group(grouping, memory, retained, opts.Top)
+				if len(entries) == 0 {
+					fmt.Fprintln(out, "NO DATA")
+					continue
+				}
+				for _, entry := range entries {
+					count := strconv.FormatInt(entry.Count, 10)
+					if memory {
+						count = bytes(entry.Count)
+					}
+					fmt.Fprintf(out, "%10s  %s\n", count, entry.Data)
+				}
+			}
+		}
+	}
+	for _, retained := range []bool{false, true} {
+		title, top := "Allocated", opts.AllocatedStrings
+		if retained {
+			title, top = "Retained", opts.RetainedStrings
+		}
+		report := p.StringReport(retained, top)
+		if len(report) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s String Report\n-----------------------------------\n", title)
+		for _, entry := range report {
+			fmt.Fprintf(out, "%10d  %s\n", entry.Count, strconv.Quote(entry.Text))
+			for _, location := range entry.Locations {
+				fmt.Fprintf(out, "%10d  %s\n", location.Count, location.Data)
+			}
+			fmt.Fprintln(out)
+		}
+	}
+	return out.Flush()
+}
+
+// WriteReportFile writes the report to path.
+func (p *AllocationProfile) WriteReportFile(path string, opts ReportOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = p.WriteReport(file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// scaleBytes prints n in the largest decimal unit below it, as
+// memory_profiler does.
+func scaleBytes(n int64) string {
+	if n <= 0 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+	value, unit := float64(n), 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.2f %s", value, units[unit])
+}
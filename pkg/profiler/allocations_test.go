@@ -0,0 +1,65 @@
+package profiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testAllocationProfile() *AllocationProfile {
+	p := &AllocationProfile{}
+	row := AllocationSite{File: "/app/report.rb", Line: 4, Class: "Row"}
+	cell := AllocationSite{File: "/gems/prawn-2.5.0/lib/prawn.rb", Line: 10, Class: "String"}
+	for i := 0; i < 3; i++ {
+		stats := p.Allocate(row, 40)
+		if i == 0 {
+			p.Retain(stats, 40)
+		}
+	}
+	p.Allocate(cell, 25)
+	text := p.AllocateString("cell", cell)
+	p.RetainString(text, cell)
+	return p
+}
+
+func TestAllocationProfileGroupsSites(t *testing.T) {
+	p := testAllocationProfile()
+	if objects, bytes := p.Totals(false); objects != 4 || bytes != 145 {
+		t.Fatalf("allocated = %d objects, %d bytes", objects, bytes)
+	}
+	if objects, bytes := p.Totals(true); objects != 1 || bytes != 40 {
+		t.Fatalf("retained = %d objects, %d bytes", objects, bytes)
+	}
+	gems := p.Group(ByGem, true, false, 0)
+	if len(gems) != 2 || gems[0] != (AllocationEntry{"other", 120}) || gems[1] != (AllocationEntry{"prawn-2.5.0", 25}) {
+		t.Fatalf("memory by gem = %v", gems)
+	}
+	if got := p.Group(ByLocation, false, true, 0); len(got) != 1 || got[0] != (AllocationEntry{"/app/report.rb:4", 1}) {
+		t.Fatalf("retained objects by location = %v", got)
+	}
+	if got := p.Group(ByClass, false, false, 1); len(got) != 1 || got[0].Data != "Row" {
+		t.Fatalf("top class = %v", got)
+	}
+}
+
+func TestAllocationProfileWritesReport(t *testing.T) {
+	var out bytes.Buffer
+	if err := testAllocationProfile().WriteReport(&out, DefaultReportOptions); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		"Total allocated: 145 bytes (4 objects)\n",
+		"Total retained:  40 bytes (1 objects)\n",
+		"allocated memory by class\n-----------------------------------\n       120  Row\n        25  String\n",
+		"retained memory by gem\n-----------------------------------\n        40  other\n",
+		"Retained String Report\n-----------------------------------\n         1  \"cell\"\n         1  /gems/prawn-2.5.0/lib/prawn.rb:10\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+	if got := scaleBytes(1536000); got != "1.54 MB" {
+		t.Errorf("scaleBytes = %q", got)
+	}
+}
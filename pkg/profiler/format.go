@@ -0,0 +1,336 @@
+package profiler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Format is an output format of a profile.
+type Format int
+
+const (
+	// Pprof is the gzipped protocol buffer read by `go tool pprof`, with a
+	// function per Ruby method and a location per line.
+	Pprof Format = iota
+	// Collapsed is one "outer;inner count" line per stack, the input of
+	// flamegraph.pl and most flame graph viewers.
+	Collapsed
+	// Speedscope is the JSON document of https://www.speedscope.app.
+	Speedscope
+)
+
+// FormatOf returns the format a file name asks for by its extension:
+// .pb.gz, .pb or .pprof for pprof, .json for speedscope and .txt, .folded
+// or .collapsed for collapsed stacks.
+func FormatOf(path string) (Format, bool) {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".pb.gz"), strings.HasSuffix(name, ".pb"), strings.HasSuffix(name, ".pprof"):
+		return Pprof, true
+	case strings.HasSuffix(name, ".json"):
+		return Speedscope, true
+	case strings.HasSuffix(name, ".txt"), strings.HasSuffix(name, ".folded"), strings.HasSuffix(name, ".collapsed"):
+		return Collapsed, true
+	}
+	return Collapsed, false
+}
+
+// Write writes the profile to w in format.
+func (p *Profile) Write(w io.Writer, format Format) error {
+	switch format {
+	case Pprof:
+		return p.WritePprof(w)
+	case Speedscope:
+		return p.WriteSpeedscope(w)
+	}
+	return p.WriteCollapsed(w)
+}
+
+// WriteFile writes the profile to path in the format its extension asks
+// for, collapsed stacks when it names none.
+func (p *Profile) WriteFile(path string) error {
+	format, _ := FormatOf(path)
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = p.Write(file, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// WriteCollapsed writes one line per distinct stack of frame names,
+// outermost first, followed by its sample count. Lines are sorted, so
+// equal profiles give equal output.
+func (p *Profile) WriteCollapsed(w io.Writer) error {
+	counts := make(map[string]int64)
+	for _, sample := range p.Samples {
+		names := make([]string, len(sample.Stack))
+		for index, frame := range sample.Stack {
+			names[len(names)-1-index] = strings.ReplaceAll(frame.Name, ";", ":")
+		}
+		counts[strings.Join(names, ";")] += sample.Count
+	}
+	stacks := make([]string, 0, len(counts))
+	for stack := range counts {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	out := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		fmt.Fprintf(out, "%s %d\n", stack, counts[stack])
+	}
+	return out.Flush()
+}
+
+// functionKey identifies a Ruby method or block across samples.
+type functionKey struct {
+	name, file string
+	startLine  int
+}
+
+func frameFunction(frame Frame) functionKey {
+	return functionKey{frame.Name, frame.File, frame.StartLine}
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+// WriteSpeedscope writes the profile as a speedscope sampled profile,
+// weighted in nanoseconds of the profile's clock.
+func (p *Profile) WriteSpeedscope(w io.Writer) error {
+	name := "rgo " + string(p.Mode) + " profile"
+	doc := speedscopeFile{
+		Schema:   "https://www.speedscope.app/file-format-schema.json",
+		Shared:   speedscopeShared{Frames: []speedscopeFrame{}},
+		Name:     name,
+		Exporter: "rgo",
+	}
+	profile := speedscopeProfile{Type: "sampled", Name: name, Unit: "nanoseconds", Samples: [][]int{}, Weights: []int64{}}
+	frames := make(map[functionKey]int)
+	for _, sample := range p.Samples {
+		stack := make([]int, len(sample.Stack))
+		for index, frame := range sample.Stack {
+			key := frameFunction(frame)
+			id, ok := frames[key]
+			if !ok {
+				id = len(doc.Shared.Frames)
+				frames[key] = id
+				doc.Shared.Frames = append(doc.Shared.Frames, speedscopeFrame{Name: frame.Name, File: frame.File, Line: frame.StartLine})
+			}
+			stack[len(stack)-1-index] = id
+		}
+		weight := sample.Count * int64(p.Interval)
+		profile.Samples = append(profile.Samples, stack)
+		profile.Weights = append(profile.Weights, weight)
+		profile.EndValue += weight
+	}
+	doc.Profiles = []speedscopeProfile{profile}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(doc)
+}
+
+// Field numbers of perftools.profiles.Profile and its messages, from
+// https://github.com/google/pprof/blob/main/proto/profile.proto.
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID        = 1
+	functionName      = 2
+	functionFilename  = 4
+	functionStartLine = 5
+)
+
+// protoBuffer encodes protocol buffer fields.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(value uint64) {
+	for value >= 0x80 {
+		b.data = append(b.data, byte(value)|0x80)
+		value >>= 7
+	}
+	b.data = append(b.data, byte(value))
+}
+
+func (b *protoBuffer) uint64Field(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(value)
+}
+
+func (b *protoBuffer) int64Field(field int, value int64) {
+	b.uint64Field(field, uint64(value))
+}
+
+func (b *protoBuffer) bytesField(field int, value []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(value)))
+	b.data = append(b.data, value...)
+}
+
+func (b *protoBuffer) packedField(field int, values []uint64) {
+	var packed protoBuffer
+	for _, value := range values {
+		packed.varint(value)
+	}
+	b.bytesField(field, packed.data)
+}
+
+// pprofStrings is a profile's string table, whose first entry must be "".
+type pprofStrings struct {
+	table []string
+	index map[string]int64
+}
+
+func (s *pprofStrings) id(value string) int64 {
+	if id, ok := s.index[value]; ok {
+		return id
+	}
+	id := int64(len(s.table))
+	s.table = append(s.table, value)
+	s.index[value] = id
+	return id
+}
+
+type pprofLocation struct {
+	function uint64
+	line     int
+}
+
+// WritePprof writes the profile as a gzipped pprof protocol buffer with a
+// sample count and a time value per stack. Each Ruby method or block is a
+// function and each line run in it a location, so `go tool pprof -lines`
+// and its source views work on Ruby files.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strs := &pprofStrings{table: []string{""}, index: map[string]int64{"": 0}}
+	var out protoBuffer
+	valueType := func(field int, kind, unit string) {
+		var message protoBuffer
+		message.int64Field(valueTypeType, strs.id(kind))
+		message.int64Field(valueTypeUnit, strs.id(unit))
+		out.bytesField(field, message.data)
+	}
+	valueType(profileSampleType, "samples", "count")
+	valueType(profileSampleType, string(p.Mode), "nanoseconds")
+
+	functions := make(map[functionKey]uint64)
+	var functionMessages protoBuffer
+	locations := make(map[pprofLocation]uint64)
+	var locationMessages protoBuffer
+	for _, sample := range p.Samples {
+		ids := make([]uint64, len(sample.Stack))
+		for index, frame := range sample.Stack {
+			key := frameFunction(frame)
+			function, ok := functions[key]
+			if !ok {
+				function = uint64(len(functions) + 1)
+				functions[key] = function
+				// The function has no system name. pprof takes a name equal to
+				// its system name for demangled C++ and strips the <...> in
+				// it, which would show <main> as <unknown>.
+				var message protoBuffer
+				message.uint64Field(functionID, function)
+				message.int64Field(functionName, strs.id(frame.Name))
+				message.int64Field(functionFilename, strs.id(frame.File))
+				message.int64Field(functionStartLine, int64(frame.StartLine))
+				functionMessages.bytesField(profileFunction, message.data)
+			}
+			location := pprofLocation{function, frame.Line}
+			id, ok := locations[location]
+			if !ok {
+				id = uint64(len(locations) + 1)
+				locations[location] = id
+				var line protoBuffer
+				line.uint64Field(lineFunctionID, function)
+				line.int64Field(lineLine, int64(frame.Line))
+				var message protoBuffer
+				message.uint64Field(locationID, id)
+				message.bytesField(locationLine, line.data)
+				locationMessages.bytesField(profileLocation, message.data)
+			}
+			ids[index] = id
+		}
+		var message protoBuffer
+		message.packedField(sampleLocationID, ids)
+		message.packedField(sampleValue, []uint64{uint64(sample.Count), uint64(sample.Count * int64(p.Interval))})
+		out.bytesField(profileSample, message.data)
+	}
+	out.data = append(out.data, locationMessages.data...)
+	out.data = append(out.data, functionMessages.data...)
+	var periodType protoBuffer
+	periodType.int64Field(valueTypeType, strs.id(string(p.Mode)))
+	periodType.int64Field(valueTypeUnit, strs.id("nanoseconds"))
+	for _, value := range strs.table {
+		out.bytesField(profileStringTable, []byte(value))
+	}
+	out.int64Field(profileTimeNanos, p.Start.UnixNano())
+	out.int64Field(profileDurationNanos, int64(p.Duration))
+	out.bytesField(profilePeriodType, periodType.data)
+	out.int64Field(profilePeriod, int64(p.Interval))
+
+	compressed := gzip.NewWriter(w)
+	if _, err := compressed.Write(out.data); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
@@ -0,0 +1,191 @@
+// Package profiler samples the Ruby call stack at a fixed interval and
+// writes the samples as a pprof profile, collapsed stacks or a speedscope
+// document. It knows nothing of the VM: a timer only marks samples as due,
+// and the interpreter, polling Due between instructions, hands Record the
+// stack it is running. An AllocationProfile likewise aggregates the
+// allocations the runtime reports into a memory_profiler style report.
+package profiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Mode selects the clock samples are taken on.
+type Mode string
+
+const (
+	// CPU takes a sample for each interval of CPU time the process uses.
+	CPU Mode = "cpu"
+	// Wall takes a sample for each interval of elapsed time, so code
+	// waiting on I/O or sleeping is sampled too.
+	Wall Mode = "wall"
+)
+
+// DefaultInterval is the sampling interval StackProf uses by default.
+const DefaultInterval = time.Millisecond
+
+// Frame is one Ruby frame of a sampled stack.
+type Frame struct {
+	// Name is the frame's backtrace label, such as "Report#render" or
+	// "block in <main>".
+	Name string
+	File string
+	// Line is the line running in the frame when the sample was taken.
+	Line int
+	// StartLine is the first line of the method or block, or 0 for a
+	// native method.
+	StartLine int
+}
+
+// Sample is a distinct stack, innermost frame first, and the number of
+// samples taken in it.
+type Sample struct {
+	Stack []Frame
+	Count int64
+}
+
+// Profile holds the samples of a finished profiling run.
+type Profile struct {
+	Mode     Mode
+	Interval time.Duration
+	Start    time.Time
+	Duration time.Duration
+	Samples  []*Sample
+
+	index map[string]*Sample
+}
+
+// Add records count samples taken in stack.
+func (p *Profile) Add(stack []Frame, count int64) {
+	if len(stack) == 0 || count <= 0 {
+		return
+	}
+	var key strings.Builder
+	for _, frame := range stack {
+		key.WriteString(frame.Name)
+		key.WriteByte(0)
+		key.WriteString(frame.File)
+		key.WriteByte(0)
+		key.WriteString(strconv.Itoa(frame.Line))
+		key.WriteByte(0)
+		key.WriteString(strconv.Itoa(frame.StartLine))
+		key.WriteByte(1)
+	}
+	if p.index == nil {
+		p.index = make(map[string]*Sample)
+	}
+	if sample := p.index[key.String()]; sample != nil {
+		sample.Count += count
+		return
+	}
+	sample := &Sample{Stack: append([]Frame(nil), stack...), Count: count}
+	p.index[key.String()] = sample
+	p.Samples = append(p.Samples, sample)
+}
+
+// Total returns the number of samples taken.
+func (p *Profile) Total() int64 {
+	total := int64(0)
+	for _, sample := range p.Samples {
+		total += sample.Count
+	}
+	return total
+}
+
+// Profiler is a running profile. Due and Record may be called from any
+// goroutine.
+type Profiler struct {
+	pending atomic.Int64
+	done    chan struct{}
+	stopped sync.WaitGroup
+
+	mu      sync.Mutex
+	profile *Profile
+}
+
+// Start starts sampling on the clock of mode every interval.
+func Start(mode Mode, interval time.Duration) (*Profiler, error) {
+	if mode != CPU && mode != Wall {
+		return nil, fmt.Errorf("unknown profiler mode %q (want cpu or wall)", mode)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("profiler interval must be positive, got %v", interval)
+	}
+	p := &Profiler{
+		done:    make(chan struct{}),
+		profile: &Profile{Mode: mode, Interval: interval, Start: time.Now()},
+	}
+	p.stopped.Add(1)
+	go p.tick(mode, interval)
+	return p, nil
+}
+
+// tick marks a sample as due for each interval that passes on the clock.
+// Intervals of CPU time are measured between ticks of the wall clock, so a
+// process using several cores may owe more than one sample per tick.
+func (p *Profiler) tick(mode Mode, interval time.Duration) {
+	defer p.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	if mode == CPU {
+		last = time.Time{}.Add(processCPUTime())
+	}
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			if mode == CPU {
+				now = time.Time{}.Add(processCPUTime())
+			}
+			if due := now.Sub(last) / interval; due > 0 {
+				p.pending.Add(int64(due))
+				last = last.Add(due * interval)
+			}
+		}
+	}
+}
+
+// processCPUTime returns the user and system CPU time used by the process.
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// Due reports whether a sample should be recorded.
+func (p *Profiler) Due() bool {
+	return p.pending.Load() > 0
+}
+
+// Record attributes the samples due to stack, innermost frame first.
+// Samples that fell due while the interpreter could not poll, during a
+// long native call for instance, all go to the stack it returns to.
+func (p *Profiler) Record(stack []Frame) {
+	count := p.pending.Swap(0)
+	if count <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.profile.Add(stack, count)
+	p.mu.Unlock()
+}
+
+// Stop stops sampling and returns the profile.
+func (p *Profiler) Stop() *Profile {
+	close(p.done)
+	p.stopped.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profile.Duration = time.Since(p.profile.Start)
+	return p.profile
+}
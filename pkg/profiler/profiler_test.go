@@ -0,0 +1,179 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testProfile() *Profile {
+	main := Frame{Name: "<main>", File: "app.rb", Line: 9}
+	render := Frame{Name: "Report#render", File: "app.rb", Line: 3, StartLine: 2}
+	row := Frame{Name: "block in Report#render", File: "app.rb", Line: 4, StartLine: 4}
+	p := &Profile{Mode: CPU, Interval: time.Millisecond, Start: time.Unix(1700000000, 0), Duration: 5 * time.Millisecond}
+	p.Add([]Frame{row, render, main}, 2)
+	p.Add([]Frame{render, main}, 1)
+	p.Add([]Frame{row, render, main}, 1)
+	return p
+}
+
+func TestProfileAggregatesStacks(t *testing.T) {
+	p := testProfile()
+	if len(p.Samples) != 2 || p.Total() != 4 {
+		t.Fatalf("samples = %d, total = %d, want 2 stacks and 4 samples", len(p.Samples), p.Total())
+	}
+	var out bytes.Buffer
+	if err := p.WriteCollapsed(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "<main>;Report#render 1\n<main>;Report#render;block in Report#render 3\n"
+	if out.String() != want {
+		t.Fatalf("collapsed = %q, want %q", out.String(), want)
+	}
+}
+
+func TestProfileWritesSpeedscope(t *testing.T) {
+	var out bytes.Buffer
+	if err := testProfile().WriteSpeedscope(&out); err != nil {
+		t.Fatal(err)
+	}
+	var doc speedscopeFile
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Shared.Frames) != 3 || doc.Shared.Frames[0].Name != "block in Report#render" {
+		t.Fatalf("frames = %+v", doc.Shared.Frames)
+	}
+	profile := doc.Profiles[0]
+	if profile.EndValue != int64(4*time.Millisecond) || len(profile.Samples) != 2 {
+		t.Fatalf("profile = %+v", profile)
+	}
+	if got := profile.Samples[0]; len(got) != 3 || got[0] != 2 || got[2] != 0 {
+		t.Fatalf("first stack = %v, want outermost frame first", got)
+	}
+}
+
+func TestProfileWritesPprof(t *testing.T) {
+	var out bytes.Buffer
+	if err := testProfile().WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Report#render", "block in Report#render", "app.rb", "samples", "cpu", "nanoseconds"} {
+		if !bytes.Contains(data, []byte(name)) {
+			t.Errorf("profile lacks the string %q", name)
+		}
+	}
+
+	// Function names must match the other formats, and no function may
+	// carry a system name, or pprof rewrites "<main>" as a C++ template.
+	var strs []string
+	var functions [][]byte
+	for _, field := range protoFields(t, data) {
+		switch field.number {
+		case profileStringTable:
+			strs = append(strs, string(field.bytes))
+		case profileFunction:
+			functions = append(functions, field.bytes)
+		}
+	}
+	var names []string
+	for _, function := range functions {
+		for _, field := range protoFields(t, function) {
+			switch field.number {
+			case functionName:
+				names = append(names, strs[field.value])
+			case 3:
+				t.Errorf("function %q has a system name", function)
+			}
+		}
+	}
+	if got := strings.Join(names, ","); got != "block in Report#render,Report#render,<main>" {
+		t.Errorf("function names = %s", got)
+	}
+}
+
+type protoField struct {
+	number int
+	value  uint64
+	bytes  []byte
+}
+
+// protoFields decodes the varint and length-delimited fields of a message.
+func protoFields(t *testing.T, data []byte) []protoField {
+	t.Helper()
+	varint := func() uint64 {
+		var value uint64
+		for shift := 0; len(data) > 0; shift += 7 {
+			b := data[0]
+			data = data[1:]
+			value |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return value
+			}
+		}
+		t.Fatal("truncated varint")
+		return 0
+	}
+	var fields []protoField
+	for len(data) > 0 {
+		key := varint()
+		field := protoField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			field.value = varint()
+		case 2:
+			length := varint()
+			if uint64(len(data)) < length {
+				t.Fatal("truncated field")
+			}
+			field.bytes, data = data[:length], data[length:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func TestFormatOf(t *testing.T) {
+	for path, want := range map[string]Format{"cpu.pb.gz": Pprof, "cpu.pprof": Pprof, "cpu.json": Speedscope, "cpu.folded": Collapsed} {
+		if got, ok := FormatOf(path); !ok || got != want {
+			t.Errorf("FormatOf(%q) = %v, %v, want %v", path, got, ok, want)
+		}
+	}
+	if _, ok := FormatOf("stackprof.dump"); ok {
+		t.Errorf("FormatOf recognised a .dump file")
+	}
+}
+
+func TestProfilerRecordsDueSamples(t *testing.T) {
+	p, err := Start(Wall, 100*time.Microsecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := []Frame{{Name: "<main>", File: "loop.rb", Line: 1}}
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.Due() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	p.Record(stack)
+	profile := p.Stop()
+	if profile.Total() == 0 || !strings.Contains(profile.Samples[0].Stack[0].File, "loop.rb") {
+		t.Fatalf("profile = %+v, want the recorded stack", profile.Samples)
+	}
+	if _, err := Start("object", time.Millisecond); err == nil {
+		t.Fatal("Start accepted an unknown mode")
+	}
+}
@@ -0,0 +1,663 @@
+package rgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// Module is a Ruby module or class that Go code can add methods and
+// constants to.
+type Module struct {
+	interp *Interpreter
+	value  *object.EmeraldValue
+}
+
+var constantPathPattern = regexp.MustCompile(`^(::)?[A-Z]\w*(::[A-Z]\w*)*$`)
+
+var (
+	valueType   = reflect.TypeOf((*object.EmeraldValue)(nil))
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType  = reflect.TypeOf((*big.Int)(nil))
+	symbolType  = reflect.TypeOf(Symbol(""))
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
+// DefineModule returns the module at path, creating it and any missing
+// enclosing modules. It fails if the constant already names something that
+// is not a module.
+func (i *Interpreter) DefineModule(path string) (*Module, error) {
+	if i.closed {
+		return nil, errClosed
+	}
+	defer i.enter()()
+	return i.defineNamespace(path, false, nil)
+}
+
+// DefineClass returns the class at path, creating it as a subclass of
+// superclass (Object when nil) if it does not exist yet.
+func (i *Interpreter) DefineClass(path string, superclass *Module) (*Module, error) {
+	if i.closed {
+		return nil, errClosed
+	}
+	defer i.enter()()
+	super := classValue("Object")
+	if superclass != nil {
+		if superclass.value.Type != object.ValueClass {
+			return nil, fmt.Errorf("rgo: superclass %s is not a class", moduleName(superclass.value))
+		}
+		super = superclass.value
+	}
+	return i.defineNamespace(path, true, super)
+}
+
+// Module looks up an existing module or class by constant path.
+func (i *Interpreter) Module(path string) (*Module, error) {
+	value, err := i.Constant(path)
+	if err != nil {
+		return nil, err
+	}
+	if value.Type != object.ValueClass && value.Type != object.ValueModule {
+		return nil, &Error{Class: "TypeError", Message: path + " is not a class/module"}
+	}
+	return &Module{interp: i, value: value}, nil
+}
+
+func (i *Interpreter) defineNamespace(path string, class bool, super *object.EmeraldValue) (*Module, error) {
+	if !constantPathPattern.MatchString(path) {
+		return nil, fmt.Errorf("rgo: %q is not a constant path", path)
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "::"), "::")
+	container := classValue("Object")
+	for index, name := range parts {
+		last := index == len(parts)-1
+		symbol := symbolValue(name)
+		var current *object.EmeraldValue
+		if core.CallMethod(container, "const_defined?", symbol, core.R.FalseVal).IsTruthy() {
+			current = core.CallMethod(container, "const_get", symbol, core.R.FalseVal)
+			if err := i.pendingError(); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case current == nil:
+			if last && class {
+				current = core.CallMethod(classValue("Class"), "new", super)
+			} else {
+				current = core.CallMethod(classValue("Module"), "new")
+			}
+			core.CallMethod(container, "const_set", symbol, current)
+			if err := i.pendingError(); err != nil {
+				return nil, err
+			}
+		case last && class && current.Type != object.ValueClass:
+			return nil, &Error{Class: "TypeError", Message: path + " is not a class"}
+		case last && !class && current.Type != object.ValueModule:
+			return nil, &Error{Class: "TypeError", Message: path + " is not a module"}
+		case current.Type != object.ValueClass && current.Type != object.ValueModule:
+			return nil, &Error{Class: "TypeError", Message: moduleName(current) + " is not a class/module"}
+		}
+		container = current
+	}
+	return &Module{interp: i, value: container}, nil
+}
+
+// pendingError reports and clears an exception raised by a core call made
+// directly from Go.
+func (i *Interpreter) pendingError() error {
+	if core.LastException == nil || !raised(core.LastException) {
+		return nil
+	}
+	_, err := i.result(nil, core.LastException)
+	return err
+}
+
+// Value returns the Ruby module or class object.
+func (m *Module) Value() Value { return m.value }
+
+// Name returns the module's Ruby name.
+func (m *Module) Name() string { return moduleName(m.value) }
+
+func moduleName(value *object.EmeraldValue) string {
+	switch data := value.Data.(type) {
+	case *object.Class:
+		return data.Name
+	case *object.Module:
+		return data.Name
+	}
+	return rubyClassName(value)
+}
+
+// DefineMethod defines a public instance method backed by fn. The receiver
+// is passed as fn's first parameter, after an optional leading
+// context.Context, so a Go method expression such as (*Counter).Add can be
+// bound directly. See DefineFunction for how the remaining parameters and
+// results are converted.
+func (m *Module) DefineMethod(name string, fn any) error {
+	if m.interp.closed {
+		return errClosed
+	}
+	defer m.interp.enter()()
+	method, err := m.interp.bindFunc(name, fn, true)
+	if err != nil {
+		return err
+	}
+	defineOn(m.value, method)
+	return nil
+}
+
+// DefineSingletonMethod defines a method on the module object itself, the
+// equivalent of `def self.name`. fn does not receive the receiver.
+func (m *Module) DefineSingletonMethod(name string, fn any) error {
+	if m.interp.closed {
+		return errClosed
+	}
+	defer m.interp.enter()()
+	method, err := m.interp.bindFunc(name, fn, false)
+	if err != nil {
+		return err
+	}
+	defineOn(core.CallMethod(m.value, "singleton_class"), method)
+	return nil
+}
+
+// DefineConstructor binds fn as the class's initialize method. fn receives
+// the arguments given to new and returns the Go value to attach to the
+// instance, optionally followed by an error. The result type is remembered,
+// so Go functions that later return values of that type hand Ruby instances
+// of this class.
+func (m *Module) DefineConstructor(fn any) error {
+	if m.interp.closed {
+		return errClosed
+	}
+	if m.value.Type != object.ValueClass {
+		return fmt.Errorf("rgo: %s is not a class", m.Name())
+	}
+	fnType := reflect.TypeOf(fn)
+	if fnType == nil || fnType.Kind() != reflect.Func || fnType.NumOut() == 0 || fnType.Out(0) == errorType {
+		return fmt.Errorf("rgo: constructor for %s must be a function returning the instance data", m.Name())
+	}
+	defer m.interp.enter()()
+	binder, err := m.interp.newBinder("initialize", fn, false)
+	if err != nil {
+		return err
+	}
+	m.interp.registerHostType(fnType.Out(0), m.value)
+	defineOn(m.value, &object.Method{Name: "initialize", Arity: binder.arity(), Visibility: "private", Fn: func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		results, exception := binder.call(receiver, args)
+		if exception != nil {
+			return exception
+		}
+		receiver.SetHostData(results[0].Interface())
+		return core.R.NilVal
+	}})
+	return nil
+}
+
+// Wrap returns a new instance of the class carrying data, without running
+// initialize. Later conversions of values of data's type produce instances
+// of this class.
+func (m *Module) Wrap(data any) (Value, error) {
+	if m.interp.closed {
+		return nil, errClosed
+	}
+	if m.value.Type != object.ValueClass {
+		return nil, fmt.Errorf("rgo: %s is not a class", m.Name())
+	}
+	if data == nil {
+		return nil, fmt.Errorf("rgo: cannot wrap nil")
+	}
+	defer m.interp.enter()()
+	m.interp.registerHostType(reflect.TypeOf(data), m.value)
+	return m.interp.wrap(m.value, data), nil
+}
+
+// DefineConstant sets a constant under the module to value converted by
+// ToRuby.
+func (m *Module) DefineConstant(name string, value any) error {
+	if m.interp.closed {
+		return errClosed
+	}
+	defer m.interp.enter()()
+	rubyValue, err := m.interp.toRuby(value)
+	if err != nil {
+		return err
+	}
+	core.CallMethod(m.value, "const_set", symbolValue(name), rubyValue)
+	return m.interp.pendingError()
+}
+
+// DefineFunction defines a private method on Object, callable from any
+// Ruby code like a Kernel function.
+//
+// fn may take a context.Context first; it receives the context passed to
+// EvalContext or CallContext. Every other parameter consumes one Ruby
+// argument, and a variadic final parameter consumes the rest. Arguments are
+// converted to the parameter types with Ruby's implicit conversion rules:
+// Value and interface{} parameters accept anything, strings require a
+// String, integers an Integer in range, floats a Float or Integer, slices an
+// Array, maps a Hash and other types an object created by DefineConstructor
+// or Wrap holding a value of that type. A wrong argument count raises
+// ArgumentError and a failed conversion TypeError or RangeError.
+//
+// fn may return nothing, one value, an error, or a value and an error. The
+// value is converted with ToRuby. A non-nil error is raised in Ruby: an
+// *Error raises its Exception, or a new exception of its Class, and any
+// other error raises RuntimeError with the error's message.
+func (i *Interpreter) DefineFunction(name string, fn any) error {
+	if i.closed {
+		return errClosed
+	}
+	defer i.enter()()
+	method, err := i.bindFunc(name, fn, false)
+	if err != nil {
+		return err
+	}
+	method.Visibility = "private"
+	defineOn(classValue("Object"), method)
+	return nil
+}
+
+// HostData returns the Go value attached to a Ruby object by
+// DefineConstructor or Wrap, or nil.
+func (i *Interpreter) HostData(value Value) any {
+	return value.HostData()
+}
+
+func defineOn(target *object.EmeraldValue, method *object.Method) {
+	switch data := target.Data.(type) {
+	case *object.Class:
+		data.DefineMethod(method.Name, method)
+	case *object.Module:
+		data.DefineMethod(method.Name, method)
+	}
+}
+
+func (i *Interpreter) registerHostType(goType reflect.Type, class *object.EmeraldValue) {
+	if i.hostClasses == nil {
+		i.hostClasses = map[reflect.Type]*object.EmeraldValue{}
+	}
+	i.hostClasses[goType] = class
+}
+
+func (i *Interpreter) wrap(class *object.EmeraldValue, data any) *object.EmeraldValue {
+	instance := object.NewObjectValue(class.Data.(*object.Class))
+	instance.SetHostData(data)
+	return instance
+}
+
+func (i *Interpreter) bindFunc(name string, fn any, withReceiver bool) (*object.Method, error) {
+	binder, err := i.newBinder(name, fn, withReceiver)
+	if err != nil {
+		return nil, err
+	}
+	return &object.Method{Name: name, Arity: binder.arity(), Fn: func(receiver *object.EmeraldValue, args ...*object.EmeraldValue) *object.EmeraldValue {
+		results, exception := binder.call(receiver, args)
+		if exception != nil {
+			return exception
+		}
+		return binder.result(results)
+	}}, nil
+}
+
+// binder adapts one Go function to the native method calling convention.
+type binder struct {
+	interp       *Interpreter
+	name         string
+	fn           reflect.Value
+	withContext  bool
+	withReceiver bool
+	params       []reflect.Type
+	variadic     bool
+	hasError     bool
+	hasValue     bool
+}
+
+func (i *Interpreter) newBinder(name string, fn any, withReceiver bool) (*binder, error) {
+	value := reflect.ValueOf(fn)
+	if !value.IsValid() || value.Kind() != reflect.Func || value.IsNil() {
+		return nil, fmt.Errorf("rgo: %s: expected a function, got %T", name, fn)
+	}
+	fnType := value.Type()
+	b := &binder{interp: i, name: name, fn: value, withReceiver: withReceiver, variadic: fnType.IsVariadic()}
+	for index := 0; index < fnType.NumIn(); index++ {
+		b.params = append(b.params, fnType.In(index))
+	}
+	if len(b.params) > 0 && b.params[0] == contextType {
+		b.withContext = true
+		b.params = b.params[1:]
+	}
+	if withReceiver && (len(b.params) == 0 || b.variadic && len(b.params) == 1) {
+		return nil, fmt.Errorf("rgo: %s: method function must take the receiver as its first parameter", name)
+	}
+	switch fnType.NumOut() {
+	case 0:
+	case 1:
+		b.hasError = fnType.Out(0) == errorType
+		b.hasValue = !b.hasError
+	case 2:
+		if fnType.Out(1) != errorType {
+			return nil, fmt.Errorf("rgo: %s: second result must be error", name)
+		}
+		b.hasValue, b.hasError = true, true
+	default:
+		return nil, fmt.Errorf("rgo: %s: function returns more than a value and an error", name)
+	}
+	return b, nil
+}
+
+// arity reports the Ruby arity: the argument count, or -(required+1) when
+// the function is variadic.
+func (b *binder) arity() int {
+	count := len(b.params)
+	if b.withReceiver {
+		count--
+	}
+	if b.variadic {
+		return -count
+	}
+	return count
+}
+
+// call converts receiver and args, invokes the function and returns its raw
+// results, or the exception to raise.
+func (b *binder) call(receiver *object.EmeraldValue, args []*object.EmeraldValue) ([]reflect.Value, *object.EmeraldValue) {
+	params := b.params
+	var in []reflect.Value
+	if b.withContext {
+		in = append(in, reflect.ValueOf(b.interp.context()))
+	}
+	if b.withReceiver {
+		self, exception := b.interp.fromRuby(receiver, params[0])
+		if exception != nil {
+			return nil, exception
+		}
+		in = append(in, self)
+		params = params[1:]
+	}
+	required := len(params)
+	if b.variadic {
+		required--
+	}
+	if len(args) < required || !b.variadic && len(args) > required {
+		expected := strconv.Itoa(required)
+		if b.variadic {
+			expected += "+"
+		}
+		return nil, core.NewArgumentError(fmt.Sprintf("wrong number of arguments (given %d, expected %s)", len(args), expected))
+	}
+	for index, arg := range args {
+		paramType := params[min(index, len(params)-1)]
+		if b.variadic && index >= required {
+			paramType = params[len(params)-1].Elem()
+		}
+		converted, exception := b.interp.fromRuby(arg, paramType)
+		if exception != nil {
+			return nil, exception
+		}
+		in = append(in, converted)
+	}
+	results, exception := b.invoke(in)
+	if exception != nil {
+		return nil, exception
+	}
+	if b.hasError {
+		if err, _ := results[len(results)-1].Interface().(error); err != nil {
+			return nil, b.interp.exceptionFor(err)
+		}
+	}
+	return results, nil
+}
+
+// invoke calls the function. A Go panic becomes a RuntimeError, so Ruby
+// code can rescue it and Eval reports it as an *Error instead of the panic
+// taking down the host program.
+func (b *binder) invoke(in []reflect.Value) (results []reflect.Value, exception *object.EmeraldValue) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			results, exception = nil, core.NewRuntimeError(fmt.Sprintf("%s panicked: %v", b.name, recovered))
+		}
+	}()
+	return b.fn.Call(in), nil
+}
+
+func (b *binder) result(results []reflect.Value) *object.EmeraldValue {
+	if !b.hasValue {
+		return core.R.NilVal
+	}
+	value, err := b.interp.toRuby(results[0].Interface())
+	if err != nil {
+		return core.NewTypeError(fmt.Sprintf("%s returned %s", b.name, err))
+	}
+	return value
+}
+
+// exceptionFor maps a Go error returned by a bound function to the Ruby
+// exception it raises.
+func (i *Interpreter) exceptionFor(err error) *object.EmeraldValue {
+	var rubyErr *Error
+	if errors.As(err, &rubyErr) {
+		if rubyErr.Exception != nil {
+			if data, ok := rubyErr.Exception.Data.(*object.RException); ok && data != nil {
+				data.Raised = true
+			}
+			core.LastException = rubyErr.Exception
+			return rubyErr.Exception
+		}
+		if class := core.GetConstantName(strings.TrimPrefix(rubyErr.Class, "::")); class != nil && class.Type == object.ValueClass {
+			exception := core.CallMethod(class, "new", core.NewStringValue(rubyErr.Message))
+			if data, ok := exception.Data.(*object.RException); ok && exception.Type == object.ValueException {
+				data.Raised = true
+				core.LastException = exception
+				return exception
+			}
+		}
+		return core.NewException(rubyErr.Class, rubyErr.Message)
+	}
+	return core.NewRuntimeError(err.Error())
+}
+
+// fromRuby converts a Ruby argument to target, returning a TypeError or
+// RangeError exception when it cannot.
+func (i *Interpreter) fromRuby(value *object.EmeraldValue, target reflect.Type) (reflect.Value, *object.EmeraldValue) {
+	if value == nil {
+		value = core.R.NilVal
+	}
+	switch target {
+	case valueType:
+		return reflect.ValueOf(value), nil
+	case bigIntType:
+		if value.Type != object.ValueInteger {
+			return reflect.Value{}, noImplicitConversion(value, "Integer")
+		}
+		if bignum := value.BigIntValue(); bignum != nil {
+			return reflect.ValueOf(new(big.Int).Set(bignum)), nil
+		}
+		return reflect.ValueOf(big.NewInt(value.Data.(int64))), nil
+	case bytesType:
+		if value.Type != object.ValueString {
+			return reflect.Value{}, noImplicitConversion(value, "String")
+		}
+		return reflect.ValueOf([]byte(value.Data.(string))), nil
+	case symbolType:
+		if value.Type != object.ValueSymbol && value.Type != object.ValueString {
+			return reflect.Value{}, core.NewTypeError(fmt.Sprintf("%s is not a symbol nor a string", inspect(value)))
+		}
+		return reflect.ValueOf(Symbol(value.Data.(string))), nil
+	}
+	if host := value.HostData(); host != nil && reflect.TypeOf(host).AssignableTo(target) {
+		return reflect.ValueOf(host), nil
+	}
+
+	converted := reflect.New(target).Elem()
+	switch target.Kind() {
+	case reflect.Interface:
+		if target.NumMethod() == 0 {
+			if goValue := i.toGo(value, map[*object.EmeraldValue]bool{}); goValue != nil {
+				converted.Set(reflect.ValueOf(goValue))
+			}
+			return converted, nil
+		}
+	case reflect.Bool:
+		converted.SetBool(value.IsTruthy())
+		return converted, nil
+	case reflect.String:
+		if value.Type != object.ValueString {
+			return reflect.Value{}, noImplicitConversion(value, "String")
+		}
+		converted.SetString(value.Data.(string))
+		return converted, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer, exception := integerArgument(value, target)
+		if exception != nil {
+			return reflect.Value{}, exception
+		}
+		if converted.OverflowInt(integer) {
+			return reflect.Value{}, integerRangeError(value, target)
+		}
+		converted.SetInt(integer)
+		return converted, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Type == object.ValueInteger && value.BigIntValue() != nil && value.BigIntValue().IsUint64() {
+			converted.SetUint(value.BigIntValue().Uint64())
+			return converted, nil
+		}
+		integer, exception := integerArgument(value, target)
+		if exception != nil {
+			return reflect.Value{}, exception
+		}
+		if integer < 0 || converted.OverflowUint(uint64(integer)) {
+			return reflect.Value{}, integerRangeError(value, target)
+		}
+		converted.SetUint(uint64(integer))
+		return converted, nil
+	case reflect.Float32, reflect.Float64:
+		switch value.Type {
+		case object.ValueFloat:
+			converted.SetFloat(value.Data.(float64))
+		case object.ValueInteger:
+			if bignum := value.BigIntValue(); bignum != nil {
+				float, _ := new(big.Float).SetInt(bignum).Float64()
+				converted.SetFloat(float)
+			} else {
+				converted.SetFloat(float64(value.Data.(int64)))
+			}
+		default:
+			return reflect.Value{}, core.NewTypeError(fmt.Sprintf("can't convert %s into Float", rubyClassName(value)))
+		}
+		return converted, nil
+	case reflect.Slice:
+		if value.Type != object.ValueArray {
+			return reflect.Value{}, noImplicitConversion(value, "Array")
+		}
+		elements, ok := value.MaterializeLazyArray()
+		if !ok {
+			elements, _ = value.Data.([]*object.EmeraldValue)
+		}
+		converted = reflect.MakeSlice(target, len(elements), len(elements))
+		for index, element := range elements {
+			item, exception := i.fromRuby(element, target.Elem())
+			if exception != nil {
+				return reflect.Value{}, exception
+			}
+			converted.Index(index).Set(item)
+		}
+		return converted, nil
+	case reflect.Map:
+		if value.Type != object.ValueHash {
+			return reflect.Value{}, noImplicitConversion(value, "Hash")
+		}
+		converted = reflect.MakeMap(target)
+		pairs := core.CallMethod(value, "to_a")
+		entries, _ := pairs.Data.([]*object.EmeraldValue)
+		for _, entry := range entries {
+			pair, _ := entry.Data.([]*object.EmeraldValue)
+			if len(pair) != 2 {
+				continue
+			}
+			key, exception := i.fromRuby(pair[0], target.Key())
+			if exception != nil {
+				return reflect.Value{}, exception
+			}
+			item, exception := i.fromRuby(pair[1], target.Elem())
+			if exception != nil {
+				return reflect.Value{}, exception
+			}
+			converted.SetMapIndex(key, item)
+		}
+		return converted, nil
+	case reflect.Pointer:
+		if value.Type == object.ValueNil {
+			return converted, nil
+		}
+	}
+	expected := target.String()
+	if class, ok := i.hostClasses[target]; ok {
+		expected = moduleName(class)
+	}
+	return reflect.Value{}, core.NewTypeError(fmt.Sprintf("wrong argument type %s (expected %s)", rubyClassName(value), expected))
+}
+
+func integerArgument(value *object.EmeraldValue, target reflect.Type) (int64, *object.EmeraldValue) {
+	switch value.Type {
+	case object.ValueInteger:
+		if value.BigIntValue() != nil {
+			return 0, integerRangeError(value, target)
+		}
+		return value.Data.(int64), nil
+	case object.ValueFloat:
+		float := value.Data.(float64)
+		if math.IsNaN(float) || math.IsInf(float, 0) || float >= math.MaxInt64 || float < math.MinInt64 {
+			return 0, core.NewRangeError(fmt.Sprintf("float %s out of range of integer", inspect(value)))
+		}
+		return int64(float), nil
+	case object.ValueNil:
+		return 0, core.NewTypeError("no implicit conversion from nil to integer")
+	}
+	return 0, noImplicitConversion(value, "Integer")
+}
+
+func integerRangeError(value *object.EmeraldValue, target reflect.Type) *object.EmeraldValue {
+	return core.NewRangeError(fmt.Sprintf("integer %s too big to convert to '%s'", inspect(value), target.Kind()))
+}
+
+func noImplicitConversion(value *object.EmeraldValue, into string) *object.EmeraldValue {
+	return core.NewTypeError(fmt.Sprintf("no implicit conversion of %s into %s", rubyClassName(value), into))
+}
+
+func rubyClassName(value *object.EmeraldValue) string {
+	switch value.Type {
+	case object.ValueNil:
+		return "nil"
+	case object.ValueBool:
+		if value.IsTruthy() {
+			return "true"
+		}
+		return "false"
+	}
+	if value.Class != nil {
+		return value.Class.Name
+	}
+	return "Object"
+}
+
+func inspect(value *object.EmeraldValue) string {
+	if text := core.CallMethod(value, "inspect"); text != nil && text.Type == object.ValueString {
+		return text.Data.(string)
+	}
+	return rubyClassName(value)
+}
+
+func symbolValue(name string) *object.EmeraldValue {
+	return &object.EmeraldValue{Type: object.ValueSymbol, Data: name, Class: core.R.Classes["Symbol"]}
+}
@@ -0,0 +1,196 @@
+package rgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type counter struct {
+	label string
+	count int64
+}
+
+func (c *counter) Add(step int64) int64 {
+	c.count += step
+	return c.count
+}
+
+type contextKey struct{}
+
+func TestBoundFunctionsConvertArgumentsAndErrors(t *testing.T) {
+	interp := newTestInterpreter(t)
+
+	if err := interp.DefineFunction("greet", func(ctx context.Context, name string, times int64) (string, error) {
+		if times < 0 {
+			return "", fmt.Errorf("negative count %d", times)
+		}
+		prefix, _ := ctx.Value(contextKey{}).(string)
+		return prefix + strings.Repeat(name, int(times)), nil
+	}); err != nil {
+		t.Fatalf("DefineFunction: %v", err)
+	}
+	if err := interp.DefineFunction("total", func(values ...float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum
+	}); err != nil {
+		t.Fatalf("DefineFunction total: %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), contextKey{}, ">")
+	value, err := interp.EvalContext(ctx, `
+results = [greet("ab", 2), total, total(1, 2.5)]
+%w[arity count type range runtime].each do |kind|
+  begin
+    case kind
+    when "arity" then greet("x")
+    when "count" then total("1")
+    when "type" then greet(1, 2)
+    when "range" then greet("x", 2**70)
+    when "runtime" then greet("x", -1)
+    end
+  rescue => e
+    results << "#{e.class}: #{e.message}"
+  end
+end
+results << method(:greet).arity << method(:total).arity
+results`, "bind.rb")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	got := interp.ToGo(value).([]any)
+	want := []any{
+		">abab", 0.0, 3.5,
+		"ArgumentError: wrong number of arguments (given 1, expected 2)",
+		"TypeError: can't convert String into Float",
+		"TypeError: no implicit conversion of Integer into String",
+		"RangeError: integer 1180591620717411303424 too big to convert to 'int64'",
+		"RuntimeError: negative count -1",
+		int64(2), int64(-1),
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("results = %#v\nwant %#v", got, want)
+	}
+}
+
+func TestBoundClassesCarryGoData(t *testing.T) {
+	interp := newTestInterpreter(t)
+
+	class, err := interp.DefineClass("Metrics::Counter", nil)
+	if err != nil {
+		t.Fatalf("DefineClass: %v", err)
+	}
+	if err := class.DefineConstructor(func(label string) (*counter, error) {
+		if label == "" {
+			return nil, &Error{Class: "ArgumentError", Message: "label required"}
+		}
+		return &counter{label: label}, nil
+	}); err != nil {
+		t.Fatalf("DefineConstructor: %v", err)
+	}
+	for name, fn := range map[string]any{
+		"add":   (*counter).Add,
+		"label": func(c *counter) string { return c.label },
+		"merge": func(c, other *counter) *counter {
+			return &counter{label: c.label + "+" + other.label, count: c.count + other.count}
+		},
+	} {
+		if err := class.DefineMethod(name, fn); err != nil {
+			t.Fatalf("DefineMethod %s: %v", name, err)
+		}
+	}
+	if err := class.DefineSingletonMethod("kind", func() Symbol { return "metric" }); err != nil {
+		t.Fatalf("DefineSingletonMethod: %v", err)
+	}
+	namespace, err := interp.Module("Metrics")
+	if err != nil {
+		t.Fatalf("Module: %v", err)
+	}
+	if err := namespace.DefineConstant("LIMIT", 10); err != nil {
+		t.Fatalf("DefineConstant: %v", err)
+	}
+
+	value, err := interp.Eval(`
+a = Metrics::Counter.new("a")
+a.add(2)
+b = Metrics::Counter.new("b")
+b.add(Metrics::LIMIT)
+merged = a.merge(b)
+errors = []
+begin
+  a.merge(1)
+rescue TypeError => e
+  errors << e.message
+end
+begin
+  Metrics::Counter.new("")
+rescue ArgumentError => e
+  errors << e.message
+end
+[merged.class.name, merged.label, merged.add(0), Metrics::Counter.kind, errors]`, "counter.rb")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	want := "[Metrics::Counter a+b 12 metric [wrong argument type Integer (expected Metrics::Counter) label required]]"
+	if got := fmt.Sprint(interp.ToGo(value)); got != want {
+		t.Fatalf("result = %s, want %s", got, want)
+	}
+
+	wrapped, err := class.Wrap(&counter{label: "go", count: 5})
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	count, err := interp.Call(wrapped, "add", 1)
+	if err != nil || interp.ToGo(count) != int64(6) {
+		t.Fatalf("add on wrapped counter = %v, %v", count, err)
+	}
+	if data, ok := interp.HostData(wrapped).(*counter); !ok || data.count != 6 {
+		t.Fatalf("HostData = %#v", interp.HostData(wrapped))
+	}
+
+	if _, err := interp.DefineModule("Metrics::Counter"); err == nil {
+		t.Fatalf("DefineModule over a class succeeded")
+	}
+	if err := class.DefineMethod("bad", func() {}); err == nil {
+		t.Fatalf("DefineMethod accepted a function without a receiver")
+	}
+
+	var rubyErr *Error
+	_, err = interp.Eval("Metrics::Counter.new(nil)", "nil.rb")
+	if !errors.As(err, &rubyErr) || rubyErr.Class != "TypeError" {
+		t.Fatalf("Counter.new(nil) error = %v", err)
+	}
+}
+
+func TestBoundFunctionPanicsRaiseRubyExceptions(t *testing.T) {
+	interp := newTestInterpreter(t)
+	if err := interp.DefineFunction("explode", func(reason string) string {
+		panic(reason)
+	}); err != nil {
+		t.Fatalf("DefineFunction: %v", err)
+	}
+
+	value, err := interp.Eval(`
+begin
+  explode("boom")
+rescue => e
+  "#{e.class}: #{e.message}"
+end`, "panic.rb")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if got, want := interp.ToGo(value), "RuntimeError: explode panicked: boom"; got != want {
+		t.Fatalf("rescued %q, want %q", got, want)
+	}
+
+	_, err = interp.Eval(`explode("again")`, "panic.rb")
+	var rubyErr *Error
+	if !errors.As(err, &rubyErr) || rubyErr.Class != "RuntimeError" || !strings.Contains(rubyErr.Message, "explode panicked: again") {
+		t.Fatalf("Eval error = %#v, want a RuntimeError", err)
+	}
+}
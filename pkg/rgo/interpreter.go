@@ -0,0 +1,315 @@
+// Package rgo embeds the RGo Ruby runtime in Go programs.
+//
+// Each Interpreter owns a complete Ruby world: its own class hierarchy,
+// constants, globals, loaded features and main object. The runtime keeps that
+// world in package-level state, so an Interpreter swaps its snapshot in on
+// every entry and out again on return; calls into different interpreters are
+// serialized by a process-wide lock. A single Interpreter must not be used
+// from several goroutines at once, but Go code invoked from Ruby may call back
+// into the interpreter that invoked it.
+package rgo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/vm"
+)
+
+// Value is a Ruby object owned by an Interpreter. Values must only be passed
+// back to the interpreter that produced them.
+type Value = *object.EmeraldValue
+
+// runtimeMu guards the package-level runtime state shared by every
+// interpreter in the process.
+var runtimeMu sync.Mutex
+
+// Interpreter is an isolated Ruby runtime.
+type Interpreter struct {
+	machine *vm.VM
+	state   *core.RuntimeState
+	depth   int
+	closed  bool
+	// ctx is the context of the outermost EvalContext or CallContext in
+	// progress; bound Go functions receive it.
+	ctx context.Context
+	// hostClasses maps Go types attached by DefineConstructor or Wrap to
+	// the Ruby class that carries them.
+	hostClasses map[reflect.Type]*object.EmeraldValue
+}
+
+// Option configures an Interpreter created by New.
+type Option func(*options)
+
+type options struct {
+	sandbox *core.SandboxPolicy
+}
+
+// SandboxPolicy lists the capabilities and resource limits of a sandboxed
+// interpreter.
+type SandboxPolicy = core.SandboxPolicy
+
+// SandboxLimitError is returned when sandboxed code exceeds its memory or
+// wall-clock budget.
+type SandboxLimitError = core.SandboxLimitError
+
+// WithSandbox runs all of the interpreter's Ruby code under policy. Denied
+// operations raise SecurityError inside Ruby. A call that exceeds
+// policy.Timeout or policy.MemoryLimit is aborted and returns a
+// *SandboxLimitError that Ruby code cannot rescue; the timeout applies to
+// each Eval or Call separately.
+func WithSandbox(policy SandboxPolicy) Option {
+	return func(o *options) { o.sandbox = &policy }
+}
+
+// New creates an interpreter with a freshly initialized core library. The
+// runtime state that was current before the call, if any, is restored before
+// New returns.
+func New(opts ...Option) (*Interpreter, error) {
+	var config options
+	for _, opt := range opts {
+		opt(&config)
+	}
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+
+	previous := core.CaptureRuntimeState()
+	previousVM := vm.CurrentVM
+	defer func() {
+		previous.Restore()
+		if previousVM != nil {
+			previousVM.Activate()
+		}
+	}()
+
+	core.Init()
+	if config.sandbox != nil {
+		core.EnableSandbox(*config.sandbox)
+	}
+	core.CurrentSpecFile = "-"
+	core.CurrentSpecFileAbsolute = ""
+	program := parser.New(lexer.New("")).ParseProgram()
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return nil, fmt.Errorf("rgo: bootstrap compile: %w", err)
+	}
+	machine := vm.New(c.Bytecode())
+	if err := machine.Run(); err != nil {
+		return nil, fmt.Errorf("rgo: bootstrap run: %w", err)
+	}
+	return &Interpreter{machine: machine, state: core.CaptureRuntimeState()}, nil
+}
+
+// enter installs the interpreter's runtime state and returns the function
+// that saves it again and restores whatever was current before. Nested entry
+// from a Go callback running inside this interpreter does not re-lock.
+func (i *Interpreter) enter() func() {
+	if i.depth > 0 {
+		i.depth++
+		return func() { i.depth-- }
+	}
+	runtimeMu.Lock()
+	previous := core.CaptureRuntimeState()
+	previousVM := vm.CurrentVM
+	i.state.Restore()
+	i.machine.Activate()
+	i.depth = 1
+	return func() {
+		i.depth = 0
+		i.state = core.CaptureRuntimeState()
+		previous.Restore()
+		if previousVM != nil {
+			previousVM.Activate()
+		}
+		runtimeMu.Unlock()
+	}
+}
+
+// context returns the context Go functions called from Ruby receive.
+func (i *Interpreter) context() context.Context {
+	if i.ctx == nil {
+		return context.Background()
+	}
+	return i.ctx
+}
+
+// withContext makes ctx current for the outermost call, which also starts
+// a new sandbox budget; nested calls keep the context they were entered
+// with.
+func (i *Interpreter) withContext(ctx context.Context) func() {
+	if i.ctx != nil {
+		return func() {}
+	}
+	i.ctx = ctx
+	core.StartSandboxClock()
+	return func() { i.ctx = nil }
+}
+
+// Eval runs source as a top-level script named filename and returns the
+// value of its last expression. Methods, classes, constants and globals it
+// defines remain visible to later calls; local variables do not, matching
+// Kernel#load. A parse failure is reported as a SyntaxError *Error.
+func (i *Interpreter) Eval(source, filename string) (Value, error) {
+	return i.EvalContext(context.Background(), source, filename)
+}
+
+// EvalContext is like Eval but passes ctx to the Go functions the script
+// calls.
+func (i *Interpreter) EvalContext(ctx context.Context, source, filename string) (Value, error) {
+	if i.closed {
+		return nil, errClosed
+	}
+	defer i.enter()()
+	defer i.withContext(ctx)()
+	if filename == "" {
+		filename = "(eval)"
+	}
+	binding := &object.RBinding{
+		RBindingExpanded: &object.RBindingExpanded{Locals: map[string]*object.EmeraldValue{}, InstanceVars: map[string]*object.EmeraldValue{}},
+		Self:             core.R.Main,
+		Path:             filename,
+		Line:             1,
+	}
+	previousEncoding := core.CurrentEvalSourceEncoding
+	core.CurrentEvalSourceEncoding = core.SourceEncoding(source)
+	defer func() { core.CurrentEvalSourceEncoding = previousEncoding }()
+	return i.result(core.LastException, core.EvalSourceWithBinding(source, binding))
+}
+
+// Call invokes method on receiver with args converted by ToRuby. The
+// receiver may be a Value or any Go value ToRuby accepts.
+func (i *Interpreter) Call(receiver any, method string, args ...any) (Value, error) {
+	return i.CallContext(context.Background(), receiver, method, args...)
+}
+
+// CallContext is like Call but passes ctx to the Go functions the method
+// calls.
+func (i *Interpreter) CallContext(ctx context.Context, receiver any, method string, args ...any) (Value, error) {
+	if i.closed {
+		return nil, errClosed
+	}
+	defer i.enter()()
+	defer i.withContext(ctx)()
+	target, err := i.toRuby(receiver)
+	if err != nil {
+		return nil, err
+	}
+	rubyArgs := make([]*object.EmeraldValue, len(args))
+	for index, arg := range args {
+		if rubyArgs[index], err = i.toRuby(arg); err != nil {
+			return nil, fmt.Errorf("rgo: argument %d: %w", index+1, err)
+		}
+	}
+	return i.result(core.LastException, core.CallMethod(target, method, rubyArgs...))
+}
+
+// Main returns the interpreter's top-level self, or nil once the
+// interpreter is closed.
+func (i *Interpreter) Main() Value {
+	if i.closed {
+		return nil
+	}
+	defer i.enter()()
+	return core.R.Main
+}
+
+// Constant resolves a constant path such as "JSON" or "Net::HTTP" from the
+// top level.
+func (i *Interpreter) Constant(path string) (Value, error) {
+	if i.closed {
+		return nil, errClosed
+	}
+	defer i.enter()()
+	name := strings.TrimPrefix(path, "::")
+	if core.GetConstantName != nil {
+		if value := core.GetConstantName(name); value != nil {
+			return value, nil
+		}
+	}
+	return nil, &Error{Class: "NameError", Message: "uninitialized constant " + name}
+}
+
+// Close runs the interpreter's at_exit handlers. The interpreter cannot be
+// used afterwards.
+func (i *Interpreter) Close() error {
+	if i.closed {
+		return nil
+	}
+	release := i.enter()
+	previousException := core.LastException
+	core.RunAtExitHooks()
+	_, err := i.result(previousException, core.LastException)
+	release()
+	i.closed = true
+	return err
+}
+
+// result turns a raised Ruby exception into an *Error and clears it from the
+// interpreter so the next call starts clean.
+func (i *Interpreter) result(previousException, value *object.EmeraldValue) (Value, error) {
+	if err := core.SandboxLimitExceeded(); err != nil {
+		core.LastException = nil
+		core.LastRaisedResult = nil
+		return nil, err
+	}
+	exception := value
+	if exception == nil || exception.Type != object.ValueException {
+		exception = nil
+		if core.LastException != nil && core.LastException != previousException && raised(core.LastException) {
+			exception = core.LastException
+		}
+	}
+	if exception == nil {
+		if value == nil {
+			value = core.R.NilVal
+		}
+		return value, nil
+	}
+	core.LastException = nil
+	core.LastRaisedResult = nil
+	return nil, newError(exception)
+}
+
+func raised(exception *object.EmeraldValue) bool {
+	data, ok := exception.Data.(*object.RException)
+	return exception.Type == object.ValueException && ok && data != nil && data.Raised
+}
+
+var errClosed = fmt.Errorf("rgo: interpreter is closed")
+
+// Error is a Ruby exception that escaped to Go.
+type Error struct {
+	Class     string
+	Message   string
+	Backtrace []string
+	// Exception is the Ruby exception object, or nil when the error was
+	// produced on the Go side of the boundary.
+	Exception Value
+}
+
+func newError(exception *object.EmeraldValue) *Error {
+	err := &Error{Class: "Exception", Exception: exception}
+	if exception.Class != nil && exception.Class.Name != "" {
+		err.Class = exception.Class.Name
+	}
+	if data, ok := exception.Data.(*object.RException); ok && data != nil {
+		err.Message = data.Message
+		err.Backtrace = append([]string(nil), data.Backtrace...)
+	}
+	return err
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Class
+	}
+	return e.Message + " (" + e.Class + ")"
+}
@@ -0,0 +1,132 @@
+package rgo
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestInterpreter(t *testing.T) *Interpreter {
+	t.Helper()
+	interp, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = interp.Close() })
+	return interp
+}
+
+func TestEvalAndCallConvertValues(t *testing.T) {
+	interp := newTestInterpreter(t)
+
+	value, err := interp.Eval("def add(a, b) = a + b\n[1, 2.5, 'three', :four, nil, {a: [true]}]", "values.rb")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	want := []any{int64(1), 2.5, "three", Symbol("four"), nil, map[any]any{Symbol("a"): []any{true}}}
+	if got := interp.ToGo(value); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ToGo = %#v, want %#v", got, want)
+	}
+
+	sum, err := interp.Call(interp.Main(), "add", 40, 2)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if got := interp.ToGo(sum); got != int64(42) {
+		t.Fatalf("add(40, 2) = %#v, want 42", got)
+	}
+
+	joined, err := interp.Call([]string{"a", "b"}, "join", "-")
+	if err != nil {
+		t.Fatalf("Call join: %v", err)
+	}
+	if got := interp.ToGo(joined); got != "a-b" {
+		t.Fatalf("join = %#v, want \"a-b\"", got)
+	}
+
+	huge := new(big.Int).Lsh(big.NewInt(1), 80)
+	doubled, err := interp.Call(huge, "*", 2)
+	if err != nil {
+		t.Fatalf("Call *: %v", err)
+	}
+	if got, ok := interp.ToGo(doubled).(*big.Int); !ok || got.Cmp(new(big.Int).Lsh(huge, 1)) != 0 {
+		t.Fatalf("2**80 * 2 = %#v", interp.ToGo(doubled))
+	}
+}
+
+func TestInterpretersAreIsolated(t *testing.T) {
+	first := newTestInterpreter(t)
+	second := newTestInterpreter(t)
+
+	if _, err := first.Eval("class Widget; def self.name_tag = 'first'; end\n$shared = 1", "first.rb"); err != nil {
+		t.Fatalf("first Eval: %v", err)
+	}
+	if _, err := first.Constant("Widget"); err != nil {
+		t.Fatalf("first Constant: %v", err)
+	}
+	if _, err := second.Constant("Widget"); err == nil {
+		t.Fatalf("Widget leaked into the second interpreter")
+	}
+	value, err := second.Eval("$shared", "second.rb")
+	if err != nil {
+		t.Fatalf("second Eval: %v", err)
+	}
+	if got := second.ToGo(value); got != nil {
+		t.Fatalf("$shared in second interpreter = %#v, want nil", got)
+	}
+	tag, err := first.Eval("Widget.name_tag", "first.rb")
+	if err != nil {
+		t.Fatalf("first Eval after second: %v", err)
+	}
+	if got := first.ToGo(tag); got != "first" {
+		t.Fatalf("Widget.name_tag = %#v, want \"first\"", got)
+	}
+}
+
+func TestRubyExceptionsBecomeErrors(t *testing.T) {
+	interp := newTestInterpreter(t)
+
+	_, err := interp.Eval("raise ArgumentError, 'bad input'", "raise.rb")
+	var rubyErr *Error
+	if !errors.As(err, &rubyErr) {
+		t.Fatalf("Eval error = %#v, want *Error", err)
+	}
+	if rubyErr.Class != "ArgumentError" || rubyErr.Message != "bad input" {
+		t.Fatalf("error = %s/%q", rubyErr.Class, rubyErr.Message)
+	}
+
+	_, err = interp.Eval("def broken(\n", "syntax.rb")
+	if !errors.As(err, &rubyErr) || rubyErr.Class != "SyntaxError" {
+		t.Fatalf("syntax error = %#v, want SyntaxError", err)
+	}
+
+	value, err := interp.Eval("1 + 1", "after.rb")
+	if err != nil || interp.ToGo(value) != int64(2) {
+		t.Fatalf("Eval after errors = %#v, %v", value, err)
+	}
+
+	if _, err := interp.ToRuby(make(chan int)); err == nil || !strings.Contains(err.Error(), "chan int") {
+		t.Fatalf("ToRuby(chan) error = %v", err)
+	}
+}
+
+func TestClosedInterpreterRefusesUse(t *testing.T) {
+	interp, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := interp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if main := interp.Main(); main != nil {
+		t.Fatalf("Main after Close = %#v, want nil", main)
+	}
+	if _, err := interp.Eval("1", "closed.rb"); err != errClosed {
+		t.Fatalf("Eval after Close error = %v", err)
+	}
+	if _, err := interp.Call(interp.Main(), "inspect"); err != errClosed {
+		t.Fatalf("Call after Close error = %v", err)
+	}
+}
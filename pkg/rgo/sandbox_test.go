@@ -0,0 +1,210 @@
+package rgo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSandboxDeniesCapabilities(t *testing.T) {
+	interp, err := New(WithSandbox(SandboxPolicy{AllowEnv: true}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer interp.Close()
+
+	for _, source := range []string{
+		"File.read('/etc/hostname')",
+		"Dir.glob('*')",
+		"IO.popen('true')",
+		"`true`",
+		"system('true')",
+		"Process.spawn('true')",
+		"require 'socket'",
+		"require './missing_helper'",
+		"load 'missing_helper.rb'",
+		"STDIN.reopen('/etc/hostname')",
+		"ARGV.replace(['/etc/hostname']); ARGF.read",
+		"ARGV.replace(['/etc/hostname']); gets",
+		"ARGV.replace(['/etc/hostname']); readline",
+		"ARGV.replace(['/etc/hostname']); readlines",
+		"Signal.trap('TERM') { }",
+		"RubyVM::AbstractSyntaxTree.parse_file('/etc/hostname')",
+		"require 'coverage'; Coverage.line_stub('/etc/hostname')",
+	} {
+		_, err := interp.Eval(source, "sandbox.rb")
+		var rubyErr *Error
+		if !errors.As(err, &rubyErr) || rubyErr.Class != "SecurityError" || !strings.Contains(rubyErr.Message, "not allowed in the sandbox") {
+			t.Errorf("%s: error = %v, want SecurityError", source, err)
+		}
+	}
+
+	value, err := interp.Eval(`
+denied = begin
+  open('/etc/hostname')
+rescue SecurityError => e
+  e.message
+end
+require 'json'
+[denied, JSON.generate([File.basename('a/b.rb')]), ENV.key?('PATH')]`, "allowed.rb")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	got := interp.ToGo(value).([]any)
+	if got[0] != "Kernel#open is not allowed in the sandbox (file system access is disabled)" || got[1] != `["b.rb"]` || got[2] != true {
+		t.Fatalf("result = %#v", got)
+	}
+}
+
+func TestSandboxReopenKeepsFile(t *testing.T) {
+	interp, err := New(WithSandbox(SandboxPolicy{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer interp.Close()
+
+	path := filepath.Join(t.TempDir(), "keep.txt")
+	if err := os.WriteFile(path, []byte("keep\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = interp.Eval(fmt.Sprintf("$stderr.reopen(%q, 'w')", path), "reopen.rb")
+	var rubyErr *Error
+	if !errors.As(err, &rubyErr) || rubyErr.Class != "SecurityError" {
+		t.Fatalf("error = %v, want SecurityError", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep\n" {
+		t.Fatalf("file = %q after a denied reopen", data)
+	}
+	if _, err := interp.Eval("$stdout.reopen($stderr)", "streams.rb"); err != nil {
+		t.Fatalf("reopen onto a stream: %v", err)
+	}
+}
+
+func TestSandboxProfilersDoNotWriteFiles(t *testing.T) {
+	interp, err := New(WithSandbox(SandboxPolicy{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer interp.Close()
+
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"sp.dump": "require 'stackprof'; StackProf.run(out: %q) { 1 }",
+		"sp.json": "require 'stackprof'; StackProf.run(out: %q) { 1 }",
+		"mp.txt":  "require 'memory_profiler'; MemoryProfiler.report { 1 }.pretty_print(to_file: %q)",
+	} {
+		path := filepath.Join(dir, name)
+		_, err := interp.Eval(fmt.Sprintf(source, path), "profile.rb")
+		var rubyErr *Error
+		if !errors.As(err, &rubyErr) || rubyErr.Class != "SecurityError" {
+			t.Errorf("%s: error = %v, want SecurityError", name, err)
+		}
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("%s was written in the sandbox", name)
+		}
+	}
+}
+
+func TestSandboxIrbKeepsHistoryInMemory(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history")
+	t.Setenv("RGO_IRB_HISTORY", history)
+	interp, err := New(WithSandbox(SandboxPolicy{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer interp.Close()
+
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+	in, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.WriteString("1 + 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	os.Stdin, os.Stdout = in, out
+
+	if _, err := interp.Eval("binding.irb", "irb.rb"); err != nil {
+		t.Fatalf("binding.irb: %v", err)
+	}
+	if _, err := os.Stat(history); err == nil {
+		t.Fatal("sandboxed irb wrote its history file")
+	}
+}
+
+func TestSandboxEnvAndOtherInterpreters(t *testing.T) {
+	sandboxed, err := New(WithSandbox(SandboxPolicy{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer sandboxed.Close()
+	open := newTestInterpreter(t)
+
+	var rubyErr *Error
+	if _, err := sandboxed.Eval("ENV['PATH']", "env.rb"); !errors.As(err, &rubyErr) || rubyErr.Class != "SecurityError" {
+		t.Fatalf("ENV in sandbox: %v", err)
+	}
+	value, err := open.Eval("ENV.key?('PATH')", "env.rb")
+	if err != nil || value == nil || open.ToGo(value) != true {
+		t.Fatalf("ENV outside sandbox = %v, %v", value, err)
+	}
+}
+
+func TestSandboxTimeoutCannotBeRescued(t *testing.T) {
+	interp, err := New(WithSandbox(SandboxPolicy{Timeout: 100 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer interp.Close()
+
+	start := time.Now()
+	_, err = interp.Eval("begin\n  loop { }\nrescue Exception\n  retry\nend", "spin.rb")
+	var limitErr *SandboxLimitError
+	if !errors.As(err, &limitErr) || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("error = %v, want a sandbox timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("timeout took %v", elapsed)
+	}
+
+	value, err := interp.Eval("6 * 7", "after.rb")
+	if err != nil || interp.ToGo(value) != int64(42) {
+		t.Fatalf("Eval after timeout = %v, %v", value, err)
+	}
+}
+
+func TestSandboxLimitsEscapeBlocksAndRescue(t *testing.T) {
+	for _, tt := range []struct {
+		policy SandboxPolicy
+		source string
+		limit  string
+	}{
+		{SandboxPolicy{Timeout: 100 * time.Millisecond}, "begin\n  [1].each { loop { } }\nrescue Exception => e\nend\n:rescued", "timeout"},
+		{SandboxPolicy{MemoryLimit: 64 << 20}, "kept = []\nbegin\n  300.times { kept << 'x' * 1_000_000 }\nrescue Exception\nend\n:rescued", "memory"},
+	} {
+		interp, err := New(WithSandbox(tt.policy))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		value, err := interp.Eval(tt.source, "limit.rb")
+		var limitErr *SandboxLimitError
+		if !errors.As(err, &limitErr) || !strings.Contains(err.Error(), tt.limit) {
+			t.Errorf("%s limit: result = %v, error = %v, want a sandbox limit error", tt.limit, value, err)
+		}
+		interp.Close()
+	}
+}
@@ -0,0 +1,211 @@
+package rgo
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// Symbol is the Go representation of a Ruby Symbol.
+type Symbol string
+
+// ToRuby converts a Go value into a Ruby object owned by i:
+//
+//	nil                      -> nil
+//	bool                     -> true / false
+//	signed/unsigned integers -> Integer (Bignum past int64)
+//	*big.Int                 -> Integer
+//	float32, float64         -> Float
+//	string, []byte           -> String
+//	Symbol                   -> Symbol
+//	slices and arrays        -> Array
+//	maps                     -> Hash
+//	Value                    -> unchanged
+//
+// Values of a Go type bound with Module.DefineConstructor or Module.Wrap
+// become new instances of that class.
+func (i *Interpreter) ToRuby(value any) (Value, error) {
+	defer i.enter()()
+	return i.toRuby(value)
+}
+
+// ToGo converts a Ruby object into plain Go data, the inverse of ToRuby:
+// Integer becomes int64 or *big.Int, Float float64, String string, Symbol
+// Symbol, Array []any and Hash map[any]any. Other objects are returned as
+// the Value itself.
+func (i *Interpreter) ToGo(value Value) any {
+	defer i.enter()()
+	return i.toGo(value, map[*object.EmeraldValue]bool{})
+}
+
+func (i *Interpreter) toRuby(value any) (*object.EmeraldValue, error) {
+	switch v := value.(type) {
+	case nil:
+		return core.R.NilVal, nil
+	case *object.EmeraldValue:
+		if v == nil {
+			return core.R.NilVal, nil
+		}
+		return v, nil
+	case bool:
+		if v {
+			return core.R.TrueVal, nil
+		}
+		return core.R.FalseVal, nil
+	case string:
+		return core.NewStringValue(v), nil
+	case []byte:
+		return core.NewStringValue(string(v)), nil
+	case Symbol:
+		return symbolValue(string(v)), nil
+	case *big.Int:
+		return core.NewIntegerFromBigInt(v), nil
+	case int:
+		return core.NewIntegerValue(int64(v)), nil
+	case int8:
+		return core.NewIntegerValue(int64(v)), nil
+	case int16:
+		return core.NewIntegerValue(int64(v)), nil
+	case int32:
+		return core.NewIntegerValue(int64(v)), nil
+	case int64:
+		return core.NewIntegerValue(v), nil
+	case uint:
+		return unsignedInteger(uint64(v)), nil
+	case uint8:
+		return core.NewIntegerValue(int64(v)), nil
+	case uint16:
+		return core.NewIntegerValue(int64(v)), nil
+	case uint32:
+		return core.NewIntegerValue(int64(v)), nil
+	case uint64:
+		return unsignedInteger(v), nil
+	case float32:
+		return core.NewFloatValue(float64(v)), nil
+	case float64:
+		return core.NewFloatValue(v), nil
+	}
+
+	if class, ok := i.hostClasses[reflect.TypeOf(value)]; ok {
+		return i.wrap(class, value), nil
+	}
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Slice, reflect.Array:
+		elements := make([]*object.EmeraldValue, reflected.Len())
+		for index := range elements {
+			element, err := i.toRuby(reflected.Index(index).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[index] = element
+		}
+		return &object.EmeraldValue{Type: object.ValueArray, Data: elements, Class: core.R.Classes["Array"]}, nil
+	case reflect.Map:
+		hash := core.CallMethod(classValue("Hash"), "new")
+		iterator := reflected.MapRange()
+		for iterator.Next() {
+			key, err := i.toRuby(iterator.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+			element, err := i.toRuby(iterator.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			core.CallMethod(hash, "[]=", key, element)
+		}
+		return hash, nil
+	case reflect.Pointer:
+		if reflected.IsNil() {
+			return core.R.NilVal, nil
+		}
+	}
+	return nil, fmt.Errorf("rgo: cannot convert %T to a Ruby value", value)
+}
+
+func unsignedInteger(value uint64) *object.EmeraldValue {
+	if value <= math.MaxInt64 {
+		return core.NewIntegerValue(int64(value))
+	}
+	return core.NewIntegerFromBigInt(new(big.Int).SetUint64(value))
+}
+
+func classValue(name string) *object.EmeraldValue {
+	return &object.EmeraldValue{Type: object.ValueClass, Data: core.R.Classes[name], Class: core.R.Classes["Class"]}
+}
+
+func (i *Interpreter) toGo(value *object.EmeraldValue, seen map[*object.EmeraldValue]bool) any {
+	if value == nil {
+		return nil
+	}
+	switch value.Type {
+	case object.ValueNil:
+		return nil
+	case object.ValueBool:
+		return value.IsTruthy()
+	case object.ValueInteger:
+		if bignum := value.BigIntValue(); bignum != nil {
+			return new(big.Int).Set(bignum)
+		}
+		if integer, ok := value.Data.(int64); ok {
+			return integer
+		}
+	case object.ValueFloat:
+		if float, ok := value.Data.(float64); ok {
+			return float
+		}
+	case object.ValueString:
+		if text, ok := value.Data.(string); ok {
+			return text
+		}
+	case object.ValueSymbol:
+		if name, ok := value.Data.(string); ok {
+			return Symbol(name)
+		}
+	case object.ValueArray:
+		if seen[value] {
+			return value
+		}
+		seen[value] = true
+		defer delete(seen, value)
+		elements, ok := value.MaterializeLazyArray()
+		if !ok {
+			elements, _ = value.Data.([]*object.EmeraldValue)
+		}
+		result := make([]any, len(elements))
+		for index, element := range elements {
+			result[index] = i.toGo(element, seen)
+		}
+		return result
+	case object.ValueHash:
+		if seen[value] {
+			return value
+		}
+		seen[value] = true
+		defer delete(seen, value)
+		pairs := core.CallMethod(value, "to_a")
+		result := map[any]any{}
+		if pairs == nil || pairs.Type != object.ValueArray {
+			return result
+		}
+		entries, _ := pairs.Data.([]*object.EmeraldValue)
+		for _, entry := range entries {
+			pair, _ := entry.Data.([]*object.EmeraldValue)
+			if len(pair) != 2 {
+				continue
+			}
+			key := i.toGo(pair[0], seen)
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				key = pair[0]
+			}
+			result[key] = i.toGo(pair[1], seen)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,205 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/lexer"
+	"github.com/GoLangDream/rgo/pkg/object"
+	"github.com/GoLangDream/rgo/pkg/parser"
+	"github.com/GoLangDream/rgo/pkg/parser/ast"
+)
+
+// Link compiles the script entry together with every file it loads with
+// require_relative, directly or through another linked file, into one
+// bundle. Only requires of a plain string literal naming an existing file
+// are followed; other requires are left to run time, where they resolve
+// against the file system as usual. Each require_relative that is left
+// behind is reported in warnings as "file:line: message".
+func Link(entry string) (bundle *compiler.Bundle, warnings []string, err error) {
+	absolute, err := filepath.Abs(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	root := filepath.Dir(absolute)
+	bundle = compiler.NewBundle(root)
+
+	previousSpecFile, previousSpecFileAbsolute := core.CurrentSpecFile, core.CurrentSpecFileAbsolute
+	previousEncoding, previousEvalSource := core.CurrentEvalSourceEncoding, core.CurrentEvalSource
+	defer func() {
+		core.CurrentSpecFile, core.CurrentSpecFileAbsolute = previousSpecFile, previousSpecFileAbsolute
+		core.CurrentEvalSourceEncoding, core.CurrentEvalSource = previousEncoding, previousEvalSource
+	}()
+	core.CurrentEvalSourceEncoding, core.CurrentEvalSource = "", false
+
+	queue := []string{absolute}
+	queued := map[string]bool{absolute: true}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		relative, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The compiler records the path of the file it compiles for
+		// __FILE__, __dir__ and backtraces; Relocate moves it later.
+		core.CurrentSpecFile, core.CurrentSpecFileAbsolute = path, path
+		file, program, err := compileBundleFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		file.Path = filepath.ToSlash(relative)
+		bundle.Add(file)
+		required, skipped := staticRequireRelatives(program, filepath.Dir(path))
+		for _, required := range required {
+			if !queued[required] {
+				queued[required] = true
+				queue = append(queue, required)
+			}
+		}
+		shown := filepath.Join(filepath.Dir(entry), relative)
+		for _, skip := range skipped {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: %s", shown, skip.line, skip.reason))
+		}
+	}
+	return bundle, warnings, nil
+}
+
+// skippedRequire is a require_relative that Link leaves to run time.
+type skippedRequire struct {
+	line   int
+	reason string
+}
+
+// compileBundleFile compiles the file at path the way require would.
+func compileBundleFile(path string) (*compiler.BundleFile, *ast.Program, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	source := strings.TrimPrefix(string(content), "\ufeff")
+	beginBlocks, remaining, syntaxErr := splitTopLevelBeginBlocks(source)
+	if syntaxErr != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, exceptionMessage(syntaxErr))
+	}
+	source = remaining
+	if len(beginBlocks) > 0 {
+		source = prependBeginBlocks(beginBlocks, remaining)
+	}
+	if message := sourceSyntaxError(source); message != "" {
+		return nil, nil, fmt.Errorf("%s: %s", path, message)
+	}
+
+	encoding := core.SourceEncoding(source)
+	p := parser.New(lexer.NewWithEncoding(source, encoding))
+	p.SetFile(path)
+	program := p.ParseProgram()
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		return nil, nil, fmt.Errorf("%s", diagnostics[0].Report(1))
+	}
+	if message := validateDynamicSyntax(program); message != "" {
+		return nil, nil, fmt.Errorf("%s: %s", path, message)
+	}
+	c := compiler.NewWithSourceEncoding(encoding)
+	if err := c.Compile(program); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	frozen, chilled := evalSourceStringLiteralMode(source)
+	return &compiler.BundleFile{
+		Encoding:             encoding,
+		FreezeStringLiterals: frozen,
+		ChillStringLiterals:  chilled,
+		Bytecode:             c.Bytecode(),
+	}, program, nil
+}
+
+// staticRequireRelatives returns the existing files that program loads with
+// require_relative "literal", resolved against dir, and the require_relative
+// calls it cannot follow: those with a computed argument or naming a file
+// that does not exist.
+func staticRequireRelatives(program *ast.Program, dir string) ([]string, []skippedRequire) {
+	var paths []string
+	var skipped []skippedRequire
+	ast.Inspect(program, func(node ast.Node) bool {
+		call, ok := node.(*ast.MethodCall)
+		if !ok || call.Receiver != nil || call.Method == nil || call.Method.Value != "require_relative" || len(call.Args) != 1 {
+			return true
+		}
+		literal, ok := call.Args[0].(*ast.StringLiteral)
+		if !ok || literal.Command || strings.Contains(literal.Value, "#{") {
+			skipped = append(skipped, skippedRequire{call.Method.Token.Line, "require_relative with a computed path is not linked; it is loaded from the file system at run time"})
+			return true
+		}
+		path := filepath.FromSlash(literal.Value)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if filepath.Ext(path) != ".rb" {
+			path += ".rb"
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			paths = append(paths, filepath.Clean(path))
+		} else {
+			skipped = append(skipped, skippedRequire{call.Method.Token.Line, fmt.Sprintf("require_relative %q names no file; it is not linked", literal.Value)})
+		}
+		return true
+	})
+	return paths, skipped
+}
+
+func exceptionMessage(exc *object.EmeraldValue) string {
+	if exception, ok := exc.Data.(*object.RException); ok && exception != nil {
+		return exception.Message
+	}
+	return exc.Inspect()
+}
+
+// LoadBundle reads a bundle written by `rgo link` and moves it next to
+// path, so that its entry script runs as path and the other files are
+// found where require_relative looks for them.
+func LoadBundle(path string) (*compiler.Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := compiler.UnmarshalBundle(data)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	bundle.Relocate(root, path)
+	return bundle, nil
+}
+
+// SetBundle makes require and require_relative load the files of bundle,
+// other than its entry script, from their bytecode instead of the file
+// system. VMs that run required files share the parent's bundle.
+func (vm *VM) SetBundle(bundle *compiler.Bundle) {
+	vm.bundled = make(map[string]*compiler.BundleFile, len(bundle.Files))
+	for _, file := range bundle.Files[1:] {
+		vm.bundled[bundle.AbsolutePath(file)] = file
+	}
+}
+
+// bundledPath returns the bundled file that require would load for path,
+// or "".
+func (vm *VM) bundledPath(path string) string {
+	if len(vm.bundled) == 0 || !filepath.IsAbs(path) {
+		return ""
+	}
+	path = filepath.Clean(path)
+	if vm.bundled[path] != nil {
+		return path
+	}
+	if filepath.Ext(path) != ".rb" && vm.bundled[path+".rb"] != nil {
+		return path + ".rb"
+	}
+	return ""
+}
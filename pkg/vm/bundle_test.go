@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+)
+
+func TestLinkedBundleRunsWithoutItsSources(t *testing.T) {
+	source := t.TempDir()
+	files := map[string]string{
+		"app.rb": `require_relative "lib/greeting"
+require_relative "lib/greeting.rb"
+puts Greeting.hello(File.basename(__FILE__))
+puts __FILE__ == $0
+begin
+  Greeting.fail!
+rescue => e
+  puts e.backtrace.first.sub(Dir.pwd, ".")
+end
+`,
+		"lib/greeting.rb": `require_relative "../shared/names"
+module Greeting
+  def self.hello(name) = "hello #{name} from #{Names::DIR}"
+  def self.fail! = raise("failed")
+end
+`,
+		"shared/names.rb": "module Names\n  DIR = File.basename(__dir__)\nend\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle, warnings, err := Link(filepath.Join(source, "app.rb"))
+	if err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("Link warnings %q", warnings)
+	}
+	var paths []string
+	for _, file := range bundle.Files {
+		paths = append(paths, file.Path)
+	}
+	if got := strings.Join(paths, " "); got != "app.rb lib/greeting.rb shared/names.rb" {
+		t.Fatalf("linked files %v", paths)
+	}
+	data, err := compiler.MarshalBundle(bundle)
+	if err != nil {
+		t.Fatalf("MarshalBundle: %v", err)
+	}
+	if err := os.RemoveAll(source); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	previousDir, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previousDir)
+	if err := os.WriteFile("app.rgob", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBundle("app.rgob")
+	if err != nil {
+		t.Fatalf("LoadBundle: %v", err)
+	}
+
+	previousSpecFile := core.CurrentSpecFile
+	core.CurrentSpecFile = "app.rgob"
+	defer func() { core.CurrentSpecFile = previousSpecFile }()
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	v := New(loaded.Files[0].Bytecode)
+	v.SetBundle(loaded)
+	v.SetProgramName("app.rgob")
+	err = v.Run()
+	w.Close()
+	os.Stdout = oldStdout
+	var out bytes.Buffer
+	io.Copy(&out, r)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := "hello app.rgob from shared\ntrue\n./lib/greeting.rb:4:in 'Greeting.fail!'\n"
+	if out.String() != want {
+		t.Fatalf("output %q, want %q", out.String(), want)
+	}
+}
+
+func TestLinkWarnsAboutRequiresItCannotFollow(t *testing.T) {
+	source := t.TempDir()
+	app := filepath.Join(source, "app.rb")
+	content := "name = \"plugin\"\nrequire_relative name\nrequire_relative \"lib/#{name}\"\nrequire_relative \"missing\"\n"
+	if err := os.WriteFile(app, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bundle, warnings, err := Link(app)
+	if err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	if len(bundle.Files) != 1 {
+		t.Fatalf("linked %d files, want only the entry script", len(bundle.Files))
+	}
+	want := []string{
+		app + ":2: require_relative with a computed path is not linked",
+		app + ":3: require_relative with a computed path is not linked",
+		app + `:4: require_relative "missing" names no file`,
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings %q, want %d", warnings, len(want))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(warnings[i], prefix) {
+			t.Errorf("warning %q, want prefix %q", warnings[i], prefix)
+		}
+	}
+}
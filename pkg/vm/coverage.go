@@ -0,0 +1,20 @@
+package vm
+
+import (
+	"github.com/GoLangDream/rgo/pkg/compiler"
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// coverMethodDefined tells the Coverage module the owner of a method whose
+// body was compiled with a method counter, which the compiler places first.
+func coverMethodDefined(fn *object.Function, owner *object.EmeraldValue) {
+	if fn == nil || owner == nil || len(fn.Instructions) < 5 || compiler.Opcode(fn.Instructions[0]) != compiler.OpCoverage {
+		return
+	}
+	id := 0
+	for _, b := range fn.Instructions[1:5] {
+		id = id<<8 | int(b)
+	}
+	core.CoverMethodDefined(id, owner)
+}
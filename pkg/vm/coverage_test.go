@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCoverageCountsLinesBranchesAndMethods(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "measured.rb")
+	source := `class Measured
+  def sign(x)
+    if x > 0
+      :pos
+    else
+      :neg
+    end
+  end
+
+  def unused
+    nil
+  end
+end
+
+def count(n)
+  i = 0
+  while i < n
+    i += 1
+  end
+  i
+end
+`
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, out := runRuby(t, fmt.Sprintf(`
+require "coverage"
+Coverage.start(lines: true, branches: true, methods: true)
+require %q
+m = Measured.new
+m.sign(1); m.sign(2); m.sign(-1)
+count(3)
+data = Coverage.result[%q]
+p data[:lines]
+data[:branches].each { |structure, targets| p [structure, targets] }
+data[:methods].each { |method, count| p [method, count] }
+p Coverage.running?
+`, path, path))
+	want := `[1, 1, 3, 2, nil, 1, nil, nil, nil, 1, 0, nil, nil, nil, 1, 1, 1, 3, nil, 1, nil]
+[[:if, 0, 3, 4, 7, 7], {[:then, 1, 4, 6, 4, 10] => 2, [:else, 2, 6, 6, 6, 10] => 1}]
+[[:while, 3, 17, 2, 19, 5], {[:body, 4, 18, 4, 18, 10] => 3}]
+[[Measured, :sign, 2, 2, 8, 5], 3]
+[[Measured, :unused, 10, 2, 12, 5], 0]
+[[Object, :count, 15, 0, 21, 3], 1]
+false
+`
+	if out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}
+
+func TestCoverageOneshotLinesAndEval(t *testing.T) {
+	_, out := runRuby(t, `
+require "coverage"
+Coverage.start(oneshot_lines: true, eval: true)
+source = "x = 1\nif x\n  y = 2\nend\n"
+eval(source, binding, "template.erb", 10)
+p Coverage.result(stop: false, clear: true)["template.erb"]
+eval(source, binding, "template.erb", 10)
+p Coverage.result["template.erb"]
+p Coverage.state
+`)
+	want := "{oneshot_lines: [10, 11, 12]}\n{oneshot_lines: []}\n:idle\n"
+	if out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}
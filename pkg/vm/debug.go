@@ -0,0 +1,238 @@
+package vm
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/debugger"
+	"github.com/GoLangDream/rgo/pkg/object"
+)
+
+// debugLocation describes the line frame is about to run to the debugger.
+// The depth counts the frames of the VMs that required this one's file too.
+func (vm *VM) debugLocation(frame *Frame, line int64) debugger.Location {
+	depth := 0
+	for current := vm; current != nil; current = current.parent {
+		depth += current.fp + 1
+	}
+	return debugger.Location{File: rubyFramePath(frame), Line: int(line), Depth: depth, Frame: frame.ID}
+}
+
+// debugBreak stops the program on d where binding.break or Kernel#debugger
+// was called.
+func (vm *VM) debugBreak(d *debugger.Debugger) {
+	if vm.fp < 0 || vm.fp >= len(vm.frames) {
+		return
+	}
+	frame := vm.frames[vm.fp]
+	if frame == nil || frame.Fn == nil {
+		return
+	}
+	d.Break(vm.debugLocation(frame, vm.sourceLineForFrame(frame)), vm.debugProgram())
+}
+
+func (vm *VM) debugProgram() debugger.Program {
+	return &debugProgram{vm: vm}
+}
+
+// debugProgram is the program as the debugger sees it while paused. Its
+// frames continue into the VMs that required this one's file, and values
+// with children are numbered for Children as they are shown.
+type debugProgram struct {
+	vm       *VM
+	frames   []debugFrame
+	listed   bool
+	bindings map[int]*object.RBinding
+	values   []*object.EmeraldValue
+}
+
+type debugFrame struct {
+	owner *VM
+	frame *Frame
+	debugger.Frame
+}
+
+func (p *debugProgram) stack() []debugFrame {
+	if p.listed {
+		return p.frames
+	}
+	p.listed = true
+	for current := p.vm; current != nil; current = current.parent {
+		for i := current.fp; i >= 0; i-- {
+			frame := current.frames[i]
+			if frame == nil || frame.Fn == nil {
+				continue
+			}
+			p.frames = append(p.frames, debugFrame{owner: current, frame: frame, Frame: debugger.Frame{
+				Name: current.rubyFrameLabel(i),
+				File: rubyFramePath(frame),
+				Line: int(current.sourceLineForFrame(frame)),
+			}})
+		}
+	}
+	return p.frames
+}
+
+func (p *debugProgram) Frames() []debugger.Frame {
+	stack := p.stack()
+	frames := make([]debugger.Frame, len(stack))
+	for i, frame := range stack {
+		frames[i] = frame.Frame
+	}
+	return frames
+}
+
+func (p *debugProgram) binding(index int) *object.RBinding {
+	stack := p.stack()
+	if index < 0 || index >= len(stack) {
+		return nil
+	}
+	if binding := p.bindings[index]; binding != nil {
+		return binding
+	}
+	if p.bindings == nil {
+		p.bindings = make(map[int]*object.RBinding)
+	}
+	owner, frame := stack[index].owner, stack[index].frame
+	owner.syncCapturedLocals(frame)
+	binding := owner.captureBindingForFrame(frame)
+	p.bindings[index] = binding
+	return binding
+}
+
+// syncCapturedLocals copies frame's locals from the stack into the bindings
+// already captured for it and into the snapshot new ones start from. An
+// evaluation at an earlier stop expands its binding into a table of its own,
+// after which that snapshot no longer follows assignments.
+func (vm *VM) syncCapturedLocals(frame *Frame) {
+	if frame == nil || frame.Fn == nil {
+		return
+	}
+	for _, index := range frame.Fn.LocalNames {
+		slot := frame.Bp + 1 + index
+		if slot < 0 || slot >= vm.sp || vm.stack[slot] == nil {
+			continue
+		}
+		value := derefClosureValue(vm.stack[slot])
+		if index < len(frame.CapturedLocalValues) {
+			frame.CapturedLocalValues[index] = value
+		}
+		vm.updateCapturedBindingLocal(frame, index, value)
+	}
+}
+
+func (p *debugProgram) Locals(frame int) []debugger.Variable {
+	binding := p.binding(frame)
+	if binding == nil {
+		return nil
+	}
+	names, values := core.BindingLocals(binding)
+	locals := make([]debugger.Variable, len(names))
+	for i, name := range names {
+		locals[i] = p.variable(name, values[i])
+	}
+	return locals
+}
+
+// Children lists the elements of an Array, the pairs of a Hash or the
+// instance variables of any other object.
+func (p *debugProgram) Children(reference int) []debugger.Variable {
+	if reference < 1 || reference > len(p.values) {
+		return nil
+	}
+	value := p.values[reference-1]
+	var children []debugger.Variable
+	switch data := value.Data.(type) {
+	case []*object.EmeraldValue:
+		for i, element := range data {
+			children = append(children, p.variable("["+strconv.Itoa(i)+"]", element))
+		}
+	case *object.RHash:
+		for _, key := range data.Keys {
+			children = append(children, p.variable(debugInspect(key), data.Pairs[key]))
+		}
+	default:
+		for _, name := range debugInstanceVariables(value) {
+			children = append(children, p.variable(name.Data.(string), core.CallMethod(value, "instance_variable_get", name)))
+		}
+	}
+	return children
+}
+
+func (p *debugProgram) Evaluate(frame int, source string) (debugger.Variable, bool, error) {
+	binding := p.binding(frame)
+	if binding == nil {
+		return debugger.Variable{}, false, errors.New("no frame " + strconv.Itoa(frame))
+	}
+	previous := core.LastException
+	// Binding#eval rather than evalSourceWithBinding, so a block frame also
+	// sees the locals of the frames it closes over.
+	receiver := &object.EmeraldValue{Type: object.ValueBinding, Data: binding, Class: core.R.Classes["Binding"]}
+	result := core.CallMethod(receiver, "eval", core.NewStringValue(source))
+	if result == nil {
+		result = core.R.NilVal
+	}
+	if result.Type != object.ValueException && core.LastException != nil && core.LastException != previous {
+		if data, ok := core.LastException.Data.(*object.RException); ok && data != nil && data.Raised {
+			result = core.LastException
+		}
+	}
+	if result.Type == object.ValueException {
+		core.LastException = previous
+		return debugger.Variable{}, false, errors.New(debugExceptionMessage(result))
+	}
+	return p.variable("", result), result.IsTruthy(), nil
+}
+
+func (p *debugProgram) variable(name string, value *object.EmeraldValue) debugger.Variable {
+	if value == nil {
+		value = core.R.NilVal
+	}
+	variable := debugger.Variable{Name: name, Value: debugInspect(value)}
+	if value.Class != nil {
+		variable.Type = value.Class.Name
+	}
+	hasChildren := false
+	switch data := value.Data.(type) {
+	case []*object.EmeraldValue:
+		hasChildren = value.Type == object.ValueArray && len(data) > 0
+	case *object.RHash:
+		hasChildren = value.Type == object.ValueHash && len(data.Keys) > 0
+	default:
+		hasChildren = value.Type == object.ValueObject && len(debugInstanceVariables(value)) > 0
+	}
+	if hasChildren {
+		p.values = append(p.values, value)
+		variable.Reference = len(p.values)
+	}
+	return variable
+}
+
+func debugInspect(value *object.EmeraldValue) string {
+	if inspected := core.CallMethod(value, "inspect"); inspected != nil && inspected.Type == object.ValueString {
+		return inspected.Data.(string)
+	}
+	return value.Inspect()
+}
+
+func debugInstanceVariables(value *object.EmeraldValue) []*object.EmeraldValue {
+	list := core.CallMethod(value, "instance_variables")
+	if list == nil {
+		return nil
+	}
+	names, _ := list.Data.([]*object.EmeraldValue)
+	return names
+}
+
+func debugExceptionMessage(exception *object.EmeraldValue) string {
+	className := "Exception"
+	if exception.Class != nil && exception.Class.Name != "" {
+		className = exception.Class.Name
+	}
+	message := className
+	if data, ok := exception.Data.(*object.RException); ok && data != nil && data.Message != "" {
+		message = data.Message
+	}
+	return message + " (" + className + ")"
+}
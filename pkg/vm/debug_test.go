@@ -0,0 +1,96 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/debugger"
+)
+
+const debugScript = `
+def total(items)
+  sum = 0
+  items.each do |i|
+    sum += i
+  end
+  sum
+end
+p total([1, 2, 3])
+`
+
+// debugSession runs debugScript under a console reading commands and
+// returns what the console and the program printed.
+func debugSession(t *testing.T, commands string) (string, string) {
+	t.Helper()
+	var console strings.Builder
+	d := debugger.New(debugger.NewConsole(strings.NewReader(commands), &console))
+	d.StopOnEntry()
+	core.StartDebugger(d)
+	t.Cleanup(core.StopDebugger)
+	specFile := core.CurrentSpecFile
+	core.CurrentSpecFile = "debug.rb"
+	t.Cleanup(func() { core.CurrentSpecFile = specFile })
+	_, out := runRuby(t, debugScript)
+	return console.String(), out
+}
+
+func TestDebuggerStopsAtConditionalBreakpoint(t *testing.T) {
+	console, out := debugSession(t, "break 5 if i == 2\ncontinue\ninfo\nbacktrace\nsum = 10\ncontinue\n")
+	for _, want := range []string{
+		"(entry)",
+		"(breakpoint #1)",
+		"(rdbg) info\ni = 2\nitems = [1, 2, 3]\nsum = 1\n",
+		"=>#0\tblock in total at ",
+		"  #1\tObject#total at ",
+		"  #2\t<main> at ",
+	} {
+		if !strings.Contains(console, want) {
+			t.Errorf("console missing %q:\n%s", want, console)
+		}
+	}
+	if out != "15\n" {
+		t.Fatalf("output %q, want the assignment made while stopped to stick", out)
+	}
+}
+
+func TestDebuggerStepsOverBlockIterations(t *testing.T) {
+	console, _ := debugSession(t, "break total\ncontinue\nnext\nnext\nnext\n")
+	var lines []string
+	for _, line := range strings.Split(console, "\n") {
+		if strings.HasPrefix(line, "Stopped at ") {
+			lines = append(lines, line[strings.LastIndex(line, ":")+1:])
+		}
+	}
+	want := []string{"2 (entry)", "3 (breakpoint #1)", "4 (step)", "7 (step)"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Fatalf("stops %q, want %q", lines, want)
+	}
+}
+
+func TestBindingEvalAssignsCapturedLocal(t *testing.T) {
+	_, out := runRuby(t, `
+def f
+  s = 1
+  [1, 2].each { |i| binding.eval("s = 10") if i == 1; s += i }
+  s
+end
+p f
+`)
+	if out != "13\n" {
+		t.Fatalf("output %q, want %q", out, "13\n")
+	}
+}
+
+func TestDebuggerSeesLocalsAssignedAfterEvaluation(t *testing.T) {
+	console, _ := debugSession(t, "break 3\ncontinue\np items.size\nnext\np sum\ninfo\ncontinue\n")
+	for _, want := range []string{
+		"(rdbg) p items.size\n=> 3\n",
+		"(rdbg) p sum\n=> 0\n",
+		"(rdbg) info\nitems = [1, 2, 3]\nsum = 0\n",
+	} {
+		if !strings.Contains(console, want) {
+			t.Errorf("console missing %q:\n%s", want, console)
+		}
+	}
+}
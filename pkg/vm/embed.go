@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// embedded holds the sources of a packaged application; see EmbedFiles.
+var embedded struct {
+	root  string
+	files fs.FS
+}
+
+// EmbedFiles makes require, require_relative and the load path find the
+// files of fsys as if they were under root, ahead of the file system. A
+// packaged executable uses its own path as root: no file on disk can be
+// under it, so the embedded files shadow nothing. Only require and
+// require_relative read from fsys; load, File and Dir still see the disk.
+func EmbedFiles(root string, fsys fs.FS) {
+	embedded.root, embedded.files = filepath.Clean(root), fsys
+}
+
+// embeddedName returns the name in the embedded files of the absolute path,
+// or "" when the path is not an embedded file.
+func embeddedName(path string) string {
+	if embedded.files == nil || !strings.HasPrefix(path, embedded.root+string(filepath.Separator)) {
+		return ""
+	}
+	name := filepath.ToSlash(path[len(embedded.root)+1:])
+	if !fs.ValidPath(name) {
+		return ""
+	}
+	if info, err := fs.Stat(embedded.files, name); err != nil || info.IsDir() {
+		return ""
+	}
+	return name
+}
+
+// readRequireFile reads the source of a file resolved by
+// resolveRequirePath.
+func readRequireFile(path string) ([]byte, error) {
+	if name := embeddedName(path); name != "" {
+		return fs.ReadFile(embedded.files, name)
+	}
+	return os.ReadFile(path)
+}
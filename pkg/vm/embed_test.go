@@ -0,0 +1,36 @@
+package vm
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRequireFindsEmbeddedFilesFirst(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "app")
+	EmbedFiles(root, fstest.MapFS{
+		"app/main.rb":       {Data: []byte(`require_relative "lib/helper"` + "\n")},
+		"app/lib/helper.rb": {Data: []byte(`HELPER = File.basename(__FILE__)` + "\n")},
+		"lib/0/tool.rb":     {Data: []byte(`TOOL = :embedded` + "\n")},
+	})
+	defer EmbedFiles("", nil)
+
+	_, out := runRuby(t, fmt.Sprintf(`
+$LOAD_PATH.unshift %q
+require "tool"
+p TOOL
+p require(%q)
+p HELPER
+p require("tool")
+begin
+  require "helper"
+rescue LoadError => e
+  puts e.message
+end
+`, filepath.Join(root, "lib", "0"), filepath.Join(root, "app", "main")))
+	want := ":embedded\ntrue\n\"helper.rb\"\nfalse\ncannot load such file -- helper\n"
+	if out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}
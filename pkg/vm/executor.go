@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"math"
 	"math/big"
@@ -11,6 +12,7 @@ import (
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,6 +21,7 @@ import (
 
 	"github.com/GoLangDream/rgo/pkg/compiler"
 	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/irb"
 	"github.com/GoLangDream/rgo/pkg/lexer"
 	"github.com/GoLangDream/rgo/pkg/object"
 	"github.com/GoLangDream/rgo/pkg/parser"
@@ -203,6 +206,10 @@ type Frame struct {
 	RetryRescue            *ActiveRescue
 	bytecodeSendCacheTable []*registerIRSendCache
 	integerLoopDisabled    map[int]bool
+	// registerIRResumable lets a framed Register IR plan hand this Frame to
+	// the interpreter mid-way; registerIRResumed records that it did.
+	registerIRResumable bool
+	registerIRResumed   bool
 }
 
 type RescueHandler struct {
@@ -423,6 +430,7 @@ var instructionExceptionSnapshotNotNeeded = [256]bool{
 	compiler.OpExitForEach:       true,
 	compiler.OpSetWhileEnd:       true,
 	compiler.OpPatternCacheClear: true,
+	compiler.OpCoverage:          true,
 	compiler.OpGetMatchCapture:   true,
 	compiler.OpBlockGiven:        true,
 	compiler.OpDefinedYield:      true,
@@ -816,6 +824,7 @@ type VM struct {
 	frozenStringCache    map[string]*object.EmeraldValue
 	frozenLiteralCache   map[*object.EmeraldValue]*object.EmeraldValue
 	requireLoadPathCache *requireLoadPathCache
+	bundled              map[string]*compiler.BundleFile // see SetBundle
 	threadCoroutines     map[*object.EmeraldValue]*threadCoroutine
 	fiberCoroutines      map[*object.EmeraldValue]*fiberCoroutine
 	fiberStackPool       [][]*object.EmeraldValue
@@ -929,10 +938,24 @@ func New(bytecode *compiler.Bytecode) *VM {
 	return newVM(bytecode, nil)
 }
 
+// sandboxPollMask sets how often execute checks the sandbox memory and
+// wall-clock budgets, in instructions.
+const sandboxPollMask = 1<<10 - 1
+
 // SetInstructionLimit bounds the number of bytecode instructions executed by
 // one Run call. A zero limit, the production default, means unlimited.
 func (vm *VM) SetInstructionLimit(limit uint64) {
-	vm.instructionLimit = limit
+	vm.instructionLimit = sandboxInstructionLimit(limit)
+}
+
+// sandboxInstructionLimit keeps a sandboxed VM with memory or wall-clock
+// budgets on the checked dispatch path, where execute polls them, by
+// replacing an unlimited instruction count with an unreachable one.
+func sandboxInstructionLimit(limit uint64) uint64 {
+	if limit == 0 && core.SandboxLimited() {
+		return math.MaxUint64
+	}
+	return limit
 }
 
 func (vm *VM) SetFreezeStringLiterals(enabled bool) {
@@ -1080,6 +1103,7 @@ func newVM(bytecode *compiler.Bytecode, parent *VM) *VM {
 	} else if os.Getenv("RGO_PROFILE_RUBY_METHODS") != "" {
 		vm.rubyMethodProfile = &rubyMethodProfile{counts: make(map[*object.Function]uint64)}
 	}
+	vm.instructionLimit = sandboxInstructionLimit(vm.instructionLimit)
 	if parent == nil {
 		core.InitializeRuntimeModules()
 		vm.rubyConsts["ARGF"] = core.NewArgfValue(nil)
@@ -1106,6 +1130,7 @@ func newVM(bytecode *compiler.Bytecode, parent *VM) *VM {
 		vm.frozenStringCache = parent.frozenStringCache
 		vm.frozenLiteralCache = parent.frozenLiteralCache
 		vm.requireLoadPathCache = parent.requireLoadPathCache
+		vm.bundled = parent.bundled
 	} else {
 		vm.SetTopLevelConstant("ARGF", vm.rubyConsts["ARGF"])
 		argv := &object.EmeraldValue{Type: object.ValueArray, Data: []*object.EmeraldValue{}, Class: core.R.Classes["Array"]}
@@ -1148,6 +1173,13 @@ func (vm *VM) SetTopLevelConstant(name string, value *object.EmeraldValue) {
 	}
 }
 
+// Activate makes vm the target of every core callback. Embedders call it
+// after restoring the runtime state the VM was created under, since core
+// dispatches through package-level hooks rather than an explicit VM.
+func (vm *VM) Activate() {
+	vm.installCoreHooks()
+}
+
 func (vm *VM) SetProgramName(name string) {
 	vm.setGlobalByName("$0", &object.EmeraldValue{Type: object.ValueString, Data: name, Class: core.R.Classes["String"]})
 }
@@ -2387,6 +2419,7 @@ func (vm *VM) installCoreHooks() {
 	}
 	core.CurrentFrameBinding = vm.currentFrameBinding
 	core.CaptureFrameBinding = vm.captureFrameBinding
+	core.DebugBreak = vm.debugBreak
 	core.SetCapturedBindingLocal = vm.setCapturedBindingLocal
 	core.CurrentFrameID = func() int {
 		return vm.currentFrameID()
@@ -2460,6 +2493,13 @@ func (vm *VM) installCoreHooks() {
 		return vm.callBlock(block, args...)
 	}
 	core.TracePointActivated = func() (int, string, int64) {
+		// The frames waiting on a call are part way through a statement whose
+		// line has already begun, so returning to them is not a new line.
+		for index := 0; index < vm.fp && index < len(vm.frames); index++ {
+			if caller := vm.frames[index]; caller != nil && caller.Fn != nil {
+				caller.TraceLine = vm.sourceLineForFrame(caller)
+			}
+		}
 		if vm.fp >= 0 && vm.fp < len(vm.frames) {
 			frame := vm.frames[vm.fp]
 			if frame != nil {
@@ -2578,9 +2618,15 @@ func (vm *VM) installCoreHooks() {
 	core.EvalSource = func(source string) *object.EmeraldValue {
 		return vm.evalSource(source)
 	}
+	core.EvalFileSource = func(source string) *object.EmeraldValue {
+		return vm.evalFileSource(source)
+	}
 	core.EvalSourceWithBinding = func(source string, binding *object.RBinding) *object.EmeraldValue {
 		return vm.evalSourceWithBinding(source, binding)
 	}
+	core.InteractiveSession = func(binding *object.RBinding) *object.EmeraldValue {
+		return irb.NewSession(binding, os.Stdin, os.Stdout).Run()
+	}
 	core.RequirePath = func(path string) (string, *object.EmeraldValue) {
 		return vm.requirePath(path)
 	}
@@ -3288,52 +3334,39 @@ func (vm *VM) endActiveRescuesForFrame(frame *Frame) {
 	}
 }
 
-func (vm *VM) evalSource(source string) *object.EmeraldValue {
-	beginBlocks, remaining, syntaxErr := splitTopLevelBeginBlocks(source)
-	if syntaxErr != nil {
-		core.LastException = syntaxErr
-		return syntaxErr
-	}
-	if len(beginBlocks) > 0 {
-		source = prependBeginBlocks(beginBlocks, remaining)
-	} else {
-		source = remaining
-	}
+// sourceSyntaxError runs the syntax checks the parser does not cover on
+// source, returning the SyntaxError message of the first that fails or "".
+func sourceSyntaxError(source string) string {
 	if invalidPercentRegexpSyntax(source) {
-		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), "invalid percent regexp")
-		core.LastException = exc
-		return exc
+		return "invalid percent regexp"
 	}
 	if message := invalidIndexAssignmentSyntax(source); message != "" {
-		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), message)
-		core.LastException = exc
-		return exc
+		return message
 	}
 	maskedSource := maskRubyStringLiterals(source)
 	if message := invalidNumberedParameterSyntaxMasked(maskedSource); message != "" {
-		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), message)
-		core.LastException = exc
-		return exc
+		return message
 	}
 	if message := invalidPatternMatchingSyntaxMasked(source, maskedSource); message != "" {
-		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), message)
-		core.LastException = exc
-		return exc
+		return message
 	}
 	if message := invalidSpacedMethodCallArgumentListSyntaxMasked(maskedSource); message != "" {
-		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), message)
-		core.LastException = exc
-		return exc
+		return message
 	}
 	if message := invalidRescueSyntaxMasked(maskedSource); message != "" {
-		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), message)
-		core.LastException = exc
-		return exc
+		return message
 	}
-	if message := invalidReadOnlyMatchGlobalAssignmentSyntaxMasked(source, maskedSource); message != "" {
+	return invalidReadOnlyMatchGlobalAssignmentSyntaxMasked(source, maskedSource)
+}
+
+// compileEvalSource runs the dynamic syntax checks that the parser does not
+// cover and compiles source. A failure is returned as a SyntaxError value;
+// parse errors in a required file are reported against the file itself.
+func (vm *VM) compileEvalSource(source string, file bool) (*compiler.Compiler, *object.EmeraldValue) {
+	if message := sourceSyntaxError(source); message != "" {
 		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), message)
 		core.LastException = exc
-		return exc
+		return nil, exc
 	}
 
 	lexerEncoding := core.CurrentEvalSourceEncoding
@@ -3344,31 +3377,92 @@ func (vm *VM) evalSource(source string) *object.EmeraldValue {
 	p := parser.New(l)
 	program := p.ParseProgram()
 	if len(p.Errors()) > 0 {
-		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), evalSyntaxErrorMessage(p.Errors()))
+		var exc *object.EmeraldValue
+		if file {
+			exc = newParseSyntaxError(p, core.CurrentSpecFile, 1)
+		} else {
+			path, line := syntaxErrorLocation(vm.currentFrameBinding())
+			exc = newParseSyntaxError(p, path, line)
+		}
 		core.LastException = exc
-		return exc
+		return nil, exc
 	}
 	if message := validateDynamicSyntax(program); message != "" {
 		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), message)
 		core.LastException = exc
-		return exc
+		return nil, exc
 	}
 
-	childEncoding := core.SourceEncoding(source)
-	c := compiler.NewWithSourceEncoding(childEncoding)
+	c := compiler.NewWithSourceEncoding(core.SourceEncoding(source))
+	if file {
+		c.SetCoverage(core.NewCoverageSource(core.CurrentSpecFile, source, program, 1, false))
+	}
 	if err := c.Compile(program); err != nil {
 		if os.Getenv("RGO_DEBUG_REQUIRE") == "1" {
 			fmt.Printf("RGO_DEBUG_REQUIRE eval compile error=%v\n", err)
 		}
 		exc := newSyntaxErrorForBinding(vm.currentFrameBinding(), err.Error())
 		core.LastException = exc
-		return exc
+		return nil, exc
+	}
+	return c, nil
+}
+
+func (vm *VM) evalSource(source string) *object.EmeraldValue {
+	return vm.evalSourceCached(source, "", false)
+}
+
+// evalFileSource evaluates the contents of a required or loaded file. Unless
+// the bytecode cache is disabled, an unchanged file reuses the bytecode
+// stored in its .rgoc entry and skips lexing, parsing and compilation.
+func (vm *VM) evalFileSource(source string) *object.EmeraldValue {
+	return vm.evalSourceCached(source, compiler.BytecodeCacheDir(), true)
+}
+
+func (vm *VM) evalSourceCached(source, cacheDir string, file bool) *object.EmeraldValue {
+	beginBlocks, remaining, syntaxErr := splitTopLevelBeginBlocks(source)
+	if syntaxErr != nil {
+		core.LastException = syntaxErr
+		return syntaxErr
+	}
+	if len(beginBlocks) > 0 {
+		source = prependBeginBlocks(beginBlocks, remaining)
+	} else {
+		source = remaining
+	}
+
+	var bytecode *compiler.Bytecode
+	var cachePath string
+	var digest [sha256.Size]byte
+	if cacheDir != "" && core.CurrentSpecFile != "" && !core.CoverageActive() {
+		if absolute, err := filepath.Abs(core.CurrentSpecFileAbsolute); err == nil {
+			cachePath = absolute
+			digest = compiler.SourceDigest(source, core.CurrentSpecFile, core.CurrentSpecFileAbsolute, strconv.FormatBool(core.CurrentEvalSource), core.CurrentEvalSourceEncoding)
+			bytecode, _ = compiler.LoadCachedBytecode(cacheDir, cachePath, digest)
+		}
+	}
+	if bytecode == nil {
+		c, exc := vm.compileEvalSource(source, file)
+		if exc != nil {
+			return exc
+		}
+		bytecode = c.Bytecode()
+		if cachePath != "" && !c.Warned() {
+			if err := compiler.StoreCachedBytecode(cacheDir, cachePath, digest, bytecode); err != nil && os.Getenv("RGO_DEBUG_REQUIRE") == "1" {
+				fmt.Printf("RGO_DEBUG_REQUIRE bytecode cache error=%v\n", err)
+			}
+		}
 	}
 	core.FireTracePointScriptCompiled(vm.currentFrameBinding(), source)
 
-	parent := CurrentVM
 	frozenStrings, chilledStrings := evalSourceStringLiteralMode(source)
-	bytecode := c.Bytecode()
+	return vm.runFileBytecode(bytecode, core.SourceEncoding(source), frozenStrings, chilledStrings)
+}
+
+// runFileBytecode runs the bytecode of an evaluated or required file in a
+// child VM and returns its value, or the exception it ended with.
+func (vm *VM) runFileBytecode(bytecode *compiler.Bytecode, childEncoding string, frozenStrings, chilledStrings bool) *object.EmeraldValue {
+	parent := CurrentVM
 	annotateStringLiteralMode(bytecode.Constants, frozenStrings, chilledStrings)
 	child := newVM(bytecode, vm)
 	child.freezeStringLiterals, child.chillStringLiterals = frozenStrings, chilledStrings
@@ -3402,7 +3496,7 @@ func (vm *VM) evalSource(source string) *object.EmeraldValue {
 		if parent != nil {
 			parent.installCoreHooks()
 		}
-		exc := core.NewRuntimeError(runErr.Error())
+		exc := core.ExecutionErrorResult(runErr)
 		core.LastException = exc
 		return exc
 	}
@@ -3518,7 +3612,8 @@ func (vm *VM) evalSourceWithBinding(source string, binding *object.RBinding) *ob
 	}
 	program := p.ParseProgram()
 	if len(p.Errors()) > 0 {
-		exc := newSyntaxErrorForBinding(binding, evalSyntaxErrorMessage(p.Errors()))
+		path, line := syntaxErrorLocation(binding)
+		exc := newParseSyntaxError(p, path, line)
 		core.LastException = exc
 		return exc
 	}
@@ -3535,6 +3630,9 @@ func (vm *VM) evalSourceWithBinding(source string, binding *object.RBinding) *ob
 		c = compiler.New()
 	}
 	c.SetEvalTopLevelReturn(true)
+	if binding != nil && binding.Path != "" && !strings.HasPrefix(binding.Path, "(eval") {
+		c.SetCoverage(core.NewCoverageSource(binding.Path, source, program, int(binding.Line), true))
+	}
 	if err := c.Compile(program); err != nil {
 		exc := newSyntaxErrorForBinding(binding, err.Error())
 		core.LastException = exc
@@ -5414,6 +5512,13 @@ func newSyntaxError(message string) *object.EmeraldValue {
 }
 
 func newSyntaxErrorForBinding(binding *object.RBinding, message string) *object.EmeraldValue {
+	path, line := syntaxErrorLocation(binding)
+	return newSyntaxError(fmt.Sprintf("%s:%d: %s", path, line, message))
+}
+
+// syntaxErrorLocation returns the file and line that code evaluated in
+// binding is reported at.
+func syntaxErrorLocation(binding *object.RBinding) (string, int64) {
 	path := ""
 	line := int64(1)
 	if binding != nil {
@@ -5426,12 +5531,19 @@ func newSyntaxErrorForBinding(binding *object.RBinding, message string) *object.
 	if line == 0 {
 		line = 1
 	}
-	return newSyntaxError(fmt.Sprintf("%s:%d: %s", path, line, message))
+	return path, line
 }
 
-func evalSyntaxErrorMessage(errors []string) string {
-	message := strings.Join(errors, "\n")
-	return strings.ReplaceAll(message, "expected end, got EOF", "unexpected end-of-input")
+// newParseSyntaxError raises the parser's first diagnostic as a SyntaxError
+// whose message carries the offending line and a caret, like MRI's.
+func newParseSyntaxError(p *parser.Parser, path string, line int64) *object.EmeraldValue {
+	diagnostic := p.Diagnostics()[0]
+	diagnostic.File = path
+	exc := newSyntaxError(diagnostic.Report(int(line)))
+	if exception, ok := exc.Data.(*object.RException); ok {
+		exception.Path = path
+	}
+	return exc
 }
 
 func bindingLocalSlots(vm *VM) map[string]int {
@@ -5544,7 +5656,19 @@ func copyBindingLocals(vm *VM, binding *object.RBinding, slots map[string]int) {
 		if vm.stack[slot] == nil {
 			continue
 		}
-		binding.Locals[name] = vm.stack[slot]
+		// A local a block closes over is held in a cell; assigning through
+		// it keeps the frame and its blocks seeing the new value.
+		if current := binding.Locals[name]; current != nil {
+			if _, cell := current.Data.(*closureCell); cell {
+				if value := vm.stack[slot]; value != current {
+					setClosureValue(&current, object.DereferenceBindingValue(value))
+				}
+			} else {
+				binding.Locals[name] = vm.stack[slot]
+			}
+		} else {
+			binding.Locals[name] = vm.stack[slot]
+		}
 		if _, ok := existing[name]; !ok {
 			binding.LocalNames = append(binding.LocalNames, name)
 			existing[name] = struct{}{}
@@ -5588,6 +5712,9 @@ func (vm *VM) resolveRequirePath(path string) string {
 	if path == "" {
 		return ""
 	}
+	if bundled := vm.bundledPath(path); bundled != "" {
+		return bundled
+	}
 	currentDir, _ := os.Getwd()
 
 	requestPath := filepath.FromSlash(strings.ReplaceAll(path, "\\", "/"))
@@ -5645,6 +5772,9 @@ func (vm *VM) resolveRequirePath(path string) string {
 			loadPaths := vm.canonicalRequireLoadPaths(entries, currentDir)
 			if cache := vm.requireLoadPathCache; cache != nil && cache.valid {
 				if candidate := cache.resolved[requestPath]; candidate != "" {
+					if embeddedName(candidate) != "" {
+						return candidate
+					}
 					if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
 						return candidate
 					}
@@ -5657,14 +5787,17 @@ func (vm *VM) resolveRequirePath(path string) string {
 		}
 	}
 	for _, candidate := range candidates {
-		if info, err := os.Stat(candidate); err != nil || info.IsDir() {
-			continue
-		}
-		file, err := os.Open(candidate)
-		if err != nil {
-			continue
+		// Embedded files come first; see EmbedFiles.
+		if embeddedName(candidate) == "" {
+			if info, err := os.Stat(candidate); err != nil || info.IsDir() {
+				continue
+			}
+			file, err := os.Open(candidate)
+			if err != nil {
+				continue
+			}
+			_ = file.Close()
 		}
-		_ = file.Close()
 		if !isAbs && !isExplicitRelative && !isDotOrDotDot {
 			if cache := vm.requireLoadPathCache; cache != nil && cache.valid {
 				cache.resolved[requestPath] = candidate
@@ -5812,7 +5945,10 @@ func (vm *VM) requirePath(path string) (string, *object.EmeraldValue) {
 		core.CurrentSpecFile = previousSpecFile
 		core.CurrentSpecFileAbsolute = previousSpecFileAbsolute
 	}()
-	content, err := os.ReadFile(candidate)
+	if file := vm.bundled[candidate]; file != nil {
+		return candidate, vm.runFileBytecode(file.Bytecode, file.Encoding, file.FreezeStringLiterals, file.ChillStringLiterals)
+	}
+	content, err := readRequireFile(candidate)
 	if err != nil {
 		return "", &object.EmeraldValue{
 			Type:  object.ValueException,
@@ -5820,7 +5956,7 @@ func (vm *VM) requirePath(path string) (string, *object.EmeraldValue) {
 			Class: core.R.Classes["LoadError"],
 		}
 	}
-	result := vm.evalSource(string(content))
+	result := vm.evalFileSource(string(content))
 	if result != nil && result.Type == object.ValueException && result.Class == core.R.Classes["SyntaxError"] {
 		if exception, ok := result.Data.(*object.RException); ok {
 			exception.Path = candidate
@@ -6261,6 +6397,11 @@ func (vm *VM) execute(op compiler.Opcode, frame *Frame) error {
 		}
 		return fmt.Errorf("instruction limit exceeded at %s:%d in %s (ip=%d, op=%v)", path, vm.sourceLineForFrame(frame), method, frame.Ip, op)
 	}
+	if vm.instructionCount&sandboxPollMask == 0 && core.Sandbox != nil {
+		if err := core.CheckSandboxLimits(); err != nil {
+			return err
+		}
+	}
 	constants := vm.frameConstants(frame)
 	switch op {
 	case compiler.OpConstant:
@@ -6306,6 +6447,9 @@ func (vm *VM) execute(op compiler.Opcode, frame *Frame) error {
 		}
 		vm.push(boolValue(matched))
 
+	case compiler.OpCoverage:
+		core.CoverCounter(vm.readUint32())
+
 	case compiler.OpPatternCacheClear:
 		if vm.readUint8() == 0 {
 			vm.patternArrayCache = nil
@@ -8048,6 +8192,7 @@ func (vm *VM) execute(op compiler.Opcode, frame *Frame) error {
 				delete(mainObj.SingletonMethods, "test")
 			}
 		}
+		coverMethodDefined(closure.Fn, method.Owner)
 
 		vm.push(&object.EmeraldValue{Type: object.ValueSymbol, Data: name, Class: core.R.Classes["Symbol"]})
 
@@ -8119,6 +8264,7 @@ func (vm *VM) execute(op compiler.Opcode, frame *Frame) error {
 			}
 		}
 
+		coverMethodDefined(closure.Fn, core.SingletonClass(receiver))
 		if errVal := core.NotifySingletonMethodAdded(receiver, name); errVal != nil && errVal.Type == object.ValueException {
 			if vm.raiseException(frame, errVal) {
 				return nil
@@ -12240,10 +12386,10 @@ func (vm *VM) executeCachedFixedArityRubyBytecodeMode(receiver *object.EmeraldVa
 			err = vm.execute(op, frame)
 		}
 		if err != nil {
-			if core.LastException != nil && core.LastException.Type == object.ValueException {
+			if core.LastException != nil && core.LastException.Type == object.ValueException && core.SandboxLimitExceeded() == nil {
 				result = core.LastException
 			} else {
-				result = core.NewRuntimeError(err.Error())
+				result = core.ExecutionErrorResult(err)
 			}
 			cleanup()
 			return result, true
@@ -12752,10 +12898,10 @@ func (vm *VM) invokeMethod(receiver *object.EmeraldValue, parentMethod, method s
 			if err != nil {
 				vm.currentBlock = prevBlock
 				vm.classStack = prevClassStack
-				if core.LastException != nil && core.LastException.Type == object.ValueException {
+				if core.LastException != nil && core.LastException.Type == object.ValueException && core.SandboxLimitExceeded() == nil {
 					return core.LastException
 				}
-				return core.NewRuntimeError(err.Error())
+				return core.ExecutionErrorResult(err)
 			}
 			if (vm.pendingReturnTargetID > 0 && vm.handlePendingNonLocalReturn(frame)) ||
 				(vm.pendingBreakTargetID > 0 && vm.handlePendingNonLocalBreak(frame)) || frame.Returned {
@@ -20353,7 +20499,10 @@ func (vm *VM) raiseException(frame *Frame, exception *object.EmeraldValue) bool
 		core.LastException = exception
 		vm.attachExceptionLocations(exception)
 		markExceptionRaised(exception)
-		core.FireTracePointException("raise", vm.currentFrameBinding(), exception)
+		if core.TracePointEventActive("raise") {
+			methodID, definedClass := vm.traceMethodContext(frame)
+			core.FireTracePointException("raise", vm.currentFrameBinding(), methodID, definedClass, exception)
+		}
 	}
 	if len(vm.activeRescues) > 0 {
 		active := vm.activeRescues[len(vm.activeRescues)-1]
@@ -20415,7 +20564,10 @@ func (vm *VM) raiseException(frame *Frame, exception *object.EmeraldValue) bool
 	}
 	vm.rescueStack = vm.rescueStack[:len(vm.rescueStack)-1]
 	if handler.RescueOffset > 0 {
-		core.FireTracePointException("rescue", vm.currentFrameBinding(), exception)
+		if core.TracePointEventActive("rescue") {
+			methodID, definedClass := vm.traceMethodContext(frame)
+			core.FireTracePointException("rescue", vm.currentFrameBinding(), methodID, definedClass, exception)
+		}
 		activeRescue := &ActiveRescue{
 			BodyOffset:        handler.BodyOffset,
 			RescueOffset:      handler.RescueOffset,
@@ -23287,7 +23439,7 @@ func (vm *VM) tryExecuteIOEachLineCapturedBlock(receiver, block *object.EmeraldV
 				err = vm.execute(op, frame)
 			}
 			if err != nil {
-				result = core.NewRuntimeError(err.Error())
+				result = core.ExecutionErrorResult(err)
 				break
 			}
 			if (vm.pendingReturnTargetID > 0 && vm.handlePendingNonLocalReturn(frame)) ||
@@ -23510,7 +23662,7 @@ func (vm *VM) executeReusableBlockFrame(frame *Frame, bp int) (*object.EmeraldVa
 			err = vm.execute(op, frame)
 		}
 		if err != nil {
-			return core.NewRuntimeError(err.Error()), reusableBlockError
+			return core.ExecutionErrorResult(err), reusableBlockError
 		}
 		if (vm.pendingReturnTargetID > 0 && vm.handlePendingNonLocalReturn(frame)) ||
 			(vm.pendingBreakTargetID > 0 && vm.handlePendingNonLocalBreak(frame)) || frame.Returned {
@@ -27771,7 +27923,8 @@ func (vm *VM) callBlockWithSelfArgsMode(block, self *object.EmeraldValue, args [
 	if core.TracePointEventActive("b_call") {
 		binding := vm.currentFrameBinding()
 		binding.Line = fn.DefinitionLine
-		core.FireTracePointCall("b_call", binding, self, nil, "", "", parameters)
+		methodID, definedClass := vm.traceMethodContext(blockFrame)
+		core.FireTracePointCall("b_call", binding, self, definedClass, methodID, methodID, parameters)
 	}
 
 	frame := vm.frames[vm.fp]
@@ -27798,6 +27951,7 @@ func (vm *VM) callBlockWithSelfArgsMode(block, self *object.EmeraldValue, args [
 					irResult, irExecuted = vm.executeRegisterIR(plan.registerIR, fn, self, args, methodName, nil, nil)
 				} else {
 					cacheSends := registerIRSendCacheEnabled && registerIRBlockSendCacheEnabled && !closureUsesRefinements(closure)
+					frame.registerIRResumable = true
 					irResult, irExecuted = vm.executeRegisterIRInstructions(plan.registerIR, self, args, frame, cacheSends)
 				}
 			}
@@ -27809,8 +27963,9 @@ func (vm *VM) callBlockWithSelfArgsMode(block, self *object.EmeraldValue, args [
 	// precise when they handle an operation; if a later guard rejects the plan,
 	// leaving that IP in place would resume bytecode in the middle of an
 	// instruction (and can turn a harmless fallback into an invalid constant
-	// index or a skipped side effect).
-	if !irExecuted {
+	// index or a skipped side effect). A plan that stopped at a resume point
+	// for tracing has set the IP the interpreter continues from instead.
+	if !irExecuted && !frame.registerIRResumed {
 		frame.Ip = -1
 	}
 	instructions := frame.Fn.Instructions
@@ -27835,7 +27990,7 @@ func (vm *VM) callBlockWithSelfArgsMode(block, self *object.EmeraldValue, args [
 			vm.currentBlock = prevBlock
 			vm.classStack = prevClassStack
 			vm.catchStack = prevCatchStack
-			return core.NewRuntimeError(err.Error())
+			return core.ExecutionErrorResult(err)
 		}
 		if (vm.pendingReturnTargetID > 0 && vm.handlePendingNonLocalReturn(frame)) ||
 			(vm.pendingBreakTargetID > 0 && vm.handlePendingNonLocalBreak(frame)) || frame.Returned {
@@ -27897,7 +28052,8 @@ func (vm *VM) callBlockWithSelfArgsMode(block, self *object.EmeraldValue, args [
 	}
 	if core.TracePointEventActive("b_return") {
 		binding := vm.currentFrameBinding()
-		core.FireTracePointReturn("b_return", binding, self, nil, "", "", parameters, result)
+		methodID, definedClass := vm.traceMethodContext(frame)
+		core.FireTracePointReturn("b_return", binding, self, definedClass, methodID, methodID, parameters, result)
 	}
 
 	vm.endActiveRescuesForFrame(frame)
@@ -28767,29 +28923,7 @@ func (vm *VM) evalReturnTargetID() int {
 
 func (vm *VM) captureFrameBinding() *object.RBinding {
 	if vm.fp >= 0 && vm.fp < len(vm.frames) {
-		if frame := vm.frames[vm.fp]; frame != nil {
-			site := frame.Ip
-			if frame.ClosureBinding != nil && frame.ClosureBindingSite == site {
-				return frame.ClosureBinding
-			}
-			if binding := frame.ClosureBindings[site]; binding != nil {
-				return binding
-			}
-			binding := vm.compactFrameBinding(frame)
-			if binding != nil {
-				if frame.ClosureBinding == nil {
-					frame.ClosureBindingSite = site
-					frame.ClosureBinding = binding
-				} else {
-					if frame.ClosureBindings == nil {
-						frame.ClosureBindings = map[int]*object.RBinding{
-							frame.ClosureBindingSite: frame.ClosureBinding,
-						}
-					}
-					frame.ClosureBindings[site] = binding
-				}
-				frame.CapturedBindings = append(frame.CapturedBindings, binding)
-			}
+		if binding := vm.captureBindingForFrame(vm.frames[vm.fp]); binding != nil {
 			return binding
 		}
 	}
@@ -28797,6 +28931,37 @@ func (vm *VM) captureFrameBinding() *object.RBinding {
 	return binding
 }
 
+// captureBindingForFrame returns the binding Kernel#binding returns in
+// frame, whose locals write through to the frame.
+func (vm *VM) captureBindingForFrame(frame *Frame) *object.RBinding {
+	if frame == nil {
+		return nil
+	}
+	site := frame.Ip
+	if frame.ClosureBinding != nil && frame.ClosureBindingSite == site {
+		return frame.ClosureBinding
+	}
+	if binding := frame.ClosureBindings[site]; binding != nil {
+		return binding
+	}
+	binding := vm.compactFrameBinding(frame)
+	if binding != nil {
+		if frame.ClosureBinding == nil {
+			frame.ClosureBindingSite = site
+			frame.ClosureBinding = binding
+		} else {
+			if frame.ClosureBindings == nil {
+				frame.ClosureBindings = map[int]*object.RBinding{
+					frame.ClosureBindingSite: frame.ClosureBinding,
+				}
+			}
+			frame.ClosureBindings[site] = binding
+		}
+		frame.CapturedBindings = append(frame.CapturedBindings, binding)
+	}
+	return binding
+}
+
 func (vm *VM) globalVariableNames() []string {
 	if vm.globalNames == nil {
 		return []string{}
@@ -29061,8 +29226,15 @@ func (vm *VM) sourceLineForFrame(frame *Frame) int64 {
 	return int64(frame.Ip + 1)
 }
 
+// fireTracePointLine runs before every instruction while AnyTracePointActive
+// holds, so it also takes the samples a running Ruby profile is due and
+// reports new lines to an attached debugger.
 func (vm *VM) fireTracePointLine(frame *Frame, op compiler.Opcode) bool {
-	if frame == nil || !core.TracePointEventActive("line") {
+	if profile := core.RubyProfiler(); profile != nil && profile.Due() {
+		profile.Record(vm.rubyProfileStack())
+	}
+	debug := core.ActiveDebugger()
+	if frame == nil || debug == nil && !core.TracePointEventActive("line") {
 		return false
 	}
 	line := frame.ExecutionLine
@@ -29075,7 +29247,13 @@ func (vm *VM) fireTracePointLine(frame *Frame, op compiler.Opcode) bool {
 		line = vm.sourceLineForFrame(frame)
 	}
 	frame.ExecutionLine = line
-	if frame.Fn != nil && line == frame.Fn.DefinitionLine && (op == compiler.OpReturn || op == compiler.OpReturnValue || op == compiler.OpNonLocalReturnValue || op == compiler.OpBlockReturn) {
+	switch op {
+	case compiler.OpJump, compiler.OpPop, compiler.OpEndRescue, compiler.OpReturnValue, compiler.OpBlockReturn:
+		// These close a statement, a loop body or a scope rather than begin
+		// one; the line they carry is where :return reports leaving.
+		return true
+	}
+	if frame.Fn != nil && line == frame.Fn.DefinitionLine && (op == compiler.OpReturn || op == compiler.OpNonLocalReturnValue) {
 		return true
 	}
 	if line <= 0 || line == frame.TraceLine {
@@ -29090,11 +29268,47 @@ func (vm *VM) fireTracePointLine(frame *Frame, op compiler.Opcode) bool {
 		}
 	}
 	frame.TraceLine = line
+	if debug != nil {
+		debug.Line(vm.debugLocation(frame, line), vm.debugProgram)
+		if !core.TracePointEventActive("line") {
+			return true
+		}
+	}
 	binding := vm.currentFrameBinding()
-	core.FireTracePointLine(binding, frame.ID)
+	methodID, definedClass := vm.traceMethodContext(frame)
+	core.FireTracePointLine(binding, frame.ID, methodID, definedClass)
 	return true
 }
 
+// traceMethodContext names the method an event in frame happens in, as
+// TracePoint#method_id and #defined_class report it. A block answers for
+// the method whose body defines it while that method is still running.
+func (vm *VM) traceMethodContext(frame *Frame) (string, *object.EmeraldValue) {
+	home := frame
+	if frame.Fn != nil && frame.Fn.Name == "__block__" && frame.Closure != nil {
+		home = nil
+		for i := vm.fp; i >= 0 && frame.Closure.ReturnOwnerID > 0; i-- {
+			if candidate := vm.frames[i]; candidate != nil && candidate.ID == frame.Closure.ReturnOwnerID {
+				home = candidate
+				break
+			}
+		}
+		if home == nil {
+			if frame.MethodName == "__main__" {
+				return "", nil
+			}
+			return frame.MethodName, nil
+		}
+	}
+	if home.TraceMethodID != "" {
+		return home.TraceMethodID, home.TraceDefinedClass
+	}
+	if home.BacktraceMethod == nil {
+		return "", nil
+	}
+	return traceMethodID(home.BacktraceMethod, home.MethodName), traceDefinedClassForInvocation(home.BacktraceMethod, home.BacktraceOwner)
+}
+
 func (vm *VM) fireTracePointReturn(frame *Frame, value *object.EmeraldValue) {
 	if frame == nil || !core.TracePointEventActive("return") {
 		return
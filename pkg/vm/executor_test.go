@@ -13,6 +13,7 @@ import (
 
 	"github.com/GoLangDream/rgo/pkg/compiler"
 	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/irb"
 	"github.com/GoLangDream/rgo/pkg/lexer"
 	"github.com/GoLangDream/rgo/pkg/object"
 	"github.com/GoLangDream/rgo/pkg/parser"
@@ -781,6 +782,102 @@ func TestRequiredMethodRetainsFrozenStringLiteralMode(t *testing.T) {
 	assertBoolResult(t, values[1], true)
 }
 
+func TestRequireReusesCachedBytecode(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv("RGO_BYTECODE_CACHE_DIR", cacheDir)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cached_feature.rb")
+	if err := os.WriteFile(path, []byte("def cached_feature = [:first, __FILE__]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ := runRuby(t, fmt.Sprintf("load %q\ncached_feature", path))
+	if got := result.Inspect(); got != fmt.Sprintf("[:first, %q]", path) {
+		t.Fatalf("first load = %s", got)
+	}
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*"+compiler.BytecodeCacheExtension))
+	if len(entries) != 1 {
+		t.Fatalf("cache entries = %v", entries)
+	}
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(entries[0], stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ = runRuby(t, fmt.Sprintf("require %q\ncached_feature", path))
+	if got := result.Inspect(); got != fmt.Sprintf("[:first, %q]", path) {
+		t.Fatalf("cached require = %s", got)
+	}
+	if info, err := os.Stat(entries[0]); err != nil || !info.ModTime().Equal(stamp) {
+		t.Fatalf("cache entry was rewritten on a hit")
+	}
+
+	if err := os.WriteFile(path, []byte("def cached_feature = :edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, _ = runRuby(t, fmt.Sprintf("load %q\ncached_feature", path))
+	assertSymbolResult(t, result, "edited")
+	if info, err := os.Stat(entries[0]); err != nil || info.ModTime().Equal(stamp) {
+		t.Fatalf("stale cache entry was not replaced")
+	}
+}
+
+func TestARGFInplaceModeRewritesFilesFromARGV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ := runRuby(t, fmt.Sprintf("ARGV.replace([%q])\nARGF.inplace_mode = \".bak\"\nwhile gets\n  print $_.upcase\nend\n[$., ARGV]", path))
+	if got := result.Inspect(); got != "[2, []]" {
+		t.Fatalf("result = %s", got)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "A\nB\n" {
+		t.Fatalf("edited file = %q", data)
+	}
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != "a\nb\n" {
+		t.Fatalf("backup file = %q", data)
+	}
+}
+
+func TestARGFReadsStandardInputWithoutWaitingForEOF(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+	oldStdin := os.Stdin
+	os.Stdin = reader
+	defer func() { os.Stdin = oldStdin }()
+	if _, err := writer.WriteString("a\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		result, _ := runRuby(t, "ARGV.clear\n[gets, gets, $.]")
+		done <- result.Inspect()
+	}()
+	select {
+	case got := <-done:
+		if got != `["a\n", "b\n", 2]` {
+			t.Fatalf("result = %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("gets waited for the end of standard input")
+	}
+}
+
+func TestENDInLoopRegistersOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ends.txt")
+	runRuby(t, fmt.Sprintf("i = 0\nwhile i < 3\n  i += 1\n  END { File.write(%q, \"loop\\n\", mode: \"a\") }\nend\n3.times { END { File.write(%q, \"block\\n\", mode: \"a\") } }", path, path))
+	core.RunAtExitHooks()
+	if data, _ := os.ReadFile(path); string(data) != "block\nloop\n" {
+		t.Fatalf("END output = %q", data)
+	}
+}
+
 func TestExtendingSameModuleAgainDoesNotChangeMethodPrecedence(t *testing.T) {
 	result, _ := runRuby(t, `
 module RepeatedExtendBase
@@ -4173,6 +4270,11 @@ func TestWhileLoopNeverExecutes(t *testing.T) {
 	assertIntResult(t, result, 10)
 }
 
+func TestWhileLoopAtProgramStartContinuesAfterNext(t *testing.T) {
+	result, _ := runRuby(t, "while ($n = ($n || 0) + 1) < 5\n  next if $n == 2\n  $seen = ($seen || 0) + $n\nend\n$seen")
+	assertIntResult(t, result, 8)
+}
+
 // === Until Loop ===
 
 func TestUntilLoop(t *testing.T) {
@@ -8226,6 +8328,33 @@ message.include?("unexpected end-of-input")`)
 	assertBoolResult(t, result, true)
 }
 
+func TestSyntaxErrorsReportLineAndSnippet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.rb")
+	if err := os.WriteFile(path, []byte("x = 1\nfoo(]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := runRuby(t, fmt.Sprintf(`
+messages = []
+begin
+  eval("a = 1\nb = (2 +", TOPLEVEL_BINDING, "snippet.rb", 10)
+rescue SyntaxError => error
+  messages << error.message
+end
+begin
+  require %q
+rescue SyntaxError => error
+  messages << error.message << error.path
+end
+messages`, path))
+	want := fmt.Sprintf("[%q, %q, %q]",
+		"snippet.rb:11: syntax error, unexpected end-of-input, expecting ')'\nb = (2 +\n        ^",
+		path+":2: syntax error, unexpected ']'\nfoo(]\n    ^",
+		path)
+	if got := result.Inspect(); got != want {
+		t.Fatalf("messages = %s\nwant %s", got, want)
+	}
+}
+
 func TestEvalIgnoresSpacedCallPatternInsideComments(t *testing.T) {
 	result, _ := runRuby(t, `eval("# configurations (including hierarchy, modules)\n1")`)
 	assertIntResult(t, result, 1)
@@ -11838,6 +11967,7 @@ key`)
 
 func TestForLoopSingleVariableOverHashOnEmptyCollectionWithoutPriorValueIsNil(t *testing.T) {
 	result, _ := runRuby(t, `for key in {}
+end
 key`)
 	assertNilResult(t, result)
 }
@@ -15096,6 +15226,28 @@ func TestBindingLocalVariableSetUpdatesCapturedFrameLocal(t *testing.T) {
 	assertIntResult(t, result, 20)
 }
 
+func TestIRBSessionKeepsLocalsAndReportsExceptions(t *testing.T) {
+	result, _ := runRuby(t, `number = 10; binding`)
+	binding, ok := result.Data.(*object.RBinding)
+	if !ok {
+		t.Fatalf("expected binding, got %s", result.Inspect())
+	}
+	var out bytes.Buffer
+	session := irb.NewSession(binding, strings.NewReader("def double(v)\n  v * 2\nend\nvalue = double(number)\nmissing_name\n_ + value\n"), &out)
+	session.HistoryPath = ""
+	if exit := session.Run(); exit != nil {
+		t.Fatalf("unexpected session exit: %s", exit.Inspect())
+	}
+	for _, want := range []string{"=> :double\n", "=> 20\n", "(NameError)\n", "=> 40\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected session output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+	if history := session.History(); len(history) != 4 || history[0] != "def double(v)\n  v * 2\nend" {
+		t.Fatalf("unexpected history: %q", history)
+	}
+}
+
 func TestBindingInsideBlockReadsLexicalParentLocal(t *testing.T) {
 	result, _ := runRuby(t, `number = 10; -> { binding.local_variable_get(:number) }.call`)
 	assertIntResult(t, result, 10)
@@ -37704,6 +37856,145 @@ end
 	}
 }
 
+func TestRipperLexReportsPositionsEventsAndStates(t *testing.T) {
+	result, _ := runRuby(t, `require "ripper"
+Ripper.lex("def m(a);nil end").map { |pos, event, tok, state| [pos, event, tok, state.to_s] }`)
+	want := `[[[1, 0], :on_kw, "def", "FNAME"], [[1, 3], :on_sp, " ", "FNAME"], [[1, 4], :on_ident, "m", "ENDFN"], ` +
+		`[[1, 5], :on_lparen, "(", "BEG|LABEL"], [[1, 6], :on_ident, "a", "ARG"], [[1, 7], :on_rparen, ")", "ENDFN"], ` +
+		`[[1, 8], :on_semicolon, ";", "BEG"], [[1, 9], :on_kw, "nil", "END"], [[1, 12], :on_sp, " ", "END"], [[1, 13], :on_kw, "end", "END"]]`
+	if got := result.Inspect(); got != want {
+		t.Fatalf("unexpected Ripper.lex result:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestRipperTokenizeRoundTripsSource(t *testing.T) {
+	result, _ := runRuby(t, `require "ripper"
+src = "x = <<~EOS\n  hi \#{name}\nEOS\nputs %w[a b], :\"s\", x # done\n"
+[Ripper.tokenize(src).join == src, Ripper.tokenize("a.b(1)")]`)
+	if got := result.Inspect(); got != `[true, ["a", ".", "b", "(", "1", ")"]]` {
+		t.Fatalf("unexpected Ripper.tokenize result: %s", got)
+	}
+}
+
+func TestRipperSexpBuildsParserEventTrees(t *testing.T) {
+	result, _ := runRuby(t, `require "ripper"
+[
+  Ripper.sexp("def hello; 42; end"),
+  Ripper.sexp("x = 1; foo(x, k: 2) { |y| y }"),
+  Ripper.sexp_raw("a"),
+  Ripper.sexp("foo(\\n"),
+]`)
+	want := `[[:program, [[:def, [:@ident, "hello", [1, 4]], [:params, nil, nil, nil, nil, nil, nil, nil], [:bodystmt, [[:@int, "42", [1, 11]]], nil, nil, nil]]]], ` +
+		`[:program, [[:assign, [:var_field, [:@ident, "x", [1, 0]]], [:@int, "1", [1, 4]]], [:method_add_block, [:method_add_arg, [:fcall, [:@ident, "foo", [1, 7]]], ` +
+		`[:arg_paren, [:args_add_block, [[:var_ref, [:@ident, "x", [1, 11]]], [:bare_assoc_hash, [[:assoc_new, [:@label, "k:", [1, 14]], [:@int, "2", [1, 17]]]]]], false]]], ` +
+		`[:brace_block, [:block_var, [:params, [[:@ident, "y", [1, 23]]], nil, nil, nil, nil, nil, nil], false], [[:var_ref, [:@ident, "y", [1, 26]]]]]]]], ` +
+		`[:program, [:stmts_add, [:stmts_new], [:vcall, [:@ident, "a", [1, 0]]]]], nil]`
+	if got := result.Inspect(); got != want {
+		t.Fatalf("unexpected Ripper.sexp result:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestRipperSubclassReceivesScannerAndParserEvents(t *testing.T) {
+	result, _ := runRuby(t, `require "ripper"
+class RGoRipperCounter < Ripper
+  attr_reader :idents, :calls, :failure
+
+  def initialize(*)
+    super
+    @idents = []
+    @calls = []
+  end
+
+  def on_ident(tok)
+    @idents << [tok, lineno, column]
+    tok
+  end
+
+  def on_command(name, args)
+    @calls << name
+  end
+
+  def on_parse_error(message)
+    @failure = message
+  end
+end
+ok = RGoRipperCounter.new("puts a\nputs b\n", "x.rb", 10)
+ok.parse
+bad = RGoRipperCounter.new("foo(\n")
+bad.parse
+[ok.idents, ok.calls, ok.error?, ok.filename, bad.error?, bad.failure]`)
+	want := `[[["puts", 10, 0], ["a", 10, 5], ["puts", 11, 0], ["b", 11, 5]], ["puts", "puts"], false, "x.rb", true, "syntax error, unexpected end-of-input, expecting ')'"]`
+	if got := result.Inspect(); got != want {
+		t.Fatalf("unexpected Ripper subclass events:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestAbstractSyntaxTreeParseReportsTypesChildrenAndPositions(t *testing.T) {
+	result, _ := runRuby(t, `node = RubyVM::AbstractSyntaxTree.parse("x = 1 + foo(2)\n")
+call = node.children[2].children[1]
+error = begin
+  RubyVM::AbstractSyntaxTree.parse("foo(")
+rescue SyntaxError => e
+  e.class
+end
+[node.type, node.children[0], call.type, call.children[1], call.children[2].type,
+ [call.first_lineno, call.first_column, call.last_lineno, call.last_column], call.inspect, error]`)
+	want := `[:SCOPE, [:x], :OPCALL, :+, :LIST, [1, 4, 1, 14], "#<RubyVM::AbstractSyntaxTree::Node:OPCALL@1:4-1:14>", SyntaxError]`
+	if got := result.Inspect(); got != want {
+		t.Fatalf("unexpected AST:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestAbstractSyntaxTreeOfFindsMethodAndBlockScopes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ast_target.rb")
+	if err := os.WriteFile(path, []byte("def rgo_ast_target(a)\n  a * 2\nend\n\nRGO_AST_BLOCK = proc { |x| x + 1 }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source := fmt.Sprintf(`require %q
+m = RubyVM::AbstractSyntaxTree.of(method(:rgo_ast_target), keep_script_lines: true)
+b = RubyVM::AbstractSyntaxTree.of(RGO_AST_BLOCK)
+[m.type, m.children[0], m.first_lineno, m.last_lineno, m.source,
+ b.children[0], [b.first_lineno, b.first_column, b.last_column],
+ RubyVM::AbstractSyntaxTree.parse_file(%q).children[2].children.map(&:type)]`, path, path)
+	result, _ := runRuby(t, source)
+	want := `[:SCOPE, [:a], 1, 3, "def rgo_ast_target(a)\n  a * 2\nend", [:x], [5, 21, 34], [:DEFN, :CDECL]]`
+	if got := result.Inspect(); got != want {
+		t.Fatalf("unexpected AST.of result:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestPrismParseReportsNodesCommentsAndErrors(t *testing.T) {
+	result, _ := runRuby(t, `require "prism"
+class RGoPrismCalls < Prism::Visitor
+  attr_reader :names
+
+  def initialize
+    @names = []
+  end
+
+  def visit_call_node(node)
+    @names << node.name
+    super
+  end
+end
+ok = Prism.parse("# frozen_string_literal: true\ndef add(a, b = 2)\n  a + b # sum\nend\nputs add(1)\n")
+definition = ok.value.statements.body[0]
+visitor = RGoPrismCalls.new
+ok.value.accept(visitor)
+bad = Prism.parse("foo(")
+[ok.success?, ok.value.statements.body.map(&:type), definition.name, definition.location.to_s,
+ definition.parameters.requireds.map(&:name), ok.comments.map { |c| [c.slice, c.trailing?] },
+ ok.magic_comments.map { |c| [c.key, c.value] }, visitor.names,
+ bad.failure?, bad.errors.map { |e| [e.message, e.location.start_line, e.location.start_column] }]`)
+	want := `[true, [:def_node, :call_node], :add, "(2,0)-(4,3)", [:a], [["# frozen_string_literal: true", false], ["# sum", true]], ` +
+		`[["frozen_string_literal", "true"]], [:+, :puts, :add], true, [["syntax error, unexpected end-of-input, expecting ')'", 1, 4]]]`
+	if got := result.Inspect(); got != want {
+		t.Fatalf("unexpected Prism.parse result:\n got %s\nwant %s", got, want)
+	}
+}
+
 func TestNumberedBlockParametersBindAndSetArity(t *testing.T) {
 	result, _ := runRuby(t, `
 first = -> { _1 }
@@ -0,0 +1,45 @@
+package vm
+
+import "testing"
+
+func TestMemoryProfilerReportsAllocationsBySite(t *testing.T) {
+	_, out := runRuby(t, `
+require "memory_profiler"
+class Row
+  def initialize(n) = @n = n
+end
+$kept = []
+report = MemoryProfiler.report do
+  20.times do |i|
+    Row.new(i)
+    [i, i]
+    $kept << Row.new(i) if i < 5
+  end
+end
+p report.total_allocated >= 45
+p report.total_retained
+classes = report.allocated_objects_by_class.to_h { |entry| [entry[:data], entry[:count]] }
+p classes["Row"], classes["Array"]
+p report.retained_objects_by_class
+p report.allocated_objects_by_location.map { |entry| [entry[:data].split(":").last, entry[:count]] }.select { |_, count| count == 20 }.sort
+`)
+	want := "true\n5\n25\n20\n[{data: \"Row\", count: 5}]\n[[\"10\", 20], [\"9\", 20]]\n"
+	if out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}
+
+func TestMemoryProfilerFiltersByClass(t *testing.T) {
+	_, out := runRuby(t, `
+require "memory_profiler"
+report = MemoryProfiler.report(trace: [Hash]) do
+  3.times { |i| [i]; {i => i} }
+end
+p report.allocated_objects_by_class
+p report.total_retained
+`)
+	want := "[{data: \"Hash\", count: 3}]\n0\n"
+	if out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}
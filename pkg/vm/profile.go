@@ -0,0 +1,52 @@
+package vm
+
+import (
+	"github.com/GoLangDream/rgo/pkg/core"
+	"github.com/GoLangDream/rgo/pkg/profiler"
+)
+
+// rubyProfileStack returns the Ruby stack for a profile sample, innermost
+// frame first, continuing into the VMs that required this one's file.
+func (vm *VM) rubyProfileStack() []profiler.Frame {
+	stack := make([]profiler.Frame, 0, vm.fp+1)
+	for current := vm; current != nil; current = current.parent {
+		for i := current.fp; i >= 0; i-- {
+			for j := len(current.nativeBacktraceFrames) - 1; j >= 0; j-- {
+				if native := current.nativeBacktraceFrames[j]; native.parentIndex == i {
+					stack = append(stack, profiler.Frame{Name: native.location.Label, File: native.location.Path, Line: int(native.location.Line)})
+				}
+			}
+			frame := current.frames[i]
+			if frame == nil || frame.Fn == nil {
+				continue
+			}
+			stack = append(stack, profiler.Frame{
+				Name:      current.rubyFrameLabel(i),
+				File:      rubyFramePath(frame),
+				Line:      int(current.sourceLineForFrame(frame)),
+				StartLine: int(frame.Fn.DefinitionLine),
+			})
+		}
+	}
+	return stack
+}
+
+// rubyFrameLabel returns the label of frame index as a Ruby backtrace shows
+// it, naming the top level of a required file as Ruby does.
+func (vm *VM) rubyFrameLabel(index int) string {
+	if frame := vm.frames[index]; frame != nil && frame.Fn != nil && frame.Fn.Name == "__main__" {
+		if vm.parent != nil {
+			return "<top (required)>"
+		}
+		return "<main>"
+	}
+	return vm.backtraceLabelForFrame(index)
+}
+
+// rubyFramePath returns the source file frame runs.
+func rubyFramePath(frame *Frame) string {
+	if frame.Fn.SourcePath != "" {
+		return frame.Fn.SourcePath
+	}
+	return core.CurrentSpecFile
+}
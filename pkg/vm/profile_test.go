@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStackProfAttributesSamplesToRubyMethods(t *testing.T) {
+	_, out := runRuby(t, `
+require "stackprof"
+def spin
+  deadline = Process.clock_gettime(Process::CLOCK_MONOTONIC) + 0.05
+  x = 0
+  x += 1 while Process.clock_gettime(Process::CLOCK_MONOTONIC) < deadline
+  x
+end
+result = StackProf.run(mode: :wall, interval: 200, raw: true) { spin }
+spin_frame = result[:frames].values.find { |frame| frame[:name] == "Object#spin" }
+p result[:mode]
+p result[:samples] > 0
+p spin_frame[:line]
+p spin_frame[:samples] == spin_frame[:total_samples]
+p spin_frame[:lines].keys.all? { |line| (4..6).cover?(line) }
+p result[:raw].size > 0
+p StackProf.running?
+`)
+	want := ":wall\ntrue\n3\ntrue\ntrue\ntrue\nfalse\n"
+	if out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}
+
+func TestStackProfRejectsUnknownModes(t *testing.T) {
+	err := runRubyExpectError(t, `require "stackprof"; StackProf.start(mode: :object)`)
+	if err == nil || !strings.Contains(err.Error(), "unknown profiler mode") {
+		t.Fatalf("error = %v", err)
+	}
+}
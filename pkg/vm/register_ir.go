@@ -485,6 +485,10 @@ type registerIRPlan struct {
 	noFrameInlineSafe               bool
 	mayDeoptChecked                 bool
 	mayDeopt                        bool
+	// resumePoints maps each instruction that starts with an empty operand
+	// stack to the bytecode it was lowered from, so a framed plan can hand
+	// its Frame to the interpreter there once tracing is switched on.
+	resumePoints                    []registerIRResumePoint
 	framedBlockChecked              bool
 	framedBlockSafe                 bool
 	caseDispatchFramedChecked       bool
@@ -560,6 +564,14 @@ const (
 	registerIRDirectFastIntegerStringConcat
 )
 
+// registerIRResumePoint is where the bytecode interpreter picks up a framed
+// plan: the instruction to run next and the end of the innermost while loop,
+// which OpSetWhileEnd would have stored in the Frame.
+type registerIRResumePoint struct {
+	byteIP   int32
+	whileEnd int32
+}
+
 type registerIRIntegerValue struct {
 	value int64
 	kind  uint8 // 0 = integer, 1 = false, 2 = true, 3 = nil
@@ -1950,6 +1962,14 @@ func defaultRegisterIRCompileOptions() registerIRCompileOptions {
 	}
 }
 
+// RegisterIRCompiles reports whether the Register IR tier accepts fn. The
+// VM may still run an accepted method on bytecode when its call site does not
+// qualify; `rgo disasm` prints this next to each method.
+func RegisterIRCompiles(fn *object.Function) bool {
+	_, ok := compileRegisterIR(fn)
+	return ok
+}
+
 func compileRegisterIR(fn *object.Function) (*registerIRPlan, bool) {
 	return compileRegisterIRWithOptions(fn, defaultRegisterIRCompileOptions())
 }
@@ -1978,6 +1998,7 @@ func compileRegisterIRWithOptions(fn *object.Function, options registerIRCompile
 	byteToIR := make(map[int]int)
 	incomingDepth := make(map[int]int)
 	byteDepth := make(map[int]int)
+	resumeAt := make(map[int]registerIRResumePoint)
 	whileEndTargets := make([]int, 0, 2)
 	implicitFallthroughReturn := false
 	// A parameter load can use the argument array only while the parameter is
@@ -2072,6 +2093,15 @@ func compileRegisterIRWithOptions(fn *object.Function, options registerIRCompile
 			}
 		}
 		byteDepth[position] = stackDepth
+		if stackDepth == 0 {
+			if _, ok := resumeAt[len(plan.instructions)]; !ok {
+				whileEnd := -1
+				if len(whileEndTargets) > 0 {
+					whileEnd = whileEndTargets[len(whileEndTargets)-1]
+				}
+				resumeAt[len(plan.instructions)] = registerIRResumePoint{byteIP: int32(position), whileEnd: int32(whileEnd)}
+			}
+		}
 		op := compiler.Opcode(instructions[position])
 		switch op {
 		case compiler.OpGetLocal, compiler.OpGetLocalFast:
@@ -2418,7 +2448,7 @@ func compileRegisterIRWithOptions(fn *object.Function, options registerIRCompile
 			if stackDepth == 16 && elements == 0 {
 				return nil, false
 			}
-			plan.instructions = append(plan.instructions, registerIRInstruction{op: registerIRArray, dst: uint8(dst), argc: uint8(elements)})
+			plan.instructions = append(plan.instructions, registerIRInstruction{op: registerIRArray, dst: uint8(dst), argc: uint8(elements), byteIP: position})
 			stackDepth = dst + 1
 			if stackDepth > maxStackDepth {
 				maxStackDepth = stackDepth
@@ -2446,7 +2476,7 @@ func compileRegisterIRWithOptions(fn *object.Function, options registerIRCompile
 				return nil, false
 			}
 			dst := stackDepth - pairs*2
-			plan.instructions = append(plan.instructions, registerIRInstruction{op: registerIRHash, dst: uint8(dst), argc: uint8(pairs)})
+			plan.instructions = append(plan.instructions, registerIRInstruction{op: registerIRHash, dst: uint8(dst), argc: uint8(pairs), byteIP: position})
 			stackDepth = dst + 1
 			if stackDepth > maxStackDepth {
 				maxStackDepth = stackDepth
@@ -2982,6 +3012,14 @@ func compileRegisterIRWithOptions(fn *object.Function, options registerIRCompile
 		}
 	}
 	plan.registers = uint8(maxStackDepth)
+	plan.resumePoints = make([]registerIRResumePoint, len(plan.instructions))
+	for index := range plan.resumePoints {
+		point, ok := resumeAt[index]
+		if !ok {
+			point.byteIP = -1
+		}
+		plan.resumePoints[index] = point
+	}
 	for _, instruction := range plan.instructions {
 		if instruction.op == registerIRLoadConstant || instruction.op == registerIRLoadScopedConstant {
 			plan.hasConstantLoads = true
@@ -3380,7 +3418,11 @@ func (vm *VM) executeRegisterIR(plan *registerIRPlan, fn *object.Function, recei
 		vm.classStack = methodObj.Closure.ClassStack
 	}
 
+	frame.registerIRResumable = true
 	result, executed := vm.executeRegisterIRInstructions(plan, receiver, args, frame, registerIRSendCacheEnabled && registerIRSendCacheContextSafe(methodObj))
+	if !executed && frame.registerIRResumed {
+		result, executed = vm.resumeFrameInBytecode(frame, bp), true
+	}
 	vm.currentBlock = previousBlock
 	vm.classStack = previousClassStack
 	vm.setStackPointer(bp)
@@ -3395,6 +3437,74 @@ func (vm *VM) executeRegisterIR(plan *registerIRPlan, fn *object.Function, recei
 	return result, executed
 }
 
+// registerIRResumeInBytecode reports whether a framed plan about to run
+// instruction pc should stop and let the bytecode interpreter finish its
+// Frame. Every tier checks AnyTracePointActive on entry, but a TracePoint
+// enabled by a callee has to take effect in the frames already running too.
+// Only statement boundaries, where the operand stack is empty, can be
+// resumed: locals already live in the Frame, so the interpreter needs
+// nothing but the IP and the enclosing loop.
+func (vm *VM) registerIRResumeInBytecode(plan *registerIRPlan, pc int, frame *Frame) bool {
+	if pc >= len(plan.resumePoints) || plan.resumePoints[pc].byteIP < 0 || !core.AnyTracePointActive() {
+		return false
+	}
+	point := plan.resumePoints[pc]
+	frame.Ip = int(point.byteIP) - 1
+	frame.WhileEnd = int(point.whileEnd)
+	frame.BlockBreakAddr = int(point.whileEnd)
+	vm.setStackPointer(frame.Bp + 1 + frame.Fn.NumLocals)
+	frame.registerIRResumed = true
+	return true
+}
+
+// resumeFrameInBytecode runs the rest of a method Frame that a framed plan
+// left at a resume point, as invokeMethod runs a Ruby method, and returns
+// the method's result.
+func (vm *VM) resumeFrameInBytecode(frame *Frame, bp int) *object.EmeraldValue {
+	instructions := frame.Fn.Instructions
+	for frame.Ip < len(instructions)-1 {
+		frame.Ip++
+		op := compiler.Opcode(instructions[frame.Ip])
+		traceLineActive := false
+		if core.AnyTracePointActive() {
+			traceLineActive = vm.fireTracePointLine(frame, op)
+		}
+		if traceLineActive || !instructionExceptionSnapshotNotNeeded[op] && core.LastException != nil {
+			frame.InstructionException = core.LastException
+			frame.InstructionSnapshotSet = true
+		}
+		if err := vm.execute(op, frame); err != nil {
+			if core.LastException != nil && core.LastException.Type == object.ValueException && core.SandboxLimitExceeded() == nil {
+				return core.LastException
+			}
+			return core.ExecutionErrorResult(err)
+		}
+		if (vm.pendingReturnTargetID > 0 && vm.handlePendingNonLocalReturn(frame)) ||
+			(vm.pendingBreakTargetID > 0 && vm.handlePendingNonLocalBreak(frame)) || frame.Returned {
+			break
+		}
+		frame = vm.frames[vm.fp]
+		if frame.BlockBreak || frame.BlockNextVal != nil || core.LastBlockResult != nil {
+			break
+		}
+		instructions = frame.Fn.Instructions
+	}
+	result := core.R.NilVal
+	if pending, ok := vm.pendingBreakResultForFrame(frame); ok {
+		result = pending
+	} else if core.LastBlockResult != nil {
+		result = core.LastBlockResult
+	} else if vm.sp > bp {
+		result = vm.stack[vm.sp-1]
+	}
+	for i := len(vm.rescueStack) - 1; i >= 0; i-- {
+		if vm.rescueStack[i].Frame == frame {
+			vm.rescueStack = append(vm.rescueStack[:i], vm.rescueStack[i+1:]...)
+		}
+	}
+	return result
+}
+
 func (vm *VM) executeRegisterIRNoFrame(plan *registerIRPlan, receiver *object.EmeraldValue, args []*object.EmeraldValue) (*object.EmeraldValue, bool) {
 	if plan == nil || plan.sendCount < 3 || plan.requiresFrame || vm == nil || !registerIRPlanSafeForActiveRescues(plan, vm) {
 		return nil, false
@@ -4711,6 +4821,9 @@ func (vm *VM) executeRegisterIRInstructionsWithFreeModeBatch(plan *registerIRPla
 	noFrame := frame == nil && plan != nil && plan.hasSends
 	if !plan.hasBranches {
 		for pc := 0; pc < len(plan.instructions); pc++ {
+			if frame != nil && frame.registerIRResumable && vm.registerIRResumeInBytecode(plan, pc, frame) {
+				return nil, false
+			}
 			instruction := plan.instructions[pc]
 			if trustedArrayIndex && instruction.op == registerIRArray {
 				if dst, result, ok := executeRegisterIRArrayLiteralIndexFold(plan, pc, &registers); ok {
@@ -5021,6 +5134,9 @@ func (vm *VM) executeRegisterIRInstructionsWithFreeModeBatch(plan *registerIRPla
 		return nil, false
 	}
 	for pc := 0; pc < len(plan.instructions); {
+		if frame != nil && frame.registerIRResumable && vm.registerIRResumeInBytecode(plan, pc, frame) {
+			return nil, false
+		}
 		instruction := plan.instructions[pc]
 		switch instruction.op {
 		case registerIRLoadParam:
@@ -6434,6 +6550,21 @@ func (vm *VM) executeRegisterIRStoreInstanceVar(frame *Frame, receiver *object.E
 	return core.SetDynamicInstanceVar(receiver, instruction.name, value), true
 }
 
+// trackRegisterIRAllocation tracks an object a register IR instruction
+// allocated. Register plans leave frame.Ip unset while they run, so the
+// allocation is attributed to the line of the bytecode the instruction was
+// lowered from.
+func (vm *VM) trackRegisterIRAllocation(value *object.EmeraldValue, frame *Frame, instruction registerIRInstruction) *object.EmeraldValue {
+	if frame == nil || !core.ObjectSpaceAllocationTracing() {
+		return vm.trackObjectSpaceAllocation(value, frame)
+	}
+	ip := frame.Ip
+	frame.Ip = instruction.byteIP
+	value = vm.trackObjectSpaceAllocation(value, frame)
+	frame.Ip = ip
+	return value
+}
+
 func (vm *VM) executeRegisterIRArray(frame *Frame, instruction registerIRInstruction, registers *[16]*object.EmeraldValue) bool {
 	if frame == nil && core.ObjectSpaceAllocationTracing() {
 		return false
@@ -6445,11 +6576,11 @@ func (vm *VM) executeRegisterIRArray(frame *Frame, instruction registerIRInstruc
 	}
 	elements := make([]*object.EmeraldValue, count)
 	copy(elements, registers[start:start+count])
-	registers[instruction.dst] = vm.trackObjectSpaceAllocation(&object.EmeraldValue{
+	registers[instruction.dst] = vm.trackRegisterIRAllocation(&object.EmeraldValue{
 		Type:  object.ValueArray,
 		Data:  elements,
 		Class: core.R.Classes["Array"],
-	}, frame)
+	}, frame, instruction)
 	return true
 }
 
@@ -6474,7 +6605,7 @@ func (vm *VM) executeRegisterIRSplatToArray(frame *Frame, instruction registerIR
 		vm.returnUnhandledException(frame, errVal)
 		return errVal, true
 	}
-	registers[instruction.dst] = vm.trackObjectSpaceAllocation(vm.arrayValue(elements...), frame)
+	registers[instruction.dst] = vm.trackRegisterIRAllocation(vm.arrayValue(elements...), frame, instruction)
 	return nil, true
 }
 
@@ -6501,11 +6632,11 @@ func (vm *VM) executeRegisterIRHash(frame *Frame, instruction registerIRInstruct
 		hash.Keys = append(hash.Keys, key)
 		hash.Pairs[key] = value
 	}
-	registers[instruction.dst] = vm.trackObjectSpaceAllocation(&object.EmeraldValue{
+	registers[instruction.dst] = vm.trackRegisterIRAllocation(&object.EmeraldValue{
 		Type:  object.ValueHash,
 		Data:  hash,
 		Class: core.R.Classes["Hash"],
-	}, frame)
+	}, frame, instruction)
 	return true
 }
 
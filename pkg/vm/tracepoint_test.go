@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+// tracedMethods defines methods that every tier compiles once warmed up, so
+// the events they report under a TracePoint come from the deoptimized path.
+const tracedMethods = `
+def sum_to(n)
+  s = 0
+  n.times { |i| s += i }
+  s
+end
+def loopy(n)
+  i = 0
+  while i < n
+    i += 1
+  end
+  i
+end
+def risky(v)
+  raise ArgumentError, "bad" if v > 1
+  v
+rescue ArgumentError
+  -1
+end
+2000.times { |k| sum_to(3); loopy(2); risky(k % 3) }
+`
+
+func TestTracePointEventsMatchInterpreterAfterWarmup(t *testing.T) {
+	_, out := runRuby(t, tracedMethods+`
+log = []
+tp = TracePoint.new(:line, :call, :return, :c_call, :b_call, :b_return, :raise) do |t|
+  rv = [:return, :b_return].include?(t.event) ? t.return_value : nil
+  log << [t.event, t.lineno, t.method_id, rv]
+end
+tp.enable { sum_to(2); loopy(1); risky(2) }
+log.each { |e| p e }
+`)
+	want := []string{
+		"[:b_call, 27, nil, nil]",
+		"[:line, 27, nil, nil]",
+		"[:call, 2, :sum_to, nil]",
+		"[:line, 3, :sum_to, nil]",
+		"[:line, 4, :sum_to, nil]",
+		"[:c_call, 4, :times, nil]",
+		"[:b_call, 4, :sum_to, nil]",
+		"[:line, 4, :sum_to, nil]",
+		"[:c_call, 4, :+, nil]",
+		"[:b_return, 4, :sum_to, 0]",
+		"[:b_call, 4, :sum_to, nil]",
+		"[:line, 4, :sum_to, nil]",
+		"[:c_call, 4, :+, nil]",
+		"[:b_return, 4, :sum_to, 1]",
+		"[:line, 5, :sum_to, nil]",
+		"[:return, 6, :sum_to, 1]",
+		"[:call, 7, :loopy, nil]",
+		"[:line, 8, :loopy, nil]",
+		"[:line, 9, :loopy, nil]",
+		"[:c_call, 9, :<, nil]",
+		"[:line, 10, :loopy, nil]",
+		"[:c_call, 10, :+, nil]",
+		"[:line, 9, :loopy, nil]",
+		"[:c_call, 9, :<, nil]",
+		"[:line, 12, :loopy, nil]",
+		"[:return, 13, :loopy, 1]",
+		"[:call, 14, :risky, nil]",
+		"[:line, 15, :risky, nil]",
+		"[:c_call, 15, :>, nil]",
+		"[:c_call, 15, :raise, nil]",
+		"[:c_call, 15, :initialize, nil]",
+		"[:raise, 15, :risky, nil]",
+		"[:line, 17, :risky, nil]",
+		"[:c_call, 17, :===, nil]",
+		"[:line, 18, :risky, nil]",
+		"[:return, 19, :risky, -1]",
+		"[:b_return, 27, nil, -1]",
+	}
+	if got := strings.Split(strings.TrimSpace(out), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTracePointEnabledMidLoopReportsRemainingLines(t *testing.T) {
+	_, out := runRuby(t, `
+$lines = []
+$tp = TracePoint.new(:line) { |t| $lines << t.lineno }
+def start_tracing
+  $tp.enable
+end
+def work(n)
+  s = 0
+  i = 0
+  while i < n
+    start_tracing if i == 3
+    s += i
+    i += 1
+  end
+  s
+end
+work(6)
+$tp.disable
+p $lines
+`)
+	if want := "[12, 13, 10, 11, 12, 13, 10, 11, 12, 13, 10, 15, 18]\n"; out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}
+
+func TestTracePointTargetLineAndScriptCompiled(t *testing.T) {
+	_, out := runRuby(t, `
+def m(a)
+  b = a + 1
+  c = b * 2
+  c
+end
+300.times { m(1) }
+lines = []
+tp = TracePoint.new(:line) { |t| lines << t.lineno }
+tp.enable(target: method(:m), target_line: 4) { m(1); m(2) }
+p lines
+sources = []
+compiled = TracePoint.new(:script_compiled) { |t| sources << t.eval_script }
+compiled.enable { eval("1 + 2") }
+p sources
+`)
+	if want := "[4, 4]\n[\"1 + 2\"]\n"; out != want {
+		t.Fatalf("output %q, want %q", out, want)
+	}
+}